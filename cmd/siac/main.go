@@ -13,12 +13,27 @@ import (
 
 var (
 	// Flags.
-	hostContractOutputType string // output type for host contracts
-	hostVerbose            bool   // display additional host info
-	initForce              bool   // destroy and reencrypt the wallet on init if it already exists
-	initPassword           bool   // supply a custom password when creating a wallet
-	renterListVerbose      bool   // Show additional info about uploaded files.
-	renterShowHistory      bool   // Show download history in addition to download queue.
+	hostContractOutputType string   // output type for host contracts
+	hostVerbose            bool     // display additional host info
+	initForce              bool     // destroy and reencrypt the wallet on init if it already exists
+	initPassword           bool     // supply a custom password when creating a wallet
+	renterExcludeGlobs     []string // Patterns of files that aren't uploaded or downloaded with a folder.
+	renterFileMetadata     []string // Application metadata of uploaded or searched files.
+	renterIncludeGlobs     []string // Patterns of the files that are uploaded or downloaded with a folder.
+	renterListVerbose      bool     // Show additional info about uploaded files.
+	renterRecursive        bool     // Download all files below a siapath into a folder.
+	renterSearchLimit      uint64   // Maximum number of files shown by a search.
+	renterSearchOffset     uint64   // Number of matching files skipped by a search.
+	renterShareASCII       bool     // Share and load .sia files in ASCII form.
+	renterShareStripIDs    bool     // Strip the contract IDs from shared .sia files.
+	renterShowHistory      bool     // Show download history in addition to download queue.
+	renterSyncDeletePolicy string   // Whether deletions are synced by a synced folder.
+	renterSyncDirection    string   // Which changes are synced by a synced folder.
+	renterUploadCompress   bool     // Compress files before they are uploaded.
+	renterUploadDedup      bool     // Upload files with convergent chunking.
+	renterUploadDryRun     bool     // Quote the cost of an upload instead of uploading.
+	renterUploadDuration   uint64   // Number of blocks that quoted uploads are stored for.
+	renterUploadPriority   uint64   // Upload priority of uploaded files.
 )
 
 var (
@@ -116,16 +131,46 @@ func main() {
 	root.AddCommand(renterCmd)
 	renterCmd.AddCommand(renterFilesDeleteCmd, renterFilesDownloadCmd,
 		renterDownloadsCmd, renterAllowanceCmd, renterSetAllowanceCmd,
+		renterSetOverdriveCmd, renterSetRedundancyCmd,
 		renterContractsCmd, renterFilesListCmd, renterFilesRenameCmd,
 		renterFilesUploadCmd, renterUploadsCmd, renterExportCmd,
-		renterPricesCmd)
+		renterPricesCmd, renterDirListCmd, renterFilesShareCmd,
+		renterFilesLoadCmd, renterBackupCmd, renterRecoverBackupCmd,
+		renterFileCmd, renterCacheCmd, renterWorkersCmd,
+		renterFilesMetadataCmd, renterFilesSearchCmd, renterSyncCmd,
+		renterTrashCmd)
 
 	renterContractsCmd.AddCommand(renterContractsViewCmd)
 	renterAllowanceCmd.AddCommand(renterAllowanceCancelCmd)
+	renterCacheCmd.AddCommand(renterCachePurgeCmd, renterCacheSetSizeCmd)
+	renterSyncCmd.AddCommand(renterSyncAddCmd, renterSyncListCmd, renterSyncRemoveCmd)
+	renterTrashCmd.AddCommand(renterTrashEmptyCmd, renterTrashListCmd,
+		renterTrashRestoreCmd, renterTrashSetRetentionCmd)
+	renterUploadsCmd.AddCommand(renterUploadsPauseCmd, renterUploadsResumeCmd)
 
 	renterCmd.Flags().BoolVarP(&renterListVerbose, "verbose", "v", false, "Show additional file info such as redundancy")
 	renterDownloadsCmd.Flags().BoolVarP(&renterShowHistory, "history", "H", false, "Show download history in addition to the download queue")
 	renterFilesListCmd.Flags().BoolVarP(&renterListVerbose, "verbose", "v", false, "Show additional file info such as redundancy")
+	renterDirListCmd.Flags().BoolVarP(&renterListVerbose, "verbose", "v", false, "Show additional directory info such as redundancy")
+	renterFilesShareCmd.Flags().BoolVarP(&renterShareASCII, "ascii", "a", false, "Print the .sia file in ASCII form instead of writing it to disk")
+	renterFilesShareCmd.Flags().BoolVarP(&renterShareStripIDs, "strip-contract-ids", "s", false, "Identify the hosts only by their public keys")
+	renterFilesLoadCmd.Flags().BoolVarP(&renterShareASCII, "ascii", "a", false, "Load a .sia file in ASCII form instead of from disk")
+	renterFilesDownloadCmd.Flags().BoolVarP(&renterRecursive, "recursive", "r", false, "Download all files below [path] into the folder [destination]")
+	renterFilesDownloadCmd.Flags().StringArrayVar(&renterIncludeGlobs, "include", nil, "Only download files that match the pattern with --recursive, can be repeated")
+	renterFilesDownloadCmd.Flags().StringArrayVar(&renterExcludeGlobs, "exclude", nil, "Don't download files that match the pattern with --recursive, can be repeated")
+	renterFilesUploadCmd.Flags().StringArrayVar(&renterIncludeGlobs, "include", nil, "Only upload files of a folder that match the pattern, can be repeated")
+	renterFilesUploadCmd.Flags().StringArrayVar(&renterExcludeGlobs, "exclude", nil, "Don't upload files of a folder that match the pattern, can be repeated")
+	renterFilesUploadCmd.Flags().Uint64VarP(&renterUploadPriority, "priority", "p", 0, "Upload priority of the file, higher priorities are uploaded first")
+	renterFilesUploadCmd.Flags().BoolVar(&renterUploadDedup, "dedup", false, "Deduplicate the chunks of the file against the chunks that are already uploaded")
+	renterFilesUploadCmd.Flags().BoolVar(&renterUploadCompress, "compress", false, "Compress the file before it is uploaded")
+	renterFilesUploadCmd.Flags().StringArrayVarP(&renterFileMetadata, "metadata", "m", nil, "Attach a key=value pair of application metadata to the file, can be repeated")
+	renterFilesUploadCmd.Flags().BoolVar(&renterUploadDryRun, "dry-run", false, "Show what the upload would cost instead of uploading")
+	renterFilesUploadCmd.Flags().Uint64Var(&renterUploadDuration, "duration", 0, "Number of blocks the data is stored for in a --dry-run, defaults to the allowance period")
+	renterFilesSearchCmd.Flags().StringArrayVarP(&renterFileMetadata, "metadata", "m", nil, "Only show files with the key=value pair of application metadata, can be repeated")
+	renterFilesSearchCmd.Flags().Uint64Var(&renterSearchLimit, "limit", 0, "Show at most this many files, 0 shows all files")
+	renterFilesSearchCmd.Flags().Uint64Var(&renterSearchOffset, "offset", 0, "Skip this many matching files")
+	renterSyncAddCmd.Flags().StringVar(&renterSyncDirection, "direction", "both", "Which changes are synced: upload, download or both")
+	renterSyncAddCmd.Flags().StringVar(&renterSyncDeletePolicy, "delete-policy", "propagate", "Whether deletions are synced: propagate or keep")
 	renterExportCmd.AddCommand(renterExportContractTxnsCmd)
 
 	root.AddCommand(gatewayCmd)
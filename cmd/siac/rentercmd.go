@@ -6,13 +6,16 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/node/api"
+	"github.com/NebulousLabs/Sia/types"
 )
 
 var (
@@ -30,6 +33,41 @@ var (
 		Run:   wrap(renterallowancecmd),
 	}
 
+	renterBackupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Back up the renter's metadata",
+		Long: `Back up the renter's files and contracts to its hosts. The backup is
+encrypted with the wallet seed and can be recovered with 'siac renter recoverbackup'
+on a node that was restored from the same seed. The renter also creates backups
+periodically on its own.`,
+		Run: wrap(renterbackupcmd),
+	}
+
+	renterCacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "View the chunk cache",
+		Long: `View the size and usage of the renter's chunk cache. Downloaded chunks are
+cached on disk, so that files that are downloaded or streamed repeatedly are
+not fetched from the hosts every time.`,
+		Run: wrap(rentercachecmd),
+	}
+
+	renterCachePurgeCmd = &cobra.Command{
+		Use:   "purge",
+		Short: "Purge the chunk cache",
+		Long:  "Remove all chunks from the renter's chunk cache.",
+		Run:   wrap(rentercachepurgecmd),
+	}
+
+	renterCacheSetSizeCmd = &cobra.Command{
+		Use:   "setsize [size]",
+		Short: "Set the size of the chunk cache",
+		Long: `Set the maximum size of the renter's chunk cache, e.g. 10GB. The least
+recently used chunks are removed from the cache when it is full. A size of 0
+disables the cache.`,
+		Run: wrap(rentercachesetsizecmd),
+	}
+
 	renterCmd = &cobra.Command{
 		Use:   "renter",
 		Short: "Perform renter actions",
@@ -51,6 +89,13 @@ var (
 		Run:   wrap(rentercontractsviewcmd),
 	}
 
+	renterDirListCmd = &cobra.Command{
+		Use:   "ls [path]",
+		Short: "List the contents of a directory",
+		Long:  "List the subdirectories and files of a directory on the Sia network. Lists the root directory if [path] is omitted.",
+		Run:   renterdirlistcmd,
+	}
+
 	renterDownloadsCmd = &cobra.Command{
 		Use:   "downloads",
 		Short: "View the download queue",
@@ -62,23 +107,54 @@ var (
 		Use:     "delete [path]",
 		Aliases: []string{"rm"},
 		Short:   "Delete a file",
-		Long:    "Delete a file. Does not delete the file on disk.",
-		Run:     wrap(renterfilesdeletecmd),
+		Long: `Delete a file. The file is moved to the trash, from which it can be restored
+until the trash retention has passed. Does not delete the file on disk.`,
+		Run: wrap(renterfilesdeletecmd),
 	}
 
 	renterFilesDownloadCmd = &cobra.Command{
 		Use:   "download [path] [destination]",
 		Short: "Download a file",
-		Long:  "Download a previously-uploaded file to a specified destination.",
-		Run:   wrap(renterfilesdownloadcmd),
+		Long: `Download a previously-uploaded file to a specified destination. With
+--recursive, all files below [path] are downloaded into the folder
+[destination], keeping their relative paths. Local files with the same size and
+modification time as the uploaded file are skipped. --include and --exclude
+select the files that are downloaded by their relative path or name, and can be
+repeated.`,
+		Run: wrap(renterfilesdownloadcmd),
+	}
+
+	renterFileCmd = &cobra.Command{
+		Use:   "file [path]",
+		Short: "Show the details of a file",
+		Long: `Show the details of a file on the Sia network, including the checksum of
+its data. The checksum is a BLAKE2b-256 hash, which can be compared to the
+output of 'b2sum -l 256'.`,
+		Run: wrap(renterfilecmd),
 	}
 
 	renterFilesListCmd = &cobra.Command{
-		Use:     "list",
-		Aliases: []string{"ls"},
-		Short:   "List the status of all files",
-		Long:    "List the status of all files known to the renter on the Sia network.",
-		Run:     wrap(renterfileslistcmd),
+		Use:   "list",
+		Short: "List the status of all files",
+		Long:  "List the status of all files known to the renter on the Sia network.",
+		Run:   wrap(renterfileslistcmd),
+	}
+
+	renterFilesLoadCmd = &cobra.Command{
+		Use:   "load [source]",
+		Short: "Load a .sia file",
+		Long: `Load the files in a .sia file into the renter. The files can be
+downloaded through the renter's own contracts with the hosts that store them.
+If --ascii is set, [source] is a .sia file in ASCII form.`,
+		Run: wrap(renterfilesloadcmd),
+	}
+
+	renterFilesMetadataCmd = &cobra.Command{
+		Use:   "metadata [path] [key=value]...",
+		Short: "Set the application metadata of a file",
+		Long: `Replace the application metadata of a file with the given key=value pairs.
+Without pairs, the metadata of the file is removed.`,
+		Run: renterfilesmetadatacmd,
 	}
 
 	renterFilesRenameCmd = &cobra.Command{
@@ -89,11 +165,47 @@ var (
 		Run:     wrap(renterfilesrenamecmd),
 	}
 
+	renterFilesSearchCmd = &cobra.Command{
+		Use:   "search [prefix]",
+		Short: "Search files",
+		Long: `List the files whose path starts with [prefix] and whose application
+metadata contains all --metadata pairs. Use --limit and --offset to page
+through the results.`,
+		Run: renterfilessearchcmd,
+	}
+
+	renterFilesShareCmd = &cobra.Command{
+		Use:   "share [destination] [path]...",
+		Short: "Share files with a .sia file",
+		Long: `Write the specified files to a .sia file at [destination], which can be
+loaded by other renters. If --ascii is set, the .sia file is printed in ASCII
+form instead and no destination is given. If --strip-contract-ids is set, the
+.sia file doesn't contain the IDs of the renter's contracts.`,
+		Run: renterfilessharecmd,
+	}
+
 	renterFilesUploadCmd = &cobra.Command{
 		Use:   "upload [source] [path]",
 		Short: "Upload a file",
-		Long:  "Upload a file to [path] on the Sia network.",
-		Run:   wrap(renterfilesuploadcmd),
+		Long: `Upload a file to [path] on the Sia network. Files with a higher --priority
+are uploaded and repaired before files with a lower priority. With --dedup,
+chunks of the file that are identical to chunks that were uploaded before are
+not uploaded again. With --compress, the file is compressed before it is
+uploaded and decompressed when it is downloaded; it can't be combined with
+--dedup. Application metadata can be attached to the file with
+--metadata key=value. With --dry-run, the cost of the upload is quoted with the
+current prices of your hosts and nothing is uploaded.
+
+If [source] is a folder, all of its files are uploaded below [path], keeping
+their relative paths. Files that were already uploaded with the same size and
+modification time are skipped. The new version of a file that changed is
+uploaded under a temporary path and replaces the old version, which is moved to
+the trash, once it is available. --include and --exclude select the files that
+are uploaded by their relative path or name, and can be repeated. siac shows
+the progress of the upload until all files are fully uploaded, the upload
+continues in the background if siac is stopped. Changed files that weren't
+available yet when siac was stopped are replaced when the upload is run again.`,
+		Run: wrap(renterfilesuploadcmd),
 	}
 
 	renterPricesCmd = &cobra.Command{
@@ -103,6 +215,17 @@ var (
 		Run:   wrap(renterpricescmd),
 	}
 
+	renterRecoverBackupCmd = &cobra.Command{
+		Use:   "recoverbackup",
+		Short: "Recover the renter's metadata from a backup",
+		Long: `Recover the files and contracts of the most recent backup that was created
+with the wallet seed. The renter scans the blockchain for the backup and
+downloads it from one of the hosts that store it, forming a small contract with
+them if needed, so the wallet must be unlocked and funded. The recovery keeps
+running in the background if siac is interrupted.`,
+		Run: wrap(renterrecoverbackupcmd),
+	}
+
 	renterSetAllowanceCmd = &cobra.Command{
 		Use:   "setallowance [amount] [period] [hosts] [renew window]",
 		Short: "Set the allowance",
@@ -128,12 +251,140 @@ have a reasonable number (>30) of hosts in your hostdb.`,
 		Run: rentersetallowancecmd,
 	}
 
+	renterSetOverdriveCmd = &cobra.Command{
+		Use:   "setoverdrive [pieces]",
+		Short: "Set the download overdrive",
+		Long: `Set the number of extra pieces that are requested from other hosts when
+the download of a chunk takes longer than expected. The first pieces to arrive
+are used, the money spent on the rest is shown as overdrive spending. 0
+disables overdrive.`,
+		Run: wrap(rentersetoverdrivecmd),
+	}
+
+	renterSetRedundancyCmd = &cobra.Command{
+		Use:   "setredundancy [path] [datapieces] [paritypieces]",
+		Short: "Change the redundancy of a file",
+		Long: `Re-encode an uploaded file with [datapieces] data pieces and [paritypieces]
+parity pieces. The data of the file is downloaded from the hosts, so no local
+copy is needed. If the number of data pieces doesn't change, the pieces the file
+already has are kept and only the missing parity pieces are uploaded. The file
+is re-encoded in the background, and the command waits until it has been
+re-encoded. If siad shuts down in the meantime, the change resumes when siad
+starts again.`,
+		Run: wrap(rentersetredundancycmd),
+	}
+
+	renterSyncCmd = &cobra.Command{
+		Use:   "sync",
+		Short: "View the synced folders",
+		Long: `List the local folders that the renter keeps in sync with siapath prefixes,
+along with the files that changed on both sides and can't be synced.`,
+		Run: wrap(rentersynccmd),
+	}
+
+	renterSyncAddCmd = &cobra.Command{
+		Use:   "add [localpath] [siapath]",
+		Short: "Sync a local folder with a siapath prefix",
+		Long: `Keep the folder at [localpath] in sync with the files below [siapath], until the
+folder is removed. The renter watches the folder for changes where it can and
+rescans the folder and [siapath] periodically.
+
+--direction selects which changes are synced: "upload" only syncs the changes
+to the local folder, "download" only the changes below [siapath], and "both"
+syncs either. --delete-policy "keep" stops deletions from being synced, by
+default deleted files are deleted on the other side as well. Files that are
+replaced or deleted below [siapath] are moved to the trash.
+
+A file that changed on both sides is a conflict. It is not synced until both
+sides are the same again or one of them is deleted.`,
+		Run: wrap(rentersyncaddcmd),
+	}
+
+	renterSyncListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the synced folders",
+		Long:  "List the synced folders, their state and their conflicts.",
+		Run:   wrap(rentersynccmd),
+	}
+
+	renterSyncRemoveCmd = &cobra.Command{
+		Use:   "remove [localpath]",
+		Short: "Stop syncing a local folder",
+		Long:  "Stop syncing the folder at [localpath]. The files on either side are kept.",
+		Run:   wrap(rentersyncremovecmd),
+	}
+
+	renterTrashCmd = &cobra.Command{
+		Use:   "trash",
+		Short: "View the trash",
+		Long: `List the deleted files in the renter's trash. Deleted files can be restored
+until the trash retention has passed, then they are purged.`,
+		Run: wrap(rentertrashcmd),
+	}
+
+	renterTrashEmptyCmd = &cobra.Command{
+		Use:   "empty",
+		Short: "Empty the trash",
+		Long:  "Purge all of the files in the renter's trash. They can't be restored afterwards.",
+		Run:   wrap(rentertrashemptycmd),
+	}
+
+	renterTrashListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the files in the trash",
+		Long:  "List the deleted files in the renter's trash and when they will be purged.",
+		Run:   wrap(rentertrashcmd),
+	}
+
+	renterTrashRestoreCmd = &cobra.Command{
+		Use:   "restore [path]",
+		Short: "Restore a deleted file",
+		Long: `Restore the deleted file that was at [path] from the trash. If several files
+were deleted from [path], the most recently deleted one is restored. There must
+not be another file at [path].`,
+		Run: wrap(rentertrashrestorecmd),
+	}
+
+	renterTrashSetRetentionCmd = &cobra.Command{
+		Use:   "setretention [duration]",
+		Short: "Set the trash retention",
+		Long: `Set how long deleted files are kept in the trash before they are purged, e.g.
+168h. A retention of 0 disables the trash, deleted files are purged right away.`,
+		Run: wrap(rentertrashsetretentioncmd),
+	}
+
 	renterUploadsCmd = &cobra.Command{
 		Use:   "uploads",
 		Short: "View the upload queue",
 		Long:  "View the list of files currently uploading.",
 		Run:   wrap(renteruploadscmd),
 	}
+
+	renterUploadsPauseCmd = &cobra.Command{
+		Use:   "pause [path]",
+		Short: "Pause uploads",
+		Long: `Pause the upload and repair of the file at [path]. If no path is given, all
+uploads are paused. Chunks that are already being uploaded are finished.`,
+		Run: renteruploadspausecmd,
+	}
+
+	renterUploadsResumeCmd = &cobra.Command{
+		Use:   "resume [path]",
+		Short: "Resume uploads",
+		Long: `Resume the upload and repair of the file at [path]. If no path is given,
+the uploads that were paused as a whole are resumed. Files that were paused
+individually remain paused.`,
+		Run: renteruploadsresumecmd,
+	}
+
+	renterWorkersCmd = &cobra.Command{
+		Use:   "workers",
+		Short: "View the download statistics of the hosts",
+		Long: `View the rolling download statistics of the renter's workers, one for every
+contract. Downloads prefer the hosts that are expected to be the fastest. The
+hosts with the slowest time to first byte are listed first.`,
+		Run: wrap(renterworkerscmd),
+	}
 )
 
 // abs returns the absolute representation of a path.
@@ -166,6 +417,7 @@ func rentercmd() {
 	    Storage:       %v
 	    Upload:        %v
 	    Download:      %v
+	      Overdrive:   %v
 	    Fees:          %v
 	  Unspent Funds:   %v
 	    Allocated:     %v
@@ -173,7 +425,8 @@ func rentercmd() {
 
 `, currencyUnits(rg.Settings.Allowance.Funds), currencyUnits(totalSpent),
 		currencyUnits(fm.StorageSpending), currencyUnits(fm.UploadSpending),
-		currencyUnits(fm.DownloadSpending), currencyUnits(fm.ContractFees),
+		currencyUnits(fm.DownloadSpending), currencyUnits(fm.OverdriveSpending),
+		currencyUnits(fm.ContractFees),
 		currencyUnits(fm.Unspent), currencyUnits(unspentAllocated),
 		currencyUnits(unspentUnallocated))
 
@@ -207,7 +460,55 @@ func renteruploadscmd() {
 	}
 	fmt.Println("Uploading", len(filteredFiles), "files:")
 	for _, file := range filteredFiles {
-		fmt.Printf("%13s  %s (uploading, %0.2f%%)\n", filesizeUnits(int64(file.Filesize)), file.SiaPath, file.UploadProgress)
+		status := "uploading"
+		if file.UploadPaused {
+			status = "paused"
+		}
+		fmt.Printf("%13s  %s (%s, %0.2f%%)\n", filesizeUnits(int64(file.Filesize)), file.SiaPath, status, file.UploadProgress)
+	}
+}
+
+// renteruploadspausecmd is the handler for the command `siac renter uploads
+// pause [path]`. Pauses the upload of a file, or of all files if no path is
+// given.
+func renteruploadspausecmd(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		cmd.UsageFunc()(cmd)
+		os.Exit(exitCodeUsage)
+	}
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if err := httpClient.RenterUploadsPausePost(path); err != nil {
+		die("Could not pause uploads:", err)
+	}
+	if path == "" {
+		fmt.Println("Paused all uploads.")
+	} else {
+		fmt.Printf("Paused the upload of %s.\n", path)
+	}
+}
+
+// renteruploadsresumecmd is the handler for the command `siac renter uploads
+// resume [path]`. Resumes the upload of a file, or of all files if no path is
+// given.
+func renteruploadsresumecmd(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		cmd.UsageFunc()(cmd)
+		os.Exit(exitCodeUsage)
+	}
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if err := httpClient.RenterUploadsResumePost(path); err != nil {
+		die("Could not resume uploads:", err)
+	}
+	if path == "" {
+		fmt.Println("Resumed all uploads.")
+	} else {
+		fmt.Printf("Resumed the upload of %s.\n", path)
 	}
 }
 
@@ -279,6 +580,215 @@ func renterallowancecancelcmd() {
 	fmt.Println("Allowance canceled.")
 }
 
+// renterbackupcmd backs up the renter's metadata to its hosts.
+func renterbackupcmd() {
+	err := httpClient.RenterBackupPost()
+	if err != nil {
+		die("Could not create backup:", err)
+	}
+	fmt.Println("Backup created.")
+}
+
+// rentercachecmd displays the size and usage of the chunk cache.
+func rentercachecmd() {
+	rc, err := httpClient.RenterCacheGet()
+	if err != nil {
+		die("Could not get chunk cache:", err)
+	}
+	if rc.MaxSize == 0 {
+		fmt.Println("The chunk cache is disabled.")
+		return
+	}
+	fmt.Printf(`Chunk Cache:
+  Size:   %v of %v
+  Chunks: %v
+  Hits:   %v
+  Misses: %v
+`, filesizeUnits(int64(rc.Size)), filesizeUnits(int64(rc.MaxSize)), rc.Chunks, rc.Hits, rc.Misses)
+}
+
+// rentersynccmd lists the renter's synced folders.
+func rentersynccmd() {
+	rs, err := httpClient.RenterSyncGet()
+	if err != nil {
+		die("Could not get synced folders:", err)
+	}
+	if len(rs.Folders) == 0 {
+		fmt.Println("No folders are synced.")
+		return
+	}
+	fmt.Println("Synced folders:")
+	for _, f := range rs.Folders {
+		arrow := "<->"
+		if f.Direction == modules.SyncDirectionUpload {
+			arrow = "-->"
+		} else if f.Direction == modules.SyncDirectionDownload {
+			arrow = "<--"
+		}
+		lastSync := "never"
+		if !f.LastSync.IsZero() {
+			lastSync = f.LastSync.Format(time.RFC822)
+		}
+		changes := "by periodic rescans"
+		if f.Watching {
+			changes = "as they happen"
+		}
+		fmt.Printf("  %v %v %v\n", f.LocalPath, arrow, f.SiaPath)
+		fmt.Printf("    Direction: %v, deletions: %v\n", f.Direction, f.DeletePolicy)
+		fmt.Printf("    Files: %v, last synced: %v, local changes found %v\n", f.Files, lastSync, changes)
+		if f.Error != "" {
+			fmt.Println("    Error:", f.Error)
+		}
+		if len(f.Conflicts) > 0 {
+			fmt.Println("    Conflicts:")
+			for _, c := range f.Conflicts {
+				fmt.Printf("      %v: %v\n", c.Path, c.Reason)
+			}
+		}
+	}
+}
+
+// rentersyncaddcmd starts syncing a local folder with a siapath prefix.
+func rentersyncaddcmd(localPath, siaPath string) {
+	err := httpClient.RenterSyncAddPost(modules.SyncFolderParams{
+		LocalPath:    abs(localPath),
+		SiaPath:      siaPath,
+		Direction:    renterSyncDirection,
+		DeletePolicy: renterSyncDeletePolicy,
+	})
+	if err != nil {
+		die("Could not sync folder:", err)
+	}
+	fmt.Printf("Syncing '%s' with '%s'.\n", abs(localPath), strings.Trim(siaPath, "/"))
+}
+
+// rentersyncremovecmd stops syncing a local folder.
+func rentersyncremovecmd(localPath string) {
+	err := httpClient.RenterSyncRemovePost(abs(localPath))
+	if err != nil {
+		die("Could not stop syncing folder:", err)
+	}
+	fmt.Printf("Stopped syncing '%s'.\n", abs(localPath))
+}
+
+// rentertrashcmd lists the files in the renter's trash.
+func rentertrashcmd() {
+	rt, err := httpClient.RenterTrashGet()
+	if err != nil {
+		die("Could not get trash:", err)
+	}
+	if len(rt.Files) == 0 {
+		fmt.Println("The trash is empty.")
+		return
+	}
+	fmt.Println("Deleted files:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  Path\tSize\tDeleted\tPurged")
+	for _, file := range rt.Files {
+		fmt.Fprintf(w, "  %v\t%v\t%v\t%v\n", file.SiaPath, filesizeUnits(int64(file.Filesize)),
+			file.DeleteTime.Format(time.RFC822), file.PurgeTime.Format(time.RFC822))
+	}
+	w.Flush()
+}
+
+// rentertrashemptycmd purges all of the files in the renter's trash.
+func rentertrashemptycmd() {
+	err := httpClient.RenterTrashEmptyPost()
+	if err != nil {
+		die("Could not empty trash:", err)
+	}
+	fmt.Println("Trash emptied.")
+}
+
+// rentertrashrestorecmd restores a deleted file from the renter's trash.
+func rentertrashrestorecmd(path string) {
+	err := httpClient.RenterTrashRestorePost(path)
+	if err != nil {
+		die("Could not restore file:", err)
+	}
+	fmt.Println("Restored", path)
+}
+
+// rentertrashsetretentioncmd sets how long deleted files are kept in the
+// renter's trash.
+func rentertrashsetretentioncmd(duration string) {
+	retention, err := time.ParseDuration(duration)
+	if err != nil {
+		die("Could not parse duration:", err)
+	}
+	err = httpClient.RenterPostTrashRetention(retention)
+	if err != nil {
+		die("Could not set trash retention:", err)
+	}
+	if retention == 0 {
+		fmt.Println("Trash disabled.")
+	} else {
+		fmt.Printf("Set trash retention to %v.\n", retention)
+	}
+}
+
+// rentercachepurgecmd removes all chunks from the chunk cache.
+func rentercachepurgecmd() {
+	err := httpClient.RenterCachePurgePost()
+	if err != nil {
+		die("Could not purge chunk cache:", err)
+	}
+	fmt.Println("Chunk cache purged.")
+}
+
+// rentercachesetsizecmd sets the maximum size of the chunk cache.
+func rentercachesetsizecmd(size string) {
+	var maxSize uint64
+	if size != "0" {
+		bytes, err := parseFilesize(size)
+		if err != nil {
+			die("Could not parse size:", err)
+		}
+		if _, err := fmt.Sscan(bytes, &maxSize); err != nil {
+			die("Could not parse size:", err)
+		}
+	}
+	err := httpClient.RenterCachePost(maxSize)
+	if err != nil {
+		die("Could not set size of chunk cache:", err)
+	}
+	if maxSize == 0 {
+		fmt.Println("Chunk cache disabled.")
+	} else {
+		fmt.Printf("Set size of chunk cache to %v.\n", filesizeUnits(int64(maxSize)))
+	}
+}
+
+// renterrecoverbackupcmd recovers the renter's metadata from the most recent
+// backup.
+func renterrecoverbackupcmd() {
+	err := httpClient.RenterRecoverBackupPost()
+	if err != nil {
+		die("Could not recover backup:", err)
+	}
+	for range time.Tick(time.Second) {
+		rrbg, err := httpClient.RenterRecoverBackupGet()
+		if err != nil {
+			die("\nCould not get the status of the recovery:", err)
+		}
+		switch rrbg.Stage {
+		case modules.RecoveryStageScanning:
+			fmt.Printf("\rScanning the blockchain... block %v of %v    ", rrbg.ScannedHeight, rrbg.Height)
+		case modules.RecoveryStageDownloading:
+			fmt.Printf("\rDownloading the backup from block %v...                ", rrbg.BackupHeight)
+		case modules.RecoveryStageRestoring:
+			fmt.Printf("\rRestoring... %v files restored                        ", rrbg.FilesRestored)
+		}
+		if !rrbg.Active {
+			if rrbg.Error != "" {
+				die("\nCould not recover backup:", rrbg.Error)
+			}
+			fmt.Printf("\nBackup recovered, %v files restored.\n", rrbg.FilesRestored)
+			return
+		}
+	}
+}
+
 // rentersetallowancecmd allows the user to set the allowance.
 // the first two parameters, amount and period, are required.
 // the second two parameters are optional:
@@ -331,6 +841,54 @@ func rentersetallowancecmd(cmd *cobra.Command, args []string) {
 	fmt.Println("Allowance updated.")
 }
 
+// rentersetoverdrivecmd sets the number of extra pieces that are requested for
+// chunks that take longer than expected.
+func rentersetoverdrivecmd(pieces string) {
+	overdrive, err := strconv.ParseUint(pieces, 10, 64)
+	if err != nil {
+		die("Could not parse number of pieces:", err)
+	}
+	err = httpClient.RenterPostDownloadOverdrive(overdrive)
+	if err != nil {
+		die("Could not set download overdrive:", err)
+	}
+	fmt.Println("Download overdrive updated.")
+}
+
+// rentersetredundancycmd re-encodes a file with a different number of data and
+// parity pieces.
+func rentersetredundancycmd(path, data, parity string) {
+	dataPieces, err := strconv.ParseUint(data, 10, 64)
+	if err != nil {
+		die("Could not parse number of data pieces:", err)
+	}
+	parityPieces, err := strconv.ParseUint(parity, 10, 64)
+	if err != nil {
+		die("Could not parse number of parity pieces:", err)
+	}
+	err = httpClient.RenterRedundancyPost(path, dataPieces, parityPieces)
+	if err != nil {
+		die("Could not change the redundancy:", err)
+	}
+	fmt.Printf("Re-encoding %v as %v-of-%v...\n", path, dataPieces, dataPieces+parityPieces)
+	for range time.Tick(time.Second) {
+		rrg, err := httpClient.RenterRedundancyGet(path)
+		if err != nil {
+			die("Could not get the status of the change:", err)
+		} else if len(rrg.Changes) == 0 {
+			die("The change of redundancy was interrupted.")
+		}
+		rc := rrg.Changes[len(rrg.Changes)-1]
+		if !rc.Completed {
+			continue
+		} else if rc.Error != "" {
+			die("Could not change the redundancy:", rc.Error)
+		}
+		break
+	}
+	fmt.Printf("%v is now stored as %v-of-%v.\n", path, dataPieces, dataPieces+parityPieces)
+}
+
 // byValue sorts contracts by their value in siacoins, high to low. If two
 // contracts have the same value, they are sorted by their host's address.
 type byValue []api.RenterContract
@@ -435,6 +993,10 @@ func renterfilesdeletecmd(path string) {
 // renterfilesdownloadcmd is the handler for the comand `siac renter download [path] [destination]`.
 // Downloads a path from the Sia network to the local specified destination.
 func renterfilesdownloadcmd(path, destination string) {
+	if renterRecursive {
+		renterdownloadfolder(path, destination)
+		return
+	}
 	destination = abs(destination)
 	done := make(chan struct{})
 	go downloadprogress(done, path)
@@ -487,6 +1049,57 @@ func (s bySiaPath) Len() int           { return len(s) }
 func (s bySiaPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s bySiaPath) Less(i, j int) bool { return s[i].SiaPath < s[j].SiaPath }
 
+// renterdirlistcmd is the handler for the command `siac renter ls [path]`.
+// Lists the subdirectories and files of a directory.
+func renterdirlistcmd(cmd *cobra.Command, args []string) {
+	var path string
+	switch len(args) {
+	case 0:
+	case 1:
+		path = args[0]
+	default:
+		cmd.UsageFunc()(cmd)
+		os.Exit(exitCodeUsage)
+	}
+	rd, err := httpClient.RenterDirGet(path)
+	if err != nil {
+		die("Could not list directory:", err)
+	}
+	dir := rd.Directories[0]
+	fmt.Printf("%v files, %v in /%v\n", dir.NumFiles, filesizeUnits(int64(dir.AggregateSize)), dir.SiaPath)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if renterListVerbose {
+		fmt.Fprintln(w, "Size\tFiles\tRedundancy\tStuck chunks\tLast update\tSia path")
+	}
+	for _, sd := range rd.Directories[1:] {
+		fmt.Fprintf(w, "%9s", filesizeUnits(int64(sd.AggregateSize)))
+		if renterListVerbose {
+			redundancyStr := fmt.Sprintf("%.2f", sd.MinRedundancy)
+			if sd.MinRedundancy == -1 {
+				redundancyStr = "-"
+			}
+			fmt.Fprintf(w, "\t%v\t%10s\t%v\t%v", sd.NumFiles, redundancyStr, sd.StuckChunks, sd.LastUpdate.Format("2006-01-02 15:04"))
+		}
+		fmt.Fprintf(w, "\t%s/\n", sd.SiaPath)
+	}
+	for _, file := range rd.Files {
+		fmt.Fprintf(w, "%9s", filesizeUnits(int64(file.Filesize)))
+		if renterListVerbose {
+			redundancyStr := fmt.Sprintf("%.2f", file.Redundancy)
+			if file.Redundancy == -1 {
+				redundancyStr = "-"
+			}
+			fmt.Fprintf(w, "\t\t%10s\t\t", redundancyStr)
+		}
+		fmt.Fprintf(w, "\t%s", file.SiaPath)
+		if !renterListVerbose && !file.Available {
+			fmt.Fprintf(w, " (uploading, %0.2f%%)", file.UploadProgress)
+		}
+		fmt.Fprintln(w, "")
+	}
+	w.Flush()
+}
+
 // renterfileslistcmd is the handler for the command `siac renter list`.
 // Lists files known to the renter on the network.
 func renterfileslistcmd() {
@@ -529,6 +1142,140 @@ func renterfileslistcmd() {
 	w.Flush()
 }
 
+// renterfilecmd is the handler for the command `siac renter file [path]`.
+// Shows the details of a file.
+func renterfilecmd(path string) {
+	rf, err := httpClient.RenterFileGet(path)
+	if err != nil {
+		die("Could not get file details:", err)
+	}
+	file := rf.File
+	redundancyStr := fmt.Sprintf("%.2f", file.Redundancy)
+	if file.Redundancy == -1 {
+		redundancyStr = "-"
+	}
+	checksumStr := file.Checksum.String()
+	if file.Checksum == (crypto.Hash{}) {
+		checksumStr = "unknown"
+	}
+	compressionStr := "none"
+	if file.Compression != "" {
+		compressionStr = fmt.Sprintf("%v (%v stored)", file.Compression, filesizeUnits(int64(file.CompressedSize)))
+	}
+	fmt.Printf(`File %v
+  Local Path:    %v
+  File Size:     %v
+  Checksum:      %v
+  Deduplicated:  %v
+  Compression:   %v
+  Erasure Code:  %v-of-%v
+
+  Available:     %v
+  Renewing:      %v
+  Redundancy:    %v
+  Health:        %.2f
+  Stuck Chunks:  %v
+  Uploaded:      %v (%.2f%%)
+  Priority:      %v
+  Upload Paused: %v
+  Expiration:    Block %v
+`, file.SiaPath, file.LocalPath, filesizeUnits(int64(file.Filesize)), checksumStr, yesNo(file.Deduplicated), compressionStr,
+		file.DataPieces, file.DataPieces+file.ParityPieces,
+		yesNo(file.Available), yesNo(file.Renewing), redundancyStr, file.Health, file.StuckChunks,
+		filesizeUnits(int64(file.UploadedBytes)), file.UploadProgress, file.Priority, yesNo(file.UploadPaused),
+		file.Expiration)
+	if len(file.Metadata) == 0 {
+		return
+	}
+	var keys []string
+	for key := range file.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Println("\n  Metadata:")
+	for _, key := range keys {
+		fmt.Printf("    %v=%v\n", key, file.Metadata[key])
+	}
+}
+
+// renterfilesmetadatacmd is the handler for the command `siac renter metadata
+// [path] [key=value]...`. Replaces the application metadata of a file.
+func renterfilesmetadatacmd(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.UsageFunc()(cmd)
+		os.Exit(exitCodeUsage)
+	}
+	err := httpClient.RenterMetadataPost(args[0], parseFileMetadata(args[1:]))
+	if err != nil {
+		die("Could not set metadata:", err)
+	}
+	fmt.Printf("Set the metadata of %v.\n", args[0])
+}
+
+// renterfilessearchcmd is the handler for the command `siac renter search
+// [prefix]`. Lists the files that match the search.
+func renterfilessearchcmd(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		cmd.UsageFunc()(cmd)
+		os.Exit(exitCodeUsage)
+	}
+	q := modules.FileSearchQuery{
+		Metadata: parseFileMetadata(renterFileMetadata),
+		Offset:   renterSearchOffset,
+		Limit:    renterSearchLimit,
+	}
+	if len(args) == 1 {
+		q.Prefix = args[0]
+	}
+	rs, err := httpClient.RenterSearchGet(q)
+	if err != nil {
+		die("Could not search files:", err)
+	}
+	if len(rs.Files) == 0 {
+		fmt.Printf("No files shown, %v files match.\n", rs.Total)
+		return
+	}
+	fmt.Printf("Showing %v of %v matching files:\n", len(rs.Files), rs.Total)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "File size\tHealth\tSia path")
+	for _, file := range rs.Files {
+		fmt.Fprintf(w, "%9s\t%6.2f\t%s\n", filesizeUnits(int64(file.Filesize)), file.Health, file.SiaPath)
+	}
+	w.Flush()
+}
+
+// parseFileMetadata parses key=value pairs of application metadata.
+func parseFileMetadata(pairs []string) map[string]string {
+	metadata := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i == -1 {
+			die("Could not parse metadata:", pair, "is not a key=value pair")
+		}
+		metadata[pair[:i]] = pair[i+1:]
+	}
+	return metadata
+}
+
+// renterfilesloadcmd is the handler for the command `siac renter load
+// [source]`. Loads the files in a .sia file into the renter.
+func renterfilesloadcmd(source string) {
+	var rl api.RenterLoad
+	var err error
+	if renterShareASCII {
+		rl, err = httpClient.RenterLoadASCIIPost(source)
+	} else {
+		rl, err = httpClient.RenterLoadPost(abs(source))
+	}
+	if err != nil {
+		die("Could not load .sia file:", err)
+	}
+	fmt.Printf("Loaded %d files:\n", len(rl.FilesAdded))
+	for _, siaPath := range rl.FilesAdded {
+		fmt.Println("\t" + siaPath)
+	}
+}
+
 // renterfilesrenamecmd is the handler for the command `siac renter rename [path] [newpath]`.
 // Renames a file on the Sia network.
 func renterfilesrenamecmd(path, newpath string) {
@@ -539,6 +1286,34 @@ func renterfilesrenamecmd(path, newpath string) {
 	fmt.Printf("Renamed %s to %s\n", path, newpath)
 }
 
+// renterfilessharecmd is the handler for the command `siac renter share
+// [destination] [path]...`. Writes the specified files to a .sia file.
+func renterfilessharecmd(cmd *cobra.Command, args []string) {
+	if renterShareASCII {
+		if len(args) == 0 {
+			cmd.UsageFunc()(cmd)
+			os.Exit(exitCodeUsage)
+		}
+		rsa, err := httpClient.RenterShareASCIIGet(args, renterShareStripIDs)
+		if err != nil {
+			die("Could not share files:", err)
+		}
+		fmt.Println(rsa.ASCIIsia)
+		return
+	}
+
+	if len(args) < 2 {
+		cmd.UsageFunc()(cmd)
+		os.Exit(exitCodeUsage)
+	}
+	destination := abs(args[0])
+	err := httpClient.RenterShareGet(args[1:], destination, renterShareStripIDs)
+	if err != nil {
+		die("Could not share files:", err)
+	}
+	fmt.Printf("Shared %d files in '%s'.\n", len(args)-1, destination)
+}
+
 // renterfilesuploadcmd is the handler for the command `siac renter upload
 // [source] [path]`. Uploads the [source] file to [path] on the Sia network.
 // If [source] is a directory, all files inside it will be uploaded and named
@@ -548,39 +1323,17 @@ func renterfilesuploadcmd(source, path string) {
 	if err != nil {
 		die("Could not stat file or folder:", err)
 	}
+	if renterUploadDryRun {
+		renteruploadquote(abs(source))
+		return
+	}
 
 	if stat.IsDir() {
 		// folder
-		var files []string
-		err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				fmt.Println("Warning: skipping file:", err)
-				return nil
-			}
-			if info.IsDir() {
-				return nil
-			}
-			files = append(files, path)
-			return nil
-		})
-		if err != nil {
-			die("Could not read folder:", err)
-		} else if len(files) == 0 {
-			die("Nothing to upload.")
-		}
-		for _, file := range files {
-			fpath, _ := filepath.Rel(source, file)
-			fpath = filepath.Join(path, fpath)
-			fpath = filepath.ToSlash(fpath)
-			err = httpClient.RenterUploadDefaultPost(abs(file), fpath)
-			if err != nil {
-				die("Could not upload file:", err)
-			}
-		}
-		fmt.Printf("Uploaded %d files into '%s'.\n", len(files), path)
+		renteruploadfolder(source, path)
 	} else {
 		// single file
-		err = httpClient.RenterUploadDefaultPost(abs(source), path)
+		err = renterUploadFile(abs(source), path)
 		if err != nil {
 			die("Could not upload file:", err)
 		}
@@ -588,6 +1341,47 @@ func renterfilesuploadcmd(source, path string) {
 	}
 }
 
+// renteruploadquote shows what uploading the file or folder at source would
+// cost, without uploading it.
+func renteruploadquote(source string) {
+	q, err := httpClient.RenterUploadQuoteGet(source, 0, 0, types.BlockHeight(renterUploadDuration))
+	if err != nil {
+		die("Could not quote upload:", err)
+	}
+	fmt.Printf(`Upload quote for '%v':
+  Size:              %v (%v uploaded to %v hosts)
+  Duration:          %v blocks
+  Storage:           %v
+  Upload:            %v
+  Contract Fees:     %v
+  Siafund Fees:      %v
+  Total:             %v
+  Unspent Allowance: %v
+`, source, filesizeUnits(int64(q.Filesize)), filesizeUnits(int64(q.UploadSize)), q.Hosts, q.Duration,
+		currencyUnits(q.StorageCost), currencyUnits(q.UploadCost), currencyUnits(q.ContractFees),
+		currencyUnits(q.SiafundFees), currencyUnits(q.TotalCost), currencyUnits(q.RemainingAllowance))
+	if q.WithinAllowance {
+		fmt.Println("The unspent allowance covers the upload.")
+	} else {
+		fmt.Println("The unspent allowance does not cover the upload.")
+	}
+}
+
+// renterUploadFile uploads the file at source to path, using the flags of
+// `siac renter upload`.
+func renterUploadFile(source, path string) error {
+	if len(renterFileMetadata) != 0 {
+		return httpClient.RenterUploadMetadataPost(source, path, renterUploadPriority, renterUploadDedup, renterUploadCompress, parseFileMetadata(renterFileMetadata))
+	}
+	if renterUploadCompress {
+		return httpClient.RenterUploadCompressPost(source, path, renterUploadPriority)
+	}
+	if renterUploadDedup {
+		return httpClient.RenterUploadDedupPost(source, path, renterUploadPriority)
+	}
+	return httpClient.RenterUploadPriorityPost(source, path, renterUploadPriority)
+}
+
 // renterpricescmd is the handler for the command `siac renter prices`, which
 // displays the prices of various storage operations.
 func renterpricescmd() {
@@ -604,3 +1398,29 @@ func renterpricescmd() {
 	fmt.Fprintln(w, "\tUpload 1 TB:\t", currencyUnits(rpg.UploadTerabyte))
 	w.Flush()
 }
+
+// renterworkerscmd is the handler for the command `siac renter workers`, which
+// lists the download statistics of the renter's workers.
+func renterworkerscmd() {
+	rw, err := httpClient.RenterWorkersGet()
+	if err != nil {
+		die("Could not get workers:", err)
+	}
+	if len(rw.Workers) == 0 {
+		fmt.Println("The renter has no workers.")
+		return
+	}
+	fmt.Println("Workers:")
+	w := tabwriter.NewWriter(os.Stdout, 2, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Host\tTime to First Byte\tThroughput\tFailure Rate\tDownloads\tFailures")
+	for _, worker := range rw.Workers {
+		fmt.Fprintf(w, "%v\t%v\t%v/s\t%.1f%%\t%v\t%v\n",
+			worker.NetAddress,
+			worker.DownloadTimeToFirstByte.Round(time.Millisecond),
+			filesizeUnits(int64(worker.DownloadThroughput)),
+			worker.DownloadFailureRate*100,
+			worker.Downloads,
+			worker.DownloadFailures)
+	}
+	w.Flush()
+}
@@ -0,0 +1,471 @@
+package main
+
+// rentermirror.go mirrors a local folder into a siapath prefix and back. The
+// relative path of every file below the folder is the same as the relative
+// path of its siapath below the prefix. Files that were already transferred
+// with the same size and modification time are skipped, so mirroring a folder
+// again only transfers the files that changed. The modification time of an
+// uploaded file is recorded by the renter, and downloaded files get the
+// modification time of the file that was uploaded.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/node/api"
+)
+
+// mirrorTempExtension is the extension of the temporary files that files are
+// downloaded into. Local files with this extension are not uploaded.
+const mirrorTempExtension = ".siadownload"
+
+// mirrorUploadInfix separates the siapath of a changed file from the suffix of
+// the temporary siapath that its new version is uploaded to.
+const mirrorUploadInfix = ".upload-"
+
+// mirrorFile is a file that is transferred as part of a mirrored folder.
+type mirrorFile struct {
+	localPath string
+	siaPath   string
+	remote    modules.FileInfo
+
+	// pending is the new version of a changed file that an earlier upload
+	// left at a temporary siapath, because it wasn't available yet.
+	pending modules.FileInfo
+
+	// tempPath is the temporary file that a file is downloaded into.
+	tempPath string
+}
+
+// checkGlobs checks that all of the --include and --exclude patterns are
+// valid.
+func checkGlobs() error {
+	for _, patterns := range [][]string{renterIncludeGlobs, renterExcludeGlobs} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesGlob returns whether the slash-separated path rel matches any of the
+// patterns. Patterns that don't contain a slash are matched against the last
+// element of rel.
+func matchesGlob(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if match, _ := path.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorIncluded returns whether the file at the slash-separated path rel
+// within a mirrored folder is transferred. A file is excluded if it or any of
+// its parent folders matches an --exclude pattern. Otherwise it is included if
+// there are no --include patterns or if it matches one of them.
+func mirrorIncluded(rel string, include, exclude []string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if matchesGlob(p, exclude) {
+			return false
+		}
+	}
+	return len(include) == 0 || matchesGlob(rel, include)
+}
+
+// unchangedFile returns whether the local file described by info has the size
+// and modification time of the uploaded file fi. Modification times are
+// compared in whole seconds, since not every filesystem stores them more
+// precisely.
+func unchangedFile(info os.FileInfo, fi modules.FileInfo) bool {
+	return !fi.ModTime.IsZero() && uint64(info.Size()) == fi.Filesize && info.ModTime().Unix() == fi.ModTime.Unix()
+}
+
+// renterFilesByPath returns the renter's files, indexed by their siapath.
+func renterFilesByPath() (map[string]modules.FileInfo, error) {
+	rf, err := httpClient.RenterFilesGet()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]modules.FileInfo, len(rf.Files))
+	for _, fi := range rf.Files {
+		files[fi.SiaPath] = fi
+	}
+	return files, nil
+}
+
+// renteruploadfolder uploads the files of the folder at source to the
+// siapath prefix siaPath. Files that were already uploaded with the same size
+// and modification time are skipped, files that changed are replaced.
+func renteruploadfolder(source, siaPath string) {
+	if err := checkGlobs(); err != nil {
+		die(err)
+	}
+	remote, err := renterFilesByPath()
+	if err != nil {
+		die("Could not get files:", err)
+	}
+	siaPath = strings.Trim(siaPath, "/")
+
+	// Find the new versions of changed files that an earlier upload couldn't
+	// replace the old version with yet.
+	pending := make(map[string]modules.FileInfo)
+	for tmpPath, fi := range remote {
+		i := strings.LastIndex(tmpPath, mirrorUploadInfix)
+		if i == -1 {
+			continue
+		}
+		if _, err := strconv.ParseInt(tmpPath[i+len(mirrorUploadInfix):], 10, 64); err != nil {
+			continue
+		}
+		if _, exists := remote[tmpPath[:i]]; exists {
+			pending[tmpPath[:i]] = fi
+		}
+	}
+
+	var files []mirrorFile
+	var skipped int
+	err = filepath.Walk(source, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Println("Warning: skipping file:", err)
+			return nil
+		}
+		rel, _ := filepath.Rel(source, localPath)
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if rel != "." && !mirrorIncluded(rel, nil, renterExcludeGlobs) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !mirrorIncluded(rel, renterIncludeGlobs, renterExcludeGlobs) || filepath.Ext(localPath) == mirrorTempExtension {
+			return nil
+		}
+		f := mirrorFile{
+			localPath: abs(localPath),
+			siaPath:   path.Join(siaPath, rel),
+		}
+		fi, exists := remote[f.siaPath]
+		if exists && unchangedFile(info, fi) {
+			skipped++
+			return nil
+		}
+		f.remote = fi
+		if tmp, exists := pending[f.siaPath]; exists && unchangedFile(info, tmp) {
+			f.pending = tmp
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		die("Could not read folder:", err)
+	} else if len(files) == 0 && skipped == 0 {
+		die("Nothing to upload.")
+	}
+
+	// Files that changed are uploaded under a temporary siapath. They only
+	// replace the old version, which is moved to the trash, once they are
+	// available, see uploadfolderprogress. Until then, the old version is left
+	// untouched.
+	siaPaths := make(map[string]string, len(files))
+	for _, f := range files {
+		if f.remote.SiaPath == "" {
+			if err := renterUploadFile(f.localPath, f.siaPath); err != nil {
+				die("Could not upload file:", err)
+			}
+			siaPaths[f.siaPath] = ""
+			continue
+		}
+		if f.pending.SiaPath != "" {
+			siaPaths[f.pending.SiaPath] = f.siaPath
+			continue
+		}
+		tmpPath := fmt.Sprintf("%s%s%d", f.siaPath, mirrorUploadInfix, time.Now().UnixNano())
+		if err := renterUploadFile(f.localPath, tmpPath); err != nil {
+			die("Could not upload file, the old version of '"+f.siaPath+"' was kept:", err)
+		}
+		siaPaths[tmpPath] = f.siaPath
+	}
+	fmt.Printf("Uploading %d files into '%s', skipped %d unchanged files.\n", len(files), siaPath, skipped)
+	if len(files) > 0 {
+		uploadfolderprogress(siaPaths)
+	}
+}
+
+// uploadfolderprogress shows the aggregate upload progress of the files at
+// the keys of siaPaths until all of them are fully uploaded. A file can't have
+// more pieces uploaded than the renter has hosts to upload to, so a file is
+// fully uploaded once it has a piece on every such host. A file that was
+// uploaded under a temporary siapath replaces the file at its value in
+// siaPaths as soon as it is available.
+func uploadfolderprogress(siaPaths map[string]string) {
+	for range time.Tick(time.Second) {
+		rf, err := httpClient.RenterFilesGet()
+		if err != nil {
+			continue // benign
+		}
+		rc, err := httpClient.RenterContractsGet()
+		if err != nil {
+			continue // benign
+		}
+		var hosts int
+		for _, c := range rc.Contracts {
+			if c.GoodForUpload {
+				hosts++
+			}
+		}
+		if hosts == 0 {
+			fmt.Println()
+			die("The renter has no contracts to upload to, the upload continues once it has formed contracts." + pendingReplacements(siaPaths))
+		}
+		if replaceAvailable(siaPaths, rf.Files) {
+			// The replaced files are tracked under their new siapath from the
+			// next update on.
+			continue
+		}
+		var size, uploaded float64
+		var found, complete int
+		for _, fi := range rf.Files {
+			if _, exists := siaPaths[fi.SiaPath]; !exists {
+				continue
+			}
+			found++
+			target := float64(100)
+			if numPieces := fi.DataPieces + fi.ParityPieces; uint64(hosts) < numPieces {
+				target = 100 * float64(hosts) / float64(numPieces)
+			}
+			// The progress is rounded, since the upload progress of a file
+			// isn't exactly the fraction of hosts that it is uploaded to.
+			progress := float64(1)
+			if target > 0 {
+				progress = math.Min(fi.UploadProgress/target+1e-9, 1)
+			}
+			size += float64(fi.Filesize)
+			uploaded += float64(fi.Filesize) * progress
+			if progress >= 1 {
+				complete++
+			}
+		}
+		pct := float64(100)
+		if size > 0 {
+			pct = 100 * uploaded / size
+		}
+		fmt.Printf("\rUploading... %5.1f%% of %v, %d of %d files complete    ", pct, filesizeUnits(int64(size)), complete, found)
+		if complete == found {
+			fmt.Printf("\nUploaded %d files.\n", complete)
+			return
+		}
+	}
+}
+
+// replaceAvailable replaces the files that the available files uploaded under
+// a temporary siapath in siaPaths stand in for, and tracks them under their
+// new siapath. It returns true if any file was replaced. If a file can't be
+// replaced, the old version is kept and the upload remains at its temporary
+// siapath.
+func replaceAvailable(siaPaths map[string]string, files []modules.FileInfo) bool {
+	var replaced bool
+	for _, fi := range files {
+		replace := siaPaths[fi.SiaPath]
+		if replace == "" || !fi.Available {
+			continue
+		}
+		delete(siaPaths, fi.SiaPath)
+		if err := httpClient.RenterReplacePost(fi.SiaPath, replace); err != nil {
+			fmt.Printf("\nCould not replace '%s', the old version was kept and the new version remains at '%s': %v\n", replace, fi.SiaPath, err)
+			siaPaths[fi.SiaPath] = ""
+			continue
+		}
+		siaPaths[replace] = ""
+		replaced = true
+	}
+	return replaced
+}
+
+// pendingReplacements returns a note on the files in siaPaths that were
+// uploaded under a temporary siapath and haven't replaced their old version
+// yet, or the empty string if there are none.
+func pendingReplacements(siaPaths map[string]string) string {
+	var pending []string
+	for tmpPath, replace := range siaPaths {
+		if replace != "" {
+			pending = append(pending, fmt.Sprintf("\n  '%s' is uploaded to '%s'", replace, tmpPath))
+		}
+	}
+	if len(pending) == 0 {
+		return ""
+	}
+	sort.Strings(pending)
+	return "\nThe following files keep their old version until their new version is available, run the upload again to replace them:" + strings.Join(pending, "")
+}
+
+// renterdownloadfolder downloads the files below the siapath prefix siaPath
+// into the folder at destination. Local files with the same size and
+// modification time as the uploaded file are skipped.
+func renterdownloadfolder(siaPath, destination string) {
+	if err := checkGlobs(); err != nil {
+		die(err)
+	}
+	remote, err := renterFilesByPath()
+	if err != nil {
+		die("Could not get files:", err)
+	}
+	destination = abs(destination)
+	prefix := strings.Trim(siaPath, "/")
+
+	var files []mirrorFile
+	var skipped int
+	for _, fi := range remote {
+		rel := fi.SiaPath
+		if prefix != "" {
+			if !strings.HasPrefix(rel, prefix+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(rel, prefix+"/")
+		}
+		if !mirrorIncluded(rel, renterIncludeGlobs, renterExcludeGlobs) {
+			continue
+		}
+		f := mirrorFile{
+			localPath: filepath.Join(destination, filepath.FromSlash(rel)),
+			siaPath:   fi.SiaPath,
+			remote:    fi,
+		}
+		if info, err := os.Stat(f.localPath); err == nil && unchangedFile(info, fi) {
+			skipped++
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 && skipped == 0 {
+		die("Nothing to download.")
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].siaPath < files[j].siaPath
+	})
+
+	// Files are downloaded into a temporary file next to the local file,
+	// which replaces the local file once the download has finished. Downloads
+	// don't truncate their destination, so a temporary file of an earlier
+	// attempt is removed first. Empty files can't be downloaded, they are
+	// created instead.
+	var downloads []mirrorFile
+	for i, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.localPath), 0700); err != nil {
+			die("Could not create folder:", err)
+		}
+		if f.remote.Filesize == 0 {
+			if err := ioutil.WriteFile(f.localPath, nil, 0600); err != nil {
+				die("Could not create file:", err)
+			}
+			continue
+		}
+		f.tempPath = f.localPath + mirrorTempExtension
+		files[i] = f
+		if err := os.Remove(f.tempPath); err != nil && !os.IsNotExist(err) {
+			die("Could not remove temporary file:", err)
+		}
+		if err := httpClient.RenterDownloadFullGet(f.siaPath, f.tempPath, true); err != nil {
+			die("Could not download file:", err)
+		}
+		downloads = append(downloads, f)
+	}
+	fmt.Printf("Downloading %d files into '%s', skipped %d unchanged files.\n", len(files), destination, skipped)
+	failed := make(map[string]string)
+	if len(downloads) > 0 {
+		failed = downloadfolderprogress(downloads)
+	}
+
+	// Downloaded files get the modification time of the uploaded file, so
+	// that they are skipped when the folder is downloaded again. The local
+	// files of failed downloads are kept.
+	for _, f := range files {
+		if _, failed := failed[f.localPath]; failed {
+			os.Remove(f.tempPath)
+			continue
+		}
+		if f.tempPath != "" {
+			if err := os.Rename(f.tempPath, f.localPath); err != nil {
+				failed[f.localPath] = err.Error()
+				os.Remove(f.tempPath)
+				continue
+			}
+		}
+		if f.remote.ModTime.IsZero() {
+			continue
+		}
+		if err := os.Chtimes(f.localPath, time.Now(), f.remote.ModTime); err != nil {
+			fmt.Println("Warning: could not set modification time:", err)
+		}
+	}
+	if len(failed) > 0 {
+		for _, f := range files {
+			if err, failed := failed[f.localPath]; failed {
+				fmt.Fprintf(os.Stderr, "Could not download '%s': %v\n", f.siaPath, err)
+			}
+		}
+		die(fmt.Sprintf("%d of %d downloads failed.", len(failed), len(downloads)))
+	}
+}
+
+// downloadfolderprogress shows the aggregate progress of the downloads of
+// files until all of them have completed. The download of a file is the most
+// recent download to its temporary file. It returns the errors of the
+// downloads that failed, indexed by the local path of their file.
+func downloadfolderprogress(files []mirrorFile) map[string]string {
+	for range time.Tick(time.Second) {
+		queue, err := httpClient.RenterDownloadsGet()
+		if err != nil {
+			continue // benign
+		}
+		downloads := make(map[string]api.DownloadInfo, len(files))
+		for _, f := range files {
+			downloads[f.tempPath] = api.DownloadInfo{}
+		}
+		for _, d := range queue.Downloads {
+			latest, exists := downloads[d.Destination]
+			if exists && d.StartTime.After(latest.StartTime) {
+				downloads[d.Destination] = d
+			}
+		}
+		var length, received uint64
+		var complete int
+		failed := make(map[string]string)
+		for _, f := range files {
+			d := downloads[f.tempPath]
+			length += d.Length
+			received += d.Received
+			if d.Completed {
+				complete++
+			}
+			if d.Error != "" {
+				failed[f.localPath] = d.Error
+			}
+		}
+		pct := float64(100)
+		if length > 0 {
+			pct = 100 * float64(received) / float64(length)
+		}
+		fmt.Printf("\rDownloading... %5.1f%% of %v, %d of %d files complete    ", pct, filesizeUnits(int64(length)), complete, len(files))
+		if complete == len(files) {
+			fmt.Printf("\nDownloaded %d files.\n", complete-len(failed))
+			return failed
+		}
+	}
+	return nil
+}
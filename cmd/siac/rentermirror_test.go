@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestMirrorIncluded checks which files of a mirrored folder are selected by
+// the --include and --exclude patterns.
+func TestMirrorIncluded(t *testing.T) {
+	tests := []struct {
+		rel              string
+		include, exclude []string
+		included         bool
+	}{
+		{"a.txt", nil, nil, true},
+		{"dir/a.txt", []string{"*.txt"}, nil, true},
+		{"dir/a.jpg", []string{"*.txt"}, nil, false},
+		{"dir/a.txt", []string{"dir/*"}, nil, true},
+		{"other/a.txt", []string{"dir/*"}, nil, false},
+		{"dir/a.txt", nil, []string{"*.txt"}, false},
+		{"dir/a.txt", []string{"*.txt"}, []string{"a.*"}, false},
+		// Excluding a folder excludes all files below it.
+		{".git/objects/ab", nil, []string{".git"}, false},
+		{"dir/.git/HEAD", nil, []string{".git"}, false},
+		{"dir/.gitignore", nil, []string{".git"}, true},
+		{"dir/sub/a.txt", nil, []string{"dir/sub"}, false},
+	}
+	for _, test := range tests {
+		if included := mirrorIncluded(test.rel, test.include, test.exclude); included != test.included {
+			t.Errorf("%v with include %v and exclude %v: expected %v, got %v", test.rel, test.include, test.exclude, test.included, included)
+		}
+	}
+}
+
+// TestUnchangedFile checks that local files are only considered unchanged if
+// they have the size and modification time of the uploaded file.
+func TestUnchangedFile(t *testing.T) {
+	dir := build.TempDir("siac", t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, make([]byte, 10), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Unix(1500000000, 0)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi := modules.FileInfo{Filesize: 10, ModTime: modTime.Add(500 * time.Millisecond)}
+	if !unchangedFile(info, fi) {
+		t.Error("file with the same size and modification time is changed")
+	}
+	fi.Filesize = 11
+	if unchangedFile(info, fi) {
+		t.Error("file with a different size is unchanged")
+	}
+	fi = modules.FileInfo{Filesize: 10, ModTime: modTime.Add(time.Second)}
+	if unchangedFile(info, fi) {
+		t.Error("file with a different modification time is unchanged")
+	}
+	if unchangedFile(info, modules.FileInfo{Filesize: 10}) {
+		t.Error("file without a recorded modification time is unchanged")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/node/api"
 	"github.com/NebulousLabs/Sia/profile"
 	mnemonics "github.com/NebulousLabs/entropy-mnemonics"
 
@@ -41,6 +42,12 @@ func verifyAPISecurity(config Config) error {
 			}
 			return errors.New("you must pass --disable-api-security to bind Siad to a non-localhost address")
 		}
+		if config.Siad.WebDAVaddr != "" && !modules.NetAddress(config.Siad.WebDAVaddr).IsLoopback() {
+			return errors.New("you must pass --disable-api-security to bind the WebDAV server to a non-localhost address")
+		}
+		if config.Siad.S3addr != "" && !modules.NetAddress(config.Siad.S3addr).IsLoopback() {
+			return errors.New("you must pass --disable-api-security to bind the S3 gateway to a non-localhost address")
+		}
 		return nil
 	}
 
@@ -100,6 +107,8 @@ func processConfig(config Config) (Config, error) {
 	config.Siad.APIaddr = processNetAddr(config.Siad.APIaddr)
 	config.Siad.RPCaddr = processNetAddr(config.Siad.RPCaddr)
 	config.Siad.HostAddr = processNetAddr(config.Siad.HostAddr)
+	config.Siad.WebDAVaddr = processNetAddr(config.Siad.WebDAVaddr)
+	config.Siad.S3addr = processNetAddr(config.Siad.S3addr)
 	config.Siad.Modules, err1 = processModules(config.Siad.Modules)
 	config.Siad.Profile, err2 = processProfileFlags(config.Siad.Profile)
 	err3 := verifyAPISecurity(config)
@@ -150,6 +159,15 @@ func startDaemon(config Config) (err error) {
 			}
 		}
 	}
+	if config.Siad.S3addr != "" {
+		config.S3Keys, err = api.ParseS3Keys(os.Getenv("SIA_S3_KEYS"))
+		if err != nil {
+			return err
+		}
+		if len(config.S3Keys) == 0 {
+			return errors.New("the S3 gateway requires access keys, set them using the SIA_S3_KEYS environment variable")
+		}
+	}
 
 	// Print the siad Version and GitRevision
 	fmt.Println("Sia Daemon v" + build.Version)
@@ -179,6 +179,25 @@ func TestVerifyAPISecurity(t *testing.T) {
 		t.Error("public + securityOn was accepted")
 	}
 
+	// Check that a public WebDAV address is rejected when security is
+	// enabled.
+	var securityOnPublicWebDAV Config
+	securityOnPublicWebDAV.Siad.APIaddr = "127.0.0.1:9980"
+	securityOnPublicWebDAV.Siad.WebDAVaddr = ":9990"
+	err = verifyAPISecurity(securityOnPublicWebDAV)
+	if err == nil {
+		t.Error("public WebDAV + securityOn was accepted")
+	}
+
+	// Check that a public S3 address is rejected when security is enabled.
+	var securityOnPublicS3 Config
+	securityOnPublicS3.Siad.APIaddr = "127.0.0.1:9980"
+	securityOnPublicS3.Siad.S3addr = "sia.tech:9985"
+	err = verifyAPISecurity(securityOnPublicS3)
+	if err == nil {
+		t.Error("public S3 + securityOn was accepted")
+	}
+
 	// Check that a public hostname is rejected when security is disabled and
 	// there is no api password.
 	var securityOffPublic Config
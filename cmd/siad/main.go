@@ -29,12 +29,19 @@ type Config struct {
 	// --authenticate-api flag is set.
 	APIPassword string
 
+	// The S3Keys map the access keys of the S3 gateway to their secret keys.
+	// They are read from the SIA_S3_KEYS environment variable if the
+	// --s3-addr flag is set.
+	S3Keys map[string]string
+
 	// The Siad variables are referenced directly by cobra, and are set
 	// according to the flags.
 	Siad struct {
 		APIaddr      string
 		RPCaddr      string
 		HostAddr     string
+		WebDAVaddr   string
+		S3addr       string
 		AllowAPIBind bool
 
 		Modules           string
@@ -161,6 +168,8 @@ func main() {
 	root.Flags().StringVarP(&globalConfig.Siad.HostAddr, "host-addr", "", ":9982", "which port the host listens on")
 	root.Flags().StringVarP(&globalConfig.Siad.ProfileDir, "profile-directory", "", "profiles", "location of the profiling directory")
 	root.Flags().StringVarP(&globalConfig.Siad.APIaddr, "api-addr", "", "localhost:9980", "which host:port the API server listens on")
+	root.Flags().StringVarP(&globalConfig.Siad.S3addr, "s3-addr", "", "", "which host:port the S3 gateway listens on, disabled if empty")
+	root.Flags().StringVarP(&globalConfig.Siad.WebDAVaddr, "webdav-addr", "", "", "which host:port the WebDAV server listens on, disabled if empty")
 	root.Flags().StringVarP(&globalConfig.Siad.SiaDir, "sia-directory", "d", "", "location of the sia directory")
 	root.Flags().BoolVarP(&globalConfig.Siad.NoBootstrap, "no-bootstrap", "", false, "disable bootstrapping on this run")
 	root.Flags().StringVarP(&globalConfig.Siad.Profile, "profile", "", "", "enable profiling with flags 'cmt' for CPU, memory, trace")
@@ -587,6 +587,42 @@ func (srv *Server) loadModules() error {
 	srv.api = a
 	srv.mu.Unlock()
 
+	// Start the WebDAV server if it was enabled.
+	if srv.config.Siad.WebDAVaddr != "" {
+		if r == nil {
+			return errors.New("the WebDAV server requires the renter module")
+		}
+		dav, err := api.NewWebDAVServer(srv.config.Siad.WebDAVaddr, r, srv.config.APIPassword)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := dav.Serve(); err != nil {
+				fmt.Println("WebDAV server stopped:", err)
+			}
+		}()
+		srv.moduleClosers = append(srv.moduleClosers, moduleCloser{name: "WebDAV server", Closer: dav})
+		fmt.Println("WebDAV server listening on", dav.Address())
+	}
+
+	// Start the S3 gateway if it was enabled.
+	if srv.config.Siad.S3addr != "" {
+		if r == nil {
+			return errors.New("the S3 gateway requires the renter module")
+		}
+		s3, err := api.NewS3Server(srv.config.Siad.S3addr, r, srv.config.S3Keys)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s3.Serve(); err != nil {
+				fmt.Println("S3 gateway stopped:", err)
+			}
+		}()
+		srv.moduleClosers = append(srv.moduleClosers, moduleCloser{name: "S3 gateway", Closer: s3})
+		fmt.Println("S3 gateway listening on", s3.Address())
+	}
+
 	// Attempt to auto-unlock the wallet using the SIA_WALLET_PASSWORD env variable
 	if password := os.Getenv("SIA_WALLET_PASSWORD"); password != "" {
 		fmt.Println("Sia Wallet Password found, attempting to auto-unlock wallet")
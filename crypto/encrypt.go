@@ -5,6 +5,7 @@ package crypto
 
 import (
 	"crypto/cipher"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"io"
@@ -17,6 +18,10 @@ import (
 const (
 	// TwofishOverhead is the number of bytes added by EncryptBytes
 	TwofishOverhead = 28
+
+	// TwofishNonceSize is the size of the nonce that EncryptBytes prepends to
+	// the ciphertext.
+	TwofishNonceSize = 12
 )
 
 var (
@@ -77,6 +82,31 @@ func (key TwofishKey) DecryptBytes(ct Ciphertext) ([]byte, error) {
 	return aead.Open(nil, ct[:aead.NonceSize()], ct[aead.NonceSize():], nil)
 }
 
+// DecryptBytesAt decrypts a part of the ciphertext created by EncryptBytes.
+// nonce is the nonce that EncryptBytes prepended to the ciphertext, and offset
+// is the position of the part within the plaintext. The part is not
+// authenticated, so its integrity needs to be verified in a different way,
+// e.g. with a Merkle proof.
+func (key TwofishKey) DecryptBytesAt(nonce []byte, ct []byte, offset uint64) ([]byte, error) {
+	if len(nonce) != TwofishNonceSize {
+		return nil, ErrInsufficientLen
+	}
+	// GCM encrypts the plaintext in counter mode, starting with the counter
+	// 2.
+	iv := make([]byte, twofish.BlockSize)
+	copy(iv, nonce)
+	binary.BigEndian.PutUint32(iv[TwofishNonceSize:], uint32(2+offset/twofish.BlockSize))
+	stream := cipher.NewCTR(key.NewCipher(), iv)
+
+	// Skip the part of the keystream that belongs to the plaintext in front
+	// of the offset.
+	skip := make([]byte, offset%twofish.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	plaintext := make([]byte, len(ct))
+	stream.XORKeyStream(plaintext, ct)
+	return plaintext, nil
+}
+
 // NewWriter returns a writer that encrypts or decrypts its input stream.
 func (key TwofishKey) NewWriter(w io.Writer) io.Writer {
 	// OK to use a zero IV if the key is unique for each ciphertext.
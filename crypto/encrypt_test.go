@@ -212,3 +212,32 @@ func TestCipherNewGCMAssumption(t *testing.T) {
 		t.Errorf("cipher must have BlockSize 16, but generated cipher has BlockSize %d\n", block.BlockSize())
 	}
 }
+
+// TestTwofishDecryptBytesAt checks that parts of a ciphertext can be decrypted
+// on their own.
+func TestTwofishDecryptBytesAt(t *testing.T) {
+	key := GenerateTwofishKey()
+	plaintext := fastrand.Bytes(600)
+	ciphertext := key.EncryptBytes(plaintext)
+	nonce, ct := ciphertext[:TwofishNonceSize], ciphertext[TwofishNonceSize:]
+
+	for _, r := range []struct{ offset, length uint64 }{
+		{0, 600},
+		{0, 1},
+		{16, 64},
+		{17, 100},
+		{599, 1},
+	} {
+		part, err := key.DecryptBytesAt(nonce, ct[r.offset:r.offset+r.length], r.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(part, plaintext[r.offset:r.offset+r.length]) {
+			t.Errorf("part at offset %v with length %v wasn't decrypted correctly", r.offset, r.length)
+		}
+	}
+
+	if _, err := key.DecryptBytesAt(nonce[:10], ct, 0); err != ErrInsufficientLen {
+		t.Error("expected ErrInsufficientLen, got", err)
+	}
+}
@@ -154,3 +154,85 @@ func VerifySegment(base []byte, hashSet []Hash, numSegments, proofIndex uint64,
 	}
 	return merkletree.VerifyProof(NewHash(), root[:], proofSet, proofIndex, numSegments)
 }
+
+// leftSubtreeSize returns the number of leaves in the left subtree of a Merkle
+// tree with n leaves, which is the largest power of two smaller than n.
+func leftSubtreeSize(n uint64) uint64 {
+	size := uint64(1)
+	for size*2 < n {
+		size *= 2
+	}
+	return size
+}
+
+// MerkleRangeProof builds a Merkle proof that the segments in the range
+// [start, end) are a part of the Merkle root formed by 'b'. The proof consists
+// of the roots of the largest subtrees that don't contain any segment of the
+// range, ordered from left to right. start must be smaller than end, and end
+// must not exceed the number of segments in 'b'.
+func MerkleRangeProof(b []byte, start, end uint64) []Hash {
+	var proof []Hash
+	var buildProof func(i, j uint64)
+	buildProof = func(i, j uint64) {
+		if j <= start || i >= end {
+			// The subtree doesn't contain any segment of the range.
+			from, to := i*SegmentSize, j*SegmentSize
+			if to > uint64(len(b)) {
+				to = uint64(len(b))
+			}
+			proof = append(proof, MerkleRoot(b[from:to]))
+			return
+		} else if start <= i && j <= end {
+			// The subtree is contained in the range.
+			return
+		}
+		mid := i + leftSubtreeSize(j-i)
+		buildProof(i, mid)
+		buildProof(mid, j)
+	}
+	buildProof(0, CalculateLeaves(uint64(len(b))))
+	return proof
+}
+
+// VerifyRangeProof verifies that the segments in the range [start, end) of a
+// Merkle tree with numSegments leaves are a part of the Merkle root, given the
+// proof created by MerkleRangeProof. Only the last segment of the tree may be
+// smaller than SegmentSize.
+func VerifyRangeProof(segments []byte, proof []Hash, start, end, numSegments uint64, root Hash) bool {
+	if start >= end || end > numSegments {
+		return false
+	}
+	// Check that the data fills the range.
+	size := uint64(len(segments))
+	if size > (end-start)*SegmentSize || size <= (end-start-1)*SegmentSize {
+		return false
+	} else if size < (end-start)*SegmentSize && end != numSegments {
+		return false
+	}
+
+	valid := true
+	var subtreeRoot func(i, j uint64) Hash
+	subtreeRoot = func(i, j uint64) Hash {
+		if j <= start || i >= end {
+			// The root of the subtree is the next hash of the proof.
+			if len(proof) == 0 {
+				valid = false
+				return Hash{}
+			}
+			h := proof[0]
+			proof = proof[1:]
+			return h
+		} else if start <= i && j <= end {
+			// The root of the subtree can be computed from the segments.
+			from, to := (i-start)*SegmentSize, (j-start)*SegmentSize
+			if to > size {
+				to = size
+			}
+			return MerkleRoot(segments[from:to])
+		}
+		mid := i + leftSubtreeSize(j-i)
+		left, right := subtreeRoot(i, mid), subtreeRoot(mid, j)
+		return HashBytes(append(append([]byte{1}, left[:]...), right[:]...))
+	}
+	return subtreeRoot(0, numSegments) == root && valid && len(proof) == 0
+}
@@ -153,3 +153,48 @@ func TestOddDataSize(t *testing.T) {
 		}
 	}
 }
+
+// TestRangeProof builds range proofs for every range of a tree and checks
+// that they verify correctly.
+func TestRangeProof(t *testing.T) {
+	for _, numSegments := range []uint64{1, 2, 7, 8, 13} {
+		data := fastrand.Bytes(int(numSegments*SegmentSize) - fastrand.Intn(SegmentSize))
+		root := MerkleRoot(data)
+		for start := uint64(0); start < numSegments; start++ {
+			for end := start + 1; end <= numSegments; end++ {
+				segments := data[start*SegmentSize:]
+				if end < numSegments {
+					segments = data[start*SegmentSize : end*SegmentSize]
+				}
+				proof := MerkleRangeProof(data, start, end)
+				if !VerifyRangeProof(segments, proof, start, end, numSegments, root) {
+					t.Fatalf("proof of range [%v, %v) of %v segments didn't verify", start, end, numSegments)
+				}
+			}
+		}
+	}
+
+	// Try some incorrect proofs.
+	numSegments := uint64(13)
+	data := fastrand.Bytes(int(numSegments * SegmentSize))
+	root := MerkleRoot(data)
+	proof := MerkleRangeProof(data, 3, 6)
+	segments := data[3*SegmentSize : 6*SegmentSize]
+	if VerifyRangeProof(segments, proof, 4, 7, numSegments, root) {
+		t.Error("verified a proof for the wrong range")
+	}
+	if VerifyRangeProof(segments[:len(segments)-1], proof, 3, 6, numSegments, root) {
+		t.Error("verified a proof with missing data")
+	}
+	if VerifyRangeProof(segments, proof[1:], 3, 6, numSegments, root) {
+		t.Error("verified a proof with a missing hash")
+	}
+	if VerifyRangeProof(segments, append(proof, Hash{}), 3, 6, numSegments, root) {
+		t.Error("verified a proof with an extra hash")
+	}
+	badSegments := append([]byte(nil), segments...)
+	badSegments[0]++
+	if VerifyRangeProof(badSegments, proof, 3, 6, numSegments, root) {
+		t.Error("verified a proof with bad data")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
@@ -20,11 +21,16 @@ var (
 	// errRequestOutOfBounds is returned when a download request is made which
 	// asks for elements of a sector which do not exist.
 	errRequestOutOfBounds = ErrorCommunication("download request has invalid sector bounds")
+
+	// errRequestUnaligned is returned when a range download request is made
+	// which asks for a range that is not aligned to segment boundaries.
+	errRequestUnaligned = ErrorCommunication("range download request is not segment-aligned")
 )
 
 // managedDownloadIteration is responsible for managing a single iteration of
-// the download loop for RPCDownload.
-func (h *Host) managedDownloadIteration(conn net.Conn, so *storageObligation) error {
+// the download loop for RPCDownload and RPCDownloadRange. If sendProofs is
+// true, a Merkle range proof is sent for each request along with the data.
+func (h *Host) managedDownloadIteration(conn net.Conn, so *storageObligation, sendProofs bool) error {
 	// Exchange settings with the renter.
 	err := h.managedRPCSettings(conn)
 	if err != nil {
@@ -66,6 +72,7 @@ func (h *Host) managedDownloadIteration(conn net.Conn, so *storageObligation) er
 	// for the renter.
 	existingRevision := so.RevisionTransactionSet[len(so.RevisionTransactionSet)-1].FileContractRevisions[0]
 	var payload [][]byte
+	var proofs [][]crypto.Hash
 	err = func() error {
 		// Check that the length of each file is in-bounds, and that the total
 		// size being requested is acceptable.
@@ -74,6 +81,9 @@ func (h *Host) managedDownloadIteration(conn net.Conn, so *storageObligation) er
 			if request.Length > modules.SectorSize || request.Offset+request.Length > modules.SectorSize {
 				return extendErr("download iteration request failed: ", errRequestOutOfBounds)
 			}
+			if sendProofs && (request.Length == 0 || request.Offset%crypto.SegmentSize != 0 || request.Length%crypto.SegmentSize != 0) {
+				return extendErr("download iteration request failed: ", errRequestUnaligned)
+			}
 			totalSize += request.Length
 		}
 		if totalSize > settings.MaxDownloadBatchSize {
@@ -95,6 +105,11 @@ func (h *Host) managedDownloadIteration(conn net.Conn, so *storageObligation) er
 				return extendErr("failed to load sector: ", ErrorInternal(err.Error()))
 			}
 			payload = append(payload, sectorData[request.Offset:request.Offset+request.Length])
+			if sendProofs {
+				start := request.Offset / crypto.SegmentSize
+				end := (request.Offset + request.Length) / crypto.SegmentSize
+				proofs = append(proofs, crypto.MerkleRangeProof(sectorData, start, end))
+			}
 		}
 		return nil
 	}()
@@ -145,6 +160,12 @@ func (h *Host) managedDownloadIteration(conn net.Conn, so *storageObligation) er
 	if err != nil {
 		return extendErr("failed to write payload: ", ErrorConnection(err.Error()))
 	}
+	if sendProofs {
+		err = encoding.WriteObject(conn, proofs)
+		if err != nil {
+			return extendErr("failed to write proofs: ", ErrorConnection(err.Error()))
+		}
+	}
 	return nil
 }
 
@@ -245,8 +266,9 @@ func verifyPaymentRevision(existingRevision, paymentRevision types.FileContractR
 }
 
 // managedRPCDownload is responsible for handling an RPC request from the
-// renter to download data.
-func (h *Host) managedRPCDownload(conn net.Conn) error {
+// renter to download data. If sendProofs is true, the renter receives a Merkle
+// range proof for each requested range.
+func (h *Host) managedRPCDownload(conn net.Conn, sendProofs bool) error {
 	// Get the start time to limit the length of the whole connection.
 	startTime := time.Now()
 	// Perform the file contract revision exchange, giving the renter the most
@@ -265,7 +287,7 @@ func (h *Host) managedRPCDownload(conn net.Conn) error {
 	// Perform a loop that will allow downloads to happen until the maximum
 	// time for a single connection has been reached.
 	for time.Now().Before(startTime.Add(iteratedConnectionTime)) {
-		err := h.managedDownloadIteration(conn, &so)
+		err := h.managedDownloadIteration(conn, &so, sendProofs)
 		if err == modules.ErrStopResponse {
 			// The renter has indicated that it has finished downloading the
 			// data, therefore there is no error. Return nil.
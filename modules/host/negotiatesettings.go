@@ -92,9 +92,10 @@ func (h *Host) managedRPCSettings(conn net.Conn) error {
 
 	// Write the settings to the renter. If the write fails, return a
 	// connection error.
-	err := crypto.WriteSignedObject(conn, hes, secretKey)
+	ext := modules.HostSettingsExtension{ProtocolVersion: modules.HostProtocolVersion}
+	err := modules.WriteHostSettings(conn, hes, ext, secretKey)
 	if err != nil {
-		return ErrorConnection("failed WriteSignedObject during RPCSettings: " + err.Error())
+		return ErrorConnection("failed WriteHostSettings during RPCSettings: " + err.Error())
 	}
 	return nil
 }
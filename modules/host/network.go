@@ -280,7 +280,10 @@ func (h *Host) threadedHandleConn(conn net.Conn) {
 	switch id {
 	case modules.RPCDownload:
 		atomic.AddUint64(&h.atomicDownloadCalls, 1)
-		err = extendErr("incoming RPCDownload failed: ", h.managedRPCDownload(conn))
+		err = extendErr("incoming RPCDownload failed: ", h.managedRPCDownload(conn, false))
+	case modules.RPCDownloadRange:
+		atomic.AddUint64(&h.atomicDownloadCalls, 1)
+		err = extendErr("incoming RPCDownloadRange failed: ", h.managedRPCDownload(conn, true))
 	case modules.RPCRenewContract:
 		atomic.AddUint64(&h.atomicRenewCalls, 1)
 		err = extendErr("incoming RPCRenewContract failed: ", h.managedRPCRenewContract(conn))
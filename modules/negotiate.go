@@ -69,6 +69,14 @@ const (
 	// encoded HostExternalSettings.
 	NegotiateMaxHostExternalSettingsLen = 16000
 
+	// HostProtocolVersion is the version of the renter-host protocol spoken
+	// by this host. It is versioned separately from siad and advertised in a
+	// HostSettingsExtension, so that renters can detect support for newer
+	// RPCs. Hosts that don't send an extension speak version 0.
+	//
+	// Version 1 added RPCDownloadRange.
+	HostProtocolVersion = 1
+
 	// NegotiateMaxSiaPubkeySize defines the maximum size that a SiaPubkey is
 	// allowed to be when being sent over the wire during negotiation.
 	NegotiateMaxSiaPubkeySize = 1e3
@@ -148,6 +156,11 @@ var (
 	// RPCDownload is the specifier for downloading a file from a host.
 	RPCDownload = types.Specifier{'D', 'o', 'w', 'n', 'l', 'o', 'a', 'd', 2}
 
+	// RPCDownloadRange is the specifier for downloading segment-aligned
+	// ranges of sectors from a host. It works like RPCDownload, but the host
+	// sends a Merkle range proof for each range along with the data.
+	RPCDownloadRange = types.Specifier{'D', 'o', 'w', 'n', 'l', 'o', 'a', 'd', 'R', 'a', 'n', 'g', 'e'}
+
 	// RPCFormContract is the specifier for forming a contract with a host.
 	RPCFormContract = types.Specifier{'F', 'o', 'r', 'm', 'C', 'o', 'n', 't', 'r', 'a', 'c', 't', 2}
 
@@ -256,6 +269,13 @@ type (
 		Version        string `json:"version"`
 	}
 
+	// HostSettingsExtension is appended to the signed HostExternalSettings by
+	// hosts that support features beyond the original protocol. Renters that
+	// don't know about the extension ignore the trailing bytes.
+	HostSettingsExtension struct {
+		ProtocolVersion uint64 `json:"protocolversion"`
+	}
+
 	// A RevisionAction is a description of an edit to be performed on a file
 	// contract. Three types are allowed, 'ActionDelete', 'ActionInsert', and
 	// 'ActionModify'. ActionDelete just takes a sector index, indicating which
@@ -323,6 +343,48 @@ func WriteNegotiationStop(w io.Writer) error {
 	return encoding.WriteObject(w, StopResponse)
 }
 
+// ReadHostSettings reads a signed HostExternalSettings object from r and
+// verifies its signature. If the host appended a HostSettingsExtension it is
+// returned as well, otherwise the extension is left empty.
+func ReadHostSettings(r io.Reader, pk crypto.PublicKey, maxLen uint64) (HostExternalSettings, HostSettingsExtension, error) {
+	var hes HostExternalSettings
+	var ext HostSettingsExtension
+
+	// read and verify the signed object
+	var sig crypto.Signature
+	if err := encoding.NewDecoder(r).Decode(&sig); err != nil {
+		return hes, ext, err
+	}
+	encObj, err := encoding.ReadPrefix(r, maxLen)
+	if err != nil {
+		return hes, ext, err
+	}
+	if err := crypto.VerifyHash(crypto.HashBytes(encObj), pk, sig); err != nil {
+		return hes, ext, err
+	}
+
+	// decode the settings, followed by the extension if there is one
+	buf := bytes.NewBuffer(encObj)
+	if err := encoding.NewDecoder(buf).Decode(&hes); err != nil {
+		return hes, ext, err
+	}
+	if buf.Len() > 0 {
+		if err := encoding.NewDecoder(buf).Decode(&ext); err != nil {
+			return hes, ext, err
+		}
+	}
+	return hes, ext, nil
+}
+
+// WriteHostSettings signs hes and ext and writes them to w as a single
+// object.
+func WriteHostSettings(w io.Writer, hes HostExternalSettings, ext HostSettingsExtension, sk crypto.SecretKey) error {
+	return crypto.WriteSignedObject(w, struct {
+		Settings  HostExternalSettings
+		Extension HostSettingsExtension
+	}{hes, ext}, sk)
+}
+
 // CreateAnnouncement will take a host announcement and encode it, returning
 // the exact []byte that should be added to the arbitrary data of a
 // transaction.
@@ -125,3 +125,58 @@ func TestNegotiationResponses(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestHostSettingsExtension checks that the settings extension survives a
+// round trip and that settings of older hosts and renters remain compatible.
+func TestHostSettingsExtension(t *testing.T) {
+	t.Parallel()
+
+	sk, pk := crypto.GenerateKeyPair()
+	hes := HostExternalSettings{NetAddress: "foo.com:1234", Version: "1.3.3"}
+	ext := HostSettingsExtension{ProtocolVersion: HostProtocolVersion}
+
+	// The extension should be returned by ReadHostSettings.
+	buf := new(bytes.Buffer)
+	if err := WriteHostSettings(buf, hes, ext, sk); err != nil {
+		t.Fatal(err)
+	}
+	encoded := append([]byte(nil), buf.Bytes()...)
+	readHES, readExt, err := ReadHostSettings(buf, pk, NegotiateMaxHostExternalSettingsLen)
+	if err != nil {
+		t.Fatal(err)
+	} else if readHES.NetAddress != hes.NetAddress || readHES.Version != hes.Version {
+		t.Fatal("settings don't match:", readHES)
+	} else if readExt != ext {
+		t.Fatal("extension doesn't match:", readExt)
+	}
+
+	// Renters that don't know about the extension should still be able to
+	// read the settings.
+	var oldHES HostExternalSettings
+	if err := crypto.ReadSignedObject(bytes.NewReader(encoded), &oldHES, NegotiateMaxHostExternalSettingsLen, pk); err != nil {
+		t.Fatal(err)
+	} else if oldHES.NetAddress != hes.NetAddress {
+		t.Fatal("settings don't match:", oldHES)
+	}
+
+	// Settings of hosts that don't send the extension should be read with an
+	// empty extension.
+	buf.Reset()
+	if err := crypto.WriteSignedObject(buf, hes, sk); err != nil {
+		t.Fatal(err)
+	}
+	readHES, readExt, err = ReadHostSettings(buf, pk, NegotiateMaxHostExternalSettingsLen)
+	if err != nil {
+		t.Fatal(err)
+	} else if readHES.NetAddress != hes.NetAddress {
+		t.Fatal("settings don't match:", readHES)
+	} else if readExt.ProtocolVersion != 0 {
+		t.Fatal("expected protocol version 0, got", readExt.ProtocolVersion)
+	}
+
+	// A tampered object should be rejected.
+	encoded[len(encoded)-1]++
+	if _, _, err := ReadHostSettings(bytes.NewReader(encoded), pk, NegotiateMaxHostExternalSettingsLen); err == nil {
+		t.Fatal("expected signature verification to fail")
+	}
+}
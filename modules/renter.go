@@ -56,11 +56,25 @@ type ContractUtility struct {
 	GoodForRenew  bool
 }
 
+// DirectoryInfo provides information about a directory of the renter's file
+// tree. The aggregate fields cover all of the files in the directory and its
+// subdirectories.
+type DirectoryInfo struct {
+	SiaPath       string    `json:"siapath"`
+	AggregateSize uint64    `json:"aggregatesize"` // The total size of all files.
+	LastUpdate    time.Time `json:"lastupdate"`    // The last time a file was added, removed or renamed.
+	MinRedundancy float64   `json:"minredundancy"` // The redundancy of the least redundant file, -1 if there are no files.
+	NumFiles      uint64    `json:"numfiles"`      // The number of files.
+	NumSubDirs    uint64    `json:"numsubdirs"`    // The number of immediate subdirectories.
+	StuckChunks   uint64    `json:"stuckchunks"`   // The number of chunks that repeatedly failed to be repaired.
+}
+
 // DownloadInfo provides information about a file that has been requested for
 // download.
 type DownloadInfo struct {
 	Destination     string `json:"destination"`     // The destination of the download.
 	DestinationType string `json:"destinationtype"` // Can be "file", "memory buffer", or "http stream".
+	ID              string `json:"id"`              // The identifier used to cancel or resume the download.
 	Length          uint64 `json:"length"`          // The length requested for the download.
 	Offset          uint64 `json:"offset"`          // The offset within the siafile requested for the download.
 	SiaPath         string `json:"siapath"`         // The siapath of the file used for the download.
@@ -69,6 +83,7 @@ type DownloadInfo struct {
 	EndTime              time.Time `json:"endtime"`              // The time when the download fully completed.
 	Error                string    `json:"error"`                // Will be the empty string unless there was an error.
 	Received             uint64    `json:"received"`             // Amount of data confirmed and decoded.
+	Resumable            bool      `json:"resumable"`            // Whether the download survives restarts and can be resumed after a failure.
 	StartTime            time.Time `json:"starttime"`            // The time when the download was started.
 	TotalDataTransferred uint64    `json:"totaldatatransferred"` // Total amount of data transferred, including negotiation, etc.
 }
@@ -79,6 +94,43 @@ type FileUploadParams struct {
 	Source      string
 	SiaPath     string
 	ErasureCode ErasureCoder
+
+	// Priority is the upload priority of the file. The chunks of files with
+	// a higher priority are uploaded and repaired first.
+	Priority uint64
+
+	// Dedup enables convergent chunking for the file. The chunks of the file
+	// are keyed by a hash of their plaintext, and chunks that the renter
+	// already stores are not uploaded again.
+	Dedup bool
+
+	// Compress compresses the data of the file before it is encrypted and
+	// uploaded. Files that don't get any smaller are uploaded uncompressed.
+	// Compressed files can't be deduplicated.
+	Compress bool
+
+	// Metadata is application metadata of the file, such as its content type
+	// or tags. The number and size of the entries is bounded.
+	Metadata map[string]string
+}
+
+// FileSearchQuery contains the criteria of a file search. Files match if their
+// siapath starts with Prefix, if their metadata contains all entries of
+// Metadata, and if their size and health are within the given bounds. A
+// maximum of 0 means that there is no maximum. Of the matching files, sorted
+// by siapath, Limit files starting at Offset are returned, or all files if
+// Limit is 0.
+type FileSearchQuery struct {
+	Prefix   string
+	Metadata map[string]string
+
+	MinSize   uint64
+	MaxSize   uint64
+	MinHealth float64
+	MaxHealth float64
+
+	Offset uint64
+	Limit  uint64
 }
 
 // FileInfo provides information about a file.
@@ -92,6 +144,124 @@ type FileInfo struct {
 	UploadedBytes  uint64            `json:"uploadedbytes"`
 	UploadProgress float64           `json:"uploadprogress"`
 	Expiration     types.BlockHeight `json:"expiration"`
+
+	// Health is the health of the least healthy chunk of the file. The health
+	// of a chunk is the number of unique pieces it has on online hosts that
+	// are good for renewal, divided by the number of pieces needed to recover
+	// it. A file with a health below 1 can't be recovered. StuckChunks is the
+	// number of chunks that repeatedly failed to be repaired.
+	Health      float64 `json:"health"`
+	StuckChunks uint64  `json:"stuckchunks"`
+
+	// Priority is the upload priority of the file. UploadPaused is true if
+	// the upload and repair of the file is paused.
+	Priority     uint64 `json:"priority"`
+	UploadPaused bool   `json:"uploadpaused"`
+
+	// Deduplicated is true if the chunks of the file are shared with
+	// identical chunks of other files.
+	Deduplicated bool `json:"deduplicated"`
+
+	// DataPieces and ParityPieces are the erasure code of the file. Every
+	// chunk of the file is encoded into DataPieces+ParityPieces pieces, any
+	// DataPieces of which are enough to recover the chunk.
+	DataPieces   uint64 `json:"datapieces"`
+	ParityPieces uint64 `json:"paritypieces"`
+
+	// Compression is the codec that the data of the file is compressed with
+	// before it is encrypted, it is empty if the file is not compressed.
+	// CompressedSize is the size of the data that is stored on the hosts,
+	// which is the same as Filesize for files that are not compressed.
+	Compression    string `json:"compression"`
+	CompressedSize uint64 `json:"compressedsize"`
+
+	// Checksum is the BLAKE2b-256 hash of the file's data, which is recorded
+	// when the file is uploaded. It is zero for files that were uploaded
+	// before checksums were recorded.
+	Checksum crypto.Hash `json:"checksum"`
+
+	// ModTime is the modification time of the local file when it was
+	// uploaded. It is zero for files that were uploaded from a stream or
+	// before modification times were recorded.
+	ModTime time.Time `json:"modtime"`
+
+	// Metadata is the application metadata of the file.
+	Metadata map[string]string `json:"metadata"`
+}
+
+// RedundancyChangeInfo provides information about a change of the redundancy
+// of a file.
+type RedundancyChangeInfo struct {
+	SiaPath      string    `json:"siapath"`
+	DataPieces   uint64    `json:"datapieces"`
+	ParityPieces uint64    `json:"paritypieces"`
+	StartTime    time.Time `json:"starttime"`
+
+	// Completed is set once the change ended, and Error contains the error
+	// it failed with, if any.
+	Completed bool      `json:"completed"`
+	EndTime   time.Time `json:"endtime"`
+	Error     string    `json:"error"`
+}
+
+// TrashedFileInfo provides information about a file in the renter's trash.
+type TrashedFileInfo struct {
+	SiaPath  string `json:"siapath"`
+	Filesize uint64 `json:"filesize"`
+
+	// DeleteTime is when the file was deleted and PurgeTime is when it will
+	// be purged from the trash.
+	DeleteTime time.Time `json:"deletetime"`
+	PurgeTime  time.Time `json:"purgetime"`
+}
+
+// The directions in which a synced folder can be synced. Changes to the local
+// folder are uploaded, changes below the siapath prefix are downloaded.
+const (
+	SyncDirectionBoth     = "both"
+	SyncDirectionDownload = "download"
+	SyncDirectionUpload   = "upload"
+)
+
+// The delete policies of a synced folder. Deletions on one side of the folder
+// are either propagated to the other side or ignored.
+const (
+	SyncDeleteKeep      = "keep"
+	SyncDeletePropagate = "propagate"
+)
+
+// SyncFolderParams configures a local folder that the renter keeps in sync
+// with a siapath prefix.
+type SyncFolderParams struct {
+	LocalPath    string `json:"localpath"`
+	SiaPath      string `json:"siapath"`
+	Direction    string `json:"direction"`
+	DeletePolicy string `json:"deletepolicy"`
+}
+
+// SyncFolderInfo provides information about a synced folder.
+type SyncFolderInfo struct {
+	SyncFolderParams
+
+	// Watching is true if changes to the local folder are detected as they
+	// happen. Otherwise they are only found by the periodic rescans of the
+	// folder. Files is the number of files that are in sync.
+	Watching bool   `json:"watching"`
+	Files    uint64 `json:"files"`
+
+	// LastSync is when the last scan of the folder completed, Error is the
+	// first error that occurred during that scan. Conflicts are the files
+	// that changed on both sides and are not synced until they are resolved.
+	LastSync  time.Time      `json:"lastsync"`
+	Error     string         `json:"error"`
+	Conflicts []SyncConflict `json:"conflicts"`
+}
+
+// SyncConflict is a file of a synced folder that changed both locally and
+// remotely.
+type SyncConflict struct {
+	Path   string `json:"path"` // Relative to the local folder and the siapath prefix.
+	Reason string `json:"reason"`
 }
 
 // A HostDBEntry represents one host entry in the Renter's host DB. It
@@ -99,6 +269,10 @@ type FileInfo struct {
 type HostDBEntry struct {
 	HostExternalSettings
 
+	// ProtocolVersion is the version of the renter-host protocol that the
+	// host advertised along with its settings.
+	ProtocolVersion uint64 `json:"protocolversion"`
+
 	// FirstSeen is the last block height at which this host was announced.
 	FirstSeen types.BlockHeight `json:"firstseen"`
 
@@ -148,6 +322,70 @@ type HostScoreBreakdown struct {
 	VersionAdjustment          float64 `json:"versionadjustment"`
 }
 
+// RepairChunkInfo provides information about a chunk that is queued for
+// repair.
+type RepairChunkInfo struct {
+	SiaPath         string  `json:"siapath"`         // The siapath of the file of the chunk.
+	ChunkIndex      uint64  `json:"chunkindex"`      // The index of the chunk within the file.
+	Health          float64 `json:"health"`          // The health of the chunk, see FileInfo.
+	PiecesCompleted uint64  `json:"piecescompleted"` // The number of pieces that have been uploaded.
+	PiecesNeeded    uint64  `json:"piecesneeded"`    // The number of pieces of a fully redundant chunk.
+	FailedAttempts  uint64  `json:"failedattempts"`  // The number of consecutive failed repairs of the chunk.
+	Stuck           bool    `json:"stuck"`           // Whether the chunk is retried on the schedule of stuck chunks.
+}
+
+// ChunkCacheInfo provides information about the renter's chunk cache on disk.
+type ChunkCacheInfo struct {
+	MaxSize uint64 `json:"maxsize"` // The maximum size of the cache in bytes, 0 if the cache is disabled.
+	Size    uint64 `json:"size"`    // The size of the cached chunks in bytes.
+	Chunks  uint64 `json:"chunks"`  // The number of cached chunks.
+	Hits    uint64 `json:"hits"`    // The number of chunks read from the cache since the renter started.
+	Misses  uint64 `json:"misses"`  // The number of chunks that weren't cached since the renter started.
+}
+
+// The stages of the recovery of a backup.
+const (
+	RecoveryStageScanning    = "scanning"    // Scanning the blockchain for the most recent backup.
+	RecoveryStageDownloading = "downloading" // Downloading the backup, forming contracts with its hosts if needed.
+	RecoveryStageRestoring   = "restoring"   // Restoring the contracts and files of the backup.
+	RecoveryStageDone        = "done"        // The backup was recovered.
+)
+
+// BackupRecoveryStatus provides information about the recovery of a backup.
+type BackupRecoveryStatus struct {
+	// Active is true while the recovery is running. Stage is the stage the
+	// recovery is in, or was in when it failed.
+	Active bool   `json:"active"`
+	Stage  string `json:"stage"`
+
+	// ScannedHeight is the height up to which the blockchain was scanned for
+	// backups, Height is the height of the blockchain when the recovery
+	// started. BackupHeight is the height of the block that announced the
+	// most recent backup.
+	ScannedHeight types.BlockHeight `json:"scannedheight"`
+	Height        types.BlockHeight `json:"height"`
+	BackupHeight  types.BlockHeight `json:"backupheight"`
+
+	// FilesRestored is the number of files that were restored from the
+	// backup. Error is the error the recovery failed with.
+	FilesRestored uint64 `json:"filesrestored"`
+	Error         string `json:"error"`
+}
+
+// WorkerInfo provides the download statistics of the worker of a contract.
+// The statistics are rolling averages over the recent downloads from the host.
+type WorkerInfo struct {
+	ContractID    types.FileContractID `json:"contractid"`
+	HostPublicKey types.SiaPublicKey   `json:"hostpublickey"`
+	NetAddress    NetAddress           `json:"netaddress"`
+
+	Downloads               uint64        `json:"downloads"`               // The number of pieces downloaded since the renter started.
+	DownloadFailures        uint64        `json:"downloadfailures"`        // The number of failed piece downloads since the renter started.
+	DownloadFailureRate     float64       `json:"downloadfailurerate"`     // The rate of recent piece downloads that failed.
+	DownloadThroughput      uint64        `json:"downloadthroughput"`      // The throughput of recent piece downloads in bytes per second.
+	DownloadTimeToFirstByte time.Duration `json:"downloadtimetofirstbyte"` // The time until the host started sending the data of recent piece downloads.
+}
+
 // RenterPriceEstimation contains a bunch of files estimating the costs of
 // various operations on the network.
 type RenterPriceEstimation struct {
@@ -165,11 +403,69 @@ type RenterPriceEstimation struct {
 	UploadTerabyte types.Currency `json:"uploadterabyte"`
 }
 
+// UploadQuoteParams contains the information used by the Renter to quote the
+// cost of an upload. The upload consists of the file or directory at Source,
+// or of Filesize bytes if Source is empty.
+type UploadQuoteParams struct {
+	Source      string
+	Filesize    uint64
+	ErasureCode ErasureCoder
+
+	// Duration is the number of blocks that the data is stored for. If it is
+	// 0, the data is stored for the period of the allowance.
+	Duration types.BlockHeight
+}
+
+// RenterUploadQuote is the cost of an upload, priced by the hosts that the
+// renter has contracts with.
+type RenterUploadQuote struct {
+	// Filesize is the size of the uploaded data. UploadSize is the amount of
+	// data that is uploaded to the hosts, including redundancy and padding.
+	Filesize   uint64            `json:"filesize"`
+	UploadSize uint64            `json:"uploadsize"`
+	Duration   types.BlockHeight `json:"duration"`
+
+	// Hosts is the number of hosts whose prices were used for the quote.
+	Hosts uint64 `json:"hosts"`
+
+	// The cost of storing the data for the duration, of uploading it, of
+	// renewing the contracts that store it and of the siafund fee on the
+	// funds that are put into contracts. TotalCost is the sum of these costs.
+	StorageCost  types.Currency `json:"storagecost"`
+	UploadCost   types.Currency `json:"uploadcost"`
+	ContractFees types.Currency `json:"contractfees"`
+	SiafundFees  types.Currency `json:"siafundfees"`
+	TotalCost    types.Currency `json:"totalcost"`
+
+	// RemainingAllowance is the part of the allowance that hasn't been spent
+	// in the current period. WithinAllowance is true if it covers TotalCost.
+	RemainingAllowance types.Currency `json:"remainingallowance"`
+	WithinAllowance    bool           `json:"withinallowance"`
+}
+
 // RenterSettings control the behavior of the Renter.
 type RenterSettings struct {
 	Allowance        Allowance `json:"allowance"`
 	MaxUploadSpeed   int64     `json:"maxuploadspeed"`
 	MaxDownloadSpeed int64     `json:"maxdownloadspeed"`
+
+	// DownloadOverdrive is the number of extra pieces that are requested for
+	// a chunk whose download takes longer than expected. The first pieces to
+	// arrive are used, 0 disables overdrive.
+	DownloadOverdrive uint64 `json:"downloadoverdrive"`
+
+	// TrashRetention is how long deleted files are kept in the trash before
+	// they are purged. 0 disables the trash, deleted files are purged right
+	// away.
+	TrashRetention time.Duration `json:"trashretention"`
+}
+
+// Streamer is an io.ReadSeeker that streams a file from the Sia network.
+// Sequential reads prefetch the data ahead of them, closing the Streamer
+// stops the prefetching.
+type Streamer interface {
+	io.ReadSeeker
+	io.Closer
 }
 
 // HostDBScans represents a sortable slice of scans.
@@ -280,6 +576,10 @@ type ContractorSpending struct {
 	UploadSpending types.Currency `json:"uploadspending"`
 	// Unspent is locked-away, unspent money.
 	Unspent types.Currency `json:"unspent"`
+	// OverdriveSpending is the part of DownloadSpending that was spent on
+	// pieces that overdrive downloads fetched in excess of the pieces that
+	// were needed.
+	OverdriveSpending types.Currency `json:"overdrivespending"`
 	// ContractSpendingDeprecated was renamed to TotalAllocated and always has the
 	// same value as TotalAllocated.
 	ContractSpendingDeprecated types.Currency `json:"contractspending"`
@@ -292,6 +592,10 @@ type Renter interface {
 	// sorted by preference.
 	ActiveHosts() []HostDBEntry
 
+	// AddSyncFolder starts keeping a local folder in sync with a siapath
+	// prefix.
+	AddSyncFolder(params SyncFolderParams) error
+
 	// AllHosts returns the full list of hosts known to the renter.
 	AllHosts() []HostDBEntry
 
@@ -312,9 +616,39 @@ type Renter interface {
 	// billing period.
 	PeriodSpending() ContractorSpending
 
-	// DeleteFile deletes a file entry from the renter.
+	// CancelDownload cancels a download. A cancelled download is not resumed
+	// after a restart.
+	CancelDownload(id string) error
+
+	// ChangeRedundancy starts re-encoding an uploaded file with a different
+	// erasure code in the background. The data of the file is downloaded from
+	// the hosts, so no local copy is needed.
+	ChangeRedundancy(siaPath string, ec ErasureCoder) error
+
+	// ConcatFiles concatenates uploaded files into a new file without
+	// uploading their data again. The concatenated files are removed.
+	ConcatFiles(siaPaths []string, newSiaPath string) error
+
+	// CreateBackup backs up the metadata of the renter to its hosts. The
+	// backup can be recovered using the wallet seed.
+	CreateBackup() error
+
+	// ChunkCache returns information about the chunk cache on disk.
+	ChunkCache() ChunkCacheInfo
+
+	// CreateDir creates a new, empty directory.
+	CreateDir(siaPath string) error
+
+	// DeleteDir deletes a directory and everything it contains.
+	DeleteDir(siaPath string) error
+
+	// DeleteFile moves a file entry to the renter's trash.
 	DeleteFile(path string) error
 
+	// DirList returns the information of a directory and its immediate
+	// subdirectories, followed by the files directly inside of it.
+	DirList(siaPath string) ([]DirectoryInfo, []FileInfo, error)
+
 	// Download performs a download according to the parameters passed, including
 	// downloads of `offset` and `length` type.
 	Download(params RenterDownloadParameters) error
@@ -347,9 +681,59 @@ type Renter interface {
 	// storage and data operations.
 	PriceEstimation() RenterPriceEstimation
 
+	// PurgeChunkCache removes all chunks from the chunk cache on disk.
+	PurgeChunkCache() error
+
+	// RecoverBackup starts restoring the contracts and files of the most
+	// recent backup that was created with the wallet seed. The recovery runs
+	// in the background, RecoveryStatus reports its progress.
+	RecoverBackup() error
+
+	// RecoveryStatus returns the status of the most recent recovery of a
+	// backup.
+	RecoveryStatus() BackupRecoveryStatus
+
+	// RedundancyChanges returns the changes of redundancy that are in progress
+	// and the ones that ended since the renter started.
+	RedundancyChanges() []RedundancyChangeInfo
+
+	// RemoveSyncFolder stops syncing a local folder. The files on either side
+	// are kept.
+	RemoveSyncFolder(localPath string) error
+
+	// RenameDir changes the path of a directory and everything it contains.
+	RenameDir(siaPath, newSiaPath string) error
+
 	// RenameFile changes the path of a file.
 	RenameFile(path, newPath string) error
 
+	// ReplaceFile changes the path of a file, replacing the file that is
+	// already at the new path. The replaced file is moved to the trash.
+	ReplaceFile(path, newPath string) error
+
+	// RepairQueue returns the chunks that are currently queued for repair,
+	// least healthy first.
+	RepairQueue() []RepairChunkInfo
+
+	// PauseUploads pauses the upload and repair of a file. If the path is
+	// empty, the whole upload pipeline is paused. Chunks that are already
+	// being uploaded are finished.
+	PauseUploads(path string) error
+
+	// ResumeDownload resumes a failed download. The chunks that were already
+	// written to the destination are not downloaded again.
+	ResumeDownload(id string) error
+
+	// RestoreFile moves a file from the trash back to its siapath.
+	RestoreFile(siaPath string) error
+
+	// ResumeUploads resumes the upload and repair of a file. If the path is
+	// empty, the whole upload pipeline is resumed.
+	ResumeUploads(path string) error
+
+	// EmptyTrash purges all files from the trash.
+	EmptyTrash() error
+
 	// EstimateHostScore will return the score for a host with the provided
 	// settings, assuming perfect age and uptime adjustments
 	EstimateHostScore(entry HostDBEntry) HostScoreBreakdown
@@ -358,25 +742,57 @@ type Renter interface {
 	// hostdb's weighting algorithm.
 	ScoreBreakdown(entry HostDBEntry) HostScoreBreakdown
 
+	// SearchFiles returns the files that match the query and the total number
+	// of matching files, regardless of the pagination of the query.
+	SearchFiles(query FileSearchQuery) (files []FileInfo, total uint64)
+
 	// Settings returns the Renter's current settings.
 	Settings() RenterSettings
 
+	// SetChunkCacheSize sets the maximum size of the chunk cache on disk in
+	// bytes. A size of 0 disables the cache.
+	SetChunkCacheSize(size uint64) error
+
+	// SetFileMetadata replaces the application metadata of a file.
+	SetFileMetadata(siaPath string, metadata map[string]string) error
+
 	// SetSettings sets the Renter's settings.
 	SetSettings(RenterSettings) error
 
-	// ShareFiles creates a '.sia' file that can be shared with others.
-	ShareFiles(paths []string, shareDest string) error
+	// ShareFiles creates a '.sia' file that can be shared with others. If
+	// stripContractIDs is true, the file identifies the hosts of the pieces
+	// only by their public keys.
+	ShareFiles(paths []string, shareDest string, stripContractIDs bool) error
 
 	// ShareFilesAscii creates an ASCII-encoded '.sia' file.
-	ShareFilesASCII(paths []string) (asciiSia string, err error)
+	ShareFilesASCII(paths []string, stripContractIDs bool) (asciiSia string, err error)
+
+	// Streamer creates a Streamer that can be used to stream downloads from
+	// the Sia network and also returns the fileName of the streamed resource.
+	Streamer(siaPath string) (string, Streamer, error)
 
-	// Streamer creates a io.ReadSeeker that can be used to stream downloads
-	// from the Sia network and also returns the fileName of the streamed
-	// resource.
-	Streamer(siaPath string) (string, io.ReadSeeker, error)
+	// SyncFolders returns the synced folders.
+	SyncFolders() []SyncFolderInfo
+
+	// Trash returns the files in the trash.
+	Trash() []TrashedFileInfo
 
 	// Upload uploads a file using the input parameters.
 	Upload(FileUploadParams) error
+
+	// UploadQuote quotes the cost of an upload with the current prices of the
+	// renter's hosts, without uploading any data.
+	UploadQuote(UploadQuoteParams) (RenterUploadQuote, error)
+
+	// UploadStreamFromReader reads a file from reader and uploads it using
+	// the input parameters. The Source of the parameters is ignored.
+	UploadStreamFromReader(up FileUploadParams, reader io.Reader) error
+
+	// UploadsPaused returns whether the whole upload pipeline is paused.
+	UploadsPaused() bool
+
+	// Workers returns the download statistics of the renter's workers.
+	Workers() []WorkerInfo
 }
 
 // RenterDownloadParameters defines the parameters passed to the Renter's
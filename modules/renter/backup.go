@@ -0,0 +1,807 @@
+package renter
+
+// backup.go backs up the metadata of the renter to its hosts, so that the
+// renter can be recovered from the wallet seed alone. Periodically, the renter
+// creates a snapshot of its files, directories and contracts, encrypts it with
+// a key derived from the wallet seed and uploads it to a few of its hosts. The
+// location of the snapshot is announced in an encrypted beacon on the
+// blockchain. A renter restored from the same seed scans the blockchain for
+// the most recent beacon, downloads the snapshot from one of the hosts listed
+// in the beacon and restores the contracts and files of the snapshot.
+//
+// Hosts serve every sector they store to every renter that has a contract with
+// them, so the restored renter needs a contract with at least one of the
+// backup hosts to download the snapshot. If it has none, it forms a small
+// contract with them. Once the snapshot is restored, the contracts of the old
+// renter are available again. Scanning the blockchain takes a while, so the
+// recovery runs in the background and reports its progress.
+//
+// Each backup stores a full snapshot on its hosts. The renter keeps the
+// sectors of the two most recent snapshots, so that the previous one can still
+// be recovered if the beacon of the most recent one doesn't make it into the
+// blockchain, and deletes the sectors of older snapshots from their contracts.
+// Sectors of files that the kept snapshots still reference are kept as well,
+// see redundancy.go.
+// Beacons are published with the minimum fee, since they don't need to be
+// confirmed quickly.
+//
+// A host only accepts revisions of the most recent revision of a contract, so
+// the restored renter needs the headers and Merkle roots of the contracts as
+// of their last revision. Uploading the snapshot revises the contracts with
+// the backup hosts, so the beacon contains the headers of these contracts
+// after the upload, which replace the outdated headers when the snapshot is
+// restored. The sectors of old snapshots and the pending sectors of replaced
+// files are deleted right before the next snapshot is created, so that no
+// backup revises a contract after its beacon was published. Contracts that are
+// revised after the most recent backup, e.g. by uploads, can't be revised by
+// the restored renter. The restore marks them as lost, which makes them
+// neither good for upload nor for renewal, and their data is repaired onto
+// other hosts.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/modules/renter/proto"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+var (
+	// backupInterval is the interval at which the renter backs up its
+	// metadata if it changed.
+	backupInterval = build.Select(build.Var{
+		Dev:      time.Hour,
+		Standard: 24 * time.Hour,
+		Testing:  time.Hour,
+	}).(time.Duration)
+
+	// numBackupHosts is the number of hosts that a snapshot is uploaded to.
+	numBackupHosts = build.Select(build.Var{
+		Dev:      2,
+		Standard: 3,
+		Testing:  2,
+	}).(int)
+
+	// backupRecoveryFunding is the funding of the contracts that are formed
+	// with the hosts of a backup that the renter has no contract with.
+	backupRecoveryFunding = build.Select(build.Var{
+		Dev:      types.SiacoinPrecision.Mul64(50),
+		Standard: types.SiacoinPrecision.Mul64(50),
+		Testing:  types.SiacoinPrecision.Mul64(50),
+	}).(types.Currency)
+
+	// backupRecoveryDuration is the number of blocks that the contracts
+	// formed to recover a backup last.
+	backupRecoveryDuration = build.Select(build.Var{
+		Dev:      types.BlockHeight(100),
+		Standard: types.BlockHeight(1008), // 1 week
+		Testing:  types.BlockHeight(100),
+	}).(types.BlockHeight)
+
+	// backupKeySpecifier is used to derive the key of the backups from the
+	// wallet seed.
+	backupKeySpecifier = types.Specifier{'r', 'e', 'n', 't', 'e', 'r', ' ', 'b', 'a', 'c', 'k', 'u', 'p'}
+
+	// backupSpecifier follows the NonSia prefix of the arbitrary data that
+	// contains a backup beacon.
+	backupSpecifier = types.Specifier{'R', 'e', 'n', 't', 'e', 'r', 'B', 'a', 'c', 'k', 'u', 'p'}
+
+	// errNoBackup is returned if no backup of the renter was found on the
+	// blockchain.
+	errNoBackup = errors.New("no backup found on the blockchain")
+
+	// errNoBackupHosts is returned if the renter has no contracts that
+	// snapshots can be uploaded to.
+	errNoBackupHosts = errors.New("no contracts available to upload the backup to")
+
+	// errRecoveryInProgress is returned if a backup is recovered while
+	// another recovery is still running.
+	errRecoveryInProgress = errors.New("a backup is already being recovered")
+)
+
+const (
+	// backupBeaconTxnSize is the estimated size of a transaction that
+	// contains a backup beacon, excluding the size of the beacon.
+	backupBeaconTxnSize = 500
+
+	// numKeptSnapshots is the number of most recent snapshots whose sectors
+	// are kept on the backup hosts.
+	numKeptSnapshots = 2
+)
+
+type (
+	// renterSnapshot contains the metadata of the renter that is backed up.
+	renterSnapshot struct {
+		Contracts []proto.ContractBackup `json:"contracts"`
+		Dirs      []string               `json:"dirs"`
+		Files     []sharedFile           `json:"files"`
+		Tracking  map[string]trackedFile `json:"tracking"`
+
+		// DedupSecret is the secret that the keys of the renter's blocks are
+		// derived from, so that the restored renter deduplicates new chunks
+		// against the restored blocks.
+		DedupSecret crypto.Hash `json:"dedupsecret"`
+	}
+
+	// backupBeacon announces the location of a snapshot. Roots are the
+	// Merkle roots of the sectors that contain the encrypted snapshot, Size
+	// is the size of the encrypted snapshot. Contracts contains the headers
+	// of the contracts that the snapshot was uploaded to, without their
+	// Merkle roots.
+	backupBeacon struct {
+		Hosts     []types.SiaPublicKey
+		Roots     []crypto.Hash
+		Size      uint64
+		Contracts []proto.ContractBackup
+	}
+
+	// snapshotUpload contains the roots of the sectors of a snapshot that
+	// were uploaded to a contract.
+	snapshotUpload struct {
+		Contract types.FileContractID `json:"contract"`
+		Roots    []crypto.Hash        `json:"roots"`
+	}
+
+	// backupScanner scans the blockchain for the backup beacons that were
+	// encrypted with a specific key. If progress is set, it is called with
+	// the height of the last scanned block.
+	backupScanner struct {
+		key      crypto.TwofishKey
+		beacons  []scannedBeacon
+		blocks   uint64
+		progress func(types.BlockHeight)
+	}
+
+	// scannedBeacon is a beacon that was found on the blockchain along with
+	// the ID and height of the block that contains it.
+	scannedBeacon struct {
+		beacon  backupBeacon
+		blockID types.BlockID
+		height  types.BlockHeight
+	}
+)
+
+// backupKey derives the key of the renter's backups from the wallet seed.
+func backupKey(seed modules.Seed) crypto.TwofishKey {
+	return crypto.TwofishKey(crypto.HashAll(seed, backupKeySpecifier))
+}
+
+// encryptSnapshot compresses and encrypts the JSON encoding of a snapshot.
+func encryptSnapshot(plaintext []byte, key crypto.TwofishKey) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zip, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if _, err := zip.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := zip.Close(); err != nil {
+		return nil, err
+	}
+	return key.EncryptBytes(buf.Bytes()), nil
+}
+
+// decryptSnapshot decrypts and decodes a snapshot.
+func decryptSnapshot(data []byte, key crypto.TwofishKey) (renterSnapshot, error) {
+	plaintext, err := key.DecryptBytes(data)
+	if err != nil {
+		return renterSnapshot{}, err
+	}
+	unzip, err := gzip.NewReader(bytes.NewReader(plaintext))
+	if err != nil {
+		return renterSnapshot{}, err
+	}
+	var s renterSnapshot
+	err = json.NewDecoder(unzip).Decode(&s)
+	return s, err
+}
+
+// beaconArbitraryData returns the arbitrary data that announces the beacon on
+// the blockchain.
+func beaconArbitraryData(b backupBeacon, key crypto.TwofishKey) []byte {
+	arb := append(modules.PrefixNonSia[:], backupSpecifier[:]...)
+	return append(arb, key.EncryptBytes(encoding.Marshal(b))...)
+}
+
+// decodeBeacon decodes the beacon in arb. It returns false if arb doesn't
+// contain a beacon that was encrypted with key.
+func decodeBeacon(arb []byte, key crypto.TwofishKey) (backupBeacon, bool) {
+	prefix := append(modules.PrefixNonSia[:], backupSpecifier[:]...)
+	if !bytes.HasPrefix(arb, prefix) {
+		return backupBeacon{}, false
+	}
+	plaintext, err := key.DecryptBytes(arb[len(prefix):])
+	if err != nil {
+		return backupBeacon{}, false
+	}
+	var b backupBeacon
+	if err := encoding.Unmarshal(plaintext, &b); err != nil {
+		return backupBeacon{}, false
+	}
+	return b, true
+}
+
+// ProcessConsensusChange implements modules.ConsensusSetSubscriber. It keeps
+// track of the beacons in the current blockchain.
+func (bs *backupScanner) ProcessConsensusChange(cc modules.ConsensusChange) {
+	for _, block := range cc.RevertedBlocks {
+		id := block.ID()
+		for len(bs.beacons) > 0 && bs.beacons[len(bs.beacons)-1].blockID == id {
+			bs.beacons = bs.beacons[:len(bs.beacons)-1]
+		}
+		bs.blocks--
+	}
+	for _, block := range cc.AppliedBlocks {
+		height := types.BlockHeight(bs.blocks)
+		for _, txn := range block.Transactions {
+			for _, arb := range txn.ArbitraryData {
+				if b, ok := decodeBeacon(arb, bs.key); ok {
+					bs.beacons = append(bs.beacons, scannedBeacon{b, block.ID(), height})
+				}
+			}
+		}
+		bs.blocks++
+	}
+	if bs.progress != nil && bs.blocks > 0 {
+		bs.progress(types.BlockHeight(bs.blocks - 1))
+	}
+}
+
+// managedSnapshot creates a snapshot of the renter's metadata. The contents
+// of the snapshot are sorted, so that the encoding of the snapshot only changes
+// if the metadata changes.
+func (r *Renter) managedSnapshot() (renterSnapshot, error) {
+	contracts, err := r.hostContractor.ContractBackups()
+	if err != nil {
+		return renterSnapshot{}, err
+	}
+	s := renterSnapshot{
+		Contracts: contracts,
+		Tracking:  make(map[string]trackedFile),
+	}
+
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	s.DedupSecret = r.dedupSecret
+	for siaPath := range r.dirs {
+		if siaPath != "" {
+			s.Dirs = append(s.Dirs, siaPath)
+		}
+	}
+	for _, f := range r.files {
+		sf, err := r.sharedFileFromFile(f, false)
+		if err != nil {
+			return renterSnapshot{}, err
+		}
+		s.Files = append(s.Files, sf)
+	}
+	for siaPath, tf := range r.tracking {
+		s.Tracking[siaPath] = tf
+	}
+	sort.Slice(s.Contracts, func(i, j int) bool {
+		return s.Contracts[i].ID().String() < s.Contracts[j].ID().String()
+	})
+	sort.Strings(s.Dirs)
+	sort.Slice(s.Files, func(i, j int) bool {
+		return s.Files[i].SiaPath < s.Files[j].SiaPath
+	})
+	return s, nil
+}
+
+// managedUploadSnapshot uploads an encrypted snapshot to a few of the renter's
+// hosts. It returns the beacon that announces the location of the snapshot and
+// the contracts that the snapshot was uploaded to.
+func (r *Renter) managedUploadSnapshot(data []byte) (backupBeacon, []snapshotUpload, error) {
+	// Split the snapshot into sectors.
+	b := backupBeacon{Size: uint64(len(data))}
+	var sectors [][]byte
+	for len(data) > 0 {
+		sector := make([]byte, modules.SectorSize)
+		data = data[copy(sector, data):]
+		sectors = append(sectors, sector)
+		b.Roots = append(b.Roots, crypto.MerkleRoot(sector))
+	}
+
+	// Upload the sectors to the first hosts that accept them.
+	var uploads []snapshotUpload
+	for _, contract := range r.hostContractor.Contracts() {
+		if len(b.Hosts) >= numBackupHosts {
+			break
+		}
+		if !contract.Utility.GoodForUpload || r.hostContractor.IsOffline(contract.ID) {
+			continue
+		}
+		if err := r.managedUploadSectors(contract.ID, sectors); err != nil {
+			r.log.Printf("WARN: could not upload backup to host %v: %v", contract.HostPublicKey, err)
+			continue
+		}
+		b.Hosts = append(b.Hosts, contract.HostPublicKey)
+		uploads = append(uploads, snapshotUpload{
+			Contract: contract.ID,
+			Roots:    b.Roots,
+		})
+
+		// Remember the revised header of the contract.
+		backup, exists, err := r.hostContractor.ContractBackup(contract.ID)
+		if err != nil || !exists {
+			continue
+		}
+		backup.Roots = nil
+		b.Contracts = append(b.Contracts, backup)
+	}
+	if len(b.Hosts) == 0 {
+		return backupBeacon{}, nil, errNoBackupHosts
+	}
+	return b, uploads, nil
+}
+
+// managedUploadSectors uploads sectors to the host of a contract.
+func (r *Renter) managedUploadSectors(id types.FileContractID, sectors [][]byte) error {
+	editor, err := r.hostContractor.Editor(id, r.tg.StopChan())
+	if err != nil {
+		return err
+	}
+	defer editor.Close()
+	for _, sector := range sectors {
+		if _, err := editor.Upload(sector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// managedDeleteSectors deletes sectors from the contract with the specified
+// ID.
+func (r *Renter) managedDeleteSectors(id types.FileContractID, roots []crypto.Hash) error {
+	editor, err := r.hostContractor.Editor(id, r.tg.StopChan())
+	if err != nil {
+		return err
+	}
+	defer editor.Close()
+	return editor.Delete(roots)
+}
+
+// managedDeleteSnapshots deletes the sectors of snapshots from their
+// contracts, except for the sectors that are shared with the kept snapshots.
+// It returns the uploads whose sectors couldn't be deleted, unless their
+// contract has ended.
+func (r *Renter) managedDeleteSnapshots(snapshots [][]snapshotUpload) [][]snapshotUpload {
+	// A forced backup of an unchanged snapshot uploads the same sectors
+	// again, so the sectors of the kept snapshots must not be deleted.
+	kept := make(map[types.FileContractID]map[crypto.Hash]struct{})
+	id := r.mu.RLock()
+	for _, uploads := range r.snapshotUploads {
+		for _, u := range uploads {
+			contractID := r.hostContractor.ResolveID(u.Contract)
+			if kept[contractID] == nil {
+				kept[contractID] = make(map[crypto.Hash]struct{})
+			}
+			for _, root := range u.Roots {
+				kept[contractID][root] = struct{}{}
+			}
+		}
+	}
+	r.mu.RUnlock(id)
+
+	var remaining [][]snapshotUpload
+	for _, uploads := range snapshots {
+		var failed []snapshotUpload
+		for _, u := range uploads {
+			var roots []crypto.Hash
+			for _, root := range u.Roots {
+				if _, ok := kept[r.hostContractor.ResolveID(u.Contract)][root]; !ok {
+					roots = append(roots, root)
+				}
+			}
+			if len(roots) == 0 {
+				continue
+			}
+			err := r.managedDeleteSectors(u.Contract, roots)
+			if _, exists := r.hostContractor.ContractByID(u.Contract); err != nil && exists {
+				r.log.Printf("WARN: could not delete old backup from contract %v: %v", u.Contract, err)
+				failed = append(failed, u)
+			}
+		}
+		if len(failed) > 0 {
+			remaining = append(remaining, failed)
+		}
+	}
+	return remaining
+}
+
+// managedReleaseSnapshots deletes the sectors of all but the numKeptSnapshots
+// most recent snapshots. Sectors that couldn't be deleted are retried before
+// the next backup. It returns whether any snapshots were released.
+func (r *Renter) managedReleaseSnapshots() bool {
+	id := r.mu.Lock()
+	n := len(r.snapshotUploads) - numKeptSnapshots
+	if n <= 0 {
+		r.mu.Unlock(id)
+		return false
+	}
+	old := r.snapshotUploads[:n]
+	r.snapshotUploads = r.snapshotUploads[n:]
+	r.mu.Unlock(id)
+
+	remaining := r.managedDeleteSnapshots(old)
+
+	id = r.mu.Lock()
+	r.snapshotUploads = append(remaining, r.snapshotUploads...)
+	err := r.saveSync()
+	r.mu.Unlock(id)
+	if err != nil {
+		r.log.Println("WARN: could not save the renter after deleting old backups:", err)
+	}
+	return true
+}
+
+// managedPublishBeacon announces a beacon on the blockchain.
+func (r *Renter) managedPublishBeacon(b backupBeacon, key crypto.TwofishKey) (err error) {
+	arb := beaconArbitraryData(b, key)
+	txnBuilder, err := r.wallet.StartTransaction()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			txnBuilder.Drop()
+		}
+	}()
+	// Beacons don't need to be confirmed quickly, so the minimum fee is
+	// enough.
+	fee, _ := r.tpool.FeeEstimation()
+	fee = fee.Mul64(backupBeaconTxnSize + uint64(len(arb)))
+	if err = txnBuilder.FundSiacoins(fee); err != nil {
+		return err
+	}
+	txnBuilder.AddMinerFee(fee)
+	txnBuilder.AddArbitraryData(arb)
+	txnSet, err := txnBuilder.Sign(true)
+	if err != nil {
+		return err
+	}
+	return r.tpool.AcceptTransactionSet(txnSet)
+}
+
+// managedBackup backs up the renter's metadata. If force is false, the backup
+// is skipped if the metadata didn't change since the last backup.
+func (r *Renter) managedBackup(force bool) error {
+	// Backups are serialized, so that the snapshots are released in order.
+	r.backupMu.Lock()
+	defer r.backupMu.Unlock()
+
+	seed, _, err := r.wallet.PrimarySeed()
+	if err != nil {
+		return err
+	}
+	key := backupKey(seed)
+
+	// The sectors that were pending deletion before the snapshot was created
+	// are not referenced by it.
+	pendingSectors := func() []*pendingSectorDeletion {
+		id := r.mu.RLock()
+		defer r.mu.RUnlock(id)
+		return append([]*pendingSectorDeletion(nil), r.pendingDeletions...)
+	}
+	pending := pendingSectors()
+	s, err := r.managedSnapshot()
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	id := r.mu.RLock()
+	unchanged := crypto.HashBytes(plaintext) == r.lastSnapshot
+	r.mu.RUnlock(id)
+	if unchanged && !force {
+		return nil
+	}
+
+	// Deleting sectors revises their contracts, which would outdate the
+	// headers in the most recent beacon. The sectors are therefore only
+	// deleted when a new backup is created, before its snapshot.
+	released := r.managedReleaseSnapshots()
+	if r.managedDeletePendingSectors() || released {
+		pending = pendingSectors()
+		if s, err = r.managedSnapshot(); err != nil {
+			return err
+		}
+		if plaintext, err = json.Marshal(s); err != nil {
+			return err
+		}
+	}
+	snapshotHash := crypto.HashBytes(plaintext)
+
+	data, err := encryptSnapshot(plaintext, key)
+	if err != nil {
+		return err
+	}
+	b, uploads, err := r.managedUploadSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if err := r.managedPublishBeacon(b, key); err != nil {
+		// The snapshot can't be found without its beacon.
+		r.managedDeleteSnapshots([][]snapshotUpload{uploads})
+		return err
+	}
+	id = r.mu.Lock()
+	r.lastSnapshot = snapshotHash
+	r.snapshotUploads = append(r.snapshotUploads, uploads)
+	for _, pd := range pending {
+		pd.Snapshots++
+	}
+	err = r.saveSync()
+	r.mu.Unlock(id)
+	return err
+}
+
+// threadedBackupLoop periodically backs up the renter's metadata.
+func (r *Renter) threadedBackupLoop() {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	for {
+		select {
+		case <-r.tg.StopChan():
+			return
+		case <-time.After(backupInterval):
+		}
+		// The seed is only available while the wallet is unlocked.
+		if unlocked, err := r.wallet.Unlocked(); err != nil || !unlocked {
+			continue
+		}
+		if err := r.managedBackup(false); err != nil {
+			r.log.Println("WARN: could not back up renter metadata:", err)
+		}
+	}
+}
+
+// managedDownloadSnapshot downloads the snapshot announced by a beacon from
+// the first backup host that has it. The hosts that the renter has a contract
+// with are tried first, a contract is formed with the other hosts.
+func (r *Renter) managedDownloadSnapshot(b backupBeacon) ([]byte, error) {
+	hostContracts := make(map[string]types.FileContractID)
+	for _, contract := range r.hostContractor.Contracts() {
+		hostContracts[contract.HostPublicKey.String()] = contract.ID
+	}
+	var hosts []types.SiaPublicKey
+	for _, host := range b.Hosts {
+		if _, exists := hostContracts[host.String()]; exists {
+			hosts = append(hosts, host)
+		}
+	}
+	for _, host := range b.Hosts {
+		if _, exists := hostContracts[host.String()]; !exists {
+			hosts = append(hosts, host)
+		}
+	}
+
+	err := fmt.Errorf("none of the backup hosts %v has the backup", b.Hosts)
+	for _, host := range hosts {
+		id, exists := hostContracts[host.String()]
+		if !exists {
+			endHeight := r.cs.Height() + backupRecoveryDuration
+			contract, formErr := r.hostContractor.FormContract(host, backupRecoveryFunding, endHeight)
+			if formErr != nil {
+				err = formErr
+				r.log.Printf("WARN: could not form a contract with backup host %v: %v", host, err)
+				continue
+			}
+			id = contract.ID
+		}
+		var data []byte
+		data, err = r.managedDownloadSectors(id, b.Roots)
+		if err != nil {
+			r.log.Printf("WARN: could not download backup from host %v: %v", host, err)
+			continue
+		}
+		if uint64(len(data)) < b.Size {
+			err = errors.New("backup is smaller than announced")
+			continue
+		}
+		return data[:b.Size], nil
+	}
+	return nil, err
+}
+
+// managedDownloadSectors downloads sectors from the host of a contract.
+func (r *Renter) managedDownloadSectors(id types.FileContractID, roots []crypto.Hash) ([]byte, error) {
+	downloader, err := r.hostContractor.Downloader(id, r.tg.StopChan())
+	if err != nil {
+		return nil, err
+	}
+	defer downloader.Close()
+	var data []byte
+	for _, root := range roots {
+		sector, err := downloader.Sector(root)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sector...)
+	}
+	return data, nil
+}
+
+// updateSnapshotContracts replaces the headers of the contracts in s that the
+// snapshot was uploaded to with the more recent headers in the beacon.
+func updateSnapshotContracts(s renterSnapshot, b backupBeacon) {
+	for _, revised := range b.Contracts {
+		for i, c := range s.Contracts {
+			if c.ID() == revised.ID() {
+				s.Contracts[i].Header = revised.Header
+				s.Contracts[i].Roots = append(c.Roots, b.Roots...)
+			}
+		}
+	}
+}
+
+// managedRestoreSnapshot restores the contracts and files of a snapshot.
+// Files that conflict with existing files are skipped.
+func (r *Renter) managedRestoreSnapshot(s renterSnapshot) error {
+	if err := r.hostContractor.RestoreContracts(s.Contracts); err != nil {
+		return err
+	}
+
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if r.dedupSecret == (crypto.Hash{}) {
+		r.dedupSecret = s.DedupSecret
+	}
+	for _, siaPath := range s.Dirs {
+		if validateDirSiapath(siaPath) == nil {
+			r.createDirs(siaPath)
+		}
+	}
+	packs, blocks := r.packsByMasterKey(), r.dedupBlocksByName()
+	for _, sf := range s.Files {
+		if _, exists := r.files[sf.SiaPath]; exists || validateSiapath(sf.SiaPath) != nil {
+			continue
+		}
+		f, err := r.fileFromSharedFile(sf, packs, blocks)
+		if err != nil {
+			r.log.Println("WARN: could not restore file from backup:", err)
+			continue
+		}
+		tf, tracked := s.Tracking[f.name]
+		if err := r.addPackedFile(f, tracked); err != nil {
+			return err
+		}
+		if err := r.addDedupFile(f); err != nil {
+			return err
+		}
+		r.files[f.name] = f
+		r.addFileToDirs(f)
+		if tracked {
+			r.tracking[f.name] = tf
+		}
+		if err := r.saveFile(f); err != nil {
+			return err
+		}
+		r.recoveryMu.Lock()
+		r.recovery.FilesRestored++
+		r.recoveryMu.Unlock()
+	}
+	return r.saveSync()
+}
+
+// CreateBackup backs up the renter's metadata to its hosts.
+func (r *Renter) CreateBackup() error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	return r.managedBackup(true)
+}
+
+// managedRecoverBackup restores the contracts and files of the most recent
+// backup that was encrypted with key.
+func (r *Renter) managedRecoverBackup(key crypto.TwofishKey) error {
+	// Find the most recent beacon.
+	bs := &backupScanner{
+		key: key,
+		progress: func(height types.BlockHeight) {
+			r.recoveryMu.Lock()
+			r.recovery.ScannedHeight = height
+			r.recoveryMu.Unlock()
+		},
+	}
+	err := r.cs.ConsensusSetSubscribe(bs, modules.ConsensusChangeBeginning, r.tg.StopChan())
+	if err != nil {
+		return err
+	}
+	r.cs.Unsubscribe(bs)
+	if len(bs.beacons) == 0 {
+		return errNoBackup
+	}
+	sb := bs.beacons[len(bs.beacons)-1]
+	r.recoveryMu.Lock()
+	r.recovery.Stage = modules.RecoveryStageDownloading
+	r.recovery.BackupHeight = sb.height
+	r.recoveryMu.Unlock()
+
+	// Download and restore the snapshot.
+	data, err := r.managedDownloadSnapshot(sb.beacon)
+	if err != nil {
+		return err
+	}
+	s, err := decryptSnapshot(data, key)
+	if err != nil {
+		return err
+	}
+	updateSnapshotContracts(s, sb.beacon)
+	r.recoveryMu.Lock()
+	r.recovery.Stage = modules.RecoveryStageRestoring
+	r.recoveryMu.Unlock()
+	return r.managedRestoreSnapshot(s)
+}
+
+// threadedRecoverBackup recovers the most recent backup that was encrypted
+// with key and records the outcome in the recovery status.
+func (r *Renter) threadedRecoverBackup(key crypto.TwofishKey) {
+	err := r.tg.Add()
+	if err == nil {
+		err = r.managedRecoverBackup(key)
+		r.tg.Done()
+	}
+	if err != nil {
+		r.log.Println("WARN: could not recover backup:", err)
+	}
+
+	r.recoveryMu.Lock()
+	defer r.recoveryMu.Unlock()
+	r.recovery.Active = false
+	if err != nil {
+		r.recovery.Error = err.Error()
+	} else {
+		r.recovery.Stage = modules.RecoveryStageDone
+	}
+}
+
+// RecoverBackup starts restoring the contracts and files of the most recent
+// backup that was created with the wallet seed. The recovery scans the whole
+// blockchain, so it runs in the background. Its progress is reported by
+// RecoveryStatus.
+func (r *Renter) RecoverBackup() error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	seed, _, err := r.wallet.PrimarySeed()
+	if err != nil {
+		return err
+	}
+
+	height := r.cs.Height()
+	r.recoveryMu.Lock()
+	defer r.recoveryMu.Unlock()
+	if r.recovery.Active {
+		return errRecoveryInProgress
+	}
+	r.recovery = modules.BackupRecoveryStatus{
+		Active: true,
+		Stage:  modules.RecoveryStageScanning,
+		Height: height,
+	}
+	go r.threadedRecoverBackup(backupKey(seed))
+	return nil
+}
+
+// RecoveryStatus returns the status of the most recent recovery of a backup.
+func (r *Renter) RecoveryStatus() modules.BackupRecoveryStatus {
+	r.recoveryMu.Lock()
+	defer r.recoveryMu.Unlock()
+	return r.recovery
+}
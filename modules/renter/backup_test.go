@@ -0,0 +1,102 @@
+package renter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestSnapshotEncryption checks that an encrypted snapshot can only be
+// decrypted with the key it was encrypted with.
+func TestSnapshotEncryption(t *testing.T) {
+	var seed modules.Seed
+	fastrand.Read(seed[:])
+	key := backupKey(seed)
+
+	s := renterSnapshot{
+		Dirs: []string{"foo", "foo/bar"},
+		Files: []sharedFile{{
+			SiaPath:   "foo/bar/baz",
+			Size:      100,
+			PieceSize: 10,
+		}},
+		Tracking: map[string]trackedFile{
+			"foo/bar/baz": {RepairPath: "/baz"},
+		},
+	}
+	plaintext, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encryptSnapshot(plaintext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decryptSnapshot(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, decrypted) {
+		t.Fatal("decrypted snapshot doesn't match the original")
+	}
+
+	// A different seed results in a different key.
+	fastrand.Read(seed[:])
+	if _, err := decryptSnapshot(data, backupKey(seed)); err == nil {
+		t.Fatal("snapshot was decrypted with the wrong key")
+	}
+}
+
+// TestBackupBeacon checks that beacons are only found by the renter whose key
+// they were encrypted with, and that beacons are dropped when their block is
+// reverted.
+func TestBackupBeacon(t *testing.T) {
+	key := crypto.GenerateTwofishKey()
+	b := backupBeacon{
+		Hosts: []types.SiaPublicKey{{Algorithm: types.SignatureEd25519, Key: fastrand.Bytes(32)}},
+		Roots: []crypto.Hash{{1}, {2}},
+		Size:  12345,
+	}
+	arb := beaconArbitraryData(b, key)
+	decoded, ok := decodeBeacon(arb, key)
+	if !ok {
+		t.Fatal("beacon couldn't be decoded")
+	}
+	if !reflect.DeepEqual(b, decoded) {
+		t.Fatal("decoded beacon doesn't match the original")
+	}
+	if _, ok := decodeBeacon(arb, crypto.GenerateTwofishKey()); ok {
+		t.Fatal("beacon was decoded with the wrong key")
+	}
+	if _, ok := decodeBeacon(append(modules.PrefixNonSia[:], fastrand.Bytes(64)...), key); ok {
+		t.Fatal("arbitrary data without the backup specifier was decoded")
+	}
+
+	// Scan two blocks that contain a beacon and revert the second one.
+	block := func(arb []byte) types.Block {
+		return types.Block{
+			Nonce:        types.BlockNonce{byte(fastrand.Intn(256))},
+			Transactions: []types.Transaction{{ArbitraryData: [][]byte{arb}}},
+		}
+	}
+	other := backupBeacon{Size: 1}
+	block1, block2 := block(arb), block(beaconArbitraryData(other, key))
+	bs := &backupScanner{key: key}
+	bs.ProcessConsensusChange(modules.ConsensusChange{
+		AppliedBlocks: []types.Block{block1, block2, block(fastrand.Bytes(64))},
+	})
+	if len(bs.beacons) != 2 || bs.beacons[1].beacon.Size != other.Size {
+		t.Fatal("expected 2 beacons, got", len(bs.beacons))
+	}
+	bs.ProcessConsensusChange(modules.ConsensusChange{
+		RevertedBlocks: []types.Block{block2},
+	})
+	if len(bs.beacons) != 1 || bs.beacons[0].beacon.Size != b.Size {
+		t.Fatal("reverted beacon wasn't removed")
+	}
+}
@@ -0,0 +1,292 @@
+package renter
+
+// checksum.go records checksums of the plaintext of uploaded files. Every file
+// records the checksum of its complete data and the checksums of the data of
+// its chunks. The Merkle roots of the pieces only prove what the hosts stored,
+// the checksums also catch data that was corrupted before it was erasure coded
+// and encrypted, or while it was recovered. Every chunk that is recovered by a
+// download is checked against its checksum before it is written to the
+// destination of the download or added to the chunk cache.
+//
+// The checksums of the chunks are also used to detect local copies that
+// changed after they were uploaded. A chunk is only repaired from its local
+// copy if the local data still matches the checksum of the chunk, otherwise it
+// is repaired from the hosts.
+//
+// The checksum of a chunk is stored by the file that owns the chunk, packs and
+// blocks store the checksums of their own chunks. The checksum of a pack's
+// chunk is recorded when the chunk is uploaded for the first time, since the
+// data of a pack grows until the pack is sealed.
+//
+// Partial downloads only fetch the requested part of a chunk, which can't be
+// checked against the checksum of the whole chunk. Every chunk therefore also
+// records the checksums of its segments. The data of every data piece of a
+// chunk is divided into segments of checksumSegmentSize bytes, and a partial
+// download fetches the segments that contain the requested data and checks
+// them against their checksums. Chunks with a checksum but without segment
+// checksums, e.g. those of files that were shared by an older renter, are
+// fetched and verified as a whole.
+//
+// The checksums are BLAKE2b-256 hashes. Files that were uploaded before
+// checksums were recorded have no checksums, the chunks of such files are not
+// verified.
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/NebulousLabs/Sia/crypto"
+)
+
+var (
+	// errChecksumMismatch is returned when the recovered data of a chunk
+	// doesn't match the checksum of the chunk.
+	errChecksumMismatch = errors.New("recovered data doesn't match the checksum of the chunk")
+
+	// errLocalDataChanged is returned when the local copy of a chunk doesn't
+	// match the checksum of the chunk anymore.
+	errLocalDataChanged = errors.New("local copy of chunk has changed since it was uploaded")
+)
+
+// chunkHasher computes the checksum of the data of a chunk and the checksums
+// of its segments while the data is written to it.
+type chunkHasher struct {
+	pieceSize uint64
+	offset    uint64
+	chunk     hash.Hash
+	segment   hash.Hash
+	segments  []crypto.Hash
+}
+
+// segmentBounds returns the bounds of the segment that contains offset within
+// the data of a chunk. Segments don't cross the boundaries of pieces, so the
+// last segment of a piece can be shorter than checksumSegmentSize.
+func segmentBounds(offset, pieceSize uint64) (start, end uint64) {
+	pieceStart := offset / pieceSize * pieceSize
+	start = pieceStart + (offset-pieceStart)/checksumSegmentSize*checksumSegmentSize
+	end = start + checksumSegmentSize
+	if end > pieceStart+pieceSize {
+		end = pieceStart + pieceSize
+	}
+	return start, end
+}
+
+// segmentIndex returns the index of the segment that contains offset within
+// the data of a chunk.
+func segmentIndex(offset, pieceSize uint64) uint64 {
+	perPiece := (pieceSize + checksumSegmentSize - 1) / checksumSegmentSize
+	return offset/pieceSize*perPiece + offset%pieceSize/checksumSegmentSize
+}
+
+// numSegments returns the number of segments of a chunk with length bytes of
+// data. The last segment ends with the data, not with its piece.
+func numSegments(length, pieceSize uint64) uint64 {
+	if length == 0 {
+		return 0
+	}
+	return segmentIndex(length-1, pieceSize) + 1
+}
+
+// newChunkHasher returns a chunkHasher for a chunk with the given piece size.
+func newChunkHasher(pieceSize uint64) *chunkHasher {
+	return &chunkHasher{
+		pieceSize: pieceSize,
+		chunk:     crypto.NewHash(),
+		segment:   crypto.NewHash(),
+	}
+}
+
+// Write adds p to the data of the chunk.
+func (ch *chunkHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	ch.chunk.Write(p)
+	for len(p) > 0 {
+		_, end := segmentBounds(ch.offset, ch.pieceSize)
+		length := end - ch.offset
+		if uint64(len(p)) < length {
+			length = uint64(len(p))
+		}
+		ch.segment.Write(p[:length])
+		p = p[length:]
+		ch.offset += length
+		if ch.offset == end {
+			ch.endSegment()
+		}
+	}
+	return n, nil
+}
+
+// endSegment records the checksum of the current segment.
+func (ch *chunkHasher) endSegment() {
+	var checksum crypto.Hash
+	ch.segment.Sum(checksum[:0])
+	ch.segments = append(ch.segments, checksum)
+	ch.segment.Reset()
+}
+
+// Sum returns the checksum of the data of the chunk and the checksums of its
+// segments.
+func (ch *chunkHasher) Sum() (checksum crypto.Hash, segments []crypto.Hash) {
+	if uint64(len(ch.segments)) < numSegments(ch.offset, ch.pieceSize) {
+		ch.endSegment()
+	}
+	ch.chunk.Sum(checksum[:0])
+	return checksum, ch.segments
+}
+
+// verifySegments returns true if data, which starts at the beginning of a
+// segment at offset within the data of a chunk, matches the checksums of the
+// segments of the chunk. The data of the chunk ends at length, data past it is
+// padding and is not verified.
+func verifySegments(data []byte, offset, length, pieceSize uint64, segments []crypto.Hash) bool {
+	end := offset + uint64(len(data))
+	if end > length {
+		end = length
+	}
+	for start := offset; start < end; {
+		_, segmentEnd := segmentBounds(start, pieceSize)
+		if segmentEnd > length {
+			segmentEnd = length
+		}
+		index := segmentIndex(start, pieceSize)
+		if segmentEnd-offset > uint64(len(data)) || index >= uint64(len(segments)) || crypto.HashBytes(data[start-offset:segmentEnd-offset]) != segments[index] {
+			return false
+		}
+		start = segmentEnd
+	}
+	return true
+}
+
+// writeChunkData writes the first length bytes of the logical data of a chunk
+// to w.
+func writeChunkData(w io.Writer, data [][]byte, length uint64) {
+	for _, piece := range data {
+		if uint64(len(piece)) > length {
+			piece = piece[:length]
+		}
+		w.Write(piece)
+		length -= uint64(len(piece))
+	}
+}
+
+// checksumChunkData returns the checksum of the first length bytes of the
+// logical data of a chunk.
+func checksumChunkData(data [][]byte, length uint64) (checksum crypto.Hash) {
+	h := crypto.NewHash()
+	writeChunkData(h, data, length)
+	h.Sum(checksum[:0])
+	return checksum
+}
+
+// hashChunkData returns the checksum of the first length bytes of the logical
+// data of a chunk and the checksums of the segments of that data.
+func hashChunkData(data [][]byte, length, pieceSize uint64) (crypto.Hash, []crypto.Hash) {
+	ch := newChunkHasher(pieceSize)
+	writeChunkData(ch, data, length)
+	return ch.Sum()
+}
+
+// hashFile returns the checksum of the first size bytes of the file at source,
+// the checksums of the chunks of that data and the checksums of the segments
+// of the chunks.
+func hashFile(source string, size, chunkSize, pieceSize uint64) (checksum crypto.Hash, chunkChecksums []crypto.Hash, segmentChecksums [][]crypto.Hash, err error) {
+	fh, err := os.Open(source)
+	if err != nil {
+		return crypto.Hash{}, nil, nil, err
+	}
+	defer fh.Close()
+
+	fileHash := crypto.NewHash()
+	for offset := uint64(0); offset < size || offset == 0; offset += chunkSize {
+		length := chunkSize
+		if size-offset < chunkSize {
+			length = size - offset
+		}
+		ch := newChunkHasher(pieceSize)
+		if _, err := io.CopyN(io.MultiWriter(fileHash, ch), fh, int64(length)); err != nil {
+			return crypto.Hash{}, nil, nil, err
+		}
+		chunkChecksum, segments := ch.Sum()
+		chunkChecksums = append(chunkChecksums, chunkChecksum)
+		segmentChecksums = append(segmentChecksums, segments)
+	}
+	fileHash.Sum(checksum[:0])
+	return checksum, chunkChecksums, segmentChecksums, nil
+}
+
+// chunkChecksum returns the checksum of the data of the chunk at index. The
+// checksum is zero if it is unknown.
+func (f *file) chunkChecksum(index uint64) crypto.Hash {
+	if index >= uint64(len(f.chunkChecksums)) {
+		return crypto.Hash{}
+	}
+	return f.chunkChecksums[index]
+}
+
+// chunkSegmentChecksums returns the checksums of the segments of the chunk at
+// index, or nil if they are unknown.
+func (f *file) chunkSegmentChecksums(index uint64) []crypto.Hash {
+	if index >= uint64(len(f.segmentChecksums)) {
+		return nil
+	}
+	segments := f.segmentChecksums[index]
+	if uint64(len(segments)) != numSegments(f.chunkDataSize(index), f.pieceSize) {
+		return nil
+	}
+	return segments
+}
+
+// managedRecordChunkChecksum records the checksum of the data of the chunk at
+// index of f and the checksums of its segments, and saves the metadata of the
+// chunk.
+func (r *Renter) managedRecordChunkChecksum(f *file, index uint64, checksum crypto.Hash, segments []crypto.Hash) {
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleted {
+		return
+	}
+	if uint64(len(f.chunkChecksums)) != f.numChunks() {
+		f.chunkChecksums = make([]crypto.Hash, f.numChunks())
+	}
+	f.chunkChecksums[index] = checksum
+	if uint64(len(f.segmentChecksums)) != f.numChunks() {
+		f.segmentChecksums = make([][]crypto.Hash, f.numChunks())
+	}
+	f.segmentChecksums[index] = segments
+	if err := r.saveChunk(f, index); err != nil {
+		r.log.Println("WARN: couldn't save checksum of chunk:", err)
+	}
+}
+
+// managedVerifyLocalData returns true if data, which was read from the local
+// copy of a chunk, matches the checksum of the chunk. If the checksum of the
+// chunk is unknown and none of its pieces have been uploaded yet, the local
+// copy is the only copy of the chunk and its checksum is recorded.
+func (r *Renter) managedVerifyLocalData(chunk *unfinishedUploadChunk, data [][]byte) bool {
+	f := chunk.renterFile
+	f.mu.RLock()
+	expected := f.chunkChecksum(chunk.index)
+	length := f.chunkDataSize(chunk.index)
+	f.mu.RUnlock()
+	checksum, segments := hashChunkData(data, length, f.pieceSize)
+	if expected != (crypto.Hash{}) {
+		return checksum == expected
+	}
+
+	// Blocks that were loaded from a share file without checksums can still
+	// be checked against their key.
+	if f.dedupBlock && !r.managedVerifyDedupData(f, data) {
+		return false
+	}
+	chunk.mu.Lock()
+	piecesCompleted := chunk.piecesCompleted
+	chunk.mu.Unlock()
+	if piecesCompleted == 0 {
+		r.managedRecordChunkChecksum(f, chunk.index, checksum, segments)
+	}
+	return true
+}
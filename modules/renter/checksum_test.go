@@ -0,0 +1,342 @@
+package renter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// testSegmentChecksums returns the checksums of the segments of the data of a
+// chunk with the provided piece size.
+func testSegmentChecksums(data []byte, pieceSize uint64) (segments []crypto.Hash) {
+	for piece := uint64(0); piece < uint64(len(data)); piece += pieceSize {
+		pieceEnd := min(piece+pieceSize, uint64(len(data)))
+		for start := piece; start < pieceEnd; start += checksumSegmentSize {
+			segments = append(segments, crypto.HashBytes(data[start:min(start+checksumSegmentSize, pieceEnd)]))
+		}
+	}
+	return segments
+}
+
+// TestHashFile checks that hashFile returns the checksums of the data of a
+// file, of its chunks and of their segments.
+func TestHashFile(t *testing.T) {
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	chunkSize := checksumSegmentSize * 3
+	pieceSize := chunkSize / 2
+	tests := []struct {
+		size      uint64
+		numChunks int
+	}{
+		{0, 1},
+		{1, 1},
+		{chunkSize, 1},
+		{chunkSize*2 + 1, 3},
+	}
+	for _, test := range tests {
+		data := fastrand.Bytes(int(test.size))
+		source := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(source, append(data, 0), 0600); err != nil {
+			t.Fatal(err)
+		}
+		checksum, chunkChecksums, segmentChecksums, err := hashFile(source, test.size, chunkSize, pieceSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != crypto.HashBytes(data) {
+			t.Error("wrong checksum for file of size", test.size)
+		}
+		if len(chunkChecksums) != test.numChunks {
+			t.Fatal("wrong number of chunk checksums:", len(chunkChecksums), test.numChunks)
+		}
+		for i, chunkChecksum := range chunkChecksums {
+			end := uint64(i+1) * chunkSize
+			if end > test.size {
+				end = test.size
+			}
+			if chunkChecksum != crypto.HashBytes(data[uint64(i)*chunkSize:end]) {
+				t.Errorf("wrong checksum for chunk %v of file of size %v", i, test.size)
+			}
+			if !reflect.DeepEqual(segmentChecksums[i], testSegmentChecksums(data[uint64(i)*chunkSize:end], pieceSize)) {
+				t.Errorf("wrong segment checksums for chunk %v of file of size %v", i, test.size)
+			}
+		}
+	}
+
+	// Hashing fails if the file is shorter than expected.
+	if _, _, _, err := hashFile(filepath.Join(dir, "file"), chunkSize*3, chunkSize, pieceSize); err == nil {
+		t.Error("expected hashing a short file to fail")
+	}
+}
+
+// TestVerifySegments checks that the segments of a partially fetched chunk
+// are verified against the checksums of the segments.
+func TestVerifySegments(t *testing.T) {
+	pieceSize := checksumSegmentSize*2 + checksumSegmentSize/2
+	length := pieceSize + checksumSegmentSize + 1
+	data := fastrand.Bytes(int(pieceSize * 2))
+	checksum, segments := hashChunkData([][]byte{data[:pieceSize], data[pieceSize:]}, length, pieceSize)
+	if checksum != crypto.HashBytes(data[:length]) {
+		t.Fatal("wrong checksum")
+	}
+	if !reflect.DeepEqual(segments, testSegmentChecksums(data[:length], pieceSize)) {
+		t.Fatal("wrong segment checksums")
+	}
+	if uint64(len(segments)) != numSegments(length, pieceSize) {
+		t.Fatal("wrong number of segments:", len(segments), numSegments(length, pieceSize))
+	}
+
+	// Every range of whole segments within a piece can be verified. The
+	// padding after the data is not verified.
+	tests := []struct {
+		start, end uint64
+	}{
+		{0, checksumSegmentSize},
+		{checksumSegmentSize, pieceSize},
+		{checksumSegmentSize * 2, pieceSize},
+		{pieceSize, pieceSize + checksumSegmentSize},
+		{pieceSize + checksumSegmentSize, pieceSize + checksumSegmentSize*2},
+		{pieceSize, pieceSize * 2},
+	}
+	for _, test := range tests {
+		fetched := append([]byte(nil), data[test.start:test.end]...)
+		if !verifySegments(fetched, test.start, length, pieceSize, segments) {
+			t.Errorf("range %v-%v wasn't verified", test.start, test.end)
+		}
+		if test.end > length {
+			fetched[len(fetched)-1]++
+			if !verifySegments(fetched, test.start, length, pieceSize, segments) {
+				t.Errorf("padding of range %v-%v was verified", test.start, test.end)
+			}
+			fetched[len(fetched)-1]--
+		}
+		fetched[0]++
+		if verifySegments(fetched, test.start, length, pieceSize, segments) {
+			t.Errorf("corrupted range %v-%v was verified", test.start, test.end)
+		}
+	}
+
+	// Data that ends before the end of its last segment can't be verified.
+	if verifySegments(data[:checksumSegmentSize-1], 0, length, pieceSize, segments) {
+		t.Error("incomplete segment was verified")
+	}
+
+	// The widened range of a partial download covers the segments that
+	// contain the fetched data and stays within its piece.
+	udc := &unfinishedDownloadChunk{
+		staticFetchOffset:      pieceSize + 1,
+		staticFetchLength:      checksumSegmentSize,
+		staticPieceSize:        pieceSize,
+		staticSegmentChecksums: segments,
+	}
+	if offset, length := udc.staticPieceRange(); offset != 0 || length != checksumSegmentSize*2 {
+		t.Error("wrong piece range:", offset, length)
+	}
+	udc.staticFetchOffset = pieceSize*2 - 1
+	udc.staticFetchLength = 1
+	if offset, length := udc.staticPieceRange(); offset != checksumSegmentSize*2 || length != checksumSegmentSize/2 {
+		t.Error("wrong piece range:", offset, length)
+	}
+}
+
+// TestFileChecksums checks that the checksums of an uploaded file are
+// recorded, persisted and shared.
+func TestFileChecksums(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	data := fastrand.Bytes(int(pieceSize)*2 + 1)
+	source := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(source, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Upload(modules.FileUploadParams{
+		Source:      source,
+		SiaPath:     "file",
+		ErasureCode: ec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := r.mu.RLock()
+	f := r.files["file"]
+	r.mu.RUnlock(id)
+	if f.checksum != crypto.HashBytes(data) {
+		t.Fatal("wrong checksum of file")
+	}
+	if len(f.chunkChecksums) != 3 || f.chunkChecksums[2] != crypto.HashBytes(data[2*pieceSize:]) {
+		t.Fatal("wrong checksums of chunks")
+	}
+	if info, err := r.File("file"); err != nil {
+		t.Fatal(err)
+	} else if info.Checksum != f.checksum {
+		t.Fatal("wrong checksum in file info")
+	}
+
+	// The checksums are persisted.
+	loaded, err := loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.checksum != f.checksum || !reflect.DeepEqual(loaded.chunkChecksums, f.chunkChecksums) || !reflect.DeepEqual(loaded.segmentChecksums, f.segmentChecksums) {
+		t.Fatal("checksums weren't persisted")
+	}
+
+	// The checksums are shared.
+	ascii, err := r.ShareFilesASCII([]string{"file"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := r.LoadSharedFilesASCII(ascii)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.RLock()
+	shared := r.files[names[0]]
+	r.mu.RUnlock(id)
+	if shared.checksum != f.checksum || !reflect.DeepEqual(shared.chunkChecksums, f.chunkChecksums) {
+		t.Fatal("checksums weren't shared")
+	}
+}
+
+// TestVerifyLocalData checks that the local copy of a chunk is only used if
+// it matches the checksum of the chunk, and that unknown checksums are only
+// recorded before the first piece of a chunk is uploaded.
+func TestVerifyLocalData(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(2, 1)
+	f := newFile("file", ec, pieceSize, pieceSize)
+	data := fastrand.Bytes(int(pieceSize))
+	buf := NewDownloadDestinationBuffer(f.staticChunkSize())
+	if _, err := buf.ReadFrom(bytes.NewReader(data)); err == nil {
+		t.Fatal("expected the buffer to be only partially filled")
+	}
+	chunk := &unfinishedUploadChunk{
+		renterFile:      f,
+		piecesCompleted: 1,
+	}
+
+	// The checksum of a chunk that was already uploaded is not recorded.
+	if !r.managedVerifyLocalData(chunk, buf) {
+		t.Fatal("data of chunk without a checksum was rejected")
+	}
+	if f.chunkChecksums != nil {
+		t.Fatal("checksum of uploaded chunk was recorded")
+	}
+
+	// The checksum of a chunk that wasn't uploaded yet is recorded.
+	chunk.piecesCompleted = 0
+	if !r.managedVerifyLocalData(chunk, buf) {
+		t.Fatal("data of new chunk was rejected")
+	}
+	if f.chunkChecksum(0) != crypto.HashBytes(data) {
+		t.Fatal("checksum of new chunk wasn't recorded")
+	}
+
+	// Padding beyond the data of the chunk doesn't matter, changed data is
+	// rejected.
+	buf[1][len(buf[1])-1]++
+	if !r.managedVerifyLocalData(chunk, buf) {
+		t.Fatal("changed padding was rejected")
+	}
+	buf[0][0]++
+	if r.managedVerifyLocalData(chunk, buf) {
+		t.Fatal("changed data was accepted")
+	}
+}
+
+// TestRecoverChecksum checks that recovered chunks are verified against their
+// checksums before they are written to the destination of the download.
+func TestRecoverChecksum(t *testing.T) {
+	ec, _ := NewRSCode(1, 1)
+	var masterKey crypto.TwofishKey
+	fastrand.Read(masterKey[:])
+	data := fastrand.Bytes(int(pieceSize) / 2)
+
+	for _, corrupt := range []bool{false, true} {
+		// Encode and encrypt the chunk like an upload would.
+		logical := NewDownloadDestinationBuffer(pieceSize)
+		logical.ReadFrom(bytes.NewReader(data))
+		pieces, err := ec.EncodeShards(logical)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if corrupt {
+			pieces[0][0]++
+		}
+		for i := range pieces {
+			pieces[i] = deriveKey(masterKey, 0, uint64(i)).EncryptBytes(pieces[i])
+		}
+		pieces[1] = nil
+
+		destination := NewDownloadDestinationBuffer(pieceSize)
+		udc := &unfinishedDownloadChunk{
+			destination: destination,
+			erasureCode: ec,
+			masterKey:   masterKey,
+
+			staticChunkSize:   pieceSize,
+			staticFetchLength: uint64(len(data)),
+			staticPieceSize:   pieceSize,
+			staticChecksum:    crypto.HashBytes(data),
+			staticDataLength:  uint64(len(data)),
+
+			physicalChunkData: pieces,
+			piecesCompleted:   1,
+			download: &download{
+				chunksCompleted: make(map[uint64]struct{}),
+				chunksRemaining: 1,
+				completeChan:    make(chan struct{}),
+				destination:     destination,
+			},
+			chunkCache: make(map[string]*cacheData),
+			cacheMu:    new(sync.Mutex),
+		}
+		err = udc.threadedRecoverLogicalData()
+		if corrupt && err != errChecksumMismatch {
+			t.Fatal("expected checksum mismatch, got", err)
+		} else if !corrupt && err != nil {
+			t.Fatal(err)
+		}
+		if corrupt && len(udc.chunkCache) != 0 {
+			t.Fatal("corrupt chunk was added to the cache")
+		}
+		if !corrupt && !bytes.Equal(destination[0][:len(data)], data) {
+			t.Fatal("recovered data doesn't match")
+		}
+	}
+}
@@ -0,0 +1,603 @@
+package renter
+
+// compression.go compresses the data of files before it is encrypted and
+// uploaded. Compression is opt-in per file. The data of a compressed file is
+// split into frames of compressionFrameSize bytes, and every frame is
+// compressed on its own. The compressed frames are stored back to back, and
+// this stored data is what the chunks of the file are made of. The file's
+// metadata records the codec, the frame size and the offset of every frame
+// within the stored data.
+//
+// Since the frames are independent, any range of a compressed file can be
+// read by fetching and decompressing only the frames that overlap the range.
+// Downloads of a compressed file fetch the stored data of these frames and
+// decompress every frame as soon as all of its data has arrived. The streamer
+// of a compressed file reads the frames through a streamer of the stored
+// data, so sequential reads of the file are sequential reads of the stored
+// data and benefit from its prefetching.
+//
+// The local copy of a compressed file is uncompressed. While a file is
+// compressed during its upload, its stored data is staged in
+// compressionStageDir, and every chunk of the initial upload is read from the
+// staged data. Once every chunk has been read, the staged data is removed.
+// Chunks that are repaired later on are read from the local copy by
+// compressing the frames that overlap the chunk again. The codec is
+// deterministic, so the compressed data matches the uploaded data unless the
+// local copy changed, which is caught by the checksums of the chunks. The
+// checksums of the chunks of a compressed file are checksums of the stored
+// data, the checksum of the file is the checksum of its uncompressed data.
+//
+// The chunks of a compressed file can be repaired and re-encoded like those
+// of any other file, since they only ever deal with the stored data.
+// Downloads of compressed files are resumed at the granularity of frames. A
+// frame that spans multiple chunks might only have been partially received
+// when the download was interrupted, so the frames that were written to the
+// destination are saved instead of the chunks, and a resumed download only
+// skips the chunks whose frames were all written.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/persist"
+)
+
+const (
+	// compressionCodecDeflate is the name of the DEFLATE codec. Every frame
+	// is a complete DEFLATE stream.
+	compressionCodecDeflate = "deflate"
+
+	// compressionStageDir is the directory within the renter directory that
+	// contains the staged data of compressed files. Siapaths within
+	// compressionStageDir are reserved.
+	compressionStageDir = ".compression"
+
+	// compressionStageExtension is the extension of the files that stage the
+	// data of compressed files.
+	compressionStageExtension = ".dat"
+)
+
+var (
+	// compressionFrameSize is the amount of a file's data that is compressed
+	// into a single frame. Reads of a compressed file are rounded to whole
+	// frames.
+	compressionFrameSize = build.Select(build.Var{
+		Dev:      uint64(1 << 18), // 256 KiB
+		Standard: uint64(1 << 20), // 1 MiB
+		Testing:  uint64(1 << 12), // 4 KiB
+	}).(uint64)
+)
+
+var (
+	// errCompressDedup is returned when a file is uploaded with both
+	// compression and deduplication.
+	errCompressDedup = errors.New("compressed files can't be deduplicated")
+
+	// errCorruptFrame is returned when a compressed frame can't be
+	// decompressed into the data it was compressed from.
+	errCorruptFrame = errors.New("compressed frame is corrupt")
+)
+
+type (
+	// fileCompression describes how the data of a compressed file is stored.
+	// frameOffsets contains the offset of every compressed frame within the
+	// stored data, dataSize is the size of the uncompressed data.
+	fileCompression struct {
+		codec        string
+		frameSize    uint64
+		frameOffsets []uint64
+		dataSize     uint64
+	}
+
+	// compressionStage is the staged data of a compressed file whose chunks
+	// haven't all been read yet. The staged data is removed once every chunk
+	// has been read.
+	compressionStage struct {
+		path    string
+		unread  map[uint64]struct{} // Chunks that haven't been read yet.
+		reading int                 // Number of chunks that are being read.
+	}
+
+	// downloadDestinationDecompressor is a downloadDestination that receives
+	// the compressed frames of a range of a compressed file. Every frame is
+	// buffered until all of its data has been written, then it is
+	// decompressed and the part of its data that is within the range is
+	// written to the underlying destination. The frames that were written
+	// are recorded in the download, so that it can be resumed.
+	downloadDestinationDecompressor struct {
+		destination downloadDestination
+		download    *download
+		file        *file
+		storedStart uint64 // Offset of the first frame within the stored data.
+		offset      uint64 // Offset of the range within the file's data.
+		length      uint64 // Length of the range.
+
+		frames   map[uint64][]byte // Buffers of the incomplete frames.
+		received map[uint64]uint64 // Bytes received of the incomplete frames.
+		mu       sync.Mutex
+	}
+
+	// compressedStreamer is a modules.Streamer of the data of a compressed
+	// file. It reads the compressed frames from a streamer of the stored
+	// data and keeps the last decompressed frame around for the next Read.
+	compressedStreamer struct {
+		file   *file
+		offset int64
+		stream *streamer
+
+		frame      []byte // The decompressed data of the frame at frameIndex.
+		frameIndex uint64
+	}
+)
+
+// codecName returns the name of the codec of a file's compression, or an
+// empty string if c is nil.
+func (c *fileCompression) codecName() string {
+	if c == nil {
+		return ""
+	}
+	return c.codec
+}
+
+// validate checks that the compressed frames are consistent with the size of
+// the stored data.
+func (c *fileCompression) validate(storedSize uint64) error {
+	if c.codec != compressionCodecDeflate {
+		return errors.New("unknown compression codec: " + c.codec)
+	}
+	if c.frameSize == 0 {
+		return errors.New("frame size of compressed file must be nonzero")
+	}
+	if uint64(len(c.frameOffsets)) != (c.dataSize+c.frameSize-1)/c.frameSize {
+		return errors.New("frames of compressed file don't match its size")
+	}
+	for i, offset := range c.frameOffsets {
+		if (i == 0 && offset != 0) || (i > 0 && offset <= c.frameOffsets[i-1]) || offset >= storedSize {
+			return errors.New("frames of compressed file are out of order")
+		}
+	}
+	return nil
+}
+
+// dataSize returns the size of the file's data. The data of a compressed file
+// is larger than what is stored on the hosts.
+func (f *file) dataSize() uint64 {
+	if f.compression != nil {
+		return f.compression.dataSize
+	}
+	return f.size
+}
+
+// frameBounds returns the offsets within the stored data at which the frame
+// at index of a compressed file starts and ends.
+func (f *file) frameBounds(index uint64) (start, end uint64) {
+	c := f.compression
+	start, end = c.frameOffsets[index], f.size
+	if index+1 < uint64(len(c.frameOffsets)) {
+		end = c.frameOffsets[index+1]
+	}
+	return start, end
+}
+
+// frameDataSize returns the size of the uncompressed data of the frame at
+// index of a compressed file. Only the last frame can be smaller than a full
+// frame.
+func (f *file) frameDataSize(index uint64) uint64 {
+	c := f.compression
+	return min(c.frameSize, c.dataSize-index*c.frameSize)
+}
+
+// storedFrameIndex returns the index of the frame of a compressed file that
+// contains the stored data at offset.
+func (f *file) storedFrameIndex(offset uint64) uint64 {
+	offsets := f.compression.frameOffsets
+	return uint64(sort.Search(len(offsets), func(i int) bool {
+		return offsets[i] > offset
+	}) - 1)
+}
+
+// compressFrame compresses the data of a single frame. The default level of
+// the flate package often doesn't compress frames of a few KiB at all, so
+// frames are compressed at the best level.
+func compressFrame(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressFrame decompresses a frame that was compressed from size bytes of
+// data.
+func decompressFrame(frame []byte, size uint64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(frame))
+	defer r.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errCorruptFrame
+	}
+	if n, _ := r.Read(make([]byte, 1)); n != 0 {
+		return nil, errCorruptFrame
+	}
+	return data, nil
+}
+
+// compressFile compresses the data of f, which is read from source, and writes
+// the compressed data to stage. If the compressed data is smaller than the
+// data, f becomes a compressed file and the checksum of its data and the
+// checksums of its compressed chunks are recorded. Otherwise f is left
+// unchanged.
+func compressFile(f *file, source string, stage io.Writer) error {
+	fh, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	// Compress the data frame by frame. The chunks are made of the
+	// compressed frames, so their checksums are computed over the stored
+	// data.
+	c := &fileCompression{
+		codec:     compressionCodecDeflate,
+		frameSize: compressionFrameSize,
+		dataSize:  f.size,
+	}
+	chunkSize := f.staticChunkSize()
+	fileHash, chunkHash := crypto.NewHash(), newChunkHasher(f.pieceSize)
+	var storedSize uint64
+	var chunkChecksums []crypto.Hash
+	var segmentChecksums [][]crypto.Hash
+	addChunkChecksum := func() {
+		checksum, segments := chunkHash.Sum()
+		chunkChecksums = append(chunkChecksums, checksum)
+		segmentChecksums = append(segmentChecksums, segments)
+		chunkHash = newChunkHasher(f.pieceSize)
+	}
+	buf := make([]byte, c.frameSize)
+	for offset := uint64(0); offset < c.dataSize; offset += c.frameSize {
+		data := buf[:min(c.frameSize, c.dataSize-offset)]
+		if _, err := io.ReadFull(fh, data); err != nil {
+			return err
+		}
+		fileHash.Write(data)
+		frame, err := compressFrame(data)
+		if err != nil {
+			return err
+		}
+		if _, err := stage.Write(frame); err != nil {
+			return err
+		}
+		c.frameOffsets = append(c.frameOffsets, storedSize)
+		for len(frame) > 0 {
+			n := min(uint64(len(frame)), chunkSize-storedSize%chunkSize)
+			chunkHash.Write(frame[:n])
+			frame = frame[n:]
+			storedSize += n
+			if storedSize%chunkSize == 0 {
+				addChunkChecksum()
+			}
+		}
+	}
+	if storedSize >= c.dataSize {
+		return nil
+	}
+	if storedSize%chunkSize != 0 {
+		addChunkChecksum()
+	}
+
+	f.size = storedSize
+	f.compression = c
+	fileHash.Sum(f.checksum[:0])
+	f.chunkChecksums = chunkChecksums
+	f.segmentChecksums = segmentChecksums
+	return nil
+}
+
+// stageCompressedFile compresses the data of f like compressFile and stages
+// the compressed data in a new file within compressionStageDir. The path of
+// the staged data is returned, or an empty string if f wasn't compressed.
+func (r *Renter) stageCompressedFile(f *file, source string) (string, error) {
+	dir := filepath.Join(r.persistDir, compressionStageDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, persist.RandomSuffix()+compressionStageExtension)
+	fh, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(fh)
+	err = compressFile(f, source, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || f.compression == nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// addCompressionStage registers the staged data of f, so that the chunks of f
+// are read from it. The lock of the renter needs to be held by the caller.
+func (r *Renter) addCompressionStage(f *file, path string) {
+	stage := &compressionStage{
+		path:   path,
+		unread: make(map[uint64]struct{}),
+	}
+	for i := uint64(0); i < f.numChunks(); i++ {
+		stage.unread[i] = struct{}{}
+	}
+	r.compressionStages[f] = stage
+}
+
+// releaseCompressionStage removes the staged data of f, if f has any. The
+// lock of the renter needs to be held by the caller.
+func (r *Renter) releaseCompressionStage(f *file) {
+	stage, exists := r.compressionStages[f]
+	if !exists {
+		return
+	}
+	delete(r.compressionStages, f)
+	if err := os.Remove(stage.path); err != nil {
+		r.log.Println("WARN: couldn't remove the staged data of a compressed file:", err)
+	}
+}
+
+// removeCompressionStages removes the staged data that is left over from
+// before the renter started. The uploads of the staged data are resumed by
+// compressing the local copies again.
+func (r *Renter) removeCompressionStages() {
+	paths, _ := filepath.Glob(filepath.Join(r.persistDir, compressionStageDir, "*"+compressionStageExtension))
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			r.log.Println("WARN: couldn't remove the staged data of a compressed file:", err)
+		}
+	}
+}
+
+// managedStagedChunkData returns the stored data of a chunk of a compressed
+// file from the file's staged data. Every chunk is only read from the staged
+// data once, nil is returned if the chunk has been read before or if the
+// file doesn't have any staged data.
+func (r *Renter) managedStagedChunkData(chunk *unfinishedUploadChunk) []byte {
+	f := chunk.renterFile
+	id := r.mu.Lock()
+	stage, exists := r.compressionStages[f]
+	if exists {
+		_, exists = stage.unread[chunk.index]
+	}
+	if !exists {
+		r.mu.Unlock(id)
+		return nil
+	}
+	delete(stage.unread, chunk.index)
+	stage.reading++
+	r.mu.Unlock(id)
+
+	offset := uint64(chunk.offset)
+	data := make([]byte, min(chunk.length, f.size-offset))
+	fh, err := os.Open(stage.path)
+	if err == nil {
+		_, err = fh.ReadAt(data, int64(offset))
+		fh.Close()
+	}
+	if err != nil {
+		r.log.Println("WARN: couldn't read the staged data of a compressed file:", err)
+		data = nil
+	}
+
+	id = r.mu.Lock()
+	stage.reading--
+	if len(stage.unread) == 0 && stage.reading == 0 {
+		r.releaseCompressionStage(f)
+	}
+	r.mu.Unlock(id)
+	return data
+}
+
+// compressedData returns length bytes of the stored data of the compressed
+// file f, starting at offset. The data is compressed from fh, the local copy
+// of the file's data. If fh changed since f was uploaded, the compressed data
+// might be too short, in which case errLocalDataChanged is returned.
+func compressedData(fh io.ReaderAt, f *file, offset, length uint64) ([]byte, error) {
+	length = min(length, f.size-offset)
+	first := f.storedFrameIndex(offset)
+	var data []byte
+	buf := make([]byte, f.compression.frameSize)
+	for i := first; i < uint64(len(f.compression.frameOffsets)) && f.compression.frameOffsets[i] < offset+length; i++ {
+		frameData := buf[:f.frameDataSize(i)]
+		if n, err := fh.ReadAt(frameData, int64(i*f.compression.frameSize)); n < len(frameData) {
+			return nil, err
+		}
+		frame, err := compressFrame(frameData)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, frame...)
+	}
+	start := offset - f.compression.frameOffsets[first]
+	if uint64(len(data)) < start+length {
+		return nil, errLocalDataChanged
+	}
+	return data[start : start+length], nil
+}
+
+// compressedDownloadParams converts the parameters of a download of a range of
+// a compressed file's data into the parameters of a download of the frames
+// that overlap the range. The frames are decompressed into the destination of
+// the original parameters. completedFrames contains the frames that an
+// earlier attempt of the download wrote to the destination, the chunks that
+// only contain such frames are skipped.
+func compressedDownloadParams(params downloadParams, completedFrames []uint64) downloadParams {
+	f := params.file
+	first := params.offset / f.compression.frameSize
+	last := (params.offset + params.length - 1) / f.compression.frameSize
+	start, _ := f.frameBounds(first)
+	_, end := f.frameBounds(last)
+	params.destination = &downloadDestinationDecompressor{
+		destination: params.destination,
+		file:        f,
+		storedStart: start,
+		offset:      params.offset,
+		length:      params.length,
+
+		frames:   make(map[uint64][]byte),
+		received: make(map[uint64]uint64),
+	}
+	params.offset, params.length = start, end-start
+
+	params.skipFrames = make(map[uint64]struct{})
+	for _, index := range completedFrames {
+		params.skipFrames[index] = struct{}{}
+	}
+	params.skipChunks = make(map[uint64]struct{})
+	chunkSize := f.staticChunkSize()
+	for i := start / chunkSize; i <= (end-1)/chunkSize; i++ {
+		from, to := i*chunkSize, min((i+1)*chunkSize, end)
+		if from < start {
+			from = start
+		}
+		skip := true
+		for index := f.storedFrameIndex(from); index <= f.storedFrameIndex(to-1) && skip; index++ {
+			_, skip = params.skipFrames[index]
+		}
+		if skip {
+			params.skipChunks[i] = struct{}{}
+		}
+	}
+	return params
+}
+
+// Close implements Close for the downloadDestination interface.
+func (dd *downloadDestinationDecompressor) Close() error {
+	return dd.destination.Close()
+}
+
+// WriteAt buffers the compressed data of the frames that it receives. Frames
+// that are complete are decompressed and written to the underlying
+// destination, in order.
+func (dd *downloadDestinationDecompressor) WriteAt(data []byte, offset int64) (int, error) {
+	written := len(data)
+	var complete []uint64
+	dd.mu.Lock()
+	for pos := dd.storedStart + uint64(offset); len(data) > 0; {
+		index := dd.file.storedFrameIndex(pos)
+		start, end := dd.file.frameBounds(index)
+		frame, exists := dd.frames[index]
+		if !exists {
+			frame = make([]byte, end-start)
+			dd.frames[index] = frame
+		}
+		n := copy(frame[pos-start:], data)
+		data = data[n:]
+		pos += uint64(n)
+		dd.received[index] += uint64(n)
+		if dd.received[index] == end-start {
+			complete = append(complete, index)
+		}
+	}
+	frames := make([][]byte, len(complete))
+	for i, index := range complete {
+		frames[i] = dd.frames[index]
+		delete(dd.frames, index)
+		delete(dd.received, index)
+	}
+	dd.mu.Unlock()
+
+	// Write the parts of the decompressed frames that are within the range of
+	// the download.
+	for i, index := range complete {
+		frameData, err := decompressFrame(frames[i], dd.file.frameDataSize(index))
+		if err != nil {
+			return 0, err
+		}
+		frameOffset := index * dd.file.compression.frameSize
+		from, to := frameOffset, frameOffset+uint64(len(frameData))
+		if from < dd.offset {
+			from = dd.offset
+		}
+		to = min(to, dd.offset+dd.length)
+		if _, err := dd.destination.WriteAt(frameData[from-frameOffset:to-frameOffset], int64(from-dd.offset)); err != nil {
+			return 0, err
+		}
+		if dd.download != nil {
+			dd.download.mu.Lock()
+			dd.download.framesCompleted[index] = struct{}{}
+			dd.download.mu.Unlock()
+		}
+	}
+	return written, nil
+}
+
+// Close closes the streamer of the stored data.
+func (cs *compressedStreamer) Close() error {
+	return cs.stream.Close()
+}
+
+// Read reads the file's data from the frame that contains the offset of the
+// streamer. A Read never returns data from more than one frame.
+func (cs *compressedStreamer) Read(p []byte) (int, error) {
+	if cs.offset >= int64(cs.file.dataSize()) {
+		return 0, io.EOF
+	}
+	index := uint64(cs.offset) / cs.file.compression.frameSize
+	if cs.frame == nil || cs.frameIndex != index {
+		start, end := cs.file.frameBounds(index)
+		if _, err := cs.stream.Seek(int64(start), io.SeekStart); err != nil {
+			return 0, err
+		}
+		frame := make([]byte, end-start)
+		if _, err := io.ReadFull(cs.stream, frame); err != nil {
+			return 0, err
+		}
+		data, err := decompressFrame(frame, cs.file.frameDataSize(index))
+		if err != nil {
+			return 0, err
+		}
+		cs.frame, cs.frameIndex = data, index
+	}
+	n := copy(p, cs.frame[uint64(cs.offset)-index*cs.file.compression.frameSize:])
+	cs.offset += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset for the next Read within the file's data, like
+// streamer.Seek.
+func (cs *compressedStreamer) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = 0
+	case io.SeekCurrent:
+		newOffset = cs.offset
+	case io.SeekEnd:
+		newOffset = int64(cs.file.dataSize())
+	}
+	newOffset += offset
+
+	if newOffset < 0 {
+		return cs.offset, errors.New("cannot seek to negative offset")
+	}
+	cs.offset = newOffset
+	return cs.offset, nil
+}
@@ -0,0 +1,374 @@
+package renter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// newCompressedTestFile writes data to a file in a temporary directory and
+// returns a file of its size with a 1-of-2 erasure code that was compressed
+// from it.
+func newCompressedTestFile(t *testing.T, data []byte) (*file, string) {
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(dir, "source")
+	if err := ioutil.WriteFile(source, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("file", ec, pieceSize, uint64(len(data)))
+	if err := compressFile(f, source, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	return f, source
+}
+
+// compressibleData returns size bytes of random hex digits, which compress to
+// about half their size.
+func compressibleData(size int) []byte {
+	data := make([]byte, size)
+	hex.Encode(data, fastrand.Bytes(size/2))
+	if size%2 != 0 {
+		data[size-1] = '0'
+	}
+	return data
+}
+
+// TestCompressFile checks that the data of a compressed file is stored in
+// independent frames and that the checksums of the chunks are checksums of
+// the stored data.
+func TestCompressFile(t *testing.T) {
+	data := compressibleData(int(5*compressionFrameSize + 123))
+	f, source := newCompressedTestFile(t, data)
+	if f.compression == nil || f.size >= uint64(len(data)) || f.dataSize() != uint64(len(data)) {
+		t.Fatal("file wasn't compressed:", f.size, f.dataSize())
+	}
+	if err := f.compression.validate(f.size); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.compression.frameOffsets) != 6 {
+		t.Fatal("expected 6 frames, got", len(f.compression.frameOffsets))
+	}
+	if f.checksum != crypto.HashBytes(data) {
+		t.Fatal("checksum of the file isn't the checksum of its uncompressed data")
+	}
+
+	// Every frame decompresses into its part of the data.
+	fh, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	stored, err := compressedData(fh, f, 0, f.size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(stored)) != f.size {
+		t.Fatal("expected stored data of the file's size, got", len(stored))
+	}
+	var stage bytes.Buffer
+	staged := newFile("file", f.erasureCode, pieceSize, uint64(len(data)))
+	if err := compressFile(staged, source, &stage); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(stage.Bytes(), stored) {
+		t.Fatal("staged data doesn't match the stored data")
+	}
+	for i := range f.compression.frameOffsets {
+		start, end := f.frameBounds(uint64(i))
+		frameData, err := decompressFrame(stored[start:end], f.frameDataSize(uint64(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := uint64(i) * compressionFrameSize
+		if !bytes.Equal(frameData, data[offset:offset+uint64(len(frameData))]) {
+			t.Fatal("frame", i, "doesn't match the data")
+		}
+	}
+	if _, err := decompressFrame(stored[:f.compression.frameOffsets[1]], compressionFrameSize+1); err != errCorruptFrame {
+		t.Fatal("expected errCorruptFrame, got", err)
+	}
+
+	// The chunk checksums are checksums of the stored data.
+	chunkSize := f.staticChunkSize()
+	if f.numChunks() != uint64(len(f.chunkChecksums)) {
+		t.Fatal("expected a checksum for every chunk, got", len(f.chunkChecksums))
+	}
+	for i, checksum := range f.chunkChecksums {
+		chunk := stored[uint64(i)*chunkSize:]
+		if uint64(len(chunk)) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if checksum != crypto.HashBytes(chunk) {
+			t.Fatal("wrong checksum of chunk", i)
+		}
+	}
+
+	// Any range of the stored data can be compressed from the local copy.
+	for _, r := range []struct{ offset, length uint64 }{
+		{0, 1},
+		{f.compression.frameOffsets[2], 10},
+		{f.compression.frameOffsets[2] - 5, chunkSize},
+		{f.size - 10, chunkSize},
+	} {
+		chunk, err := compressedData(fh, f, r.offset, r.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := min(r.offset+r.length, f.size)
+		if !bytes.Equal(chunk, stored[r.offset:end]) {
+			t.Fatalf("wrong stored data at %v+%v", r.offset, r.length)
+		}
+	}
+
+	// Data that doesn't compress leaves the file uncompressed.
+	g, _ := newCompressedTestFile(t, fastrand.Bytes(int(2*compressionFrameSize)))
+	if g.compression != nil || g.size != 2*compressionFrameSize || g.chunkChecksums != nil {
+		t.Fatal("incompressible file was compressed")
+	}
+}
+
+// TestCompressedDataChanged checks that a local copy that changed since the
+// file was compressed is detected.
+func TestCompressedDataChanged(t *testing.T) {
+	data := compressibleData(int(3 * compressionFrameSize))
+	f, source := newCompressedTestFile(t, data)
+	if err := ioutil.WriteFile(source, make([]byte, len(data)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	if _, err := compressedData(fh, f, f.size-10, 10); err != errLocalDataChanged {
+		t.Fatal("expected errLocalDataChanged, got", err)
+	}
+}
+
+// TestDownloadDestinationDecompressor checks that the frames of a range of a
+// compressed file are decompressed into the destination, regardless of the
+// order in which their data is written.
+func TestDownloadDestinationDecompressor(t *testing.T) {
+	data := compressibleData(int(6*compressionFrameSize + 77))
+	f, source := newCompressedTestFile(t, data)
+	fh, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	stored, err := compressedData(fh, f, 0, f.size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ offset, length uint64 }{
+		{0, uint64(len(data))},
+		{10, 20},
+		{compressionFrameSize - 1, 2},
+		{compressionFrameSize + 5, 4*compressionFrameSize + 70},
+	} {
+		buf := NewDownloadDestinationBuffer(r.length)
+		params := compressedDownloadParams(downloadParams{
+			destination: buf,
+			file:        f,
+			offset:      r.offset,
+			length:      r.length,
+		}, nil)
+		first := r.offset / compressionFrameSize
+		if start, _ := f.frameBounds(first); params.offset != start {
+			t.Fatal("download doesn't start at the first frame of the range:", params.offset, start)
+		}
+
+		// Write the stored data of the frames back to front, in pieces that
+		// don't line up with the frames.
+		fetched := stored[params.offset : params.offset+params.length]
+		for end := uint64(len(fetched)); end > 0; {
+			start := uint64(0)
+			if end > 1000 {
+				start = end - 1000
+			}
+			if _, err := params.destination.WriteAt(fetched[start:end], int64(start)); err != nil {
+				t.Fatal(err)
+			}
+			end = start
+		}
+		if !bytes.Equal(bytes.Join(buf, nil)[:r.length], data[r.offset:r.offset+r.length]) {
+			t.Fatalf("wrong data downloaded at %v+%v", r.offset, r.length)
+		}
+	}
+}
+
+// TestCompressedDownloadResume checks that the frames that are written to the
+// destination of a download are recorded, and that a resumed download only
+// skips the chunks whose frames were all written.
+func TestCompressedDownloadResume(t *testing.T) {
+	data := compressibleData(int(12 * compressionFrameSize))
+	f, source := newCompressedTestFile(t, data)
+	fh, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	stored, err := compressedData(fh, f, 0, f.size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkSize := f.staticChunkSize()
+	if f.numChunks() < 3 {
+		t.Fatal("expected at least 3 chunks, got", f.numChunks())
+	}
+
+	// Write the first two chunks.
+	d := &download{framesCompleted: make(map[uint64]struct{})}
+	params := compressedDownloadParams(downloadParams{
+		destination: NewDownloadDestinationBuffer(uint64(len(data))),
+		file:        f,
+		length:      uint64(len(data)),
+	}, nil)
+	if len(params.skipChunks) != 0 {
+		t.Fatal("new download skips chunks:", params.skipChunks)
+	}
+	params.destination.(*downloadDestinationDecompressor).download = d
+	written := 2 * chunkSize
+	if _, err := params.destination.WriteAt(stored[:written], 0); err != nil {
+		t.Fatal(err)
+	}
+	var frames []uint64
+	for i := range f.compression.frameOffsets {
+		_, end := f.frameBounds(uint64(i))
+		if _, completed := d.framesCompleted[uint64(i)]; completed != (end <= written) {
+			t.Fatalf("frame %v ending at %v completed: %v", i, end, completed)
+		}
+		if end <= written {
+			frames = append(frames, uint64(i))
+		}
+	}
+
+	// The first chunk only contains completed frames, the last chunk doesn't
+	// contain any.
+	params = compressedDownloadParams(downloadParams{
+		destination: NewDownloadDestinationBuffer(uint64(len(data))),
+		file:        f,
+		length:      uint64(len(data)),
+	}, frames)
+	for i := uint64(0); i < f.numChunks(); i++ {
+		_, end := f.frameBounds(f.storedFrameIndex(min((i+1)*chunkSize, f.size) - 1))
+		if _, skipped := params.skipChunks[i]; skipped != (end <= written) {
+			t.Fatalf("chunk %v skipped: %v", i, skipped)
+		}
+	}
+	if _, skipped := params.skipChunks[0]; !skipped {
+		t.Fatal("first chunk wasn't skipped")
+	} else if _, skipped := params.skipChunks[f.numChunks()-1]; skipped {
+		t.Fatal("last chunk was skipped")
+	}
+}
+
+// TestCompressionStage checks that every chunk of a compressed file is read
+// from its staged data once, and that the staged data is removed afterwards.
+func TestCompressionStage(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	data := compressibleData(int(12 * compressionFrameSize))
+	source := filepath.Join(r.persistDir, "source")
+	if err := ioutil.WriteFile(source, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("file", ec, pieceSize, uint64(len(data)))
+	path, err := r.stageCompressedFile(f, source)
+	if err != nil {
+		t.Fatal(err)
+	} else if f.compression == nil || filepath.Dir(path) != filepath.Join(r.persistDir, compressionStageDir) {
+		t.Fatal("file wasn't staged:", path)
+	}
+	stored, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := r.mu.Lock()
+	r.addCompressionStage(f, path)
+	r.mu.Unlock(id)
+
+	chunkSize := f.staticChunkSize()
+	for i := uint64(0); i < f.numChunks(); i++ {
+		chunk := &unfinishedUploadChunk{
+			renterFile: f,
+			index:      i,
+			offset:     int64(i * chunkSize),
+			length:     chunkSize,
+		}
+		end := min((i+1)*chunkSize, f.size)
+		if !bytes.Equal(r.managedStagedChunkData(chunk), stored[i*chunkSize:end]) {
+			t.Fatal("wrong staged data of chunk", i)
+		}
+		if i+1 < f.numChunks() && r.managedStagedChunkData(chunk) != nil {
+			t.Fatal("chunk was read from the staged data twice")
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("staged data wasn't removed:", err)
+	} else if len(r.compressionStages) != 0 {
+		t.Fatal("stage wasn't released")
+	}
+
+	// Staged data that is left over from before the renter started is
+	// removed.
+	path, err = r.stageCompressedFile(newFile("file", ec, pieceSize, uint64(len(data))), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.removeCompressionStages()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("leftover staged data wasn't removed:", err)
+	}
+}
+
+// TestFileCompressionValidate checks that inconsistent compression metadata
+// is rejected.
+func TestFileCompressionValidate(t *testing.T) {
+	valid := func() *fileCompression {
+		return &fileCompression{
+			codec:        compressionCodecDeflate,
+			frameSize:    10,
+			frameOffsets: []uint64{0, 4, 9},
+			dataSize:     25,
+		}
+	}
+	if err := valid().validate(12); err != nil {
+		t.Fatal(err)
+	}
+	tests := []func(c *fileCompression){
+		func(c *fileCompression) { c.codec = "gzip" },
+		func(c *fileCompression) { c.frameSize = 0 },
+		func(c *fileCompression) { c.dataSize = 31 },
+		func(c *fileCompression) { c.frameOffsets = []uint64{0, 9, 4} },
+		func(c *fileCompression) { c.frameOffsets = []uint64{1, 4, 9} },
+		func(c *fileCompression) { c.frameOffsets = []uint64{0, 4, 12} },
+	}
+	for i, modify := range tests {
+		c := valid()
+		modify(c)
+		if err := c.validate(12); err == nil {
+			t.Error("invalid compression", i, "was accepted")
+		}
+	}
+}
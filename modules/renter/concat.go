@@ -0,0 +1,166 @@
+package renter
+
+// concat.go concatenates uploaded files into a new file without uploading
+// their data again. The chunks of the new file are the chunks of the
+// concatenated files, in order, so every file but the last one has to consist
+// of full chunks, and all of the files have to use the same erasure code and
+// piece size. The pieces of a chunk stay encrypted with the keys of the file
+// that they were uploaded as, so the new file records the master key and the
+// index that the keys of each of its chunks are derived from.
+//
+// The data of packed, deduplicated and compressed files isn't stored in
+// chunks of their own, so they can't be concatenated. The concatenated files
+// are removed from the renter, their pieces belong to the new file.
+
+import (
+	"errors"
+
+	"github.com/NebulousLabs/Sia/crypto"
+)
+
+var (
+	// ErrConcatIncompatible is returned when files are concatenated whose
+	// chunks can't be joined into the chunks of a single file.
+	ErrConcatIncompatible = errors.New("files can't be concatenated without uploading them again")
+
+	// errConcatDuplicate is returned when a file is concatenated more than
+	// once.
+	errConcatDuplicate = errors.New("a file can only be concatenated once")
+)
+
+// chunkKey is the master key and the index that the keys of the pieces of a
+// chunk are derived from.
+type chunkKey struct {
+	MasterKey crypto.TwofishKey
+	Index     uint64
+}
+
+// newConcatFile creates the file that consists of the chunks of files. The
+// files need to be compatible, see concat.go.
+func newConcatFile(siaPath string, files []*file) (*file, error) {
+	first := files[0]
+	nf := newFile(siaPath, first.erasureCode, first.pieceSize, 0)
+	nf.mode = first.mode
+	chunkSize := nf.staticChunkSize()
+	var checksums []crypto.Hash
+	var segmentChecksums [][]crypto.Hash
+	knownChecksums, knownSegments := false, false
+	for i, f := range files {
+		f.mu.RLock()
+		compatible := f.pack == nil && f.blocks == nil && f.compression == nil &&
+			f.pieceSize == first.pieceSize &&
+			f.erasureCode.MinPieces() == first.erasureCode.MinPieces() &&
+			f.erasureCode.NumPieces() == first.erasureCode.NumPieces() &&
+			(i == len(files)-1 || f.size%chunkSize == 0)
+		if !compatible {
+			f.mu.RUnlock()
+			return nil, ErrConcatIncompatible
+		}
+
+		// Empty files don't contribute any chunks.
+		offset := nf.size / chunkSize
+		numChunks := uint64(0)
+		if f.size > 0 {
+			numChunks = f.numChunks()
+		}
+		for index := uint64(0); index < numChunks; index++ {
+			masterKey, keyIndex := f.chunkKey(index)
+			nf.chunkKeys = append(nf.chunkKeys, chunkKey{
+				MasterKey: masterKey,
+				Index:     keyIndex,
+			})
+			checksum := f.chunkChecksum(index)
+			checksums = append(checksums, checksum)
+			knownChecksums = knownChecksums || checksum != (crypto.Hash{})
+			segments := f.chunkSegmentChecksums(index)
+			segmentChecksums = append(segmentChecksums, segments)
+			knownSegments = knownSegments || segments != nil
+			if f.isStuck(index) {
+				nf.setStuck(offset+index, true)
+			}
+		}
+		for id, fc := range f.contracts {
+			nfc, exists := nf.contracts[id]
+			if !exists {
+				nfc = fileContract{
+					ID:          fc.ID,
+					IP:          fc.IP,
+					WindowStart: fc.WindowStart,
+				}
+			}
+			for _, p := range fc.Pieces {
+				if p.Chunk < numChunks {
+					p.Chunk += offset
+					nfc.Pieces = append(nfc.Pieces, p)
+				}
+			}
+			nf.contracts[id] = nfc
+		}
+		nf.size += f.size
+		f.mu.RUnlock()
+	}
+	if nf.size == 0 {
+		return nil, ErrConcatIncompatible
+	}
+	if knownChecksums {
+		nf.chunkChecksums = checksums
+	}
+	if knownSegments {
+		nf.segmentChecksums = segmentChecksums
+	}
+	return nf, nil
+}
+
+// ConcatFiles concatenates the files at siaPaths into a new file at
+// newSiaPath, reusing the pieces that are already stored on the hosts. The
+// concatenated files are removed. ErrConcatIncompatible is returned if the
+// chunks of the files can't be joined, in which case none of the files are
+// changed.
+func (r *Renter) ConcatFiles(siaPaths []string, newSiaPath string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+
+	if err := validateSiapath(newSiaPath); err != nil {
+		return err
+	} else if _, exists := r.files[newSiaPath]; exists {
+		return ErrPathOverload
+	} else if len(siaPaths) == 0 {
+		return ErrConcatIncompatible
+	}
+	files := make([]*file, len(siaPaths))
+	seen := make(map[string]struct{})
+	for i, siaPath := range siaPaths {
+		f, exists := r.files[siaPath]
+		if !exists {
+			return ErrUnknownPath
+		} else if _, exists := seen[siaPath]; exists {
+			return errConcatDuplicate
+		}
+		seen[siaPath] = struct{}{}
+		files[i] = f
+	}
+	nf, err := newConcatFile(newSiaPath, files)
+	if err != nil {
+		return err
+	}
+	if err := r.saveFile(nf); err != nil {
+		return err
+	}
+
+	// The pieces of the concatenated files belong to the new file now, so
+	// the files are removed without releasing their data.
+	for i, f := range files {
+		r.removeFileFromDirs(siaPaths[i], f.dataSize())
+		r.deleteFile(siaPaths[i], f)
+		f.mu.Lock()
+		f.deleted = true
+		f.mu.Unlock()
+	}
+	r.files[newSiaPath] = nf
+	r.addFileToDirs(nf)
+	return r.saveSync()
+}
@@ -0,0 +1,78 @@
+package renter
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestConcatFiles checks that files are concatenated into a file that keeps
+// their pieces and keys, and that incompatible files are left untouched.
+func TestConcatFiles(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	f1 := newTestingFileWithPieces(2, 2)
+	f1.name = "part1"
+	f1.size = f1.staticChunkSize() * 2
+	f2 := newTestingFileWithPieces(1, 2)
+	f2.name = "part2"
+	for _, f := range []*file{f1, f2} {
+		if err := r.saveFile(f); err != nil {
+			t.Fatal(err)
+		}
+		r.files[f.name] = f
+	}
+
+	// Only the last file may end with a partial chunk, and a file can't be
+	// concatenated twice.
+	if err := r.ConcatFiles([]string{"part2", "part1"}, "object"); err != ErrConcatIncompatible {
+		t.Fatal("expected ErrConcatIncompatible, got", err)
+	} else if err := r.ConcatFiles([]string{"part1", "part1"}, "object"); err != errConcatDuplicate {
+		t.Fatal("expected errConcatDuplicate, got", err)
+	} else if r.files["part1"] != f1 || r.files["part2"] != f2 || r.files["object"] != nil {
+		t.Fatal("failed concatenation changed the files")
+	}
+
+	if err := r.ConcatFiles([]string{"part1", "part2"}, "object"); err != nil {
+		t.Fatal(err)
+	}
+	nf := r.files["object"]
+	if nf == nil || r.files["part1"] != nil || r.files["part2"] != nil || !f1.deleted || !f2.deleted {
+		t.Fatal("files weren't replaced by the concatenated file")
+	} else if nf.size != f1.size+f2.size || nf.numChunks() != 3 {
+		t.Fatal("concatenated file has the wrong size:", nf.size)
+	}
+	for i, expected := range []chunkKey{{f1.masterKey, 0}, {f1.masterKey, 1}, {f2.masterKey, 0}} {
+		if masterKey, index := nf.chunkKey(uint64(i)); masterKey != expected.MasterKey || index != expected.Index {
+			t.Fatalf("chunk %v has the wrong key", i)
+		}
+	}
+	for id, fc := range f2.contracts {
+		pieces := nf.contracts[id].Pieces
+		if len(pieces) != len(fc.Pieces) {
+			t.Fatal("concatenated file has the wrong pieces:", pieces)
+		}
+		for j, p := range fc.Pieces {
+			p.Chunk += 2
+			if !reflect.DeepEqual(pieces[j], p) {
+				t.Fatal("pieces of the last file weren't moved to its chunks:", nf.contracts[id].Pieces)
+			}
+		}
+	}
+
+	// The chunk keys are persisted.
+	loaded, err := loadFile(filepath.Join(r.persistDir, metadataPath("object")), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(loaded.chunkKeys, nf.chunkKeys) {
+		t.Fatal("chunk keys weren't persisted")
+	}
+}
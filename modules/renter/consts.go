@@ -53,6 +53,11 @@ var (
 )
 
 const (
+	// defaultDownloadOverdrive is the number of extra pieces that are
+	// requested for a chunk that takes longer than expected, unless the user
+	// changes it.
+	defaultDownloadOverdrive = 2
+
 	// defaultFilePerm defines the default permissions used for a new file if no
 	// permissions are supplied.
 	defaultFilePerm = 0666
@@ -71,12 +76,62 @@ const (
 	// from the /renter/stream endpoint.
 	destinationTypeSeekStream = "httpseekstream"
 
+	// destinationTypePrefetch is the destination type used for downloads of
+	// chunks that a streamer prefetches. Unlike the chunks of
+	// destinationTypeSeekStream downloads, prefetched chunks are not added to
+	// the in-memory chunk cache, since the streamer holds on to them itself.
+	destinationTypePrefetch = "prefetch"
+
+	// destinationTypeRepair is the destination type used for downloads of
+	// chunks that are repaired from the hosts.
+	destinationTypeRepair = "buffer"
+
 	// downloadCacheSize is the cache size of the /renter/stream cache in
 	// chunks.
 	downloadCacheSize = 2
+
+	// maxFileMetadataEntries is the maximum number of entries of the
+	// application metadata of a file.
+	maxFileMetadataEntries = 64
+
+	// maxFileMetadataKeySize and maxFileMetadataValueSize are the maximum
+	// sizes of the keys and values of the application metadata of a file in
+	// bytes. maxFileMetadataSize is the maximum size of all keys and values
+	// together.
+	maxFileMetadataKeySize   = 128
+	maxFileMetadataValueSize = 1024
+	maxFileMetadataSize      = 4096
+
+	// maxWorkerFailureRate caps the failure rate of a worker when estimating
+	// how long its downloads take, so that failing workers still rank by
+	// their speed.
+	maxWorkerFailureRate = 0.9
+
+	// repairThresholdDivisor determines the repair threshold of a chunk. A
+	// repair that uploads at least the minimum pieces of a chunk plus
+	// 1/repairThresholdDivisor of its parity pieces counts as successful,
+	// even if the chunk didn't reach full redundancy.
+	repairThresholdDivisor = 2
+
+	// overdriveTimeoutMultiplier is the multiple of the expected download time
+	// of its slowest worker that a chunk may take before overdrive kicks in.
+	overdriveTimeoutMultiplier = 2
+
+	// workerStatsWindow is the number of recent downloads that the rolling
+	// statistics of a worker roughly cover.
+	workerStatsWindow = 20
 )
 
 var (
+	// checksumSegmentSize is the size of the segments of the data pieces of a
+	// chunk whose checksums are recorded. A partial download fetches and
+	// verifies whole segments.
+	checksumSegmentSize = build.Select(build.Var{
+		Dev:      uint64(1 << 16), // 64 KiB
+		Standard: uint64(1 << 18), // 256 KiB
+		Testing:  uint64(1 << 9),  // 512 B
+	}).(uint64)
+
 	// chunkDownloadTimeout defines the maximum amount of time to wait for a
 	// chunk download to finish before returning in the download-to-upload repair
 	// loop
@@ -86,6 +141,22 @@ var (
 		Testing:  1 * time.Minute,
 	}).(time.Duration)
 
+	// defaultOverdriveTimeout is how long a chunk may take before overdrive
+	// kicks in if the download time of its workers can't be estimated yet.
+	defaultOverdriveTimeout = build.Select(build.Var{
+		Dev:      5 * time.Second,
+		Standard: 10 * time.Second,
+		Testing:  time.Second,
+	}).(time.Duration)
+
+	// defaultTrashRetention is how long deleted files are kept in the trash
+	// by default.
+	defaultTrashRetention = build.Select(build.Var{
+		Dev:      1 * time.Hour,
+		Standard: 7 * 24 * time.Hour,
+		Testing:  1 * time.Hour,
+	}).(time.Duration)
+
 	// maxConsecutivePenalty determines how many times the timeout/cooldown for
 	// being a bad host can be doubled before a maximum cooldown is reached.
 	maxConsecutivePenalty = build.Select(build.Var{
@@ -94,6 +165,14 @@ var (
 		Testing:  3,
 	}).(int)
 
+	// maxRepairAttempts is the number of consecutive failed repairs after
+	// which a chunk is marked as stuck.
+	maxRepairAttempts = build.Select(build.Var{
+		Dev:      3,
+		Standard: 5,
+		Testing:  3,
+	}).(int)
+
 	// maxScheduledDownloads specifies the number of chunks that can be downloaded
 	// for auto repair at once. If the limit is reached new ones will only be scheduled
 	// once old ones are scheduled for upload
@@ -103,6 +182,14 @@ var (
 		Testing:  5,
 	}).(int)
 
+	// maxStreamReadAhead is the maximum number of chunks that a streamer
+	// prefetches ahead of sequential reads.
+	maxStreamReadAhead = build.Select(build.Var{
+		Dev:      4,
+		Standard: 4,
+		Testing:  2,
+	}).(int)
+
 	// offlineCheckFrequency is how long the renter will wait to check the
 	// online status if it is offline.
 	offlineCheckFrequency = build.Select(build.Var{
@@ -111,6 +198,14 @@ var (
 		Testing:  250 * time.Millisecond,
 	}).(time.Duration)
 
+	// packFlushInterval is how long a pack stays open to new files before it
+	// is sealed and uploaded.
+	packFlushInterval = build.Select(build.Var{
+		Dev:      10 * time.Second,
+		Standard: 1 * time.Minute,
+		Testing:  1 * time.Second,
+	}).(time.Duration)
+
 	// rebuildChunkHeapInterval defines how long the renter sleeps between
 	// checking on the filesystem health.
 	rebuildChunkHeapInterval = build.Select(build.Var{
@@ -127,6 +222,76 @@ var (
 		Testing:  0.25,
 	}).(float64)
 
+	// saveInterval is how often the renter saves the changes of its
+	// persistence that are not saved right away.
+	saveInterval = build.Select(build.Var{
+		Dev:      1 * time.Minute,
+		Standard: 10 * time.Minute,
+		Testing:  1 * time.Second,
+	}).(time.Duration)
+
+	// stuckChunkRetryInterval defines how long the renter waits between
+	// attempts to repair the chunks that are stuck.
+	stuckChunkRetryInterval = build.Select(build.Var{
+		Dev:      5 * time.Minute,
+		Standard: 1 * time.Hour,
+		Testing:  5 * time.Second,
+	}).(time.Duration)
+
+	// syncMaxDeleteShare is the largest share of the files of a synced folder
+	// that a scan may delete. The deletions of a scan that would delete more
+	// files are withheld, since they more likely follow a mistake than a
+	// deliberate deletion.
+	syncMaxDeleteShare = build.Select(build.Var{
+		Dev:      0.5,
+		Standard: 0.5,
+		Testing:  0.5,
+	}).(float64)
+
+	// syncMinGuardedFiles is the number of indexed files a synced folder
+	// needs before the deletions of its scans are checked against
+	// syncMaxDeleteShare and an empty local folder. Deleting some of the
+	// files of a small folder is ordinary use.
+	syncMinGuardedFiles = build.Select(build.Var{
+		Dev:      10,
+		Standard: 10,
+		Testing:  4,
+	}).(int)
+
+	// syncMaxTransfers is the number of files of a synced folder that are
+	// uploaded or downloaded at once.
+	syncMaxTransfers = build.Select(build.Var{
+		Dev:      4,
+		Standard: 4,
+		Testing:  2,
+	}).(int)
+
+	// syncRescanInterval is how often synced folders are scanned for the
+	// changes that their watchers can't report, including all changes below
+	// their siapath prefixes.
+	syncRescanInterval = build.Select(build.Var{
+		Dev:      30 * time.Second,
+		Standard: 5 * time.Minute,
+		Testing:  1 * time.Second,
+	}).(time.Duration)
+
+	// syncSettleTime is how long a synced folder has to go without changes
+	// before it is scanned, so that files aren't synced while they are being
+	// written.
+	syncSettleTime = build.Select(build.Var{
+		Dev:      1 * time.Second,
+		Standard: 2 * time.Second,
+		Testing:  100 * time.Millisecond,
+	}).(time.Duration)
+
+	// trashPurgeInterval is how often the renter purges the files whose
+	// retention in the trash has run out.
+	trashPurgeInterval = build.Select(build.Var{
+		Dev:      1 * time.Minute,
+		Standard: 1 * time.Hour,
+		Testing:  1 * time.Second,
+	}).(time.Duration)
+
 	// Prime to avoid intersecting with regular events.
 	uploadFailureCooldown = build.Select(build.Var{
 		Dev:      time.Second * 7,
@@ -63,6 +63,10 @@ type Contractor struct {
 	staticContracts *proto.ContractSet
 	oldContracts    map[types.FileContractID]modules.RenterContract
 	renewedIDs      map[types.FileContractID]types.FileContractID
+
+	// lostContracts contains the restored contracts that the host has a more
+	// recent revision of. They can't be revised anymore.
+	lostContracts map[types.FileContractID]struct{}
 }
 
 // readlockResolveID returns the ID of the most recent renewal of id.
@@ -136,6 +140,100 @@ func (c *Contractor) Contracts() []modules.RenterContract {
 	return c.staticContracts.ViewAll()
 }
 
+// ContractBackup returns a backup of the contract with the specified ID.
+func (c *Contractor) ContractBackup(id types.FileContractID) (proto.ContractBackup, bool, error) {
+	return c.staticContracts.Backup(c.ResolveID(id))
+}
+
+// ContractBackups returns backups of the contracts formed by the contractor in
+// the current allowance period.
+func (c *Contractor) ContractBackups() ([]proto.ContractBackup, error) {
+	var backups []proto.ContractBackup
+	for _, id := range c.staticContracts.IDs() {
+		backup, exists, err := c.staticContracts.Backup(id)
+		if err != nil {
+			return nil, err
+		} else if exists {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
+
+// RestoreContracts adds the contracts in the provided backups to the
+// contractor. Contracts that have ended or that the contractor already knows
+// about are ignored. A restored contract that was revised after the backup was
+// created can't be revised anymore, since the host only accepts revisions of
+// its most recent revision. Such contracts are marked as lost, which makes
+// them neither good for upload nor for renewal.
+func (c *Contractor) RestoreContracts(backups []proto.ContractBackup) error {
+	for _, backup := range backups {
+		id := backup.ID()
+		c.mu.RLock()
+		_, old := c.oldContracts[id]
+		_, renewed := c.renewedIDs[id]
+		ended := backup.EndHeight() <= c.blockHeight
+		c.mu.RUnlock()
+		_, known := c.staticContracts.View(id)
+		if old || renewed || ended || known {
+			continue
+		}
+		if err := c.staticContracts.Restore(backup); err != nil {
+			return err
+		}
+
+		// Opening a downloader compares the revision of the contract with
+		// the host's revision. Contracts whose host can't be reached are
+		// assumed to be up to date.
+		d, err := c.Downloader(id, c.tg.StopChan())
+		if proto.IsRevisionMismatch(err) {
+			c.log.Println("WARN: restored contract is outdated and can't be used anymore:", id)
+			if err := c.managedMarkContractLost(id); err != nil {
+				return err
+			}
+		} else if err == nil {
+			d.Close()
+		}
+	}
+	return nil
+}
+
+// managedMarkContractLost marks a contract as lost and takes away its
+// utility.
+func (c *Contractor) managedMarkContractLost(id types.FileContractID) error {
+	c.mu.Lock()
+	c.lostContracts[id] = struct{}{}
+	err := c.saveSync()
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.managedUpdateContractUtility(id, modules.ContractUtility{})
+}
+
+// FormContract forms a contract with the host that has the specified public
+// key. Unlike the contracts formed for the allowance, the contract is not used
+// for uploads and is not renewed. It lets the renter download data from hosts
+// that it has no other contract with, e.g. to recover a backup.
+func (c *Contractor) FormContract(hostKey types.SiaPublicKey, funding types.Currency, endHeight types.BlockHeight) (modules.RenterContract, error) {
+	if err := c.tg.Add(); err != nil {
+		return modules.RenterContract{}, err
+	}
+	defer c.tg.Done()
+	host, ok := c.hdb.Host(hostKey)
+	if !ok {
+		return modules.RenterContract{}, errors.New("no record of that host")
+	}
+	contract, err := c.managedNewContract(host, funding, endHeight)
+	if err != nil {
+		return modules.RenterContract{}, err
+	}
+	c.mu.Lock()
+	err = c.saveSync()
+	c.mu.Unlock()
+	return contract, err
+}
+
 // ContractUtility returns the utility fields for the given contract.
 func (c *Contractor) ContractUtility(id types.FileContractID) (modules.ContractUtility, bool) {
 	return c.managedContractUtility(id)
@@ -234,6 +332,7 @@ func NewCustomContractor(cs consensusSet, w wallet, tp transactionPool, hdb host
 		renewedIDs:      make(map[types.FileContractID]types.FileContractID),
 		renewing:        make(map[types.FileContractID]bool),
 		revising:        make(map[types.FileContractID]bool),
+		lostContracts:   make(map[types.FileContractID]struct{}),
 	}
 
 	// Close the contract set and logger upon shutdown.
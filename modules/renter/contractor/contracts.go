@@ -103,6 +103,16 @@ func (c *Contractor) managedMarkContractsUtility() error {
 			u.GoodForUpload = true
 			u.GoodForRenew = true
 
+			// Contract has no utility if it can't be revised anymore.
+			c.mu.RLock()
+			_, lost := c.lostContracts[contract.ID]
+			c.mu.RUnlock()
+			if lost {
+				u.GoodForUpload = false
+				u.GoodForRenew = false
+				return
+			}
+
 			host, exists := c.hdb.Host(contract.HostPublicKey)
 			// Contract has no utility if the host is not in the database.
 			if !exists {
@@ -3,6 +3,7 @@ package contractor
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
@@ -21,6 +22,16 @@ type Downloader interface {
 	// retrieve.
 	Sector(root crypto.Hash) ([]byte, error)
 
+	// Ranges retrieves the requested segment-aligned ranges of sectors, and
+	// revises the underlying contract to pay the host proportionally to the
+	// data retrieved.
+	Ranges(actions []modules.DownloadAction) ([][]byte, error)
+
+	// TimeToFirstByte returns the time between sending the request of the
+	// last successful download to the host and receiving the first byte of
+	// its data.
+	TimeToFirstByte() time.Duration
+
 	// Close terminates the connection to the host.
 	Close() error
 }
@@ -101,6 +112,32 @@ func (hd *hostDownloader) Sector(root crypto.Hash) ([]byte, error) {
 	return sector, nil
 }
 
+// Ranges retrieves the requested segment-aligned ranges of sectors, and
+// revises the underlying contract to pay the host proportionally to the data
+// retrieved.
+func (hd *hostDownloader) Ranges(actions []modules.DownloadAction) ([][]byte, error) {
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+	if hd.invalid {
+		return nil, errInvalidDownloader
+	}
+
+	// Download the ranges.
+	_, data, err := hd.downloader.Ranges(actions)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// TimeToFirstByte returns the time between sending the request of the last
+// successful download to the host and receiving the first byte of its data.
+func (hd *hostDownloader) TimeToFirstByte() time.Duration {
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+	return hd.downloader.TimeToFirstByte()
+}
+
 // Downloader returns a Downloader object that can be used to download sectors
 // from a host.
 func (c *Contractor) Downloader(id types.FileContractID, cancel <-chan struct{}) (_ Downloader, err error) {
@@ -23,6 +23,10 @@ type Editor interface {
 	// returns the Merkle root of the data.
 	Upload(data []byte) (root crypto.Hash, err error)
 
+	// Delete revises the underlying contract to remove the sectors with
+	// the specified Merkle roots.
+	Delete(roots []crypto.Hash) error
+
 	// Address returns the address of the host.
 	Address() modules.NetAddress
 
@@ -113,6 +117,17 @@ func (he *hostEditor) Upload(data []byte) (_ crypto.Hash, err error) {
 	return sectorRoot, nil
 }
 
+// Delete negotiates a revision that removes sectors from a file contract.
+func (he *hostEditor) Delete(roots []crypto.Hash) error {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	if he.invalid {
+		return errInvalidEditor
+	}
+	_, err := he.editor.Delete(roots)
+	return err
+}
+
 // Editor returns a Editor object that can be used to upload, modify, and
 // delete sectors on a host.
 func (c *Contractor) Editor(id types.FileContractID, cancel <-chan struct{}) (_ Editor, err error) {
@@ -312,6 +312,154 @@ func TestIntegrationUploadDownload(t *testing.T) {
 	}
 }
 
+// TestIntegrationDownloadRanges tests that the contractor can download
+// segment-aligned ranges of a sector and pays only for the downloaded bytes.
+func TestIntegrationDownloadRanges(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+	// create testing trio
+	h, c, _, err := newTestingTrio(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	defer c.Close()
+
+	// get the host's entry from the db
+	hostEntry, ok := c.hdb.Host(h.PublicKey())
+	if !ok {
+		t.Fatal("no entry for host in db")
+	}
+
+	// form a contract with the host and upload a sector
+	contract, err := c.managedNewContract(hostEntry, types.SiacoinPrecision.Mul64(50), c.blockHeight+100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor, err := c.Editor(contract.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(int(modules.SectorSize))
+	root, err := editor.Upload(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// download a few ranges
+	downloader, err := c.Downloader(contract.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer downloader.Close()
+	before, _ := c.ContractByID(contract.ID)
+	actions := []modules.DownloadAction{
+		{MerkleRoot: root, Offset: 0, Length: crypto.SegmentSize},
+		{MerkleRoot: root, Offset: 10 * crypto.SegmentSize, Length: 3 * crypto.SegmentSize},
+		{MerkleRoot: root, Offset: modules.SectorSize - crypto.SegmentSize, Length: crypto.SegmentSize},
+	}
+	ranges, err := downloader.Ranges(actions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, action := range actions {
+		if !bytes.Equal(ranges[i], data[action.Offset:action.Offset+action.Length]) {
+			t.Fatal("downloaded range does not match original", i)
+		}
+	}
+	after, _ := c.ContractByID(contract.ID)
+	if spent := after.DownloadSpending.Sub(before.DownloadSpending); spent.Cmp(hostEntry.DownloadBandwidthPrice.Mul64(modules.SectorSize)) >= 0 {
+		t.Fatal("paid for a whole sector:", spent)
+	}
+
+	// unaligned ranges are rejected
+	_, err = downloader.Ranges([]modules.DownloadAction{{MerkleRoot: root, Offset: 1, Length: crypto.SegmentSize}})
+	if err == nil {
+		t.Fatal("expected unaligned range to be rejected")
+	}
+}
+
+// TestIntegrationDeleteSectors tests that the contractor can delete sectors
+// from a contract and keep revising it afterwards.
+func TestIntegrationDeleteSectors(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+	// create testing trio
+	h, c, _, err := newTestingTrio(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	defer c.Close()
+
+	// get the host's entry from the db
+	hostEntry, ok := c.hdb.Host(h.PublicKey())
+	if !ok {
+		t.Fatal("no entry for host in db")
+	}
+
+	// form a contract with the host and upload a few sectors
+	contract, err := c.managedNewContract(hostEntry, types.SiacoinPrecision.Mul64(50), c.blockHeight+100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor, err := c.Editor(contract.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots []crypto.Hash
+	for i := 0; i < 3; i++ {
+		root, err := editor.Upload(fastrand.Bytes(int(modules.SectorSize)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+
+	// delete the first two sectors and upload another one. The upload only
+	// succeeds if the renter and the host agree on the remaining sectors.
+	if err := editor.Delete(roots[:2]); err != nil {
+		t.Fatal(err)
+	}
+	root, err := editor.Upload(fastrand.Bytes(int(modules.SectorSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backup, _, err := c.ContractBackup(contract.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.Roots) != 2 || backup.Roots[0] != roots[2] || backup.Roots[1] != root {
+		t.Fatal("contract has the wrong roots after deleting sectors:", backup.Roots)
+	}
+	if size := backup.Header.LastRevision().NewFileSize; size != 2*modules.SectorSize {
+		t.Fatal("contract has the wrong size after deleting sectors:", size)
+	}
+
+	// the deleted sectors can't be downloaded anymore
+	downloader, err := c.Downloader(contract.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer downloader.Close()
+	if _, err := downloader.Sector(roots[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := downloader.Sector(roots[0]); err == nil {
+		t.Fatal("deleted sector was downloaded")
+	}
+}
+
 // TestIntegrationRenew tests that the contractor can renew a previously-
 // formed file contract.
 func TestIntegrationRenew(t *testing.T) {
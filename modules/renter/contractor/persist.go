@@ -21,6 +21,7 @@ type contractorPersist struct {
 	LastChange    modules.ConsensusChangeID `json:"lastchange"`
 	OldContracts  []modules.RenterContract  `json:"oldcontracts"`
 	RenewedIDs    map[string]string         `json:"renewedids"`
+	LostContracts []types.FileContractID    `json:"lostcontracts"`
 }
 
 // persistData returns the data in the Contractor that will be saved to disk.
@@ -38,6 +39,9 @@ func (c *Contractor) persistData() contractorPersist {
 	for oldID, newID := range c.renewedIDs {
 		data.RenewedIDs[oldID.String()] = newID.String()
 	}
+	for id := range c.lostContracts {
+		data.LostContracts = append(data.LostContracts, id)
+	}
 	return data
 }
 
@@ -61,6 +65,9 @@ func (c *Contractor) load() error {
 		newHash.LoadString(newString)
 		c.renewedIDs[types.FileContractID(oldHash)] = types.FileContractID(newHash)
 	}
+	for _, id := range data.LostContracts {
+		c.lostContracts[id] = struct{}{}
+	}
 
 	return nil
 }
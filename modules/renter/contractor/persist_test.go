@@ -35,6 +35,9 @@ func TestSaveLoad(t *testing.T) {
 		{1}: {ID: types.FileContractID{1}, HostPublicKey: types.SiaPublicKey{Key: []byte("bar")}},
 		{2}: {ID: types.FileContractID{2}, HostPublicKey: types.SiaPublicKey{Key: []byte("baz")}},
 	}
+	c.lostContracts = map[types.FileContractID]struct{}{
+		{3}: {},
+	}
 
 	// save, clear, and reload
 	err := c.save()
@@ -44,6 +47,7 @@ func TestSaveLoad(t *testing.T) {
 	c.hdb = stubHostDB{}
 	c.renewedIDs = make(map[types.FileContractID]types.FileContractID)
 	c.oldContracts = make(map[types.FileContractID]modules.RenterContract)
+	c.lostContracts = make(map[types.FileContractID]struct{})
 	err = c.load()
 	if err != nil {
 		t.Fatal(err)
@@ -61,6 +65,9 @@ func TestSaveLoad(t *testing.T) {
 	if !ok0 || !ok1 || !ok2 {
 		t.Fatal("oldContracts were not restored properly:", c.oldContracts)
 	}
+	if _, ok := c.lostContracts[types.FileContractID{3}]; !ok {
+		t.Fatal("lostContracts were not restored properly:", c.lostContracts)
+	}
 
 	// use stdPersist instead of mock
 	c.persist = NewPersist(build.TempDir("contractor", t.Name()))
@@ -73,6 +80,7 @@ func TestSaveLoad(t *testing.T) {
 	}
 	c.renewedIDs = make(map[types.FileContractID]types.FileContractID)
 	c.oldContracts = make(map[types.FileContractID]modules.RenterContract)
+	c.lostContracts = make(map[types.FileContractID]struct{})
 	err = c.load()
 	if err != nil {
 		t.Fatal(err)
@@ -90,6 +98,9 @@ func TestSaveLoad(t *testing.T) {
 	if !ok0 || !ok1 || !ok2 {
 		t.Fatal("oldContracts were not restored properly:", c.oldContracts)
 	}
+	if _, ok := c.lostContracts[types.FileContractID{3}]; !ok {
+		t.Fatal("lostContracts were not restored properly:", c.lostContracts)
+	}
 }
 
 // TestConvertPersist tests that contracts previously stored in the
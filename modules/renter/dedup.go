@@ -0,0 +1,263 @@
+package renter
+
+// dedup.go deduplicates the chunks of files that are uploaded with convergent
+// chunking. The data of a deduplicated file is not stored in chunks of its
+// own. Instead, every chunk of the file is stored in a block. A block is an
+// internal file that consists of a single chunk, like a pack, and it is keyed
+// by the hash of its plaintext. Chunks with identical plaintext share a block,
+// so a chunk that was uploaded before, as part of the same file or of another
+// file, reuses the pieces that are already stored on the hosts.
+//
+// The pieces of regular files are encrypted with keys derived from a random
+// master key and the index of their chunk, which would give identical chunks
+// different keys. The key of a block is derived from the hash of its plaintext
+// instead, so identical chunks always map to the same key and the same block.
+// A secret of the renter is mixed into the key, which prevents anyone that
+// doesn't know the secret from confirming that the renter stores a known chunk
+// by encrypting the chunk themselves. Blocks are named after the hash of their
+// key, so the name of a block doesn't reveal the hash of its plaintext.
+//
+// Blocks are reference counted by the chunks of the files that reference
+// them. Deleting a file drops its references, and a block is only deleted once
+// no chunk references it anymore. A block is repaired if any of the files that
+// reference it is tracked, using the local copy of one of those files. Since
+// the local copy might have changed after it was uploaded, the data read from
+// it is checked against the checksum of the block before it's used, or against
+// the key of the block if its checksum is unknown.
+//
+// TODO: The sectors of freed blocks are not removed from the hosts, just like
+// the sectors of deleted files.
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+	"github.com/NebulousLabs/fastrand"
+)
+
+const (
+	// dedupDir is the directory within the renter directory that contains the
+	// blocks of deduplicated files. Siapaths within dedupDir are reserved.
+	dedupDir = ".dedup"
+)
+
+var (
+	// dedupKeySpecifier is used to derive the keys of blocks.
+	dedupKeySpecifier = types.Specifier{'d', 'e', 'd', 'u', 'p', ' ', 'b', 'l', 'o', 'c', 'k'}
+
+	// errMissingDedupBlock is returned when a block of a deduplicated file
+	// can't be found.
+	errMissingDedupBlock = errors.New("block of deduplicated file is missing")
+)
+
+// deriveDedupKey derives the key of the block that stores a chunk with the
+// plaintext hash dataHash. It replaces the random master key of regular files
+// for deduplicated chunks: the key only depends on the renter's secret, on the
+// content of the chunk and on how the chunk is encoded. The keys of the pieces
+// of a block are derived from the block's key by deriveKey, like the keys of
+// any other single-chunk file.
+func deriveDedupKey(secret crypto.Hash, ec modules.ErasureCoder, pieceSize uint64, dataHash crypto.Hash) crypto.TwofishKey {
+	return crypto.TwofishKey(crypto.HashAll(dedupKeySpecifier, secret, packKey(ec), pieceSize, dataHash))
+}
+
+// dedupBlockID returns the ID of the block with the provided key. Files refer
+// to their blocks by ID in their metadata.
+func dedupBlockID(key crypto.TwofishKey) crypto.Hash {
+	return crypto.HashObject(key)
+}
+
+// dedupBlockName returns the name of the block with the provided ID.
+func dedupBlockName(id crypto.Hash) string {
+	return dedupDir + "/" + id.String()
+}
+
+// newDedupBlock creates a new block with the provided key that stores a chunk
+// with the provided size, checksum and segment checksums.
+func newDedupBlock(key crypto.TwofishKey, ec modules.ErasureCoder, size uint64, checksum crypto.Hash, segments []crypto.Hash) *file {
+	block := newFile(dedupBlockName(dedupBlockID(key)), ec, pieceSize, size)
+	block.masterKey = key
+	block.dedupBlock = true
+	block.chunkChecksums = []crypto.Hash{checksum}
+	if segments != nil {
+		block.segmentChecksums = [][]crypto.Hash{segments}
+	}
+	return block
+}
+
+// addDedupFile adds the references of a deduplicated file to its blocks.
+// Blocks that are new to the renter are saved and added to the renter as
+// well.
+func (r *Renter) addDedupFile(f *file) error {
+	for i, block := range f.blocks {
+		if _, exists := r.dedupBlocks[block.name]; !exists {
+			if err := r.saveFile(block); err != nil {
+				r.releaseDedupFile(f)
+				return err
+			}
+			r.dedupBlocks[block.name] = block
+		}
+		if block.dedupRefs == nil {
+			block.dedupRefs = make(map[*file][]uint64)
+		}
+		block.dedupRefs[f] = append(block.dedupRefs[f], uint64(i))
+	}
+	return nil
+}
+
+// releaseDedupFile drops the references of a deleted deduplicated file to its
+// blocks. Blocks that aren't referenced by any chunk anymore are deleted.
+func (r *Renter) releaseDedupFile(f *file) {
+	for _, block := range f.blocks {
+		if _, exists := block.dedupRefs[f]; !exists {
+			continue
+		}
+		delete(block.dedupRefs, f)
+		if len(block.dedupRefs) == 0 {
+			r.deleteDedupBlock(block)
+		}
+	}
+}
+
+// dedupRefCount returns the number of chunks that reference the block.
+func (f *file) dedupRefCount() (n int) {
+	for _, indices := range f.dedupRefs {
+		n += len(indices)
+	}
+	return n
+}
+
+// deleteDedupBlock removes a block from the renter and deletes its metadata.
+func (r *Renter) deleteDedupBlock(block *file) {
+	delete(r.dedupBlocks, block.name)
+	err := persist.RemoveFile(filepath.Join(r.persistDir, metadataPath(block.name)))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove block:", err)
+	}
+
+	block.mu.Lock()
+	block.deleted = true
+	block.mu.Unlock()
+}
+
+// dedupBlocksByName returns a copy of the blocks of the renter. Blocks are
+// identified by their name when they are read from a share file or a backup.
+func (r *Renter) dedupBlocksByName() map[string]*file {
+	blocks := make(map[string]*file, len(r.dedupBlocks))
+	for name, block := range r.dedupBlocks {
+		blocks[name] = block
+	}
+	return blocks
+}
+
+// dedupRepairSource returns the local copy that a block is repaired from and
+// the offset of the block's data within it. A block is only repaired if one of
+// the files that reference it is tracked.
+func (r *Renter) dedupRepairSource(block *file) (localPath string, offset int64, tracked bool) {
+	for f, indices := range block.dedupRefs {
+		tf, exists := r.tracking[f.name]
+		if !exists {
+			continue
+		}
+		tracked = true
+		localPath, offset = tf.RepairPath, int64(indices[0]*f.staticChunkSize())
+		if localPath != "" {
+			break
+		}
+	}
+	return localPath, offset, tracked
+}
+
+// dedupPriority returns the upload priority of a block and whether its upload
+// is paused. A block is uploaded with the highest priority of the files that
+// reference it, and it is only paused if all of those files are paused.
+func (r *Renter) dedupPriority(block *file) (priority uint64, paused bool) {
+	paused = true
+	for f := range block.dedupRefs {
+		f.mu.RLock()
+		if !f.paused {
+			paused = false
+			if f.priority > priority {
+				priority = f.priority
+			}
+		}
+		f.mu.RUnlock()
+	}
+	return priority, paused && len(block.dedupRefs) > 0
+}
+
+// managedVerifyDedupData returns true if data, which was read from the local
+// copy of a block, still matches the block.
+func (r *Renter) managedVerifyDedupData(block *file, data [][]byte) bool {
+	hash := checksumChunkData(data, block.size)
+	id := r.mu.RLock()
+	secret := r.dedupSecret
+	r.mu.RUnlock(id)
+	return deriveDedupKey(secret, block.erasureCode, block.pieceSize, hash) == block.masterKey
+}
+
+// loadDedupBlocks loads the metadata of all blocks. The blocks need to be
+// loaded before the files that reference them.
+func (r *Renter) loadDedupBlocks() error {
+	paths, err := filepath.Glob(filepath.Join(r.persistDir, dedupDir, "*"+ShareExtension))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		block, err := loadFile(path, nil, nil)
+		if err != nil {
+			r.log.Println("ERROR: could not load block:", err)
+			continue
+		}
+		block.dedupBlock = true
+		r.dedupBlocks[block.name] = block
+	}
+	return nil
+}
+
+// managedUploadDeduplicated adds a file to the renter whose chunks are stored
+// in blocks. hashes contains the checksums of the file's chunks, which
+// determine the blocks of the chunks, and segments the checksums of their
+// segments. Only the blocks that the renter doesn't have yet are uploaded.
+func (r *Renter) managedUploadDeduplicated(f *file, source string, hashes []crypto.Hash, segments [][]crypto.Hash) error {
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if _, exists := r.files[f.name]; exists {
+		return ErrPathOverload
+	}
+	if r.dedupSecret == (crypto.Hash{}) {
+		fastrand.Read(r.dedupSecret[:])
+		if err := r.saveSync(); err != nil {
+			return err
+		}
+	}
+
+	// Chunks with the same hash share a block, even within the file.
+	blocks := r.dedupBlocksByName()
+	f.blocks = make([]*file, len(hashes))
+	for i, hash := range hashes {
+		key := deriveDedupKey(r.dedupSecret, f.erasureCode, f.pieceSize, hash)
+		block, exists := blocks[dedupBlockName(dedupBlockID(key))]
+		if !exists {
+			block = newDedupBlock(key, f.erasureCode, f.chunkDataSize(uint64(i)), hash, segments[i])
+			blocks[block.name] = block
+		}
+		f.blocks[i] = block
+	}
+	if err := r.addDedupFile(f); err != nil {
+		return err
+	}
+	if err := r.saveFile(f); err != nil {
+		r.releaseDedupFile(f)
+		return err
+	}
+	r.files[f.name] = f
+	r.tracking[f.name] = trackedFile{
+		RepairPath: source,
+	}
+	r.addFileToDirs(f)
+	return r.saveSync()
+}
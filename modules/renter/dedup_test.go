@@ -0,0 +1,252 @@
+package renter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestDeriveDedupKey checks that the key of a block only depends on the
+// secret, the encoding and the content of the chunk.
+func TestDeriveDedupKey(t *testing.T) {
+	ec1, _ := NewRSCode(1, 1)
+	ec2, _ := NewRSCode(1, 2)
+	var secret crypto.Hash
+	fastrand.Read(secret[:])
+	hash := crypto.HashBytes(fastrand.Bytes(100))
+
+	key := deriveDedupKey(secret, ec1, pieceSize, hash)
+	if deriveDedupKey(secret, ec1, pieceSize, hash) != key {
+		t.Error("same chunk got a different key")
+	}
+	var otherSecret crypto.Hash
+	fastrand.Read(otherSecret[:])
+	for _, other := range []crypto.TwofishKey{
+		deriveDedupKey(otherSecret, ec1, pieceSize, hash),
+		deriveDedupKey(secret, ec2, pieceSize, hash),
+		deriveDedupKey(secret, ec1, pieceSize/2, hash),
+		deriveDedupKey(secret, ec1, pieceSize, crypto.HashBytes(fastrand.Bytes(100))),
+	} {
+		if other == key {
+			t.Error("different chunk got the same key")
+		}
+	}
+}
+
+// TestDedupFiles checks that identical chunks of deduplicated files share a
+// block, and that blocks are only freed once no chunk references them.
+func TestDedupFiles(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// Purge deleted files right away, so that they release their blocks.
+	r.trashRetention = 0
+
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	chunkSize := int(pieceSize) * ec.MinPieces()
+	x, y, z := fastrand.Bytes(chunkSize), fastrand.Bytes(chunkSize), fastrand.Bytes(chunkSize/2)
+
+	// Upload a file with a repeated chunk and a file that shares a chunk with
+	// the first file.
+	if err := uploadTestFile(r, dir, bytes.Join([][]byte{x, y, x}, nil), modules.FileUploadParams{SiaPath: "a", ErasureCode: ec, Dedup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadTestFile(r, dir, bytes.Join([][]byte{y, z}, nil), modules.FileUploadParams{SiaPath: "b", ErasureCode: ec, Dedup: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := r.mu.Lock()
+	a, b := r.files["a"], r.files["b"]
+	if len(a.blocks) != 3 || len(b.blocks) != 2 || len(r.dedupBlocks) != 3 {
+		t.Fatal("wrong number of blocks:", len(a.blocks), len(b.blocks), len(r.dedupBlocks))
+	}
+	blockX, blockY, blockZ := a.blocks[0], a.blocks[1], b.blocks[1]
+	if a.blocks[2] != blockX || b.blocks[0] != blockY {
+		t.Fatal("identical chunks don't share a block")
+	}
+	if blockX.size != uint64(chunkSize) || blockZ.size != uint64(len(z)) {
+		t.Fatal("blocks have the wrong size:", blockX.size, blockZ.size)
+	}
+	if blockX.dedupRefCount() != 2 || blockY.dedupRefCount() != 2 || blockZ.dedupRefCount() != 1 {
+		t.Fatal("wrong reference counts:", blockX.dedupRefCount(), blockY.dedupRefCount(), blockZ.dedupRefCount())
+	}
+	localPath, offset, tracked := r.dedupRepairSource(blockZ)
+	if !tracked || localPath != filepath.Join(dir, "b") || offset != int64(chunkSize) {
+		t.Fatal("wrong repair source of block:", localPath, offset, tracked)
+	}
+	r.mu.Unlock(id)
+	if info, err := r.File("a"); err != nil {
+		t.Fatal(err)
+	} else if !info.Deduplicated || info.Filesize != uint64(3*chunkSize) {
+		t.Fatal("wrong file info:", info)
+	}
+
+	// Deleting the first file only frees the block that isn't shared with the
+	// second file.
+	if err := r.DeleteFile("a"); err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	_, existsX := r.dedupBlocks[blockX.name]
+	_, existsY := r.dedupBlocks[blockY.name]
+	refsY := blockY.dedupRefCount()
+	r.mu.Unlock(id)
+	if existsX || !existsY || refsY != 1 {
+		t.Fatal("wrong blocks after deleting a file:", existsX, existsY, refsY)
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(blockX.name))); !os.IsNotExist(err) {
+		t.Fatal("metadata of freed block was not deleted:", err)
+	}
+
+	// The blocks are referenced again after the renter is reloaded.
+	id = r.mu.Lock()
+	r.files = make(map[string]*file)
+	r.dedupBlocks = make(map[string]*file)
+	err = r.load()
+	b = r.files["b"]
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || len(b.blocks) != 2 || len(r.dedupBlocks) != 2 {
+		t.Fatal("deduplicated file was not loaded correctly")
+	}
+	if b.blocks[0].name != blockY.name || b.blocks[1].masterKey != blockZ.masterKey || !b.blocks[1].dedupBlock {
+		t.Fatal("loaded file doesn't reference its blocks")
+	}
+
+	// Deleting the last file frees all blocks.
+	if err := r.DeleteFile("b"); err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	numBlocks := len(r.dedupBlocks)
+	r.mu.Unlock(id)
+	if numBlocks != 0 {
+		t.Fatal("blocks were not freed:", numBlocks)
+	}
+}
+
+// TestDedupShareLoad checks that deduplicated files can be shared and loaded,
+// and that loaded files share the blocks that the renter already has.
+func TestDedupShareLoad(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	data := fastrand.Bytes(int(pieceSize)*3/2 + 1)
+	if err := uploadTestFile(r, dir, data, modules.FileUploadParams{SiaPath: "file", ErasureCode: ec, Dedup: true}); err != nil {
+		t.Fatal(err)
+	}
+	ascii, err := r.ShareFilesASCII([]string{"file"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading the file again reuses its blocks.
+	names, err := r.LoadSharedFilesASCII(ascii)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := r.mu.Lock()
+	orig, loaded := r.files["file"], r.files[names[0]]
+	if len(loaded.blocks) != 2 || loaded.blocks[0] != orig.blocks[0] || loaded.blocks[1] != orig.blocks[1] {
+		t.Error("loaded file doesn't share the blocks of the original file")
+	}
+	if len(r.dedupBlocks) != 2 || orig.blocks[0].dedupRefCount() != 2 {
+		t.Error("wrong number of blocks or references:", len(r.dedupBlocks), orig.blocks[0].dedupRefCount())
+	}
+
+	// Load the file into a renter that doesn't know the blocks.
+	r.files = make(map[string]*file)
+	r.dedupBlocks = make(map[string]*file)
+	r.mu.Unlock(id)
+	if _, err := r.LoadSharedFilesASCII(ascii); err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f := r.files["file"]
+	if len(f.blocks) != 2 || len(r.dedupBlocks) != 2 {
+		t.Fatal("loaded file has the wrong blocks")
+	}
+	for i, block := range f.blocks {
+		if block.name != orig.blocks[i].name || block.masterKey != orig.blocks[i].masterKey || block.size != orig.blocks[i].size {
+			t.Fatal("new block doesn't match the shared block")
+		}
+	}
+}
+
+// TestVerifyDedupData checks that data read from the local copy of a block is
+// only accepted if it still matches the block.
+func TestVerifyDedupData(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(2, 1)
+	data := fastrand.Bytes(int(pieceSize) * 3 / 2)
+	if err := uploadTestFile(r, dir, data, modules.FileUploadParams{SiaPath: "file", ErasureCode: ec, Dedup: true}); err != nil {
+		t.Fatal(err)
+	}
+	id := r.mu.Lock()
+	block := r.files["file"].blocks[0]
+	r.mu.Unlock(id)
+
+	// The local data is read into a buffer of whole pieces, the padding
+	// doesn't matter.
+	buf := NewDownloadDestinationBuffer(block.staticChunkSize())
+	if _, err := buf.ReadFrom(bytes.NewReader(data)); err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	if !r.managedVerifyDedupData(block, buf) {
+		t.Fatal("unchanged data was rejected")
+	}
+	buf[1][len(buf[1])-1]++
+	if !r.managedVerifyDedupData(block, buf) {
+		t.Fatal("changed padding was rejected")
+	}
+	buf[0][0]++
+	if r.managedVerifyDedupData(block, buf) {
+		t.Fatal("changed data was accepted")
+	}
+}
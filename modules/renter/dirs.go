@@ -0,0 +1,504 @@
+package renter
+
+// dirs.go implements the renter's directory tree. Every siapath is split on
+// '/' into a chain of directories, and each directory keeps track of its
+// immediate children along with aggregate metadata about all of the files
+// below it. This allows the renter to list a single directory without walking
+// the whole file set.
+//
+// Structural changes (adding, removing or renaming files) are applied to the
+// aggregates of every parent directory right away. Redundancy and stuck chunk
+// counts depend on the state of the hosts, so they are refreshed for the whole
+// tree whenever the upload loop rebuilds its chunk heap.
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// SiaDirExtension is the name of the metadata file that is stored inside
+	// of every renter directory.
+	SiaDirExtension = ".siadir"
+)
+
+var (
+	// ErrUnknownDir is returned if a directory cannot be found with the given
+	// path.
+	ErrUnknownDir = errors.New("no directory known with that path")
+
+	// errRootDir is returned if the caller tries to delete or rename the root
+	// directory.
+	errRootDir = errors.New("cannot delete or rename the root directory")
+
+	// errRenameDirIntoSelf is returned if the caller tries to move a directory
+	// into one of its own subdirectories.
+	errRenameDirIntoSelf = errors.New("cannot move a directory into itself")
+
+	dirMetadataHeader = persist.Metadata{
+		Header:  "Sia Directory Metadata",
+		Version: "1.0",
+	}
+)
+
+// A siaDir is a directory of the renter's file tree.
+type siaDir struct {
+	name     string // The siapath of the directory, "" for the root.
+	files    map[string]struct{}
+	subDirs  map[string]struct{}
+	metadata dirMetadata
+}
+
+// dirMetadata is the aggregate metadata of a directory, covering all of the
+// files in the directory and its subdirectories. It is persisted in the
+// directory's .siadir file.
+type dirMetadata struct {
+	AggregateSize uint64    `json:"aggregatesize"`
+	LastUpdate    time.Time `json:"lastupdate"`
+	MinRedundancy float64   `json:"minredundancy"`
+	NumFiles      uint64    `json:"numfiles"`
+	StuckChunks   uint64    `json:"stuckchunks"`
+}
+
+// dirName returns the siapath of the directory containing siaPath.
+func dirName(siaPath string) string {
+	dir := path.Dir(siaPath)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// joinSiaPath joins a directory siapath with the name of one of its children.
+func joinSiaPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// validateDirSiapath checks that a siapath is a legal directory name. Unlike
+// files, the empty siapath is allowed and refers to the root directory.
+func validateDirSiapath(siaPath string) error {
+	if siaPath == "" {
+		return nil
+	}
+	if strings.HasSuffix(siaPath, "/") {
+		return errors.New("directory siapath cannot end with /")
+	}
+	return validateSiapath(siaPath)
+}
+
+// newSiaDir returns an empty directory with the provided siapath.
+func newSiaDir(name string) *siaDir {
+	return &siaDir{
+		name:    name,
+		files:   make(map[string]struct{}),
+		subDirs: make(map[string]struct{}),
+		metadata: dirMetadata{
+			LastUpdate:    time.Now(),
+			MinRedundancy: -1,
+		},
+	}
+}
+
+// dirPath returns the location of the metadata file of a directory.
+func (r *Renter) dirPath(siaPath string) string {
+	return filepath.Join(r.persistDir, siaPath, SiaDirExtension)
+}
+
+// saveDir saves the metadata of a directory to disk.
+func (r *Renter) saveDir(d *siaDir) error {
+	dirPath := r.dirPath(d.name)
+	if err := os.MkdirAll(filepath.Dir(dirPath), 0700); err != nil {
+		return err
+	}
+	return persist.SaveJSON(dirMetadataHeader, d.metadata, dirPath)
+}
+
+// createDirs returns the directory with the provided siapath, creating it and
+// any missing parents.
+func (r *Renter) createDirs(siaPath string) *siaDir {
+	d, exists := r.dirs[siaPath]
+	if exists {
+		return d
+	}
+	d = newSiaDir(siaPath)
+	r.dirs[siaPath] = d
+	if siaPath != "" {
+		parent := r.createDirs(dirName(siaPath))
+		parent.subDirs[path.Base(siaPath)] = struct{}{}
+	}
+	// If the directory already exists on disk, keep its metadata.
+	err := persist.LoadJSON(dirMetadataHeader, &d.metadata, r.dirPath(siaPath))
+	if err == nil {
+		return d
+	}
+	d.metadata = newSiaDir(siaPath).metadata
+	if err := r.saveDir(d); err != nil {
+		r.log.Println("WARN: could not save directory metadata:", err)
+	}
+	return d
+}
+
+// updateParentDirs adjusts the aggregate size and number of files of every
+// directory containing siaPath after files were added to or removed from the
+// tree, and marks the directories as updated.
+func (r *Renter) updateParentDirs(siaPath string, size, numFiles uint64, added bool) {
+	now := time.Now()
+	dir := siaPath
+	for dir != "" {
+		dir = dirName(dir)
+		d, exists := r.dirs[dir]
+		if !exists {
+			continue
+		}
+		if added {
+			d.metadata.AggregateSize += size
+			d.metadata.NumFiles += numFiles
+		} else if d.metadata.NumFiles >= numFiles && d.metadata.AggregateSize >= size {
+			d.metadata.AggregateSize -= size
+			d.metadata.NumFiles -= numFiles
+		}
+		d.metadata.LastUpdate = now
+		if err := r.saveDir(d); err != nil {
+			r.log.Println("WARN: could not save directory metadata:", err)
+		}
+	}
+}
+
+// addFileToDirs adds a file to the directory tree.
+func (r *Renter) addFileToDirs(f *file) {
+	d := r.createDirs(dirName(f.name))
+	d.files[path.Base(f.name)] = struct{}{}
+	r.updateParentDirs(f.name, f.dataSize(), 1, true)
+}
+
+// removeFileFromDirs removes a file from the directory tree.
+func (r *Renter) removeFileFromDirs(siaPath string, size uint64) {
+	d, exists := r.dirs[dirName(siaPath)]
+	if !exists {
+		return
+	}
+	if _, exists := d.files[path.Base(siaPath)]; !exists {
+		return
+	}
+	delete(d.files, path.Base(siaPath))
+	r.updateParentDirs(siaPath, size, 1, false)
+}
+
+// contractStatus builds two maps that map every provided contract id to its
+// offline and goodForRenew status.
+func (r *Renter) contractStatus(contractIDs map[types.FileContractID]struct{}) (offline, goodForRenew map[types.FileContractID]bool) {
+	goodForRenew = make(map[types.FileContractID]bool)
+	offline = make(map[types.FileContractID]bool)
+	for cid := range contractIDs {
+		resolvedID := r.hostContractor.ResolveID(cid)
+		cu, ok := r.hostContractor.ContractUtility(resolvedID)
+		goodForRenew[cid] = ok && cu.GoodForRenew
+		offline[cid] = r.hostContractor.IsOffline(resolvedID)
+	}
+	return offline, goodForRenew
+}
+
+// refreshDirMetadata recomputes the aggregate metadata of a directory and all
+// of its subdirectories. The directories are only saved to disk if their
+// metadata changed. The LastUpdate field is not modified.
+func (r *Renter) refreshDirMetadata(d *siaDir, offline, goodForRenew map[types.FileContractID]bool) {
+	md := dirMetadata{
+		LastUpdate:    d.metadata.LastUpdate,
+		MinRedundancy: math.MaxFloat64,
+	}
+	for name := range d.files {
+		f, exists := r.files[joinSiaPath(d.name, name)]
+		if !exists {
+			continue
+		}
+		f.mu.RLock()
+		md.AggregateSize += f.dataSize()
+		md.NumFiles++
+		md.StuckChunks += f.numStuckChunks()
+		if redundancy := f.redundancy(offline, goodForRenew); redundancy >= 0 && redundancy < md.MinRedundancy {
+			md.MinRedundancy = redundancy
+		}
+		f.mu.RUnlock()
+	}
+	for name := range d.subDirs {
+		sd, exists := r.dirs[joinSiaPath(d.name, name)]
+		if !exists {
+			continue
+		}
+		r.refreshDirMetadata(sd, offline, goodForRenew)
+		md.AggregateSize += sd.metadata.AggregateSize
+		md.NumFiles += sd.metadata.NumFiles
+		md.StuckChunks += sd.metadata.StuckChunks
+		if sd.metadata.MinRedundancy >= 0 && sd.metadata.MinRedundancy < md.MinRedundancy {
+			md.MinRedundancy = sd.metadata.MinRedundancy
+		}
+	}
+	if md.MinRedundancy == math.MaxFloat64 {
+		md.MinRedundancy = -1
+	}
+	if md == d.metadata {
+		return
+	}
+	d.metadata = md
+	if err := r.saveDir(d); err != nil {
+		r.log.Println("WARN: could not save directory metadata:", err)
+	}
+}
+
+// managedRefreshDirMetadata recomputes the aggregate metadata of the whole
+// directory tree.
+func (r *Renter) managedRefreshDirMetadata() {
+	// Get the contracts of all files.
+	contractIDs := make(map[types.FileContractID]struct{})
+	id := r.mu.RLock()
+	for _, f := range r.files {
+		f.mu.RLock()
+		f.addContractIDs(contractIDs)
+		f.mu.RUnlock()
+	}
+	r.mu.RUnlock(id)
+	offline, goodForRenew := r.contractStatus(contractIDs)
+
+	id = r.mu.Lock()
+	r.refreshDirMetadata(r.createDirs(""), offline, goodForRenew)
+	r.mu.Unlock(id)
+}
+
+// dirInfo returns the DirectoryInfo of a directory.
+func (d *siaDir) dirInfo() modules.DirectoryInfo {
+	return modules.DirectoryInfo{
+		SiaPath:       d.name,
+		AggregateSize: d.metadata.AggregateSize,
+		LastUpdate:    d.metadata.LastUpdate,
+		MinRedundancy: d.metadata.MinRedundancy,
+		NumFiles:      d.metadata.NumFiles,
+		NumSubDirs:    uint64(len(d.subDirs)),
+		StuckChunks:   d.metadata.StuckChunks,
+	}
+}
+
+// CreateDir creates a new, empty directory at the provided siapath. Missing
+// parent directories are created as well.
+func (r *Renter) CreateDir(siaPath string) error {
+	if siaPath == "" {
+		return ErrPathOverload
+	}
+	if err := validateDirSiapath(siaPath); err != nil {
+		return err
+	}
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if _, exists := r.dirs[siaPath]; exists {
+		return ErrPathOverload
+	}
+	r.createDirs(siaPath)
+	return nil
+}
+
+// DeleteDir deletes a directory along with all of the directories it contains.
+// The files it contains are moved to the trash.
+func (r *Renter) DeleteDir(siaPath string) error {
+	if siaPath == "" {
+		return errRootDir
+	}
+	if err := validateDirSiapath(siaPath); err != nil {
+		return err
+	}
+	lockID := r.mu.Lock()
+	d, exists := r.dirs[siaPath]
+	if !exists {
+		r.mu.Unlock(lockID)
+		return ErrUnknownDir
+	}
+
+	// Move all of the files below the directory to the trash.
+	prefix := siaPath + "/"
+	for name, f := range r.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if err := r.trashFile(name, f); err != nil {
+			r.log.Println("WARN: could not move file to the trash, purging it:", err)
+			r.purgeFile(name, f)
+		}
+	}
+	r.updateParentDirs(siaPath, d.metadata.AggregateSize, d.metadata.NumFiles, false)
+
+	// Delete the directory and all of its subdirectories, deepest first so
+	// that the emptied folders can be removed from disk.
+	var dirs []string
+	for name := range r.dirs {
+		if name == siaPath || strings.HasPrefix(name, prefix) {
+			dirs = append(dirs, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, name := range dirs {
+		delete(r.dirs, name)
+		err := persist.RemoveFile(r.dirPath(name))
+		if err != nil {
+			r.log.Println("WARN: couldn't remove directory metadata:", err)
+		}
+		// Only empty folders are removed. Folders that still contain other
+		// data are left untouched.
+		os.Remove(filepath.Join(r.persistDir, name))
+	}
+	parent := r.dirs[dirName(siaPath)]
+	delete(parent.subDirs, path.Base(d.name))
+	parent.metadata.LastUpdate = time.Now()
+	if err := r.saveDir(parent); err != nil {
+		r.log.Println("WARN: could not save directory metadata:", err)
+	}
+	err := r.saveSync()
+	r.mu.Unlock(lockID)
+	return err
+}
+
+// RenameDir moves a directory along with all of the files and directories it
+// contains to a new siapath. There must not be a directory at the new siapath
+// yet.
+func (r *Renter) RenameDir(currentPath, newPath string) error {
+	if currentPath == "" || newPath == "" {
+		return errRootDir
+	}
+	if err := validateDirSiapath(currentPath); err != nil {
+		return err
+	}
+	if err := validateDirSiapath(newPath); err != nil {
+		return err
+	}
+	if newPath == currentPath || strings.HasPrefix(newPath, currentPath+"/") {
+		return errRenameDirIntoSelf
+	}
+	lockID := r.mu.Lock()
+	defer r.mu.Unlock(lockID)
+	if _, exists := r.dirs[currentPath]; !exists {
+		return ErrUnknownDir
+	}
+	if _, exists := r.dirs[newPath]; exists {
+		return ErrPathOverload
+	}
+
+	// Make sure none of the new file names are taken.
+	prefix := currentPath + "/"
+	var names []string
+	for name := range r.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, exists := r.files[newPath+"/"+strings.TrimPrefix(name, prefix)]; exists {
+			return ErrPathOverload
+		}
+		names = append(names, name)
+	}
+
+	// Move the directories. The old .siadir files are removed once all of the
+	// files have been moved.
+	moved := r.dirs[currentPath]
+	r.updateParentDirs(currentPath, moved.metadata.AggregateSize, moved.metadata.NumFiles, false)
+	delete(r.dirs[dirName(currentPath)].subDirs, path.Base(currentPath))
+	var oldDirs []string
+	for name := range r.dirs {
+		if name == currentPath || strings.HasPrefix(name, prefix) {
+			oldDirs = append(oldDirs, name)
+		}
+	}
+	for _, name := range oldDirs {
+		d := r.dirs[name]
+		delete(r.dirs, name)
+		d.name = newPath + strings.TrimPrefix(name, currentPath)
+		r.dirs[d.name] = d
+		if err := r.saveDir(d); err != nil {
+			return err
+		}
+	}
+	r.createDirs(dirName(newPath)).subDirs[path.Base(newPath)] = struct{}{}
+	r.updateParentDirs(newPath, moved.metadata.AggregateSize, moved.metadata.NumFiles, true)
+
+	// Move the files.
+	for _, name := range names {
+		newName := newPath + "/" + strings.TrimPrefix(name, prefix)
+		if err := r.moveFile(r.files[name], name, newName); err != nil {
+			return err
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(oldDirs)))
+	for _, name := range oldDirs {
+		err := persist.RemoveFile(r.dirPath(name))
+		if err != nil {
+			r.log.Println("WARN: couldn't remove directory metadata:", err)
+		}
+		os.Remove(filepath.Join(r.persistDir, name))
+	}
+	return r.saveSync()
+}
+
+// DirList returns the information of a directory, followed by the
+// information of its immediate subdirectories, along with the information of
+// all the files directly inside of the directory.
+func (r *Renter) DirList(siaPath string) ([]modules.DirectoryInfo, []modules.FileInfo, error) {
+	if err := validateDirSiapath(siaPath); err != nil {
+		return nil, nil, err
+	}
+	lockID := r.mu.RLock()
+	defer r.mu.RUnlock(lockID)
+	d, exists := r.dirs[siaPath]
+	if !exists && siaPath != "" {
+		return nil, nil, ErrUnknownDir
+	} else if !exists {
+		d = newSiaDir("")
+	}
+
+	// Collect the directories.
+	dirs := []modules.DirectoryInfo{d.dirInfo()}
+	var subDirs []string
+	for name := range d.subDirs {
+		subDirs = append(subDirs, name)
+	}
+	sort.Strings(subDirs)
+	for _, name := range subDirs {
+		if sd, exists := r.dirs[joinSiaPath(siaPath, name)]; exists {
+			dirs = append(dirs, sd.dirInfo())
+		}
+	}
+
+	// Collect the files.
+	var files []*file
+	var fileNames []string
+	for name := range d.files {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+	contractIDs := make(map[types.FileContractID]struct{})
+	for _, name := range fileNames {
+		f, exists := r.files[joinSiaPath(siaPath, name)]
+		if !exists {
+			continue
+		}
+		files = append(files, f)
+		f.mu.RLock()
+		f.addContractIDs(contractIDs)
+		f.mu.RUnlock()
+	}
+	offline, goodForRenew := r.contractStatus(contractIDs)
+	fileList := make([]modules.FileInfo, 0, len(files))
+	for _, f := range files {
+		f.mu.RLock()
+		fileList = append(fileList, r.fileInfo(f, offline, goodForRenew))
+		f.mu.RUnlock()
+	}
+	return dirs, fileList, nil
+}
@@ -0,0 +1,124 @@
+package renter
+
+import (
+	"os"
+	"testing"
+)
+
+// TestRenterDirs probes the directory methods of the renter.
+func TestRenterDirs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+
+	// Add some files to the renter.
+	id := rt.renter.mu.Lock()
+	for _, name := range []string{"foo/bar", "foo/baz/qux", "quux"} {
+		f := newTestingFile()
+		f.name = name
+		f.size = 10
+		rt.renter.files[name] = f
+		rt.renter.addFileToDirs(f)
+		if err := rt.renter.saveFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rt.renter.mu.Unlock(id)
+
+	// List the root directory.
+	dirs, files, err := rt.renter.DirList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 2 || dirs[1].SiaPath != "foo" {
+		t.Fatal("unexpected directories in root directory:", dirs)
+	}
+	if len(files) != 1 || files[0].SiaPath != "quux" {
+		t.Fatal("unexpected files in root directory:", files)
+	}
+	if dirs[0].NumFiles != 3 || dirs[0].AggregateSize != 30 || dirs[0].NumSubDirs != 1 {
+		t.Fatal("unexpected root directory metadata:", dirs[0])
+	}
+	if dirs[1].NumFiles != 2 || dirs[1].AggregateSize != 20 {
+		t.Fatal("unexpected directory metadata:", dirs[1])
+	}
+
+	// Create a directory.
+	if err := rt.renter.CreateDir("foo/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.renter.CreateDir("foo/empty"); err != ErrPathOverload {
+		t.Fatal("expected ErrPathOverload, got", err)
+	}
+	dirs, files, err = rt.renter.DirList("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 3 || dirs[1].SiaPath != "foo/baz" || dirs[2].SiaPath != "foo/empty" {
+		t.Fatal("unexpected directories in foo:", dirs)
+	}
+	if len(files) != 1 || files[0].SiaPath != "foo/bar" {
+		t.Fatal("unexpected files in foo:", files)
+	}
+
+	// Rename a directory.
+	if err := rt.renter.RenameDir("foo", "foo/new"); err != errRenameDirIntoSelf {
+		t.Fatal("expected errRenameDirIntoSelf, got", err)
+	}
+	if err := rt.renter.RenameDir("foo", "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.renter.File("a/b/baz/qux"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rt.renter.DirList("foo"); err != ErrUnknownDir {
+		t.Fatal("expected ErrUnknownDir, got", err)
+	}
+	dirs, _, err = rt.renter.DirList("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirs[0].NumFiles != 2 || dirs[0].AggregateSize != 20 || len(dirs) != 2 {
+		t.Fatal("unexpected directory metadata after rename:", dirs)
+	}
+
+	// Reload the renter and check that the directories were persisted.
+	id = rt.renter.mu.Lock()
+	rt.renter.dirs = make(map[string]*siaDir)
+	rt.renter.files = make(map[string]*file)
+	err = rt.renter.load()
+	rt.renter.mu.Unlock(id)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	dirs, files, err = rt.renter.DirList("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 3 || len(files) != 1 || dirs[0].NumFiles != 2 {
+		t.Fatal("directory was not persisted correctly:", dirs, files)
+	}
+
+	// Delete a directory.
+	if err := rt.renter.DeleteDir(""); err != errRootDir {
+		t.Fatal("expected errRootDir, got", err)
+	}
+	if err := rt.renter.DeleteDir("a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.renter.FileList()) != 1 {
+		t.Fatal("files were not deleted with their directory")
+	}
+	dirs, _, err = rt.renter.DirList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0].NumFiles != 1 || dirs[0].AggregateSize != 10 {
+		t.Fatal("unexpected root directory after delete:", dirs)
+	}
+}
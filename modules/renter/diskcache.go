@@ -0,0 +1,383 @@
+package renter
+
+// diskcache.go implements a cache of downloaded chunks on disk. The cache in
+// downloadcache.go only holds the last few chunks of streams in memory, so
+// files that are read repeatedly, e.g. by a media server, would otherwise be
+// downloaded from the hosts every time. The disk cache holds the recovered
+// chunks of all downloads up to a configurable size and evicts the least
+// recently used chunks first. Downloads and streams check the cache before
+// they fetch a chunk from the hosts.
+//
+// Every cached chunk is stored in its own file in cacheDir. The file starts
+// with the checksum of the cached data, which is verified whenever the chunk
+// is read from the cache. Chunks that don't match their checksum are removed
+// from the cache and downloaded from the hosts instead. Chunks are identified
+// by the master key of their file, their index and the length of their data,
+// so the chunks of a deleted or replaced file or of a pack that grew are never
+// returned for another file. The last access of a chunk is stored as the
+// modification time of its file, which preserves the order of eviction across
+// restarts. Files that were uploaded to cacheDir before it was reserved are
+// moved out of it when the renter loads, see migrateFile.
+//
+// The cache is disabled until its size is set. Chunks that are downloaded for
+// repairs are not added to the cache, since they are uploaded again right
+// away. Note that the cache stores the plaintext of the chunks.
+
+import (
+	"container/list"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+)
+
+const (
+	// cacheDir is the directory within the renter directory that contains the
+	// chunk cache. Siapaths within cacheDir are reserved.
+	cacheDir = ".cache"
+
+	// cacheExtension is the extension of the files of cached chunks.
+	cacheExtension = ".chunk"
+
+	// cacheTempSuffix is the suffix of the temporary files that chunks are
+	// written to before they are added to the cache.
+	cacheTempSuffix = "_temp"
+)
+
+// errCachedChunkCorrupted is returned when a cached chunk doesn't match its
+// checksum.
+var errCachedChunkCorrupted = errors.New("cached chunk doesn't match its checksum")
+
+type (
+	// diskCache is an LRU cache of recovered chunks on disk.
+	diskCache struct {
+		chunks  map[crypto.Hash]*list.Element
+		lru     *list.List // Values are *diskCacheEntry, most recently used first.
+		maxSize uint64     // The cache is disabled if maxSize is 0.
+		size    uint64
+		hits    uint64
+		misses  uint64
+
+		// generation is incremented whenever the cache is purged, so that
+		// chunks that were written before the purge are not added afterwards.
+		generation uint64
+
+		staticDir string
+		staticLog *persist.Logger
+		mu        sync.Mutex
+	}
+
+	// diskCacheEntry is a chunk in the disk cache.
+	diskCacheEntry struct {
+		key  crypto.Hash
+		size uint64 // Size of the file of the chunk, including the checksum.
+	}
+)
+
+// newDiskCache returns an empty, disabled disk cache that stores its chunks in
+// dir.
+func newDiskCache(dir string, log *persist.Logger) *diskCache {
+	return &diskCache{
+		chunks:    make(map[crypto.Hash]*list.Element),
+		lru:       list.New(),
+		staticDir: dir,
+		staticLog: log,
+	}
+}
+
+// diskCacheKey returns the key of a chunk in the disk cache. index is the
+// index of the chunk within the file with masterKey, and length is the length
+// of the data of the chunk.
+func diskCacheKey(masterKey crypto.TwofishKey, index, length uint64) crypto.Hash {
+	return crypto.HashAll(masterKey, index, length)
+}
+
+// path returns the path of the file of the chunk with key.
+func (dc *diskCache) path(key crypto.Hash) string {
+	return filepath.Join(dc.staticDir, key.String()+cacheExtension)
+}
+
+// load adds the chunks in the cache directory to the cache and evicts chunks
+// until the cache fits its size. Leftovers of interrupted writes are removed,
+// anything else in the cache directory is left alone.
+func (dc *diskCache) load() error {
+	if err := os.MkdirAll(dc.staticDir, 0700); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(dc.staticDir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		var key crypto.Hash
+		name := info.Name()
+		if !info.IsDir() && strings.HasSuffix(name, cacheTempSuffix) {
+			if err := os.Remove(filepath.Join(dc.staticDir, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if info.IsDir() || filepath.Ext(name) != cacheExtension || key.LoadString(strings.TrimSuffix(name, cacheExtension)) != nil {
+			continue
+		}
+		dc.chunks[key] = dc.lru.PushBack(&diskCacheEntry{
+			key:  key,
+			size: uint64(info.Size()),
+		})
+		dc.size += uint64(info.Size())
+	}
+	dc.evict(0)
+	return nil
+}
+
+// evict removes the least recently used chunks from the cache until a chunk
+// of the given size fits.
+func (dc *diskCache) evict(size uint64) {
+	for dc.lru.Len() > 0 && dc.size+size > dc.maxSize {
+		dc.remove(dc.lru.Back())
+	}
+}
+
+// remove removes the chunk of elem from the cache.
+func (dc *diskCache) remove(elem *list.Element) {
+	entry := dc.lru.Remove(elem).(*diskCacheEntry)
+	delete(dc.chunks, entry.key)
+	dc.size -= entry.size
+	if err := os.Remove(dc.path(entry.key)); err != nil && !os.IsNotExist(err) {
+		dc.staticLog.Println("WARN: couldn't remove chunk from the chunk cache:", err)
+	}
+}
+
+// readChunk reads the chunk with key from disk and verifies its checksum.
+func (dc *diskCache) readChunk(key crypto.Hash) ([]byte, error) {
+	data, err := ioutil.ReadFile(dc.path(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < crypto.HashSize {
+		return nil, errCachedChunkCorrupted
+	}
+	var checksum crypto.Hash
+	copy(checksum[:], data)
+	data = data[crypto.HashSize:]
+	if crypto.HashBytes(data) != checksum {
+		return nil, errCachedChunkCorrupted
+	}
+	return data, nil
+}
+
+// writeChunk writes data to a temporary file in the cache directory and
+// returns the path of the file. The temporary file is only renamed to the
+// file of the chunk once it is complete.
+func (dc *diskCache) writeChunk(data []byte) (string, error) {
+	checksum := crypto.HashBytes(data)
+	path := filepath.Join(dc.staticDir, persist.RandomSuffix()+cacheTempSuffix)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(checksum[:])
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// managedAdd adds the data of the chunk with key to the cache, evicting the
+// least recently used chunks to make room for it. Chunks that are larger than
+// the cache are not added, and neither are chunks if the cache was purged while
+// they were written.
+func (dc *diskCache) managedAdd(key crypto.Hash, data []byte) {
+	size := uint64(crypto.HashSize + len(data))
+	dc.mu.Lock()
+	_, cached := dc.chunks[key]
+	fits := size <= dc.maxSize
+	generation := dc.generation
+	dc.mu.Unlock()
+	if cached || !fits {
+		return
+	}
+
+	// The chunk is written without holding the lock, the cache might have
+	// changed in the meantime.
+	tmpPath, err := dc.writeChunk(data)
+	if err != nil {
+		dc.staticLog.Println("WARN: couldn't write chunk to the chunk cache:", err)
+		return
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if _, cached := dc.chunks[key]; cached || size > dc.maxSize || dc.generation != generation {
+		os.Remove(tmpPath)
+		return
+	}
+	dc.evict(size)
+	if err := os.Rename(tmpPath, dc.path(key)); err != nil {
+		dc.staticLog.Println("WARN: couldn't write chunk to the chunk cache:", err)
+		os.Remove(tmpPath)
+		return
+	}
+	dc.chunks[key] = dc.lru.PushFront(&diskCacheEntry{
+		key:  key,
+		size: size,
+	})
+	dc.size += size
+}
+
+// managedGet returns the data of the chunk with key if the chunk is cached
+// and matches its checksum. Corrupted chunks are removed from the cache.
+func (dc *diskCache) managedGet(key crypto.Hash) ([]byte, bool) {
+	dc.mu.Lock()
+	elem, cached := dc.chunks[key]
+	if !cached {
+		if dc.maxSize > 0 {
+			dc.misses++
+		}
+		dc.mu.Unlock()
+		return nil, false
+	}
+	dc.lru.MoveToFront(elem)
+	dc.mu.Unlock()
+
+	data, err := dc.readChunk(key)
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if err != nil {
+		dc.staticLog.Println("WARN: removing unreadable chunk from the chunk cache:", err)
+		if dc.chunks[key] == elem {
+			dc.remove(elem)
+		}
+		dc.misses++
+		return nil, false
+	}
+	dc.hits++
+	now := time.Now()
+	if err := os.Chtimes(dc.path(key), now, now); err != nil && !os.IsNotExist(err) {
+		dc.staticLog.Println("WARN: couldn't update the access time of a cached chunk:", err)
+	}
+	return data, true
+}
+
+// managedInfo returns information about the cache.
+func (dc *diskCache) managedInfo() modules.ChunkCacheInfo {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return modules.ChunkCacheInfo{
+		MaxSize: dc.maxSize,
+		Size:    dc.size,
+		Chunks:  uint64(dc.lru.Len()),
+		Hits:    dc.hits,
+		Misses:  dc.misses,
+	}
+}
+
+// managedMaxSize returns the maximum size of the cache.
+func (dc *diskCache) managedMaxSize() uint64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.maxSize
+}
+
+// managedPurge removes all chunks from the cache, including the chunks that
+// are being added right now.
+func (dc *diskCache) managedPurge() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.generation++
+	for dc.lru.Len() > 0 {
+		dc.remove(dc.lru.Back())
+	}
+}
+
+// managedRemove removes the chunk with key from the cache.
+func (dc *diskCache) managedRemove(key crypto.Hash) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if elem, cached := dc.chunks[key]; cached {
+		dc.remove(elem)
+	}
+}
+
+// managedSetMaxSize sets the maximum size of the cache and evicts chunks
+// until the cache fits.
+func (dc *diskCache) managedSetMaxSize(maxSize uint64) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.maxSize = maxSize
+	dc.evict(0)
+}
+
+// addChunkToDiskCache adds the recovered data of the chunk to the disk cache.
+// Chunks that were downloaded for repairs are not cached.
+func (udc *unfinishedDownloadChunk) addChunkToDiskCache(data []byte) {
+	if udc.diskCache == nil || udc.download.staticDestinationType == destinationTypeRepair {
+		return
+	}
+	udc.diskCache.managedAdd(udc.staticDiskCacheKey, data)
+}
+
+// managedTryDiskCache returns the data of the chunk if it's in the disk
+// cache. If the checksum of the chunk is known, the cached data is verified
+// against it as well.
+func (r *Renter) managedTryDiskCache(udc *unfinishedDownloadChunk) ([]byte, bool) {
+	data, cached := r.diskCache.managedGet(udc.staticDiskCacheKey)
+	if !cached {
+		return nil, false
+	}
+	if uint64(len(data)) != udc.staticChunkSize || (udc.staticChecksum != (crypto.Hash{}) && crypto.HashBytes(data[:udc.staticDataLength]) != udc.staticChecksum) {
+		r.log.Println("WARN: removing chunk that doesn't match its checksum from the chunk cache")
+		r.diskCache.managedRemove(udc.staticDiskCacheKey)
+		return nil, false
+	}
+	return data, true
+}
+
+// ChunkCache returns information about the renter's chunk cache on disk.
+func (r *Renter) ChunkCache() modules.ChunkCacheInfo {
+	return r.diskCache.managedInfo()
+}
+
+// PurgeChunkCache removes all chunks from the renter's chunk cache on disk.
+func (r *Renter) PurgeChunkCache() error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	r.diskCache.managedPurge()
+	return nil
+}
+
+// SetChunkCacheSize sets the maximum size of the renter's chunk cache on disk
+// in bytes and evicts chunks until the cache fits. A size of 0 disables the
+// cache.
+func (r *Renter) SetChunkCacheSize(size uint64) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	r.diskCache.managedSetMaxSize(size)
+	return r.saveSync()
+}
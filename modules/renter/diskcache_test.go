@@ -0,0 +1,286 @@
+package renter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// newTestDiskCache returns a disk cache of maxSize bytes in a new test
+// directory.
+func newTestDiskCache(t *testing.T, maxSize uint64) *diskCache {
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	log, err := persist.NewFileLogger(filepath.Join(dir, "cache.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := newDiskCache(filepath.Join(dir, cacheDir), log)
+	dc.maxSize = maxSize
+	if err := dc.load(); err != nil {
+		t.Fatal(err)
+	}
+	return dc
+}
+
+// TestDiskCache checks that the disk cache returns the chunks that were added
+// and evicts the least recently used chunks first.
+func TestDiskCache(t *testing.T) {
+	chunkSize := 100
+	dc := newTestDiskCache(t, uint64(3*(crypto.HashSize+chunkSize)))
+	var keys []crypto.Hash
+	var chunks [][]byte
+	for i := 0; i < 4; i++ {
+		keys = append(keys, crypto.HashObject(i))
+		chunks = append(chunks, fastrand.Bytes(chunkSize))
+	}
+
+	// Add three chunks and access the first one, which makes the second chunk
+	// the least recently used one.
+	for i := 0; i < 3; i++ {
+		dc.managedAdd(keys[i], chunks[i])
+	}
+	if data, cached := dc.managedGet(keys[0]); !cached || !bytes.Equal(data, chunks[0]) {
+		t.Fatal("cached chunk wasn't returned")
+	}
+	dc.managedAdd(keys[3], chunks[3])
+	if _, cached := dc.managedGet(keys[1]); cached {
+		t.Fatal("least recently used chunk wasn't evicted")
+	}
+	for _, i := range []int{0, 2, 3} {
+		if data, cached := dc.managedGet(keys[i]); !cached || !bytes.Equal(data, chunks[i]) {
+			t.Fatal("cached chunk wasn't returned", i)
+		}
+	}
+	info := dc.managedInfo()
+	if info.Chunks != 3 || info.Size != dc.maxSize || info.Hits != 4 || info.Misses != 1 {
+		t.Fatal("wrong cache info:", info)
+	}
+	if _, err := os.Stat(dc.path(keys[1])); !os.IsNotExist(err) {
+		t.Fatal("file of evicted chunk wasn't removed:", err)
+	}
+
+	// Chunks that don't fit into the cache are not added.
+	dc.managedAdd(crypto.HashObject("large"), fastrand.Bytes(int(dc.maxSize)))
+	if info := dc.managedInfo(); info.Chunks != 3 {
+		t.Fatal("chunk that doesn't fit was added")
+	}
+
+	// A chunk that was corrupted on disk is removed from the cache.
+	raw, err := ioutil.ReadFile(dc.path(keys[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1]++
+	if err := ioutil.WriteFile(dc.path(keys[0]), raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, cached := dc.managedGet(keys[0]); cached {
+		t.Fatal("corrupted chunk was returned")
+	}
+	if info := dc.managedInfo(); info.Chunks != 2 {
+		t.Fatal("corrupted chunk wasn't removed:", info.Chunks)
+	}
+
+	// Shrinking the cache evicts chunks, disabling it evicts all chunks.
+	dc.managedSetMaxSize(uint64(crypto.HashSize + chunkSize))
+	if _, cached := dc.managedGet(keys[3]); !cached {
+		t.Fatal("most recently used chunk was evicted")
+	}
+	if info := dc.managedInfo(); info.Chunks != 1 {
+		t.Fatal("wrong number of chunks after shrinking the cache:", info.Chunks)
+	}
+	dc.managedSetMaxSize(0)
+	if info := dc.managedInfo(); info.Chunks != 0 || info.Size != 0 {
+		t.Fatal("chunks weren't evicted when disabling the cache:", info)
+	}
+	dc.managedAdd(keys[0], chunks[0])
+	if info := dc.managedInfo(); info.Chunks != 0 {
+		t.Fatal("chunk was added to disabled cache")
+	}
+}
+
+// TestDiskCacheLoad checks that the chunks of the disk cache are loaded in the
+// order they were used, and that leftovers of interrupted writes are removed
+// while other files are left alone.
+func TestDiskCacheLoad(t *testing.T) {
+	chunkSize := 100
+	dc := newTestDiskCache(t, uint64(3*(crypto.HashSize+chunkSize)))
+	var keys []crypto.Hash
+	for i := 0; i < 3; i++ {
+		keys = append(keys, crypto.HashObject(i))
+		dc.managedAdd(keys[i], fastrand.Bytes(chunkSize))
+	}
+	// Make the first chunk the most recently used one.
+	for i, key := range keys {
+		accessTime := time.Now().Add(time.Duration(i-len(keys)) * time.Hour)
+		if i == 0 {
+			accessTime = time.Now()
+		}
+		if err := os.Chtimes(dc.path(key), accessTime, accessTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpPath, err := dc.writeChunk(fastrand.Bytes(chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(dc.staticDir, "other"+ShareExtension)
+	if err := ioutil.WriteFile(otherPath, []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the cache with room for two chunks. The second chunk was used least
+	// recently.
+	loaded := newDiskCache(dc.staticDir, dc.staticLog)
+	loaded.maxSize = uint64(2 * (crypto.HashSize + chunkSize))
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, cached := loaded.managedGet(keys[1]); cached {
+		t.Fatal("least recently used chunk wasn't evicted")
+	}
+	for _, i := range []int{0, 2} {
+		if _, cached := loaded.managedGet(keys[i]); !cached {
+			t.Fatal("chunk wasn't loaded", i)
+		}
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Fatal("temporary file wasn't removed:", err)
+	} else if _, err := os.Stat(otherPath); err != nil {
+		t.Fatal("file that isn't a chunk was removed:", err)
+	}
+
+	// Purging the cache removes all chunks.
+	loaded.managedPurge()
+	if info := loaded.managedInfo(); info.Chunks != 0 || info.Size != 0 {
+		t.Fatal("cache wasn't purged:", info)
+	}
+	files, err := ioutil.ReadDir(loaded.staticDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("files of purged chunks weren't removed:", len(files))
+	}
+}
+
+// TestTryDiskCache checks that chunks in the disk cache are written to the
+// destination of a download, and that cached chunks that don't match the
+// checksum of their chunk are ignored.
+func TestTryDiskCache(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+	if err := r.SetChunkCacheSize(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	chunkSize := uint64(1 << 10)
+	data := fastrand.Bytes(int(chunkSize))
+	for _, corrupt := range []bool{false, true} {
+		key := crypto.HashObject(corrupt)
+		checksum := crypto.HashBytes(data)
+		if corrupt {
+			checksum = crypto.Hash{}
+			checksum[0] = 1
+		}
+		r.diskCache.managedAdd(key, data)
+
+		destination := NewDownloadDestinationBuffer(chunkSize)
+		udc := &unfinishedDownloadChunk{
+			destination: destination,
+
+			staticCacheID:     key.String(),
+			staticChunkSize:   chunkSize,
+			staticFetchOffset: 10,
+			staticFetchLength: 100,
+			staticPieceSize:   chunkSize,
+			staticChecksum:    checksum,
+			staticDataLength:  chunkSize,
+
+			download: &download{
+				chunksCompleted: make(map[uint64]struct{}),
+				chunksRemaining: 1,
+				completeChan:    make(chan struct{}),
+				destination:     destination,
+			},
+			chunkCache: r.chunkCache,
+			cacheMu:    r.cmu,
+
+			diskCache:          r.diskCache,
+			staticDiskCacheKey: key,
+		}
+		cached := r.managedTryCache(udc)
+		if corrupt {
+			if cached {
+				t.Fatal("chunk that doesn't match its checksum was used")
+			}
+			if r.ChunkCache().Chunks != 1 {
+				t.Fatal("chunk that doesn't match its checksum wasn't removed")
+			}
+			continue
+		}
+		if !cached {
+			t.Fatal("cached chunk wasn't used")
+		}
+		select {
+		case <-udc.download.completeChan:
+		default:
+			t.Fatal("download wasn't completed")
+		}
+		if !bytes.Equal(destination[0][:100], data[10:110]) || udc.download.atomicDataReceived != 100 {
+			t.Fatal("cached chunk wasn't written to the destination")
+		}
+	}
+}
+
+// TestChunkCacheSizePersist checks that the size of the chunk cache is
+// persisted and that the cached chunks survive a restart of the renter.
+func TestChunkCacheSizePersist(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+	if err := r.SetChunkCacheSize(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.HashObject("chunk")
+	r.diskCache.managedAdd(key, fastrand.Bytes(100))
+
+	// Reload the cache like the renter does when it starts.
+	r.diskCache = newDiskCache(r.diskCache.staticDir, r.log)
+	id := r.mu.Lock()
+	err = r.load()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.diskCache.load(); err != nil {
+		t.Fatal(err)
+	}
+	if info := r.ChunkCache(); info.MaxSize != 1<<20 || info.Chunks != 1 {
+		t.Fatal("chunk cache wasn't persisted:", info)
+	}
+}
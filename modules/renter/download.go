@@ -46,10 +46,11 @@ package renter
 
 // The download process has a slightly complicating factor, which is overdrive
 // workers. Traditionally, if you need 10 pieces to recover a file, you will use
-// 10 workers. But if you have an overdrive of '2', you will actually use 12
-// workers, meaning you download 2 more pieces than you need. This means that up
-// to two of the workers can be slow or fail and the download can still complete
-// quickly. This complicates resource handling, because not all memory can be
+// 10 workers. But if you have an overdrive of '2' and a chunk takes longer than
+// expected, you will actually use 12 workers, meaning you download 2 more
+// pieces than you need. This means that up to two of the workers can be slow
+// or fail and the download can still complete quickly, see overdrive.go. This
+// complicates resource handling, because not all memory can be
 // released as soon as a download completes - there may be overdrive workers
 // still out fetching the file. To handle this, a catchall 'cleanUp' function is
 // used which gets called every time a worker finishes, and every time recovery
@@ -78,13 +79,7 @@ package renter
 // adding some sort of latency scoring will probably be the biggest thing that
 // we can do to improve overall file latency.
 //
-// After we do that, the second most important thing that we can do is enable
-// partial downloads. It's hard to have a low latency when to get any data back
-// at all you need to download a full 40 MiB. If we can leverage partial
-// downloads to drop that to something like 256kb, we'll get much better overall
-// latency for small files and for starting video streams.
-//
-// After both of those, we can leverage worker latency discrimination. We can
+// After that, we can leverage worker latency discrimination. We can
 // add code to 'managedProcessDownloadChunk' to put a worker on standby
 // initially instead of have it grab a piece if the latency of the worker is
 // higher than the faster workers. This will prevent the slow workers from
@@ -92,28 +87,16 @@ package renter
 // harm overall system throughput because it means that the slower workers will
 // idle some of the time.
 
-// TODO: Currently the number of overdrive workers is set to '2' for the first 2
-// chunks of any user-initiated download. But really, this should be a parameter
-// of downloading that gets set by the user through the API on a per-file basis
-// instead of set by default.
-
-// TODO: I tried to write the code such that the transition to true partial
-// downloads would be as seamless as possible, but there's a lot of work that
-// still needs to be done to make that fully possible. The most disruptive thing
-// probably is the place where we call 'Sector' in worker.managedDownload.
-// That's going to need to be changed to a partial sector. This is probably
-// going to result in downloading that's 64-byte aligned instead of perfectly
-// byte-aligned. Further, the encryption and erasure coding may also have
-// alignment requirements which interefere with how the call to Sector can work.
-// So you need to make sure that in 'managedDownload' you download at least
-// enough data to fit the alignment requirements of all 3 steps (download from
-// host, encryption, erasure coding). After the logical data has been recovered,
-// we slice it to whatever is meant to be written to the underlying
-// downloadWriter, that code is going to need to be adjusted as well to slice
-// things in the right way.
-//
-// Overall I don't think it's going to be all that difficult, but it's not
-// nearly as clean-cut as some of the other potential extensions that we can do.
+// Partial downloads: if the data requested from a chunk is contained in a
+// single data piece, the workers only fetch the matching range of their pieces
+// (aligned to segments, plus the segment containing the nonce) and decrypt it
+// right away. Reed-Solomon operates on every byte position independently, so
+// the ranges can be recovered like full pieces. Hosts that don't support range
+// proofs still send whole sectors, which are sliced by the proto.Downloader.
+// Partial chunks are not added to the chunk cache. If the checksums of the
+// segments of a chunk are known, the ranges are widened to whole segments,
+// which are verified against their checksums, see checksum.go. Chunks that
+// only have a checksum of the whole chunk are not fetched partially.
 
 // TODO: Right now the whole download will build and send off chunks even if
 // there are not enough hosts to download the file, and even if there are not
@@ -131,6 +114,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/persist"
 	"github.com/NebulousLabs/Sia/types"
@@ -146,9 +130,12 @@ type (
 		atomicTotalDataTransferred uint64 // Incremented as data arrives, includes overdrive, contract negotiation, etc.
 
 		// Other progress variables.
-		chunksRemaining uint64        // Number of chunks whose downloads are incomplete.
-		completeChan    chan struct{} // Closed once the download is complete.
-		err             error         // Only set if there was an error which prevented the download from completing.
+		cancelled       bool                // Set if the download was cancelled by the user.
+		chunksCompleted map[uint64]struct{} // Chunks that have been written to the destination.
+		chunksRemaining uint64              // Number of chunks whose downloads are incomplete.
+		completeChan    chan struct{}       // Closed once the download is complete.
+		err             error               // Only set if there was an error which prevented the download from completing.
+		framesCompleted map[uint64]struct{} // Frames of a compressed file that have been written to the destination.
 
 		// Timestamp information.
 		endTime         time.Time // Set immediately before closing 'completeChan'.
@@ -158,9 +145,11 @@ type (
 		destination           downloadDestination
 		destinationString     string // The string reported to the user to indicate the download's destination.
 		staticDestinationType string // "memory buffer", "http stream", "file", etc.
+		staticFetchLength     uint64 // Length of the data that is fetched, which is shorter than staticLength for compressed files.
 		staticLength          uint64 // Length to download starting from the offset.
 		staticOffset          uint64 // Offset within the file to start the download.
 		staticSiaPath         string // The path of the siafile at the time the download started.
+		staticUID             string // Identifies the download when it is cancelled or resumed.
 
 		// Retrieval settings for the file.
 		staticLatencyTarget time.Duration // In milliseconds. Lower latency results in lower total system throughput.
@@ -168,9 +157,10 @@ type (
 		staticPriority      uint64        // Downloads with higher priority will complete first.
 
 		// Utilities.
-		log           *persist.Logger // Same log as the renter.
-		memoryManager *memoryManager  // Same memoryManager used across the renter.
-		mu            sync.Mutex      // Unique to the download object.
+		log                *persist.Logger // Same log as the renter.
+		memoryManager      *memoryManager  // Same memoryManager used across the renter.
+		mu                 sync.Mutex      // Unique to the download object.
+		staticSaveProgress func() error    // Persists the completed chunks of the download, nil if the download isn't resumable.
 	}
 
 	// downloadParams is the set of parameters to use when downloading a file.
@@ -184,8 +174,14 @@ type (
 		length        uint64        // Length of download. Cannot be 0.
 		needsMemory   bool          // Whether new memory needs to be allocated to perform the download.
 		offset        uint64        // Offset within the file to start the download. Must be less than the total filesize.
-		overdrive     int           // How many extra pieces to download to prevent slow hosts from being a bottleneck.
+		overdrive     int           // How many extra pieces to download for chunks that take longer than expected.
+		partial       bool          // Whether chunks may be fetched partially if only a part of them is requested.
 		priority      uint64        // Files with a higher priority will be downloaded first.
+
+		saveProgress func() error        // Persists the completed chunks of a resumable download.
+		skipChunks   map[uint64]struct{} // Chunks that were written to the destination by an earlier attempt of the download.
+		skipFrames   map[uint64]struct{} // Frames of a compressed file that were written to the destination by an earlier attempt of the download.
+		uid          string              // The identifier of a resumed download. A new one is generated if empty.
 	}
 )
 
@@ -214,6 +210,21 @@ func (d *download) managedFail(err error) {
 	}
 }
 
+// managedCancel fails the download with err unless it has completed already.
+// Unlike managedFail, it may race with the completion of the download.
+func (d *download) managedCancel(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.staticComplete() {
+		return
+	}
+	d.err = err
+	close(d.completeChan)
+	if err := d.destination.Close(); err != nil {
+		d.log.Println("unable to close download destination:", err)
+	}
+}
+
 // staticComplete is a helper function to indicate whether or not the download
 // has completed.
 func (d *download) staticComplete() bool {
@@ -233,6 +244,19 @@ func (d *download) Err() (err error) {
 	return err
 }
 
+// received returns the amount of the download's data that has been received.
+// The data of a compressed file is fetched compressed, so the amount of data
+// that has been fetched is scaled to the length of the download.
+func (d *download) received() uint64 {
+	received := atomic.LoadUint64(&d.atomicDataReceived)
+	if d.staticFetchLength == d.staticLength {
+		return received
+	} else if received >= d.staticFetchLength {
+		return d.staticLength
+	}
+	return uint64(float64(received) / float64(d.staticFetchLength) * float64(d.staticLength))
+}
+
 // Download performs a file download using the passed parameters and blocks
 // until the download is finished.
 func (r *Renter) Download(p modules.RenterDownloadParameters) error {
@@ -282,60 +306,105 @@ func (r *Renter) managedDownload(p modules.RenterDownloadParameters) (*download,
 	if p.Destination != "" && !filepath.IsAbs(p.Destination) {
 		return nil, errors.New("destination must be an absolute path")
 	}
-	if p.Offset == file.size {
+	fileSize := file.dataSize()
+	if p.Offset == fileSize {
 		return nil, errors.New("offset equals filesize")
 	}
 	// Sentinel: if length == 0, download the entire file.
 	if p.Length == 0 {
-		p.Length = file.size - p.Offset
+		p.Length = fileSize - p.Offset
 	}
 	// Check whether offset and length is valid.
-	if p.Offset < 0 || p.Offset+p.Length > file.size {
-		return nil, fmt.Errorf("offset and length combination invalid, max byte is at index %d", file.size-1)
+	if p.Offset < 0 || p.Offset+p.Length > fileSize {
+		return nil, fmt.Errorf("offset and length combination invalid, max byte is at index %d", fileSize-1)
 	}
 
-	// Instantiate the correct downloadWriter implementation.
-	var dw downloadDestination
-	var destinationType string
+	// Instantiate the correct downloadWriter implementation. Downloads to a
+	// file are resumable.
+	params := userDownloadParams(file, p.Offset, p.Length, int(r.managedDownloadOverdrive()))
 	if isHTTPResp {
-		dw = newDownloadDestinationWriteCloserFromWriter(p.Httpwriter)
-		destinationType = "http stream"
+		params.destination = newDownloadDestinationWriteCloserFromWriter(p.Httpwriter)
+		params.destinationType = "http stream"
 	} else {
 		osFile, err := os.OpenFile(p.Destination, os.O_CREATE|os.O_WRONLY, os.FileMode(file.mode))
 		if err != nil {
 			return nil, err
 		}
-		dw = osFile
-		destinationType = "file"
+		params.destination = osFile
+		params.destinationType = "file"
+		params.destinationString = p.Destination
+		params.saveProgress = r.managedSaveDownloads
+	}
+	// The frames of a compressed file are downloaded and decompressed into
+	// the destination.
+	if file.compression != nil {
+		params = compressedDownloadParams(params, nil)
 	}
 
-	// Create the download object.
-	d, err := r.managedNewDownload(downloadParams{
-		destination:       dw,
-		destinationType:   destinationType,
-		destinationString: p.Destination,
-		file:              file,
-
-		latencyTarget: 25e3 * time.Millisecond, // TODO: high default until full latency support is added.
-		length:        p.Length,
-		needsMemory:   true,
-		offset:        p.Offset,
-		overdrive:     3, // TODO: moderate default until full overdrive support is added.
-		priority:      5, // TODO: moderate default until full priority support is added.
-	})
+	// Create the download object and add it to the download queue.
+	r.downloadHistoryMu.Lock()
+	defer r.downloadHistoryMu.Unlock()
+	d, err := r.managedNewDownload(params)
 	if err != nil {
 		return nil, err
 	}
-
-	// Add the download object to the download queue.
-	r.downloadHistoryMu.Lock()
 	r.downloadHistory = append(r.downloadHistory, d)
-	r.downloadHistoryMu.Unlock()
+	if params.saveProgress != nil {
+		if err := r.saveDownloads(); err != nil {
+			r.log.Println("WARN: couldn't save the new download:", err)
+		}
+	}
 
 	// Return the download object
 	return d, nil
 }
 
+// userDownloadParams returns the parameters of a download that was requested
+// by the user, without a destination.
+func userDownloadParams(file *file, offset, length uint64, overdrive int) downloadParams {
+	return downloadParams{
+		file: file,
+
+		latencyTarget: 25e3 * time.Millisecond, // TODO: high default until full latency support is added.
+		length:        length,
+		needsMemory:   true,
+		offset:        offset,
+		overdrive:     overdrive,
+		partial:       true,
+		priority:      5, // TODO: moderate default until full priority support is added.
+	}
+}
+
+// downloadChunkMaps maps the contract IDs of the pieces of the chunks between
+// minChunk and maxChunk of f to the pieces that the contracts are responsible
+// for.
+func (r *Renter) downloadChunkMaps(f *file, minChunk, maxChunk uint64) []map[types.FileContractID]downloadPieceInfo {
+	chunkMaps := make([]map[types.FileContractID]downloadPieceInfo, maxChunk-minChunk+1)
+	for i := range chunkMaps {
+		chunkMaps[i] = make(map[types.FileContractID]downloadPieceInfo)
+	}
+	f.mu.Lock()
+	for id, contract := range f.contracts {
+		resolvedID := r.hostContractor.ResolveID(id)
+		for _, piece := range contract.Pieces {
+			if piece.Chunk >= minChunk && piece.Chunk <= maxChunk {
+				// Sanity check - the same worker should not have two pieces for
+				// the same chunk.
+				_, exists := chunkMaps[piece.Chunk-minChunk][resolvedID]
+				if exists {
+					r.log.Println("ERROR: Worker has multiple pieces uploaded for the same chunk.")
+				}
+				chunkMaps[piece.Chunk-minChunk][resolvedID] = downloadPieceInfo{
+					index: piece.Piece,
+					root:  piece.MerkleRoot,
+				}
+			}
+		}
+	}
+	f.mu.Unlock()
+	return chunkMaps
+}
+
 // managedNewDownload creates and initializes a download based on the provided
 // parameters.
 func (r *Renter) managedNewDownload(params downloadParams) (*download, error) {
@@ -353,24 +422,60 @@ func (r *Renter) managedNewDownload(params downloadParams) (*download, error) {
 		return nil, errors.New("download is requesting data past the boundary of the file")
 	}
 
+	// The file has a reader until the download completes, so that its
+	// sectors aren't deleted if it is replaced in the meantime.
+	f := params.file
+	atomic.AddInt64(&f.atomicReaders, 1)
+
+	// The data of a packed file is downloaded from the chunk of its pack.
+	siaPath, offset := params.file.name, params.offset
+	if params.file.pack != nil {
+		params.offset += params.file.packOffset
+		params.file = params.file.pack
+	}
+	uid := params.uid
+	if uid == "" {
+		uid = persist.RandomSuffix()
+	}
+
 	// Create the download object.
 	d := &download{
-		completeChan: make(chan struct{}),
+		chunksCompleted: make(map[uint64]struct{}),
+		completeChan:    make(chan struct{}),
 
 		staticStartTime: time.Now(),
 
 		destination:           params.destination,
 		destinationString:     params.destinationString,
 		staticDestinationType: params.destinationType,
+		staticFetchLength:     params.length,
 		staticLatencyTarget:   params.latencyTarget,
 		staticLength:          params.length,
-		staticOffset:          params.offset,
+		staticOffset:          offset,
 		staticOverdrive:       params.overdrive,
-		staticSiaPath:         params.file.name,
+		staticSiaPath:         siaPath,
 		staticPriority:        params.priority,
+		staticUID:             uid,
 
-		log:           r.log,
-		memoryManager: r.memoryManager,
+		log:                r.log,
+		memoryManager:      r.memoryManager,
+		staticSaveProgress: params.saveProgress,
+	}
+	go func() {
+		<-d.completeChan
+		atomic.AddInt64(&f.atomicReaders, -1)
+	}()
+
+	// The decompressor of a compressed file records the frames that it writes
+	// in the download. The download reports the range of the file's data
+	// instead of the range of the frames.
+	if dd, ok := params.destination.(*downloadDestinationDecompressor); ok {
+		d.framesCompleted = make(map[uint64]struct{})
+		for index := range params.skipFrames {
+			d.framesCompleted[index] = struct{}{}
+		}
+		d.staticOffset, d.staticLength = dd.offset, dd.length
+		dd.download = d
 	}
 
 	// Determine which chunks to download.
@@ -378,45 +483,51 @@ func (r *Renter) managedNewDownload(params downloadParams) (*download, error) {
 	maxChunk := (params.offset + params.length - 1) / params.file.staticChunkSize()
 
 	// For each chunk, assemble a mapping from the contract id to the index of
-	// the piece within the chunk that the contract is responsible for.
-	chunkMaps := make([]map[types.FileContractID]downloadPieceInfo, maxChunk-minChunk+1)
-	for i := range chunkMaps {
-		chunkMaps[i] = make(map[types.FileContractID]downloadPieceInfo)
-	}
-	params.file.mu.Lock()
-	for id, contract := range params.file.contracts {
-		resolvedID := r.hostContractor.ResolveID(id)
-		for _, piece := range contract.Pieces {
-			if piece.Chunk >= minChunk && piece.Chunk <= maxChunk {
-				// Sanity check - the same worker should not have two pieces for
-				// the same chunk.
-				_, exists := chunkMaps[piece.Chunk-minChunk][resolvedID]
-				if exists {
-					r.log.Println("ERROR: Worker has multiple pieces uploaded for the same chunk.")
-				}
-				chunkMaps[piece.Chunk-minChunk][resolvedID] = downloadPieceInfo{
-					index: piece.Piece,
-					root:  piece.MerkleRoot,
-				}
-			}
+	// the piece within the chunk that the contract is responsible for. The
+	// chunks of a deduplicated file are downloaded from the only chunk of
+	// their blocks.
+	var chunkMaps []map[types.FileContractID]downloadPieceInfo
+	if params.file.blocks == nil {
+		chunkMaps = r.downloadChunkMaps(params.file, minChunk, maxChunk)
+	} else {
+		for i := minChunk; i <= maxChunk; i++ {
+			chunkMaps = append(chunkMaps, r.downloadChunkMaps(params.file.blocks[i], 0, 0)[0])
 		}
 	}
-	params.file.mu.Unlock()
 
 	// Queue the downloads for each chunk.
 	writeOffset := int64(0) // where to write a chunk within the download destination.
 	d.chunksRemaining += maxChunk - minChunk + 1
+	for i := range params.skipChunks {
+		if i >= minChunk && i <= maxChunk {
+			d.chunksRemaining--
+		}
+	}
+	downloadComplete := d.chunksRemaining == 0
 	for i := minChunk; i <= maxChunk; i++ {
+		data, dataIndex := params.file, i
+		if params.file.blocks != nil {
+			data, dataIndex = params.file.blocks[i], 0
+		}
+		data.mu.RLock()
+		checksum, dataLength := data.chunkChecksum(dataIndex), data.chunkDataSize(dataIndex)
+		segments := data.chunkSegmentChecksums(dataIndex)
+		data.mu.RUnlock()
+		masterKey, keyIndex := data.chunkKey(dataIndex)
 		udc := &unfinishedDownloadChunk{
 			destination: params.destination,
-			erasureCode: params.file.erasureCode,
-			masterKey:   params.file.masterKey,
-
-			staticChunkIndex: i,
-			staticCacheID:    fmt.Sprintf("%v:%v", d.staticSiaPath, i),
-			staticChunkMap:   chunkMaps[i-minChunk],
-			staticChunkSize:  params.file.staticChunkSize(),
-			staticPieceSize:  params.file.pieceSize,
+			erasureCode: data.erasureCode,
+			masterKey:   masterKey,
+
+			staticChunkIndex:       i,
+			staticKeyIndex:         keyIndex,
+			staticCacheID:          fmt.Sprintf("%v:%v", data.staticUID, dataIndex),
+			staticChunkMap:         chunkMaps[i-minChunk],
+			staticChunkSize:        params.file.staticChunkSize(),
+			staticChecksum:         checksum,
+			staticDataLength:       dataLength,
+			staticSegmentChecksums: segments,
+			staticPieceSize:        params.file.pieceSize,
 
 			// TODO: 25ms is just a guess for a good default. Really, we want to
 			// set the latency target such that slower workers will pick up the
@@ -437,6 +548,9 @@ func (r *Renter) managedNewDownload(params downloadParams) (*download, error) {
 			download:   d,
 			chunkCache: r.chunkCache,
 			cacheMu:    r.cmu,
+
+			diskCache:          r.diskCache,
+			staticDiskCacheKey: diskCacheKey(masterKey, keyIndex, dataLength),
 		}
 
 		// Set the fetchOffset - the offset within the chunk that we start
@@ -457,10 +571,29 @@ func (r *Renter) managedNewDownload(params downloadParams) (*download, error) {
 		// be written.
 		udc.staticWriteOffset = writeOffset
 		writeOffset += int64(udc.staticFetchLength)
+		// Only fetch the required parts of the pieces if the fetched data is
+		// contained in a single data piece. Otherwise, recovering the data
+		// requires the whole pieces anyway. Whole chunks are always recovered
+		// completely, so that they can be verified and cached, and so are
+		// chunks whose checksum is known but not the checksums of their
+		// segments, which can only be verified as a whole.
+		firstPiece := udc.staticFetchOffset / udc.staticPieceSize
+		lastPiece := (udc.staticFetchOffset + udc.staticFetchLength - 1) / udc.staticPieceSize
+		verifiable := checksum == (crypto.Hash{}) || segments != nil
+		udc.staticPartial = params.partial && verifiable && firstPiece == lastPiece && udc.staticFetchLength < udc.staticChunkSize
+
+		// Chunks that an earlier attempt of the download wrote to the
+		// destination are not downloaded again.
+		if _, skip := params.skipChunks[i]; skip {
+			d.mu.Lock()
+			d.chunksCompleted[i] = struct{}{}
+			d.mu.Unlock()
+			atomic.AddUint64(&d.atomicDataReceived, udc.staticFetchLength)
+			continue
+		}
 
-		// TODO: Currently all chunks are given overdrive. This should probably
-		// be changed once the hostdb knows how to measure host speed/latency
-		// and once we can assign overdrive dynamically.
+		// The extra pieces are only requested once the chunk takes longer
+		// than expected.
 		udc.staticOverdrive = params.overdrive
 
 		// Add this chunk to the chunk heap, and notify the download loop that
@@ -471,6 +604,15 @@ func (r *Renter) managedNewDownload(params downloadParams) (*download, error) {
 		default:
 		}
 	}
+
+	// A resumed download might not have any chunks left to download.
+	if downloadComplete {
+		d.endTime = time.Now()
+		close(d.completeChan)
+		if err := d.destination.Close(); err != nil {
+			return nil, err
+		}
+	}
 	return d, nil
 }
 
@@ -496,13 +638,15 @@ func (r *Renter) DownloadHistory() []modules.DownloadInfo {
 		downloads[i] = modules.DownloadInfo{
 			Destination:     d.destinationString,
 			DestinationType: d.staticDestinationType,
+			ID:              d.staticUID,
 			Length:          d.staticLength,
 			Offset:          d.staticOffset,
 			SiaPath:         d.staticSiaPath,
 
 			Completed:            d.staticComplete(),
 			EndTime:              d.endTime,
-			Received:             atomic.LoadUint64(&d.atomicDataReceived),
+			Received:             d.received(),
+			Resumable:            d.staticSaveProgress != nil && !d.cancelled,
 			StartTime:            d.staticStartTime,
 			TotalDataTransferred: atomic.LoadUint64(&d.atomicTotalDataTransferred),
 		}
@@ -4,6 +4,7 @@ package renter
 // via the API.
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/NebulousLabs/errors"
@@ -45,29 +46,40 @@ func (udc *unfinishedDownloadChunk) addChunkToCache(data []byte) {
 	}
 }
 
-// managedTryCache tries to retrieve the chunk from the renter's cache. If
-// successful it will write the data to the destination and stop the download
-// if it was the last missing chunk. The function returns true if the chunk was
-// in the cache.
-// TODO in the future we might need cache invalidation. At the
-// moment this doesn't worry us since our files are static.
+// managedTryCache tries to retrieve the chunk from the renter's cache, or from
+// the disk cache if it isn't in memory. If successful it will write the data
+// to the destination and stop the download if it was the last missing chunk.
+// The function returns true if the chunk was in the cache.
+// Chunks are cached by the UID of their file, so a file that replaces a
+// deleted or renamed file never receives the chunks of the old file.
 func (r *Renter) managedTryCache(udc *unfinishedDownloadChunk) bool {
 	udc.mu.Lock()
 	defer udc.mu.Unlock()
 	r.cmu.Lock()
 	cd, cached := r.chunkCache[udc.staticCacheID]
 	r.cmu.Unlock()
-	if !cached {
-		return false
+	var data []byte
+	if cached {
+		// chunk exists, updating lastAccess and reinserting into map
+		cd.lastAccess = time.Now()
+		r.chunkCache[udc.staticCacheID] = cd
+		data = cd.data
+	} else {
+		data, cached = r.managedTryDiskCache(udc)
+		if !cached {
+			return false
+		}
+		udc.addChunkToCache(data)
 	}
 
-	// chunk exists, updating lastAccess and reinserting into map
-	cd.lastAccess = time.Now()
-	r.chunkCache[udc.staticCacheID] = cd
-
 	start := udc.staticFetchOffset
 	end := start + udc.staticFetchLength
-	_, err := udc.destination.WriteAt(cd.data[start:end], udc.staticWriteOffset)
+	_, err := udc.destination.WriteAt(data[start:end], udc.staticWriteOffset)
+	// The data of a resumable download needs to be on disk before the chunk
+	// is recorded as completed.
+	if syncer, ok := udc.destination.(interface{ Sync() error }); ok && err == nil && udc.download.staticSaveProgress != nil {
+		err = syncer.Sync()
+	}
 	if err != nil {
 		r.log.Println("WARN: failed to write cached chunk to destination:", err)
 		udc.fail(errors.AddContext(err, "failed to write cached chunk to destination"))
@@ -77,10 +89,21 @@ func (r *Renter) managedTryCache(udc *unfinishedDownloadChunk) bool {
 	// Check if the download is complete now.
 	udc.download.mu.Lock()
 	udc.download.chunksRemaining--
-	if udc.download.chunksRemaining == 0 {
+	udc.download.chunksCompleted[udc.staticChunkIndex] = struct{}{}
+	atomic.AddUint64(&udc.download.atomicDataReceived, udc.staticFetchLength)
+	if udc.download.chunksRemaining == 0 && !udc.download.staticComplete() {
 		udc.download.endTime = time.Now()
 		close(udc.download.completeChan)
+		err = udc.download.destination.Close()
 	}
 	udc.download.mu.Unlock()
+	if err != nil {
+		r.log.Println("WARN: failed to close the destination of a download:", err)
+	}
+	if udc.download.staticSaveProgress != nil {
+		if err := udc.download.staticSaveProgress(); err != nil {
+			r.log.Println("WARN: couldn't save the progress of a download:", err)
+		}
+	}
 	return true
 }
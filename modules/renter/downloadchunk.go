@@ -49,23 +49,31 @@ type unfinishedDownloadChunk struct {
 	masterKey   crypto.TwofishKey
 
 	// Fetch + Write instructions - read only or otherwise thread safe.
-	staticChunkIndex  uint64                                     // Required for deriving the encryption keys for each piece.
+	staticChunkIndex  uint64                                     // Index of the chunk within the downloaded file.
+	staticKeyIndex    uint64                                     // Required for deriving the encryption keys for each piece.
 	staticCacheID     string                                     // Used to uniquely identify a chunk in the chunk cache.
 	staticChunkMap    map[types.FileContractID]downloadPieceInfo // Maps from file contract ids to the info for the piece associated with that contract
 	staticChunkSize   uint64
 	staticFetchLength uint64 // Length within the logical chunk to fetch.
 	staticFetchOffset uint64 // Offset within the logical chunk that is being downloaded.
+	staticPartial     bool   // Only fetch the parts of the pieces that are needed to recover the fetched data.
 	staticPieceSize   uint64
 	staticWriteOffset int64 // Offet within the writer to write the completed data.
 
+	// Verification of the recovered data - read only or otherwise thread safe.
+	staticChecksum         crypto.Hash   // Checksum of the data of the chunk, zero if unknown.
+	staticDataLength       uint64        // Length of the data of the chunk without padding.
+	staticSegmentChecksums []crypto.Hash // Checksums of the segments of the chunk, nil if unknown.
+
 	// Fetch + Write instructions - read only or otherwise thread safe.
 	staticLatencyTarget time.Duration
 	staticNeedsMemory   bool // Set to true if memory was not pre-allocated for this chunk.
-	staticOverdrive     int
+	staticOverdrive     int  // Maximum number of extra pieces to fetch, see overdrive.go.
 	staticPriority      uint64
 
 	// Download chunk state - need mutex to access.
 	failed            bool      // Indicates if the chunk has been marked as failed.
+	overdrive         int       // Number of extra pieces to fetch, set to staticOverdrive once overdrive kicks in.
 	physicalChunkData [][]byte  // Used to recover the logical data.
 	pieceUsage        []bool    // Which pieces are being actively fetched.
 	piecesCompleted   int       // Number of pieces that have successfully completed.
@@ -84,6 +92,11 @@ type unfinishedDownloadChunk struct {
 	// Caching related fields
 	chunkCache map[string]*cacheData
 	cacheMu    *sync.Mutex
+
+	// The chunk is stored in the disk cache under staticDiskCacheKey, see
+	// diskcache.go.
+	diskCache          *diskCache
+	staticDiskCacheKey crypto.Hash
 }
 
 // fail will set the chunk status to failed. The physical chunk memory will be
@@ -120,7 +133,7 @@ func (udc *unfinishedDownloadChunk) managedCleanUp() {
 
 	// Check whether standby workers are required.
 	chunkComplete := udc.piecesCompleted >= udc.erasureCode.MinPieces()
-	desiredPiecesRegistered := udc.erasureCode.MinPieces() + udc.staticOverdrive - udc.piecesCompleted
+	desiredPiecesRegistered := udc.erasureCode.MinPieces() + udc.overdrive - udc.piecesCompleted
 	standbyWorkersRequired := !chunkComplete && udc.piecesRegistered < desiredPiecesRegistered
 	if !standbyWorkersRequired {
 		udc.mu.Unlock()
@@ -178,6 +191,20 @@ func (udc *unfinishedDownloadChunk) returnMemory() {
 	}
 }
 
+// staticPieceRange returns the offset and length of the data within each piece
+// that is fetched by a partial download. A chunk is only downloaded partially
+// if the fetched data is contained in a single data piece. If the checksums of
+// the segments of the chunk are known, the range covers the whole segments that
+// contain the fetched data, so that they can be verified.
+func (udc *unfinishedDownloadChunk) staticPieceRange() (offset, length uint64) {
+	if udc.staticSegmentChecksums == nil {
+		return udc.staticFetchOffset % udc.staticPieceSize, udc.staticFetchLength
+	}
+	start, _ := segmentBounds(udc.staticFetchOffset, udc.staticPieceSize)
+	_, end := segmentBounds(udc.staticFetchOffset+udc.staticFetchLength-1, udc.staticPieceSize)
+	return start % udc.staticPieceSize, end - start
+}
+
 // threadedRecoverLogicalData will take all of the pieces that have been
 // downloaded and encode them into the logical data which is then written to the
 // underlying writer for the download.
@@ -186,6 +213,12 @@ func (udc *unfinishedDownloadChunk) threadedRecoverLogicalData() error {
 	// succeeds or fails.
 	defer udc.managedCleanUp()
 
+	// A partial chunk only consists of the requested part of every piece, which
+	// was already decrypted by the workers.
+	if udc.staticPartial {
+		return udc.recoverPartialData()
+	}
+
 	// Decrypt the chunk pieces. This doesn't need to happen under a lock,
 	// because any thread potentially writing to the physicalChunkData array is
 	// going to be stopped by the fact that the chunk is complete.
@@ -195,7 +228,7 @@ func (udc *unfinishedDownloadChunk) threadedRecoverLogicalData() error {
 			continue
 		}
 
-		key := deriveKey(udc.masterKey, udc.staticChunkIndex, uint64(i))
+		key := deriveKey(udc.masterKey, udc.staticKeyIndex, uint64(i))
 		decryptedPiece, err := key.DecryptBytes(udc.physicalChunkData[i])
 		if err != nil {
 			udc.mu.Lock()
@@ -226,20 +259,81 @@ func (udc *unfinishedDownloadChunk) threadedRecoverLogicalData() error {
 	// Get recovered data
 	recoveredData := recoverWriter.Bytes()
 
+	// Verify the recovered data before it's used.
+	if udc.staticChecksum != (crypto.Hash{}) && crypto.HashBytes(recoveredData[:udc.staticDataLength]) != udc.staticChecksum {
+		udc.mu.Lock()
+		udc.fail(errChecksumMismatch)
+		udc.mu.Unlock()
+		return errChecksumMismatch
+	}
+
 	// Add the chunk to the cache.
 	udc.addChunkToCache(recoveredData)
 
 	// Write the bytes to the requested output.
 	start := udc.staticFetchOffset
 	end := udc.staticFetchOffset + udc.staticFetchLength
-	_, err = udc.destination.WriteAt(recoveredData[start:end], udc.staticWriteOffset)
+	if err := udc.finishRecovery(recoveredData[start:end]); err != nil {
+		return err
+	}
+
+	// Add the chunk to the disk cache once the download doesn't wait for it
+	// anymore.
+	udc.addChunkToDiskCache(recoveredData)
+	return nil
+}
+
+// recoverPartialData recovers the fetched data of a partial chunk. Because the
+// erasure code operates on each byte position of the pieces independently, the
+// requested parts of the pieces can be recovered like full pieces. The fetched
+// data is then the part that belongs to the data piece containing it, which is
+// verified against the checksums of its segments if they are known.
+func (udc *unfinishedDownloadChunk) recoverPartialData() error {
+	offset, length := udc.staticPieceRange()
+	recoverWriter := new(bytes.Buffer)
+	err := udc.erasureCode.Recover(udc.physicalChunkData, uint64(udc.erasureCode.MinPieces())*length, recoverWriter)
+	if err != nil {
+		udc.mu.Lock()
+		udc.fail(err)
+		udc.mu.Unlock()
+		return errors.AddContext(err, "unable to recover chunk")
+	}
+	for i := range udc.physicalChunkData {
+		udc.physicalChunkData[i] = nil
+	}
+
+	// Verify the recovered segments before they are used.
+	piece := udc.staticFetchOffset / udc.staticPieceSize
+	data := recoverWriter.Bytes()[piece*length : (piece+1)*length]
+	start := piece*udc.staticPieceSize + offset
+	if udc.staticSegmentChecksums != nil && !verifySegments(data, start, udc.staticDataLength, udc.staticPieceSize, udc.staticSegmentChecksums) {
+		udc.mu.Lock()
+		udc.fail(errChecksumMismatch)
+		udc.mu.Unlock()
+		return errChecksumMismatch
+	}
+
+	// The partial data is not added to the cache, which only holds whole
+	// chunks.
+	start = udc.staticFetchOffset - start
+	return udc.finishRecovery(data[start : start+udc.staticFetchLength])
+}
+
+// finishRecovery writes the recovered data to the download destination and
+// marks the chunk as complete.
+func (udc *unfinishedDownloadChunk) finishRecovery(data []byte) error {
+	_, err := udc.destination.WriteAt(data, udc.staticWriteOffset)
+	// The data of a resumable download needs to be on disk before the chunk
+	// is recorded as completed.
+	if syncer, ok := udc.destination.(interface{ Sync() error }); ok && err == nil && udc.download.staticSaveProgress != nil {
+		err = syncer.Sync()
+	}
 	if err != nil {
 		udc.mu.Lock()
 		udc.fail(err)
 		udc.mu.Unlock()
 		return errors.AddContext(err, "unable to write to download destination")
 	}
-	recoverWriter = nil
 
 	// Now that the download has completed and been flushed from memory, we can
 	// release the memory that was used to store the data. Call 'cleanUp' to
@@ -251,15 +345,25 @@ func (udc *unfinishedDownloadChunk) threadedRecoverLogicalData() error {
 
 	// Update the download and signal completion of this chunk.
 	udc.download.mu.Lock()
-	defer udc.download.mu.Unlock()
 	udc.download.chunksRemaining--
+	udc.download.chunksCompleted[udc.staticChunkIndex] = struct{}{}
 	atomic.AddUint64(&udc.download.atomicDataReceived, udc.staticFetchLength)
-	if udc.download.chunksRemaining == 0 {
+	// A download that was cancelled is complete already.
+	if udc.download.chunksRemaining == 0 && !udc.download.staticComplete() {
 		// Download is complete, send out a notification and close the
 		// destination writer.
 		udc.download.endTime = time.Now()
 		close(udc.download.completeChan)
-		return udc.download.destination.Close()
+		err = udc.download.destination.Close()
 	}
-	return nil
+	udc.download.mu.Unlock()
+
+	// Record the progress of a resumable download. The download lock can't be
+	// held while saving, because saving acquires the lock of every download.
+	if udc.download.staticSaveProgress != nil {
+		if saveErr := udc.download.staticSaveProgress(); saveErr != nil {
+			udc.download.log.Println("WARN: couldn't save the progress of a download:", saveErr)
+		}
+	}
+	return err
 }
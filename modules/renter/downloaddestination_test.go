@@ -0,0 +1,33 @@
+package renter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestDownloadDestinationBufferWriteAt checks that data written to a
+// downloadDestinationBuffer can span multiple shards.
+func TestDownloadDestinationBufferWriteAt(t *testing.T) {
+	buf := NewDownloadDestinationBuffer(2*pieceSize + 1)
+	if len(buf) != 3 {
+		t.Fatal("expected 3 shards, got", len(buf))
+	}
+	data := fastrand.Bytes(int(pieceSize))
+	if _, err := buf.WriteAt(data, int64(pieceSize/2)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[0][pieceSize/2:], data[:pieceSize-pieceSize/2]) || !bytes.Equal(buf[1][:pieceSize/2], data[pieceSize-pieceSize/2:]) {
+		t.Fatal("data wasn't written across the shards")
+	}
+	if _, err := buf.WriteAt(data, int64(2*pieceSize)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[2], data) {
+		t.Fatal("data wasn't written to the last shard")
+	}
+	if _, err := buf.WriteAt(data, int64(2*pieceSize+1)); err == nil {
+		t.Fatal("expected a write past the end of the buffer to fail")
+	}
+}
@@ -117,23 +117,52 @@ func (r *Renter) managedBlockUntilOnline() bool {
 }
 
 // managedDistributeDownloadChunkToWorkers will take a chunk and pass it out to
-// all of the workers.
+// the fastest workers that hold a piece of the chunk. The slower workers are
+// put on standby and only work on the chunk if the faster workers fail or take
+// longer than expected.
 func (r *Renter) managedDistributeDownloadChunkToWorkers(udc *unfinishedDownloadChunk) {
+	fetchSize := udc.staticPieceSize
+	if udc.staticPartial {
+		_, fetchSize = udc.staticPieceRange()
+	}
+
 	// Distribute the chunk to workers, marking the number of workers
 	// that have received the work.
 	id := r.mu.Lock()
+	var workers []*worker
+	for _, worker := range r.workerPool {
+		if _, exists := udc.staticChunkMap[worker.contract.ID]; exists {
+			workers = append(workers, worker)
+		}
+	}
+	rankDownloadWorkers(workers, fetchSize)
+	preferred := udc.erasureCode.MinPieces()
+	if preferred > len(workers) {
+		preferred = len(workers)
+	}
 	udc.mu.Lock()
-	udc.workersRemaining = len(r.workerPool)
+	udc.workersRemaining = len(workers)
+	udc.workersStandby = append(udc.workersStandby, workers[preferred:]...)
 	udc.mu.Unlock()
-	for _, worker := range r.workerPool {
+	for _, worker := range workers[:preferred] {
 		worker.managedQueueDownloadChunk(udc)
 	}
 	r.mu.Unlock(id)
 
 	// If there are no workers, there will be no workers to attempt to clean up
 	// the chunk, so we must make sure that managedCleanUp is called at least
-	// once on the chunk.
-	udc.managedCleanUp()
+	// once on the chunk. Otherwise the workers clean up the chunk, cleaning it
+	// up here would take the slower workers off standby before the faster
+	// workers registered for the chunk.
+	if preferred == 0 {
+		udc.managedCleanUp()
+	}
+
+	// Request extra pieces from the standby workers if the chunk takes longer
+	// than the preferred workers are expected to take.
+	if udc.staticOverdrive > 0 && preferred < len(workers) {
+		go r.threadedOverdriveChunk(udc, overdriveTimeout(workers[:preferred], fetchSize))
+	}
 }
 
 // managedNextDownloadChunk will fetch the next chunk from the download heap. If
@@ -0,0 +1,289 @@
+package renter
+
+// downloadresume.go persists the downloads to files, so that they can be
+// resumed after the renter restarts or after they failed. Every time a chunk of
+// such a download is written to the destination, the set of completed chunks
+// of the download is saved. A resumed download writes into the same
+// destination file and skips the chunks that were completed before.
+//
+// Downloads that complete successfully or that are cancelled are removed from
+// the persisted downloads. Failed downloads are kept, they are resumed once
+// the renter restarts or when the user resumes them.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/persist"
+)
+
+const (
+	// downloadsFile is the file within the renter directory that contains the
+	// downloads that can be resumed.
+	downloadsFile = "downloads.json"
+)
+
+var (
+	// downloadsMetadata is the header of the downloads file.
+	downloadsMetadata = persist.Metadata{
+		Header:  "Renter Downloads",
+		Version: "1.0",
+	}
+
+	// errDownloadCancelled is the error of a download that was cancelled.
+	errDownloadCancelled = errors.New("download was cancelled")
+
+	// errDownloadCompleted is returned when a download that completed
+	// successfully is cancelled or resumed.
+	errDownloadCompleted = errors.New("download has already completed")
+
+	// errDownloadInProgress is returned when a download that is still in
+	// progress is resumed.
+	errDownloadInProgress = errors.New("download is still in progress")
+
+	// errDownloadNotResumable is returned when a download that isn't written
+	// to a file is resumed.
+	errDownloadNotResumable = errors.New("only downloads to a file can be resumed")
+
+	// errUnknownDownload is returned when no download with the given id
+	// exists.
+	errUnknownDownload = errors.New("no download with that id")
+)
+
+// persistDownload is the persisted state of a download that can be resumed.
+// The downloads of compressed files record the frames that were written to
+// the destination. See compression.go.
+type persistDownload struct {
+	UID             string   `json:"uid"`
+	SiaPath         string   `json:"siapath"`
+	Destination     string   `json:"destination"`
+	Offset          uint64   `json:"offset"`
+	Length          uint64   `json:"length"`
+	CompletedChunks []uint64 `json:"completedchunks"`
+	CompletedFrames []uint64 `json:"completedframes,omitempty"`
+}
+
+// persistData returns the persisted state of a resumable download. The lock of
+// the download needs to be held by the caller.
+func (d *download) persistData() persistDownload {
+	pd := persistDownload{
+		UID:         d.staticUID,
+		SiaPath:     d.staticSiaPath,
+		Destination: d.destinationString,
+		Offset:      d.staticOffset,
+		Length:      d.staticLength,
+	}
+	for index := range d.chunksCompleted {
+		pd.CompletedChunks = append(pd.CompletedChunks, index)
+	}
+	sort.Slice(pd.CompletedChunks, func(i, j int) bool {
+		return pd.CompletedChunks[i] < pd.CompletedChunks[j]
+	})
+	for index := range d.framesCompleted {
+		pd.CompletedFrames = append(pd.CompletedFrames, index)
+	}
+	sort.Slice(pd.CompletedFrames, func(i, j int) bool {
+		return pd.CompletedFrames[i] < pd.CompletedFrames[j]
+	})
+	return pd
+}
+
+// saveDownloads saves the downloads that can be resumed. The download history
+// lock needs to be held by the caller.
+func (r *Renter) saveDownloads() error {
+	downloads := []persistDownload{}
+	for _, d := range r.downloadHistory {
+		d.mu.Lock()
+		succeeded := d.staticComplete() && d.err == nil
+		if d.staticSaveProgress != nil && !d.cancelled && !succeeded {
+			downloads = append(downloads, d.persistData())
+		}
+		d.mu.Unlock()
+	}
+	return persist.SaveJSON(downloadsMetadata, downloads, filepath.Join(r.persistDir, downloadsFile))
+}
+
+// managedSaveDownloads saves the downloads that can be resumed.
+func (r *Renter) managedSaveDownloads() error {
+	r.downloadHistoryMu.Lock()
+	defer r.downloadHistoryMu.Unlock()
+	return r.saveDownloads()
+}
+
+// managedResumeDownload restarts a persisted download. The chunks that were
+// completed before are not downloaded again.
+func (r *Renter) managedResumeDownload(pd persistDownload) (*download, error) {
+	lockID := r.mu.RLock()
+	file, exists := r.files[pd.SiaPath]
+	r.mu.RUnlock(lockID)
+	if !exists {
+		return nil, fmt.Errorf("no file with that path: %s", pd.SiaPath)
+	}
+	if pd.Offset+pd.Length > file.dataSize() {
+		return nil, errors.New("file is smaller than the download")
+	}
+	osFile, err := os.OpenFile(pd.Destination, os.O_CREATE|os.O_WRONLY, os.FileMode(file.mode))
+	if err != nil {
+		return nil, err
+	}
+
+	params := userDownloadParams(file, pd.Offset, pd.Length, int(r.managedDownloadOverdrive()))
+	params.destination = osFile
+	params.destinationType = "file"
+	params.destinationString = pd.Destination
+	params.saveProgress = r.managedSaveDownloads
+	params.uid = pd.UID
+	// The chunks of a compressed file are skipped if all of their frames were
+	// written to the destination.
+	if file.compression != nil {
+		params = compressedDownloadParams(params, pd.CompletedFrames)
+	} else {
+		params.skipChunks = make(map[uint64]struct{})
+		for _, index := range pd.CompletedChunks {
+			params.skipChunks[index] = struct{}{}
+		}
+	}
+	d, err := r.managedNewDownload(params)
+	if err != nil {
+		osFile.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// newFailedDownload creates a download for the download history that failed
+// to be resumed. It can be resumed again later on.
+func (r *Renter) newFailedDownload(pd persistDownload, err error) *download {
+	d := &download{
+		chunksCompleted: make(map[uint64]struct{}),
+		completeChan:    make(chan struct{}),
+		endTime:         time.Now(),
+		err:             err,
+		framesCompleted: make(map[uint64]struct{}),
+
+		staticStartTime: time.Now(),
+
+		destinationString:     pd.Destination,
+		staticDestinationType: "file",
+		staticFetchLength:     pd.Length,
+		staticLength:          pd.Length,
+		staticOffset:          pd.Offset,
+		staticSiaPath:         pd.SiaPath,
+		staticUID:             pd.UID,
+
+		log:                r.log,
+		memoryManager:      r.memoryManager,
+		staticSaveProgress: r.managedSaveDownloads,
+	}
+	for _, index := range pd.CompletedChunks {
+		d.chunksCompleted[index] = struct{}{}
+	}
+	for _, index := range pd.CompletedFrames {
+		d.framesCompleted[index] = struct{}{}
+	}
+	close(d.completeChan)
+	return d
+}
+
+// managedLoadDownloads resumes the downloads that were in progress or had
+// failed when the renter shut down.
+func (r *Renter) managedLoadDownloads() error {
+	var downloads []persistDownload
+	err := persist.LoadJSON(downloadsMetadata, &downloads, filepath.Join(r.persistDir, downloadsFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	r.downloadHistoryMu.Lock()
+	defer r.downloadHistoryMu.Unlock()
+	for _, pd := range downloads {
+		d, err := r.managedResumeDownload(pd)
+		if err != nil {
+			r.log.Printf("WARN: couldn't resume the download of %v: %v", pd.SiaPath, err)
+			d = r.newFailedDownload(pd, err)
+		}
+		r.downloadHistory = append(r.downloadHistory, d)
+	}
+	return r.saveDownloads()
+}
+
+// download returns the index of the download with the given id within the
+// download history. The download history lock needs to be held by the caller.
+func (r *Renter) download(id string) (int, error) {
+	for i, d := range r.downloadHistory {
+		if d.staticUID == id {
+			return i, nil
+		}
+	}
+	return 0, errUnknownDownload
+}
+
+// CancelDownload stops a download and removes it from the downloads that are
+// resumed. The data that was already downloaded remains in the destination.
+func (r *Renter) CancelDownload(id string) error {
+	r.downloadHistoryMu.Lock()
+	defer r.downloadHistoryMu.Unlock()
+	i, err := r.download(id)
+	if err != nil {
+		return err
+	}
+	d := r.downloadHistory[i]
+	d.mu.Lock()
+	succeeded := d.staticComplete() && d.err == nil
+	if !succeeded {
+		d.cancelled = true
+	}
+	d.mu.Unlock()
+	if succeeded {
+		return errDownloadCompleted
+	}
+	d.managedFail(errDownloadCancelled)
+	return r.saveDownloads()
+}
+
+// ResumeDownload restarts a failed download that was written to a file. The
+// chunks that were written to the destination before are not downloaded
+// again.
+func (r *Renter) ResumeDownload(id string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	r.downloadHistoryMu.Lock()
+	defer r.downloadHistoryMu.Unlock()
+	i, err := r.download(id)
+	if err != nil {
+		return err
+	}
+	d := r.downloadHistory[i]
+	d.mu.Lock()
+	switch {
+	case d.staticSaveProgress == nil:
+		err = errDownloadNotResumable
+	case d.cancelled:
+		err = errDownloadCancelled
+	case !d.staticComplete():
+		err = errDownloadInProgress
+	case d.err == nil:
+		err = errDownloadCompleted
+	}
+	pd := d.persistData()
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	resumed, err := r.managedResumeDownload(pd)
+	if err != nil {
+		return err
+	}
+	r.downloadHistory[i] = resumed
+	return r.saveDownloads()
+}
@@ -0,0 +1,148 @@
+package renter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+)
+
+// persistedDownloads returns the ids of the downloads in the downloads file.
+func persistedDownloads(r *Renter) ([]string, error) {
+	var downloads []persistDownload
+	err := persist.LoadJSON(downloadsMetadata, &downloads, filepath.Join(r.persistDir, downloadsFile))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, pd := range downloads {
+		ids = append(ids, pd.UID)
+	}
+	return ids, nil
+}
+
+// downloadInfo returns the download with the given id from the download
+// history of the renter.
+func downloadInfo(r *Renter, id string) (modules.DownloadInfo, bool) {
+	for _, di := range r.DownloadHistory() {
+		if di.ID == id {
+			return di, true
+		}
+	}
+	return modules.DownloadInfo{}, false
+}
+
+// TestDownloadResume checks that persisted downloads are resumed without
+// downloading their completed chunks again, and that downloads can be
+// cancelled and resumed.
+func TestDownloadResume(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	dir := build.TempDir("renter", t.Name(), "downloads")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	f := newTestingFileWithPieces(3, 2)
+	f.mode = 0600
+	id := r.mu.Lock()
+	r.files[f.name] = f
+	r.mu.Unlock(id)
+
+	// Persist a download whose chunks were all completed and two downloads
+	// of a file that doesn't exist.
+	downloads := []persistDownload{{
+		UID:             "done",
+		SiaPath:         f.name,
+		Destination:     filepath.Join(dir, "done"),
+		Length:          f.size,
+		CompletedChunks: []uint64{0, 1, 2},
+	}, {
+		UID:             "missing1",
+		SiaPath:         "missing1",
+		Destination:     filepath.Join(dir, "missing1"),
+		Length:          10,
+		CompletedChunks: []uint64{0},
+	}, {
+		UID:         "missing2",
+		SiaPath:     "missing2",
+		Destination: filepath.Join(dir, "missing2"),
+		Length:      10,
+	}}
+	if err := persist.SaveJSON(downloadsMetadata, downloads, filepath.Join(r.persistDir, downloadsFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedLoadDownloads(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first download completes without downloading anything, the other
+	// downloads fail but remain resumable.
+	di, exists := downloadInfo(r, "done")
+	if !exists || !di.Completed || di.Error != "" || di.Received != f.size {
+		t.Fatal("download with completed chunks wasn't completed:", di)
+	}
+	for _, uid := range []string{"missing1", "missing2"} {
+		di, exists := downloadInfo(r, uid)
+		if !exists || !di.Completed || di.Error == "" || !di.Resumable {
+			t.Fatal("download of missing file should have failed:", di)
+		}
+	}
+	ids, err := persistedDownloads(r)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 2 || ids[0] != "missing1" || ids[1] != "missing2" {
+		t.Fatal("wrong persisted downloads:", ids)
+	}
+
+	// Once the file exists, the failed download can be resumed.
+	if err := r.ResumeDownload("done"); err != errDownloadCompleted {
+		t.Fatal("expected errDownloadCompleted, got", err)
+	}
+	missing := newTestingFileWithPieces(1, 2)
+	missing.name = "missing1"
+	missing.mode = 0600
+	id = r.mu.Lock()
+	r.files[missing.name] = missing
+	r.mu.Unlock(id)
+	if err := r.ResumeDownload("missing1"); err != nil {
+		t.Fatal(err)
+	}
+	di, _ = downloadInfo(r, "missing1")
+	if !di.Completed || di.Error != "" || di.Received != 10 {
+		t.Fatal("resumed download wasn't completed:", di)
+	}
+
+	// A cancelled download isn't persisted or resumed anymore.
+	if err := r.CancelDownload("unknown"); err != errUnknownDownload {
+		t.Fatal("expected errUnknownDownload, got", err)
+	}
+	if err := r.CancelDownload("done"); err != errDownloadCompleted {
+		t.Fatal("expected errDownloadCompleted, got", err)
+	}
+	if err := r.CancelDownload("missing2"); err != nil {
+		t.Fatal(err)
+	}
+	if di, _ := downloadInfo(r, "missing2"); di.Resumable {
+		t.Fatal("cancelled download is resumable")
+	}
+	if err := r.ResumeDownload("missing2"); err != errDownloadCancelled {
+		t.Fatal("expected errDownloadCancelled, got", err)
+	}
+	ids, err = persistedDownloads(r)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 0 {
+		t.Fatal("wrong persisted downloads:", ids)
+	}
+}
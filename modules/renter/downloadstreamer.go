@@ -1,15 +1,40 @@
 package renter
 
+// The streamer prefetches chunks ahead of sequential reads, so that sequential
+// playback doesn't stall at every chunk boundary. The number of prefetched
+// chunks grows with every chunk boundary that is crossed sequentially, up to
+// maxStreamReadAhead chunks. A read at any other offset than where the
+// previous read ended cancels all prefetches and starts over.
+//
+// Prefetches are downloaded at a lower priority than the downloads requested
+// by users, and they are only started if the memory manager has enough memory
+// available for them right away, so that they never hold up other downloads
+// while waiting for memory. The buffer of a prefetched chunk is requested from
+// the memory manager as well and returned once the chunk is dropped. Closing
+// the streamer cancels its downloads and drops all chunks, which happens when
+// the client of a stream disconnects.
+
 import (
 	"bytes"
 	"fmt"
 	"io"
 	"math"
+	"sync/atomic"
 	"time"
 
+	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/errors"
 )
 
+var (
+	// errStreamerClosed is returned when reading from a closed streamer.
+	errStreamerClosed = errors.New("streamer has been closed")
+
+	// errStreamChunkCancelled is the error of the download of a chunk that
+	// was cancelled because the reader of the stream moved elsewhere.
+	errStreamChunkCancelled = errors.New("download of stream chunk was cancelled")
+)
+
 type (
 	// streamer is a io.ReadSeeker that can be used to stream downloads from
 	// the sia network.
@@ -17,6 +42,27 @@ type (
 		file   *file
 		offset int64
 		r      *Renter
+
+		// readEnd is the offset at which the previous Read ended. A Read at a
+		// different offset follows a seek.
+		readEnd int64
+
+		// chunks are the downloads of whole chunks for sequential reads,
+		// indexed by chunk. They contain the chunk that is being read and the
+		// chunks that are prefetched ahead of it. sequentialChunks is the
+		// number of chunk boundaries that were crossed by sequential reads
+		// since the last seek.
+		chunks           map[uint64]*streamChunk
+		closed           bool
+		sequentialChunks int
+	}
+
+	// streamChunk is the download of a whole chunk of a stream. memory is
+	// the memory that was requested for the buffer of a prefetched chunk.
+	streamChunk struct {
+		buffer   *bytes.Buffer
+		download *download
+		memory   uint64
 	}
 )
 
@@ -31,9 +77,9 @@ func min(values ...uint64) uint64 {
 	return min
 }
 
-// Streamer creates an io.ReadSeeker that can be used to stream downloads from
-// the sia network.
-func (r *Renter) Streamer(siaPath string) (string, io.ReadSeeker, error) {
+// Streamer creates a modules.Streamer that can be used to stream downloads
+// from the sia network.
+func (r *Renter) Streamer(siaPath string) (string, modules.Streamer, error) {
 	// Lookup the file associated with the nickname.
 	lockID := r.mu.RLock()
 	file, exists := r.files[siaPath]
@@ -41,19 +87,132 @@ func (r *Renter) Streamer(siaPath string) (string, io.ReadSeeker, error) {
 	if !exists || file.deleted {
 		return "", nil, fmt.Errorf("no file with that path: %s", siaPath)
 	}
-	// Create the streamer
+	// Create the streamer. The data of a compressed file is streamed by
+	// decompressing the frames of a stream of its compressed data. The file
+	// has a reader until the streamer is closed.
+	atomic.AddInt64(&file.atomicReaders, 1)
 	s := &streamer{
-		file: file,
-		r:    r,
+		file:   file,
+		r:      r,
+		chunks: make(map[uint64]*streamChunk),
+	}
+	if file.compression != nil {
+		return file.name, &compressedStreamer{file: file, stream: s}, nil
 	}
 	return file.name, s, nil
 }
 
+// cancelChunks cancels the downloads of all chunks outside of [minIndex,
+// maxIndex) and drops them.
+func (s *streamer) cancelChunks(minIndex, maxIndex uint64) {
+	for index, c := range s.chunks {
+		if index < minIndex || index >= maxIndex {
+			c.download.managedCancel(errStreamChunkCancelled)
+			s.dropChunk(index)
+		}
+	}
+}
+
+// dropChunk removes the chunk at index from the streamer and returns the
+// memory of its buffer.
+func (s *streamer) dropChunk(index uint64) {
+	if c, exists := s.chunks[index]; exists {
+		s.r.memoryManager.Return(c.memory)
+		delete(s.chunks, index)
+	}
+}
+
+// managedDownloadChunk starts the download of the whole chunk at index.
+// Prefetches are downloaded with a low priority and without overdrive.
+func (s *streamer) managedDownloadChunk(index, fileSize uint64, prefetch bool) (*streamChunk, error) {
+	destinationType, overdrive, priority := destinationTypeSeekStream, int(s.r.managedDownloadOverdrive()), uint64(1000)
+	if prefetch {
+		// Prefetches yield to the downloads requested by users, but not to
+		// repairs.
+		destinationType, overdrive, priority = destinationTypePrefetch, 0, 1
+	}
+	chunkSize := s.file.staticChunkSize()
+	buffer := bytes.NewBuffer([]byte{})
+	d, err := s.r.managedNewDownload(downloadParams{
+		destination:       newDownloadDestinationWriteCloserFromWriter(buffer),
+		destinationType:   destinationType,
+		destinationString: "httpresponse",
+		file:              s.file,
+
+		latencyTarget: 50 * time.Millisecond, // TODO low default until full latency suport is added.
+		length:        min(chunkSize, fileSize-index*chunkSize),
+		needsMemory:   true,
+		offset:        index * chunkSize,
+		overdrive:     overdrive,
+		priority:      priority,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &streamChunk{
+		buffer:   buffer,
+		download: d,
+	}, nil
+}
+
+// managedPrefetch starts the downloads of the chunks following the chunk at
+// index. The number of prefetched chunks grows with the number of chunks that
+// were read sequentially.
+func (s *streamer) managedPrefetch(index, fileSize uint64) {
+	readAhead := uint64(s.sequentialChunks + 1)
+	if readAhead > uint64(maxStreamReadAhead) {
+		readAhead = uint64(maxStreamReadAhead)
+	}
+	// The buffer of a prefetched chunk is held until the chunk is dropped,
+	// its memory is requested right away. The download heap requests the
+	// memory for downloading the chunk once it is popped, see
+	// managedAcquireMemoryForDownloadChunk. Prefetches are only started if
+	// all of that memory is available right away, so that they don't hold up
+	// other downloads by waiting for memory.
+	chunkSize := s.file.staticChunkSize()
+	memoryRequired := uint64(s.file.erasureCode.MinPieces()) * s.file.pieceSize
+	for i := index + 1; i <= index+readAhead && i*chunkSize < fileSize; i++ {
+		if _, exists := s.chunks[i]; exists {
+			continue
+		}
+		if !s.r.memoryManager.TryRequest(chunkSize) {
+			return
+		}
+		if s.r.memoryManager.Available() < memoryRequired {
+			s.r.memoryManager.Return(chunkSize)
+			return
+		}
+		c, err := s.managedDownloadChunk(i, fileSize, true)
+		if err != nil {
+			s.r.memoryManager.Return(chunkSize)
+			s.r.log.Debugln("WARN: failed to prefetch chunk of stream:", err)
+			return
+		}
+		c.memory = chunkSize
+		s.chunks[i] = c
+	}
+}
+
+// Close cancels the downloads of the streamer that are still in progress.
+func (s *streamer) Close() error {
+	if s.closed {
+		return errStreamerClosed
+	}
+	s.closed = true
+	s.cancelChunks(0, 0)
+	atomic.AddInt64(&s.file.atomicReaders, -1)
+	return nil
+}
+
 // Read implements the standard Read interface. It will download the requested
 // data from the sia network and block until the download is complete.  To
 // prevent http.ServeContent from requesting too much data at once, Read can
 // only request a single chunk at once.
 func (s *streamer) Read(p []byte) (n int, err error) {
+	if s.closed {
+		return 0, errStreamerClosed
+	}
+
 	// Get the file's size
 	s.file.mu.RLock()
 	fileSize := int64(s.file.size)
@@ -71,6 +230,59 @@ func (s *streamer) Read(p []byte) (n int, err error) {
 	remainingChunk := chunkSize - uint64(s.offset)%chunkSize
 	length := min(remainingData, requestedData, remainingChunk)
 
+	// After a seek, only fetch the requested data instead of the whole chunk
+	// to reduce latency and cost.
+	if s.offset != s.readEnd {
+		s.sequentialChunks = 0
+		s.cancelChunks(0, 0)
+		return s.managedReadPartial(p[:length])
+	}
+
+	// Sequential reads fetch whole chunks, which are kept until the reader
+	// moves on to the next chunk, and prefetch the chunks ahead. A failed
+	// prefetch is retried at the priority of the reader.
+	index := uint64(s.offset) / chunkSize
+	if s.offset > 0 && uint64(s.offset)%chunkSize == 0 {
+		s.sequentialChunks++
+	}
+	s.cancelChunks(index, math.MaxUint64)
+	c, exists := s.chunks[index]
+	if exists && c.download.staticComplete() && c.download.Err() != nil {
+		s.dropChunk(index)
+		exists = false
+	}
+	if !exists {
+		c, err = s.managedDownloadChunk(index, uint64(fileSize), false)
+		if err != nil {
+			return 0, errors.AddContext(err, "failed to create new download")
+		}
+		s.chunks[index] = c
+	}
+	s.managedPrefetch(index, uint64(fileSize))
+
+	// Block until the download has completed.
+	select {
+	case <-c.download.completeChan:
+		if c.download.Err() != nil {
+			s.dropChunk(index)
+			return 0, errors.AddContext(c.download.Err(), "download failed")
+		}
+	case <-s.r.tg.StopChan():
+		return 0, errors.New("download interrupted by shutdown")
+	}
+
+	// Copy downloaded data into buffer.
+	copy(p[:length], c.buffer.Bytes()[uint64(s.offset)%chunkSize:])
+
+	// Adjust offset
+	s.offset += int64(length)
+	s.readEnd = s.offset
+	return int(length), nil
+}
+
+// managedReadPartial downloads len(p) bytes at the offset of the streamer
+// into p, without fetching the rest of the chunk.
+func (s *streamer) managedReadPartial(p []byte) (n int, err error) {
 	// Download data
 	buffer := bytes.NewBuffer([]byte{})
 	d, err := s.r.managedNewDownload(downloadParams{
@@ -80,10 +292,11 @@ func (s *streamer) Read(p []byte) (n int, err error) {
 		file:              s.file,
 
 		latencyTarget: 50 * time.Millisecond, // TODO low default until full latency suport is added.
-		length:        length,
+		length:        uint64(len(p)),
 		needsMemory:   true,
 		offset:        uint64(s.offset),
-		overdrive:     5,    // TODO: high default until full overdrive support is added.
+		overdrive:     int(s.r.managedDownloadOverdrive()),
+		partial:       true,
 		priority:      1000, // TODO: high default until full priority support is added.
 	})
 	if err != nil {
@@ -110,8 +323,9 @@ func (s *streamer) Read(p []byte) (n int, err error) {
 	copy(p, buffer.Bytes())
 
 	// Adjust offset
-	s.offset += int64(length)
-	return int(length), nil
+	s.offset += int64(len(p))
+	s.readEnd = s.offset
+	return len(p), nil
 }
 
 // Seek sets the offset for the next Read to offset, interpreted
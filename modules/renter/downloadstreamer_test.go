@@ -0,0 +1,102 @@
+package renter
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+)
+
+// TestStreamerReadAhead checks that the number of chunks that a streamer
+// prefetches grows with the number of chunks that were read sequentially, that
+// the buffers of prefetched chunks are accounted for by the memory manager, and
+// that seeking or closing the streamer cancels the prefetches.
+func TestStreamerReadAhead(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("file", ec, pieceSize, pieceSize*10)
+	id := r.mu.Lock()
+	r.files["file"] = f
+	r.mu.Unlock(id)
+	_, rs, err := r.Streamer("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rs.(*streamer)
+	initialMemory := r.memoryManager.Available()
+
+	// The first sequential chunk prefetches a single chunk, the read-ahead
+	// grows up to maxStreamReadAhead chunks.
+	fileSize := f.size
+	s.managedPrefetch(0, fileSize)
+	if _, exists := s.chunks[1]; !exists || len(s.chunks) != 1 {
+		t.Fatal("expected the next chunk to be prefetched, got", len(s.chunks))
+	} else if s.chunks[1].memory != f.staticChunkSize() || r.memoryManager.Available() > initialMemory-f.staticChunkSize() {
+		t.Fatal("memory of the prefetched chunk wasn't requested")
+	}
+	s.sequentialChunks = 10
+	s.managedPrefetch(1, fileSize)
+	if len(s.chunks) != maxStreamReadAhead+1 {
+		t.Fatal("wrong number of prefetched chunks:", len(s.chunks))
+	}
+
+	// Chunks beyond the end of the file are not prefetched.
+	s.managedPrefetch(9, fileSize)
+	if _, exists := s.chunks[10]; exists {
+		t.Fatal("chunk beyond the end of the file was prefetched")
+	}
+
+	// A read after a seek cancels the prefetches. The read itself fails,
+	// because the renter has no workers.
+	if _, err := s.Seek(int64(pieceSize)*5+1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	s.Read(make([]byte, 10))
+	if len(s.chunks) != 0 || s.sequentialChunks != 0 {
+		t.Fatal("seek didn't cancel the prefetches")
+	}
+
+	// Nothing is prefetched while the memory manager is out of memory.
+	available := r.memoryManager.Available()
+	if !r.memoryManager.Request(available, memoryPriorityHigh) {
+		t.Fatal("couldn't request memory")
+	}
+	s.managedPrefetch(0, fileSize)
+	r.memoryManager.Return(available)
+	if len(s.chunks) != 0 {
+		t.Fatal("chunk was prefetched without memory")
+	}
+
+	// Closing the streamer cancels the prefetches.
+	s.managedPrefetch(0, fileSize)
+	prefetched := s.chunks[1]
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.chunks) != 0 || !prefetched.download.staticComplete() {
+		t.Fatal("closing the streamer didn't cancel the prefetches")
+	}
+	err = build.Retry(50, 10*time.Millisecond, func() error {
+		if available := r.memoryManager.Available(); available != initialMemory {
+			return fmt.Errorf("memory wasn't returned: %v != %v", available, initialMemory)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(make([]byte, 10)); err != errStreamerClosed {
+		t.Fatal("expected reading from a closed streamer to fail, got", err)
+	}
+}
@@ -0,0 +1,166 @@
+package renter
+
+// filemetadata.go manages the application metadata of files and the search of
+// files. Applications can attach key/value pairs to a file when it is uploaded,
+// and replace them later. The metadata is stored in the header of the .sia
+// file, so the number and size of the entries is bounded.
+//
+// A search filters the files by the prefix of their siapath, their metadata,
+// their size and their health. The health of a file is only computed for files
+// that are returned, unless the search filters by health.
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+var (
+	// errFileMetadataEmptyKey is returned when the application metadata of a
+	// file has an empty key.
+	errFileMetadataEmptyKey = errors.New("metadata keys can't be empty")
+
+	// errFileMetadataInvalidKey is returned when a key of the application
+	// metadata of a file contains a '='.
+	errFileMetadataInvalidKey = errors.New("metadata keys can't contain '='")
+
+	// errFileMetadataTooLarge is returned when the application metadata of a
+	// file exceeds the bounds on the number or size of its entries.
+	errFileMetadataTooLarge = errors.New("metadata exceeds the maximum number or size of entries")
+)
+
+// validateFileMetadata checks that the application metadata of a file is
+// within the bounds on the number and size of its entries.
+func validateFileMetadata(metadata map[string]string) error {
+	if len(metadata) > maxFileMetadataEntries {
+		return errFileMetadataTooLarge
+	}
+	size := 0
+	for key, value := range metadata {
+		if key == "" {
+			return errFileMetadataEmptyKey
+		} else if strings.Contains(key, "=") {
+			return errFileMetadataInvalidKey
+		} else if len(key) > maxFileMetadataKeySize || len(value) > maxFileMetadataValueSize {
+			return errFileMetadataTooLarge
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxFileMetadataSize {
+		return errFileMetadataTooLarge
+	}
+	return nil
+}
+
+// copyFileMetadata returns a copy of the application metadata of a file, or
+// nil if there is no metadata.
+func copyFileMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		c[key] = value
+	}
+	return c
+}
+
+// matchesQuery returns whether the siapath, metadata and size of the file
+// match the query. The health of the file is checked separately.
+func (f *file) matchesQuery(q modules.FileSearchQuery) bool {
+	if !strings.HasPrefix(f.name, q.Prefix) {
+		return false
+	}
+	if size := f.dataSize(); size < q.MinSize || (q.MaxSize != 0 && size > q.MaxSize) {
+		return false
+	}
+	for key, value := range q.Metadata {
+		if v, exists := f.metadata[key]; !exists || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SetFileMetadata replaces the application metadata of the file at siaPath.
+func (r *Renter) SetFileMetadata(siaPath string, metadata map[string]string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if err := validateFileMetadata(metadata); err != nil {
+		return err
+	}
+
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f, exists := r.files[siaPath]
+	if !exists {
+		return ErrUnknownPath
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	oldMetadata := f.metadata
+	f.metadata = copyFileMetadata(metadata)
+	if err := r.saveFile(f); err != nil {
+		f.metadata = oldMetadata
+		return err
+	}
+	return nil
+}
+
+// SearchFiles returns the files that match the query, sorted by siapath, and
+// the total number of matching files.
+func (r *Renter) SearchFiles(q modules.FileSearchQuery) ([]modules.FileInfo, uint64) {
+	// Get the files that match the query, except for their health.
+	var files []*file
+	id := r.mu.RLock()
+	for _, f := range r.files {
+		f.mu.RLock()
+		matches := f.matchesQuery(q)
+		f.mu.RUnlock()
+		if matches {
+			files = append(files, f)
+		}
+	}
+	r.mu.RUnlock(id)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+
+	// The health of the files is only computed if the search filters by
+	// health, otherwise only the infos of the requested page are needed.
+	var infos []modules.FileInfo
+	var total uint64
+	if q.MinHealth == 0 && q.MaxHealth == 0 {
+		total = uint64(len(files))
+		start, end := searchPage(total, q.Offset, q.Limit)
+		infos = r.managedFileInfos(files[start:end])
+	} else {
+		for _, info := range r.managedFileInfos(files) {
+			if info.Health >= q.MinHealth && (q.MaxHealth == 0 || info.Health <= q.MaxHealth) {
+				infos = append(infos, info)
+			}
+		}
+		total = uint64(len(infos))
+		start, end := searchPage(total, q.Offset, q.Limit)
+		infos = infos[start:end]
+	}
+	return infos, total
+}
+
+// searchPage returns the bounds of the page of n search results that starts
+// at offset and contains up to limit results, or all remaining results if
+// limit is 0.
+func searchPage(n, offset, limit uint64) (start, end uint64) {
+	if offset >= n {
+		return n, n
+	}
+	end = n
+	if limit != 0 && limit < n-offset {
+		end = offset + limit
+	}
+	return offset, end
+}
@@ -0,0 +1,164 @@
+package renter
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// TestValidateFileMetadata checks the bounds on the application metadata of a
+// file.
+func TestValidateFileMetadata(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i <= maxFileMetadataEntries; i++ {
+		tooMany[string(rune('a'+i%26))+strings.Repeat("x", i/26)] = ""
+	}
+	tooLarge := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		tooLarge[string(rune('a'+i))] = strings.Repeat("x", maxFileMetadataValueSize)
+	}
+	tests := []struct {
+		metadata map[string]string
+		err      error
+	}{
+		{nil, nil},
+		{map[string]string{"content-type": "text/plain", "tag": ""}, nil},
+		{map[string]string{"": "value"}, errFileMetadataEmptyKey},
+		{map[string]string{"a=b": "value"}, errFileMetadataInvalidKey},
+		{map[string]string{strings.Repeat("k", maxFileMetadataKeySize+1): ""}, errFileMetadataTooLarge},
+		{map[string]string{"key": strings.Repeat("v", maxFileMetadataValueSize+1)}, errFileMetadataTooLarge},
+		{tooMany, errFileMetadataTooLarge},
+		{tooLarge, errFileMetadataTooLarge},
+	}
+	for i, test := range tests {
+		if err := validateFileMetadata(test.metadata); err != test.err {
+			t.Errorf("%v: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+// TestSetFileMetadata checks that the application metadata of a file can be
+// replaced and that it is persisted.
+func TestSetFileMetadata(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("file", ec, pieceSize, pieceSize)
+	id := r.mu.Lock()
+	r.files["file"] = f
+	r.mu.Unlock(id)
+
+	metadata := map[string]string{"content-type": "text/plain"}
+	if err := r.SetFileMetadata("file", metadata); err != nil {
+		t.Fatal(err)
+	}
+	metadata["content-type"] = "changed"
+	info, err := r.File("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata["content-type"] != "text/plain" {
+		t.Fatal("wrong metadata in file info:", info.Metadata)
+	}
+	loaded, err := loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.metadata, map[string]string{"content-type": "text/plain"}) {
+		t.Fatal("metadata wasn't persisted:", loaded.metadata)
+	}
+
+	// Invalid metadata is rejected and doesn't change the file.
+	if err := r.SetFileMetadata("file", map[string]string{"": ""}); err != errFileMetadataEmptyKey {
+		t.Fatal("expected invalid metadata to be rejected, got", err)
+	}
+	if err := r.SetFileMetadata("missing", nil); err != ErrUnknownPath {
+		t.Fatal("expected ErrUnknownPath, got", err)
+	}
+
+	// Setting no metadata removes it.
+	if err := r.SetFileMetadata("file", nil); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.metadata) != 0 {
+		t.Fatal("metadata wasn't removed:", loaded.metadata)
+	}
+}
+
+// TestSearchFiles checks that searches filter files by their siapath, metadata
+// and size, and that the results are paginated.
+func TestSearchFiles(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	files := []struct {
+		name     string
+		size     uint64
+		metadata map[string]string
+	}{
+		{"photos/b.jpg", 200, map[string]string{"type": "image", "year": "2018"}},
+		{"photos/a.jpg", 100, map[string]string{"type": "image", "year": "2017"}},
+		{"photos/c.png", 300, map[string]string{"type": "image"}},
+		{"docs/a.txt", 10, map[string]string{"type": "text"}},
+	}
+	id := r.mu.Lock()
+	for _, file := range files {
+		f := newFile(file.name, ec, pieceSize, file.size)
+		f.metadata = file.metadata
+		r.files[file.name] = f
+	}
+	r.mu.Unlock(id)
+
+	names := func(infos []modules.FileInfo) (names []string) {
+		for _, info := range infos {
+			names = append(names, info.SiaPath)
+		}
+		return names
+	}
+	tests := []struct {
+		query modules.FileSearchQuery
+		names []string
+		total uint64
+	}{
+		{modules.FileSearchQuery{}, []string{"docs/a.txt", "photos/a.jpg", "photos/b.jpg", "photos/c.png"}, 4},
+		{modules.FileSearchQuery{Prefix: "photos/"}, []string{"photos/a.jpg", "photos/b.jpg", "photos/c.png"}, 3},
+		{modules.FileSearchQuery{Metadata: map[string]string{"type": "image", "year": "2018"}}, []string{"photos/b.jpg"}, 1},
+		{modules.FileSearchQuery{Metadata: map[string]string{"year": ""}}, nil, 0},
+		{modules.FileSearchQuery{MinSize: 100, MaxSize: 200}, []string{"photos/a.jpg", "photos/b.jpg"}, 2},
+		{modules.FileSearchQuery{Prefix: "photos/", Offset: 1, Limit: 1}, []string{"photos/b.jpg"}, 3},
+		{modules.FileSearchQuery{Prefix: "photos/", Offset: 2, Limit: 5}, []string{"photos/c.png"}, 3},
+		{modules.FileSearchQuery{Offset: 4}, nil, 4},
+		// None of the files are uploaded, so their health is 0.
+		{modules.FileSearchQuery{MinHealth: 1}, nil, 0},
+		{modules.FileSearchQuery{MaxHealth: 1, Limit: 1}, []string{"docs/a.txt"}, 4},
+	}
+	for i, test := range tests {
+		infos, total := r.SearchFiles(test.query)
+		if !reflect.DeepEqual(names(infos), test.names) || total != test.total {
+			t.Errorf("%v: expected %v of %v, got %v of %v", i, test.names, test.total, names(infos), total)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/crypto"
@@ -29,17 +30,86 @@ var (
 // master key. The pieces are uploaded to hosts in groups, such that one file
 // contract covers many pieces.
 type file struct {
+	// atomicReaders is the number of downloads and streamers that read the
+	// file. The sectors of a replaced file are only deleted once it has no
+	// readers. See redundancy.go.
+	atomicReaders int64
+
 	name        string
-	size        uint64 // Static - can be accessed without lock.
+	size        uint64 // Static - can be accessed without lock, except while the file is streamed.
 	contracts   map[types.FileContractID]fileContract
 	masterKey   crypto.TwofishKey    // Static - can be accessed without lock.
 	erasureCode modules.ErasureCoder // Static - can be accessed without lock.
 	pieceSize   uint64               // Static - can be accessed without lock.
 	mode        uint32               // actually an os.FileMode
+	modTime     time.Time            // Modification time of the source of the upload, zero if unknown.
 	deleted     bool                 // indicates if the file has been deleted.
 
 	staticUID string // A UID assigned to the file when it gets created.
 
+	// The data of a packed file is stored at packOffset within the chunk of
+	// pack. pack is nil if the file is not packed. packRefs contains the
+	// files stored in a pack, it is protected by the renter's lock.
+	pack       *file  // Static - can be accessed without lock.
+	packOffset uint64 // Static - can be accessed without lock.
+	packRefs   map[*file]struct{}
+
+	// The data of chunk i of a deduplicated file is stored in blocks[i],
+	// blocks is nil if the file is not deduplicated. dedupBlock is set if the
+	// file is a block itself. dedupRefs maps the files that reference a block
+	// to the indices of the chunks that reference it, it is protected by the
+	// renter's lock.
+	blocks     []*file // Static - can be accessed without lock.
+	dedupBlock bool    // Static - can be accessed without lock.
+	dedupRefs  map[*file][]uint64
+
+	// checksum is the checksum of the plaintext of the file and
+	// chunkChecksums contains the checksums of the plaintext of its chunks.
+	// Unknown checksums are zero, chunkChecksums is nil if the checksums of
+	// all chunks are unknown. segmentChecksums contains the checksums of the
+	// segments of the chunks, it is nil if they are unknown. See checksum.go.
+	checksum         crypto.Hash
+	chunkChecksums   []crypto.Hash
+	segmentChecksums [][]crypto.Hash
+
+	// chunkKeys contains the keys of the chunks of a file that was
+	// concatenated from other files, chunkKeys is nil if the keys of all
+	// chunks are derived from masterKey and the index of the chunk. See
+	// concat.go.
+	chunkKeys []chunkKey // Static - can be accessed without lock.
+
+	// repairFailures counts the consecutive failed repairs of the chunks of
+	// the file, it is not persisted. Chunks that failed maxRepairAttempts
+	// times in a row are added to stuckChunks. Stuck chunks are only retried
+	// every stuckChunkRetryInterval.
+	repairFailures map[uint64]int
+	stuckChunks    map[uint64]struct{}
+
+	// The chunks of files with a higher priority are uploaded and repaired
+	// first. The chunks of a paused file are not uploaded or repaired at all.
+	priority uint64
+	paused   bool
+
+	// metadata is the application metadata of the file, nil if the file has
+	// none. See filemetadata.go.
+	metadata map[string]string
+
+	// compression describes the compressed frames that the data of a
+	// compressed file is stored as, it is nil if the file is not compressed.
+	// The size of a compressed file is the size of its compressed data. See
+	// compression.go.
+	compression *fileCompression // Static - can be accessed without lock.
+
+	// contractTable is the order in which the contracts of the file are
+	// stored in its on-disk metadata. headerPages, contractPages and
+	// chunkPages are the number of pages reserved for the header, for the
+	// contract table and for each chunk. They are zero if the file hasn't
+	// been saved yet.
+	contractTable []types.FileContractID
+	headerPages   uint64
+	contractPages uint64
+	chunkPages    uint64
+
 	mu sync.RWMutex
 }
 
@@ -69,6 +139,15 @@ func deriveKey(masterKey crypto.TwofishKey, chunkIndex, pieceIndex uint64) crypt
 	return crypto.TwofishKey(crypto.HashAll(masterKey, chunkIndex, pieceIndex))
 }
 
+// chunkKey returns the master key and the index that the keys of the pieces
+// of the chunk at index are derived from.
+func (f *file) chunkKey(index uint64) (crypto.TwofishKey, uint64) {
+	if f.chunkKeys != nil {
+		return f.chunkKeys[index].MasterKey, f.chunkKeys[index].Index
+	}
+	return f.masterKey, index
+}
+
 // staticChunkSize returns the size of one chunk.
 func (f *file) staticChunkSize() uint64 {
 	return f.pieceSize * uint64(f.erasureCode.MinPieces())
@@ -88,8 +167,56 @@ func (f *file) numChunks() uint64 {
 	return n
 }
 
-// available indicates whether the file is ready to be downloaded.
+// chunkDataSize returns the number of bytes of the file's data that are stored
+// in the chunk at index. Only the last chunk can be smaller than a full chunk.
+func (f *file) chunkDataSize(index uint64) uint64 {
+	if remaining := f.size - index*f.staticChunkSize(); remaining < f.staticChunkSize() {
+		return remaining
+	}
+	return f.staticChunkSize()
+}
+
+// addContractIDs adds the IDs of the contracts that store the data of the
+// file to ids. The data of a packed file is stored by the contracts of its
+// pack, the data of a deduplicated file by the contracts of its blocks.
+func (f *file) addContractIDs(ids map[types.FileContractID]struct{}) {
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		f.pack.addContractIDs(ids)
+		return
+	}
+	for _, block := range f.blocks {
+		block.mu.RLock()
+		block.addContractIDs(ids)
+		block.mu.RUnlock()
+	}
+	for cid := range f.contracts {
+		ids[cid] = struct{}{}
+	}
+}
+
+// available indicates whether the file is ready to be downloaded. The
+// availability, redundancy and upload progress of a packed file are those of
+// its pack. A deduplicated file is as available and redundant as its least
+// available and redundant block.
 func (f *file) available(offline map[types.FileContractID]bool) bool {
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		return f.pack.available(offline)
+	}
+	if f.blocks != nil {
+		for _, block := range f.blocks {
+			block.mu.RLock()
+			available := block.available(offline)
+			block.mu.RUnlock()
+			if !available {
+				return false
+			}
+		}
+		return true
+	}
 	chunkPieces := make([]int, f.numChunks())
 	for _, fc := range f.contracts {
 		if offline[fc.ID] {
@@ -107,10 +234,130 @@ func (f *file) available(offline map[types.FileContractID]bool) bool {
 	return true
 }
 
+// numStuckChunks returns the number of chunks that repeatedly failed to be
+// repaired.
+func (f *file) numStuckChunks() uint64 {
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		return f.pack.numStuckChunks()
+	}
+	if f.blocks != nil {
+		var stuck uint64
+		for _, block := range f.blocks {
+			block.mu.RLock()
+			stuck += block.numStuckChunks()
+			block.mu.RUnlock()
+		}
+		return stuck
+	}
+	return uint64(len(f.stuckChunks))
+}
+
+// isStuck returns true if the chunk at index is stuck.
+func (f *file) isStuck(index uint64) bool {
+	_, stuck := f.stuckChunks[index]
+	return stuck
+}
+
+// setStuck marks the chunk at index as stuck or not stuck. It returns true if
+// the status of the chunk changed.
+func (f *file) setStuck(index uint64, stuck bool) bool {
+	if f.isStuck(index) == stuck {
+		return false
+	}
+	if !stuck {
+		delete(f.stuckChunks, index)
+		return true
+	}
+	if f.stuckChunks == nil {
+		f.stuckChunks = make(map[uint64]struct{})
+	}
+	f.stuckChunks[index] = struct{}{}
+	return true
+}
+
+// chunkHealth returns the health of every chunk of the file. The health of a
+// chunk is the number of unique pieces of the chunk that are stored on online
+// hosts that are good for renewal, divided by the number of pieces needed to
+// recover the chunk.
+func (f *file) chunkHealth(offline map[types.FileContractID]bool, goodForRenew map[types.FileContractID]bool) []float64 {
+	numPieces := uint64(f.erasureCode.NumPieces())
+	goodPieces := make([][]bool, f.numChunks())
+	for i := range goodPieces {
+		goodPieces[i] = make([]bool, numPieces)
+	}
+	for _, fc := range f.contracts {
+		if offline[fc.ID] || !goodForRenew[fc.ID] {
+			continue
+		}
+		for _, p := range fc.Pieces {
+			if p.Chunk < uint64(len(goodPieces)) && p.Piece < numPieces {
+				goodPieces[p.Chunk][p.Piece] = true
+			}
+		}
+	}
+	health := make([]float64, len(goodPieces))
+	for i, pieces := range goodPieces {
+		var n int
+		for _, good := range pieces {
+			if good {
+				n++
+			}
+		}
+		health[i] = float64(n) / float64(f.erasureCode.MinPieces())
+	}
+	return health
+}
+
+// health returns the health of the least healthy chunk of the file. The health
+// of a packed file is the health of its pack, the health of a deduplicated file
+// is the health of its least healthy block.
+func (f *file) health(offline map[types.FileContractID]bool, goodForRenew map[types.FileContractID]bool) float64 {
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		return f.pack.health(offline, goodForRenew)
+	}
+	if f.blocks != nil {
+		health := math.Inf(1)
+		for _, block := range f.blocks {
+			block.mu.RLock()
+			health = math.Min(health, block.health(offline, goodForRenew))
+			block.mu.RUnlock()
+		}
+		return health
+	}
+	chunkHealth := f.chunkHealth(offline, goodForRenew)
+	health := chunkHealth[0]
+	for _, h := range chunkHealth {
+		if h < health {
+			health = h
+		}
+	}
+	return health
+}
+
 // uploadedBytes indicates how many bytes of the file have been uploaded via
 // current file contracts. Note that this includes padding and redundancy, so
 // uploadedBytes can return a value much larger than the file's original filesize.
+// The blocks of a deduplicated file are counted once for every chunk that
+// references them.
 func (f *file) uploadedBytes() uint64 {
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		return f.pack.uploadedBytes()
+	}
+	if f.blocks != nil {
+		var uploaded uint64
+		for _, block := range f.blocks {
+			block.mu.RLock()
+			uploaded += block.uploadedBytes()
+			block.mu.RUnlock()
+		}
+		return uploaded
+	}
 	var uploaded uint64
 	for _, fc := range f.contracts {
 		// Note: we need to multiply by SectorSize here instead of
@@ -125,6 +372,11 @@ func (f *file) uploadedBytes() uint64 {
 // been uploaded. Note that a file may be Available long before UploadProgress
 // reaches 100%, and UploadProgress may report a value greater than 100%.
 func (f *file) uploadProgress() float64 {
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		return f.pack.uploadProgress()
+	}
 	uploaded := f.uploadedBytes()
 	desired := modules.SectorSize * uint64(f.erasureCode.NumPieces()) * f.numChunks()
 
@@ -139,6 +391,20 @@ func (f *file) redundancy(offlineMap map[types.FileContractID]bool, goodForRenew
 	if f.size == 0 {
 		return -1
 	}
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		return f.pack.redundancy(offlineMap, goodForRenewMap)
+	}
+	if f.blocks != nil {
+		redundancy := math.Inf(1)
+		for _, block := range f.blocks {
+			block.mu.RLock()
+			redundancy = math.Min(redundancy, block.redundancy(offlineMap, goodForRenewMap))
+			block.mu.RUnlock()
+		}
+		return redundancy
+	}
 	piecesPerChunk := make([]int, f.numChunks())
 	piecesPerChunkNoRenew := make([]int, f.numChunks())
 	// If the file has non-0 size then the number of chunks should also be
@@ -195,6 +461,22 @@ func (f *file) redundancy(offlineMap map[types.FileContractID]bool, goodForRenew
 // expiration returns the lowest height at which any of the file's contracts
 // will expire.
 func (f *file) expiration() types.BlockHeight {
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		return f.pack.expiration()
+	}
+	if f.blocks != nil {
+		lowest := ^types.BlockHeight(0)
+		for _, block := range f.blocks {
+			block.mu.RLock()
+			if expiration := block.expiration(); expiration < lowest {
+				lowest = expiration
+			}
+			block.mu.RUnlock()
+		}
+		return lowest
+	}
 	if len(f.contracts) == 0 {
 		return 0
 	}
@@ -221,90 +503,82 @@ func newFile(name string, code modules.ErasureCoder, pieceSize, fileSize uint64)
 	}
 }
 
-// DeleteFile removes a file entry from the renter and deletes its data from
-// the hosts it is stored on.
+// deleteFile removes a file from the renter's file set and deletes its
+// metadata from disk. The directory tree is not updated.
+func (r *Renter) deleteFile(nickname string, f *file) {
+	delete(r.files, nickname)
+	delete(r.tracking, nickname)
+
+	err := persist.RemoveFile(filepath.Join(r.persistDir, f.name+ShareExtension))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove file :", err)
+	}
+}
+
+// DeleteFile moves a file entry to the renter's trash. The file is purged
+// once the trash retention has passed.
 //
 // TODO: The data is not cleared from any contracts where the host is not
 // immediately online.
 func (r *Renter) DeleteFile(nickname string) error {
+	return r.managedDeleteFile(nickname, true)
+}
+
+// managedDeleteFile removes a file entry from the renter. The file is moved to
+// the trash if trash is set, otherwise it is purged right away.
+func (r *Renter) managedDeleteFile(nickname string, trash bool) error {
 	lockID := r.mu.Lock()
+	defer r.mu.Unlock(lockID)
 	f, exists := r.files[nickname]
 	if !exists {
-		r.mu.Unlock(lockID)
 		return ErrUnknownPath
 	}
-	delete(r.files, nickname)
-	delete(r.tracking, nickname)
-
-	err := persist.RemoveFile(filepath.Join(r.persistDir, f.name+ShareExtension))
-	if err != nil {
-		r.log.Println("WARN: couldn't remove file :", err)
+	r.removeFileFromDirs(nickname, f.dataSize())
+	if trash {
+		if err := r.trashFile(nickname, f); err != nil {
+			r.addFileToDirs(f)
+			return err
+		}
+	} else {
+		r.purgeFile(nickname, f)
 	}
 
-	r.saveSync()
-	r.mu.Unlock(lockID)
-
-	// delete the file's associated contract data.
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	// mark the file as deleted
-	f.deleted = true
-
 	// TODO: delete the sectors of the file as well.
 
-	return nil
+	return r.saveSync()
 }
 
 // FileList returns all of the files that the renter has.
 func (r *Renter) FileList() []modules.FileInfo {
-	// Get all the files and their contracts
+	// Get all the files.
 	var files []*file
-	contractIDs := make(map[types.FileContractID]struct{})
 	lockID := r.mu.RLock()
 	for _, f := range r.files {
 		files = append(files, f)
+	}
+	r.mu.RUnlock(lockID)
+	return r.managedFileInfos(files)
+}
+
+// managedFileInfos returns the FileInfos of the files.
+func (r *Renter) managedFileInfos(files []*file) []modules.FileInfo {
+	contractIDs := make(map[types.FileContractID]struct{})
+	for _, f := range files {
 		f.mu.RLock()
-		for cid := range f.contracts {
-			contractIDs[cid] = struct{}{}
-		}
+		f.addContractIDs(contractIDs)
 		f.mu.RUnlock()
 	}
-	r.mu.RUnlock(lockID)
 
 	// Build 2 maps that map every contract id to its offline and goodForRenew
 	// status.
-	goodForRenew := make(map[types.FileContractID]bool)
-	offline := make(map[types.FileContractID]bool)
-	for cid := range contractIDs {
-		resolvedID := r.hostContractor.ResolveID(cid)
-		cu, ok := r.hostContractor.ContractUtility(resolvedID)
-		goodForRenew[cid] = ok && cu.GoodForRenew
-		offline[cid] = r.hostContractor.IsOffline(resolvedID)
-	}
+	offline, goodForRenew := r.contractStatus(contractIDs)
 
 	// Build the list of FileInfos.
 	var fileList []modules.FileInfo
 	for _, f := range files {
 		lockID := r.mu.RLock()
 		f.mu.RLock()
-		renewing := true
-		var localPath string
-		tf, exists := r.tracking[f.name]
-		if exists {
-			localPath = tf.RepairPath
-		}
-		fileList = append(fileList, modules.FileInfo{
-			SiaPath:        f.name,
-			LocalPath:      localPath,
-			Filesize:       f.size,
-			Renewing:       renewing,
-			Available:      f.available(offline),
-			Redundancy:     f.redundancy(offline, goodForRenew),
-			UploadedBytes:  f.uploadedBytes(),
-			UploadProgress: f.uploadProgress(),
-			Expiration:     f.expiration(),
-		})
+		fileList = append(fileList, r.fileInfo(f, offline, goodForRenew))
 		f.mu.RUnlock()
 		r.mu.RUnlock(lockID)
 	}
@@ -326,41 +600,49 @@ func (r *Renter) File(siaPath string) (modules.FileInfo, error) {
 	}
 	file.mu.RLock()
 	defer file.mu.RUnlock()
-	for cid := range file.contracts {
-		contractIDs[cid] = struct{}{}
-	}
+	file.addContractIDs(contractIDs)
 
 	// Build 2 maps that map every contract id to its offline and goodForRenew
 	// status.
-	goodForRenew := make(map[types.FileContractID]bool)
-	offline := make(map[types.FileContractID]bool)
-	for cid := range contractIDs {
-		resolvedID := r.hostContractor.ResolveID(cid)
-		cu, ok := r.hostContractor.ContractUtility(resolvedID)
-		goodForRenew[cid] = ok && cu.GoodForRenew
-		offline[cid] = r.hostContractor.IsOffline(resolvedID)
-	}
+	offline, goodForRenew := r.contractStatus(contractIDs)
 
 	// Build the FileInfo
+	fileInfo = r.fileInfo(file, offline, goodForRenew)
+	return fileInfo, nil
+}
+
+// fileInfo builds the FileInfo of a file. The renter's lock and the file's
+// read lock need to be held by the caller.
+func (r *Renter) fileInfo(f *file, offline map[types.FileContractID]bool, goodForRenew map[types.FileContractID]bool) modules.FileInfo {
 	renewing := true
 	var localPath string
-	tf, exists := r.tracking[file.name]
+	tf, exists := r.tracking[f.name]
 	if exists {
 		localPath = tf.RepairPath
 	}
-	fileInfo = modules.FileInfo{
-		SiaPath:        file.name,
+	return modules.FileInfo{
+		SiaPath:        f.name,
 		LocalPath:      localPath,
-		Filesize:       file.size,
+		Filesize:       f.dataSize(),
 		Renewing:       renewing,
-		Available:      file.available(offline),
-		Redundancy:     file.redundancy(offline, goodForRenew),
-		UploadedBytes:  file.uploadedBytes(),
-		UploadProgress: file.uploadProgress(),
-		Expiration:     file.expiration(),
+		Available:      f.available(offline),
+		Redundancy:     f.redundancy(offline, goodForRenew),
+		UploadedBytes:  f.uploadedBytes(),
+		UploadProgress: f.uploadProgress(),
+		Expiration:     f.expiration(),
+		Health:         f.health(offline, goodForRenew),
+		StuckChunks:    f.numStuckChunks(),
+		Priority:       f.priority,
+		UploadPaused:   f.paused,
+		Deduplicated:   f.blocks != nil,
+		DataPieces:     uint64(f.erasureCode.MinPieces()),
+		ParityPieces:   uint64(f.erasureCode.NumPieces() - f.erasureCode.MinPieces()),
+		Compression:    f.compression.codecName(),
+		CompressedSize: f.size,
+		Checksum:       f.checksum,
+		ModTime:        f.modTime,
+		Metadata:       copyFileMetadata(f.metadata),
 	}
-
-	return fileInfo, nil
 }
 
 // RenameFile takes an existing file and changes the nickname. The original
@@ -385,10 +667,81 @@ func (r *Renter) RenameFile(currentName, newName string) error {
 		return ErrPathOverload
 	}
 
+	// Move the file within the directory tree.
+	r.removeFileFromDirs(currentName, file.dataSize())
+	err = r.moveFile(file, currentName, newName)
+	r.addFileToDirs(file)
+	if err != nil {
+		return err
+	}
+	return r.saveSync()
+}
+
+// ReplaceFile changes the nickname of an existing file like RenameFile, but
+// replaces the file that already has the replacement nickname. The replaced
+// file is moved to the trash. If the file can't be renamed, the replaced file
+// is restored, so the replacement nickname always refers to one of the files.
+func (r *Renter) ReplaceFile(currentName, newName string) error {
+	lockID := r.mu.Lock()
+	defer r.mu.Unlock(lockID)
+
+	err := validateSiapath(newName)
+	if err != nil {
+		return err
+	}
+	file, exists := r.files[currentName]
+	if !exists {
+		return ErrUnknownPath
+	}
+	old, exists := r.files[newName]
+	if !exists {
+		r.removeFileFromDirs(currentName, file.dataSize())
+		err = r.moveFile(file, currentName, newName)
+		r.addFileToDirs(file)
+		if err != nil {
+			return err
+		}
+		return r.saveSync()
+	} else if old == file {
+		return nil
+	}
+
+	// Move the replaced file to the trash, even if the trash is disabled, so
+	// that it can be restored if the rename fails.
+	r.removeFileFromDirs(newName, old.dataSize())
+	tf, err := r.moveToTrash(newName, old)
+	if err != nil {
+		r.addFileToDirs(old)
+		return err
+	}
+	r.removeFileFromDirs(currentName, file.dataSize())
+	err = r.moveFile(file, currentName, newName)
+	r.addFileToDirs(file)
+	if err != nil && r.files[newName] != file {
+		if restoreErr := r.restoreTrashedFile(tf); restoreErr != nil {
+			r.log.Println("WARN: couldn't restore replaced file:", restoreErr)
+		}
+		return err
+	}
+	if r.trashRetention == 0 {
+		r.purgeTrashedFile(tf)
+	}
+	if err != nil {
+		return err
+	}
+	return r.saveSync()
+}
+
+// moveFile changes the name of a file, saves it under the new name and
+// deletes the old .sia file. The directory tree is not updated.
+func (r *Renter) moveFile(file *file, currentName, newName string) error {
 	// Modify the file and save it to disk.
 	file.mu.Lock()
 	file.name = newName
-	err = r.saveFile(file)
+	err := r.saveFile(file)
+	if err != nil {
+		file.name = currentName
+	}
 	file.mu.Unlock()
 	if err != nil {
 		return err
@@ -401,9 +754,8 @@ func (r *Renter) RenameFile(currentName, newName string) error {
 		delete(r.tracking, currentName)
 		r.tracking[newName] = t
 	}
-	err = r.saveSync()
-	if err != nil {
-		return err
+	if rc := r.activeRedundancyChange(file); rc != nil {
+		rc.SiaPath = newName
 	}
 
 	// Delete the old .sia file.
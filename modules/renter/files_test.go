@@ -213,6 +213,62 @@ func TestFileRedundancy(t *testing.T) {
 	}
 }
 
+// TestFileHealth probes the chunkHealth and health methods of the file type.
+func TestFileHealth(t *testing.T) {
+	rsc, _ := NewRSCode(2, 2)
+	f := &file{
+		size:        1000,
+		pieceSize:   300,
+		contracts:   make(map[types.FileContractID]fileContract),
+		erasureCode: rsc,
+	}
+	if f.numChunks() != 2 {
+		t.Fatal("expected 2 chunks, got", f.numChunks())
+	}
+	if h := f.health(nil, nil); h != 0 {
+		t.Error("expected 0 health, got", h)
+	}
+
+	// Only unique pieces on online contracts that are good for renewal count
+	// towards the health of a chunk.
+	goodForRenew := map[types.FileContractID]bool{
+		{0}: true,
+		{1}: true,
+		{2}: true,
+	}
+	offline := map[types.FileContractID]bool{
+		{2}: true,
+	}
+	f.contracts[types.FileContractID{0}] = fileContract{
+		ID:     types.FileContractID{0},
+		Pieces: []pieceData{{Chunk: 0, Piece: 0}, {Chunk: 1, Piece: 0}},
+	}
+	f.contracts[types.FileContractID{1}] = fileContract{
+		ID:     types.FileContractID{1},
+		Pieces: []pieceData{{Chunk: 0, Piece: 0}, {Chunk: 0, Piece: 1}},
+	}
+	f.contracts[types.FileContractID{2}] = fileContract{
+		ID:     types.FileContractID{2},
+		Pieces: []pieceData{{Chunk: 1, Piece: 1}},
+	}
+	f.contracts[types.FileContractID{3}] = fileContract{
+		ID:     types.FileContractID{3},
+		Pieces: []pieceData{{Chunk: 1, Piece: 2}},
+	}
+	chunkHealth := f.chunkHealth(offline, goodForRenew)
+	if len(chunkHealth) != 2 || chunkHealth[0] != 1 || chunkHealth[1] != 0.5 {
+		t.Error("wrong chunk health:", chunkHealth)
+	}
+	if h := f.health(offline, goodForRenew); h != 0.5 {
+		t.Error("expected 0.5 health, got", h)
+	}
+
+	// Once the offline contract comes back online, the file is healthy.
+	if h := f.health(nil, goodForRenew); h != 1 {
+		t.Error("expected a health of 1, got", h)
+	}
+}
+
 // TestFileExpiration probes the expiration method of the file type.
 func TestFileExpiration(t *testing.T) {
 	f := &file{
@@ -285,6 +341,10 @@ func TestRenterDeleteFile(t *testing.T) {
 	}
 	defer rt.Close()
 
+	// Disable the trash so that deleted files are purged right away. The
+	// trash is tested in trash_test.go.
+	rt.renter.trashRetention = 0
+
 	// Delete a file from an empty renter.
 	err = rt.renter.DeleteFile("dne")
 	if err != ErrUnknownPath {
@@ -448,3 +508,65 @@ func TestRenterRenameFile(t *testing.T) {
 		t.Error("renaming should have updated the entry in the tracking set")
 	}
 }
+
+// TestRenterReplaceFile probes the ReplaceFile method of the renter type.
+func TestRenterReplaceFile(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// Replace a file that doesn't exist.
+	if err := r.ReplaceFile("1", "2"); err != ErrUnknownPath {
+		t.Error("Expecting ErrUnknownPath:", err)
+	}
+
+	// Without an existing file, ReplaceFile renames the file.
+	f1, f2 := newTestingFile(), newTestingFile()
+	f1.name, f2.name = "1", "2"
+	r.files["1"] = f1
+	if err := r.ReplaceFile("1", "2"); err != nil {
+		t.Fatal(err)
+	} else if r.files["2"] != f1 || r.files["1"] != nil {
+		t.Fatal("file wasn't renamed")
+	}
+
+	// The replaced file is moved to the trash.
+	r.files["1"] = f2
+	f2.name = "1"
+	if err := r.ReplaceFile("1", "2"); err != nil {
+		t.Fatal(err)
+	} else if r.files["2"] != f2 || r.files["1"] != nil {
+		t.Fatal("file wasn't replaced")
+	} else if trash := r.Trash(); len(trash) != 1 || trash[0].SiaPath != "2" {
+		t.Fatal("replaced file wasn't moved to the trash:", trash)
+	}
+	for _, tf := range r.trash {
+		if tf.file != f1 {
+			t.Fatal("wrong file in the trash")
+		}
+	}
+
+	// Without a trash, the replaced file is purged.
+	if err := r.managedSetTrashRetention(0); err != nil {
+		t.Fatal(err)
+	}
+	f3 := newTestingFile()
+	f3.name = "3"
+	r.files["3"] = f3
+	if err := r.ReplaceFile("3", "2"); err != nil {
+		t.Fatal(err)
+	} else if r.files["2"] != f3 || !f2.deleted {
+		t.Fatal("replaced file wasn't purged")
+	}
+	for _, tf := range r.trash {
+		if tf.file == f2 {
+			t.Fatal("replaced file was moved to the disabled trash")
+		}
+	}
+}
@@ -144,6 +144,7 @@ func (hdb *HostDB) updateEntry(entry modules.HostDBEntry, netErr error) {
 	newEntry, exists := hdb.hostTree.Select(entry.PublicKey)
 	if exists {
 		newEntry.HostExternalSettings = entry.HostExternalSettings
+		newEntry.ProtocolVersion = entry.ProtocolVersion
 	} else {
 		newEntry = entry
 	}
@@ -256,6 +257,7 @@ func (hdb *HostDB) managedScanHost(entry modules.HostDBEntry) {
 	hdb.mu.RUnlock()
 
 	var settings modules.HostExternalSettings
+	var ext modules.HostSettingsExtension
 	var latency time.Duration
 	err := func() error {
 		timeout := hostRequestTimeout
@@ -303,7 +305,8 @@ func (hdb *HostDB) managedScanHost(entry modules.HostDBEntry) {
 		}
 		var pubkey crypto.PublicKey
 		copy(pubkey[:], pubKey.Key)
-		return crypto.ReadSignedObject(conn, &settings, maxSettingsLen, pubkey)
+		settings, ext, err = modules.ReadHostSettings(conn, pubkey, maxSettingsLen)
+		return err
 	}()
 	if err != nil {
 		hdb.log.Debugf("Scan of host at %v failed: %v", netAddr, err)
@@ -311,6 +314,7 @@ func (hdb *HostDB) managedScanHost(entry modules.HostDBEntry) {
 	} else {
 		hdb.log.Debugf("Scan of host at %v succeeded.", netAddr)
 		entry.HostExternalSettings = settings
+		entry.ProtocolVersion = ext.ProtocolVersion
 	}
 	success := err == nil
 
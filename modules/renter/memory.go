@@ -95,6 +95,17 @@ func (mm *memoryManager) Request(amount uint64, priority bool) bool {
 	}
 }
 
+// TryRequest is a non-blocking request for memory. It returns false right away
+// if the memory can't be acquired without waiting.
+func (mm *memoryManager) TryRequest(amount uint64) bool {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if len(mm.fifo) > 0 || len(mm.priorityFifo) > 0 {
+		return false
+	}
+	return mm.try(amount)
+}
+
 // Return will return memory to the manager, waking any blocking threads which
 // now have enough memory to proceed.
 func (mm *memoryManager) Return(amount uint64) {
@@ -148,6 +159,18 @@ func (mm *memoryManager) Return(amount uint64) {
 	}
 }
 
+// Available returns the amount of memory that can be requested without
+// blocking. No memory is available while other requests are waiting for
+// memory.
+func (mm *memoryManager) Available() uint64 {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if len(mm.fifo) > 0 || len(mm.priorityFifo) > 0 {
+		return 0
+	}
+	return mm.available
+}
+
 // newMemoryManager will create a memoryManager and return it.
 func newMemoryManager(baseMemory uint64, stopChan <-chan struct{}) *memoryManager {
 	return &memoryManager{
@@ -0,0 +1,130 @@
+package renter
+
+// overdrive.go requests extra pieces for chunks whose download takes longer
+// than expected. A chunk is first given to the MinPieces workers that are
+// expected to be the fastest, see workerstats.go. Once the chunk has been
+// waiting for overdriveTimeoutMultiplier times the expected download time of
+// the slowest of these workers, the standby workers are asked for up to
+// staticOverdrive extra pieces. The chunk is recovered from the first MinPieces
+// pieces that arrive, the workers that didn't start fetching their piece by
+// then drop the chunk. Pieces that are already being transferred can't be
+// cancelled.
+//
+// The money spent on the pieces that arrive after a chunk already had enough
+// pieces is reported as the OverdriveSpending of the current period. It is
+// saved by the periodic save of the renter rather than for every piece.
+
+import (
+	"math"
+	"time"
+
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// overdriveTimeout returns how long a chunk may take before overdrive kicks in
+// if it was given to workers, which download size bytes of a piece each.
+func overdriveTimeout(workers []*worker, size uint64) time.Duration {
+	var slowest time.Duration
+	unknown := false
+	for _, w := range workers {
+		estimate := w.managedEstimateDownloadTime(size)
+		if estimate == 0 {
+			unknown = true
+		} else if estimate > slowest {
+			slowest = estimate
+		}
+	}
+	if slowest > math.MaxInt64/overdriveTimeoutMultiplier {
+		return math.MaxInt64
+	}
+	timeout := overdriveTimeoutMultiplier * slowest
+	if unknown && timeout < defaultOverdriveTimeout {
+		timeout = defaultOverdriveTimeout
+	}
+	return timeout
+}
+
+// managedStartOverdrive asks the standby workers of the chunk for its extra
+// pieces, unless the chunk is already complete.
+func (udc *unfinishedDownloadChunk) managedStartOverdrive() {
+	udc.mu.Lock()
+	chunkComplete := udc.piecesCompleted >= udc.erasureCode.MinPieces()
+	if chunkComplete || udc.failed || udc.overdrive == udc.staticOverdrive {
+		udc.mu.Unlock()
+		return
+	}
+	udc.overdrive = udc.staticOverdrive
+	udc.mu.Unlock()
+
+	// Cleaning up the chunk queues it with the standby workers. The workers
+	// that aren't needed go back on standby.
+	udc.managedCleanUp()
+}
+
+// threadedOverdriveChunk starts the overdrive of the chunk if it didn't
+// complete within timeout.
+func (r *Renter) threadedOverdriveChunk(udc *unfinishedDownloadChunk, timeout time.Duration) {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-udc.download.completeChan:
+		return
+	case <-r.tg.StopChan():
+		return
+	}
+	udc.managedStartOverdrive()
+}
+
+// managedDownloadOverdrive returns the number of extra pieces that are
+// requested for chunks that take longer than expected.
+func (r *Renter) managedDownloadOverdrive() uint64 {
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	return r.downloadOverdrive
+}
+
+// managedSetDownloadOverdrive sets the number of extra pieces that are
+// requested for chunks that take longer than expected. Downloads that are in
+// progress keep their overdrive.
+func (r *Renter) managedSetDownloadOverdrive(overdrive uint64) error {
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if r.downloadOverdrive == overdrive {
+		return nil
+	}
+	r.downloadOverdrive = overdrive
+	return r.saveSync()
+}
+
+// managedRecordOverdriveSpending adds the cost of a piece that arrived after
+// its chunk already had enough pieces to the overdrive spending of the current
+// period.
+func (r *Renter) managedRecordOverdriveSpending(cost types.Currency) {
+	period := r.hostContractor.CurrentPeriod()
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if r.overdrivePeriod != period {
+		r.overdrivePeriod = period
+		r.overdriveSpending = types.ZeroCurrency
+	}
+	r.overdriveSpending = r.overdriveSpending.Add(cost)
+	r.unsavedChanges = true
+}
+
+// managedOverdriveSpending returns the overdrive spending of the current
+// period.
+func (r *Renter) managedOverdriveSpending() types.Currency {
+	period := r.hostContractor.CurrentPeriod()
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	if r.overdrivePeriod != period {
+		return types.ZeroCurrency
+	}
+	return r.overdriveSpending
+}
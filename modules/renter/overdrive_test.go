@@ -0,0 +1,152 @@
+package renter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	siasync "github.com/NebulousLabs/Sia/sync"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestOverdriveTimeout checks that overdrive kicks in after the expected
+// download time of the slowest worker, or after defaultOverdriveTimeout if the
+// download time of a worker is unknown.
+func TestOverdriveTimeout(t *testing.T) {
+	fast, slow, unknown := new(worker), new(worker), new(worker)
+	fast.managedRecordDownload(time.Second, 2*time.Second, 1000)
+	slow.managedRecordDownload(2*time.Second, 3*time.Second, 1000)
+	if timeout := overdriveTimeout([]*worker{fast, slow}, 1000); timeout != overdriveTimeoutMultiplier*3*time.Second {
+		t.Fatal("wrong timeout:", timeout)
+	}
+	if timeout := overdriveTimeout([]*worker{unknown}, 1000); timeout != defaultOverdriveTimeout {
+		t.Fatal("wrong timeout for unknown worker:", timeout)
+	}
+	if timeout := overdriveTimeout([]*worker{fast, unknown}, 1000); timeout < defaultOverdriveTimeout || timeout < overdriveTimeoutMultiplier*2*time.Second {
+		t.Fatal("wrong timeout for known and unknown worker:", timeout)
+	}
+	failing := new(worker)
+	failing.managedRecordDownloadFailure()
+	if timeout := overdriveTimeout([]*worker{fast, failing}, 1000); timeout != math.MaxInt64 {
+		t.Fatal("wrong timeout for failing worker:", timeout)
+	}
+}
+
+// TestStartOverdrive checks that starting the overdrive of a chunk queues the
+// chunk with its standby workers.
+func TestStartOverdrive(t *testing.T) {
+	r := &Renter{
+		mu:         siasync.New(modules.SafeMutexDelay, 1),
+		workerPool: make(map[types.FileContractID]*worker),
+	}
+	var workers []*worker
+	chunkMap := make(map[types.FileContractID]downloadPieceInfo)
+	for i := 0; i < 3; i++ {
+		w := &worker{
+			downloadChan: make(chan struct{}, 1),
+			renter:       r,
+		}
+		w.contract.ID[0] = byte(i)
+		w.managedRecordDownload(time.Duration(i+1)*time.Second, time.Duration(i+2)*time.Second, pieceSize)
+		chunkMap[w.contract.ID] = downloadPieceInfo{index: uint64(i)}
+		r.workerPool[w.contract.ID] = w
+		workers = append(workers, w)
+	}
+	ec, _ := NewRSCode(1, 2)
+	udc := &unfinishedDownloadChunk{
+		erasureCode:     ec,
+		staticChunkMap:  chunkMap,
+		staticOverdrive: 1,
+		staticPieceSize: pieceSize,
+		download: &download{
+			completeChan: make(chan struct{}),
+		},
+	}
+
+	// Only the fastest worker is given the chunk at first.
+	r.managedDistributeDownloadChunkToWorkers(udc)
+	for i, w := range workers {
+		if queued := len(w.downloadChunks) == 1; queued != (i == 0) {
+			t.Fatalf("worker %v: queued %v", i, queued)
+		}
+	}
+
+	// Overdrive queues the chunk with the standby workers.
+	udc.managedStartOverdrive()
+	if udc.overdrive != 1 || len(udc.workersStandby) != 0 {
+		t.Fatal("overdrive didn't take the workers off standby")
+	}
+	for i, w := range workers {
+		if len(w.downloadChunks) != 1 {
+			t.Fatalf("worker %v wasn't given the chunk", i)
+		}
+	}
+
+	// Overdrive doesn't start for chunks that are complete.
+	udc.overdrive = 0
+	udc.piecesCompleted = 1
+	udc.managedStartOverdrive()
+	if udc.overdrive != 0 {
+		t.Fatal("overdrive started for a complete chunk")
+	}
+}
+
+// TestOverdriveSettings checks that the download overdrive and the overdrive
+// spending are persisted, and that the spending only counts for the current
+// period.
+func TestOverdriveSettings(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	settings := r.Settings()
+	if settings.DownloadOverdrive != defaultDownloadOverdrive {
+		t.Fatal("wrong default overdrive:", settings.DownloadOverdrive)
+	}
+	settings.DownloadOverdrive = 5
+	if err := r.SetSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	r.managedRecordOverdriveSpending(types.NewCurrency64(100))
+	r.managedRecordOverdriveSpending(types.NewCurrency64(200))
+
+	// The spending is saved with the next periodic save. Reload the renter's
+	// persistence afterwards.
+	id := r.mu.Lock()
+	if !r.unsavedChanges {
+		r.mu.Unlock(id)
+		t.Fatal("overdrive spending wasn't marked as unsaved")
+	}
+	if err := r.saveChanges(); err != nil {
+		r.mu.Unlock(id)
+		t.Fatal(err)
+	}
+	r.downloadOverdrive = 0
+	r.overdriveSpending = types.ZeroCurrency
+	err = r.load()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Settings().DownloadOverdrive != 5 {
+		t.Fatal("overdrive wasn't persisted")
+	}
+	if spending := r.PeriodSpending().OverdriveSpending; !spending.Equals(types.NewCurrency64(300)) {
+		t.Fatal("wrong overdrive spending:", spending)
+	}
+
+	// Spending of an earlier period is not reported.
+	id = r.mu.Lock()
+	r.overdrivePeriod++
+	r.mu.Unlock(id)
+	if spending := r.PeriodSpending().OverdriveSpending; !spending.IsZero() {
+		t.Fatal("overdrive spending of another period was reported:", spending)
+	}
+}
@@ -0,0 +1,317 @@
+package renter
+
+// packs.go packs small files into shared chunks. Uploading a file that is much
+// smaller than a chunk on its own wastes most of the chunk's sectors on
+// padding. Instead, the data of a small file is appended to an open pack. A
+// pack is an internal file that consists of a single chunk. Once a pack is
+// full, or once it has been open for packFlushInterval, it is sealed and
+// uploaded and repaired like any other file.
+//
+// Every packed file records the pack that stores its data and the offset of
+// its data within the pack. Downloads of a packed file are served by its pack,
+// and the health of a packed file is the health of its pack.
+//
+// The data of a pack is staged in a file next to the pack's metadata, which is
+// also the local copy that the pack is repaired from. Packs are reference
+// counted by the files they contain. Once the last file of a sealed pack is
+// deleted, the metadata and the staged data of the pack are deleted as well.
+//
+// TODO: The sectors of a freed pack are not removed from the hosts, just like
+// the sectors of deleted files.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+)
+
+const (
+	// packDir is the directory within the renter directory that contains the
+	// packs. Siapaths within packDir are reserved.
+	packDir = ".packs"
+
+	// packDataExtension is the extension of the file that stages the data of
+	// a pack.
+	packDataExtension = ".dat"
+
+	// packThresholdDivisor determines which files are packed. Files that are
+	// at most 1/packThresholdDivisor of a chunk in size are packed.
+	packThresholdDivisor = 4
+)
+
+var (
+	// errMissingPack is returned when the pack of a packed file can't be
+	// found.
+	errMissingPack = errors.New("pack of packed file is missing")
+)
+
+// packThreshold returns the size up to which files that are uploaded with the
+// erasure code ec are packed.
+func packThreshold(ec modules.ErasureCoder) uint64 {
+	return pieceSize * uint64(ec.MinPieces()) / packThresholdDivisor
+}
+
+// packKey returns the key of the open pack for files that are uploaded with
+// the erasure code ec. Only files with the same erasure code share a pack.
+func packKey(ec modules.ErasureCoder) string {
+	return fmt.Sprintf("%v+%v", ec.MinPieces(), ec.NumPieces()-ec.MinPieces())
+}
+
+// newPackName returns a unique name for a new pack.
+func newPackName() string {
+	return packDir + "/" + persist.RandomSuffix()
+}
+
+// packDataPath returns the path of the file that stages the data of a pack.
+func (r *Renter) packDataPath(pack *file) string {
+	return filepath.Join(r.persistDir, pack.name+packDataExtension)
+}
+
+// openPack returns the open pack for the erasure code ec if it has room for
+// size more bytes. Otherwise the open pack is sealed and a new pack is
+// opened.
+func (r *Renter) openPack(ec modules.ErasureCoder, size uint64) (*file, error) {
+	key := packKey(ec)
+	if pack, exists := r.openPacks[key]; exists {
+		pack.mu.RLock()
+		fits := pack.size+size <= pack.staticChunkSize()
+		pack.mu.RUnlock()
+		if fits {
+			return pack, nil
+		}
+		r.sealPack(pack)
+	}
+
+	pack := newFile(newPackName(), ec, pieceSize, 0)
+	if err := r.saveFile(pack); err != nil {
+		return nil, err
+	}
+	r.packs[pack.name] = pack
+	r.openPacks[key] = pack
+	r.tracking[pack.name] = trackedFile{
+		RepairPath: r.packDataPath(pack),
+	}
+	go r.threadedSealPack(pack)
+	return pack, nil
+}
+
+// packFile appends data to an open pack and turns f into a packed file that
+// references the data within the pack.
+func (r *Renter) packFile(f *file, data []byte) error {
+	pack, err := r.openPack(f.erasureCode, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	pack.mu.Lock()
+	defer pack.mu.Unlock()
+
+	// Stage the data before recording the new size of the pack.
+	fh, err := os.OpenFile(r.packDataPath(pack), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fh.WriteAt(data, int64(pack.size))
+	if err == nil {
+		err = fh.Sync()
+	}
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	offset := pack.size
+	pack.size += uint64(len(data))
+	if err := r.saveFile(pack); err != nil {
+		pack.size = offset
+		return err
+	}
+
+	f.pack = pack
+	f.packOffset = offset
+	pack.addPackRef(f)
+	return nil
+}
+
+// sealPack closes an open pack to new files and notifies the upload loop that
+// the chunk of the pack can be uploaded. A pack that doesn't contain any files
+// anymore is deleted instead.
+func (r *Renter) sealPack(pack *file) {
+	delete(r.openPacks, packKey(pack.erasureCode))
+	if len(pack.packRefs) == 0 {
+		r.deletePack(pack)
+		return
+	}
+	select {
+	case r.uploadHeap.newUploads <- struct{}{}:
+	default:
+	}
+}
+
+// threadedSealPack seals a pack once it has been open for packFlushInterval,
+// so that small uploads don't wait for their pack to fill up indefinitely.
+func (r *Renter) threadedSealPack(pack *file) {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	select {
+	case <-r.tg.StopChan():
+		return
+	case <-time.After(packFlushInterval):
+	}
+
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if r.openPacks[packKey(pack.erasureCode)] == pack {
+		r.sealPack(pack)
+	}
+}
+
+// isOpenPack returns true if f is a pack that is still open to new files.
+func (r *Renter) isOpenPack(f *file) bool {
+	return r.openPacks[packKey(f.erasureCode)] == f
+}
+
+// deletePack removes a pack from the renter and deletes its metadata and its
+// staged data.
+func (r *Renter) deletePack(pack *file) {
+	delete(r.packs, pack.name)
+	delete(r.tracking, pack.name)
+
+	err := persist.RemoveFile(filepath.Join(r.persistDir, metadataPath(pack.name)))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove pack:", err)
+	}
+	err = os.Remove(r.packDataPath(pack))
+	if err != nil && !os.IsNotExist(err) {
+		r.log.Println("WARN: couldn't remove data of pack:", err)
+	}
+
+	pack.mu.Lock()
+	pack.deleted = true
+	pack.mu.Unlock()
+}
+
+// releasePackedFile drops the reference of a deleted packed file to its pack.
+// A sealed pack that doesn't contain any files anymore is deleted, which
+// frees its chunk.
+func (r *Renter) releasePackedFile(f *file) {
+	pack := f.pack
+	if pack == nil {
+		return
+	}
+	delete(pack.packRefs, f)
+	if len(pack.packRefs) == 0 && !r.isOpenPack(pack) {
+		r.deletePack(pack)
+	}
+}
+
+// addPackedFile registers a packed file that was read from a share file or a
+// backup with its pack. If the pack is new to the renter, it's added as
+// well. New packs are repaired from the hosts if track is true.
+func (r *Renter) addPackedFile(f *file, track bool) error {
+	pack := f.pack
+	if pack == nil {
+		return nil
+	}
+	if _, exists := r.packs[pack.name]; !exists {
+		if err := r.saveFile(pack); err != nil {
+			return err
+		}
+		r.packs[pack.name] = pack
+		if track {
+			r.tracking[pack.name] = trackedFile{}
+		}
+	}
+	pack.addPackRef(f)
+	return nil
+}
+
+// addPackRef records that f is stored in the pack.
+func (pack *file) addPackRef(f *file) {
+	if pack.packRefs == nil {
+		pack.packRefs = make(map[*file]struct{})
+	}
+	pack.packRefs[f] = struct{}{}
+}
+
+// packsByMasterKey returns the packs of the renter, keyed by their master
+// keys. Packs are identified by their master key when they are read from a
+// share file or a backup.
+func (r *Renter) packsByMasterKey() map[crypto.TwofishKey]*file {
+	packs := make(map[crypto.TwofishKey]*file)
+	for _, pack := range r.packs {
+		packs[pack.masterKey] = pack
+	}
+	return packs
+}
+
+// loadPacks loads the metadata of all packs. The packs need to be loaded
+// before the files that reference them.
+func (r *Renter) loadPacks() error {
+	paths, err := filepath.Glob(filepath.Join(r.persistDir, packDir, "*"+ShareExtension))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		pack, err := loadFile(path, nil, nil)
+		if err != nil {
+			r.log.Println("ERROR: could not load pack:", err)
+			continue
+		}
+		r.packs[pack.name] = pack
+	}
+	return nil
+}
+
+// managedUploadPacked adds a small file to the renter by appending its data to
+// an open pack. The data is uploaded once the pack is sealed. The compressed
+// data of a compressed file is appended instead of its data, which is read
+// from stagePath.
+func (r *Renter) managedUploadPacked(f *file, source, stagePath string) error {
+	fh, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if f.compression != nil {
+		data, err = ioutil.ReadFile(stagePath)
+	} else {
+		data = make([]byte, f.size)
+		_, err = io.ReadFull(fh, data)
+		f.checksum = crypto.HashBytes(data)
+	}
+	fh.Close()
+	if err != nil {
+		return err
+	}
+
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if _, exists := r.files[f.name]; exists {
+		return ErrPathOverload
+	}
+	if err := r.packFile(f, data); err != nil {
+		return err
+	}
+	if err := r.saveFile(f); err != nil {
+		r.releasePackedFile(f)
+		return err
+	}
+	r.files[f.name] = f
+	r.tracking[f.name] = trackedFile{
+		RepairPath: source,
+	}
+	r.addFileToDirs(f)
+	return r.saveSync()
+}
@@ -0,0 +1,319 @@
+package renter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// uploadTestFile writes data to a file in dir and uploads it to the renter
+// with the provided parameters. The file is named after up.SiaPath.
+func uploadTestFile(r *Renter, dir string, data []byte, up modules.FileUploadParams) error {
+	up.Source = filepath.Join(dir, up.SiaPath)
+	if err := ioutil.WriteFile(up.Source, data, 0600); err != nil {
+		return err
+	}
+	return r.Upload(up)
+}
+
+// TestPackSmallFiles checks that small files are packed into a shared pack,
+// and that the pack is freed once all of its files are deleted.
+func TestPackSmallFiles(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// Purge deleted files right away, so that they release their pack.
+	r.trashRetention = 0
+
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+
+	// Upload two small files and a file that is too large to be packed.
+	data1, data2 := fastrand.Bytes(100), fastrand.Bytes(200)
+	if err := uploadTestFile(r, dir, data1, modules.FileUploadParams{SiaPath: "small1", ErasureCode: ec}); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadTestFile(r, dir, data2, modules.FileUploadParams{SiaPath: "small2", ErasureCode: ec}); err != nil {
+		t.Fatal(err)
+	}
+	large := fastrand.Bytes(int(packThreshold(ec)) + 1)
+	if err := uploadTestFile(r, dir, large, modules.FileUploadParams{SiaPath: "large", ErasureCode: ec}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := r.mu.Lock()
+	f1, f2, large := r.files["small1"], r.files["small2"], r.files["large"]
+	pack := f1.pack
+	r.mu.Unlock(id)
+	if pack == nil || f2.pack != pack {
+		t.Fatal("small files were not packed into the same pack")
+	}
+	if large.pack != nil {
+		t.Fatal("large file was packed")
+	}
+	if f1.packOffset != 0 || f2.packOffset != 100 || pack.size != 300 {
+		t.Fatal("wrong layout of pack:", f1.packOffset, f2.packOffset, pack.size)
+	}
+	staged, err := ioutil.ReadFile(r.packDataPath(pack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(staged, append(data1, data2...)) {
+		t.Fatal("staged data of pack doesn't match the data of the files")
+	}
+
+	// Deleting a file from an open pack doesn't delete the pack, even if the
+	// pack becomes empty.
+	if err := r.DeleteFile("small1"); err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	packedFiles := len(pack.packRefs)
+	r.mu.Unlock(id)
+	if packedFiles != 1 {
+		t.Fatal("wrong number of packed files:", packedFiles)
+	}
+
+	// Once the pack is sealed, deleting its last file frees it.
+	id = r.mu.Lock()
+	r.sealPack(pack)
+	r.mu.Unlock(id)
+	if err := r.DeleteFile("small2"); err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	_, exists := r.packs[pack.name]
+	_, tracked := r.tracking[pack.name]
+	r.mu.Unlock(id)
+	if exists || tracked {
+		t.Fatal("empty pack was not deleted")
+	}
+	if _, err := os.Stat(r.packDataPath(pack)); !os.IsNotExist(err) {
+		t.Fatal("staged data of pack was not deleted:", err)
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(pack.name))); !os.IsNotExist(err) {
+		t.Fatal("metadata of pack was not deleted:", err)
+	}
+}
+
+// TestPackPersistence checks that packed files reference their packs after
+// the renter is reloaded, and that packs without files are deleted.
+func TestPackPersistence(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// Deleted files release their pack right away without the trash.
+	r.trashRetention = 0
+
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	for _, siaPath := range []string{"small1", "dir/small2"} {
+		if err := os.MkdirAll(filepath.Join(dir, "dir"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := uploadTestFile(r, dir, fastrand.Bytes(100), modules.FileUploadParams{SiaPath: siaPath, ErasureCode: ec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Seal the pack and open a second one, which becomes empty.
+	id := r.mu.Lock()
+	pack := r.files["small1"].pack
+	r.sealPack(pack)
+	r.mu.Unlock(id)
+	if err := uploadTestFile(r, dir, fastrand.Bytes(100), modules.FileUploadParams{SiaPath: "small3", ErasureCode: ec}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteFile("small3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload the renter.
+	id = r.mu.Lock()
+	r.files = make(map[string]*file)
+	r.packs = make(map[string]*file)
+	r.openPacks = make(map[string]*file)
+	err = r.load()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id = r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if len(r.packs) != 1 {
+		t.Fatal("expected 1 pack after reload, got", len(r.packs))
+	}
+	f1, f2 := r.files["small1"], r.files["dir/small2"]
+	if f1 == nil || f2 == nil {
+		t.Fatal("packed files were not loaded")
+	}
+	if f1.pack == nil || f1.pack != f2.pack || f1.pack.name != pack.name {
+		t.Fatal("packed files don't reference their pack")
+	}
+	if len(f1.pack.packRefs) != 2 || f2.packOffset != 100 || f1.pack.size != 200 {
+		t.Fatal("pack was not loaded correctly")
+	}
+	if f1.pack.masterKey != pack.masterKey {
+		t.Fatal("master key of pack doesn't match")
+	}
+}
+
+// TestPackShareLoad checks that packed files are only shared if their pack
+// doesn't contain other files, and that share files describing a pack are
+// loaded correctly.
+func TestPackShareLoad(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	for _, siaPath := range []string{"small1", "small2"} {
+		if err := uploadTestFile(r, dir, fastrand.Bytes(100), modules.FileUploadParams{SiaPath: siaPath, ErasureCode: ec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sharing a file that shares its pack with another file must fail
+	// without revealing anything about the pack.
+	ascii, err := r.ShareFilesASCII([]string{"small1"}, false)
+	if err == nil || !strings.Contains(err.Error(), errSharePackedFile.Error()) {
+		t.Fatal("expected errSharePackedFile, got", err)
+	} else if ascii != "" {
+		t.Fatal("failed share returned data:", ascii)
+	}
+	shareDest := filepath.Join(dir, "small1"+ShareExtension)
+	if err := r.ShareFiles([]string{"small1"}, shareDest, false); err == nil || !strings.Contains(err.Error(), errSharePackedFile.Error()) {
+		t.Fatal("expected errSharePackedFile, got", err)
+	} else if _, err := os.Stat(shareDest); !os.IsNotExist(err) {
+		t.Fatal("failed share left a share file behind:", err)
+	}
+
+	// Backups aren't shared with other renters, so they contain packed files
+	// regardless of their pack.
+	s, err := r.managedSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(s.Files) != 2 || s.Files[0].Pack == nil || s.Files[1].Pack == nil {
+		t.Fatal("snapshot doesn't contain the packed files:", s.Files)
+	}
+
+	// A file that is the only data of its pack can be shared.
+	ec2, _ := NewRSCode(2, 1)
+	if err := uploadTestFile(r, dir, fastrand.Bytes(100), modules.FileUploadParams{SiaPath: "alone", ErasureCode: ec2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ShareFilesASCII([]string{"alone"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a share file that describes both packed files, like older
+	// renters did.
+	id := r.mu.Lock()
+	var sharedFiles []sharedFile
+	for _, name := range []string{"small1", "small2"} {
+		f := r.files[name]
+		sharedFiles = append(sharedFiles, sharedFile{
+			SiaPath:   f.name,
+			Size:      f.size,
+			Mode:      f.mode,
+			MasterKey: f.pack.masterKey,
+			PieceSize: f.pack.pieceSize,
+			ErasureCode: sharedErasureCode{
+				Type:         erasureCodeReedSolomon,
+				DataPieces:   1,
+				ParityPieces: 1,
+			},
+			Pack: &sharedPack{
+				Offset: f.packOffset,
+				Size:   f.pack.size,
+			},
+			Contracts: r.sharedContracts(f.pack, false),
+		})
+	}
+	r.mu.Unlock(id)
+	buf := new(bytes.Buffer)
+	enc := base64.NewEncoder(base64.URLEncoding, buf)
+	if err := writeSharedFiles(sharedFiles, enc); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ascii = buf.String()
+
+	// Loading the files again reuses the existing pack.
+	names, err := r.LoadSharedFilesASCII(ascii)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	pack := r.files["small1"].pack
+	for _, name := range names {
+		if f := r.files[name]; f.pack != pack {
+			t.Error("loaded file doesn't reference the existing pack")
+		}
+	}
+	if len(r.packs) != 2 || len(pack.packRefs) != 4 {
+		t.Error("wrong number of packs or packed files:", len(r.packs), len(pack.packRefs))
+	}
+
+	// Load the files into a renter that doesn't know the pack.
+	r.files = make(map[string]*file)
+	r.packs = make(map[string]*file)
+	r.openPacks = make(map[string]*file)
+	r.mu.Unlock(id)
+	if _, err := r.LoadSharedFilesASCII(ascii); err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f1, f2 := r.files["small1"], r.files["small2"]
+	if f1.pack == nil || f1.pack != f2.pack || len(r.packs) != 1 {
+		t.Fatal("loaded files don't share a new pack")
+	}
+	if f1.pack.name == pack.name || f1.pack.masterKey != pack.masterKey || f1.pack.size != 200 {
+		t.Fatal("new pack doesn't match the shared pack")
+	}
+	if len(f1.pack.packRefs) != 2 || f2.packOffset != 100 {
+		t.Fatal("wrong layout of new pack")
+	}
+}
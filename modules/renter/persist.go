@@ -1,28 +1,38 @@
 package renter
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/encoding"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/persist"
 	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/NebulousLabs/writeaheadlog"
 )
 
 const (
 	logFile = modules.RenterDir + ".log"
+	// persistVersion is the current version of the renter persistence. Since
+	// version 1.3.4 every file is stored in the paged metadata format defined
+	// in siafile.go.
+	persistVersion = "1.3.4"
 	// PersistFilename is the filename to be used when persisting renter information to a JSON file
 	PersistFilename = "renter.json"
 	// ShareExtension is the extension to be used
 	ShareExtension = ".sia"
+	// migratedDir is the directory that files are moved to if they were
+	// uploaded to a directory before it was reserved.
+	migratedDir = "migrated"
 )
 
 var (
@@ -37,11 +47,8 @@ var (
 
 	saveMetadata = persist.Metadata{
 		Header:  "Renter Persistence",
-		Version: "0.4",
+		Version: persistVersion,
 	}
-
-	shareHeader  = [15]byte{'S', 'i', 'a', ' ', 'S', 'h', 'a', 'r', 'e', 'd', ' ', 'F', 'i', 'l', 'e'}
-	shareVersion = "0.4"
 )
 
 // MarshalSia implements the encoding.SiaMarshaller interface, writing the
@@ -159,49 +166,120 @@ func (f *file) UnmarshalSia(r io.Reader) error {
 	return nil
 }
 
-// saveFile saves a file to the renter directory.
-func (r *Renter) saveFile(f *file) error {
-	if f.deleted {
-		return errors.New("can't save deleted file")
+// saveSync stores the current renter data to disk and then syncs to disk.
+func (r *Renter) saveSync() error {
+	data := struct {
+		Tracking       map[string]trackedFile
+		UploadsPaused  bool
+		DedupSecret    crypto.Hash
+		ChunkCacheSize uint64
+
+		DownloadOverdrive uint64
+		OverdrivePeriod   types.BlockHeight
+		OverdriveSpending types.Currency
+
+		Trash          map[string]*trashedFile
+		TrashRetention time.Duration
+
+		LastSnapshot crypto.Hash
+		Snapshots    [][]snapshotUpload
+
+		RedundancyChanges []*redundancyChange
+		PendingDeletions  []*pendingSectorDeletion
+	}{r.tracking, r.uploadsPaused, r.dedupSecret, r.diskCache.managedMaxSize(),
+		r.downloadOverdrive, r.overdrivePeriod, r.overdriveSpending,
+		r.trash, r.trashRetention,
+		r.lastSnapshot, r.snapshotUploads,
+		nil, r.pendingDeletions}
+	// Only the changes of redundancy that are in progress are resumed.
+	for _, rc := range r.redundancyChanges {
+		if !rc.completed {
+			data.RedundancyChanges = append(data.RedundancyChanges, rc)
+		}
+	}
+
+	return persist.SaveJSON(saveMetadata, data, filepath.Join(r.persistDir, PersistFilename))
+}
+
+// load fetches the saved renter data from disk.
+func (r *Renter) load() error {
+	// Load the tracking data. If the renter was persisted by an older
+	// version, convert its persistence first. The persist file might also be
+	// missing if an older renter never tracked any files, in which case the
+	// conversion is needed as well.
+	data := struct {
+		Tracking       map[string]trackedFile
+		UploadsPaused  bool
+		DedupSecret    crypto.Hash
+		ChunkCacheSize uint64
+
+		DownloadOverdrive uint64
+		OverdrivePeriod   types.BlockHeight
+		OverdriveSpending types.Currency
+
+		Trash          map[string]*trashedFile
+		TrashRetention time.Duration
+
+		LastSnapshot crypto.Hash
+		Snapshots    [][]snapshotUpload
+
+		RedundancyChanges []*redundancyChange
+		PendingDeletions  []*pendingSectorDeletion
+	}{}
+	// Renters that were persisted before overdrive was configurable use the
+	// default overdrive.
+	data.DownloadOverdrive = defaultDownloadOverdrive
+	data.TrashRetention = defaultTrashRetention
+	persistPath := filepath.Join(r.persistDir, PersistFilename)
+	err := persist.LoadJSON(saveMetadata, &data, persistPath)
+	if err == persist.ErrBadVersion || os.IsNotExist(err) {
+		if err = r.convertPersistVersionFrom04To134(); err != nil {
+			return err
+		}
+		err = persist.LoadJSON(saveMetadata, &data, persistPath)
 	}
-	// Create directory structure specified in nickname.
-	fullPath := filepath.Join(r.persistDir, f.name+ShareExtension)
-	err := os.MkdirAll(filepath.Dir(fullPath), 0700)
 	if err != nil {
 		return err
 	}
+	if data.Tracking != nil {
+		r.tracking = data.Tracking
+	}
+	r.uploadsPaused = data.UploadsPaused
+	r.dedupSecret = data.DedupSecret
+	r.diskCache.managedSetMaxSize(data.ChunkCacheSize)
+	r.downloadOverdrive = data.DownloadOverdrive
+	r.overdrivePeriod = data.OverdrivePeriod
+	r.overdriveSpending = data.OverdriveSpending
+	r.trashRetention = data.TrashRetention
+	if data.Trash != nil {
+		r.trash = data.Trash
+	}
+	for key, tf := range r.trash {
+		tf.key = key
+		if tf.SiaPath == "" {
+			tf.SiaPath = key
+		}
+	}
+	r.lastSnapshot = data.LastSnapshot
+	r.snapshotUploads = data.Snapshots
+	r.pendingDeletions = data.PendingDeletions
+	redundancyChanges := make(map[string]*redundancyChange)
+	for _, rc := range data.RedundancyChanges {
+		redundancyChanges[rc.TempName] = rc
+	}
 
-	// Open SafeFile handle.
-	handle, err := persist.NewSafeFile(filepath.Join(r.persistDir, f.name+ShareExtension))
-	if err != nil {
+	// Load the packs and blocks before the files that reference them.
+	if err := r.loadPacks(); err != nil {
 		return err
 	}
-	defer handle.Close()
-
-	// Write file data.
-	err = shareFiles([]*file{f}, handle)
-	if err != nil {
+	if err := r.loadDedupBlocks(); err != nil {
 		return err
 	}
 
-	// Commit the SafeFile.
-	return handle.CommitSync()
-}
-
-// saveSync stores the current renter data to disk and then syncs to disk.
-func (r *Renter) saveSync() error {
-	data := struct {
-		Tracking map[string]trackedFile
-	}{r.tracking}
-
-	return persist.SaveJSON(saveMetadata, data, filepath.Join(r.persistDir, PersistFilename))
-}
-
-// load fetches the saved renter data from disk.
-func (r *Renter) load() error {
 	// Recursively load all files found in renter directory. Errors
 	// encountered during loading are logged, but are not considered fatal.
-	err := filepath.Walk(r.persistDir, func(path string, info os.FileInfo, err error) error {
+	var reservedFiles []*file
+	err = filepath.Walk(r.persistDir, func(path string, info os.FileInfo, err error) error {
 		// This error is non-nil if filepath.Walk couldn't stat a file or
 		// folder.
 		if err != nil {
@@ -209,195 +287,182 @@ func (r *Renter) load() error {
 			return nil
 		}
 
-		// Skip folders and non-sia files.
-		if info.IsDir() || filepath.Ext(path) != ShareExtension {
+		// Register the directories found in the renter directory.
+		if !info.IsDir() && info.Name() == SiaDirExtension {
+			dir, err := filepath.Rel(r.persistDir, filepath.Dir(path))
+			if err != nil {
+				return nil
+			}
+			dir = filepath.ToSlash(dir)
+			if dir == "." {
+				dir = ""
+			}
+			if isReservedName(dir) {
+				return nil
+			}
+			r.createDirs(dir)
 			return nil
 		}
 
-		// Open the file.
-		file, err := os.Open(path)
-		if err != nil {
-			r.log.Println("ERROR: could not open .sia file:", err)
+		// The packs and blocks have been loaded already.
+		if info.IsDir() && (path == filepath.Join(r.persistDir, packDir) || path == filepath.Join(r.persistDir, dedupDir)) {
+			return filepath.SkipDir
+		}
+
+		// Skip folders and non-sia files.
+		if info.IsDir() || filepath.Ext(path) != ShareExtension {
 			return nil
 		}
-		defer file.Close()
 
-		// Load the file contents into the renter.
-		_, err = r.loadSharedFiles(file)
+		// Load the file into the renter.
+		f, err := loadFile(path, r.packs, r.dedupBlocks)
 		if err != nil {
 			r.log.Println("ERROR: could not load .sia file:", err)
 			return nil
 		}
+		if tf, exists := r.trash[strings.TrimPrefix(f.name, trashDir+"/")]; exists && isTrashName(f.name) {
+			tf.file = f
+		} else if rc, exists := redundancyChanges[f.name]; exists {
+			rc.newFile = f
+		} else if isReservedName(f.name) {
+			// Files that were uploaded to a directory that was reserved later
+			// on are moved out of it once all files are known.
+			reservedFiles = append(reservedFiles, f)
+		} else {
+			r.files[f.name] = f
+		}
+		if f.pack != nil {
+			f.pack.addPackRef(f)
+		}
+		if err := r.addDedupFile(f); err != nil {
+			r.log.Println("ERROR: could not add the blocks of .sia file:", err)
+		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
-
-	// Load contracts, repair set, and entropy.
-	data := struct {
-		Tracking  map[string]trackedFile
-		Repairing map[string]string // COMPATv0.4.8
-	}{}
-	err = persist.LoadJSON(saveMetadata, &data, filepath.Join(r.persistDir, PersistFilename))
-	if err != nil {
-		return err
+	for _, f := range reservedFiles {
+		if err := r.migrateFile(f); err != nil {
+			r.log.Println("ERROR: could not move file out of reserved directory:", err)
+		}
 	}
-	if data.Tracking != nil {
-		r.tracking = data.Tracking
+	r.removeCompressionStages()
+
+	// Changes of redundancy that were interrupted are resumed if their file
+	// still exists. The file is identified by its key, since another file
+	// might have been moved to its siapath.
+	r.redundancyChanges = nil
+	for _, rc := range data.RedundancyChanges {
+		f, exists := r.files[rc.SiaPath]
+		if rc.newFile == nil {
+			r.log.Println("WARN: could not resume the change of redundancy of", rc.SiaPath+": re-encoded file is missing")
+			continue
+		} else if !exists || crypto.HashObject(f.masterKey) != rc.FileKey {
+			r.abandonRedundancyFile(rc.newFile)
+			continue
+		}
+		rc.file = f
+		r.redundancyChanges = append(r.redundancyChanges, rc)
 	}
 
-	return nil
-}
-
-// shareFiles writes the specified files to w. First a header is written,
-// followed by the gzipped concatenation of each file.
-func shareFiles(files []*file, w io.Writer) error {
-	// Write header.
-	err := encoding.NewEncoder(w).EncodeAll(
-		shareHeader,
-		shareVersion,
-		uint64(len(files)),
-	)
-	if err != nil {
-		return err
+	// Entries of trashed files whose metadata is missing can't be restored.
+	for key, tf := range r.trash {
+		if tf.file == nil {
+			delete(r.trash, key)
+		}
 	}
 
-	// Create compressor.
-	zip, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
-	enc := encoding.NewEncoder(zip)
-
-	// Encode each file.
-	for _, f := range files {
-		err = enc.Encode(f)
-		if err != nil {
-			return err
+	// Packs that don't contain any files anymore were not deleted before the
+	// renter shut down. Packs that were open are sealed by now.
+	for _, pack := range r.packs {
+		if len(pack.packRefs) == 0 {
+			r.deletePack(pack)
 		}
 	}
-
-	return zip.Close()
-}
-
-// ShareFiles saves the specified files to shareDest.
-func (r *Renter) ShareFiles(nicknames []string, shareDest string) error {
-	lockID := r.mu.RLock()
-	defer r.mu.RUnlock(lockID)
-
-	// TODO: consider just appending the proper extension.
-	if filepath.Ext(shareDest) != ShareExtension {
-		return ErrNonShareSuffix
+	// The same goes for blocks that aren't referenced anymore.
+	for _, block := range r.dedupBlocks {
+		if len(block.dedupRefs) == 0 {
+			r.deleteDedupBlock(block)
+		}
 	}
 
-	handle, err := os.Create(shareDest)
-	if err != nil {
-		return err
+	// Add the loaded files to the directory tree. The aggregate metadata of
+	// the directories was loaded from disk and is refreshed by the upload
+	// loop.
+	for name := range r.files {
+		r.createDirs(dirName(name)).files[path.Base(name)] = struct{}{}
 	}
-	defer handle.Close()
+	return nil
+}
 
-	// Load files from renter.
-	files := make([]*file, len(nicknames))
-	for i, name := range nicknames {
-		f, exists := r.files[name]
-		if !exists {
-			return ErrUnknownPath
-		}
-		files[i] = f
+// saveChanges saves the renter if it has unsaved changes.
+func (r *Renter) saveChanges() error {
+	if !r.unsavedChanges {
+		return nil
 	}
-
-	err = shareFiles(files, handle)
-	if err != nil {
-		os.Remove(shareDest)
+	if err := r.saveSync(); err != nil {
 		return err
 	}
-
+	r.unsavedChanges = false
 	return nil
 }
 
-// ShareFilesASCII returns the specified files in ASCII format.
-func (r *Renter) ShareFilesASCII(nicknames []string) (string, error) {
-	lockID := r.mu.RLock()
-	defer r.mu.RUnlock(lockID)
-
-	// Load files from renter.
-	files := make([]*file, len(nicknames))
-	for i, name := range nicknames {
-		f, exists := r.files[name]
-		if !exists {
-			return "", ErrUnknownPath
+// threadedSaveLoop periodically saves the changes of the renter that are not
+// saved right away.
+func (r *Renter) threadedSaveLoop() {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.tg.StopChan():
+			return
+		case <-ticker.C:
 		}
-		files[i] = f
-	}
 
-	buf := new(bytes.Buffer)
-	err := shareFiles(files, base64.NewEncoder(base64.URLEncoding, buf))
-	if err != nil {
-		return "", err
+		id := r.mu.Lock()
+		if err := r.saveChanges(); err != nil {
+			r.log.Println("WARN: could not save the renter:", err)
+		}
+		r.mu.Unlock(id)
 	}
-
-	return buf.String(), nil
 }
 
-// loadSharedFiles reads .sia data from reader and registers the contained
-// files in the renter. It returns the nicknames of the loaded files.
-func (r *Renter) loadSharedFiles(reader io.Reader) ([]string, error) {
-	// read header
-	var header [15]byte
-	var version string
-	var numFiles uint64
-	err := encoding.NewDecoder(reader).DecodeAll(
-		&header,
-		&version,
-		&numFiles,
-	)
-	if err != nil {
-		return nil, err
-	} else if header != shareHeader {
-		return nil, ErrBadFile
-	} else if version != shareVersion {
-		return nil, ErrIncompatible
-	}
-
-	// Create decompressor.
-	unzip, err := gzip.NewReader(reader)
-	if err != nil {
-		return nil, err
-	}
-	dec := encoding.NewDecoder(unzip)
-
-	// Read each file.
-	files := make([]*file, numFiles)
-	for i := range files {
-		files[i] = new(file)
-		err := dec.Decode(files[i])
-		if err != nil {
-			return nil, err
-		}
-
-		// Make sure the file's name does not conflict with existing files.
-		dupCount := 0
-		origName := files[i].name
-		for {
-			_, exists := r.files[files[i].name]
-			if !exists {
-				break
-			}
-			dupCount++
-			files[i].name = origName + "_" + strconv.Itoa(dupCount)
+// isReservedName returns whether siaPath is inside one of the reserved
+// directories that files might have been uploaded to before it was reserved.
+func isReservedName(siaPath string) bool {
+	for _, dir := range []string{cacheDir, trashDir, redundancyDir, compressionStageDir} {
+		if siaPath == dir || strings.HasPrefix(siaPath, dir+"/") {
+			return true
 		}
 	}
+	return false
+}
 
-	// Add files to renter.
-	names := make([]string, numFiles)
-	for i, f := range files {
-		r.files[f.name] = f
-		names[i] = f.name
+// migrateFile moves a file whose siapath is inside a reserved directory to the
+// same siapath within migratedDir. The file was uploaded before the directory
+// was reserved and couldn't be accessed otherwise. A number is appended to the
+// new siapath if it's taken already.
+func (r *Renter) migrateFile(f *file) error {
+	oldName := f.name
+	newName := migratedDir + "/" + oldName
+	for i := 1; ; i++ {
+		_, exists := r.files[newName]
+		if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(newName))); !exists && os.IsNotExist(err) {
+			break
+		}
+		newName = fmt.Sprintf("%v/%v_%v", migratedDir, oldName, i)
 	}
-	// Save the files.
-	for _, f := range files {
-		r.saveFile(f)
+	if err := r.moveFile(f, oldName, newName); err != nil {
+		return err
 	}
-
-	return names, nil
+	r.log.Printf("Moved file %v out of a reserved directory to %v", oldName, newName)
+	return nil
 }
 
 // initPersist handles all of the persistence initialization, such as creating
@@ -415,34 +480,35 @@ func (r *Renter) initPersist() error {
 		return err
 	}
 
-	// Load the prior persistence structures.
+	// Open the write-ahead log and apply the updates that were not applied
+	// completely before the renter was shut down.
+	txns, wal, err := writeaheadlog.New(filepath.Join(r.persistDir, walFile))
+	if err != nil {
+		return err
+	}
+	r.wal = wal
+	r.tg.OnStop(func() error {
+		_, err := r.wal.CloseIncomplete()
+		return err
+	})
+	for _, txn := range txns {
+		if err := r.applyUpdates(txn.Updates...); err != nil {
+			return err
+		}
+		if err := txn.SignalUpdatesApplied(); err != nil {
+			return err
+		}
+	}
+
+	// Load the prior persistence structures. The size of the chunk cache is
+	// part of the persistence, the cached chunks are loaded afterwards.
+	r.diskCache = newDiskCache(filepath.Join(r.persistDir, cacheDir), r.log)
 	err = r.load()
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return nil
-}
-
-// LoadSharedFiles loads a .sia file into the renter. It returns the nicknames
-// of the loaded files.
-func (r *Renter) LoadSharedFiles(filename string) ([]string, error) {
-	lockID := r.mu.Lock()
-	defer r.mu.Unlock(lockID)
-
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	if err := r.loadSyncFolders(); err != nil {
+		return err
 	}
-	defer file.Close()
-	return r.loadSharedFiles(file)
-}
-
-// LoadSharedFilesASCII loads an ASCII-encoded .sia file into the renter. It
-// returns the nicknames of the loaded files.
-func (r *Renter) LoadSharedFilesASCII(asciiSia string) ([]string, error) {
-	lockID := r.mu.Lock()
-	defer r.mu.Unlock(lockID)
-
-	dec := base64.NewDecoder(base64.URLEncoding, bytes.NewBufferString(asciiSia))
-	return r.loadSharedFiles(dec)
+	return r.diskCache.load()
 }
@@ -0,0 +1,140 @@
+package renter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/persist"
+)
+
+const (
+	// persistVersion040 is the version of the renter persistence before the
+	// paged file metadata was introduced. In that version, the metadata of
+	// every file was stored as a .sia share file which was rewritten on every
+	// change.
+	persistVersion040 = "0.4"
+
+	// shareVersion040 is the version of the legacy share format, which
+	// consists of the gzipped concatenation of the Sia encoding of each file.
+	shareVersion040 = "0.4"
+)
+
+// convertPersistVersionFrom04To134 converts the renter persistence from
+// version 0.4 to version 1.3.4. Every legacy .sia file in the renter directory
+// is replaced with the paged metadata of the files it contains, after which
+// the persist file is saved with the current version. If the conversion is
+// interrupted, it is repeated on the next startup.
+func (r *Renter) convertPersistVersionFrom04To134() error {
+	// Load the legacy persist file. It might not exist if the renter never
+	// tracked any files.
+	metadata := persist.Metadata{
+		Header:  saveMetadata.Header,
+		Version: persistVersion040,
+	}
+	data := struct {
+		Tracking  map[string]trackedFile
+		Repairing map[string]string // COMPATv0.4.8
+	}{}
+	persistPath := filepath.Join(r.persistDir, PersistFilename)
+	err := persist.LoadJSON(metadata, &data, persistPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Convert the legacy .sia files. Errors are logged, but are not
+	// considered fatal, the same way they aren't when loading the files.
+	err = filepath.Walk(r.persistDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			r.log.Println("WARN: could not stat file or folder during walk:", err)
+			return nil
+		}
+		if info.IsDir() || filepath.Ext(path) != ShareExtension {
+			return nil
+		}
+		if err := r.convertLegacyFile(path); err != nil {
+			r.log.Println("ERROR: could not convert .sia file:", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Save the persist file with the current version.
+	newData := struct {
+		Tracking map[string]trackedFile
+	}{data.Tracking}
+	return persist.SaveJSON(saveMetadata, newData, persistPath)
+}
+
+// convertLegacyFile replaces a legacy .sia file in the renter directory with
+// the paged metadata of the files it contains. Files that were already
+// converted are ignored.
+func (r *Renter) convertLegacyFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(b, shareHeader[:]) {
+		return nil
+	}
+	reader := bytes.NewReader(b)
+	var header [15]byte
+	var version string
+	err = encoding.NewDecoder(reader).DecodeAll(&header, &version)
+	if err != nil {
+		return err
+	} else if version != shareVersion040 {
+		return ErrIncompatible
+	}
+	files, err := readSharedFiles040(reader)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := r.saveFile(f); err != nil {
+			return err
+		}
+	}
+
+	// The legacy file is usually replaced by the new metadata. If it isn't,
+	// it has to be removed so it won't be loaded again.
+	for _, f := range files {
+		if filepath.Join(r.persistDir, metadataPath(f.name)) == path {
+			return nil
+		}
+	}
+	return os.Remove(path)
+}
+
+// readSharedFiles040 reads the files contained in legacy v0.4 .sia data from
+// reader. The header and the version have already been read.
+func readSharedFiles040(reader io.Reader) ([]*file, error) {
+	var numFiles uint64
+	if err := encoding.NewDecoder(reader).Decode(&numFiles); err != nil {
+		return nil, err
+	}
+
+	// Create decompressor.
+	unzip, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	dec := encoding.NewDecoder(unzip)
+
+	// Read each file.
+	files := make([]*file, numFiles)
+	for i := range files {
+		files[i] = new(file)
+		err := dec.Decode(files[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
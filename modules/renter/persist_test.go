@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/crypto"
@@ -83,14 +84,14 @@ func TestFileShareLoad(t *testing.T) {
 	defer rt.Close()
 
 	// Create a file and add it to the renter.
-	savedFile := newTestingFile()
+	savedFile := newTestingFileWithPieces(2, 2)
 	id := rt.renter.mu.Lock()
 	rt.renter.files[savedFile.name] = savedFile
 	rt.renter.mu.Unlock(id)
 
 	// Share .sia file to disk.
 	path := filepath.Join(build.SiaTestingDir, "renter", t.Name(), "test.sia")
-	err = rt.renter.ShareFiles([]string{savedFile.name}, path)
+	err = rt.renter.ShareFiles([]string{savedFile.name}, path, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -112,10 +113,10 @@ func TestFileShareLoad(t *testing.T) {
 	}
 
 	// Share and load multiple files.
-	savedFile2 := newTestingFile()
+	savedFile2 := newTestingFileWithPieces(2, 2)
 	rt.renter.files[savedFile2.name] = savedFile2
 	path = filepath.Join(build.SiaTestingDir, "renter", t.Name(), "test2.sia")
-	err = rt.renter.ShareFiles([]string{savedFile.name, savedFile2.name}, path)
+	err = rt.renter.ShareFiles([]string{savedFile.name, savedFile2.name}, path, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -153,12 +154,12 @@ func TestFileShareLoadASCII(t *testing.T) {
 	defer rt.Close()
 
 	// Create a file and add it to the renter.
-	savedFile := newTestingFile()
+	savedFile := newTestingFileWithPieces(2, 2)
 	id := rt.renter.mu.Lock()
 	rt.renter.files[savedFile.name] = savedFile
 	rt.renter.mu.Unlock(id)
 
-	ascii, err := rt.renter.ShareFilesASCII([]string{savedFile.name})
+	ascii, err := rt.renter.ShareFilesASCII([]string{savedFile.name}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -231,6 +232,82 @@ func TestRenterSaveLoad(t *testing.T) {
 	}
 }
 
+// TestRenterLoadReservedFiles checks that files which were uploaded to a
+// reserved directory before it was reserved are moved out of it on load,
+// instead of being deleted with the directory's contents or adopted by the
+// trash. Trashed files of older renters are still loaded into the trash.
+func TestRenterLoadReservedFiles(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	f1 := newTestingFile()
+	f1.name = cacheDir + "/foo"
+	f2 := newTestingFile()
+	f2.name = migratedDir + "/" + cacheDir + "/foo"
+	f3 := newTestingFile()
+	f3.name = trashName("foo")
+	legacy := newTestingFile()
+	legacy.name = trashName("legacy")
+	f4 := newTestingFile()
+	f4.name = redundancyDir + "/foo"
+	for _, f := range []*file{f1, f2, f3, legacy, f4} {
+		if err := r.saveFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id := r.mu.Lock()
+	r.trash["legacy"] = &trashedFile{DeleteTime: time.Now()}
+	err = r.saveSync()
+	if err == nil {
+		r.trash = make(map[string]*trashedFile)
+		err = r.load()
+	}
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.diskCache.load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := equalFiles(f2, r.files[f2.name]); err != nil {
+		t.Fatal(err)
+	}
+	migrated := r.files[f2.name+"_1"]
+	if migrated == nil || migrated.masterKey != f1.masterKey {
+		t.Fatal("file wasn't moved out of the reserved directory:", r.files)
+	} else if _, exists := r.files[f1.name]; exists {
+		t.Fatal("file in reserved directory was loaded")
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(f1.name))); !os.IsNotExist(err) {
+		t.Fatal("metadata wasn't moved:", err)
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(f2.name+"_1"))); err != nil {
+		t.Fatal("metadata of moved file is missing:", err)
+	}
+	if migrated := r.files[migratedDir+"/"+f3.name]; migrated == nil || migrated.masterKey != f3.masterKey {
+		t.Fatal("file without a trash entry wasn't moved out of the trash:", r.files)
+	}
+	if migrated := r.files[migratedDir+"/"+f4.name]; migrated == nil || migrated.masterKey != f4.masterKey {
+		t.Fatal("file without a change of redundancy wasn't moved out of its directory:", r.files)
+	}
+	if trash := r.Trash(); len(trash) != 1 || trash[0].SiaPath != "legacy" {
+		t.Fatal("trash of an older renter wasn't loaded:", trash)
+	}
+	if err := r.RestoreFile("legacy"); err != nil {
+		t.Fatal(err)
+	} else if restored := r.files["legacy"]; restored == nil || restored.masterKey != legacy.masterKey {
+		t.Fatal("trashed file of an older renter wasn't restored")
+	}
+}
+
 // TestRenterPaths checks that the renter properly handles nicknames
 // containing the path separator ("/").
 func TestRenterPaths(t *testing.T) {
@@ -19,6 +19,10 @@ const (
 	// remainingFile is a constant used to indicate that a fileSection can access
 	// the whole remaining file instead of being bound to a certain end offset.
 	remainingFile = -1
+
+	// rangeDownloadProtocolVersion is the first renter-host protocol version
+	// that supports RPCDownloadRange.
+	rangeDownloadProtocolVersion = 1
 )
 
 var (
@@ -19,8 +19,9 @@ const (
 	// portion of a contract can consume.
 	contractHeaderSize = writeaheadlog.MaxPayloadSize // TODO: test this
 
-	updateNameSetHeader = "setHeader"
-	updateNameSetRoot   = "setRoot"
+	updateNameDeleteRoots = "deleteRoots"
+	updateNameSetHeader   = "setHeader"
+	updateNameSetRoot     = "setRoot"
 )
 
 type updateSetHeader struct {
@@ -42,6 +43,15 @@ type updateSetRoot struct {
 	Index int
 }
 
+// updateDeleteRoots replaces the roots starting at Index with Roots and
+// removes the roots after them. Applying it again has no effect, so it can
+// safely be replayed from the WAL.
+type updateDeleteRoots struct {
+	ID    types.FileContractID
+	Index int
+	Roots []crypto.Hash
+}
+
 type contractHeader struct {
 	// transaction is the signed transaction containing the most recent
 	// revision of the file contract.
@@ -231,6 +241,20 @@ func (c *SafeContract) makeUpdateSetRoot(root crypto.Hash, index int) writeahead
 	}
 }
 
+func (c *SafeContract) makeUpdateDeleteRoots(index int, roots []crypto.Hash) writeaheadlog.Update {
+	c.headerMu.Lock()
+	id := c.header.ID()
+	c.headerMu.Unlock()
+	return writeaheadlog.Update{
+		Name: updateNameDeleteRoots,
+		Instructions: encoding.Marshal(updateDeleteRoots{
+			ID:    id,
+			Index: index,
+			Roots: roots,
+		}),
+	}
+}
+
 func (c *SafeContract) applySetHeader(h contractHeader) error {
 	headerBytes := make([]byte, contractHeaderSize)
 	copy(headerBytes, encoding.Marshal(h))
@@ -247,6 +271,15 @@ func (c *SafeContract) applySetRoot(root crypto.Hash, index int) error {
 	return c.merkleRoots.insert(index, root)
 }
 
+func (c *SafeContract) applyDeleteRoots(index int, roots []crypto.Hash) error {
+	for i, root := range roots {
+		if err := c.merkleRoots.insert(index+i, root); err != nil {
+			return err
+		}
+	}
+	return c.merkleRoots.truncate(index + len(roots))
+}
+
 func (c *SafeContract) recordUploadIntent(rev types.FileContractRevision, root crypto.Hash, storageCost, bandwidthCost types.Currency) (*writeaheadlog.Transaction, error) {
 	// construct new header
 	// NOTE: this header will not include the host signature
@@ -339,6 +372,54 @@ func (c *SafeContract) commitDownload(t *writeaheadlog.Transaction, signedTxn ty
 	return nil
 }
 
+// recordDeleteIntent records the intent to delete sectors from the contract.
+// The roots starting at index are replaced with roots, the roots after them
+// are removed.
+func (c *SafeContract) recordDeleteIntent(rev types.FileContractRevision, index int, roots []crypto.Hash) (*writeaheadlog.Transaction, error) {
+	// construct new header
+	// NOTE: this header will not include the host signature
+	c.headerMu.Lock()
+	newHeader := c.header
+	c.headerMu.Unlock()
+	newHeader.Transaction.FileContractRevisions = []types.FileContractRevision{rev}
+
+	t, err := c.wal.NewTransaction([]writeaheadlog.Update{
+		c.makeUpdateSetHeader(newHeader),
+		c.makeUpdateDeleteRoots(index, roots),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := <-t.SignalSetupComplete(); err != nil {
+		return nil, err
+	}
+	c.unappliedTxns = append(c.unappliedTxns, t)
+	return t, nil
+}
+
+func (c *SafeContract) commitDelete(t *writeaheadlog.Transaction, signedTxn types.Transaction, index int, roots []crypto.Hash) error {
+	// construct new header
+	c.headerMu.Lock()
+	newHeader := c.header
+	c.headerMu.Unlock()
+	newHeader.Transaction = signedTxn
+
+	if err := c.applySetHeader(newHeader); err != nil {
+		return err
+	}
+	if err := c.applyDeleteRoots(index, roots); err != nil {
+		return err
+	}
+	if err := c.headerFile.Sync(); err != nil {
+		return err
+	}
+	if err := t.SignalUpdatesApplied(); err != nil {
+		return err
+	}
+	c.unappliedTxns = nil
+	return nil
+}
+
 // commitTxns commits the unapplied transactions to the contract file and marks
 // the transactions as applied.
 func (c *SafeContract) commitTxns() error {
@@ -361,6 +442,14 @@ func (c *SafeContract) commitTxns() error {
 				if err := c.applySetRoot(u.Root, u.Index); err != nil {
 					return err
 				}
+			case updateNameDeleteRoots:
+				var u updateDeleteRoots
+				if err := encoding.Unmarshal(update.Instructions, &u); err != nil {
+					return err
+				}
+				if err := c.applyDeleteRoots(u.Index, u.Roots); err != nil {
+					return err
+				}
 			}
 		}
 		if err := c.headerFile.Sync(); err != nil {
@@ -473,6 +562,12 @@ func (cs *ContractSet) loadSafeContract(filename string, walTxns []*writeaheadlo
 				return err
 			}
 			id = u.ID
+		case updateNameDeleteRoots:
+			var u updateDeleteRoots
+			if err := encoding.Unmarshal(update.Instructions, &u); err != nil {
+				return err
+			}
+			id = u.ID
 		}
 		if id == header.ID() {
 			unappliedTxns = append(unappliedTxns, t)
@@ -142,6 +142,57 @@ func (cs *ContractSet) ViewAll() []modules.RenterContract {
 	return contracts
 }
 
+// A ContractBackup contains the header and the Merkle roots of a contract,
+// which is everything needed to restore the contract.
+type ContractBackup struct {
+	Header contractHeader `json:"header"`
+	Roots  MerkleRootSet  `json:"roots"`
+}
+
+// ID returns the ID of the backed up contract.
+func (b ContractBackup) ID() types.FileContractID {
+	return b.Header.ID()
+}
+
+// EndHeight returns the height at which the backed up contract ends.
+func (b ContractBackup) EndHeight() types.BlockHeight {
+	return b.Header.EndHeight()
+}
+
+// Backup returns a backup of the contract with the specified ID. If the
+// contract is not present in the set, Backup returns false.
+func (cs *ContractSet) Backup(id types.FileContractID) (ContractBackup, bool, error) {
+	c, ok := cs.Acquire(id)
+	if !ok {
+		return ContractBackup{}, false, nil
+	}
+	defer cs.Return(c)
+	roots, err := c.merkleRoots.merkleRoots()
+	if err != nil {
+		return ContractBackup{}, true, err
+	}
+	c.headerMu.Lock()
+	h := c.header
+	c.headerMu.Unlock()
+	return ContractBackup{
+		Header: h,
+		Roots:  roots,
+	}, true, nil
+}
+
+// Restore adds the contract in the backup to the set. If the contract is
+// already present in the set, Restore is a no-op.
+func (cs *ContractSet) Restore(b ContractBackup) error {
+	if err := b.Header.validate(); err != nil {
+		return err
+	}
+	if _, ok := cs.View(b.ID()); ok {
+		return nil
+	}
+	_, err := cs.managedInsertContract(b.Header, b.Roots)
+	return err
+}
+
 // Close closes all contracts in a contract set, this means rendering it unusable for I/O
 func (cs *ContractSet) Close() error {
 	for _, c := range cs.contracts {
@@ -148,3 +148,65 @@ func TestContractSet(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// TestContractSetBackupRestore tests that a contract can be restored from its
+// backup into another contract set.
+func TestContractSetBackupRestore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	cs, err := NewContractSet(build.TempDir(t.Name(), "original"), modules.ProdDependencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := contractHeader{Transaction: types.Transaction{
+		FileContractRevisions: []types.FileContractRevision{{
+			ParentID:             types.FileContractID{1},
+			NewValidProofOutputs: []types.SiacoinOutput{{}, {}},
+			UnlockConditions: types.UnlockConditions{
+				PublicKeys: []types.SiaPublicKey{{}, {}},
+			},
+		}},
+	}}
+	roots := []crypto.Hash{{1}, {2}, {3}}
+	if _, err := cs.managedInsertContract(header, roots); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, ok, err := cs.Backup(header.ID())
+	if err != nil || !ok {
+		t.Fatal("failed to back up contract:", ok, err)
+	}
+	if _, ok, _ := cs.Backup(types.FileContractID{2}); ok {
+		t.Fatal("backed up a contract that isn't part of the set")
+	}
+
+	// Restore the contract into a new set. Restoring it twice should be a
+	// no-op.
+	restored, err := NewContractSet(build.TempDir(t.Name(), "restored"), modules.ProdDependencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := restored.Restore(backup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if restored.Len() != 1 {
+		t.Fatal("expected 1 contract, got", restored.Len())
+	}
+	c := restored.mustAcquire(t, header.ID())
+	restoredRoots, err := c.merkleRoots.merkleRoots()
+	restored.Return(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restoredRoots) != len(roots) || restoredRoots[2] != roots[2] {
+		t.Fatal("roots weren't restored:", restoredRoots)
+	}
+
+	// An invalid backup can't be restored.
+	if err := restored.Restore(ContractBackup{}); err == nil {
+		t.Fatal("restored an invalid contract")
+	}
+}
@@ -2,6 +2,7 @@ package proto
 
 import (
 	"errors"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -12,8 +13,13 @@ import (
 	"github.com/NebulousLabs/Sia/types"
 )
 
+// errUnalignedRange is returned if a range of a sector is requested that is
+// not aligned to segment boundaries.
+var errUnalignedRange = errors.New("range is not segment-aligned")
+
 // A Downloader retrieves sectors by calling the download RPC on a host.
-// Downloaders are NOT thread- safe; calls to Sector must be serialized.
+// Downloaders are NOT thread- safe; calls to Sector and Ranges must be
+// serialized.
 type Downloader struct {
 	contractID  types.FileContractID
 	contractSet *ContractSet
@@ -22,12 +28,93 @@ type Downloader struct {
 	closeChan   chan struct{}
 	once        sync.Once
 	hdb         hostDB
+
+	// rangeProofs indicates whether the host sends Merkle range proofs, which
+	// allows the Downloader to retrieve parts of sectors.
+	rangeProofs bool
+
+	// timeToFirstByte is the time between sending the last request to the
+	// host and receiving the first byte of the requested data.
+	timeToFirstByte time.Duration
+}
+
+// firstByteReader records when the first byte is read from the underlying
+// reader.
+type firstByteReader struct {
+	io.Reader
+	firstByte time.Time
+}
+
+// Read implements io.Reader.
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && r.firstByte.IsZero() {
+		r.firstByte = time.Now()
+	}
+	return n, err
+}
+
+// TimeToFirstByte returns the time between sending the last successful
+// request to the host and receiving the first byte of the requested data.
+func (hd *Downloader) TimeToFirstByte() time.Duration {
+	return hd.timeToFirstByte
 }
 
 // Sector retrieves the sector with the specified Merkle root, and revises
 // the underlying contract to pay the host proportionally to the data
 // retrieve.
 func (hd *Downloader) Sector(root crypto.Hash) (_ modules.RenterContract, _ []byte, err error) {
+	contract, data, err := hd.download([]modules.DownloadAction{{
+		MerkleRoot: root,
+		Offset:     0,
+		Length:     modules.SectorSize,
+	}})
+	if err != nil {
+		return modules.RenterContract{}, nil, err
+	}
+	return contract, data[0], nil
+}
+
+// Ranges retrieves the requested ranges of sectors, and revises the
+// underlying contract to pay the host for the retrieved bytes only. The
+// ranges must be segment-aligned. If the host can't prove that the ranges are
+// part of their sectors, the whole sectors are retrieved and paid for
+// instead.
+func (hd *Downloader) Ranges(actions []modules.DownloadAction) (_ modules.RenterContract, _ [][]byte, err error) {
+	for _, action := range actions {
+		if action.Length == 0 || action.Offset%crypto.SegmentSize != 0 || action.Length%crypto.SegmentSize != 0 {
+			return modules.RenterContract{}, nil, errUnalignedRange
+		} else if action.Offset+action.Length > modules.SectorSize {
+			return modules.RenterContract{}, nil, errors.New("range exceeds the sector")
+		}
+	}
+	if hd.rangeProofs {
+		return hd.download(actions)
+	}
+
+	// Download the whole sectors and slice them.
+	var contract modules.RenterContract
+	sectors := make(map[crypto.Hash][]byte)
+	data := make([][]byte, len(actions))
+	for i, action := range actions {
+		sector, exists := sectors[action.MerkleRoot]
+		if !exists {
+			contract, sector, err = hd.Sector(action.MerkleRoot)
+			if err != nil {
+				return modules.RenterContract{}, nil, err
+			}
+			sectors[action.MerkleRoot] = sector
+		}
+		data[i] = sector[action.Offset : action.Offset+action.Length]
+	}
+	return contract, data, nil
+}
+
+// download performs one iteration of the download loop, retrieving the data
+// requested by actions. If the host sends range proofs, the data of every
+// action is verified using the proof, otherwise every action has to request
+// a whole sector.
+func (hd *Downloader) download(actions []modules.DownloadAction) (_ modules.RenterContract, _ [][]byte, err error) {
 	// Reset deadline when finished.
 	defer extendDeadline(hd.conn, time.Hour) // TODO: Constant.
 
@@ -41,16 +128,20 @@ func (hd *Downloader) Sector(root crypto.Hash) (_ modules.RenterContract, _ []by
 	contract := sc.header // for convenience
 
 	// calculate price
-	sectorPrice := hd.host.DownloadBandwidthPrice.Mul64(modules.SectorSize)
-	if contract.RenterFunds().Cmp(sectorPrice) < 0 {
+	var totalLength uint64
+	for _, action := range actions {
+		totalLength += action.Length
+	}
+	price := hd.host.DownloadBandwidthPrice.Mul64(totalLength)
+	if contract.RenterFunds().Cmp(price) < 0 {
 		return modules.RenterContract{}, nil, errors.New("contract has insufficient funds to support download")
 	}
 	// To mitigate small errors (e.g. differing block heights), fudge the
 	// price and collateral by 0.2%.
-	sectorPrice = sectorPrice.MulFloat(1 + hostPriceLeeway)
+	price = price.MulFloat(1 + hostPriceLeeway)
 
 	// create the download revision
-	rev := newDownloadRevision(contract.LastRevision(), sectorPrice)
+	rev := newDownloadRevision(contract.LastRevision(), price)
 
 	// initiate download by confirming host settings
 	extendDeadline(hd.conn, modules.NegotiateSettingsTime)
@@ -61,18 +152,15 @@ func (hd *Downloader) Sector(root crypto.Hash) (_ modules.RenterContract, _ []by
 	// record the change we are about to make to the contract. If we lose power
 	// mid-revision, this allows us to restore either the pre-revision or
 	// post-revision contract.
-	walTxn, err := sc.recordDownloadIntent(rev, sectorPrice)
+	walTxn, err := sc.recordDownloadIntent(rev, price)
 	if err != nil {
 		return modules.RenterContract{}, nil, err
 	}
 
-	// send download action
+	// send download actions
 	extendDeadline(hd.conn, 2*time.Minute) // TODO: Constant.
-	err = encoding.WriteObject(hd.conn, []modules.DownloadAction{{
-		MerkleRoot: root,
-		Offset:     0,
-		Length:     modules.SectorSize,
-	}})
+	requestTime := time.Now()
+	err = encoding.WriteObject(hd.conn, actions)
 	if err != nil {
 		return modules.RenterContract{}, nil, err
 	}
@@ -98,27 +186,53 @@ func (hd *Downloader) Sector(root crypto.Hash) (_ modules.RenterContract, _ []by
 		return modules.RenterContract{}, nil, err
 	}
 
-	// read sector data, completing one iteration of the download loop
+	// read the data, completing one iteration of the download loop
 	extendDeadline(hd.conn, modules.NegotiateDownloadTime)
-	var sectors [][]byte
-	if err := encoding.ReadObject(hd.conn, &sectors, modules.SectorSize+16); err != nil {
+	var data [][]byte
+	maxLen := totalLength + 8*uint64(len(actions)+1)
+	dataReader := &firstByteReader{Reader: hd.conn}
+	if err := encoding.ReadObject(dataReader, &data, maxLen); err != nil {
 		return modules.RenterContract{}, nil, err
-	} else if len(sectors) != 1 {
+	} else if len(data) != len(actions) {
 		return modules.RenterContract{}, nil, errors.New("host did not send enough sectors")
 	}
-	sector := sectors[0]
-	if uint64(len(sector)) != modules.SectorSize {
-		return modules.RenterContract{}, nil, errors.New("host did not send enough sector data")
-	} else if crypto.MerkleRoot(sector) != root {
-		return modules.RenterContract{}, nil, errors.New("host sent bad sector data")
+	for i, action := range actions {
+		if uint64(len(data[i])) != action.Length {
+			return modules.RenterContract{}, nil, errors.New("host did not send enough sector data")
+		}
+	}
+	if hd.rangeProofs {
+		var proofs [][]crypto.Hash
+		// A range proof contains at most two hashes per level of the tree.
+		maxLen := uint64(len(actions)+1) * (8 + 2*sectorHeight*crypto.HashSize)
+		if err := encoding.ReadObject(hd.conn, &proofs, maxLen); err != nil {
+			return modules.RenterContract{}, nil, err
+		} else if len(proofs) != len(actions) {
+			return modules.RenterContract{}, nil, errors.New("host did not send enough proofs")
+		}
+		numSegments := modules.SectorSize / crypto.SegmentSize
+		for i, action := range actions {
+			start := action.Offset / crypto.SegmentSize
+			end := (action.Offset + action.Length) / crypto.SegmentSize
+			if !crypto.VerifyRangeProof(data[i], proofs[i], start, end, numSegments, action.MerkleRoot) {
+				return modules.RenterContract{}, nil, errors.New("host sent bad sector data")
+			}
+		}
+	} else {
+		for i, action := range actions {
+			if action.Length != modules.SectorSize || crypto.MerkleRoot(data[i]) != action.MerkleRoot {
+				return modules.RenterContract{}, nil, errors.New("host sent bad sector data")
+			}
+		}
 	}
 
 	// update contract and metrics
-	if err := sc.commitDownload(walTxn, signedTxn, sectorPrice); err != nil {
+	if err := sc.commitDownload(walTxn, signedTxn, price); err != nil {
 		return modules.RenterContract{}, nil, err
 	}
+	hd.timeToFirstByte = dataReader.firstByte.Sub(requestTime)
 
-	return sc.Metadata(), sector, nil
+	return sc.Metadata(), data, nil
 }
 
 // shutdown terminates the revision loop and signals the goroutine spawned in
@@ -165,11 +279,18 @@ func (cs *ContractSet) NewDownloader(host modules.HostDBEntry, id types.FileCont
 		}
 	}()
 
-	conn, closeChan, err := initiateRevisionLoop(host, contract, modules.RPCDownload, cancel, cs.rl)
+	// Use the range download RPC if the host supports it.
+	rpc := modules.RPCDownload
+	rangeProofs := host.ProtocolVersion >= rangeDownloadProtocolVersion
+	if rangeProofs {
+		rpc = modules.RPCDownloadRange
+	}
+
+	conn, closeChan, err := initiateRevisionLoop(host, contract, rpc, cancel, cs.rl)
 	if IsRevisionMismatch(err) && len(sc.unappliedTxns) > 0 {
 		// we have desynced from the host. If we have unapplied updates from the
 		// WAL, try applying them.
-		conn, closeChan, err = initiateRevisionLoop(host, sc.unappliedHeader(), modules.RPCDownload, cancel, cs.rl)
+		conn, closeChan, err = initiateRevisionLoop(host, sc.unappliedHeader(), rpc, cancel, cs.rl)
 		if err != nil {
 			return nil, err
 		}
@@ -194,5 +315,7 @@ func (cs *ContractSet) NewDownloader(host modules.HostDBEntry, id types.FileCont
 		conn:        conn,
 		closeChan:   closeChan,
 		hdb:         hdb,
+
+		rangeProofs: rangeProofs,
 	}, nil
 }
@@ -154,6 +154,104 @@ func (he *Editor) Upload(data []byte) (_ modules.RenterContract, _ crypto.Hash,
 	return sc.Metadata(), sectorRoot, nil
 }
 
+// Delete negotiates a revision that removes sectors from a file contract.
+// Roots that the contract doesn't contain are ignored. The host stops storing
+// the removed sectors, which lowers the cost of renewing the contract, but the
+// storage that was already paid for is not refunded.
+func (he *Editor) Delete(roots []crypto.Hash) (_ modules.RenterContract, err error) {
+	// Acquire the contract.
+	sc, haveContract := he.contractSet.Acquire(he.contractID)
+	if !haveContract {
+		return modules.RenterContract{}, errors.New("contract not present in contract set")
+	}
+	defer he.contractSet.Return(sc)
+	contract := sc.header // for convenience
+
+	// find the sectors to delete
+	oldRoots, err := sc.merkleRoots.merkleRoots()
+	if err != nil {
+		return modules.RenterContract{}, err
+	}
+	remove := make(map[crypto.Hash]struct{}, len(roots))
+	for _, root := range roots {
+		remove[root] = struct{}{}
+	}
+	var actions []modules.RevisionAction
+	newRoots := make([]crypto.Hash, 0, len(oldRoots))
+	for i, root := range oldRoots {
+		if _, ok := remove[root]; ok {
+			actions = append(actions, modules.RevisionAction{
+				Type:        modules.ActionDelete,
+				SectorIndex: uint64(i),
+			})
+			continue
+		}
+		newRoots = append(newRoots, root)
+	}
+	if len(actions) == 0 {
+		return sc.Metadata(), nil
+	}
+	// The host applies the actions in order and shifts the sectors after a
+	// deleted sector, so the sectors are deleted back to front.
+	firstIndex := int(actions[0].SectorIndex)
+	for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+		actions[i], actions[j] = actions[j], actions[i]
+	}
+
+	// create the revision
+	merkleRoot := cachedMerkleRoot(newRoots)
+	rev := newDeleteRevision(contract.LastRevision(), merkleRoot, uint64(len(actions)))
+
+	// run the revision iteration
+	defer func() {
+		// Increase Successful/Failed interactions accordingly
+		if err != nil {
+			he.hdb.IncrementFailedInteractions(he.host.PublicKey)
+		} else {
+			he.hdb.IncrementSuccessfulInteractions(he.host.PublicKey)
+		}
+
+		// reset deadline
+		extendDeadline(he.conn, time.Hour)
+	}()
+
+	// initiate revision
+	extendDeadline(he.conn, modules.NegotiateSettingsTime)
+	if err := startRevision(he.conn, he.host); err != nil {
+		return modules.RenterContract{}, err
+	}
+
+	// record the change we are about to make to the contract.
+	walTxn, err := sc.recordDeleteIntent(rev, firstIndex, newRoots[firstIndex:])
+	if err != nil {
+		return modules.RenterContract{}, err
+	}
+
+	// send actions
+	extendDeadline(he.conn, modules.NegotiateFileContractRevisionTime)
+	if err := encoding.WriteObject(he.conn, actions); err != nil {
+		return modules.RenterContract{}, err
+	}
+
+	// send revision to host and exchange signatures
+	extendDeadline(he.conn, 2*time.Minute)
+	signedTxn, err := negotiateRevision(he.conn, rev, contract.SecretKey)
+	if err == modules.ErrStopResponse {
+		// if host gracefully closed, close our connection as well; this will
+		// cause the next operation to fail
+		he.conn.Close()
+	} else if err != nil {
+		return modules.RenterContract{}, err
+	}
+
+	// update contract
+	err = sc.commitDelete(walTxn, signedTxn, firstIndex, newRoots[firstIndex:])
+	if err != nil {
+		return modules.RenterContract{}, err
+	}
+	return sc.Metadata(), nil
+}
+
 // NewEditor initiates the contract revision process with a host, and returns
 // an Editor.
 func (cs *ContractSet) NewEditor(host modules.HostDBEntry, id types.FileContractID, currentHeight types.BlockHeight, hdb hostDB, cancel <-chan struct{}) (_ *Editor, err error) {
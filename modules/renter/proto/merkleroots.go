@@ -187,6 +187,26 @@ func (mr *merkleRoots) delete(i int, lastRoot crypto.Hash, truncateSize int64) e
 	return nil
 }
 
+// truncate removes all but the first n roots.
+func (mr *merkleRoots) truncate(n int) error {
+	if n >= mr.numMerkleRoots {
+		return nil
+	}
+	if err := mr.rootsFile.Truncate(fileOffsetFromRootIndex(n)); err != nil {
+		return errors.AddContext(err, "failed to truncate file")
+	}
+	mr.numMerkleRoots = n
+	// Drop the cached subTrees that contained removed roots and load the
+	// remaining roots of the last one into mr.uncachedRoots.
+	mr.cachedSubTrees = mr.cachedSubTrees[:n/merkleRootsPerCache]
+	roots, err := mr.merkleRootsFromIndexFromDisk(len(mr.cachedSubTrees)*merkleRootsPerCache, n)
+	if err != nil {
+		return errors.AddContext(err, "failed to read uncached roots")
+	}
+	mr.uncachedRoots = roots
+	return nil
+}
+
 // insert inserts a root by replacing a root at an existing index.
 func (mr *merkleRoots) insert(index int, root crypto.Hash) error {
 	// If the index does point to an offset beyond the end of the file we fill
@@ -381,6 +381,57 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+// TestTruncate tests that truncating the roots keeps the in-memory structure
+// consistent with the roots on disk.
+func TestTruncate(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	dir := build.TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	filePath := path.Join(dir, "file.dat")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create enough sector roots for a few cached subTrees.
+	numMerkleRoots := 3*merkleRootsPerCache + 10
+	rootSection := newFileSection(file, 0, -1)
+	merkleRoots := newMerkleRoots(rootSection)
+	for i := 0; i < numMerkleRoots; i++ {
+		hash := crypto.Hash{}
+		copy(hash[:], fastrand.Bytes(crypto.HashSize)[:])
+		merkleRoots.push(hash)
+	}
+
+	// Truncate the roots to a random length a few times. Truncating twice
+	// should have no effect.
+	for numMerkleRoots > 0 {
+		numMerkleRoots = fastrand.Intn(numMerkleRoots)
+		for i := 0; i < 2; i++ {
+			if err := merkleRoots.truncate(numMerkleRoots); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if merkleRoots.numMerkleRoots != numMerkleRoots {
+			t.Fatal("wrong number of roots after truncating", merkleRoots.numMerkleRoots, numMerkleRoots)
+		}
+		loadedRoots, err := loadExistingMerkleRoots(merkleRoots.rootsFile)
+		if err != nil {
+			t.Fatal("failed to load existing roots", err)
+		}
+		if err := cmpRoots(loadedRoots, merkleRoots); err != nil {
+			t.Fatal(err)
+		}
+		if loadedRoots.root() != merkleRoots.root() {
+			t.Fatal("roots have different Merkle roots")
+		}
+	}
+}
+
 // TestMerkleRootsRandom creates a large number of merkle roots and runs random
 // valid operations on them that shouldn't result in any errors.
 func TestMerkleRootsRandom(t *testing.T) {
@@ -51,8 +51,8 @@ func verifySettings(conn net.Conn, host modules.HostDBEntry) (modules.HostDBEntr
 	copy(pk[:], host.PublicKey.Key)
 
 	// read signed host settings
-	var recvSettings modules.HostExternalSettings
-	if err := crypto.ReadSignedObject(conn, &recvSettings, modules.NegotiateMaxHostExternalSettingsLen, pk); err != nil {
+	recvSettings, ext, err := modules.ReadHostSettings(conn, pk, modules.NegotiateMaxHostExternalSettingsLen)
+	if err != nil {
 		return modules.HostDBEntry{}, errors.New("couldn't read host's settings: " + err.Error())
 	}
 	// TODO: check recvSettings against host.HostExternalSettings. If there is
@@ -63,6 +63,7 @@ func verifySettings(conn net.Conn, host modules.HostDBEntry) (modules.HostDBEntr
 		recvSettings.NetAddress = host.NetAddress
 	}
 	host.HostExternalSettings = recvSettings
+	host.ProtocolVersion = ext.ProtocolVersion
 	return host, nil
 }
 
@@ -215,10 +216,10 @@ func newUploadRevision(current types.FileContractRevision, merkleRoot crypto.Has
 }
 
 // newDeleteRevision revises the current revision to cover the cost of
-// deleting a sector.
-func newDeleteRevision(current types.FileContractRevision, merkleRoot crypto.Hash) types.FileContractRevision {
+// deleting sectors.
+func newDeleteRevision(current types.FileContractRevision, merkleRoot crypto.Hash, numSectors uint64) types.FileContractRevision {
 	rev := newRevision(current, types.ZeroCurrency)
-	rev.NewFileSize -= modules.SectorSize
+	rev.NewFileSize -= modules.SectorSize * numSectors
 	rev.NewFileMerkleRoot = merkleRoot
 	return rev
 }
@@ -0,0 +1,559 @@
+package renter
+
+// redundancy.go changes the erasure code of files that have already been
+// uploaded. A file is re-encoded into a new file, which replaces it once its
+// chunks have been uploaded. The data of the chunks is always downloaded from
+// the hosts, the same way it is for remote repairs, so no local copy of the
+// file is needed.
+//
+// If the number of data pieces doesn't change, the redundancy is changed in
+// place. The data pieces and the parity pieces of a chunk don't depend on the
+// number of parity pieces, so the new file keeps the pieces that the file
+// already has and only uploads the parity pieces that are missing. Lowering the
+// parity in place doesn't transfer any data at all.
+//
+// While the new file is uploaded, its metadata is stored in the reserved
+// redundancy directory. Once the upload is complete, the metadata of the new
+// file atomically replaces the metadata of the old file. The sectors of the old
+// file that neither the new file nor any other file references are no longer
+// needed, but they can't be deleted right away. The snapshots of the recent
+// backups still reference them, and so do the downloads and streamers that
+// started reading the old file before it was replaced. The sectors are saved
+// with the renter and deleted from their contracts once numKeptSnapshots
+// snapshots were backed up after the file was replaced and the old file has
+// no readers left. Sectors that can't be deleted, e.g. because their host is
+// offline, stay in their contracts until the contracts expire.
+//
+// Changes run in the background. The changes that are in progress are saved
+// with the renter and resumed when the renter starts, keeping the pieces that
+// were uploaded already. The changes since the renter started and their
+// outcomes are reported by RedundancyChanges.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// redundancyDir is the reserved directory that contains the metadata of
+	// the files that are being re-encoded.
+	redundancyDir = ".redundancy"
+)
+
+var (
+	// errRedundancyChangeInProgress is returned if the redundancy of a file
+	// is changed while a previous change of its redundancy is still in
+	// progress.
+	errRedundancyChangeInProgress = errors.New("the redundancy of the file is already being changed")
+
+	// errRedundancyFileDeleted is returned if a file is deleted while its
+	// redundancy is changed.
+	errRedundancyFileDeleted = errors.New("file was deleted while its redundancy was changed")
+
+	// errRedundancyInterrupted is returned if the renter shuts down before
+	// the redundancy of a file was changed.
+	errRedundancyInterrupted = errors.New("renter shut down before the redundancy was changed")
+
+	// errRedundancySharedChunks is returned when the redundancy of a packed
+	// or deduplicated file is changed. Their chunks are shared with other
+	// files.
+	errRedundancySharedChunks = errors.New("the redundancy of packed or deduplicated files can't be changed")
+
+	// errRedundancyUnchanged is returned when a file is re-encoded with the
+	// erasure code it already has.
+	errRedundancyUnchanged = errors.New("file already has the requested redundancy")
+)
+
+// redundancyChange is a change of the redundancy of a file. The changes that
+// are in progress are persisted, so that they are resumed when the renter
+// starts.
+type redundancyChange struct {
+	// SiaPath is the current siapath of the file and FileKey the hash of its
+	// master key, which identifies the file when the change is resumed.
+	// TempName is the siapath of the metadata of the re-encoded file.
+	SiaPath      string
+	FileKey      crypto.Hash
+	TempName     string
+	DataPieces   int
+	ParityPieces int
+	StartTime    time.Time
+
+	// completed is set once the change is no longer in progress, err is the
+	// error that it failed with.
+	completed bool
+	endTime   time.Time
+	err       error
+
+	file    *file
+	newFile *file
+}
+
+// pendingSectorDeletion contains the sectors of a replaced file that no file
+// needs anymore, grouped by contract. Snapshots is the number of snapshots
+// that were backed up since the file was replaced. file is the replaced file,
+// it is not persisted, since its readers don't survive a restart.
+type pendingSectorDeletion struct {
+	Sectors   []snapshotUpload
+	Snapshots int
+
+	file *file
+}
+
+// requiredRedundancyPieces returns the number of pieces that every chunk of a
+// file with the erasure code ec needs before it replaces a file with the
+// erasure code old. The redundancy of the file only drops if ec is less
+// redundant than old.
+func requiredRedundancyPieces(old, ec modules.ErasureCoder) int {
+	if ec.NumPieces()*old.MinPieces() <= old.NumPieces()*ec.MinPieces() {
+		return ec.NumPieces()
+	}
+	return (old.NumPieces()*ec.MinPieces() + old.MinPieces() - 1) / old.MinPieces()
+}
+
+// newRedundancyFile creates the file that replaces f with the erasure code ec.
+// If the number of data pieces doesn't change, the new file keeps the keys, the
+// chunk checksums and the pieces of f that are part of the new erasure code.
+// The lock of f needs to be held by the caller.
+func newRedundancyFile(f *file, ec modules.ErasureCoder) *file {
+	nf := newFile(redundancyDir+"/"+persist.RandomSuffix(), ec, f.pieceSize, f.size)
+	nf.mode = f.mode
+	nf.modTime = f.modTime
+	nf.checksum = f.checksum
+	nf.compression = f.compression
+	if ec.MinPieces() != f.erasureCode.MinPieces() {
+		return nf
+	}
+	nf.masterKey = f.masterKey
+	nf.chunkKeys = f.chunkKeys
+	nf.chunkChecksums = append([]crypto.Hash(nil), f.chunkChecksums...)
+	nf.segmentChecksums = append([][]crypto.Hash(nil), f.segmentChecksums...)
+	for id, fc := range f.contracts {
+		var pieces []pieceData
+		for _, p := range fc.Pieces {
+			if p.Piece < uint64(ec.NumPieces()) {
+				pieces = append(pieces, p)
+			}
+		}
+		if len(pieces) > 0 {
+			fc.Pieces = pieces
+			nf.contracts[id] = fc
+		}
+	}
+	return nf
+}
+
+// managedRedundancyChunks returns the chunks of nf that are missing pieces.
+// The pieces that nf already has on hosts that are good for renewal are marked
+// as completed, like they are for repairs. The data of the chunks is
+// downloaded from source.
+func (r *Renter) managedRedundancyChunks(nf, source *file, hosts map[string]struct{}) []*unfinishedUploadChunk {
+	nf.mu.RLock()
+	defer nf.mu.RUnlock()
+	chunks := make([]*unfinishedUploadChunk, nf.numChunks())
+	for i := range chunks {
+		chunks[i] = newUnfinishedUploadChunk(nf, uint64(i), "", hosts)
+		chunks[i].sourceFile = source
+		// An empty file has no data to download.
+		if nf.size == 0 {
+			chunks[i].logicalChunkData = NewDownloadDestinationBuffer(chunks[i].length)
+		}
+	}
+	for id, fc := range nf.contracts {
+		contract, exists := r.hostContractor.ContractByID(id)
+		utility, exists2 := r.hostContractor.ContractUtility(id)
+		if !exists || !exists2 || !utility.GoodForRenew {
+			continue
+		}
+		hpk := contract.HostPublicKey.String()
+		for _, p := range fc.Pieces {
+			uc := chunks[p.Chunk]
+			if _, unused := uc.unusedHosts[hpk]; !unused {
+				continue
+			}
+			delete(uc.unusedHosts, hpk)
+			if !uc.pieceUsage[p.Piece] {
+				uc.pieceUsage[p.Piece] = true
+				uc.piecesCompleted++
+			}
+		}
+	}
+
+	incompleteChunks := chunks[:0]
+	for _, uc := range chunks {
+		if uc.piecesCompleted < uc.piecesNeeded {
+			incompleteChunks = append(incompleteChunks, uc)
+		}
+	}
+	return incompleteChunks
+}
+
+// managedUploadRedundancyChunks uploads the pieces that nf is missing, using
+// the data of f. It returns once every chunk of nf is at least as redundant as
+// required to replace f.
+func (r *Renter) managedUploadRedundancyChunks(f, nf *file, hosts map[string]struct{}) error {
+	chunks := r.managedRedundancyChunks(nf, f, hosts)
+	for _, uc := range chunks {
+		if !r.memoryManager.Request(uc.memoryNeeded, memoryPriorityLow) {
+			return errRedundancyInterrupted
+		}
+		go r.managedFetchAndRepairChunk(uc)
+	}
+
+	required := requiredRedundancyPieces(f.erasureCode, nf.erasureCode)
+	for _, uc := range chunks {
+		select {
+		case <-uc.completeChan:
+		case <-r.tg.StopChan():
+			return errRedundancyInterrupted
+		}
+		uc.mu.Lock()
+		piecesCompleted := uc.piecesCompleted
+		uc.mu.Unlock()
+		if piecesCompleted < required {
+			return fmt.Errorf("only %v of %v required pieces of chunk %v were uploaded", piecesCompleted, required, uc.index)
+		}
+	}
+	return nil
+}
+
+// abandonRedundancyFile discards a file that was created to replace another
+// file. The lock of nf needs to be held by the caller.
+func (r *Renter) abandonRedundancyFile(nf *file) {
+	nf.deleted = true
+	err := os.RemoveAll(filepath.Join(r.persistDir, metadataPath(nf.name)))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove abandoned file:", err)
+	}
+}
+
+// surplusSectors returns the roots of the sectors of f that neither nf nor any
+// other file of the renter references, grouped by contract. The lock of the
+// renter needs to be held by the caller, as well as the locks of f and nf.
+func (r *Renter) surplusSectors(f, nf *file) map[types.FileContractID][]crypto.Hash {
+	surplus := make(map[crypto.Hash]types.FileContractID)
+	for id, fc := range f.contracts {
+		for _, p := range fc.Pieces {
+			surplus[p.MerkleRoot] = id
+		}
+	}
+	keep := func(g *file) {
+		for _, fc := range g.contracts {
+			for _, p := range fc.Pieces {
+				delete(surplus, p.MerkleRoot)
+			}
+		}
+	}
+	keep(nf)
+	others := make([]*file, 0, len(r.files)+len(r.trash))
+	for _, g := range r.files {
+		others = append(others, g)
+	}
+	for _, tf := range r.trash {
+		others = append(others, tf.file)
+	}
+	for _, rc := range r.redundancyChanges {
+		if !rc.completed {
+			others = append(others, rc.newFile)
+		}
+	}
+	for _, g := range others {
+		if g == nil || g == f || g == nf {
+			continue
+		}
+		g.mu.RLock()
+		keep(g)
+		g.mu.RUnlock()
+	}
+
+	roots := make(map[types.FileContractID][]crypto.Hash)
+	for root, id := range surplus {
+		roots[id] = append(roots[id], root)
+	}
+	return roots
+}
+
+// managedDeleteSurplusSectors deletes the sectors that a replaced file no
+// longer needs from their contracts. Sectors that can't be deleted stay in
+// their contracts until the contracts expire.
+func (r *Renter) managedDeleteSurplusSectors(roots map[types.FileContractID][]crypto.Hash) {
+	for id, contractRoots := range roots {
+		if err := r.managedDeleteSectors(id, contractRoots); err != nil {
+			r.log.Printf("WARN: couldn't delete %v surplus sectors from contract %v: %v", len(contractRoots), id, err)
+		}
+	}
+}
+
+// addPendingSectorDeletion saves the sectors of the replaced file f that are
+// no longer needed, so that they are deleted once no snapshot or reader
+// references them anymore.
+func (r *Renter) addPendingSectorDeletion(f *file, roots map[types.FileContractID][]crypto.Hash) {
+	if len(roots) == 0 {
+		return
+	}
+	pd := &pendingSectorDeletion{file: f}
+	for id, contractRoots := range roots {
+		pd.Sectors = append(pd.Sectors, snapshotUpload{Contract: id, Roots: contractRoots})
+	}
+	r.pendingDeletions = append(r.pendingDeletions, pd)
+}
+
+// managedDeletePendingSectors deletes the pending sectors that no kept
+// snapshot and no reader of their replaced file references anymore. It returns
+// whether any sectors were deleted.
+func (r *Renter) managedDeletePendingSectors() bool {
+	var deletable []*pendingSectorDeletion
+	id := r.mu.Lock()
+	remaining := r.pendingDeletions[:0]
+	for _, pd := range r.pendingDeletions {
+		if pd.Snapshots >= numKeptSnapshots && (pd.file == nil || atomic.LoadInt64(&pd.file.atomicReaders) == 0) {
+			deletable = append(deletable, pd)
+		} else {
+			remaining = append(remaining, pd)
+		}
+	}
+	r.pendingDeletions = remaining
+	r.mu.Unlock(id)
+	if len(deletable) == 0 {
+		return false
+	}
+
+	for _, pd := range deletable {
+		roots := make(map[types.FileContractID][]crypto.Hash, len(pd.Sectors))
+		for _, s := range pd.Sectors {
+			roots[s.Contract] = s.Roots
+		}
+		r.managedDeleteSurplusSectors(roots)
+	}
+	id = r.mu.Lock()
+	err := r.saveSync()
+	r.mu.Unlock(id)
+	if err != nil {
+		r.log.Println("WARN: could not save the renter after deleting surplus sectors:", err)
+	}
+	return true
+}
+
+// managedReplaceFile replaces f with nf, which was re-encoded from the data of
+// f. The metadata of nf atomically replaces the metadata of f, and f is marked
+// as deleted. The sectors of f that are no longer needed are saved as pending
+// deletions. If f was deleted in the meantime, nf is abandoned and its own
+// sectors are returned, so that they can be deleted right away. Changes that
+// were made to the priority and the metadata of f in the meantime are kept.
+func (r *Renter) managedReplaceFile(f, nf *file) (map[types.FileContractID][]crypto.Hash, error) {
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	nf.mu.Lock()
+	defer nf.mu.Unlock()
+	if f.deleted || r.files[f.name] != f {
+		roots := r.surplusSectors(nf, f)
+		r.abandonRedundancyFile(nf)
+		return roots, errRedundancyFileDeleted
+	}
+
+	// Saving nf under the name of f replaces the metadata of f in a single
+	// WAL transaction.
+	tempName := nf.name
+	nf.name = f.name
+	nf.priority, nf.paused = f.priority, f.paused
+	nf.metadata = copyFileMetadata(f.metadata)
+	if err := r.saveFile(nf); err != nil {
+		nf.name = tempName
+		r.abandonRedundancyFile(nf)
+		return nil, err
+	}
+	err := os.RemoveAll(filepath.Join(r.persistDir, metadataPath(tempName)))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove temporary file:", err)
+	}
+	r.addPendingSectorDeletion(f, r.surplusSectors(f, nf))
+	r.files[f.name] = nf
+	f.deleted = true
+	return nil, nil
+}
+
+// activeRedundancyChange returns the change of the redundancy of f that is in
+// progress, or nil if there is none.
+func (r *Renter) activeRedundancyChange(f *file) *redundancyChange {
+	for _, rc := range r.redundancyChanges {
+		if rc.file == f && !rc.completed {
+			return rc
+		}
+	}
+	return nil
+}
+
+// managedNewRedundancyChange creates the file that replaces the file at
+// siaPath with the erasure code ec and saves the change of its redundancy. The
+// new file is not part of the renter's files until it replaces the old file,
+// so the repair loop ignores it in the meantime.
+func (r *Renter) managedNewRedundancyChange(siaPath string, ec modules.ErasureCoder) (*redundancyChange, error) {
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f, exists := r.files[siaPath]
+	if !exists {
+		return nil, ErrUnknownPath
+	}
+	if r.activeRedundancyChange(f) != nil {
+		return nil, errRedundancyChangeInProgress
+	}
+	var nf *file
+	var err error
+	f.mu.RLock()
+	if f.pack != nil || f.blocks != nil {
+		err = errRedundancySharedChunks
+	} else if f.erasureCode.MinPieces() == ec.MinPieces() && f.erasureCode.NumPieces() == ec.NumPieces() {
+		err = errRedundancyUnchanged
+	} else {
+		nf = newRedundancyFile(f, ec)
+	}
+	fileKey := crypto.HashObject(f.masterKey)
+	f.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.saveFile(nf); err != nil {
+		return nil, err
+	}
+	rc := &redundancyChange{
+		SiaPath:      siaPath,
+		FileKey:      fileKey,
+		TempName:     nf.name,
+		DataPieces:   ec.MinPieces(),
+		ParityPieces: ec.NumPieces() - ec.MinPieces(),
+		StartTime:    time.Now(),
+		file:         f,
+		newFile:      nf,
+	}
+	r.redundancyChanges = append(r.redundancyChanges, rc)
+	if err := r.saveSync(); err != nil {
+		r.redundancyChanges = r.redundancyChanges[:len(r.redundancyChanges)-1]
+		nf.mu.Lock()
+		r.abandonRedundancyFile(nf)
+		nf.mu.Unlock()
+		return nil, err
+	}
+	return rc, nil
+}
+
+// managedAbandonRedundancyChange abandons the re-encoded file of a change that
+// failed and returns the sectors that were uploaded for it.
+func (r *Renter) managedAbandonRedundancyChange(rc *redundancyChange) map[types.FileContractID][]crypto.Hash {
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	rc.file.mu.Lock()
+	defer rc.file.mu.Unlock()
+	rc.newFile.mu.Lock()
+	defer rc.newFile.mu.Unlock()
+	roots := r.surplusSectors(rc.newFile, rc.file)
+	r.abandonRedundancyFile(rc.newFile)
+	return roots
+}
+
+// managedChangeRedundancy uploads the re-encoded file of a change and replaces
+// the file with it. If the change fails, the sectors that were uploaded for it
+// are returned, since no file needs them. The new file is kept if the change
+// is interrupted by a shutdown.
+func (r *Renter) managedChangeRedundancy(rc *redundancyChange) (map[types.FileContractID][]crypto.Hash, error) {
+	// The chunks bypass the repair loop, so the workers need to be available
+	// right away. Once the new file is uploaded, it replaces the old file.
+	f, nf := rc.file, rc.newFile
+	hosts := r.managedRefreshHostsAndWorkers()
+	id := r.mu.RLock()
+	availableWorkers := len(r.workerPool)
+	r.mu.RUnlock(id)
+	var err error
+	if availableWorkers < rc.DataPieces {
+		err = fmt.Errorf("not enough workers to upload file: got %v, needed %v", availableWorkers, rc.DataPieces)
+	} else {
+		err = r.managedUploadRedundancyChunks(f, nf, hosts)
+	}
+	if err == errRedundancyInterrupted {
+		return nil, err
+	} else if err != nil {
+		return r.managedAbandonRedundancyChange(rc), err
+	}
+	return r.managedReplaceFile(f, nf)
+}
+
+// threadedChangeRedundancy runs a change of redundancy and records its
+// outcome. The sectors that were uploaded for a failed change are deleted
+// afterwards. A change that is interrupted by a shutdown stays in progress and
+// is resumed when the renter starts.
+func (r *Renter) threadedChangeRedundancy(rc *redundancyChange) {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+	roots, err := r.managedChangeRedundancy(rc)
+	if err == errRedundancyInterrupted {
+		return
+	}
+	id := r.mu.Lock()
+	rc.completed = true
+	rc.endTime = time.Now()
+	rc.err = err
+	siaPath := rc.SiaPath
+	if err := r.saveSync(); err != nil {
+		r.log.Println("WARN: couldn't save the renter after changing the redundancy of a file:", err)
+	}
+	r.mu.Unlock(id)
+	if err != nil {
+		r.log.Printf("WARN: changing the redundancy of %v failed: %v", siaPath, err)
+	}
+	r.managedDeleteSurplusSectors(roots)
+}
+
+// ChangeRedundancy starts re-encoding the file at siaPath with the erasure
+// code ec. The data of the file is downloaded from the hosts and uploaded with
+// the new erasure code in the background. The progress of the change is
+// reported by RedundancyChanges.
+func (r *Renter) ChangeRedundancy(siaPath string, ec modules.ErasureCoder) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if err := r.checkUploadContracts(ec); err != nil {
+		return err
+	}
+	rc, err := r.managedNewRedundancyChange(siaPath, ec)
+	if err != nil {
+		return err
+	}
+	go r.threadedChangeRedundancy(rc)
+	return nil
+}
+
+// RedundancyChanges returns the changes of redundancy that are in progress and
+// the ones that ended since the renter started, in the order they were
+// started.
+func (r *Renter) RedundancyChanges() []modules.RedundancyChangeInfo {
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	infos := make([]modules.RedundancyChangeInfo, 0, len(r.redundancyChanges))
+	for _, rc := range r.redundancyChanges {
+		info := modules.RedundancyChangeInfo{
+			SiaPath:      rc.SiaPath,
+			DataPieces:   uint64(rc.DataPieces),
+			ParityPieces: uint64(rc.ParityPieces),
+			StartTime:    rc.StartTime,
+			Completed:    rc.completed,
+			EndTime:      rc.endTime,
+		}
+		if rc.err != nil {
+			info.Error = rc.err.Error()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
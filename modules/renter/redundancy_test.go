@@ -0,0 +1,404 @@
+package renter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/types"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestRSCodeParityPrefix checks that the pieces of a chunk don't depend on the
+// number of parity pieces, which allows changing the parity of a file in place.
+func TestRSCodeParityPrefix(t *testing.T) {
+	small, _ := NewRSCode(4, 2)
+	large, _ := NewRSCode(4, 8)
+	data := fastrand.Bytes(4 * 64)
+	smallPieces, err := small.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	largePieces, err := large.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range smallPieces {
+		if !bytes.Equal(smallPieces[i], largePieces[i]) {
+			t.Fatalf("piece %v differs between the erasure codes", i)
+		}
+	}
+}
+
+// TestRequiredRedundancyPieces checks the number of pieces that every chunk
+// needs before a re-encoded file replaces the original file.
+func TestRequiredRedundancyPieces(t *testing.T) {
+	tests := []struct {
+		oldData, oldParity, data, parity, required int
+	}{
+		// A less redundant file only needs to be complete.
+		{10, 30, 10, 10, 20},
+		{10, 30, 20, 10, 30},
+		// A more redundant file needs to be at least as redundant as the
+		// original file.
+		{10, 10, 10, 30, 20},
+		{10, 20, 4, 12, 12},
+		{10, 20, 3, 12, 9},
+	}
+	for _, test := range tests {
+		old, _ := NewRSCode(test.oldData, test.oldParity)
+		ec, _ := NewRSCode(test.data, test.parity)
+		if required := requiredRedundancyPieces(old, ec); required != test.required {
+			t.Errorf("%v-of-%v to %v-of-%v: expected %v, got %v", test.oldData, test.oldData+test.oldParity,
+				test.data, test.data+test.parity, test.required, required)
+		}
+	}
+}
+
+// TestNewRedundancyFile checks that a file whose parity is changed in place
+// keeps its key and its pieces, and that a re-encoded file starts over.
+func TestNewRedundancyFile(t *testing.T) {
+	ec, _ := NewRSCode(2, 2)
+	f := newFile("file", ec, pieceSize, 2*pieceSize)
+	f.chunkChecksums = []crypto.Hash{{1}}
+	var id types.FileContractID
+	for i := uint64(0); i < 4; i++ {
+		id[0] = byte(i)
+		f.contracts[id] = fileContract{ID: id, Pieces: []pieceData{{Chunk: 0, Piece: i}}}
+	}
+
+	// Lowering the parity drops the pieces that aren't part of the new code.
+	lower, _ := NewRSCode(2, 1)
+	nf := newRedundancyFile(f, lower)
+	if !strings.HasPrefix(nf.name, redundancyDir+"/") || nf.size != f.size {
+		t.Fatal("wrong new file:", nf.name, nf.size)
+	}
+	if nf.masterKey != f.masterKey || len(nf.chunkChecksums) != 1 || nf.chunkChecksums[0] != f.chunkChecksums[0] {
+		t.Fatal("key and checksums weren't kept")
+	}
+	if len(nf.contracts) != 3 {
+		t.Fatal("expected 3 contracts, got", len(nf.contracts))
+	}
+	for _, fc := range nf.contracts {
+		if fc.Pieces[0].Piece >= 3 {
+			t.Fatal("piece outside of the new erasure code was kept:", fc.Pieces[0])
+		}
+	}
+	if len(f.contracts) != 4 {
+		t.Fatal("pieces of the original file were modified")
+	}
+
+	// Raising the parity keeps all pieces.
+	higher, _ := NewRSCode(2, 6)
+	if nf := newRedundancyFile(f, higher); len(nf.contracts) != 4 || nf.masterKey != f.masterKey {
+		t.Fatal("pieces weren't kept when raising the parity")
+	}
+
+	// Changing the number of data pieces re-encodes the file.
+	reencoded, _ := NewRSCode(1, 3)
+	nf = newRedundancyFile(f, reencoded)
+	if nf.masterKey == f.masterKey || len(nf.contracts) != 0 || nf.chunkChecksums != nil {
+		t.Fatal("re-encoded file kept the key, pieces or checksums of the original file")
+	}
+	if nf.numChunks() != 2 {
+		t.Fatal("expected 2 chunks, got", nf.numChunks())
+	}
+}
+
+// TestChangeRedundancyErrors checks that the redundancy of a file can only be
+// changed if the file exists, owns its chunks and isn't being changed already,
+// that interrupted changes are resumed and that the new file is abandoned if
+// the change fails.
+func TestChangeRedundancyErrors(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("file", ec, pieceSize, pieceSize)
+	packed := newFile("packed", ec, pieceSize, 1)
+	packed.pack = newFile(newPackName(), ec, pieceSize, pieceSize)
+	id := r.mu.Lock()
+	r.files[f.name] = f
+	r.files[packed.name] = packed
+	err = r.saveFile(f)
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	higher, _ := NewRSCode(1, 2)
+	if err := r.ChangeRedundancy("dne", higher); err != ErrUnknownPath {
+		t.Fatal("expected ErrUnknownPath, got", err)
+	}
+	if err := r.ChangeRedundancy("file", ec); err != errRedundancyUnchanged {
+		t.Fatal("expected errRedundancyUnchanged, got", err)
+	}
+	if err := r.ChangeRedundancy("packed", higher); err != errRedundancySharedChunks {
+		t.Fatal("expected errRedundancySharedChunks, got", err)
+	}
+
+	// The redundancy of a file can't be changed twice at the same time.
+	rc, err := r.managedNewRedundancyChange("file", higher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(rc.TempName))); err != nil {
+		t.Fatal("metadata of the new file wasn't saved:", err)
+	}
+	if err := r.ChangeRedundancy("file", higher); err != errRedundancyChangeInProgress {
+		t.Fatal("expected errRedundancyChangeInProgress, got", err)
+	}
+
+	// Interrupted changes are resumed when the renter is loaded.
+	reload := func() {
+		id := r.mu.Lock()
+		r.files = make(map[string]*file)
+		err := r.load()
+		r.mu.Unlock(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	reload()
+	if len(r.redundancyChanges) != 1 {
+		t.Fatal("expected the interrupted change to be resumed, got", len(r.redundancyChanges))
+	}
+	rc = r.redundancyChanges[0]
+	if rc.file != r.files["file"] || rc.newFile == nil || rc.newFile.name != rc.TempName || rc.DataPieces != 1 || rc.ParityPieces != 2 {
+		t.Fatal("wrong change after reloading the renter:", rc)
+	}
+	if len(r.FileList()) != 1 {
+		t.Fatal("expected only the original file, got", r.FileList())
+	}
+
+	// Without workers, the change fails and the new file is abandoned.
+	r.threadedChangeRedundancy(rc)
+	changes := r.RedundancyChanges()
+	if len(changes) != 1 || !changes[0].Completed || !strings.Contains(changes[0].Error, "not enough workers") {
+		t.Fatal("expected the change to fail without workers, got", changes)
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(rc.TempName))); !os.IsNotExist(err) {
+		t.Fatal("metadata of the abandoned file wasn't removed:", err)
+	}
+	reload()
+	if len(r.redundancyChanges) != 0 {
+		t.Fatal("failed change was resumed")
+	}
+
+	// A change is abandoned if its file was replaced before the renter is
+	// loaded.
+	rc, err = r.managedNewRedundancyChange("file", higher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newFile("file", ec, pieceSize, pieceSize)
+	if err := r.saveFile(other); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if len(r.redundancyChanges) != 0 {
+		t.Fatal("change of a replaced file was resumed")
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(rc.TempName))); !os.IsNotExist(err) {
+		t.Fatal("metadata of the abandoned file wasn't removed:", err)
+	}
+}
+
+// TestSurplusSectors checks that the sectors of a replaced file are only
+// deleted if no other file references them.
+func TestSurplusSectors(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(2, 2)
+	f := newFile("file", ec, pieceSize, 2*pieceSize)
+	var id types.FileContractID
+	for i := uint64(0); i < 4; i++ {
+		id[0] = byte(i)
+		f.contracts[id] = fileContract{ID: id, Pieces: []pieceData{{Chunk: 0, Piece: i, MerkleRoot: crypto.Hash{byte(i + 1)}}}}
+	}
+	lower, _ := NewRSCode(2, 1)
+	nf := newRedundancyFile(f, lower)
+	lockID := r.mu.Lock()
+	r.files[f.name] = f
+	roots := r.surplusSectors(f, nf)
+	r.mu.Unlock(lockID)
+
+	// Only the piece that isn't part of the new erasure code is surplus.
+	id[0] = 3
+	if len(roots) != 1 || len(roots[id]) != 1 || roots[id][0] != (crypto.Hash{4}) {
+		t.Fatal("wrong surplus sectors:", roots)
+	}
+
+	// A sector that another file references is kept.
+	other := newFile("other", ec, pieceSize, pieceSize)
+	other.contracts[id] = fileContract{ID: id, Pieces: []pieceData{{MerkleRoot: crypto.Hash{4}}}}
+	lockID = r.mu.Lock()
+	r.files[other.name] = other
+	roots = r.surplusSectors(f, nf)
+	r.mu.Unlock(lockID)
+	if len(roots) != 0 {
+		t.Fatal("sector of another file is surplus:", roots)
+	}
+}
+
+// TestPendingSectorDeletion checks that the surplus sectors of a replaced file
+// are kept until enough snapshots were backed up and the file has no readers,
+// also after the renter's persistence is reloaded.
+func TestPendingSectorDeletion(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("file", ec, pieceSize, pieceSize)
+	f.atomicReaders = 1
+	id := r.mu.Lock()
+	r.addPendingSectorDeletion(f, map[types.FileContractID][]crypto.Hash{{1}: {{2}}})
+	err = r.saveSync()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := func() int {
+		id := r.mu.RLock()
+		defer r.mu.RUnlock(id)
+		return len(r.pendingDeletions)
+	}
+
+	// The sectors are kept until numKeptSnapshots snapshots were backed up
+	// and the file has no readers.
+	r.managedDeletePendingSectors()
+	if pending() != 1 {
+		t.Fatal("sectors were deleted before the snapshots were backed up")
+	}
+	r.pendingDeletions[0].Snapshots = numKeptSnapshots
+	r.managedDeletePendingSectors()
+	if pending() != 1 {
+		t.Fatal("sectors were deleted while the file had a reader")
+	}
+
+	// After a restart, the file has no readers.
+	id = r.mu.Lock()
+	err = r.saveSync()
+	if err == nil {
+		r.pendingDeletions = nil
+		err = r.load()
+	}
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending() != 1 || r.pendingDeletions[0].file != nil || len(r.pendingDeletions[0].Sectors) != 1 || r.pendingDeletions[0].Sectors[0].Roots[0] != (crypto.Hash{2}) {
+		t.Fatal("pending sectors weren't persisted:", r.pendingDeletions)
+	}
+	r.managedDeletePendingSectors()
+	if pending() != 0 {
+		t.Fatal("sectors weren't deleted")
+	}
+}
+
+// TestReplaceFile checks that a re-encoded file replaces the original file,
+// also after the renter's persistence is reloaded.
+func TestReplaceFile(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("dir/file", ec, pieceSize, pieceSize)
+	id := r.mu.Lock()
+	r.files[f.name] = f
+	r.addFileToDirs(f)
+	err = r.saveFile(f)
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to the file while it is re-encoded are kept.
+	higher, _ := NewRSCode(1, 2)
+	rc, err := r.managedNewRedundancyChange("dir/file", higher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nf, tempName := rc.newFile, rc.TempName
+	if err := r.SetFileMetadata("dir/file", map[string]string{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.managedReplaceFile(f, nf); err != nil {
+		t.Fatal(err)
+	}
+	if !f.deleted || nf.name != "dir/file" {
+		t.Fatal("file wasn't replaced")
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(tempName))); !os.IsNotExist(err) {
+		t.Fatal("metadata of the new file wasn't moved:", err)
+	}
+
+	// Reload the renter's persistence. The file should have the new erasure
+	// code.
+	id = r.mu.Lock()
+	r.files = make(map[string]*file)
+	err = r.load()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := r.File("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.DataPieces != 1 || info.ParityPieces != 2 || info.Metadata["key"] != "value" {
+		t.Fatal("wrong file after replacing it:", info)
+	}
+
+	// A file that was deleted in the meantime isn't replaced.
+	g := r.files["dir/file"]
+	rc, err = r.managedNewRedundancyChange("dir/file", ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nf = rc.newFile
+	if err := r.DeleteFile("dir/file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.managedReplaceFile(g, nf); err != errRedundancyFileDeleted {
+		t.Fatal("expected errRedundancyFileDeleted, got", err)
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(nf.name))); !os.IsNotExist(err) {
+		t.Fatal("metadata of the abandoned file wasn't removed:", err)
+	}
+	if _, err := r.File("dir/file"); err != ErrUnknownPath {
+		t.Fatal("deleted file was replaced:", err)
+	}
+}
@@ -20,16 +20,20 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/modules/renter/contractor"
 	"github.com/NebulousLabs/Sia/modules/renter/hostdb"
+	"github.com/NebulousLabs/Sia/modules/renter/proto"
 	"github.com/NebulousLabs/Sia/persist"
 	siasync "github.com/NebulousLabs/Sia/sync"
 	"github.com/NebulousLabs/Sia/types"
 
 	"github.com/NebulousLabs/threadgroup"
+	"github.com/NebulousLabs/writeaheadlog"
 )
 
 var (
@@ -38,6 +42,7 @@ var (
 	errNilGateway    = errors.New("cannot create hostdb with nil gateway")
 	errNilHdb        = errors.New("cannot create renter with nil hostdb")
 	errNilTpool      = errors.New("cannot create renter with nil transaction pool")
+	errNilWallet     = errors.New("cannot create renter with nil wallet")
 )
 
 var (
@@ -104,6 +109,14 @@ type hostContractor interface {
 	// Contracts returns the contracts formed by the contractor.
 	Contracts() []modules.RenterContract
 
+	// ContractBackup returns a backup of the contract associated with the
+	// file contract id.
+	ContractBackup(types.FileContractID) (proto.ContractBackup, bool, error)
+
+	// ContractBackups returns backups of the contracts formed by the
+	// contractor.
+	ContractBackups() ([]proto.ContractBackup, error)
+
 	// ContractByID returns the contract associated with the file contract id.
 	ContractByID(types.FileContractID) (modules.RenterContract, bool)
 
@@ -123,6 +136,9 @@ type hostContractor interface {
 	// insertion, deletion, and modification of sectors.
 	Editor(types.FileContractID, <-chan struct{}) (contractor.Editor, error)
 
+	// FormContract forms a contract with a host outside of the allowance.
+	FormContract(types.SiaPublicKey, types.Currency, types.BlockHeight) (modules.RenterContract, error)
+
 	// IsOffline reports whether the specified host is considered offline.
 	IsOffline(types.FileContractID) bool
 
@@ -133,6 +149,9 @@ type hostContractor interface {
 	// ResolveID returns the most recent renewal of the specified ID.
 	ResolveID(types.FileContractID) types.FileContractID
 
+	// RestoreContracts adds the contracts of a backup to the contractor.
+	RestoreContracts([]proto.ContractBackup) error
+
 	// RateLimits Gets the bandwidth limits for connections created by the
 	// contractor and its submodules.
 	RateLimits() (readBPS int64, writeBPS int64, packetSize uint64)
@@ -165,8 +184,52 @@ type Renter struct {
 	//
 	// tracking contains a list of files that the user intends to maintain. By
 	// default, files loaded through sharing are not maintained by the user.
+	//
+	// dirs contains the directory tree of the files, keyed by the siapath of
+	// each directory. The root directory has the empty siapath.
+	//
+	// wal is used to atomically update the on-disk metadata of the files.
+	dirs     map[string]*siaDir
 	files    map[string]*file
 	tracking map[string]trackedFile // Map from nickname to metadata.
+	wal      *writeaheadlog.WAL
+
+	// packs contains the packs that store the data of small files, keyed by
+	// their names. openPacks contains the packs that are still open to new
+	// files, keyed by the erasure code of their files. See packs.go.
+	packs     map[string]*file
+	openPacks map[string]*file
+
+	// dedupBlocks contains the blocks that store the chunks of deduplicated
+	// files, keyed by their names. dedupSecret is mixed into the keys of the
+	// blocks. See dedup.go.
+	dedupBlocks map[string]*file
+	dedupSecret crypto.Hash
+
+	// compressionStages contains the staged data of the compressed files
+	// whose chunks haven't all been read since they were uploaded. See
+	// compression.go.
+	compressionStages map[*file]*compressionStage
+
+	// trash contains the deleted files that can still be restored, keyed by
+	// their siapaths and delete times. They are purged once they have been in
+	// the trash for trashRetention. See trash.go.
+	trash          map[string]*trashedFile
+	trashRetention time.Duration
+
+	// redundancyChanges contains the changes of redundancy that are in
+	// progress and the ones that ended since the renter started. See
+	// redundancy.go.
+	redundancyChanges []*redundancyChange
+
+	// pendingDeletions contains the sectors of replaced files that are
+	// deleted once no snapshot and no download references them anymore. See
+	// redundancy.go.
+	pendingDeletions []*pendingSectorDeletion
+
+	// syncFolders contains the local folders that are kept in sync with
+	// siapath prefixes, keyed by their local paths. See syncfolder.go.
+	syncFolders map[string]*syncFolder
 
 	// Download management. The heap has a separate mutex because it is always
 	// accessed in isolation.
@@ -182,8 +245,23 @@ type Renter struct {
 	downloadHistory   []*download
 	downloadHistoryMu sync.Mutex
 
-	// Upload management.
-	uploadHeap uploadHeap
+	// Download overdrive. downloadOverdrive is the number of extra pieces that
+	// are requested for chunks that take longer than expected,
+	// overdriveSpending is the money spent on excess pieces during the period
+	// that began at overdrivePeriod. See overdrive.go.
+	downloadOverdrive uint64
+	overdrivePeriod   types.BlockHeight
+	overdriveSpending types.Currency
+
+	// unsavedChanges is set when persisted data like the overdrive spending
+	// changes too often to be saved right away. The changes are saved by
+	// threadedSaveLoop and when the renter shuts down.
+	unsavedChanges bool
+
+	// Upload management. uploadsPaused is set while the whole upload
+	// pipeline is paused.
+	uploadHeap    uploadHeap
+	uploadsPaused bool
 
 	// List of workers that can be used for uploading and/or downloading.
 	memoryManager *memoryManager
@@ -192,11 +270,23 @@ type Renter struct {
 	// Cache the last price estimation result.
 	lastEstimation modules.RenterPriceEstimation
 
+	// Backup management. lastSnapshot is the hash of the most recent snapshot
+	// that was backed up, snapshotUploads contains the sectors of the recent
+	// snapshots, oldest first. backupMu serializes backups. recovery is the
+	// status of the most recent recovery of a backup, it has a separate mutex
+	// because it is updated while the consensus set is locked. See backup.go.
+	lastSnapshot    crypto.Hash
+	snapshotUploads [][]snapshotUpload
+	backupMu        sync.Mutex
+	recovery        modules.BackupRecoveryStatus
+	recoveryMu      sync.Mutex
+
 	// Utilities.
 	chunkCache     map[string]*cacheData
 	cmu            *sync.Mutex
 	cs             modules.ConsensusSet
 	deps           modules.Dependencies
+	diskCache      *diskCache
 	g              modules.Gateway
 	hostContractor hostContractor
 	hostDB         hostDB
@@ -205,6 +295,7 @@ type Renter struct {
 	mu             *siasync.RWMutex
 	tg             threadgroup.ThreadGroup
 	tpool          modules.TransactionPool
+	wallet         modules.Wallet
 }
 
 // Close closes the Renter and its dependencies
@@ -306,6 +397,14 @@ func (r *Renter) SetSettings(s modules.RenterSettings) error {
 		// the user wants to limit the connection.
 		r.hostContractor.SetRateLimits(s.MaxDownloadSpeed, s.MaxUploadSpeed, 4*4096)
 	}
+	// Set download overdrive.
+	if err := r.managedSetDownloadOverdrive(s.DownloadOverdrive); err != nil {
+		return err
+	}
+	// Set trash retention.
+	if err := r.managedSetTrashRetention(s.TrashRetention); err != nil {
+		return err
+	}
 
 	r.managedUpdateWorkerPool()
 	return nil
@@ -342,8 +441,13 @@ func (r *Renter) ContractUtility(id types.FileContractID) (modules.ContractUtili
 	return r.hostContractor.ContractUtility(id)
 }
 
-// PeriodSpending returns the host contractor's period spending
-func (r *Renter) PeriodSpending() modules.ContractorSpending { return r.hostContractor.PeriodSpending() }
+// PeriodSpending returns the host contractor's period spending, including the
+// spending on excess pieces of overdrive downloads.
+func (r *Renter) PeriodSpending() modules.ContractorSpending {
+	spending := r.hostContractor.PeriodSpending()
+	spending.OverdriveSpending = r.managedOverdriveSpending()
+	return spending
+}
 
 // Settings returns the host contractor's allowance
 func (r *Renter) Settings() modules.RenterSettings {
@@ -352,6 +456,9 @@ func (r *Renter) Settings() modules.RenterSettings {
 		Allowance:        r.hostContractor.Allowance(),
 		MaxDownloadSpeed: download,
 		MaxUploadSpeed:   upload,
+
+		DownloadOverdrive: r.managedDownloadOverdrive(),
+		TrashRetention:    r.managedTrashRetention(),
 	}
 }
 
@@ -385,6 +492,24 @@ func validateSiapath(siapath string) error {
 	if strings.HasPrefix(siapath, "./") {
 		return errors.New("siapath connot begin with ./")
 	}
+	if siapath == packDir || strings.HasPrefix(siapath, packDir+"/") {
+		return errors.New("siapath cannot be inside the reserved " + packDir + " directory")
+	}
+	if siapath == dedupDir || strings.HasPrefix(siapath, dedupDir+"/") {
+		return errors.New("siapath cannot be inside the reserved " + dedupDir + " directory")
+	}
+	if siapath == cacheDir || strings.HasPrefix(siapath, cacheDir+"/") {
+		return errors.New("siapath cannot be inside the reserved " + cacheDir + " directory")
+	}
+	if siapath == trashDir || strings.HasPrefix(siapath, trashDir+"/") {
+		return errors.New("siapath cannot be inside the reserved " + trashDir + " directory")
+	}
+	if siapath == redundancyDir || strings.HasPrefix(siapath, redundancyDir+"/") {
+		return errors.New("siapath cannot be inside the reserved " + redundancyDir + " directory")
+	}
+	if siapath == compressionStageDir || strings.HasPrefix(siapath, compressionStageDir+"/") {
+		return errors.New("siapath cannot be inside the reserved " + compressionStageDir + " directory")
+	}
 	for _, pathElem := range strings.Split(siapath, "/") {
 		if pathElem == "." || pathElem == ".." {
 			return errors.New("siapath cannot contain . or .. elements")
@@ -397,13 +522,16 @@ func validateSiapath(siapath string) error {
 var _ modules.Renter = (*Renter)(nil)
 
 // NewCustomRenter initializes a renter and returns it.
-func NewCustomRenter(g modules.Gateway, cs modules.ConsensusSet, tpool modules.TransactionPool, hdb hostDB, hc hostContractor, persistDir string, deps modules.Dependencies) (*Renter, error) {
+func NewCustomRenter(g modules.Gateway, cs modules.ConsensusSet, w modules.Wallet, tpool modules.TransactionPool, hdb hostDB, hc hostContractor, persistDir string, deps modules.Dependencies) (*Renter, error) {
 	if g == nil {
 		return nil, errNilGateway
 	}
 	if cs == nil {
 		return nil, errNilCS
 	}
+	if w == nil {
+		return nil, errNilWallet
+	}
 	if tpool == nil {
 		return nil, errNilTpool
 	}
@@ -415,9 +543,21 @@ func NewCustomRenter(g modules.Gateway, cs modules.ConsensusSet, tpool modules.T
 	}
 
 	r := &Renter{
+		dirs:     make(map[string]*siaDir),
 		files:    make(map[string]*file),
 		tracking: make(map[string]trackedFile),
 
+		packs:     make(map[string]*file),
+		openPacks: make(map[string]*file),
+
+		dedupBlocks: make(map[string]*file),
+
+		compressionStages: make(map[*file]*compressionStage),
+
+		trash: make(map[string]*trashedFile),
+
+		syncFolders: make(map[string]*syncFolder),
+
 		// Making newDownloads a buffered channel means that most of the time, a
 		// new download will trigger an unnecessary extra iteration of the
 		// download heap loop, searching for a chunk that's not there. This is
@@ -427,7 +567,7 @@ func NewCustomRenter(g modules.Gateway, cs modules.ConsensusSet, tpool modules.T
 		downloadHeap: new(downloadChunkHeap),
 
 		uploadHeap: uploadHeap{
-			activeChunks: make(map[uploadChunkID]struct{}),
+			activeChunks: make(map[uploadChunkID]*unfinishedUploadChunk),
 			newUploads:   make(chan struct{}, 1),
 		},
 
@@ -443,6 +583,7 @@ func NewCustomRenter(g modules.Gateway, cs modules.ConsensusSet, tpool modules.T
 		persistDir:     persistDir,
 		mu:             siasync.New(modules.SafeMutexDelay, 1),
 		tpool:          tpool,
+		wallet:         w,
 	}
 	r.memoryManager = newMemoryManager(defaultMemory, r.tg.StopChan())
 
@@ -459,8 +600,30 @@ func NewCustomRenter(g modules.Gateway, cs modules.ConsensusSet, tpool modules.T
 
 	// Spin up the workers for the work pool.
 	r.managedUpdateWorkerPool()
+
+	// Resume the downloads that didn't complete before the last shutdown.
+	if err := r.managedLoadDownloads(); err != nil {
+		return nil, err
+	}
 	go r.threadedDownloadLoop()
 	go r.threadedUploadLoop()
+	go r.threadedStuckLoop()
+	go r.threadedBackupLoop()
+	go r.threadedTrashLoop()
+	go r.threadedSaveLoop()
+	for _, f := range r.syncFolders {
+		go r.threadedSyncFolder(f)
+	}
+	for _, rc := range r.redundancyChanges {
+		go r.threadedChangeRedundancy(rc)
+	}
+
+	// Save the remaining changes once all threads have stopped.
+	r.tg.AfterStop(func() error {
+		id := r.mu.Lock()
+		defer r.mu.Unlock(id)
+		return r.saveChanges()
+	})
 
 	// Kill workers on shutdown.
 	r.tg.OnStop(func() error {
@@ -486,5 +649,5 @@ func New(g modules.Gateway, cs modules.ConsensusSet, wallet modules.Wallet, tpoo
 		return nil, err
 	}
 
-	return NewCustomRenter(g, cs, tpool, hdb, hc, persistDir, modules.ProdDependencies)
+	return NewCustomRenter(g, cs, wallet, tpool, hdb, hc, persistDir, modules.ProdDependencies)
 }
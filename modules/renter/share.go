@@ -0,0 +1,707 @@
+package renter
+
+// share.go implements the .sia share format, which is used to share files
+// with other renters. A share file starts with a header and a version, which
+// are followed by the gzipped JSON encoding of the shared files. Readers
+// accept every version with the same major version number and ignore fields
+// that they don't know about, so that fields can be added to the format
+// without breaking older renters.
+//
+// The data of a packed file is described by its pack. The erasure code, key,
+// pieces and chunk checksums of a packed file are those of the pack, and the
+// location of the file's data within the pack is stored separately. Sharing
+// the key of a pack reveals the data of every file in it, so only files that
+// are the only data of their pack can be shared. Backups of the renter use the
+// same format and contain every packed file, and share files that describe a
+// pack with other files can still be loaded. For the same reason, backups
+// contain the keys of the chunks of concatenated files, which can't be shared
+// since older renters would ignore the keys. The chunks
+// of a deduplicated file are described by the blocks that store them, in the
+// order of the chunks. The size of a compressed file is the size of its
+// compressed data, which is described by the frames of the file.
+//
+// The pieces of a shared file are listed per host. Every host is identified
+// by its public key, which allows a renter to download the file through its
+// own contracts with the same hosts. The contract IDs of the sharing renter
+// can optionally be stripped from the share file.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+const (
+	// shareVersion is the current version of the share format. Share files
+	// with a different minor version can still be read.
+	shareVersion = "1.0"
+
+	// erasureCodeReedSolomon is the name of the Reed-Solomon erasure code in
+	// the share format.
+	erasureCodeReedSolomon = "Reed-Solomon"
+)
+
+var (
+	// errUnknownErasureCode is returned when a shared file uses an erasure
+	// code that is not supported by the renter.
+	errUnknownErasureCode = errors.New("unknown erasure code")
+
+	// errSharePackedFile is returned when a file is shared whose pack also
+	// stores the data of other files.
+	errSharePackedFile = errors.New("file is packed together with other files and can't be shared")
+
+	// errShareConcatenatedFile is returned when a concatenated file is
+	// shared.
+	errShareConcatenatedFile = errors.New("file was concatenated from other files and can't be shared")
+
+	// errShareUncoveredChunks is returned when a shared file has chunks that
+	// neither its pieces nor its checksums describe.
+	errShareUncoveredChunks = errors.New("shared file has chunks without pieces")
+
+	shareHeader = [15]byte{'S', 'i', 'a', ' ', 'S', 'h', 'a', 'r', 'e', 'd', ' ', 'F', 'i', 'l', 'e'}
+)
+
+type (
+	// sharedFile is the share format of a file.
+	sharedFile struct {
+		SiaPath     string            `json:"siapath"`
+		Size        uint64            `json:"size"`
+		Mode        uint32            `json:"mode"`
+		MasterKey   crypto.TwofishKey `json:"masterkey"`
+		PieceSize   uint64            `json:"piecesize"`
+		ErasureCode sharedErasureCode `json:"erasurecode"`
+		Contracts   []sharedContract  `json:"contracts"`
+		Pack        *sharedPack       `json:"pack,omitempty"`
+		Blocks      []sharedBlock     `json:"blocks,omitempty"`
+
+		// Checksum is the checksum of the file's data and ChunkChecksums
+		// contains the checksums of the chunks that are described by
+		// Contracts. Unknown checksums are zero. SegmentChecksums contains
+		// the checksums of the segments of those chunks.
+		Checksum         crypto.Hash     `json:"checksum"`
+		ChunkChecksums   []crypto.Hash   `json:"chunkchecksums,omitempty"`
+		SegmentChecksums [][]crypto.Hash `json:"segmentchecksums,omitempty"`
+
+		// Metadata is the application metadata of the file.
+		Metadata map[string]string `json:"metadata,omitempty"`
+
+		// ModTime is the modification time of the source of the upload. It
+		// is zero if it is unknown.
+		ModTime time.Time `json:"modtime"`
+
+		// Compression describes the compressed frames of a compressed file,
+		// it is omitted if the file is not compressed. Size is the size of
+		// the compressed data in that case.
+		Compression *sharedCompression `json:"compression,omitempty"`
+
+		// ChunkKeys contains the keys of the chunks of a concatenated file,
+		// it is omitted if the keys of the chunks are derived from
+		// MasterKey.
+		ChunkKeys []sharedChunkKey `json:"chunkkeys,omitempty"`
+	}
+
+	// sharedChunkKey is the master key and the index that the keys of the
+	// pieces of a chunk are derived from.
+	sharedChunkKey struct {
+		MasterKey crypto.TwofishKey `json:"masterkey"`
+		Index     uint64            `json:"index"`
+	}
+
+	// sharedCompression contains the codec and the frames of a compressed
+	// file.
+	sharedCompression struct {
+		Codec            string   `json:"codec"`
+		FrameSize        uint64   `json:"framesize"`
+		FrameOffsets     []uint64 `json:"frameoffsets"`
+		UncompressedSize uint64   `json:"uncompressedsize"`
+	}
+
+	// sharedBlock contains the key and the pieces of the block that stores a
+	// chunk of a deduplicated file.
+	sharedBlock struct {
+		MasterKey        crypto.TwofishKey `json:"masterkey"`
+		Size             uint64            `json:"size"`
+		Checksum         crypto.Hash       `json:"checksum"`
+		SegmentChecksums []crypto.Hash     `json:"segmentchecksums,omitempty"`
+		Contracts        []sharedContract  `json:"contracts"`
+	}
+
+	// sharedPack contains the location of a packed file's data within its
+	// pack.
+	sharedPack struct {
+		Offset uint64 `json:"offset"`
+		Size   uint64 `json:"size"`
+	}
+
+	// sharedErasureCode contains the parameters of the erasure code of a
+	// shared file.
+	sharedErasureCode struct {
+		Type         string `json:"type"`
+		DataPieces   int    `json:"datapieces"`
+		ParityPieces int    `json:"paritypieces"`
+	}
+
+	// sharedContract contains the pieces of a shared file that are stored on
+	// a host. ID is omitted if the contract IDs were stripped from the share
+	// file, HostPublicKey is empty if the host of the contract is unknown.
+	sharedContract struct {
+		ID            *types.FileContractID `json:"id,omitempty"`
+		HostPublicKey string                `json:"hostpublickey,omitempty"`
+		NetAddress    modules.NetAddress    `json:"netaddress"`
+		WindowStart   types.BlockHeight     `json:"windowstart"`
+		Pieces        []pieceData           `json:"pieces"`
+	}
+)
+
+// compatibleShareVersion returns true if share files with the provided
+// version can be read by the renter.
+func compatibleShareVersion(version string) bool {
+	major := strings.SplitN(shareVersion, ".", 2)[0]
+	return strings.SplitN(version, ".", 2)[0] == major
+}
+
+// shareable returns an error if f can't be shared with other renters. The
+// pack of a packed file must not contain the data of any other file, since the
+// pack's key would reveal it. Renters that don't know about chunk keys would
+// decrypt the chunks of a concatenated file with the wrong keys.
+func shareable(f *file) error {
+	if f.chunkKeys != nil {
+		return fmt.Errorf("%v: %v", f.name, errShareConcatenatedFile)
+	} else if f.pack == nil {
+		return nil
+	}
+	f.pack.mu.RLock()
+	defer f.pack.mu.RUnlock()
+	if f.packOffset != 0 || f.pack.size != f.size {
+		return fmt.Errorf("%v: %v", f.name, errSharePackedFile)
+	}
+	return nil
+}
+
+// sharedFileFromFile converts f to the share format. If stripContractIDs is
+// true, the contract IDs are omitted and pieces stored on unknown hosts are
+// left out, since no other renter would be able to find them.
+func (r *Renter) sharedFileFromFile(f *file, stripContractIDs bool) (sharedFile, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// The data of a packed file is described by its pack.
+	data := f
+	var pack *sharedPack
+	if f.pack != nil {
+		f.pack.mu.RLock()
+		defer f.pack.mu.RUnlock()
+		data = f.pack
+		pack = &sharedPack{
+			Offset: f.packOffset,
+			Size:   f.pack.size,
+		}
+	}
+
+	rsc, ok := data.erasureCode.(*rsCode)
+	if !ok {
+		return sharedFile{}, errUnknownErasureCode
+	}
+	sf := sharedFile{
+		SiaPath:   f.name,
+		Size:      f.size,
+		Mode:      f.mode,
+		ModTime:   f.modTime,
+		MasterKey: data.masterKey,
+		PieceSize: data.pieceSize,
+		ErasureCode: sharedErasureCode{
+			Type:         erasureCodeReedSolomon,
+			DataPieces:   rsc.dataPieces,
+			ParityPieces: rsc.numPieces - rsc.dataPieces,
+		},
+		Pack:      pack,
+		Contracts: r.sharedContracts(data, stripContractIDs),
+
+		Checksum:         f.checksum,
+		ChunkChecksums:   data.chunkChecksums,
+		SegmentChecksums: data.segmentChecksums,
+		Metadata:         copyFileMetadata(f.metadata),
+	}
+	if c := f.compression; c != nil {
+		sf.Compression = &sharedCompression{
+			Codec:            c.codec,
+			FrameSize:        c.frameSize,
+			FrameOffsets:     c.frameOffsets,
+			UncompressedSize: c.dataSize,
+		}
+	}
+	for _, ck := range f.chunkKeys {
+		sf.ChunkKeys = append(sf.ChunkKeys, sharedChunkKey{
+			MasterKey: ck.MasterKey,
+			Index:     ck.Index,
+		})
+	}
+	for _, block := range f.blocks {
+		block.mu.RLock()
+		sf.Blocks = append(sf.Blocks, sharedBlock{
+			MasterKey:        block.masterKey,
+			Size:             block.size,
+			Checksum:         block.chunkChecksum(0),
+			SegmentChecksums: block.chunkSegmentChecksums(0),
+			Contracts:        r.sharedContracts(block, stripContractIDs),
+		})
+		block.mu.RUnlock()
+	}
+	return sf, nil
+}
+
+// sharedContracts converts the contracts of f to the share format. The
+// contracts are shared in a fixed order, so that sharing the same file twice
+// results in the same share file.
+func (r *Renter) sharedContracts(f *file, stripContractIDs bool) []sharedContract {
+	ids := make([]types.FileContractID, 0, len(f.contracts))
+	for id := range f.contracts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+	var contracts []sharedContract
+	for _, id := range ids {
+		fc := f.contracts[id]
+		sc := sharedContract{
+			NetAddress:  fc.IP,
+			WindowStart: fc.WindowStart,
+			Pieces:      fc.Pieces,
+		}
+		if contract, exists := r.hostContractor.ContractByID(id); exists {
+			sc.HostPublicKey = contract.HostPublicKey.String()
+		}
+		if !stripContractIDs {
+			contractID := id
+			sc.ID = &contractID
+		} else if sc.HostPublicKey == "" {
+			continue
+		}
+		contracts = append(contracts, sc)
+	}
+	return contracts
+}
+
+// fileFromSharedFile converts a shared file to a file of the renter. The
+// pieces of each host are assigned to the renter's own contract with that
+// host. If the renter has no contract with a host, the contract ID from the
+// share file is used. Pieces that can be assigned to neither are dropped.
+//
+// The pack of a packed file is looked up in packs by its master key. If the
+// pack is unknown, a new pack is created and added to packs. The same goes for
+// the blocks of a deduplicated file, which are looked up in blocks by their
+// name.
+func (r *Renter) fileFromSharedFile(sf sharedFile, packs map[crypto.TwofishKey]*file, blocks map[string]*file) (*file, error) {
+	if sf.PieceSize == 0 {
+		return nil, errors.New("piece size must be nonzero")
+	}
+	if sf.ErasureCode.Type != erasureCodeReedSolomon {
+		return nil, errUnknownErasureCode
+	}
+	rsc, err := NewRSCode(sf.ErasureCode.DataPieces, sf.ErasureCode.ParityPieces)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFileMetadata(sf.Metadata); err != nil {
+		return nil, err
+	}
+
+	// Map the hosts to the renter's contracts.
+	hostContracts := make(map[string]types.FileContractID)
+	for _, contract := range r.hostContractor.Contracts() {
+		hostContracts[contract.HostPublicKey.String()] = contract.ID
+	}
+
+	f := &file{
+		name:        sf.SiaPath,
+		size:        sf.Size,
+		contracts:   make(map[types.FileContractID]fileContract),
+		masterKey:   sf.MasterKey,
+		erasureCode: rsc,
+		pieceSize:   sf.PieceSize,
+		mode:        sf.Mode,
+		modTime:     sf.ModTime,
+		checksum:    sf.Checksum,
+		metadata:    copyFileMetadata(sf.Metadata),
+
+		staticUID: persist.RandomSuffix(),
+	}
+	if sc := sf.Compression; sc != nil {
+		f.compression = &fileCompression{
+			codec:        sc.Codec,
+			frameSize:    sc.FrameSize,
+			frameOffsets: sc.FrameOffsets,
+			dataSize:     sc.UncompressedSize,
+		}
+		if err := f.compression.validate(f.size); err != nil {
+			return nil, err
+		}
+	}
+
+	// The chunks of a deduplicated file are stored in its blocks.
+	if len(sf.Blocks) > 0 {
+		if sf.Pack != nil || uint64(len(sf.Blocks)) != f.numChunks() {
+			return nil, errors.New("blocks of deduplicated file don't match its chunks")
+		}
+		f.blocks = make([]*file, len(sf.Blocks))
+		for i, sb := range sf.Blocks {
+			if sb.Size != f.chunkDataSize(uint64(i)) {
+				return nil, fmt.Errorf("block of chunk %v has the wrong size", i)
+			}
+			block, exists := blocks[dedupBlockName(dedupBlockID(sb.MasterKey))]
+			if !exists {
+				block = newDedupBlock(sb.MasterKey, rsc, sb.Size, sb.Checksum, sb.SegmentChecksums)
+				block.pieceSize = sf.PieceSize
+				if err := addSharedContracts(block, sb.Contracts, hostContracts); err != nil {
+					return nil, err
+				}
+				blocks[block.name] = block
+			}
+			f.blocks[i] = block
+		}
+		return f, nil
+	}
+
+	// The pieces of a packed file belong to its pack.
+	data := f
+	if sf.Pack != nil {
+		if sf.Pack.Size > f.staticChunkSize() || sf.Pack.Offset+sf.Size > sf.Pack.Size {
+			return nil, errors.New("packed file exceeds its pack")
+		}
+		f.packOffset = sf.Pack.Offset
+		if pack, exists := packs[sf.MasterKey]; exists {
+			f.pack = pack
+			return f, nil
+		}
+		f.pack = &file{
+			name:        newPackName(),
+			size:        sf.Pack.Size,
+			contracts:   make(map[types.FileContractID]fileContract),
+			masterKey:   sf.MasterKey,
+			erasureCode: rsc,
+			pieceSize:   sf.PieceSize,
+
+			staticUID: persist.RandomSuffix(),
+		}
+		packs[sf.MasterKey] = f.pack
+		data = f.pack
+	}
+
+	if len(sf.ChunkKeys) > 0 {
+		if sf.Pack != nil || uint64(len(sf.ChunkKeys)) != f.numChunks() {
+			return nil, errors.New("chunk keys of shared file don't match its chunks")
+		}
+		for _, sk := range sf.ChunkKeys {
+			f.chunkKeys = append(f.chunkKeys, chunkKey{
+				MasterKey: sk.MasterKey,
+				Index:     sk.Index,
+			})
+		}
+	}
+	if len(sf.ChunkChecksums) > 0 {
+		if uint64(len(sf.ChunkChecksums)) != data.numChunks() {
+			return nil, errors.New("checksums of shared file don't match its chunks")
+		}
+		data.chunkChecksums = sf.ChunkChecksums
+	}
+	if len(sf.SegmentChecksums) > 0 {
+		if uint64(len(sf.SegmentChecksums)) != data.numChunks() {
+			return nil, errors.New("segment checksums of shared file don't match its chunks")
+		}
+		data.segmentChecksums = sf.SegmentChecksums
+	}
+	if err := addSharedContracts(data, sf.Contracts, hostContracts); err != nil {
+		return nil, err
+	}
+	if len(data.chunkChecksums) == 0 && !coversChunks(sf.Contracts, data.numChunks()) {
+		return nil, errShareUncoveredChunks
+	}
+	return f, nil
+}
+
+// coversChunks returns whether every chunk of a shared file with numChunks
+// chunks has a piece in contracts. Otherwise the size of the file could make
+// the renter allocate the metadata of far more chunks than the share file
+// describes. A single chunk without pieces is allowed, e.g. for empty files.
+func coversChunks(contracts []sharedContract, numChunks uint64) bool {
+	if numChunks <= 1 {
+		return true
+	}
+	chunks := make(map[uint64]struct{})
+	for _, sc := range contracts {
+		for _, piece := range sc.Pieces {
+			chunks[piece.Chunk] = struct{}{}
+		}
+	}
+	return uint64(len(chunks)) >= numChunks
+}
+
+// addSharedContracts adds the pieces of shared contracts to f. The pieces of
+// each host are assigned to the renter's own contract with that host, which
+// is looked up in hostContracts.
+func addSharedContracts(f *file, contracts []sharedContract, hostContracts map[string]types.FileContractID) error {
+	for _, sc := range contracts {
+		for _, piece := range sc.Pieces {
+			if piece.Piece >= uint64(f.erasureCode.NumPieces()) || piece.Chunk >= f.numChunks() {
+				return fmt.Errorf("piece %v of chunk %v is out of bounds", piece.Piece, piece.Chunk)
+			}
+		}
+		id, exists := hostContracts[sc.HostPublicKey]
+		if sc.HostPublicKey == "" || !exists {
+			if sc.ID == nil {
+				continue
+			}
+			id = *sc.ID
+		}
+		// Two hosts in the share file might map to the same contract if the
+		// share file was edited, in which case their pieces are merged.
+		fc := f.contracts[id]
+		fc.ID = id
+		fc.IP = sc.NetAddress
+		fc.WindowStart = sc.WindowStart
+		fc.Pieces = append(fc.Pieces, sc.Pieces...)
+		f.contracts[id] = fc
+	}
+	return nil
+}
+
+// shareFiles writes the specified files to w. First a header is written,
+// followed by the gzipped JSON encoding of the files.
+func (r *Renter) shareFiles(files []*file, w io.Writer, stripContractIDs bool) error {
+	sharedFiles := make([]sharedFile, len(files))
+	for i, f := range files {
+		if err := shareable(f); err != nil {
+			return err
+		}
+		sf, err := r.sharedFileFromFile(f, stripContractIDs)
+		if err != nil {
+			return err
+		}
+		sharedFiles[i] = sf
+	}
+	return writeSharedFiles(sharedFiles, w)
+}
+
+// writeSharedFiles writes the header of the share format to w, followed by
+// the gzipped JSON encoding of sharedFiles.
+func writeSharedFiles(sharedFiles []sharedFile, w io.Writer) error {
+	// Write header.
+	err := encoding.NewEncoder(w).EncodeAll(
+		shareHeader,
+		shareVersion,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Encode the files.
+	zip, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err := json.NewEncoder(zip).Encode(sharedFiles); err != nil {
+		return err
+	}
+	return zip.Close()
+}
+
+// filesByNames returns the renter's files with the specified nicknames.
+func (r *Renter) filesByNames(nicknames []string) ([]*file, error) {
+	if len(nicknames) == 0 {
+		return nil, ErrNoNicknames
+	}
+	files := make([]*file, len(nicknames))
+	for i, name := range nicknames {
+		f, exists := r.files[name]
+		if !exists {
+			return nil, ErrUnknownPath
+		}
+		files[i] = f
+	}
+	return files, nil
+}
+
+// ShareFiles saves the specified files to shareDest. If stripContractIDs is
+// true, the renter's contract IDs are not included.
+func (r *Renter) ShareFiles(nicknames []string, shareDest string, stripContractIDs bool) error {
+	lockID := r.mu.RLock()
+	defer r.mu.RUnlock(lockID)
+
+	// TODO: consider just appending the proper extension.
+	if filepath.Ext(shareDest) != ShareExtension {
+		return ErrNonShareSuffix
+	}
+
+	// Load files from renter.
+	files, err := r.filesByNames(nicknames)
+	if err != nil {
+		return err
+	}
+
+	handle, err := os.Create(shareDest)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	err = r.shareFiles(files, handle, stripContractIDs)
+	if err != nil {
+		os.Remove(shareDest)
+		return err
+	}
+
+	return nil
+}
+
+// ShareFilesASCII returns the specified files in ASCII format. If
+// stripContractIDs is true, the renter's contract IDs are not included.
+func (r *Renter) ShareFilesASCII(nicknames []string, stripContractIDs bool) (string, error) {
+	lockID := r.mu.RLock()
+	defer r.mu.RUnlock(lockID)
+
+	// Load files from renter.
+	files, err := r.filesByNames(nicknames)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	enc := base64.NewEncoder(base64.URLEncoding, buf)
+	err = r.shareFiles(files, enc, stripContractIDs)
+	if err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// readSharedFiles reads the files contained in the .sia data from reader.
+// Legacy v0.4 share files are read as well.
+func (r *Renter) readSharedFiles(reader io.Reader) ([]*file, error) {
+	// Read header.
+	var header [15]byte
+	var version string
+	err := encoding.NewDecoder(reader).DecodeAll(
+		&header,
+		&version,
+	)
+	if err != nil {
+		return nil, err
+	} else if header != shareHeader {
+		return nil, ErrBadFile
+	} else if version == shareVersion040 {
+		return readSharedFiles040(reader)
+	} else if !compatibleShareVersion(version) {
+		return nil, ErrIncompatible
+	}
+
+	// Decode the files.
+	unzip, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	var sharedFiles []sharedFile
+	if err := json.NewDecoder(unzip).Decode(&sharedFiles); err != nil {
+		return nil, err
+	}
+	files := make([]*file, len(sharedFiles))
+	packs, blocks := r.packsByMasterKey(), r.dedupBlocksByName()
+	for i, sf := range sharedFiles {
+		files[i], err = r.fileFromSharedFile(sf, packs, blocks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// loadSharedFiles reads .sia data from reader and registers the contained
+// files in the renter. It returns the nicknames of the loaded files.
+func (r *Renter) loadSharedFiles(reader io.Reader) ([]string, error) {
+	files, err := r.readSharedFiles(reader)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := validateSiapath(f.name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Make sure the files' names do not conflict with existing files.
+	for _, f := range files {
+		dupCount := 0
+		origName := f.name
+		for {
+			_, exists := r.files[f.name]
+			if !exists {
+				break
+			}
+			dupCount++
+			f.name = origName + "_" + strconv.Itoa(dupCount)
+		}
+	}
+
+	// Add files to renter.
+	names := make([]string, len(files))
+	for i, f := range files {
+		r.files[f.name] = f
+		r.addFileToDirs(f)
+		names[i] = f.name
+	}
+	// Save the files.
+	for _, f := range files {
+		if err := r.addPackedFile(f, false); err != nil {
+			r.log.Println("ERROR: could not save pack of loaded file:", err)
+		}
+		if err := r.addDedupFile(f); err != nil {
+			r.log.Println("ERROR: could not save blocks of loaded file:", err)
+		}
+		if err := r.saveFile(f); err != nil {
+			r.log.Println("ERROR: could not save loaded file:", err)
+		}
+	}
+
+	return names, nil
+}
+
+// LoadSharedFiles loads a .sia file into the renter. It returns the nicknames
+// of the loaded files.
+func (r *Renter) LoadSharedFiles(filename string) ([]string, error) {
+	lockID := r.mu.Lock()
+	defer r.mu.Unlock(lockID)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return r.loadSharedFiles(file)
+}
+
+// LoadSharedFilesASCII loads an ASCII-encoded .sia file into the renter. It
+// returns the nicknames of the loaded files.
+func (r *Renter) LoadSharedFilesASCII(asciiSia string) ([]string, error) {
+	lockID := r.mu.Lock()
+	defer r.mu.Unlock(lockID)
+
+	dec := base64.NewDecoder(base64.URLEncoding, bytes.NewBufferString(asciiSia))
+	return r.loadSharedFiles(dec)
+}
@@ -0,0 +1,225 @@
+package renter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// shareContractor is a hostContractor that reports a fixed set of contracts.
+type shareContractor struct {
+	hostContractor
+	contracts []modules.RenterContract
+}
+
+func (sc shareContractor) Contracts() []modules.RenterContract { return sc.contracts }
+func (sc shareContractor) ContractByID(id types.FileContractID) (modules.RenterContract, bool) {
+	for _, c := range sc.contracts {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return modules.RenterContract{}, false
+}
+
+// newShareContract returns a contract with a random ID and the provided host.
+func newShareContract(host types.SiaPublicKey) modules.RenterContract {
+	var id types.FileContractID
+	fastrand.Read(id[:])
+	return modules.RenterContract{ID: id, HostPublicKey: host}
+}
+
+// TestFileShareStripContractIDs checks that the pieces of a shared file are
+// assigned to the contracts of the loading renter, and that the contract IDs
+// of the sharing renter are only used if they weren't stripped.
+func TestFileShareStripContractIDs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// Create a file with pieces on three contracts. The host of the last
+	// contract is unknown.
+	f := newTestingFileWithPieces(2, 3)
+	var ids []types.FileContractID
+	for id := range f.contracts {
+		ids = append(ids, id)
+	}
+	hostA := types.SiaPublicKey{Algorithm: types.SignatureEd25519, Key: fastrand.Bytes(32)}
+	hostB := types.SiaPublicKey{Algorithm: types.SignatureEd25519, Key: fastrand.Bytes(32)}
+	sharer := shareContractor{hostContractor: r.hostContractor}
+	for i, host := range []types.SiaPublicKey{hostA, hostB} {
+		c := newShareContract(host)
+		c.ID = ids[i]
+		sharer.contracts = append(sharer.contracts, c)
+	}
+	id := r.mu.Lock()
+	r.files[f.name] = f
+	r.hostContractor = sharer
+	r.mu.Unlock(id)
+
+	stripped, err := r.ShareFilesASCII([]string{f.name}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := r.ShareFilesASCII([]string{f.name}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the files into a renter that only has a contract with host A.
+	ownContract := newShareContract(hostA)
+	id = r.mu.Lock()
+	r.hostContractor = shareContractor{
+		hostContractor: sharer.hostContractor,
+		contracts:      []modules.RenterContract{ownContract},
+	}
+	delete(r.files, f.name)
+	r.mu.Unlock(id)
+
+	names, err := r.LoadSharedFilesASCII(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := r.files[names[0]]
+	if err := equalFiles(f, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.contracts) != 1 {
+		t.Fatal("expected 1 contract, got", len(loaded.contracts))
+	}
+	if len(loaded.contracts[ownContract.ID].Pieces) != len(f.contracts[ids[0]].Pieces) {
+		t.Fatal("pieces of host A weren't assigned to the renter's contract")
+	}
+
+	// Without stripping, the pieces on the other hosts keep their IDs.
+	names, err = r.LoadSharedFilesASCII(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded = r.files[names[0]]
+	if len(loaded.contracts) != 3 {
+		t.Fatal("expected 3 contracts, got", len(loaded.contracts))
+	}
+	for _, cid := range []types.FileContractID{ownContract.ID, ids[1], ids[2]} {
+		if _, exists := loaded.contracts[cid]; !exists {
+			t.Fatal("missing contract", cid)
+		}
+	}
+}
+
+// TestFileShareVersion checks that share files with a newer minor version are
+// loaded, and that share files with a newer major version are rejected.
+func TestFileShareVersion(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+
+	// shareASCII creates an ASCII share file with the provided version and
+	// JSON encoded files.
+	shareASCII := func(version, data string) string {
+		buf := new(bytes.Buffer)
+		enc := base64.NewEncoder(base64.URLEncoding, buf)
+		encoding.NewEncoder(enc).EncodeAll(shareHeader, version)
+		zip := gzip.NewWriter(enc)
+		zip.Write([]byte(data))
+		zip.Close()
+		enc.Close()
+		return buf.String()
+	}
+	data := `[{"siapath":"foo","size":10,"piecesize":100,"newfield":true,
+		"erasurecode":{"type":"Reed-Solomon","datapieces":1,"paritypieces":2}}]`
+
+	names, err := rt.renter.LoadSharedFilesASCII(shareASCII("1.9", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "foo" {
+		t.Fatal("file not loaded properly:", names)
+	}
+	if ec := rt.renter.files["foo"].erasureCode; ec.MinPieces() != 1 || ec.NumPieces() != 3 {
+		t.Fatal("erasure code not loaded properly")
+	}
+
+	_, err = rt.renter.LoadSharedFilesASCII(shareASCII("2.0", data))
+	if err != ErrIncompatible {
+		t.Fatal("expected ErrIncompatible, got", err)
+	}
+}
+
+// TestFileShareSize checks that shared files are rejected if their size
+// implies chunks that none of their pieces belong to.
+func TestFileShareSize(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// shareASCII returns an ASCII share file of a file with the provided
+	// size and a piece on each of the provided chunks.
+	shareASCII := func(size uint64, chunks ...uint64) string {
+		sc := sharedContract{ID: &types.FileContractID{1}}
+		for _, chunk := range chunks {
+			sc.Pieces = append(sc.Pieces, pieceData{Chunk: chunk})
+		}
+		sf := sharedFile{
+			SiaPath:   fmt.Sprint("file", size),
+			Size:      size,
+			PieceSize: 100,
+			ErasureCode: sharedErasureCode{
+				Type:         erasureCodeReedSolomon,
+				DataPieces:   1,
+				ParityPieces: 1,
+			},
+			Contracts: []sharedContract{sc},
+		}
+		buf := new(bytes.Buffer)
+		enc := base64.NewEncoder(base64.URLEncoding, buf)
+		if err := writeSharedFiles([]sharedFile{sf}, enc); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// A file whose pieces cover its chunks is loaded.
+	if _, err := r.LoadSharedFilesASCII(shareASCII(250, 0, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file with more chunks than its pieces cover is rejected, also if a
+	// piece belongs to its last chunk.
+	if _, err := r.LoadSharedFilesASCII(shareASCII(250, 0, 2)); err != errShareUncoveredChunks {
+		t.Fatal("expected errShareUncoveredChunks, got", err)
+	}
+	if _, err := r.LoadSharedFilesASCII(shareASCII(1<<62, 0, 1<<62/100-1)); err != errShareUncoveredChunks {
+		t.Fatal("expected errShareUncoveredChunks, got", err)
+	}
+	if len(r.files) != 1 {
+		t.Fatal("expected 1 file, got", len(r.files))
+	}
+}
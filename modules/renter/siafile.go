@@ -0,0 +1,760 @@
+package renter
+
+// siafile.go contains the on-disk format of the renter's file metadata. Every
+// file is stored in its own metadata file, named after its siapath. The
+// metadata file starts with a header, which contains the static fields of the
+// file. The header is followed by the table of contracts that the file's
+// pieces are stored on, and the contract table is followed by the piece tables
+// of the file's chunks. The header, the contract table and every piece table
+// occupy a fixed number of pages, so that recording a newly uploaded piece only
+// requires rewriting the piece table of a single chunk, and the contract table
+// if the piece was stored on a new contract. Besides the pieces of a chunk, its
+// piece table contains the flags of the chunk and the checksums of its data, so
+// the size of the header doesn't grow with the number of chunks.
+//
+// All writes to the metadata files go through the renter's write-ahead log,
+// which guarantees that an update is either applied completely or not at all,
+// even if the renter crashes while writing.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+	"github.com/NebulousLabs/writeaheadlog"
+)
+
+const (
+	// pageSize is the granularity of a file's on-disk metadata. The header,
+	// the contract table and the piece table of every chunk are padded to a
+	// multiple of pageSize.
+	pageSize = 4096
+
+	// pieceTablePrefixSize is the size of the prefix of a piece table, which
+	// contains the number of pieces in the table, the flags of the chunk, the
+	// checksum of the chunk's data and the number of segment checksums. The
+	// prefix is followed by the segment checksums and then by the pieces.
+	pieceTablePrefixSize = 4 + 1 + crypto.HashSize + 4
+
+	// chunkFlagStuck is set in the flags of a stuck chunk.
+	chunkFlagStuck = 1 << 0
+
+	// marshaledPieceSize is the size of a single entry in a piece table. An
+	// entry consists of the offset of the piece's contract in the contract
+	// table, the index of the piece within its chunk and the Merkle root of
+	// the piece.
+	marshaledPieceSize = 4 + 4 + crypto.HashSize
+
+	// reservedContractSize is the space that is reserved in the contract
+	// table for every contract that might be added to the file. Contracts
+	// whose encoding is larger than reservedContractSize still fit into the
+	// table, as long as the table's reserved space isn't exhausted.
+	reservedContractSize = 128
+
+	// updateNameInsert is the name of a WAL update that writes data to a
+	// metadata file at a given offset.
+	updateNameInsert = "RenterFileInsert"
+
+	// updateNameDelete is the name of a WAL update that deletes a metadata
+	// file.
+	updateNameDelete = "RenterFileDelete"
+
+	// walFile is the name of the renter's write-ahead log.
+	walFile = modules.RenterDir + ".wal"
+)
+
+var (
+	// errCorruptPieceTable is returned when the piece table of a chunk can't
+	// be decoded.
+	errCorruptPieceTable = errors.New("piece table of chunk is corrupt")
+
+	// fileMetadataHeader is the header of a file's on-disk metadata.
+	fileMetadataHeader = persist.Metadata{
+		Header:  "Sia Renter File",
+		Version: "1.0",
+	}
+)
+
+type (
+	// fileHeader is the header of a file's on-disk metadata.
+	fileHeader struct {
+		// HeaderPages is the number of pages reserved for the header,
+		// ContractPages is the number of pages reserved for the contract
+		// table and ChunkPages is the number of pages reserved for the piece
+		// table of each chunk.
+		HeaderPages   uint64
+		ContractPages uint64
+		ChunkPages    uint64
+
+		Name         string
+		Size         uint64
+		MasterKey    crypto.TwofishKey
+		PieceSize    uint64
+		Mode         uint32
+		ErasureCode  string
+		DataPieces   uint64
+		ParityPieces uint64
+
+		// Pack is the name of the pack that stores the data of a packed file
+		// and PackOffset is the offset of the data within the pack. Pack is
+		// empty if the file is not packed.
+		Pack       string
+		PackOffset uint64
+
+		// Priority is the upload priority of the file and Paused is set if
+		// the upload of the file is paused.
+		Priority uint64
+		Paused   bool
+
+		// Blocks contains the IDs of the blocks that store the chunks of a
+		// deduplicated file, in the order of the chunks. Blocks is empty if
+		// the file is not deduplicated.
+		Blocks []crypto.Hash
+
+		// Checksum is the checksum of the file's data. The checksums of the
+		// chunks are stored in their piece tables.
+		Checksum crypto.Hash
+
+		// Metadata contains the entries of the application metadata of the
+		// file, sorted by key.
+		Metadata []fileHeaderMetadata
+
+		// Compression is the codec of a compressed file, FrameSize and
+		// FrameOffsets describe its compressed frames and UncompressedSize
+		// is the size of its data. Compression is empty if the file is not
+		// compressed.
+		Compression      string
+		FrameSize        uint64
+		FrameOffsets     []uint64
+		UncompressedSize uint64
+
+		// ModTime is the modification time of the source of the upload in
+		// nanoseconds since the Unix epoch, or zero if it is unknown.
+		ModTime int64
+
+		// ChunkKeys contains the keys of the chunks of a concatenated file.
+		// ChunkKeys is empty if the keys of the chunks are derived from
+		// MasterKey.
+		ChunkKeys []chunkKey
+	}
+
+	// fileHeaderMetadata is an entry of a file's application metadata.
+	fileHeaderMetadata struct {
+		Key   string
+		Value string
+	}
+
+	// fileHeaderContract is an entry of a file's contract table.
+	fileHeaderContract struct {
+		ID          types.FileContractID
+		IP          modules.NetAddress
+		WindowStart types.BlockHeight
+	}
+
+	// updateInsert is the instruction of an updateNameInsert update. Path is
+	// relative to the renter's persist directory.
+	updateInsert struct {
+		Path   string
+		Offset int64
+		Data   []byte
+	}
+
+	// updateDelete is the instruction of an updateNameDelete update. Path is
+	// relative to the renter's persist directory.
+	updateDelete struct {
+		Path string
+	}
+)
+
+// numPages returns the number of pages needed to store n bytes. At least one
+// page is always used.
+func numPages(n int) uint64 {
+	if n == 0 {
+		return 1
+	}
+	return uint64((n + pageSize - 1) / pageSize)
+}
+
+// metadataPath returns the path of a file's metadata, relative to the
+// renter's persist directory.
+func metadataPath(siaPath string) string {
+	return siaPath + ShareExtension
+}
+
+// contractTableOffset returns the offset of the contract table within the
+// file's metadata.
+func (f *file) contractTableOffset() int64 {
+	return int64(f.headerPages * pageSize)
+}
+
+// chunkOffset returns the offset of the piece table of a chunk within the
+// file's metadata.
+func (f *file) chunkOffset(chunkIndex uint64) int64 {
+	return int64((f.headerPages + f.contractPages + chunkIndex*f.chunkPages) * pageSize)
+}
+
+// updateContractTable brings the contract table of the file in line with the
+// file's contracts. New contracts are appended to the table and contracts that
+// are no longer part of the file are dropped from it. updateContractTable
+// returns whether the table changed, and whether existing offsets were
+// invalidated in the process.
+func (f *file) updateContractTable() (changed, invalidated bool) {
+	table := make([]types.FileContractID, 0, len(f.contracts))
+	inTable := make(map[types.FileContractID]struct{})
+	for _, id := range f.contractTable {
+		if _, exists := f.contracts[id]; !exists {
+			invalidated = true
+			continue
+		}
+		table = append(table, id)
+		inTable[id] = struct{}{}
+	}
+	// Append the new contracts in a deterministic order.
+	var newIDs []types.FileContractID
+	for id := range f.contracts {
+		if _, exists := inTable[id]; !exists {
+			newIDs = append(newIDs, id)
+		}
+	}
+	sort.Slice(newIDs, func(i, j int) bool {
+		return bytes.Compare(newIDs[i][:], newIDs[j][:]) < 0
+	})
+	table = append(table, newIDs...)
+	f.contractTable = table
+	return invalidated || len(newIDs) > 0, invalidated
+}
+
+// marshalHeader returns the encoded header of the file, using the provided
+// page counts. The length of the header does not depend on the page counts.
+func (f *file) marshalHeader(headerPages, contractPages, chunkPages uint64) ([]byte, error) {
+	rsc, ok := f.erasureCode.(*rsCode)
+	if !ok {
+		if build.DEBUG {
+			panic("unknown erasure code")
+		}
+		return nil, errors.New("unknown erasure code")
+	}
+	h := fileHeader{
+		HeaderPages:   headerPages,
+		ContractPages: contractPages,
+		ChunkPages:    chunkPages,
+		Name:          f.name,
+		Size:          f.size,
+		MasterKey:     f.masterKey,
+		PieceSize:     f.pieceSize,
+		Mode:          f.mode,
+		ErasureCode:   "Reed-Solomon",
+		DataPieces:    uint64(rsc.dataPieces),
+		ParityPieces:  uint64(rsc.numPieces - rsc.dataPieces),
+		Priority:      f.priority,
+		Paused:        f.paused,
+
+		Checksum:  f.checksum,
+		ChunkKeys: f.chunkKeys,
+	}
+	if f.pack != nil {
+		h.Pack = f.pack.name
+		h.PackOffset = f.packOffset
+	}
+	for _, block := range f.blocks {
+		h.Blocks = append(h.Blocks, dedupBlockID(block.masterKey))
+	}
+	if c := f.compression; c != nil {
+		h.Compression = c.codec
+		h.FrameSize = c.frameSize
+		h.FrameOffsets = c.frameOffsets
+		h.UncompressedSize = c.dataSize
+	}
+	if !f.modTime.IsZero() {
+		h.ModTime = f.modTime.UnixNano()
+	}
+	for key, value := range f.metadata {
+		h.Metadata = append(h.Metadata, fileHeaderMetadata{
+			Key:   key,
+			Value: value,
+		})
+	}
+	sort.Slice(h.Metadata, func(i, j int) bool {
+		return h.Metadata[i].Key < h.Metadata[j].Key
+	})
+	return encoding.MarshalAll(fileMetadataHeader, h), nil
+}
+
+// marshalContractTable returns the encoded contract table of the file. The
+// contract table needs to be up to date.
+func (f *file) marshalContractTable() []byte {
+	contracts := make([]fileHeaderContract, 0, len(f.contractTable))
+	for _, id := range f.contractTable {
+		fc := f.contracts[id]
+		contracts = append(contracts, fileHeaderContract{
+			ID:          fc.ID,
+			IP:          fc.IP,
+			WindowStart: fc.WindowStart,
+		})
+	}
+	return encoding.Marshal(contracts)
+}
+
+// marshalPieceTables returns the encoded piece tables of all chunks of the
+// file. The contract table needs to be up to date.
+func (f *file) marshalPieceTables() [][]byte {
+	tables := make([][]byte, f.numChunks())
+	for i := range tables {
+		tables[i] = f.newPieceTable(uint64(i))
+	}
+	for offset, id := range f.contractTable {
+		for _, p := range f.contracts[id].Pieces {
+			if p.Chunk >= uint64(len(tables)) {
+				continue
+			}
+			tables[p.Chunk] = appendPiece(tables[p.Chunk], offset, p)
+		}
+	}
+	for i := range tables {
+		setPieceCount(tables[i])
+	}
+	return tables
+}
+
+// marshalPieceTable returns the encoded piece table of a single chunk of the
+// file. The contract table needs to be up to date.
+func (f *file) marshalPieceTable(chunkIndex uint64) []byte {
+	table := f.newPieceTable(chunkIndex)
+	for offset, id := range f.contractTable {
+		for _, p := range f.contracts[id].Pieces {
+			if p.Chunk == chunkIndex {
+				table = appendPiece(table, offset, p)
+			}
+		}
+	}
+	setPieceCount(table)
+	return table
+}
+
+// newPieceTable returns a piece table without pieces for the chunk at
+// chunkIndex, which contains the flags and the checksums of the chunk.
+func (f *file) newPieceTable(chunkIndex uint64) []byte {
+	segments := f.chunkSegmentChecksums(chunkIndex)
+	table := make([]byte, pieceTablePrefixSize, pieceTablePrefixSize+len(segments)*crypto.HashSize)
+	if f.isStuck(chunkIndex) {
+		table[4] |= chunkFlagStuck
+	}
+	checksum := f.chunkChecksum(chunkIndex)
+	copy(table[5:], checksum[:])
+	binary.LittleEndian.PutUint32(table[5+crypto.HashSize:], uint32(len(segments)))
+	for _, segment := range segments {
+		table = append(table, segment[:]...)
+	}
+	return table
+}
+
+// piecesOffset returns the offset of the first piece within a piece table.
+func piecesOffset(table []byte) int {
+	numSegments := int(binary.LittleEndian.Uint32(table[5+crypto.HashSize:]))
+	return pieceTablePrefixSize + numSegments*crypto.HashSize
+}
+
+// isEmptyPieceTable returns true if the piece table consists of zeros only,
+// which is how a piece table that was never written reads.
+func isEmptyPieceTable(table []byte) bool {
+	for _, b := range table {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// appendPiece appends the encoded piece to a piece table.
+func appendPiece(table []byte, contractOffset int, p pieceData) []byte {
+	var entry [marshaledPieceSize]byte
+	binary.LittleEndian.PutUint32(entry[:4], uint32(contractOffset))
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(p.Piece))
+	copy(entry[8:], p.MerkleRoot[:])
+	return append(table, entry[:]...)
+}
+
+// setPieceCount writes the number of pieces in a piece table to its prefix.
+func setPieceCount(table []byte) {
+	n := (len(table) - piecesOffset(table)) / marshaledPieceSize
+	binary.LittleEndian.PutUint32(table[:4], uint32(n))
+}
+
+// makeUpdateInsert creates a WAL update that writes data to the metadata of
+// the file at siaPath.
+func makeUpdateInsert(siaPath string, offset int64, data []byte) writeaheadlog.Update {
+	return writeaheadlog.Update{
+		Name: updateNameInsert,
+		Instructions: encoding.Marshal(updateInsert{
+			Path:   metadataPath(siaPath),
+			Offset: offset,
+			Data:   data,
+		}),
+	}
+}
+
+// makeUpdateDelete creates a WAL update that deletes the metadata of the file
+// at siaPath.
+func makeUpdateDelete(siaPath string) writeaheadlog.Update {
+	return writeaheadlog.Update{
+		Name: updateNameDelete,
+		Instructions: encoding.Marshal(updateDelete{
+			Path: metadataPath(siaPath),
+		}),
+	}
+}
+
+// saveFile saves the complete metadata of a file to the renter directory,
+// replacing any metadata that was previously saved for the file.
+func (r *Renter) saveFile(f *file) error {
+	if f.deleted {
+		return errors.New("can't save deleted file")
+	}
+	f.updateContractTable()
+	header, err := f.marshalHeader(0, 0, 0)
+	if err != nil {
+		return err
+	}
+	contractTable := f.marshalContractTable()
+	tables := f.marshalPieceTables()
+
+	// Reserve enough pages for the header, the contract table and the largest
+	// piece table. The contract table gets room for another full set of
+	// contracts and every chunk gets room for the checksums of a full chunk
+	// and at least one full set of pieces, so that recording a checksum or
+	// repairing a chunk usually doesn't require the layout to change.
+	headerPages := numPages(len(header))
+	contractPages := numPages(len(contractTable) + f.erasureCode.NumPieces()*reservedContractSize)
+	chunkPages := numPages(pieceTablePrefixSize + int(numSegments(f.staticChunkSize(), f.pieceSize))*crypto.HashSize + f.erasureCode.NumPieces()*marshaledPieceSize)
+	for _, table := range tables {
+		if n := numPages(len(table)); n > chunkPages {
+			chunkPages = n
+		}
+	}
+	header, err = f.marshalHeader(headerPages, contractPages, chunkPages)
+	if err != nil {
+		return err
+	}
+
+	// Replace the existing metadata. Empty piece tables don't need to be
+	// written, an empty page reads as an empty piece table.
+	oldHeaderPages, oldContractPages, oldChunkPages := f.headerPages, f.contractPages, f.chunkPages
+	f.headerPages, f.contractPages, f.chunkPages = headerPages, contractPages, chunkPages
+	updates := []writeaheadlog.Update{
+		makeUpdateDelete(f.name),
+		makeUpdateInsert(f.name, 0, header),
+		makeUpdateInsert(f.name, f.contractTableOffset(), contractTable),
+	}
+	for i, table := range tables {
+		if !isEmptyPieceTable(table) {
+			updates = append(updates, makeUpdateInsert(f.name, f.chunkOffset(uint64(i)), table))
+		}
+	}
+	if err := r.createAndApplyTransaction(updates...); err != nil {
+		f.headerPages, f.contractPages, f.chunkPages = oldHeaderPages, oldContractPages, oldChunkPages
+		return err
+	}
+	return nil
+}
+
+// chunkUpdates returns the WAL updates that save the piece table of a single
+// chunk of a file, together with the file's contract table if contracts were
+// added to the file. chunkUpdates returns false if the layout of the file's
+// metadata has to change, in which case the complete file needs to be saved.
+func (f *file) chunkUpdates(chunkIndex uint64) ([]writeaheadlog.Update, bool) {
+	if f.headerPages == 0 {
+		return nil, false
+	}
+	changed, invalidated := f.updateContractTable()
+	if invalidated {
+		return nil, false
+	}
+	table := f.marshalPieceTable(chunkIndex)
+	if numPages(len(table)) > f.chunkPages {
+		return nil, false
+	}
+	var updates []writeaheadlog.Update
+	if changed {
+		contractTable := f.marshalContractTable()
+		if numPages(len(contractTable)) > f.contractPages {
+			return nil, false
+		}
+		updates = append(updates, makeUpdateInsert(f.name, f.contractTableOffset(), contractTable))
+	}
+	updates = append(updates, makeUpdateInsert(f.name, f.chunkOffset(chunkIndex), table))
+	return updates, true
+}
+
+// saveChunk saves the piece table of a single chunk of a file, together with
+// the file's contract table if contracts were added to the file. If the layout
+// of the file's metadata has to change, the complete file is saved instead.
+func (r *Renter) saveChunk(f *file, chunkIndex uint64) error {
+	if f.deleted {
+		return errors.New("can't save deleted file")
+	}
+	updates, ok := f.chunkUpdates(chunkIndex)
+	if !ok {
+		return r.saveFile(f)
+	}
+	return r.createAndApplyTransaction(updates...)
+}
+
+// createAndApplyTransaction records the updates in the write-ahead log,
+// applies them and marks them as applied.
+func (r *Renter) createAndApplyTransaction(updates ...writeaheadlog.Update) error {
+	txn, err := r.wal.NewTransaction(updates)
+	if err != nil {
+		return err
+	}
+	if err := <-txn.SignalSetupComplete(); err != nil {
+		return err
+	}
+	if err := r.applyUpdates(updates...); err != nil {
+		return err
+	}
+	return txn.SignalUpdatesApplied()
+}
+
+// applyUpdates applies WAL updates to the metadata files in the renter
+// directory. Applying the same updates multiple times has the same effect as
+// applying them once.
+func (r *Renter) applyUpdates(updates ...writeaheadlog.Update) (err error) {
+	// Keep the files open until all updates are applied, so every file only
+	// needs to be synced once.
+	handles := make(map[string]*os.File)
+	defer func() {
+		for _, h := range handles {
+			if closeErr := h.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}()
+
+	for _, update := range updates {
+		switch update.Name {
+		case updateNameInsert:
+			var u updateInsert
+			if err := encoding.Unmarshal(update.Instructions, &u); err != nil {
+				return err
+			}
+			path := filepath.Join(r.persistDir, u.Path)
+			h, exists := handles[path]
+			if !exists {
+				if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+					return err
+				}
+				h, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+				if err != nil {
+					return err
+				}
+				handles[path] = h
+			}
+			if _, err := h.WriteAt(u.Data, u.Offset); err != nil {
+				return err
+			}
+		case updateNameDelete:
+			var u updateDelete
+			if err := encoding.Unmarshal(update.Instructions, &u); err != nil {
+				return err
+			}
+			path := filepath.Join(r.persistDir, u.Path)
+			if h, exists := handles[path]; exists {
+				delete(handles, path)
+				if err := h.Close(); err != nil {
+					return err
+				}
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		default:
+			return errors.New("unknown renter WAL update: " + update.Name)
+		}
+	}
+	for _, h := range handles {
+		if err := h.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFile loads a file from its on-disk metadata. The pack of a packed file
+// is looked up in packs, the blocks of a deduplicated file in blocks.
+func loadFile(path string, packs, blocks map[string]*file) (*file, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	// Decode the header.
+	var meta persist.Metadata
+	var h fileHeader
+	if err := encoding.NewDecoder(fh).DecodeAll(&meta, &h); err != nil {
+		return nil, err
+	}
+	if meta.Header != fileMetadataHeader.Header {
+		return nil, ErrBadFile
+	} else if meta.Version != fileMetadataHeader.Version {
+		return nil, ErrIncompatible
+	} else if h.ErasureCode != "Reed-Solomon" {
+		return nil, errors.New("unrecognized erasure code type: " + h.ErasureCode)
+	} else if h.HeaderPages == 0 || h.ContractPages == 0 || h.ChunkPages == 0 {
+		return nil, errors.New("invalid page counts in file header")
+	}
+	rsc, err := NewRSCode(int(h.DataPieces), int(h.ParityPieces))
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		name:        h.Name,
+		size:        h.Size,
+		contracts:   make(map[types.FileContractID]fileContract),
+		masterKey:   h.MasterKey,
+		erasureCode: rsc,
+		pieceSize:   h.PieceSize,
+		mode:        h.Mode,
+		priority:    h.Priority,
+		paused:      h.Paused,
+		checksum:    h.Checksum,
+		staticUID:   persist.RandomSuffix(),
+
+		headerPages:   h.HeaderPages,
+		contractPages: h.ContractPages,
+		chunkPages:    h.ChunkPages,
+	}
+	if h.Pack != "" {
+		pack, exists := packs[h.Pack]
+		if !exists {
+			return nil, errMissingPack
+		}
+		f.pack = pack
+		f.packOffset = h.PackOffset
+	}
+	if len(h.Blocks) > 0 {
+		if uint64(len(h.Blocks)) != f.numChunks() {
+			return nil, errors.New("number of blocks doesn't match the number of chunks")
+		}
+		f.blocks = make([]*file, len(h.Blocks))
+		for i, id := range h.Blocks {
+			block, exists := blocks[dedupBlockName(id)]
+			if !exists {
+				return nil, errMissingDedupBlock
+			}
+			f.blocks[i] = block
+		}
+	}
+	if len(h.ChunkKeys) > 0 {
+		if uint64(len(h.ChunkKeys)) != f.numChunks() {
+			return nil, errors.New("number of chunk keys doesn't match the number of chunks")
+		}
+		f.chunkKeys = h.ChunkKeys
+	}
+	if len(h.Metadata) > 0 {
+		f.metadata = make(map[string]string, len(h.Metadata))
+		for _, entry := range h.Metadata {
+			f.metadata[entry.Key] = entry.Value
+		}
+	}
+	if h.ModTime != 0 {
+		f.modTime = time.Unix(0, h.ModTime)
+	}
+	if h.Compression != "" {
+		f.compression = &fileCompression{
+			codec:        h.Compression,
+			frameSize:    h.FrameSize,
+			frameOffsets: h.FrameOffsets,
+			dataSize:     h.UncompressedSize,
+		}
+		if err := f.compression.validate(f.size); err != nil {
+			return nil, err
+		}
+	}
+	// Decode the contract table.
+	var contracts []fileHeaderContract
+	buf := make([]byte, f.contractPages*pageSize)
+	if _, err := fh.ReadAt(buf, f.contractTableOffset()); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := encoding.Unmarshal(buf, &contracts); err != nil {
+		return nil, err
+	}
+	for _, c := range contracts {
+		f.contracts[c.ID] = fileContract{
+			ID:          c.ID,
+			IP:          c.IP,
+			WindowStart: c.WindowStart,
+		}
+		f.contractTable = append(f.contractTable, c.ID)
+	}
+	// Decode the piece tables. A piece table that lies beyond the end of the
+	// metadata file is empty.
+	buf = make([]byte, f.chunkPages*pageSize)
+	for i := uint64(0); i < f.numChunks(); i++ {
+		n, err := fh.ReadAt(buf, f.chunkOffset(i))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if n < pieceTablePrefixSize {
+			continue
+		}
+		if buf[4]&chunkFlagStuck != 0 {
+			f.setStuck(i, true)
+		}
+		numPieces := int(binary.LittleEndian.Uint32(buf[:4]))
+		start := piecesOffset(buf)
+		if start+numPieces*marshaledPieceSize > n {
+			return nil, errCorruptPieceTable
+		}
+
+		// Decode the checksums of the chunk. The checksums of a file are only
+		// allocated if at least one of them is known.
+		var checksum crypto.Hash
+		copy(checksum[:], buf[5:])
+		if checksum != (crypto.Hash{}) {
+			if f.chunkChecksums == nil {
+				f.chunkChecksums = make([]crypto.Hash, f.numChunks())
+			}
+			f.chunkChecksums[i] = checksum
+		}
+		if start > pieceTablePrefixSize {
+			if f.segmentChecksums == nil {
+				f.segmentChecksums = make([][]crypto.Hash, f.numChunks())
+			}
+			segments := make([]crypto.Hash, (start-pieceTablePrefixSize)/crypto.HashSize)
+			for j := range segments {
+				copy(segments[j][:], buf[pieceTablePrefixSize+j*crypto.HashSize:])
+			}
+			f.segmentChecksums[i] = segments
+		}
+
+		for j := 0; j < numPieces; j++ {
+			entry := buf[start+j*marshaledPieceSize:][:marshaledPieceSize]
+			offset := binary.LittleEndian.Uint32(entry[:4])
+			if int(offset) >= len(f.contractTable) {
+				return nil, errCorruptPieceTable
+			}
+			p := pieceData{
+				Chunk: i,
+				Piece: uint64(binary.LittleEndian.Uint32(entry[4:8])),
+			}
+			copy(p.MerkleRoot[:], entry[8:])
+			fc := f.contracts[f.contractTable[offset]]
+			fc.Pieces = append(fc.Pieces, p)
+			f.contracts[fc.ID] = fc
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,315 @@
+package renter
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/Sia/types"
+	"github.com/NebulousLabs/fastrand"
+	"github.com/NebulousLabs/writeaheadlog"
+)
+
+// newTestingFileWithPieces creates a file with numChunks chunks, which has a
+// piece of every chunk stored on each of numContracts contracts. The erasure
+// code of the file has enough pieces for every contract.
+func newTestingFileWithPieces(numChunks, numContracts int) *file {
+	f := newTestingFile()
+	f.erasureCode, _ = NewRSCode(2, numContracts+1)
+	f.pieceSize = 100
+	f.size = f.staticChunkSize()*uint64(numChunks) - 1
+	f.contracts = make(map[types.FileContractID]fileContract)
+	for i := 0; i < numContracts; i++ {
+		fc := fileContract{
+			ID:          types.FileContractID(crypto.HashObject(fastrand.Bytes(16))),
+			IP:          modules.NetAddress("127.0.0.1:9982"),
+			WindowStart: types.BlockHeight(i),
+		}
+		for chunk := 0; chunk < numChunks; chunk++ {
+			fc.Pieces = append(fc.Pieces, pieceData{
+				Chunk:      uint64(chunk),
+				Piece:      uint64(i),
+				MerkleRoot: crypto.HashObject(fastrand.Bytes(16)),
+			})
+		}
+		f.contracts[fc.ID] = fc
+	}
+	return f
+}
+
+// shareFiles040 writes the specified files to w in the legacy v0.4 share
+// format.
+func shareFiles040(files []*file, w io.Writer) error {
+	err := encoding.NewEncoder(w).EncodeAll(
+		shareHeader,
+		shareVersion040,
+		uint64(len(files)),
+	)
+	if err != nil {
+		return err
+	}
+	zip, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	enc := encoding.NewEncoder(zip)
+	for _, f := range files {
+		if err := enc.Encode(f); err != nil {
+			return err
+		}
+	}
+	return zip.Close()
+}
+
+// checkFileMetadata loads the metadata of f from disk and compares it to f.
+func checkFileMetadata(r *Renter, f *file) error {
+	loaded, err := loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := equalFiles(f, loaded); err != nil {
+		return err
+	}
+	if !loaded.modTime.Equal(f.modTime) {
+		return errors.New("modification time of loaded file doesn't match")
+	}
+	if len(f.contracts) == 0 && len(loaded.contracts) == 0 {
+		return nil
+	}
+	if !reflect.DeepEqual(f.contracts, loaded.contracts) {
+		return errors.New("contracts of loaded file don't match")
+	}
+	return nil
+}
+
+// TestFileMetadata tests saving and loading the on-disk metadata of a file.
+func TestFileMetadata(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// Save the complete file.
+	f := newTestingFileWithPieces(10, 3)
+	f.modTime = time.Unix(1500000000, 123456789)
+	if err := r.saveFile(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkFileMetadata(r, f); err != nil {
+		t.Fatal(err)
+	}
+	chunkPages := f.chunkPages
+
+	// Add a piece on a new contract and save only the affected chunk.
+	fc := fileContract{ID: types.FileContractID{1}}
+	fc.Pieces = append(fc.Pieces, pieceData{Chunk: 3, Piece: 5})
+	f.contracts[fc.ID] = fc
+	if err := r.saveChunk(f, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkFileMetadata(r, f); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add more pieces to a chunk than fit into its pages. The layout of the
+	// metadata should grow.
+	for i := 0; i < pageSize/marshaledPieceSize; i++ {
+		fc.Pieces = append(fc.Pieces, pieceData{Chunk: 9, Piece: uint64(i)})
+	}
+	f.contracts[fc.ID] = fc
+	if err := r.saveChunk(f, 9); err != nil {
+		t.Fatal(err)
+	}
+	if f.chunkPages <= chunkPages {
+		t.Fatal("chunk pages didn't grow:", f.chunkPages)
+	}
+	if err := checkFileMetadata(r, f); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove a contract, which invalidates the contract table.
+	delete(f.contracts, f.contractTable[0])
+	if err := r.saveChunk(f, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkFileMetadata(r, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSaveChunkSize checks that the amount of data that saveChunk writes
+// doesn't grow with the number of chunks of the file, even if the piece was
+// stored on a new contract and the checksum of the chunk was recorded.
+func TestSaveChunkSize(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	f := newTestingFileWithPieces(5000, 3)
+	if err := r.saveFile(f); err != nil {
+		t.Fatal(err)
+	}
+	fc := fileContract{
+		ID:     types.FileContractID{1},
+		IP:     modules.NetAddress("127.0.0.1:9982"),
+		Pieces: []pieceData{{Chunk: 2500, Piece: 3}},
+	}
+	f.contracts[fc.ID] = fc
+	f.chunkChecksums = make([]crypto.Hash, f.numChunks())
+	f.chunkChecksums[2500] = crypto.HashObject(fastrand.Bytes(16))
+	updates, ok := f.chunkUpdates(2500)
+	if !ok {
+		t.Fatal("adding a contract changed the layout of the metadata")
+	}
+	var written int
+	for _, update := range updates {
+		var u updateInsert
+		if err := encoding.Unmarshal(update.Instructions, &u); err != nil {
+			t.Fatal(err)
+		}
+		if u.Offset < f.contractTableOffset() {
+			t.Fatal("saving a chunk rewrote the header")
+		}
+		written += len(u.Data)
+	}
+	if written > pageSize {
+		t.Fatal("saving a chunk wrote too much data:", written)
+	}
+	if err := r.saveChunk(f, 2500); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkFileMetadata(r, f); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(loaded.chunkChecksums, f.chunkChecksums) {
+		t.Fatal("checksum of chunk wasn't persisted")
+	}
+}
+
+// TestApplyUpdatesIdempotent checks that applying the WAL updates of a file
+// multiple times, as happens when the renter recovers from a crash, has the
+// same result as applying them once.
+func TestApplyUpdatesIdempotent(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	f := newTestingFileWithPieces(5, 2)
+	if err := r.saveFile(f); err != nil {
+		t.Fatal(err)
+	}
+	f.contracts[types.FileContractID{1}] = fileContract{
+		ID:     types.FileContractID{1},
+		Pieces: []pieceData{{Chunk: 2, Piece: 3}},
+	}
+	f.updateContractTable()
+	updates := []writeaheadlog.Update{
+		makeUpdateInsert(f.name, f.contractTableOffset(), f.marshalContractTable()),
+		makeUpdateInsert(f.name, f.chunkOffset(2), f.marshalPieceTable(2)),
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.applyUpdates(updates...); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkFileMetadata(r, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRenterConvertPersist04 checks that the persistence of a v0.4 renter is
+// converted to the paged file metadata.
+func TestRenterConvertPersist04(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	// Write two legacy .sia files. The second one is not stored at the path
+	// that matches its siapath.
+	f1 := newTestingFileWithPieces(3, 2)
+	f1.name = "foo"
+	f2 := newTestingFileWithPieces(2, 1)
+	f2.name = "bar/baz"
+	for path, f := range map[string]*file{"foo.sia": f1, "qux.sia": f2} {
+		handle, err := os.Create(filepath.Join(r.persistDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := shareFiles040([]*file{f}, handle); err != nil {
+			t.Fatal(err)
+		}
+		handle.Close()
+	}
+	legacyMetadata := persist.Metadata{
+		Header:  saveMetadata.Header,
+		Version: persistVersion040,
+	}
+	legacyData := struct {
+		Tracking map[string]trackedFile
+	}{map[string]trackedFile{"foo": {RepairPath: "/foo"}}}
+	if err := persist.SaveJSON(legacyMetadata, legacyData, filepath.Join(r.persistDir, PersistFilename)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the renter persistence.
+	id := r.mu.Lock()
+	r.files = make(map[string]*file)
+	r.tracking = make(map[string]trackedFile)
+	err = r.load()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.files) != 2 {
+		t.Fatal("expected 2 files, got", len(r.files))
+	}
+	for _, f := range []*file{f1, f2} {
+		if err := checkFileMetadata(r, f); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.files[f.name].contracts, f.contracts) {
+			t.Fatal("contracts of converted file don't match")
+		}
+	}
+	if r.tracking["foo"].RepairPath != "/foo" {
+		t.Fatal("tracking data wasn't converted")
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, "qux.sia")); !os.IsNotExist(err) {
+		t.Fatal("legacy file wasn't removed:", err)
+	}
+	if err := persist.LoadJSON(saveMetadata, &legacyData, filepath.Join(r.persistDir, PersistFilename)); err != nil {
+		t.Fatal("persist file wasn't converted:", err)
+	}
+}
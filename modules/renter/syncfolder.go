@@ -0,0 +1,960 @@
+package renter
+
+// syncfolder.go keeps local folders in sync with siapath prefixes. A synced
+// folder is scanned whenever its watcher reports a change, and periodically to
+// find the changes below its prefix and those that the watcher missed. On
+// platforms that can't watch folders, the periodic scans find all changes.
+//
+// A scan compares the files in the folder and below the prefix with the index
+// of the folder, which records the size and modification time of both sides of
+// every file when it was last synced. A file changed on a side if it differs
+// from the index. Changes are copied to the other side if the direction of the
+// folder allows it. Local files are uploaded through Upload to a temporary
+// siapath, which replaces the remote file through ReplaceFile once a later scan
+// finds the upload available. Until then, the remote file stays untouched, and
+// an upload of a local file that changed again is discarded. The replaced
+// remote file is moved to the trash. Remote files are downloaded into a
+// temporary file that replaces the local file. Uploaded files record the
+// modification time of the local file and downloaded files get the
+// modification time of the remote file, so both sides of a synced file have
+// the same size and modification time.
+//
+// A file that was deleted on one side is deleted on the other side if the
+// delete policy of the folder propagates deletions. If a new file with the
+// same size and modification time appeared on that side at the same time, the
+// file was renamed, and it is renamed on the other side as well. A file that
+// changed on both sides is a conflict. It is not synced until both sides are
+// the same again or one of them is deleted, since a change always wins over a
+// deletion.
+//
+// Uploads and downloads run in the background, so that a large file doesn't
+// hold up the deletions and renames of the folder. A file is skipped by scans
+// while it is being transferred, and the folder is scanned again once a
+// transfer finished. If a scan of a folder with at least syncMinGuardedFiles
+// indexed files finds the local folder empty, or would delete a large share of
+// the index, its deletions are withheld and reported as an error, since an
+// unmounted drive or a mistake looks the same as a deliberate deletion. The
+// other actions of the scan are still performed.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+)
+
+const (
+	// syncPersistFilename is the file that contains the synced folders and
+	// their indexes.
+	syncPersistFilename = "sync.json"
+
+	// syncTempExtension is the extension of the temporary files that remote
+	// files are downloaded into and of the temporary siapaths that local
+	// files are uploaded to. Files with this extension are not synced.
+	syncTempExtension = ".siasync"
+)
+
+// The kinds of actions that sync a file of a synced folder.
+const (
+	syncRecord = iota
+	syncForget
+	syncUpload
+	syncDownload
+	syncDeleteLocal
+	syncDeleteRemote
+	syncRenameLocal
+	syncRenameRemote
+)
+
+var (
+	// errSyncFolderExists is returned when a folder that is already synced is
+	// added again.
+	errSyncFolderExists = errors.New("folder is already synced")
+
+	// errSyncFolderOverlap is returned when a folder or siapath prefix is the
+	// same as or inside of another synced folder or prefix, or the other way
+	// around.
+	errSyncFolderOverlap = errors.New("folder overlaps with another synced folder")
+
+	// errSyncInvalidDeletePolicy is returned for an unknown delete policy.
+	errSyncInvalidDeletePolicy = errors.New("delete policy must be 'propagate' or 'keep'")
+
+	// errSyncInvalidDirection is returned for an unknown sync direction.
+	errSyncInvalidDirection = errors.New("direction must be 'both', 'upload' or 'download'")
+
+	// errSyncLocalEmpty is returned when the local folder of a synced folder
+	// is empty while its index is not.
+	errSyncLocalEmpty = errors.New("local folder is empty, delete the remote files to sync the deletion")
+
+	// errSyncLocalChanged is returned when a local file changed while it was
+	// being synced. The change is synced by the next scan.
+	errSyncLocalChanged = errors.New("local file changed during sync")
+
+	// errSyncUploadPending is returned when the upload of a local file isn't
+	// available yet. The upload replaces the remote file in a later scan.
+	errSyncUploadPending = errors.New("upload is not available yet")
+
+	// errSyncNotFolder is returned when the local path of a synced folder is
+	// not a folder.
+	errSyncNotFolder = errors.New("local path is not a folder")
+
+	// errSyncPersistDir is returned when a synced folder overlaps with the
+	// renter's persist directory.
+	errSyncPersistDir = errors.New("folder overlaps with the renter's persist directory")
+
+	// errSyncRelativePath is returned when the local path of a synced folder
+	// is not absolute.
+	errSyncRelativePath = errors.New("local path must be absolute")
+
+	// errSyncTooManyDeletions is returned when a scan would delete more than
+	// syncMaxDeleteShare of the files of a synced folder.
+	errSyncTooManyDeletions = errors.New("too many files were deleted, delete them on both sides to sync the deletion")
+
+	// errUnknownSyncFolder is returned when a folder that isn't synced is
+	// removed.
+	errUnknownSyncFolder = errors.New("folder is not synced")
+
+	syncMetadata = persist.Metadata{
+		Header:  "Renter Sync Folders",
+		Version: persistVersion,
+	}
+)
+
+type (
+	// syncState is the size and modification time of one side of a synced
+	// file. Modification times are in whole seconds, since not every
+	// filesystem stores them more precisely. Remote files without a
+	// modification time have a ModTime of 0.
+	syncState struct {
+		Size    uint64
+		ModTime int64
+	}
+
+	// syncEntry is the state of both sides of a file when it was last synced.
+	syncEntry struct {
+		Local  syncState
+		Remote syncState
+	}
+
+	// syncAction is an action that syncs a file of a synced folder. Renames
+	// move the file at path to newPath.
+	syncAction struct {
+		kind    int
+		path    string
+		newPath string
+	}
+
+	// syncFolder is a local folder that is kept in sync with a siapath
+	// prefix. The index contains the files that are in sync, keyed by their
+	// slash-separated paths relative to the folder and the prefix. The index
+	// is replaced after every scan and transfer instead of being modified, so
+	// a reference to it can be used after the lock is released.
+	syncFolder struct {
+		params modules.SyncFolderParams
+		index  map[string]syncEntry
+
+		// transfers contains the paths of the files that are being uploaded
+		// or downloaded. transferDone receives a value when a transfer
+		// finished.
+		transfers    map[string]struct{}
+		transferDone chan struct{}
+
+		// The results of the most recent scan of the folder.
+		conflicts []modules.SyncConflict
+		err       string
+		lastSync  time.Time
+		watching  bool
+
+		// stop is closed when the folder is removed.
+		stop chan struct{}
+		mu   sync.Mutex
+	}
+
+	// A syncWatcher reports changes within the folders that it watches.
+	syncWatcher interface {
+		// Add watches a folder, not including its subfolders.
+		Add(dir string) error
+
+		// Changes returns a channel that receives a value whenever a file or
+		// folder in one of the watched folders changed.
+		Changes() <-chan struct{}
+
+		// Close stops watching all folders.
+		Close() error
+	}
+
+	// syncFolderPersist is the persisted configuration and index of a synced
+	// folder.
+	syncFolderPersist struct {
+		Params modules.SyncFolderParams
+		Index  map[string]syncEntry
+	}
+)
+
+// newSyncFolder returns a synced folder with the given index.
+func newSyncFolder(params modules.SyncFolderParams, index map[string]syncEntry) *syncFolder {
+	return &syncFolder{
+		params:       params,
+		index:        index,
+		transfers:    make(map[string]struct{}),
+		transferDone: make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+}
+
+// mergeIndex copies the entries of paths from index into the index of the
+// folder, removing the paths that index doesn't contain. f.mu must be held.
+func (f *syncFolder) mergeIndex(index map[string]syncEntry, paths []string) {
+	merged := make(map[string]syncEntry, len(f.index))
+	for path, e := range f.index {
+		merged[path] = e
+	}
+	for _, path := range paths {
+		if e, exists := index[path]; exists {
+			merged[path] = e
+		} else {
+			delete(merged, path)
+		}
+	}
+	f.index = merged
+}
+
+// localSyncState returns the state of a local file.
+func localSyncState(info os.FileInfo) syncState {
+	return syncState{
+		Size:    uint64(info.Size()),
+		ModTime: info.ModTime().Unix(),
+	}
+}
+
+// remoteSyncState returns the state of a remote file.
+func remoteSyncState(fi modules.FileInfo) syncState {
+	s := syncState{Size: fi.Filesize}
+	if !fi.ModTime.IsZero() {
+		s.ModTime = fi.ModTime.Unix()
+	}
+	return s
+}
+
+// overlappingPaths returns whether one of the paths is the same as or inside
+// of the other path.
+func overlappingPaths(a, b, sep string) bool {
+	return a == b || strings.HasPrefix(a, strings.TrimSuffix(b, sep)+sep) || strings.HasPrefix(b, strings.TrimSuffix(a, sep)+sep)
+}
+
+// validateSyncFolderParams checks the parameters of a synced folder and fills
+// in the defaults. Folders are synced in both directions and deletions are
+// propagated, unless the parameters say otherwise.
+func validateSyncFolderParams(params modules.SyncFolderParams) (modules.SyncFolderParams, error) {
+	if !filepath.IsAbs(params.LocalPath) {
+		return params, errSyncRelativePath
+	}
+	params.LocalPath = filepath.Clean(params.LocalPath)
+	info, err := os.Stat(params.LocalPath)
+	if err != nil {
+		return params, err
+	} else if !info.IsDir() {
+		return params, errSyncNotFolder
+	}
+	params.SiaPath = strings.Trim(params.SiaPath, "/")
+	if err := validateSiapath(params.SiaPath); err != nil {
+		return params, err
+	}
+
+	switch params.Direction {
+	case "":
+		params.Direction = modules.SyncDirectionBoth
+	case modules.SyncDirectionBoth, modules.SyncDirectionDownload, modules.SyncDirectionUpload:
+	default:
+		return params, errSyncInvalidDirection
+	}
+	switch params.DeletePolicy {
+	case "":
+		params.DeletePolicy = modules.SyncDeletePropagate
+	case modules.SyncDeleteKeep, modules.SyncDeletePropagate:
+	default:
+		return params, errSyncInvalidDeletePolicy
+	}
+	return params, nil
+}
+
+// syncRenames returns the files that were renamed on one side of a synced
+// folder, mapping their old paths to their new paths. side contains the files
+// on that side and other the files on the other side. A file was renamed if it
+// disappeared while a file that is not on the other side appeared with the
+// same state. Renames that are ambiguous are not detected.
+func syncRenames(index map[string]syncEntry, side, other map[string]syncState, local bool) map[string]string {
+	states := func(e syncEntry) (syncState, syncState) {
+		if local {
+			return e.Local, e.Remote
+		}
+		return e.Remote, e.Local
+	}
+	gone := make(map[syncState][]string)
+	for path, e := range index {
+		sideState, otherState := states(e)
+		if _, exists := side[path]; exists {
+			continue
+		}
+		if s, exists := other[path]; !exists || s != otherState {
+			continue
+		}
+		gone[sideState] = append(gone[sideState], path)
+	}
+	added := make(map[syncState][]string)
+	for path, s := range side {
+		_, indexed := index[path]
+		_, exists := other[path]
+		if !indexed && !exists {
+			added[s] = append(added[s], path)
+		}
+	}
+	renames := make(map[string]string)
+	for s, from := range gone {
+		if to := added[s]; len(from) == 1 && len(to) == 1 {
+			renames[from[0]] = to[0]
+		}
+	}
+	return renames
+}
+
+// planSync compares the local and remote files of a synced folder with its
+// index. It returns the actions that sync the files and the files that are in
+// conflict, both sorted by path.
+func planSync(params modules.SyncFolderParams, index map[string]syncEntry, local, remote map[string]syncState) ([]syncAction, []modules.SyncConflict) {
+	upload := params.Direction != modules.SyncDirectionDownload
+	download := params.Direction != modules.SyncDirectionUpload
+	propagate := params.DeletePolicy == modules.SyncDeletePropagate
+
+	// Renames are found first, so that the old and new paths of renamed
+	// files are not synced as deletions and new files.
+	var actions []syncAction
+	handled := make(map[string]struct{})
+	addRenames := func(renames map[string]string, kind int) {
+		for from, to := range renames {
+			actions = append(actions, syncAction{kind: kind, path: from, newPath: to})
+			handled[from] = struct{}{}
+			handled[to] = struct{}{}
+		}
+	}
+	if upload {
+		addRenames(syncRenames(index, local, remote, true), syncRenameRemote)
+	}
+	if download {
+		addRenames(syncRenames(index, remote, local, false), syncRenameLocal)
+	}
+
+	paths := make(map[string]struct{})
+	for _, files := range []map[string]syncState{local, remote} {
+		for path := range files {
+			paths[path] = struct{}{}
+		}
+	}
+	for path := range index {
+		paths[path] = struct{}{}
+	}
+	var conflicts []modules.SyncConflict
+	for path := range paths {
+		if _, exists := handled[path]; exists {
+			continue
+		}
+		l, localExists := local[path]
+		rs, remoteExists := remote[path]
+		e, indexed := index[path]
+		localChanged := !indexed || !localExists || l != e.Local
+		remoteChanged := !indexed || !remoteExists || rs != e.Remote
+
+		kind := -1
+		switch {
+		case !localChanged && !remoteChanged:
+		case localExists && remoteExists && l == rs:
+			kind = syncRecord
+		case localChanged && remoteChanged:
+			// The file was added on both sides, or it changed or was
+			// deleted on both sides since it was last synced.
+			switch {
+			case localExists && remoteExists:
+				reason := "changed locally and remotely"
+				if !indexed {
+					reason = "added locally and remotely"
+				}
+				conflicts = append(conflicts, modules.SyncConflict{Path: path, Reason: reason})
+			case localExists && upload:
+				kind = syncUpload
+			case remoteExists && download:
+				kind = syncDownload
+			case !localExists && !remoteExists:
+				kind = syncForget
+			}
+		case localChanged:
+			if localExists && upload {
+				kind = syncUpload
+			} else if !localExists && upload && propagate {
+				kind = syncDeleteRemote
+			}
+		case remoteChanged:
+			if remoteExists && download {
+				kind = syncDownload
+			} else if !remoteExists && download && propagate {
+				kind = syncDeleteLocal
+			}
+		}
+		if kind < 0 {
+			continue
+		}
+		actions = append(actions, syncAction{kind: kind, path: path})
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].path < actions[j].path
+	})
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Path < conflicts[j].Path
+	})
+	return actions, conflicts
+}
+
+// checkSyncDeletions checks that the actions of a scan don't delete a large
+// share of the files of a synced folder, and that the local folder isn't empty
+// while files are synced. Files that are gone on both sides are not checked,
+// and neither are folders with fewer than syncMinGuardedFiles indexed files.
+func checkSyncDeletions(index map[string]syncEntry, local, remote map[string]syncState, actions []syncAction) error {
+	if len(index) < syncMinGuardedFiles {
+		return nil
+	} else if len(local) == 0 && len(remote) != 0 {
+		return errSyncLocalEmpty
+	}
+	var deletions int
+	for _, a := range actions {
+		if a.kind == syncDeleteLocal || a.kind == syncDeleteRemote {
+			deletions++
+		}
+	}
+	if float64(deletions) > syncMaxDeleteShare*float64(len(index)) {
+		return fmt.Errorf("%v (%v of %v files)", errSyncTooManyDeletions, deletions, len(index))
+	}
+	return nil
+}
+
+// withholdSyncDeletions returns the actions without the ones that delete
+// files.
+func withholdSyncDeletions(actions []syncAction) []syncAction {
+	var kept []syncAction
+	for _, a := range actions {
+		if a.kind != syncDeleteLocal && a.kind != syncDeleteRemote {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// checkLocalSyncState checks that the local file at path still has the state
+// it had when the synced folder was scanned.
+func checkLocalSyncState(path string, s syncState, exists bool) error {
+	info, err := os.Stat(path)
+	if !exists && os.IsNotExist(err) {
+		return nil
+	} else if exists && err == nil && localSyncState(info) == s {
+		return nil
+	}
+	return errSyncLocalChanged
+}
+
+// scanSyncFolder returns the files in the local folder of a synced folder.
+// The folder and its subfolders are added to the watcher if there is one. A
+// folder that can't be read fails the scan, since its files would appear to
+// have been deleted otherwise.
+func scanSyncFolder(dir string, w syncWatcher) (files map[string]syncState, watching bool, err error) {
+	files = make(map[string]syncState)
+	watching = w != nil
+	err = filepath.Walk(dir, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if w != nil && w.Add(localPath) != nil {
+				watching = false
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || filepath.Ext(localPath) == syncTempExtension {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, localPath)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = localSyncState(info)
+		return nil
+	})
+	return files, watching, err
+}
+
+// managedRemoteSyncFiles returns the renter's files below the siapath prefix
+// of a synced folder, keyed by their paths relative to the prefix.
+func (r *Renter) managedRemoteSyncFiles(prefix string) map[string]modules.FileInfo {
+	id := r.mu.RLock()
+	var files []*file
+	for siaPath, f := range r.files {
+		if strings.HasPrefix(siaPath, prefix+"/") && path.Ext(siaPath) != syncTempExtension {
+			files = append(files, f)
+		}
+	}
+	r.mu.RUnlock(id)
+
+	remote := make(map[string]modules.FileInfo, len(files))
+	for _, fi := range r.managedFileInfos(files) {
+		remote[strings.TrimPrefix(fi.SiaPath, prefix+"/")] = fi
+	}
+	return remote
+}
+
+// managedApplySyncAction performs an action that syncs a file of a synced
+// folder and updates the index accordingly.
+func (r *Renter) managedApplySyncAction(params modules.SyncFolderParams, index map[string]syncEntry, a syncAction, local map[string]syncState, remote map[string]modules.FileInfo) error {
+	localPath := filepath.Join(params.LocalPath, filepath.FromSlash(a.path))
+	siaPath := path.Join(params.SiaPath, a.path)
+	l, localExists := local[a.path]
+	fi := remote[a.path]
+
+	switch a.kind {
+	case syncRecord:
+		index[a.path] = syncEntry{Local: l, Remote: remoteSyncState(fi)}
+
+	case syncForget:
+		delete(index, a.path)
+
+	case syncUpload:
+		// The local file is uploaded to a temporary siapath, which only
+		// replaces the remote file once it is available, so that the remote
+		// file stays available until then. An upload of an older version of
+		// the local file is discarded. If the upload fails, the next scan
+		// uploads the file again.
+		tempSiaPath := siaPath + syncTempExtension
+		temp, err := r.File(tempSiaPath)
+		if err != nil || remoteSyncState(temp) != l {
+			if err := r.DeleteFile(tempSiaPath); err != nil && err != ErrUnknownPath {
+				return err
+			}
+			err := r.Upload(modules.FileUploadParams{
+				Source:  localPath,
+				SiaPath: tempSiaPath,
+			})
+			if err != nil {
+				return err
+			}
+			return errSyncUploadPending
+		} else if !temp.Available && !r.deps.Disrupt("syncUploadAvailable") {
+			return errSyncUploadPending
+		}
+		if err := r.ReplaceFile(tempSiaPath, siaPath); err != nil {
+			return err
+		}
+		fi, err := r.File(siaPath)
+		if err != nil {
+			return err
+		}
+		index[a.path] = syncEntry{Local: l, Remote: remoteSyncState(fi)}
+
+	case syncDownload:
+		// Files that are still being uploaded are downloaded by a later
+		// scan.
+		if !fi.Available {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0700); err != nil {
+			return err
+		}
+		tempPath := localPath + syncTempExtension
+		if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var err error
+		if fi.Filesize == 0 {
+			err = ioutil.WriteFile(tempPath, nil, defaultFilePerm)
+		} else {
+			err = r.Download(modules.RenterDownloadParameters{
+				SiaPath:     siaPath,
+				Destination: tempPath,
+			})
+		}
+		if err == nil && !fi.ModTime.IsZero() {
+			err = os.Chtimes(tempPath, time.Now(), fi.ModTime)
+		}
+		if err == nil {
+			err = checkLocalSyncState(localPath, l, localExists)
+		}
+		if err == nil {
+			err = os.Rename(tempPath, localPath)
+		}
+		if err != nil {
+			os.Remove(tempPath)
+			return err
+		}
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		index[a.path] = syncEntry{Local: localSyncState(info), Remote: remoteSyncState(fi)}
+
+	case syncDeleteLocal:
+		if err := checkLocalSyncState(localPath, l, localExists); err != nil {
+			return err
+		}
+		if err := os.Remove(localPath); err != nil {
+			return err
+		}
+		delete(index, a.path)
+
+	case syncDeleteRemote:
+		if err := r.DeleteFile(siaPath); err != nil && err != ErrUnknownPath {
+			return err
+		}
+		if err := r.DeleteFile(siaPath + syncTempExtension); err != nil && err != ErrUnknownPath {
+			return err
+		}
+		delete(index, a.path)
+
+	case syncRenameLocal:
+		newLocalPath := filepath.Join(params.LocalPath, filepath.FromSlash(a.newPath))
+		if err := checkLocalSyncState(localPath, l, localExists); err != nil {
+			return err
+		}
+		if err := checkLocalSyncState(newLocalPath, syncState{}, false); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(newLocalPath), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(localPath, newLocalPath); err != nil {
+			return err
+		}
+		index[a.newPath] = index[a.path]
+		delete(index, a.path)
+
+	case syncRenameRemote:
+		if err := r.RenameFile(siaPath, path.Join(params.SiaPath, a.newPath)); err != nil {
+			return err
+		}
+		index[a.newPath] = index[a.path]
+		delete(index, a.path)
+	}
+	return nil
+}
+
+// managedStartSyncTransfer uploads or downloads a file of a synced folder in
+// the background. At most syncMaxTransfers files of a folder are transferred
+// at once, the other files are transferred by a later scan.
+func (r *Renter) managedStartSyncTransfer(f *syncFolder, params modules.SyncFolderParams, index map[string]syncEntry, a syncAction, local map[string]syncState, remote map[string]modules.FileInfo) {
+	f.mu.Lock()
+	if _, exists := f.transfers[a.path]; exists || len(f.transfers) >= syncMaxTransfers {
+		f.mu.Unlock()
+		return
+	}
+	f.transfers[a.path] = struct{}{}
+	f.mu.Unlock()
+
+	entry := make(map[string]syncEntry)
+	if e, exists := index[a.path]; exists {
+		entry[a.path] = e
+	}
+	go r.threadedSyncTransfer(f, params, entry, a, local, remote)
+}
+
+// threadedSyncTransfer performs an upload or download of a synced folder and
+// merges its entry into the index of the folder. The folder is scanned again
+// after a successful transfer, failed transfers and uploads that aren't
+// available yet are retried by the next periodic scan.
+func (r *Renter) threadedSyncTransfer(f *syncFolder, params modules.SyncFolderParams, index map[string]syncEntry, a syncAction, local map[string]syncState, remote map[string]modules.FileInfo) {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	err := r.managedApplySyncAction(params, index, a, local, remote)
+	f.mu.Lock()
+	delete(f.transfers, a.path)
+	if err == errSyncUploadPending {
+		// Nothing changed yet, the upload is checked by the next periodic
+		// scan.
+	} else if err != nil {
+		f.err = fmt.Sprintf("could not sync %v: %v", a.path, err)
+	} else {
+		f.mergeIndex(index, []string{a.path})
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return
+	}
+	select {
+	case f.transferDone <- struct{}{}:
+	default:
+	}
+
+	id := r.mu.Lock()
+	err = r.saveSyncFolders()
+	r.mu.Unlock(id)
+	if err != nil {
+		r.log.Println("WARN: could not save synced folders:", err)
+	}
+}
+
+// managedSyncFolder scans a synced folder and syncs the files that changed.
+// Files that are being transferred are skipped.
+func (r *Renter) managedSyncFolder(f *syncFolder, w syncWatcher) {
+	f.mu.Lock()
+	params := f.params
+	index := make(map[string]syncEntry, len(f.index))
+	for path, e := range f.index {
+		index[path] = e
+	}
+	transfers := make(map[string]struct{}, len(f.transfers))
+	for path := range f.transfers {
+		transfers[path] = struct{}{}
+	}
+	f.mu.Unlock()
+	transferring := func(path string) bool {
+		_, exists := transfers[path]
+		return exists
+	}
+
+	var conflicts []modules.SyncConflict
+	var actions []syncAction
+	var remoteFiles map[string]modules.FileInfo
+	local, watching, scanErr := scanSyncFolder(params.LocalPath, w)
+	if scanErr != nil {
+		scanErr = fmt.Errorf("could not scan local folder: %v", scanErr)
+	} else {
+		remoteFiles = r.managedRemoteSyncFiles(params.SiaPath)
+		remote := make(map[string]syncState, len(remoteFiles))
+		for path, fi := range remoteFiles {
+			remote[path] = remoteSyncState(fi)
+		}
+		planned, plannedConflicts := planSync(params, index, local, remote)
+		for _, a := range planned {
+			if !transferring(a.path) && !transferring(a.newPath) {
+				actions = append(actions, a)
+			}
+		}
+		for _, c := range plannedConflicts {
+			if !transferring(c.Path) {
+				conflicts = append(conflicts, c)
+			}
+		}
+		if err := checkSyncDeletions(index, local, remote, actions); err != nil {
+			scanErr = err
+			actions = withholdSyncDeletions(actions)
+		}
+	}
+
+	var changed []string
+apply:
+	for _, a := range actions {
+		select {
+		case <-f.stop:
+			break apply
+		case <-r.tg.StopChan():
+			break apply
+		default:
+		}
+		if a.kind == syncUpload || a.kind == syncDownload {
+			r.managedStartSyncTransfer(f, params, index, a, local, remoteFiles)
+			continue
+		}
+		err := r.managedApplySyncAction(params, index, a, local, remoteFiles)
+		if err != nil && scanErr == nil {
+			scanErr = fmt.Errorf("could not sync %v: %v", a.path, err)
+		}
+		changed = append(changed, a.path)
+		if a.newPath != "" {
+			changed = append(changed, a.newPath)
+		}
+	}
+
+	f.mu.Lock()
+	f.mergeIndex(index, changed)
+	f.conflicts = conflicts
+	f.err = ""
+	if scanErr != nil {
+		f.err = scanErr.Error()
+	} else {
+		f.lastSync = time.Now()
+	}
+	f.watching = watching
+	f.mu.Unlock()
+
+	if len(actions) == 0 {
+		return
+	}
+	id := r.mu.Lock()
+	err := r.saveSyncFolders()
+	r.mu.Unlock(id)
+	if err != nil {
+		r.log.Println("WARN: could not save synced folders:", err)
+	}
+}
+
+// threadedSyncFolder keeps a synced folder in sync until it is removed or the
+// renter shuts down. The folder is scanned after its watcher reported changes
+// and the folder settled, after a transfer finished, and periodically.
+func (r *Renter) threadedSyncFolder(f *syncFolder) {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	w, err := newSyncWatcher()
+	if err != nil {
+		r.log.Debugln("Could not watch synced folder, relying on rescans:", err)
+		w = nil
+	}
+	var changes <-chan struct{}
+	if w != nil {
+		defer w.Close()
+		changes = w.Changes()
+	}
+
+	for {
+		r.managedSyncFolder(f, w)
+
+		select {
+		case <-f.stop:
+			return
+		case <-r.tg.StopChan():
+			return
+		case <-time.After(syncRescanInterval):
+			continue
+		case <-f.transferDone:
+			continue
+		case <-changes:
+		}
+		for settled := false; !settled; {
+			select {
+			case <-f.stop:
+				return
+			case <-r.tg.StopChan():
+				return
+			case <-changes:
+			case <-time.After(syncSettleTime):
+				settled = true
+			}
+		}
+	}
+}
+
+// saveSyncFolders saves the synced folders and their indexes.
+func (r *Renter) saveSyncFolders() error {
+	folders := make([]syncFolderPersist, 0, len(r.syncFolders))
+	for _, f := range r.syncFolders {
+		f.mu.Lock()
+		folders = append(folders, syncFolderPersist{
+			Params: f.params,
+			Index:  f.index,
+		})
+		f.mu.Unlock()
+	}
+	return persist.SaveJSON(syncMetadata, folders, filepath.Join(r.persistDir, syncPersistFilename))
+}
+
+// loadSyncFolders loads the synced folders and their indexes.
+func (r *Renter) loadSyncFolders() error {
+	var folders []syncFolderPersist
+	err := persist.LoadJSON(syncMetadata, &folders, filepath.Join(r.persistDir, syncPersistFilename))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, sf := range folders {
+		if sf.Index == nil {
+			sf.Index = make(map[string]syncEntry)
+		}
+		r.syncFolders[sf.Params.LocalPath] = newSyncFolder(sf.Params, sf.Index)
+	}
+	return nil
+}
+
+// AddSyncFolder starts keeping a local folder in sync with a siapath prefix.
+// The folder and the prefix must not overlap with other synced folders.
+func (r *Renter) AddSyncFolder(params modules.SyncFolderParams) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	params, err := validateSyncFolderParams(params)
+	if err != nil {
+		return err
+	}
+	if overlappingPaths(params.LocalPath, r.persistDir, string(filepath.Separator)) {
+		return errSyncPersistDir
+	}
+
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if _, exists := r.syncFolders[params.LocalPath]; exists {
+		return errSyncFolderExists
+	}
+	for _, sf := range r.syncFolders {
+		if overlappingPaths(params.LocalPath, sf.params.LocalPath, string(filepath.Separator)) || overlappingPaths(params.SiaPath, sf.params.SiaPath, "/") {
+			return errSyncFolderOverlap
+		}
+	}
+	f := newSyncFolder(params, make(map[string]syncEntry))
+	r.syncFolders[params.LocalPath] = f
+	if err := r.saveSyncFolders(); err != nil {
+		delete(r.syncFolders, params.LocalPath)
+		return err
+	}
+	go r.threadedSyncFolder(f)
+	return nil
+}
+
+// RemoveSyncFolder stops syncing a local folder. The files on either side are
+// kept.
+func (r *Renter) RemoveSyncFolder(localPath string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f, exists := r.syncFolders[filepath.Clean(localPath)]
+	if !exists {
+		return errUnknownSyncFolder
+	}
+	close(f.stop)
+	delete(r.syncFolders, f.params.LocalPath)
+	return r.saveSyncFolders()
+}
+
+// SyncFolders returns the synced folders, sorted by their local paths.
+func (r *Renter) SyncFolders() []modules.SyncFolderInfo {
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	var infos []modules.SyncFolderInfo
+	for _, f := range r.syncFolders {
+		f.mu.Lock()
+		infos = append(infos, modules.SyncFolderInfo{
+			SyncFolderParams: f.params,
+			Watching:         f.watching,
+			Files:            uint64(len(f.index)),
+			LastSync:         f.lastSync,
+			Error:            f.err,
+			Conflicts:        append([]modules.SyncConflict(nil), f.conflicts...),
+		})
+		f.mu.Unlock()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].LocalPath < infos[j].LocalPath
+	})
+	return infos
+}
@@ -0,0 +1,507 @@
+package renter
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// dependencySyncUploadAvailable is a dependency used to treat the uploads of
+// synced folders as available, since the renter tester has no hosts to upload
+// to.
+type dependencySyncUploadAvailable struct {
+	modules.ProductionDependencies
+	mu        sync.Mutex
+	available bool
+}
+
+// Disrupt returns true for the syncUploadAvailable disrupt once setAvailable
+// was called.
+func (d *dependencySyncUploadAvailable) Disrupt(s string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.available && s == "syncUploadAvailable"
+}
+
+// setAvailable sets whether uploads of synced folders are treated as
+// available.
+func (d *dependencySyncUploadAvailable) setAvailable(available bool) {
+	d.mu.Lock()
+	d.available = available
+	d.mu.Unlock()
+}
+
+// TestPlanSync checks the actions and conflicts that a scan of a synced folder
+// finds.
+func TestPlanSync(t *testing.T) {
+	a := syncState{Size: 10, ModTime: 1000}
+	b := syncState{Size: 20, ModTime: 2000}
+	c := syncState{Size: 30, ModTime: 3000}
+	synced := map[string]syncEntry{"f": {Local: a, Remote: a}}
+	both := modules.SyncFolderParams{Direction: modules.SyncDirectionBoth, DeletePolicy: modules.SyncDeletePropagate}
+	keep := modules.SyncFolderParams{Direction: modules.SyncDirectionBoth, DeletePolicy: modules.SyncDeleteKeep}
+	upload := modules.SyncFolderParams{Direction: modules.SyncDirectionUpload, DeletePolicy: modules.SyncDeletePropagate}
+	download := modules.SyncFolderParams{Direction: modules.SyncDirectionDownload, DeletePolicy: modules.SyncDeletePropagate}
+
+	tests := []struct {
+		name      string
+		params    modules.SyncFolderParams
+		index     map[string]syncEntry
+		local     map[string]syncState
+		remote    map[string]syncState
+		actions   []syncAction
+		conflicts []string
+	}{
+		{"new local file", both, nil, map[string]syncState{"f": a}, nil,
+			[]syncAction{{kind: syncUpload, path: "f"}}, nil},
+		{"new remote file", both, nil, nil, map[string]syncState{"f": a},
+			[]syncAction{{kind: syncDownload, path: "f"}}, nil},
+		{"same new file", both, nil, map[string]syncState{"f": a}, map[string]syncState{"f": a},
+			[]syncAction{{kind: syncRecord, path: "f"}}, nil},
+		{"different new files", both, nil, map[string]syncState{"f": a}, map[string]syncState{"f": b},
+			nil, []string{"f"}},
+		{"in sync", both, synced, map[string]syncState{"f": a}, map[string]syncState{"f": a},
+			nil, nil},
+		{"changed locally", both, synced, map[string]syncState{"f": b}, map[string]syncState{"f": a},
+			[]syncAction{{kind: syncUpload, path: "f"}}, nil},
+		{"changed remotely", both, synced, map[string]syncState{"f": a}, map[string]syncState{"f": b},
+			[]syncAction{{kind: syncDownload, path: "f"}}, nil},
+		{"changed on both sides", both, synced, map[string]syncState{"f": b}, map[string]syncState{"f": c},
+			nil, []string{"f"}},
+		{"changed to the same file", both, synced, map[string]syncState{"f": b}, map[string]syncState{"f": b},
+			[]syncAction{{kind: syncRecord, path: "f"}}, nil},
+		{"deleted locally", both, synced, nil, map[string]syncState{"f": a},
+			[]syncAction{{kind: syncDeleteRemote, path: "f"}}, nil},
+		{"deleted remotely", both, synced, map[string]syncState{"f": a}, nil,
+			[]syncAction{{kind: syncDeleteLocal, path: "f"}}, nil},
+		{"deleted locally and kept", keep, synced, nil, map[string]syncState{"f": a},
+			nil, nil},
+		{"deleted remotely and kept", keep, synced, map[string]syncState{"f": a}, nil,
+			nil, nil},
+		{"deleted on both sides", both, synced, nil, nil,
+			[]syncAction{{kind: syncForget, path: "f"}}, nil},
+		{"deleted locally and changed remotely", both, synced, nil, map[string]syncState{"f": b},
+			[]syncAction{{kind: syncDownload, path: "f"}}, nil},
+		{"changed locally and deleted remotely", both, synced, map[string]syncState{"f": b}, nil,
+			[]syncAction{{kind: syncUpload, path: "f"}}, nil},
+		{"renamed locally", both, synced, map[string]syncState{"g": a}, map[string]syncState{"f": a},
+			[]syncAction{{kind: syncRenameRemote, path: "f", newPath: "g"}}, nil},
+		{"renamed remotely", both, synced, map[string]syncState{"f": a}, map[string]syncState{"g": a},
+			[]syncAction{{kind: syncRenameLocal, path: "f", newPath: "g"}}, nil},
+		{"renamed locally and kept", keep, synced, map[string]syncState{"g": a}, map[string]syncState{"f": a},
+			[]syncAction{{kind: syncRenameRemote, path: "f", newPath: "g"}}, nil},
+		{"ambiguous rename", both,
+			map[string]syncEntry{"f": {Local: a, Remote: a}, "g": {Local: a, Remote: a}},
+			map[string]syncState{"h": a}, map[string]syncState{"f": a, "g": a},
+			[]syncAction{{kind: syncDeleteRemote, path: "f"}, {kind: syncDeleteRemote, path: "g"}, {kind: syncUpload, path: "h"}}, nil},
+		{"upload ignores remote changes", upload, synced, map[string]syncState{"f": a}, map[string]syncState{"f": b, "g": c},
+			nil, nil},
+		{"upload ignores remote deletions", upload, synced, map[string]syncState{"f": a}, nil,
+			nil, nil},
+		{"upload ignores remote renames", upload, synced, map[string]syncState{"f": a}, map[string]syncState{"g": a},
+			nil, nil},
+		{"upload reports conflicts", upload, synced, map[string]syncState{"f": b}, map[string]syncState{"f": c},
+			nil, []string{"f"}},
+		{"download ignores local changes", download, synced, map[string]syncState{"f": b, "g": c}, map[string]syncState{"f": a},
+			nil, nil},
+		{"download ignores local deletions", download, synced, nil, map[string]syncState{"f": a},
+			nil, nil},
+		{"download syncs remote changes", download, synced, map[string]syncState{"f": a}, map[string]syncState{"f": b},
+			[]syncAction{{kind: syncDownload, path: "f"}}, nil},
+	}
+	for _, test := range tests {
+		actions, conflicts := planSync(test.params, test.index, test.local, test.remote)
+		if !reflect.DeepEqual(actions, test.actions) {
+			t.Errorf("%v: expected actions %v, got %v", test.name, test.actions, actions)
+		}
+		var paths []string
+		for _, c := range conflicts {
+			paths = append(paths, c.Path)
+		}
+		if !reflect.DeepEqual(paths, test.conflicts) {
+			t.Errorf("%v: expected conflicts %v, got %v", test.name, test.conflicts, paths)
+		}
+	}
+}
+
+// TestCheckSyncDeletions checks that the deletions of scans that would delete
+// a large share of a synced folder are withheld.
+func TestCheckSyncDeletions(t *testing.T) {
+	a := syncState{Size: 10, ModTime: 1000}
+	states := func(paths ...string) map[string]syncState {
+		m := make(map[string]syncState)
+		for _, path := range paths {
+			m[path] = a
+		}
+		return m
+	}
+	entries := func(paths ...string) map[string]syncEntry {
+		m := make(map[string]syncEntry)
+		for _, path := range paths {
+			m[path] = syncEntry{Local: a, Remote: a}
+		}
+		return m
+	}
+	deleteRemote := func(paths ...string) (actions []syncAction) {
+		for _, path := range paths {
+			actions = append(actions, syncAction{kind: syncDeleteRemote, path: path})
+		}
+		return
+	}
+	var paths []string
+	for i := 0; i < syncMinGuardedFiles*2; i++ {
+		paths = append(paths, fmt.Sprintf("f%v", i))
+	}
+	half := len(paths) / 2
+
+	tests := []struct {
+		name    string
+		index   map[string]syncEntry
+		local   map[string]syncState
+		remote  map[string]syncState
+		actions []syncAction
+		err     bool
+	}{
+		{"new folder", nil, nil, states(paths...), nil, false},
+		{"only file deleted", entries("f"), nil, states("f"), deleteRemote("f"), false},
+		{"small folder", entries("f", "g", "h"), states("h"), states("f", "g", "h"), deleteRemote("f", "g"), false},
+		{"empty local folder", entries(paths...), nil, states(paths...), deleteRemote(paths...), true},
+		{"empty on both sides", entries(paths...), nil, nil, nil, false},
+		{"few deletions", entries(paths...), states(paths[1:]...), states(paths...), deleteRemote(paths[0]), false},
+		{"many deletions", entries(paths...), states(paths[half+1:]...), states(paths...), deleteRemote(paths[:half+1]...), true},
+		{"renames", entries(paths...), states(append([]string{"x", "y"}, paths[2:]...)...), states(paths...),
+			[]syncAction{{kind: syncRenameRemote, path: paths[0], newPath: "x"}, {kind: syncRenameRemote, path: paths[1], newPath: "y"}}, false},
+	}
+	for _, test := range tests {
+		err := checkSyncDeletions(test.index, test.local, test.remote, test.actions)
+		if (err != nil) != test.err {
+			t.Errorf("%v: unexpected error %v", test.name, err)
+		}
+	}
+
+	// Only the deletions are withheld when a check fails.
+	actions := []syncAction{
+		{kind: syncDeleteLocal, path: "a"},
+		{kind: syncUpload, path: "b"},
+		{kind: syncDeleteRemote, path: "c"},
+		{kind: syncRenameRemote, path: "d", newPath: "e"},
+	}
+	if kept := withholdSyncDeletions(actions); !reflect.DeepEqual(kept, []syncAction{actions[1], actions[3]}) {
+		t.Error("wrong actions were withheld:", kept)
+	}
+}
+
+// TestAddSyncFolder checks that the parameters of synced folders are
+// validated and that synced folders are persisted.
+func TestAddSyncFolder(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+	defer r.Close()
+
+	dir := build.TempDir("renter", t.Name(), "local")
+	for _, sub := range []string{"a", "b", "a/sub"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = r.AddSyncFolder(modules.SyncFolderParams{
+		LocalPath: filepath.Join(dir, "a") + "/",
+		SiaPath:   "/sync/a/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		params modules.SyncFolderParams
+		err    error
+	}{
+		{modules.SyncFolderParams{LocalPath: "b", SiaPath: "b"}, errSyncRelativePath},
+		{modules.SyncFolderParams{LocalPath: filepath.Join(dir, "file"), SiaPath: "b"}, errSyncNotFolder},
+		{modules.SyncFolderParams{LocalPath: filepath.Join(dir, "b"), SiaPath: "b", Direction: "sideways"}, errSyncInvalidDirection},
+		{modules.SyncFolderParams{LocalPath: filepath.Join(dir, "b"), SiaPath: "b", DeletePolicy: "never"}, errSyncInvalidDeletePolicy},
+		{modules.SyncFolderParams{LocalPath: filepath.Join(dir, "a"), SiaPath: "b"}, errSyncFolderExists},
+		{modules.SyncFolderParams{LocalPath: filepath.Join(dir, "a", "sub"), SiaPath: "b"}, errSyncFolderOverlap},
+		{modules.SyncFolderParams{LocalPath: dir, SiaPath: "b"}, errSyncFolderOverlap},
+		{modules.SyncFolderParams{LocalPath: filepath.Join(dir, "b"), SiaPath: "sync"}, errSyncFolderOverlap},
+		{modules.SyncFolderParams{LocalPath: filepath.Join(dir, "b"), SiaPath: "sync/a/b"}, errSyncFolderOverlap},
+		{modules.SyncFolderParams{LocalPath: r.persistDir, SiaPath: "b"}, errSyncPersistDir},
+	}
+	for i, test := range tests {
+		if err := r.AddSyncFolder(test.params); err != test.err {
+			t.Errorf("%v: expected %v, got %v", i, test.err, err)
+		}
+	}
+	if err := r.AddSyncFolder(modules.SyncFolderParams{LocalPath: filepath.Join(dir, "b"), SiaPath: "sync/b", Direction: modules.SyncDirectionUpload, DeletePolicy: modules.SyncDeleteKeep}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []modules.SyncFolderParams{
+		{LocalPath: filepath.Join(dir, "a"), SiaPath: "sync/a", Direction: modules.SyncDirectionBoth, DeletePolicy: modules.SyncDeletePropagate},
+		{LocalPath: filepath.Join(dir, "b"), SiaPath: "sync/b", Direction: modules.SyncDirectionUpload, DeletePolicy: modules.SyncDeleteKeep},
+	}
+	checkFolders := func() {
+		folders := r.SyncFolders()
+		if len(folders) != len(expected) {
+			t.Fatal("wrong number of synced folders:", folders)
+		}
+		for i, f := range folders {
+			if f.SyncFolderParams != expected[i] {
+				t.Fatalf("expected folder %v, got %v", expected[i], f.SyncFolderParams)
+			}
+		}
+	}
+	checkFolders()
+
+	// Reload the synced folders.
+	id := r.mu.Lock()
+	r.syncFolders = make(map[string]*syncFolder)
+	err = r.loadSyncFolders()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFolders()
+
+	if err := r.RemoveSyncFolder(filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RemoveSyncFolder(filepath.Join(dir, "b")); err != errUnknownSyncFolder {
+		t.Fatal("expected errUnknownSyncFolder, got", err)
+	}
+	expected = expected[:1]
+	checkFolders()
+}
+
+// TestSyncFolderUpload checks that the changes to a synced folder are synced
+// to its siapath prefix.
+func TestSyncFolderUpload(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+	defer r.Close()
+	deps := &dependencySyncUploadAvailable{available: true}
+	r.deps = deps
+
+	dir := build.TempDir("renter", t.Name(), "local")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func(name string, size int) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), fastrand.Bytes(size), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("a", 100)
+	writeFile("sub/b", 200)
+
+	// checkRemote waits until the prefix contains exactly the files in
+	// expected, with their sizes.
+	checkRemote := func(expected map[string]uint64) {
+		err := build.Retry(100, 100*time.Millisecond, func() error {
+			remote := r.managedRemoteSyncFiles("sync")
+			if len(remote) != len(expected) {
+				return errors.New("wrong number of remote files")
+			}
+			for path, size := range expected {
+				if fi, exists := remote[path]; !exists || fi.Filesize != size {
+					return errors.New("missing remote file " + path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err, r.managedRemoteSyncFiles("sync"))
+		}
+	}
+
+	err = r.AddSyncFolder(modules.SyncFolderParams{LocalPath: dir, SiaPath: "sync"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(map[string]uint64{"a": 100, "sub/b": 200})
+
+	// A file that changed is only replaced once its upload is available, the
+	// old version stays in place until then.
+	deps.setAvailable(false)
+	writeFile("a", 150)
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		temp, err := r.File("sync/a" + syncTempExtension)
+		if err != nil || temp.Filesize != 150 {
+			return errors.New("changed file wasn't uploaded")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * syncRescanInterval)
+	checkRemote(map[string]uint64{"a": 100, "sub/b": 200})
+	if trash := r.Trash(); len(trash) != 0 {
+		t.Fatal("file was replaced before its upload was available:", trash)
+	}
+
+	// A new file is uploaded, a file that changed is replaced and the old
+	// version is moved to the trash.
+	writeFile("c", 300)
+	deps.setAvailable(true)
+	checkRemote(map[string]uint64{"a": 150, "sub/b": 200, "c": 300})
+	if trash := r.Trash(); len(trash) != 1 || trash[0].SiaPath != "sync/a" || trash[0].Filesize != 100 {
+		t.Fatal("replaced file wasn't moved to the trash:", trash)
+	}
+
+	// A renamed file is renamed remotely instead of being uploaded again, a
+	// deleted file is moved to the trash.
+	if err := os.Rename(filepath.Join(dir, "sub", "b"), filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "c")); err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(map[string]uint64{"a": 150, "b": 200})
+	if trash := r.Trash(); len(trash) != 2 || trash[1].SiaPath != "sync/c" {
+		t.Fatal("deleted file wasn't moved to the trash:", trash)
+	}
+
+	// A file that changes on both sides is a conflict. The remote file
+	// changes first, but it can't be downloaded since the renter has no
+	// hosts.
+	id := r.mu.Lock()
+	f := r.files["sync/b"]
+	f.mu.Lock()
+	f.modTime = time.Unix(1500000000, 0)
+	f.mu.Unlock()
+	r.mu.Unlock(id)
+	writeFile("b", 250)
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		folders := r.SyncFolders()
+		if len(folders) != 1 || len(folders[0].Conflicts) != 1 || folders[0].Conflicts[0].Path != "b" {
+			return errors.New("conflict wasn't reported")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err, r.SyncFolders())
+	}
+	checkRemote(map[string]uint64{"a": 150, "b": 200})
+
+	// Deleting one side resolves the conflict.
+	if err := r.DeleteFile("sync/b"); err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(map[string]uint64{"a": 150, "b": 250})
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if folder := r.SyncFolders()[0]; len(folder.Conflicts) != 0 || folder.Error != "" || folder.Files != 2 {
+			return errors.New("conflict wasn't resolved")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err, r.SyncFolders())
+	}
+
+	// Deleting the files of a small folder deletes them remotely.
+	for _, name := range []string{"a", "b"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkRemote(map[string]uint64{})
+
+	// The deletions of a scan that would delete most of the files of a
+	// larger folder are withheld, the other changes are still synced.
+	expected := make(map[string]uint64)
+	for i := 0; i < syncMinGuardedFiles; i++ {
+		name := fmt.Sprintf("d%v", i)
+		writeFile(name, 100)
+		expected[name] = 100
+	}
+	checkRemote(expected)
+	for name := range expected {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("e", 100)
+	expected["e"] = 100
+	checkRemote(expected)
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if folder := r.SyncFolders()[0]; !strings.HasPrefix(folder.Error, errSyncTooManyDeletions.Error()) {
+			return errors.New("deletions weren't withheld")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err, r.SyncFolders())
+	}
+
+	// An empty local folder doesn't delete the remote files.
+	if err := os.Remove(filepath.Join(dir, "e")); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if folder := r.SyncFolders()[0]; folder.Error != errSyncLocalEmpty.Error() {
+			return errors.New("empty folder wasn't reported")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err, r.SyncFolders())
+	}
+	checkRemote(expected)
+}
+
+// TestSyncWatcher checks that the watcher of a synced folder reports changes,
+// on the platforms that support it.
+func TestSyncWatcher(t *testing.T) {
+	w, err := newSyncWatcher()
+	if err != nil {
+		t.Skip("watching folders is not supported:", err)
+	}
+	defer w.Close()
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("change wasn't reported")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
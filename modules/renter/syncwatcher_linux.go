@@ -0,0 +1,73 @@
+package renter
+
+// syncwatcher_linux.go watches synced folders through inotify.
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	// inotifyMask selects the events that are reported for watched folders.
+	inotifyMask = syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+		syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MODIFY |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF
+)
+
+// inotifyWatcher is a syncWatcher that uses an inotify instance. The events
+// themselves are not inspected, since any event causes the synced folder to
+// be scanned.
+type inotifyWatcher struct {
+	fd      int
+	file    *os.File
+	changes chan struct{}
+}
+
+// newSyncWatcher creates a watcher for a synced folder.
+func newSyncWatcher() (syncWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking file is read through the runtime's poller, so closing
+	// the file interrupts the read.
+	w := &inotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan struct{}, 1),
+	}
+	go w.threadedReadEvents()
+	return w, nil
+}
+
+// Add watches a folder, not including its subfolders.
+func (w *inotifyWatcher) Add(dir string) error {
+	_, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	return os.NewSyscallError("inotify_add_watch", err)
+}
+
+// Changes returns a channel that receives a value whenever a file or folder in
+// one of the watched folders changed.
+func (w *inotifyWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Close stops watching all folders.
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+// threadedReadEvents reports a change for every batch of events until the
+// watcher is closed.
+func (w *inotifyWatcher) threadedReadEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		if _, err := w.file.Read(buf); err != nil {
+			return
+		}
+		select {
+		case w.changes <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// +build !linux
+
+package renter
+
+// syncwatcher_other.go is used on platforms that can't watch synced folders.
+// Their changes are found by the periodic rescans instead.
+
+import (
+	"errors"
+)
+
+// newSyncWatcher returns an error, since folders can't be watched on this
+// platform.
+func newSyncWatcher() (syncWatcher, error) {
+	return nil, errors.New("watching folders is not supported on this platform")
+}
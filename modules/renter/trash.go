@@ -0,0 +1,317 @@
+package renter
+
+// trash.go implements the renter's trash. Deleted files are moved to the trash
+// instead of being deleted right away, so that they can be restored if they
+// were deleted by mistake. The metadata of a trashed file is moved to the
+// reserved trash directory and the file is no longer repaired, but its data
+// stays on the hosts until the file is purged.
+//
+// Every trashed file is stored under its own key, which consists of its
+// siapath and the time it was deleted, so that a file that is deleted from the
+// same siapath again doesn't replace the earlier version. Restoring a siapath
+// restores its most recently deleted version. The trash of older renters is
+// keyed by siapath only, which is still supported. Files in the trash
+// directory without an entry in the trash were not trashed and are moved out
+// of it when the renter loads, see migrateFile.
+//
+// Files are purged once they have been in the trash for the trash retention of
+// the renter, or when the trash is emptied. A trash retention of 0 disables the
+// trash, deleted files are purged right away.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// trashDir is the reserved directory that contains the metadata of the
+	// files in the trash.
+	trashDir = ".trash"
+)
+
+var (
+	// errNegativeTrashRetention is returned when the trash retention is set
+	// to a negative duration.
+	errNegativeTrashRetention = errors.New("trash retention can't be negative")
+)
+
+// trashedFile is a file in the trash.
+type trashedFile struct {
+	// SiaPath is the siapath the file was deleted from, DeleteTime is the
+	// time the file was moved to the trash. Tracking is the tracking data of
+	// the file before it was deleted, nil if the file wasn't tracked. Entries
+	// of older renters don't have a SiaPath, their key is the siapath.
+	SiaPath    string
+	DeleteTime time.Time
+	Tracking   *trackedFile
+
+	file *file
+	key  string
+}
+
+// trashName returns the siapath of the metadata of the trashed file with key.
+func trashName(key string) string {
+	return trashDir + "/" + key
+}
+
+// newTrashKey returns an unused key for a file that was deleted from siaPath
+// at deleteTime.
+func (r *Renter) newTrashKey(siaPath string, deleteTime time.Time) string {
+	nanos := deleteTime.UnixNano()
+	for {
+		key := fmt.Sprintf("%v_%v", siaPath, nanos)
+		if _, exists := r.trash[key]; !exists {
+			return key
+		}
+		nanos++
+	}
+}
+
+// isTrashName returns whether the siapath belongs to a trashed file.
+func isTrashName(siaPath string) bool {
+	return strings.HasPrefix(siaPath, trashDir+"/")
+}
+
+// trashFile moves a file to the trash. The file is purged right away if the
+// trash is disabled. The directory tree is not updated.
+func (r *Renter) trashFile(siaPath string, f *file) error {
+	if r.trashRetention == 0 {
+		r.purgeFile(siaPath, f)
+		return nil
+	}
+	_, err := r.moveToTrash(siaPath, f)
+	return err
+}
+
+// moveToTrash moves a file to the trash, even if the trash is disabled. Files
+// that were trashed from the same siapath before are kept. The directory tree
+// is not updated.
+func (r *Renter) moveToTrash(siaPath string, f *file) (*trashedFile, error) {
+	deleteTime := time.Now()
+	key := r.newTrashKey(siaPath, deleteTime)
+
+	// Save the metadata of the file in the trash before the old metadata is
+	// removed.
+	f.mu.Lock()
+	f.name = trashName(key)
+	err := r.saveFile(f)
+	if err != nil {
+		f.name = siaPath
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	err = os.RemoveAll(filepath.Join(r.persistDir, metadataPath(siaPath)))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove file:", err)
+	}
+
+	tf := &trashedFile{
+		SiaPath:    siaPath,
+		DeleteTime: deleteTime,
+		file:       f,
+		key:        key,
+	}
+	if t, exists := r.tracking[siaPath]; exists {
+		tf.Tracking = &t
+	}
+	delete(r.files, siaPath)
+	delete(r.tracking, siaPath)
+	r.trash[key] = tf
+	return tf, nil
+}
+
+// purgeFile removes a file from the renter and deletes its metadata and its
+// references to packs and blocks. The directory tree is not updated.
+func (r *Renter) purgeFile(siaPath string, f *file) {
+	r.deleteFile(siaPath, f)
+	r.releasePackedFile(f)
+	r.releaseDedupFile(f)
+	r.releaseCompressionStage(f)
+
+	f.mu.Lock()
+	f.deleted = true
+	f.mu.Unlock()
+}
+
+// purgeTrashedFile removes a file from the trash and deletes its metadata and
+// its references to packs and blocks.
+func (r *Renter) purgeTrashedFile(tf *trashedFile) {
+	delete(r.trash, tf.key)
+	err := os.RemoveAll(filepath.Join(r.persistDir, metadataPath(trashName(tf.key))))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove trashed file:", err)
+	}
+	r.releasePackedFile(tf.file)
+	r.releaseDedupFile(tf.file)
+	r.releaseCompressionStage(tf.file)
+
+	tf.file.mu.Lock()
+	tf.file.deleted = true
+	tf.file.mu.Unlock()
+}
+
+// purgeExpiredTrash purges the files that have been in the trash for longer
+// than the trash retention.
+func (r *Renter) purgeExpiredTrash() (purged bool) {
+	for _, tf := range r.trash {
+		if time.Since(tf.DeleteTime) >= r.trashRetention {
+			r.purgeTrashedFile(tf)
+			purged = true
+		}
+	}
+	return purged
+}
+
+// EmptyTrash purges all of the files in the trash.
+func (r *Renter) EmptyTrash() error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	for _, tf := range r.trash {
+		r.purgeTrashedFile(tf)
+	}
+	return r.saveSync()
+}
+
+// RestoreFile moves the most recently deleted file at a siapath from the trash
+// back to that siapath. There must not be a file at that siapath.
+func (r *Renter) RestoreFile(siaPath string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	var latest *trashedFile
+	for _, tf := range r.trash {
+		if tf.SiaPath == siaPath && (latest == nil || tf.DeleteTime.After(latest.DeleteTime)) {
+			latest = tf
+		}
+	}
+	if latest == nil {
+		return ErrUnknownPath
+	}
+	if _, exists := r.files[siaPath]; exists {
+		return ErrPathOverload
+	}
+	if err := r.restoreTrashedFile(latest); err != nil {
+		return err
+	}
+	return r.saveSync()
+}
+
+// restoreTrashedFile moves a file from the trash back to the siapath it was
+// deleted from and adds it to the directory tree.
+func (r *Renter) restoreTrashedFile(tf *trashedFile) error {
+	f := tf.file
+	f.mu.Lock()
+	f.name = tf.SiaPath
+	err := r.saveFile(f)
+	if err != nil {
+		f.name = trashName(tf.key)
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(filepath.Join(r.persistDir, metadataPath(trashName(tf.key))))
+	if err != nil {
+		r.log.Println("WARN: couldn't remove trashed file:", err)
+	}
+
+	delete(r.trash, tf.key)
+	r.files[tf.SiaPath] = f
+	if tf.Tracking != nil {
+		r.tracking[tf.SiaPath] = *tf.Tracking
+	}
+	r.addFileToDirs(f)
+	return nil
+}
+
+// Trash returns the files in the trash, sorted by siapath and then by the time
+// they were deleted.
+func (r *Renter) Trash() []modules.TrashedFileInfo {
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	var infos []modules.TrashedFileInfo
+	for _, tf := range r.trash {
+		tf.file.mu.RLock()
+		size := tf.file.dataSize()
+		tf.file.mu.RUnlock()
+		infos = append(infos, modules.TrashedFileInfo{
+			SiaPath:    tf.SiaPath,
+			Filesize:   size,
+			DeleteTime: tf.DeleteTime,
+			PurgeTime:  tf.DeleteTime.Add(r.trashRetention),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].SiaPath != infos[j].SiaPath {
+			return infos[i].SiaPath < infos[j].SiaPath
+		}
+		return infos[i].DeleteTime.Before(infos[j].DeleteTime)
+	})
+	return infos
+}
+
+// managedTrashRetention returns how long deleted files are kept in the trash.
+func (r *Renter) managedTrashRetention() time.Duration {
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	return r.trashRetention
+}
+
+// managedSetTrashRetention sets how long deleted files are kept in the trash.
+// Files that have been in the trash for longer than the new retention are
+// purged by the trash loop.
+func (r *Renter) managedSetTrashRetention(retention time.Duration) error {
+	if retention < 0 {
+		return errNegativeTrashRetention
+	}
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	if retention == r.trashRetention {
+		return nil
+	}
+	r.trashRetention = retention
+	return r.saveSync()
+}
+
+// threadedTrashLoop periodically purges the files that have been in the trash
+// for longer than the trash retention.
+func (r *Renter) threadedTrashLoop() {
+	if err := r.tg.Add(); err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.tg.StopChan():
+			return
+		case <-ticker.C:
+		}
+
+		id := r.mu.Lock()
+		if r.purgeExpiredTrash() {
+			if err := r.saveSync(); err != nil {
+				r.log.Println("WARN: could not save the renter after purging the trash:", err)
+			}
+		}
+		r.mu.Unlock(id)
+	}
+}
@@ -0,0 +1,213 @@
+package renter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTrashRestore checks that deleted files are moved to the trash and that
+// they can be restored, also after the renter's persistence is reloaded.
+func TestTrashRestore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	f := newFile("dir/file", ec, pieceSize, pieceSize)
+	id := r.mu.Lock()
+	r.files[f.name] = f
+	r.tracking[f.name] = trackedFile{RepairPath: "/local/file"}
+	r.addFileToDirs(f)
+	err = r.saveFile(f)
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete the file. It should be in the trash and its metadata should
+	// have moved.
+	if err := r.DeleteFile("dir/file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.File("dir/file"); err != ErrUnknownPath {
+		t.Fatal("deleted file is still listed:", err)
+	}
+	trash := r.Trash()
+	if len(trash) != 1 || trash[0].SiaPath != "dir/file" || trash[0].Filesize != pieceSize {
+		t.Fatal("wrong trash:", trash)
+	}
+	if !trash[0].PurgeTime.Equal(trash[0].DeleteTime.Add(defaultTrashRetention)) {
+		t.Fatal("wrong purge time:", trash[0])
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath("dir/file"))); !os.IsNotExist(err) {
+		t.Fatal("metadata of deleted file wasn't moved:", err)
+	}
+	var key string
+	for k := range r.trash {
+		key = k
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(trashName(key)))); err != nil {
+		t.Fatal("metadata of deleted file isn't in the trash:", err)
+	}
+
+	// Reload the renter's persistence. The file should still be in the trash.
+	id = r.mu.Lock()
+	r.files = make(map[string]*file)
+	r.trash = make(map[string]*trashedFile)
+	err = r.load()
+	r.mu.Unlock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.FileList()) != 0 {
+		t.Fatal("trashed file was loaded as a regular file")
+	}
+	if trash := r.Trash(); len(trash) != 1 || trash[0].SiaPath != "dir/file" {
+		t.Fatal("trash wasn't persisted:", trash)
+	}
+
+	// A file can't be restored over an existing file.
+	g := newFile("dir/file", ec, pieceSize, 1)
+	id = r.mu.Lock()
+	r.files[g.name] = g
+	r.mu.Unlock(id)
+	if err := r.RestoreFile("dir/file"); err != ErrPathOverload {
+		t.Fatal("expected ErrPathOverload, got", err)
+	}
+	id = r.mu.Lock()
+	delete(r.files, g.name)
+	r.mu.Unlock(id)
+
+	// Restore the file.
+	if err := r.RestoreFile("dir/file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RestoreFile("dir/file"); err != ErrUnknownPath {
+		t.Fatal("expected ErrUnknownPath, got", err)
+	}
+	info, err := r.File("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Filesize != pieceSize || info.LocalPath != "/local/file" {
+		t.Fatal("file wasn't restored correctly:", info)
+	}
+	if len(r.Trash()) != 0 {
+		t.Fatal("restored file is still in the trash")
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath("dir/file"))); err != nil {
+		t.Fatal("metadata of restored file is missing:", err)
+	}
+}
+
+// TestTrashPurge checks that files are purged once the trash retention has
+// passed, when the trash is emptied, and right away if the trash is disabled.
+func TestTrashPurge(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	ec, _ := NewRSCode(1, 1)
+	addFile := func(name string) *file {
+		f := newFile(name, ec, pieceSize, pieceSize)
+		id := r.mu.Lock()
+		defer r.mu.Unlock(id)
+		r.files[name] = f
+		r.addFileToDirs(f)
+		if err := r.saveFile(f); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	for _, name := range []string{"old", "new"} {
+		addFile(name)
+		if err := r.DeleteFile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the file that has been in the trash for longer than the retention
+	// is purged.
+	id := r.mu.Lock()
+	var old *trashedFile
+	for _, tf := range r.trash {
+		if tf.SiaPath == "old" {
+			old = tf
+		}
+	}
+	old.DeleteTime = old.DeleteTime.Add(-defaultTrashRetention)
+	r.purgeExpiredTrash()
+	r.mu.Unlock(id)
+	if trash := r.Trash(); len(trash) != 1 || trash[0].SiaPath != "new" {
+		t.Fatal("wrong trash after purge:", trash)
+	}
+	if !old.file.deleted {
+		t.Fatal("purged file wasn't marked as deleted")
+	}
+	if _, err := os.Stat(filepath.Join(r.persistDir, metadataPath(trashName(old.key)))); !os.IsNotExist(err) {
+		t.Fatal("metadata of purged file wasn't removed:", err)
+	}
+
+	// Deleting a file with the same siapath keeps the earlier version.
+	// Restoring the siapath restores the most recently deleted version.
+	newer := addFile("new")
+	if err := r.DeleteFile("new"); err != nil {
+		t.Fatal(err)
+	}
+	trash := r.Trash()
+	if len(trash) != 2 || trash[0].SiaPath != "new" || trash[1].SiaPath != "new" || !trash[0].DeleteTime.Before(trash[1].DeleteTime) {
+		t.Fatal("wrong trash after deleting the same siapath twice:", trash)
+	}
+	if err := r.RestoreFile("new"); err != nil {
+		t.Fatal(err)
+	}
+	id = r.mu.Lock()
+	restored := r.files["new"]
+	r.mu.Unlock(id)
+	if restored != newer || len(r.Trash()) != 1 {
+		t.Fatal("the most recently deleted version wasn't restored")
+	}
+	if err := r.DeleteFile("new"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Empty the trash.
+	if err := r.EmptyTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Trash()) != 0 {
+		t.Fatal("trash wasn't emptied")
+	}
+
+	// With the trash disabled, files are purged right away.
+	settings := r.Settings()
+	settings.TrashRetention = 0
+	if err := r.SetSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	f := addFile("file")
+	if err := r.DeleteFile("file"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Trash()) != 0 || !f.deleted {
+		t.Fatal("file wasn't purged with the trash disabled")
+	}
+	settings.TrashRetention = -time.Second
+	if err := r.SetSettings(settings); err != errNegativeTrashRetention {
+		t.Fatal("expected errNegativeTrashRetention, got", err)
+	}
+}
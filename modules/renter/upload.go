@@ -41,6 +41,20 @@ func validateSource(sourcePath string) error {
 	return nil
 }
 
+// checkUploadContracts checks that the renter has enough contracts to upload a
+// file using the provided erasure code.
+func (r *Renter) checkUploadContracts(ec modules.ErasureCoder) error {
+	// We need at least data + parity/2 contracts. NumPieces is equal to
+	// data+parity, and min pieces is equal to parity. Therefore
+	// (NumPieces+MinPieces)/2 = (data+data+parity)/2 = data+parity/2.
+	numContracts := len(r.hostContractor.Contracts())
+	requiredContracts := (ec.NumPieces() + ec.MinPieces()) / 2
+	if numContracts < requiredContracts && build.Release != "testing" {
+		return fmt.Errorf("not enough contracts to upload file: got %v, needed %v", numContracts, requiredContracts)
+	}
+	return nil
+}
+
 // Upload instructs the renter to start tracking a file. The renter will
 // automatically upload and repair tracked files using a background loop.
 func (r *Renter) Upload(up modules.FileUploadParams) error {
@@ -52,6 +66,9 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 	if err := validateSource(up.Source); err != nil {
 		return err
 	}
+	if err := validateFileMetadata(up.Metadata); err != nil {
+		return err
+	}
 
 	// Check for a nickname conflict.
 	lockID := r.mu.RLock()
@@ -70,19 +87,61 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 		up.ErasureCode, _ = NewRSCode(defaultDataPieces, defaultParityPieces)
 	}
 
-	// Check that we have contracts to upload to. We need at least data +
-	// parity/2 contracts. NumPieces is equal to data+parity, and min pieces is
-	// equal to parity. Therefore (NumPieces+MinPieces)/2 = (data+data+parity)/2
-	// = data+parity/2.
-	numContracts := len(r.hostContractor.Contracts())
-	requiredContracts := (up.ErasureCode.NumPieces() + up.ErasureCode.MinPieces()) / 2
-	if numContracts < requiredContracts && build.Release != "testing" {
-		return fmt.Errorf("not enough contracts to upload file: got %v, needed %v", numContracts, (up.ErasureCode.NumPieces()+up.ErasureCode.MinPieces())/2)
+	// Check that we have contracts to upload to.
+	if err := r.checkUploadContracts(up.ErasureCode); err != nil {
+		return err
 	}
 
 	// Create file object.
 	f := newFile(up.SiaPath, up.ErasureCode, pieceSize, uint64(fileInfo.Size()))
 	f.mode = uint32(fileInfo.Mode())
+	f.modTime = fileInfo.ModTime()
+	f.priority = up.Priority
+	f.metadata = copyFileMetadata(up.Metadata)
+
+	// The data of a compressed file is compressed before anything else
+	// happens to it, all further processing deals with the compressed data,
+	// which is staged until it has been uploaded. See compression.go.
+	var stagePath string
+	if up.Compress {
+		if up.Dedup {
+			return errCompressDedup
+		}
+		stagePath, err = r.stageCompressedFile(f, up.Source)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Small files are packed into a shared chunk instead of being uploaded
+	// on their own.
+	if f.size <= packThreshold(up.ErasureCode) {
+		if stagePath != "" {
+			defer os.Remove(stagePath)
+		}
+		return r.managedUploadPacked(f, up.Source, stagePath)
+	}
+
+	// Record the checksums of the file's data, unless they were recorded
+	// while the file was compressed. The chunks of a deduplicated file are
+	// stored in blocks, which are determined by the checksums of the chunks
+	// and only uploaded if the renter doesn't have them yet.
+	if f.compression == nil {
+		checksum, chunkChecksums, segmentChecksums, err := hashFile(up.Source, f.size, f.staticChunkSize(), f.pieceSize)
+		if err != nil {
+			return err
+		}
+		f.checksum = checksum
+		if up.Dedup {
+			if err := r.managedUploadDeduplicated(f, up.Source, chunkChecksums, segmentChecksums); err != nil {
+				return err
+			}
+			r.managedQueueFileChunks(f)
+			return nil
+		}
+		f.chunkChecksums = chunkChecksums
+		f.segmentChecksums = segmentChecksums
+	}
 
 	// Add file to renter.
 	lockID = r.mu.Lock()
@@ -90,6 +149,10 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 	r.tracking[up.SiaPath] = trackedFile{
 		RepairPath: up.Source,
 	}
+	r.addFileToDirs(f)
+	if stagePath != "" {
+		r.addCompressionStage(f, stagePath)
+	}
 	r.saveSync()
 	err = r.saveFile(f)
 	r.mu.Unlock(lockID)
@@ -98,9 +161,24 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 	}
 
 	// Send the upload to the repair loop.
+	r.managedQueueFileChunks(f)
+	return nil
+}
+
+// managedQueueFileChunks adds the unfinished chunks of a file to the upload
+// heap and notifies the repair loop. The chunks of a deduplicated file are
+// the chunks of its blocks.
+func (r *Renter) managedQueueFileChunks(f *file) {
 	hosts := r.managedRefreshHostsAndWorkers()
 	id := r.mu.Lock()
-	unfinishedChunks := r.buildUnfinishedChunks(f, hosts)
+	unfinishedChunks := r.buildUnfinishedChunks(f, hosts, false)
+	queued := make(map[*file]struct{})
+	for _, block := range f.blocks {
+		if _, exists := queued[block]; !exists {
+			queued[block] = struct{}{}
+			unfinishedChunks = append(unfinishedChunks, r.buildUnfinishedChunks(block, hosts, false)...)
+		}
+	}
 	r.mu.Unlock(id)
 	for i := 0; i < len(unfinishedChunks); i++ {
 		r.uploadHeap.managedPush(unfinishedChunks[i])
@@ -109,5 +187,80 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 	case r.uploadHeap.newUploads <- struct{}{}:
 	default:
 	}
+}
+
+// managedSetPaused pauses or resumes the upload of the file at siaPath, or of
+// the whole upload pipeline if siaPath is empty.
+func (r *Renter) managedSetPaused(siaPath string, paused bool) error {
+	id := r.mu.Lock()
+	if siaPath == "" {
+		r.uploadsPaused = paused
+		err := r.saveSync()
+		r.mu.Unlock(id)
+		if err != nil || paused {
+			return err
+		}
+		select {
+		case r.uploadHeap.newUploads <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+
+	f, exists := r.files[siaPath]
+	if !exists {
+		r.mu.Unlock(id)
+		return ErrUnknownPath
+	}
+	f.mu.Lock()
+	f.paused = paused
+	err := r.saveFile(f)
+	f.mu.Unlock()
+	r.mu.Unlock(id)
+	if err != nil || paused {
+		return err
+	}
+
+	// Queue the chunks of the resumed file right away instead of waiting for
+	// the next rebuild of the upload heap. Packed files are uploaded through
+	// their pack, which isn't uploaded while it's open.
+	if f.pack != nil {
+		id = r.mu.RLock()
+		open := r.isOpenPack(f.pack)
+		r.mu.RUnlock(id)
+		if open {
+			return nil
+		}
+		f = f.pack
+	}
+	r.managedQueueFileChunks(f)
 	return nil
 }
+
+// PauseUploads pauses the upload and repair of the file at siaPath, or of the
+// whole upload pipeline if siaPath is empty. Chunks that are already being
+// uploaded are finished.
+func (r *Renter) PauseUploads(siaPath string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	return r.managedSetPaused(siaPath, true)
+}
+
+// ResumeUploads resumes the upload and repair of the file at siaPath, or of
+// the whole upload pipeline if siaPath is empty.
+func (r *Renter) ResumeUploads(siaPath string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	return r.managedSetPaused(siaPath, false)
+}
+
+// UploadsPaused returns whether the whole upload pipeline is paused.
+func (r *Renter) UploadsPaused() bool {
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	return r.uploadsPaused
+}
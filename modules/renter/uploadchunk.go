@@ -1,6 +1,7 @@
 package renter
 
 import (
+	"bytes"
 	"io"
 	"os"
 	"sync"
@@ -25,6 +26,17 @@ type unfinishedUploadChunk struct {
 	localPath  string
 	renterFile *file
 
+	// streamed is true if the logical data of the chunk was read from a
+	// stream before the chunk was added to the upload heap. The memory of a
+	// streamed chunk has already been requested, and its data can't be read
+	// again, so the heap holds on to the chunk while it can't be uploaded.
+	streamed bool
+
+	// sourceFile is the file that the logical data of the chunk is downloaded
+	// from if it isn't renterFile. This is the case for chunks of files whose
+	// redundancy is being changed, see redundancy.go.
+	sourceFile *file
+
 	// Information about the chunk, namely where it exists within the file.
 	//
 	// TODO / NOTE: As we change the file mapper, we're probably going to have
@@ -38,6 +50,8 @@ type unfinishedUploadChunk struct {
 	minimumPieces  int    // number of pieces required to recover the file.
 	offset         int64  // Offset of the chunk within the file.
 	piecesNeeded   int    // number of pieces to achieve a 100% complete upload
+	piecesStart    int    // number of pieces that were complete before the upload started.
+	priority       uint64 // Chunks with a higher priority are uploaded first.
 
 	// The logical data is the data that is presented to the user when the user
 	// requests the chunk. The physical data is all of the pieces that get
@@ -73,6 +87,7 @@ type unfinishedUploadChunk struct {
 	//	+ the worker should decrement the number of pieces registered
 	//	+ the worker should release the memory for the completed piece
 	mu               sync.Mutex
+	completeChan     chan struct{}       // closed once no workers are remaining and no pieces are registered.
 	pieceUsage       []bool              // 'true' if a piece is either uploaded, or a worker is attempting to upload that piece.
 	piecesCompleted  int                 // number of pieces that have been fully uploaded.
 	piecesRegistered int                 // number of pieces that are being uploaded, but aren't finished yet (may fail).
@@ -134,11 +149,15 @@ func (r *Renter) managedDownloadLogicalChunkData(chunk *unfinishedUploadChunk) e
 	}
 
 	// Create the download.
+	source := chunk.renterFile
+	if chunk.sourceFile != nil {
+		source = chunk.sourceFile
+	}
 	buf := NewDownloadDestinationBuffer(chunk.length)
 	d, err := r.managedNewDownload(downloadParams{
 		destination:     buf,
-		destinationType: "buffer",
-		file:            chunk.renterFile,
+		destinationType: destinationTypeRepair,
+		file:            source,
 
 		latencyTarget: 200e3, // No need to rush latency on repair downloads.
 		length:        downloadLength,
@@ -251,7 +270,8 @@ func (r *Renter) managedFetchAndRepairChunk(chunk *unfinishedUploadChunk) {
 			chunk.physicalChunkData[i] = nil
 		} else {
 			// Encrypt the piece.
-			key := deriveKey(chunk.renterFile.masterKey, chunk.index, uint64(i))
+			masterKey, keyIndex := chunk.renterFile.chunkKey(chunk.index)
+			key := deriveKey(masterKey, keyIndex, uint64(i))
 			chunk.physicalChunkData[i] = key.EncryptBytes(chunk.physicalChunkData[i])
 		}
 	}
@@ -276,6 +296,25 @@ func (r *Renter) managedFetchLogicalChunkData(chunk *unfinishedUploadChunk) erro
 	minMissingPiecesToDownload := int(numParityPieces * RemoteRepairDownloadThreshold)
 	download := chunk.piecesCompleted+minMissingPiecesToDownload < chunk.piecesNeeded
 
+	// The logical data of a chunk from a streaming upload has already been
+	// read from the stream.
+	if chunk.logicalChunkData != nil {
+		return nil
+	}
+
+	// The logical data of a chunk whose redundancy is being changed is always
+	// downloaded from the file it was uploaded with. Its checksum is checked
+	// or recorded like the checksum of local data.
+	if chunk.sourceFile != nil {
+		if err := r.managedDownloadLogicalChunkData(chunk); err != nil {
+			return err
+		}
+		if !r.managedVerifyLocalData(chunk, chunk.logicalChunkData) {
+			return errChecksumMismatch
+		}
+		return nil
+	}
+
 	// Download the chunk if it's not on disk.
 	if chunk.localPath == "" && download {
 		return r.managedDownloadLogicalChunkData(chunk)
@@ -301,8 +340,17 @@ func (r *Renter) managedFetchLogicalChunkData(chunk *unfinishedUploadChunk) erro
 	// needing to ignore the EOF errors, because the chunk size should always
 	// match the tail end of the file. Until then, we ignore io.EOF.
 	buf := NewDownloadDestinationBuffer(chunk.length)
-	sr := io.NewSectionReader(osFile, chunk.offset, int64(chunk.length))
-	_, err = buf.ReadFrom(sr)
+	var sr io.Reader = io.NewSectionReader(osFile, chunk.offset, int64(chunk.length))
+	if chunk.renterFile.compression != nil {
+		data := r.managedStagedChunkData(chunk)
+		if data == nil {
+			data, err = compressedData(osFile, chunk.renterFile, uint64(chunk.offset), chunk.length)
+		}
+		sr = bytes.NewReader(data)
+	}
+	if err == nil {
+		_, err = buf.ReadFrom(sr)
+	}
 	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF && download {
 		r.log.Debugln("failed to read file, downloading instead:", err)
 		return r.managedDownloadLogicalChunkData(chunk)
@@ -310,6 +358,15 @@ func (r *Renter) managedFetchLogicalChunkData(chunk *unfinishedUploadChunk) erro
 		r.log.Debugln("failed to read file locally:", err)
 		return errors.Extend(err, errors.New("failed to read file locally"))
 	}
+	// The local copy might have changed since the chunk was uploaded, so its
+	// data is only used if it still matches the checksum of the chunk.
+	if !r.managedVerifyLocalData(chunk, buf) {
+		if download {
+			r.log.Debugln("local copy of chunk changed, downloading instead")
+			return r.managedDownloadLogicalChunkData(chunk)
+		}
+		return errLocalDataChanged
+	}
 	chunk.logicalChunkData = buf
 
 	// Data successfully read from disk.
@@ -374,6 +431,8 @@ func (r *Renter) managedCleanUpUploadChunk(uc *unfinishedUploadChunk) {
 		r.uploadHeap.mu.Lock()
 		delete(r.uploadHeap.activeChunks, uc.id)
 		r.uploadHeap.mu.Unlock()
+		r.managedUpdateStuckStatus(uc)
+		close(uc.completeChan)
 	}
 	// Sanity check - all memory should be released if the chunk is complete.
 	if chunkComplete && totalMemoryReleased != uc.memoryNeeded {
@@ -18,11 +18,13 @@ package renter
 
 import (
 	"container/heap"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
 )
 
 // uploadHeap contains a priority-sorted heap of all the chunks being uploaded
@@ -33,10 +35,16 @@ type uploadHeap struct {
 	// of the workers. A chunk is added to the activeChunks map as soon as it is
 	// added to the uploadHeap, and it is removed from the map as soon as the
 	// last worker completes work on the chunk.
-	activeChunks map[uploadChunkID]struct{}
+	activeChunks map[uploadChunkID]*unfinishedUploadChunk
 	heap         uploadChunkHeap
 	newUploads   chan struct{}
 	mu           sync.Mutex
+
+	// heldChunks contains streamed chunks that were popped while their file
+	// was paused or while there weren't enough workers. Unlike other chunks,
+	// they can't be rebuilt from their file, so they are pushed again the
+	// next time the heap is built.
+	heldChunks []*unfinishedUploadChunk
 }
 
 // uploadChunkHeap is a bunch of priority-sorted chunks that need to be either
@@ -50,6 +58,9 @@ type uploadChunkHeap []*unfinishedUploadChunk
 // Implementation of heap.Interface for uploadChunkHeap.
 func (uch uploadChunkHeap) Len() int { return len(uch) }
 func (uch uploadChunkHeap) Less(i, j int) bool {
+	if uch[i].priority != uch[j].priority {
+		return uch[i].priority > uch[j].priority
+	}
 	return float64(uch[i].piecesCompleted)/float64(uch[i].piecesNeeded) < float64(uch[j].piecesCompleted)/float64(uch[j].piecesNeeded)
 }
 func (uch uploadChunkHeap) Swap(i, j int)       { uch[i], uch[j] = uch[j], uch[i] }
@@ -79,8 +90,8 @@ func (uh *uploadHeap) managedPush(uuc *unfinishedUploadChunk) {
 	uh.mu.Lock()
 	_, exists := uh.activeChunks[ucid]
 	if !exists {
-		uh.activeChunks[ucid] = struct{}{}
-		uh.heap.Push(uuc)
+		uh.activeChunks[ucid] = uuc
+		heap.Push(&uh.heap, uuc)
 	}
 	uh.mu.Unlock()
 }
@@ -95,18 +106,98 @@ func (uh *uploadHeap) managedPop() (uc *unfinishedUploadChunk) {
 	return uc
 }
 
+// managedHold sets a streamed chunk aside until the heap is built again. The
+// chunk stays active, so that it isn't rebuilt from its file in the meantime.
+func (uh *uploadHeap) managedHold(uc *unfinishedUploadChunk) {
+	uh.mu.Lock()
+	uh.heldChunks = append(uh.heldChunks, uc)
+	uh.mu.Unlock()
+}
+
+// managedPushHeld pushes the held chunks back onto the heap.
+func (uh *uploadHeap) managedPushHeld() {
+	uh.mu.Lock()
+	for _, uc := range uh.heldChunks {
+		heap.Push(&uh.heap, uc)
+	}
+	uh.heldChunks = nil
+	uh.mu.Unlock()
+}
+
+// newUnfinishedUploadChunk creates an unfinished chunk for the chunk of f at
+// the given index, which can be uploaded to any of the provided hosts.
+func newUnfinishedUploadChunk(f *file, index uint64, localPath string, hosts map[string]struct{}) *unfinishedUploadChunk {
+	uc := &unfinishedUploadChunk{
+		renterFile: f,
+		localPath:  localPath,
+
+		id: uploadChunkID{
+			fileUID: f.staticUID,
+			index:   index,
+		},
+
+		index:  index,
+		length: f.staticChunkSize(),
+		offset: int64(index * f.staticChunkSize()),
+
+		// memoryNeeded has to also include the logical data, and also
+		// include the overhead for encryption.
+		//
+		// TODO / NOTE: If we adjust the file to have a flexible encryption
+		// scheme, we'll need to adjust the overhead stuff too.
+		//
+		// TODO: Currently we request memory for all of the pieces as well
+		// as the minimum pieces, but we perhaps don't need to request all
+		// of that.
+		memoryNeeded:  f.pieceSize*uint64(f.erasureCode.NumPieces()+f.erasureCode.MinPieces()) + uint64(f.erasureCode.NumPieces()*crypto.TwofishOverhead),
+		minimumPieces: f.erasureCode.MinPieces(),
+		piecesNeeded:  f.erasureCode.NumPieces(),
+
+		physicalChunkData: make([][]byte, f.erasureCode.NumPieces()),
+
+		completeChan: make(chan struct{}),
+		pieceUsage:   make([]bool, f.erasureCode.NumPieces()),
+		unusedHosts:  make(map[string]struct{}),
+	}
+	// Every chunk can have a different set of unused hosts.
+	for host := range hosts {
+		uc.unusedHosts[host] = struct{}{}
+	}
+	return uc
+}
+
 // buildUnfinishedChunks will pull all of the unfinished chunks out of a file.
+// If stuck is true, only the stuck chunks of the file are returned, otherwise
+// only the chunks that are not stuck are returned.
 //
 // TODO / NOTE: This code can be substantially simplified once the files store
 // the HostPubKey instead of the FileContractID, and can be simplified even
 // further once the layout is per-chunk instead of per-filecontract.
-func (r *Renter) buildUnfinishedChunks(f *file, hosts map[string]struct{}) []*unfinishedUploadChunk {
+func (r *Renter) buildUnfinishedChunks(f *file, hosts map[string]struct{}, stuck bool) []*unfinishedUploadChunk {
+	// Paused files are not repaired.
+	priority, paused := r.uploadPriority(f)
+	if paused {
+		return nil
+	}
+
 	// Files are not threadsafe.
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	// If the file is not being tracked, don't repair it.
+	// Packed files are repaired through their pack, deduplicated files
+	// through their blocks.
+	if f.pack != nil || f.blocks != nil {
+		return nil
+	}
+
+	// If the file is not being tracked, don't repair it. Blocks are tracked
+	// through the files that reference them, and they are repaired from the
+	// local copy of one of those files.
 	trackedFile, exists := r.tracking[f.name]
+	localPath, offset := trackedFile.RepairPath, int64(0)
+	if f.dedupBlock {
+		localPath, offset, exists = r.dedupRepairSource(f)
+	}
 	if !exists {
 		return nil
 	}
@@ -124,41 +215,9 @@ func (r *Renter) buildUnfinishedChunks(f *file, hosts map[string]struct{}) []*un
 	chunkCount := f.numChunks()
 	newUnfinishedChunks := make([]*unfinishedUploadChunk, chunkCount)
 	for i := uint64(0); i < chunkCount; i++ {
-		newUnfinishedChunks[i] = &unfinishedUploadChunk{
-			renterFile: f,
-			localPath:  trackedFile.RepairPath,
-
-			id: uploadChunkID{
-				fileUID: f.staticUID,
-				index:   i,
-			},
-
-			index:  i,
-			length: f.staticChunkSize(),
-			offset: int64(i * f.staticChunkSize()),
-
-			// memoryNeeded has to also include the logical data, and also
-			// include the overhead for encryption.
-			//
-			// TODO / NOTE: If we adjust the file to have a flexible encryption
-			// scheme, we'll need to adjust the overhead stuff too.
-			//
-			// TODO: Currently we request memory for all of the pieces as well
-			// as the minimum pieces, but we perhaps don't need to request all
-			// of that.
-			memoryNeeded:  f.pieceSize*uint64(f.erasureCode.NumPieces()+f.erasureCode.MinPieces()) + uint64(f.erasureCode.NumPieces()*crypto.TwofishOverhead),
-			minimumPieces: f.erasureCode.MinPieces(),
-			piecesNeeded:  f.erasureCode.NumPieces(),
-
-			physicalChunkData: make([][]byte, f.erasureCode.NumPieces()),
-
-			pieceUsage:  make([]bool, f.erasureCode.NumPieces()),
-			unusedHosts: make(map[string]struct{}),
-		}
-		// Every chunk can have a different set of unused hosts.
-		for host := range hosts {
-			newUnfinishedChunks[i].unusedHosts[host] = struct{}{}
-		}
+		newUnfinishedChunks[i] = newUnfinishedUploadChunk(f, i, localPath, hosts)
+		newUnfinishedChunks[i].offset += offset
+		newUnfinishedChunks[i].priority = priority
 	}
 
 	// Iterate through the contracts of the file and mark which hosts are
@@ -203,6 +262,31 @@ func (r *Renter) buildUnfinishedChunks(f *file, hosts map[string]struct{}) []*un
 			}
 		}
 	}
+
+	// Remember how many pieces each chunk had before the repair, so that
+	// managedUpdateStuckStatus can tell whether the repair made progress.
+	for i := 0; i < len(newUnfinishedChunks); i++ {
+		newUnfinishedChunks[i].piecesStart = newUnfinishedChunks[i].piecesCompleted
+	}
+
+	// Iterate through the set of newUnfinishedChunks and remove any that are
+	// completed. Stuck chunks that are complete have recovered without being
+	// repaired, for example because their hosts came back online, and are no
+	// longer stuck.
+	var recovered []uint64
+	incompleteChunks := newUnfinishedChunks[:0]
+	for i := 0; i < len(newUnfinishedChunks); i++ {
+		if f.isStuck(newUnfinishedChunks[i].index) != stuck {
+			continue
+		}
+		if newUnfinishedChunks[i].piecesCompleted < newUnfinishedChunks[i].piecesNeeded {
+			incompleteChunks = append(incompleteChunks, newUnfinishedChunks[i])
+		} else if stuck {
+			f.setStuck(newUnfinishedChunks[i].index, false)
+			recovered = append(recovered, newUnfinishedChunks[i].index)
+		}
+	}
+
 	// If 'saveFile' is marked, it means we deleted some dead contracts and
 	// cleaned up the file a bit. Save the file to clean up some space on disk
 	// and prevent the same work from being repeated after the next restart.
@@ -214,14 +298,11 @@ func (r *Renter) buildUnfinishedChunks(f *file, hosts map[string]struct{}) []*un
 		if err != nil {
 			r.log.Println("error while saving a file after pruning some contracts from it:", err)
 		}
-	}
-
-	// Iterate through the set of newUnfinishedChunks and remove any that are
-	// completed.
-	incompleteChunks := newUnfinishedChunks[:0]
-	for i := 0; i < len(newUnfinishedChunks); i++ {
-		if newUnfinishedChunks[i].piecesCompleted < newUnfinishedChunks[i].piecesNeeded {
-			incompleteChunks = append(incompleteChunks, newUnfinishedChunks[i])
+	} else {
+		for _, index := range recovered {
+			if err := r.saveChunk(f, index); err != nil {
+				r.log.Println("WARN: couldn't save the stuck status of a chunk:", err)
+			}
 		}
 	}
 	// TODO: Don't return chunks that can't be downloaded, uploaded or otherwise
@@ -229,14 +310,71 @@ func (r *Renter) buildUnfinishedChunks(f *file, hosts map[string]struct{}) []*un
 	return incompleteChunks
 }
 
+// uploadPriority returns the upload priority of the chunks of f and whether
+// their upload is paused. A pack is uploaded with the highest priority of the
+// files it stores, and it is only paused if all of its files are paused. The
+// same goes for blocks and the files that reference them. The renter's lock
+// needs to be held by the caller, the lock of f must not be held.
+func (r *Renter) uploadPriority(f *file) (priority uint64, paused bool) {
+	if f.dedupBlock {
+		return r.dedupPriority(f)
+	}
+	if _, isPack := r.packs[f.name]; !isPack {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+		return f.priority, f.paused
+	}
+	paused = true
+	for pf := range f.packRefs {
+		pf.mu.RLock()
+		if !pf.paused {
+			paused = false
+			if pf.priority > priority {
+				priority = pf.priority
+			}
+		}
+		pf.mu.RUnlock()
+	}
+	return priority, paused && len(f.packRefs) > 0
+}
+
+// managedChunkPaused returns whether the upload of the chunk has been paused
+// since it was added to the upload heap.
+func (r *Renter) managedChunkPaused(uc *unfinishedUploadChunk) bool {
+	id := r.mu.RLock()
+	defer r.mu.RUnlock(id)
+	_, paused := r.uploadPriority(uc.renterFile)
+	return paused
+}
+
 // managedBuildChunkHeap will iterate through all of the files in the renter and
-// construct a chunk heap.
-func (r *Renter) managedBuildChunkHeap(hosts map[string]struct{}) {
+// construct a chunk heap. If stuck is true, only the stuck chunks are added to
+// the heap, otherwise only the chunks that are not stuck are added.
+func (r *Renter) managedBuildChunkHeap(hosts map[string]struct{}, stuck bool) {
+	// Give the held streamed chunks another chance.
+	r.uploadHeap.managedPushHeld()
+
 	// Loop through the whole set of files and get a list of chunks to add to
 	// the heap.
 	id := r.mu.Lock()
 	for _, file := range r.files {
-		unfinishedUploadChunks := r.buildUnfinishedChunks(file, hosts)
+		unfinishedUploadChunks := r.buildUnfinishedChunks(file, hosts, stuck)
+		for i := 0; i < len(unfinishedUploadChunks); i++ {
+			r.uploadHeap.managedPush(unfinishedUploadChunks[i])
+		}
+	}
+	// Open packs are uploaded once they are sealed.
+	for _, pack := range r.packs {
+		if r.isOpenPack(pack) {
+			continue
+		}
+		unfinishedUploadChunks := r.buildUnfinishedChunks(pack, hosts, stuck)
+		for i := 0; i < len(unfinishedUploadChunks); i++ {
+			r.uploadHeap.managedPush(unfinishedUploadChunks[i])
+		}
+	}
+	for _, block := range r.dedupBlocks {
+		unfinishedUploadChunks := r.buildUnfinishedChunks(block, hosts, stuck)
 		for i := 0; i < len(unfinishedUploadChunks); i++ {
 			r.uploadHeap.managedPush(unfinishedUploadChunks[i])
 		}
@@ -244,6 +382,88 @@ func (r *Renter) managedBuildChunkHeap(hosts map[string]struct{}) {
 	r.mu.Unlock(id)
 }
 
+// repairThreshold returns the number of pieces that a repair of the chunk
+// needs to reach to count as successful.
+func (uc *unfinishedUploadChunk) repairThreshold() int {
+	return uc.minimumPieces + (uc.piecesNeeded-uc.minimumPieces)/repairThresholdDivisor
+}
+
+// managedUpdateStuckStatus records the outcome of an attempt to repair a
+// chunk. A chunk that fails to be repaired maxRepairAttempts times in a row is
+// marked as stuck, a chunk that is repaired successfully is no longer stuck.
+// The attempt failed if it didn't upload any pieces or if the chunk stayed
+// below its repair threshold. A chunk that can't reach full redundancy, for
+// example because there are fewer hosts than pieces, isn't marked as stuck
+// as long as it is above the threshold.
+func (r *Renter) managedUpdateStuckStatus(uc *unfinishedUploadChunk) {
+	uc.mu.Lock()
+	repaired := uc.piecesCompleted >= uc.piecesNeeded ||
+		(uc.piecesCompleted > uc.piecesStart && uc.piecesCompleted >= uc.repairThreshold())
+	uc.mu.Unlock()
+
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f := uc.renterFile
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleted {
+		return
+	}
+	stuck := f.isStuck(uc.index)
+	if repaired {
+		delete(f.repairFailures, uc.index)
+		stuck = false
+	} else {
+		if f.repairFailures == nil {
+			f.repairFailures = make(map[uint64]int)
+		}
+		f.repairFailures[uc.index]++
+		stuck = stuck || f.repairFailures[uc.index] >= maxRepairAttempts
+	}
+	if !f.setStuck(uc.index, stuck) {
+		return
+	}
+	if stuck {
+		r.log.Debugf("Chunk %v of %v is stuck after %v failed repairs", uc.index, f.name, f.repairFailures[uc.index])
+	}
+	if err := r.saveChunk(f, uc.index); err != nil {
+		r.log.Println("WARN: couldn't save the stuck status of a chunk:", err)
+	}
+}
+
+// RepairQueue returns the chunks that are currently queued for repair or
+// being repaired, least healthy first.
+func (r *Renter) RepairQueue() []modules.RepairChunkInfo {
+	r.uploadHeap.mu.Lock()
+	chunks := make([]*unfinishedUploadChunk, 0, len(r.uploadHeap.activeChunks))
+	for _, uc := range r.uploadHeap.activeChunks {
+		chunks = append(chunks, uc)
+	}
+	r.uploadHeap.mu.Unlock()
+
+	queue := make([]modules.RepairChunkInfo, 0, len(chunks))
+	for _, uc := range chunks {
+		uc.mu.Lock()
+		info := modules.RepairChunkInfo{
+			ChunkIndex:      uc.index,
+			Health:          float64(uc.piecesCompleted) / float64(uc.minimumPieces),
+			PiecesCompleted: uint64(uc.piecesCompleted),
+			PiecesNeeded:    uint64(uc.piecesNeeded),
+		}
+		uc.mu.Unlock()
+		uc.renterFile.mu.RLock()
+		info.SiaPath = uc.renterFile.name
+		info.FailedAttempts = uint64(uc.renterFile.repairFailures[uc.index])
+		info.Stuck = uc.renterFile.isStuck(uc.index)
+		uc.renterFile.mu.RUnlock()
+		queue = append(queue, info)
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		return queue[i].Health < queue[j].Health
+	})
+	return queue
+}
+
 // managedPrepareNextChunk takes the next chunk from the chunk heap and prepares
 // it for upload. Preparation includes blocking until enough memory is
 // available, fetching the logical data for the chunk (either from the disk or
@@ -252,8 +472,9 @@ func (r *Renter) managedBuildChunkHeap(hosts map[string]struct{}) {
 func (r *Renter) managedPrepareNextChunk(uuc *unfinishedUploadChunk, hosts map[string]struct{}) {
 	// Grab the next chunk, loop until we have enough memory, update the amount
 	// of memory available, and then spin up a thread to asynchronously handle
-	// the rest of the chunk tasks.
-	if !r.memoryManager.Request(uuc.memoryNeeded, memoryPriorityLow) {
+	// the rest of the chunk tasks. The memory of streamed chunks was requested
+	// before their data was read.
+	if !uuc.streamed && !r.memoryManager.Request(uuc.memoryNeeded, memoryPriorityLow) {
 		return
 	}
 	// Fetch the chunk in a separate goroutine, as it can take a long time and
@@ -296,6 +517,16 @@ func (r *Renter) threadedUploadLoop() {
 			return
 		}
 
+		// Wait until the upload pipeline is resumed if it is paused.
+		if r.UploadsPaused() {
+			select {
+			case <-r.uploadHeap.newUploads:
+			case <-r.tg.StopChan():
+				return
+			}
+			continue
+		}
+
 		// Refresh the worker pool and get the set of hosts that are currently
 		// useful for uploading.
 		hosts := r.managedRefreshHostsAndWorkers()
@@ -305,7 +536,8 @@ func (r *Renter) threadedUploadLoop() {
 		// TODO: After replacing the filesystem to resemble a tree, we'll be
 		// able to go through the filesystem piecewise instead of doing
 		// everything all at once.
-		r.managedBuildChunkHeap(hosts)
+		r.managedBuildChunkHeap(hosts, false)
+		r.managedRefreshDirMetadata()
 		r.uploadHeap.mu.Lock()
 		heapLen := r.uploadHeap.heap.Len()
 		r.uploadHeap.mu.Unlock()
@@ -325,8 +557,9 @@ func (r *Renter) threadedUploadLoop() {
 			default:
 			}
 
-			// Break to the outer loop if not online.
-			if !r.g.Online() {
+			// Break to the outer loop if not online or if the upload
+			// pipeline was paused.
+			if !r.g.Online() || r.UploadsPaused() {
 				break
 			}
 
@@ -344,6 +577,23 @@ func (r *Renter) threadedUploadLoop() {
 			availableWorkers := len(r.workerPool)
 			r.mu.RUnlock(id)
 			if availableWorkers < nextChunk.minimumPieces {
+				if nextChunk.streamed {
+					r.uploadHeap.managedHold(nextChunk)
+				}
+				continue
+			}
+
+			// Drop the chunk if its file was paused. It is added to the heap
+			// again once the file is resumed. Streamed chunks are held
+			// instead, since they can't be rebuilt from their file.
+			if r.managedChunkPaused(nextChunk) {
+				if nextChunk.streamed {
+					r.uploadHeap.managedHold(nextChunk)
+					continue
+				}
+				r.uploadHeap.mu.Lock()
+				delete(r.uploadHeap.activeChunks, nextChunk.id)
+				r.uploadHeap.mu.Unlock()
 				continue
 			}
 
@@ -366,3 +616,35 @@ func (r *Renter) threadedUploadLoop() {
 		}
 	}
 }
+
+// threadedStuckLoop is a background thread that periodically retries the
+// repair of the chunks that are stuck. Stuck chunks are excluded from the
+// regular repairs, so that chunks that can't be repaired right now don't
+// keep the renter from repairing other chunks.
+func (r *Renter) threadedStuckLoop() {
+	err := r.tg.Add()
+	if err != nil {
+		return
+	}
+	defer r.tg.Done()
+
+	for {
+		select {
+		case <-r.tg.StopChan():
+			return
+		case <-time.After(stuckChunkRetryInterval):
+		}
+		if !r.g.Online() {
+			continue
+		}
+
+		// Add the stuck chunks to the upload heap and wake up the upload
+		// loop.
+		hosts := r.managedRefreshHostsAndWorkers()
+		r.managedBuildChunkHeap(hosts, true)
+		select {
+		case r.uploadHeap.newUploads <- struct{}{}:
+		default:
+		}
+	}
+}
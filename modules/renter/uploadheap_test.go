@@ -0,0 +1,229 @@
+package renter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/persist"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestUploadHeapPriority checks that chunks with a higher priority are popped
+// from the upload heap first, and that chunks with the same priority are
+// popped by their upload progress.
+func TestUploadHeapPriority(t *testing.T) {
+	uh := uploadHeap{
+		activeChunks: make(map[uploadChunkID]*unfinishedUploadChunk),
+	}
+	f := newTestingFileWithPieces(4, 2)
+	chunks := []struct {
+		priority        uint64
+		piecesCompleted int
+	}{{0, 0}, {5, 2}, {5, 1}, {1, 0}}
+	for i, c := range chunks {
+		uc := newUnfinishedUploadChunk(f, uint64(i), "", nil)
+		uc.priority = c.priority
+		uc.piecesCompleted = c.piecesCompleted
+		uh.managedPush(uc)
+	}
+	for _, index := range []uint64{2, 1, 3, 0} {
+		uc := uh.managedPop()
+		if uc == nil || uc.index != index {
+			t.Fatal("chunks were popped in the wrong order, expected chunk", index)
+		}
+	}
+}
+
+// TestPauseUploads checks that files and the whole upload pipeline can be
+// paused and resumed, and that a pack is only paused if all of its files are
+// paused.
+func TestPauseUploads(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	f := newTestingFileWithPieces(3, 2)
+	if err := r.saveFile(f); err != nil {
+		t.Fatal(err)
+	}
+	id := r.mu.Lock()
+	r.files[f.name] = f
+	r.mu.Unlock(id)
+
+	// Pause the file. The paused status is persisted.
+	if err := r.PauseUploads("unknown"); err != ErrUnknownPath {
+		t.Fatal("expected ErrUnknownPath, got", err)
+	}
+	if err := r.PauseUploads(f.name); err != nil {
+		t.Fatal(err)
+	}
+	uc := newUnfinishedUploadChunk(f, 0, "", nil)
+	if !r.managedChunkPaused(uc) {
+		t.Fatal("chunk of paused file isn't paused")
+	}
+	if fi, err := r.File(f.name); err != nil || !fi.UploadPaused {
+		t.Fatal("file info doesn't show the file as paused:", err)
+	}
+	loaded, err := loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !loaded.paused {
+		t.Fatal("paused status wasn't persisted")
+	}
+	if err := r.ResumeUploads(f.name); err != nil {
+		t.Fatal(err)
+	}
+	if r.managedChunkPaused(uc) {
+		t.Fatal("chunk of resumed file is paused")
+	}
+
+	// Pause the whole upload pipeline.
+	if err := r.PauseUploads(""); err != nil {
+		t.Fatal(err)
+	}
+	if !r.UploadsPaused() {
+		t.Fatal("uploads weren't paused")
+	}
+	var data struct {
+		UploadsPaused bool
+	}
+	if err := persist.LoadJSON(saveMetadata, &data, filepath.Join(r.persistDir, PersistFilename)); err != nil {
+		t.Fatal(err)
+	} else if !data.UploadsPaused {
+		t.Fatal("paused uploads weren't persisted")
+	}
+	if err := r.ResumeUploads(""); err != nil {
+		t.Fatal(err)
+	}
+	if r.UploadsPaused() {
+		t.Fatal("uploads weren't resumed")
+	}
+
+	// Pack two small files. The pack uses the highest priority of the files
+	// that aren't paused.
+	dir := build.TempDir("renter", t.Name(), "sources")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := NewRSCode(1, 1)
+	for _, siaPath := range []string{"small1", "small2"} {
+		if err := uploadTestFile(r, dir, fastrand.Bytes(100), modules.FileUploadParams{SiaPath: siaPath, ErasureCode: ec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id = r.mu.Lock()
+	small1, small2 := r.files["small1"], r.files["small2"]
+	small1.priority, small2.priority = 3, 7
+	pack := small1.pack
+	r.mu.Unlock(id)
+	packPriority := func() (uint64, bool) {
+		id := r.mu.RLock()
+		defer r.mu.RUnlock(id)
+		return r.uploadPriority(pack)
+	}
+	if priority, paused := packPriority(); priority != 7 || paused {
+		t.Fatal("wrong priority of pack:", priority, paused)
+	}
+	if err := r.PauseUploads("small2"); err != nil {
+		t.Fatal(err)
+	}
+	if priority, paused := packPriority(); priority != 3 || paused {
+		t.Fatal("wrong priority of pack:", priority, paused)
+	}
+	if err := r.PauseUploads("small1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, paused := packPriority(); !paused {
+		t.Fatal("pack isn't paused after all of its files were paused")
+	}
+}
+
+// TestChunkStuckStatus checks that a chunk is marked as stuck after
+// maxRepairAttempts failed repairs, that the stuck status is persisted, and
+// that a successful repair clears it. Repairs that make progress and reach the
+// repair threshold are successful even below full redundancy.
+func TestChunkStuckStatus(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+
+	f := newTestingFileWithPieces(3, 2)
+	if err := r.saveFile(f); err != nil {
+		t.Fatal(err)
+	}
+	id := r.mu.Lock()
+	r.files[f.name] = f
+	r.mu.Unlock(id)
+
+	// Fail to repair the second chunk. No pieces are uploaded to the missing
+	// hosts.
+	uc := newUnfinishedUploadChunk(f, 1, "", nil)
+	uc.piecesStart = 2
+	uc.piecesCompleted = 2
+	for i := 0; i < maxRepairAttempts; i++ {
+		if f.numStuckChunks() != 0 {
+			t.Fatal("chunk is stuck after", i, "failed repairs")
+		}
+		r.managedUpdateStuckStatus(uc)
+	}
+	if f.numStuckChunks() != 1 || !f.isStuck(1) {
+		t.Fatal("chunk isn't stuck after", maxRepairAttempts, "failed repairs")
+	}
+	fi, err := r.File(f.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.StuckChunks != 1 {
+		t.Fatal("wrong number of stuck chunks in file info:", fi.StuckChunks)
+	}
+	loaded, err := loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.numStuckChunks() != 1 || !loaded.isStuck(1) {
+		t.Fatal("stuck status wasn't persisted")
+	}
+
+	// A repair that makes progress but stays below the repair threshold is
+	// still a failure.
+	uc.piecesStart = uc.minimumPieces - 1
+	uc.piecesCompleted = uc.repairThreshold() - 1
+	r.managedUpdateStuckStatus(uc)
+	if !f.isStuck(1) || f.repairFailures[1] != maxRepairAttempts+1 {
+		t.Fatal("repair below the repair threshold wasn't counted as a failure")
+	}
+
+	// A repair that makes progress and reaches the repair threshold clears
+	// the stuck status and the failed repairs, even if the chunk isn't fully
+	// redundant.
+	uc.piecesCompleted = uc.repairThreshold()
+	if uc.piecesCompleted >= uc.piecesNeeded {
+		t.Fatal("repair threshold should be below full redundancy")
+	}
+	r.managedUpdateStuckStatus(uc)
+	if f.numStuckChunks() != 0 || f.repairFailures[1] != 0 {
+		t.Fatal("chunk is still stuck after it was repaired")
+	}
+	loaded, err = loadFile(filepath.Join(r.persistDir, metadataPath(f.name)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.numStuckChunks() != 0 {
+		t.Fatal("stuck status wasn't cleared on disk")
+	}
+}
@@ -0,0 +1,152 @@
+package renter
+
+// uploadquote.go quotes the cost of an upload before any data is committed.
+// Unlike PriceEstimation, which averages the prices of random hosts from the
+// hostdb, a quote uses the current prices of the hosts that the renter can
+// upload to, the erasure code of the upload and the duration that the data is
+// stored for.
+//
+// Files are split into chunks that are padded to the full chunk size, and every
+// chunk is uploaded as NumPieces sectors. Small files are packed with other
+// files, so they only pay for their share of a chunk. The pieces are spread
+// across the hosts, so every uploaded byte is priced at the average price of
+// the hosts.
+//
+// Contracts end with the allowance period and are renewed for as long as the
+// data is stored. Every renewal costs the contract price of the hosts that
+// store the data. The siafund fee is charged on all of the funds that are put
+// into contracts to pay for the upload.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+var (
+	// errNoQuoteDuration is returned when an upload is quoted without a
+	// duration while the renter has no allowance.
+	errNoQuoteDuration = errors.New("a duration is required to quote an upload without an allowance")
+
+	// errNoQuoteHosts is returned when an upload is quoted while the renter
+	// has no contracts with hosts that it can upload to.
+	errNoQuoteHosts = errors.New("no contracts with hosts that can be uploaded to")
+)
+
+// quoteUploadSize returns the number of bytes that are uploaded to the hosts
+// for a file of the provided size, including redundancy and padding.
+func quoteUploadSize(size uint64, ec modules.ErasureCoder) uint64 {
+	if size <= packThreshold(ec) {
+		return size * uint64(ec.NumPieces()) / uint64(ec.MinPieces())
+	}
+	chunkSize := pieceSize * uint64(ec.MinPieces())
+	numChunks := (size + chunkSize - 1) / chunkSize
+	return numChunks * modules.SectorSize * uint64(ec.NumPieces())
+}
+
+// quoteSourceSize returns the size of the file or of all of the files in the
+// directory at source, and the number of bytes that are uploaded to the hosts
+// for them.
+func quoteSourceSize(source string, ec modules.ErasureCoder) (size, uploadSize uint64, err error) {
+	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		size += uint64(info.Size())
+		uploadSize += quoteUploadSize(uint64(info.Size()), ec)
+		return nil
+	})
+	return size, uploadSize, err
+}
+
+// managedQuoteHosts returns the hosts of the contracts that the renter can
+// upload to.
+func (r *Renter) managedQuoteHosts() []modules.HostDBEntry {
+	var hosts []modules.HostDBEntry
+	for _, c := range r.hostContractor.Contracts() {
+		if utility, exists := r.hostContractor.ContractUtility(c.ID); !exists || !utility.GoodForUpload {
+			continue
+		}
+		host, exists := r.hostDB.Host(c.HostPublicKey)
+		if !exists {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// UploadQuote quotes the cost of an upload with the current prices of the
+// hosts that the renter can upload to, without uploading any data.
+func (r *Renter) UploadQuote(qp modules.UploadQuoteParams) (modules.RenterUploadQuote, error) {
+	if err := r.tg.Add(); err != nil {
+		return modules.RenterUploadQuote{}, err
+	}
+	defer r.tg.Done()
+	if qp.ErasureCode == nil {
+		qp.ErasureCode, _ = NewRSCode(defaultDataPieces, defaultParityPieces)
+	}
+
+	// Determine the amount of data that is uploaded to the hosts.
+	quote := modules.RenterUploadQuote{
+		Filesize: qp.Filesize,
+		Duration: qp.Duration,
+	}
+	if qp.Source != "" {
+		var err error
+		quote.Filesize, quote.UploadSize, err = quoteSourceSize(qp.Source, qp.ErasureCode)
+		if err != nil {
+			return modules.RenterUploadQuote{}, err
+		}
+	} else {
+		quote.UploadSize = quoteUploadSize(qp.Filesize, qp.ErasureCode)
+	}
+	period := r.hostContractor.Allowance().Period
+	if quote.Duration == 0 {
+		if period == 0 {
+			return modules.RenterUploadQuote{}, errNoQuoteDuration
+		}
+		quote.Duration = period
+	}
+
+	// Price the upload with the average prices of the hosts.
+	hosts := r.managedQuoteHosts()
+	if len(hosts) == 0 {
+		return modules.RenterUploadQuote{}, errNoQuoteHosts
+	}
+	quote.Hosts = uint64(len(hosts))
+	var storagePrice, uploadPrice, contractPrice types.Currency
+	for _, host := range hosts {
+		storagePrice = storagePrice.Add(host.StoragePrice)
+		uploadPrice = uploadPrice.Add(host.UploadBandwidthPrice)
+		contractPrice = contractPrice.Add(host.ContractPrice)
+	}
+	quote.StorageCost = storagePrice.Mul64(quote.UploadSize).Mul64(uint64(quote.Duration)).Div64(quote.Hosts)
+	quote.UploadCost = uploadPrice.Mul64(quote.UploadSize).Div64(quote.Hosts)
+
+	// The contracts of the current period are paid for already. The data is
+	// stored by at most NumPieces hosts.
+	var renewals uint64
+	if period != 0 {
+		renewals = uint64((quote.Duration - 1) / period)
+	}
+	storingHosts := uint64(qp.ErasureCode.NumPieces())
+	if storingHosts > quote.Hosts {
+		storingHosts = quote.Hosts
+	}
+	quote.ContractFees = contractPrice.Mul64(renewals * storingHosts).Div64(quote.Hosts)
+
+	funds := quote.StorageCost.Add(quote.UploadCost).Add(quote.ContractFees)
+	quote.SiafundFees = types.Tax(r.cs.Height(), funds)
+	quote.TotalCost = funds.Add(quote.SiafundFees)
+
+	quote.RemainingAllowance = r.hostContractor.PeriodSpending().Unspent
+	quote.WithinAllowance = quote.TotalCost.Cmp(quote.RemainingAllowance) <= 0
+	return quote, nil
+}
@@ -0,0 +1,190 @@
+package renter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// quoteContractor is a hostContractor with a fixed allowance, spending and set
+// of contracts.
+type quoteContractor struct {
+	hostContractor
+	allowance modules.Allowance
+	contracts []modules.RenterContract
+	utilities map[types.FileContractID]modules.ContractUtility
+	spending  modules.ContractorSpending
+}
+
+func (qc quoteContractor) Allowance() modules.Allowance               { return qc.allowance }
+func (qc quoteContractor) Contracts() []modules.RenterContract        { return qc.contracts }
+func (qc quoteContractor) PeriodSpending() modules.ContractorSpending { return qc.spending }
+func (qc quoteContractor) ContractUtility(id types.FileContractID) (modules.ContractUtility, bool) {
+	u, exists := qc.utilities[id]
+	return u, exists
+}
+
+// quoteHostDB is a hostDB that knows a fixed set of hosts.
+type quoteHostDB struct {
+	stubHostDB
+	hosts map[string]modules.HostDBEntry
+}
+
+func (qh quoteHostDB) Host(spk types.SiaPublicKey) (modules.HostDBEntry, bool) {
+	host, exists := qh.hosts[spk.String()]
+	return host, exists
+}
+
+// TestQuoteUploadSize checks the amount of data that is uploaded to the hosts
+// for files of different sizes.
+func TestQuoteUploadSize(t *testing.T) {
+	ec, _ := NewRSCode(2, 4)
+	chunkSize := 2 * pieceSize
+	tests := []struct {
+		size, uploadSize uint64
+	}{
+		// Small files are packed and pay for their share of a chunk.
+		{0, 0},
+		{300, 900},
+		{packThreshold(ec), 3 * packThreshold(ec)},
+		// Other files are padded to full chunks.
+		{packThreshold(ec) + 1, 6 * modules.SectorSize},
+		{chunkSize, 6 * modules.SectorSize},
+		{chunkSize + 1, 12 * modules.SectorSize},
+	}
+	for _, test := range tests {
+		if uploadSize := quoteUploadSize(test.size, ec); uploadSize != test.uploadSize {
+			t.Errorf("size %v: expected %v, got %v", test.size, test.uploadSize, uploadSize)
+		}
+	}
+
+	// The sizes of the files in a directory are added up.
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 300), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "b"), make([]byte, 600), 0600); err != nil {
+		t.Fatal(err)
+	}
+	size, uploadSize, err := quoteSourceSize(dir, ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 900 || uploadSize != 2700 {
+		t.Fatal("wrong size of directory:", size, uploadSize)
+	}
+	if _, _, err := quoteSourceSize(filepath.Join(dir, "dne"), ec); !os.IsNotExist(err) {
+		t.Fatal("expected missing source to fail, got", err)
+	}
+}
+
+// TestUploadQuote checks that uploads are quoted with the prices of the hosts
+// that the renter can upload to.
+func TestUploadQuote(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rt.Close()
+	r := rt.renter
+	ec, _ := NewRSCode(1, 1)
+
+	// Without an allowance, a duration is required. Without contracts, there
+	// are no prices.
+	if _, err := r.UploadQuote(modules.UploadQuoteParams{Filesize: 100, ErasureCode: ec}); err != errNoQuoteDuration {
+		t.Fatal("expected errNoQuoteDuration, got", err)
+	}
+	if _, err := r.UploadQuote(modules.UploadQuoteParams{Filesize: 100, ErasureCode: ec, Duration: 10}); err != errNoQuoteHosts {
+		t.Fatal("expected errNoQuoteHosts, got", err)
+	}
+
+	// Give the renter two hosts it can upload to and one it can't.
+	qc := quoteContractor{
+		hostContractor: r.hostContractor,
+		allowance:      modules.Allowance{Period: 10},
+		utilities:      make(map[types.FileContractID]modules.ContractUtility),
+		spending:       modules.ContractorSpending{Unspent: types.SiacoinPrecision},
+	}
+	qh := quoteHostDB{hosts: make(map[string]modules.HostDBEntry)}
+	prices := []struct {
+		storage, upload, contract uint64
+		goodForUpload             bool
+	}{
+		{1, 2, 10, true},
+		{3, 4, 30, true},
+		{1000, 1000, 1000, false},
+	}
+	for i, p := range prices {
+		var host modules.HostDBEntry
+		host.PublicKey.Key = []byte{byte(i)}
+		host.StoragePrice = types.NewCurrency64(p.storage)
+		host.UploadBandwidthPrice = types.NewCurrency64(p.upload)
+		host.ContractPrice = types.NewCurrency64(p.contract)
+		qh.hosts[host.PublicKey.String()] = host
+		c := newShareContract(host.PublicKey)
+		qc.contracts = append(qc.contracts, c)
+		qc.utilities[c.ID] = modules.ContractUtility{GoodForUpload: p.goodForUpload}
+	}
+	r.hostContractor = qc
+	r.hostDB = qh
+
+	// The file has two chunks, which are uploaded as two sectors each. The
+	// data is stored for two periods after the current one.
+	quote, err := r.UploadQuote(modules.UploadQuoteParams{Filesize: pieceSize + 1, ErasureCode: ec, Duration: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadSize := 4 * modules.SectorSize
+	if quote.Filesize != pieceSize+1 || quote.UploadSize != uploadSize || quote.Duration != 25 || quote.Hosts != 2 {
+		t.Fatal("wrong quote:", quote)
+	}
+	if !quote.StorageCost.Equals(types.NewCurrency64(2 * uploadSize * 25)) {
+		t.Fatal("wrong storage cost:", quote.StorageCost)
+	}
+	if !quote.UploadCost.Equals(types.NewCurrency64(3 * uploadSize)) {
+		t.Fatal("wrong upload cost:", quote.UploadCost)
+	}
+	if !quote.ContractFees.Equals(types.NewCurrency64(80)) {
+		t.Fatal("wrong contract fees:", quote.ContractFees)
+	}
+	funds := quote.StorageCost.Add(quote.UploadCost).Add(quote.ContractFees)
+	if !quote.SiafundFees.Equals(types.Tax(rt.cs.Height(), funds)) || quote.SiafundFees.IsZero() {
+		t.Fatal("wrong siafund fees:", quote.SiafundFees)
+	}
+	if !quote.TotalCost.Equals(funds.Add(quote.SiafundFees)) {
+		t.Fatal("wrong total cost:", quote.TotalCost)
+	}
+	if !quote.RemainingAllowance.Equals(types.SiacoinPrecision) || !quote.WithinAllowance {
+		t.Fatal("upload should be within the allowance:", quote.RemainingAllowance)
+	}
+
+	// Without a duration, the data is stored for the allowance period, which
+	// doesn't require any renewals.
+	quote, err = r.UploadQuote(modules.UploadQuoteParams{Filesize: pieceSize + 1, ErasureCode: ec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Duration != 10 || !quote.ContractFees.IsZero() {
+		t.Fatal("wrong quote without duration:", quote)
+	}
+	qc.spending.Unspent = types.NewCurrency64(1)
+	r.hostContractor = qc
+	quote, err = r.UploadQuote(modules.UploadQuoteParams{Filesize: pieceSize + 1, ErasureCode: ec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.WithinAllowance {
+		t.Fatal("upload shouldn't be within the allowance")
+	}
+}
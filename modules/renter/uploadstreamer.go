@@ -0,0 +1,214 @@
+package renter
+
+// uploadstreamer.go uploads files whose data is read from a stream instead of
+// a file on disk. The chunks of the file are read from the stream one at a
+// time and pushed onto the upload heap along with their data, so that they are
+// uploaded according to the priority of the file and not while the file or
+// the upload pipeline is paused. Since no local copy of the file exists
+// afterwards, the file is tracked without a repair path, which means that it
+// can only be repaired remotely.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+const (
+	// streamFileMode is the file mode of files uploaded from a stream, which
+	// don't have a source file to take the mode from.
+	streamFileMode = 0644
+)
+
+var (
+	// errStreamUploadInterrupted is returned if the renter shuts down before
+	// a streaming upload completes.
+	errStreamUploadInterrupted = errors.New("renter shut down before the upload was completed")
+
+	// errStreamFileDeleted is returned if a file is deleted while its data is
+	// still being uploaded from a stream.
+	errStreamFileDeleted = errors.New("file was deleted during the upload")
+)
+
+// readChunkData reads from r into the shards of buf until buf is full or r is
+// exhausted. It returns the number of bytes read.
+func readChunkData(buf downloadDestinationBuffer, r io.Reader) (uint64, error) {
+	var n uint64
+	for _, shard := range buf {
+		read, err := io.ReadFull(r, shard)
+		n += uint64(read)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// managedUploadStreamChunks reads the chunks of f from reader and pushes them
+// onto the upload heap. The size of f grows with every chunk that is read. Once the
+// stream is exhausted, managedUploadStreamChunks waits until every chunk has
+// been uploaded.
+func (r *Renter) managedUploadStreamChunks(f *file, reader io.Reader, hosts map[string]struct{}) error {
+	var chunks []*unfinishedUploadChunk
+	fileHash := crypto.NewHash()
+	for index := uint64(0); ; index++ {
+		// Wait for the memory of the chunk before reading its data, so that
+		// the amount of buffered data stays within the renter's memory
+		// limits.
+		uc := newUnfinishedUploadChunk(f, index, "", hosts)
+		if !r.memoryManager.Request(uc.memoryNeeded, memoryPriorityLow) {
+			return errStreamUploadInterrupted
+		}
+		buf := NewDownloadDestinationBuffer(uc.length)
+		n, err := readChunkData(buf, reader)
+		if err != nil {
+			r.memoryManager.Return(uc.memoryNeeded)
+			return err
+		}
+		// An empty file still consists of one chunk, but the stream ending
+		// after a full chunk doesn't need another one.
+		if n == 0 && index > 0 {
+			r.memoryManager.Return(uc.memoryNeeded)
+			break
+		}
+
+		// Grow the file and record the checksum of its new chunk before the
+		// chunk is uploaded.
+		writeChunkData(fileHash, buf, n)
+		chunkChecksum, segments := hashChunkData(buf, n, f.pieceSize)
+		id := r.mu.Lock()
+		f.mu.Lock()
+		deleted := f.deleted || isTrashName(f.name)
+		if !deleted {
+			f.size += n
+			f.chunkChecksums = append(f.chunkChecksums, chunkChecksum)
+			f.segmentChecksums = append(f.segmentChecksums, segments)
+			r.updateParentDirs(f.name, n, 0, true)
+		}
+		f.mu.Unlock()
+		if !deleted {
+			uc.priority, _ = r.uploadPriority(f)
+		}
+		r.mu.Unlock(id)
+		if deleted {
+			r.memoryManager.Return(uc.memoryNeeded)
+			return errStreamFileDeleted
+		}
+
+		uc.logicalChunkData = buf
+		uc.streamed = true
+		chunks = append(chunks, uc)
+		r.uploadHeap.managedPush(uc)
+		select {
+		case r.uploadHeap.newUploads <- struct{}{}:
+		default:
+		}
+		if n < uc.length {
+			break
+		}
+	}
+
+	// Wait for the chunks to complete and make sure that every chunk can be
+	// recovered.
+	for _, uc := range chunks {
+		select {
+		case <-uc.completeChan:
+		case <-r.tg.StopChan():
+			return errStreamUploadInterrupted
+		}
+		uc.mu.Lock()
+		piecesCompleted := uc.piecesCompleted
+		uc.mu.Unlock()
+		if piecesCompleted < uc.minimumPieces {
+			return fmt.Errorf("only %v of %v required pieces of chunk %v were uploaded", piecesCompleted, uc.minimumPieces, uc.index)
+		}
+	}
+	f.mu.Lock()
+	fileHash.Sum(f.checksum[:0])
+	f.mu.Unlock()
+	return nil
+}
+
+// UploadStreamFromReader reads the data of a file from reader and uploads it
+// chunk by chunk. UploadStreamFromReader returns once every chunk has been
+// uploaded. The renter keeps no local copy of the file, so it can only be
+// repaired remotely.
+func (r *Renter) UploadStreamFromReader(up modules.FileUploadParams, reader io.Reader) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	// Enforce nickname rules.
+	if err := validateSiapath(up.SiaPath); err != nil {
+		return err
+	}
+	if err := validateFileMetadata(up.Metadata); err != nil {
+		return err
+	}
+	if up.ErasureCode == nil {
+		up.ErasureCode, _ = NewRSCode(defaultDataPieces, defaultParityPieces)
+	}
+	if err := r.checkUploadContracts(up.ErasureCode); err != nil {
+		return err
+	}
+
+	// Fail early if there aren't enough workers to upload the chunks.
+	hosts := r.managedRefreshHostsAndWorkers()
+	id := r.mu.RLock()
+	availableWorkers := len(r.workerPool)
+	r.mu.RUnlock(id)
+	if availableWorkers < up.ErasureCode.MinPieces() {
+		return fmt.Errorf("not enough workers to upload file: got %v, needed %v", availableWorkers, up.ErasureCode.MinPieces())
+	}
+
+	// Create the file. It is not tracked until the upload is complete, so the
+	// repair loop ignores it in the meantime.
+	f := newFile(up.SiaPath, up.ErasureCode, pieceSize, 0)
+	f.mode = streamFileMode
+	f.priority = up.Priority
+	f.metadata = copyFileMetadata(up.Metadata)
+	id = r.mu.Lock()
+	if _, exists := r.files[up.SiaPath]; exists {
+		r.mu.Unlock(id)
+		return ErrPathOverload
+	}
+	r.files[up.SiaPath] = f
+	r.addFileToDirs(f)
+	err := r.saveFile(f)
+	r.mu.Unlock(id)
+	if err != nil {
+		return err
+	}
+
+	// Upload the data. If the upload fails, the file can't be recovered and
+	// is removed again.
+	if err := r.managedUploadStreamChunks(f, reader, hosts); err != nil {
+		f.mu.RLock()
+		siaPath := f.name
+		f.mu.RUnlock()
+		if deleteErr := r.managedDeleteFile(siaPath, false); deleteErr != nil && deleteErr != ErrUnknownPath {
+			r.log.Println("WARN: could not delete file after failed streaming upload:", deleteErr)
+		}
+		return err
+	}
+
+	// Save the final size of the file and track it without a repair path.
+	id = r.mu.Lock()
+	defer r.mu.Unlock(id)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleted || isTrashName(f.name) {
+		return errStreamFileDeleted
+	}
+	if err := r.saveFile(f); err != nil {
+		return err
+	}
+	r.tracking[f.name] = trackedFile{}
+	return r.saveSync()
+}
@@ -40,6 +40,11 @@ type worker struct {
 	downloadMu         sync.Mutex
 	downloadTerminated bool // Has downloading been terminated for this worker?
 
+	// Rolling download statistics. They have a separate mutex because they
+	// are read when chunks are distributed to the workers.
+	downloadStats   workerDownloadStats
+	downloadStatsMu sync.Mutex
+
 	// Upload variables.
 	unprocessedChunks         []*unfinishedUploadChunk // Yet unprocessed work items.
 	uploadChan                chan struct{}            // Notifications of new work.
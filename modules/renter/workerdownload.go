@@ -7,8 +7,59 @@ package renter
 import (
 	"sync/atomic"
 	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/modules/renter/contractor"
 )
 
+// downloadPartialPiece fetches the part of an encrypted piece that contains
+// the plaintext at [offset, offset+length) and decrypts it. The returned
+// integer is the number of bytes that were fetched from the host, which is
+// larger than length due to segment alignment and the nonce.
+func downloadPartialPiece(d contractor.Downloader, root crypto.Hash, key crypto.TwofishKey, offset, length uint64) ([]byte, uint64, error) {
+	// The ciphertext of the piece follows the nonce at the beginning of the
+	// sector. Hosts only serve ranges that are aligned to segments.
+	start := crypto.TwofishNonceSize + offset
+	end := start + length
+	alignedStart := start / crypto.SegmentSize * crypto.SegmentSize
+	alignedEnd := (end + crypto.SegmentSize - 1) / crypto.SegmentSize * crypto.SegmentSize
+	if alignedEnd > modules.SectorSize {
+		alignedEnd = modules.SectorSize
+	}
+	actions := []modules.DownloadAction{{
+		MerkleRoot: root,
+		Offset:     alignedStart,
+		Length:     alignedEnd - alignedStart,
+	}}
+	// Fetch the first segment as well if it doesn't contain the nonce.
+	if alignedStart != 0 {
+		actions = append(actions, modules.DownloadAction{
+			MerkleRoot: root,
+			Offset:     0,
+			Length:     crypto.SegmentSize,
+		})
+	}
+	data, err := d.Ranges(actions)
+	if err != nil {
+		return nil, 0, err
+	}
+	nonce := data[0][:crypto.TwofishNonceSize]
+	var transferred uint64
+	for i := range data {
+		transferred += uint64(len(data[i]))
+		if actions[i].Offset == 0 {
+			nonce = data[i][:crypto.TwofishNonceSize]
+		}
+	}
+	ct := data[0][start-alignedStart : end-alignedStart]
+	plaintext, err := key.DecryptBytesAt(nonce, ct, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return plaintext, transferred, nil
+}
+
 // managedDownload will perform some download work.
 func (w *worker) managedDownload(udc *unfinishedDownloadChunk) {
 	// Process this chunk. If the worker is not fit to do the download, or is
@@ -29,21 +80,37 @@ func (w *worker) managedDownload(udc *unfinishedDownloadChunk) {
 	// unregistered with the chunk.
 	d, err := w.renter.hostContractor.Downloader(w.contract.ID, w.renter.tg.StopChan())
 	if err != nil {
+		w.managedRecordDownloadFailure()
 		udc.managedUnregisterWorker(w)
 		return
 	}
 	defer d.Close()
-	data, err := d.Sector(udc.staticChunkMap[w.contract.ID].root)
+	pieceData := udc.staticChunkMap[w.contract.ID]
+	var data []byte
+	transferred := udc.staticPieceSize
+	start := time.Now()
+	if udc.staticPartial {
+		// Only fetch the part of the piece that is needed to recover the
+		// requested data. The piece is decrypted right away, because the
+		// nonce is not kept.
+		key := deriveKey(udc.masterKey, udc.staticKeyIndex, pieceData.index)
+		offset, length := udc.staticPieceRange()
+		data, transferred, err = downloadPartialPiece(d, pieceData.root, key, offset, length)
+	} else {
+		data, err = d.Sector(pieceData.root)
+	}
 	if err != nil {
+		w.managedRecordDownloadFailure()
 		udc.managedUnregisterWorker(w)
 		return
 	}
+	w.managedRecordDownload(d.TimeToFirstByte(), time.Since(start), transferred)
 	// TODO: Instead of adding the whole sector after the download completes,
 	// have the 'd.Sector' call add to this value ongoing as the sector comes
 	// in. Perhaps even include the data from creating the downloader and other
 	// data sent to and received from the host (like signatures) that aren't
 	// actually payload data.
-	atomic.AddUint64(&udc.download.atomicTotalDataTransferred, udc.staticPieceSize)
+	atomic.AddUint64(&udc.download.atomicTotalDataTransferred, transferred)
 
 	// Mark the piece as completed. Perform chunk recovery if we newly have
 	// enough pieces to do so. Chunk recovery is an expensive operation that
@@ -52,12 +119,19 @@ func (w *worker) managedDownload(udc *unfinishedDownloadChunk) {
 	udc.piecesCompleted++
 	udc.piecesRegistered--
 	if udc.piecesCompleted <= udc.erasureCode.MinPieces() {
-		udc.physicalChunkData[udc.staticChunkMap[w.contract.ID].index] = data
+		udc.physicalChunkData[pieceData.index] = data
 	}
 	if udc.piecesCompleted == udc.erasureCode.MinPieces() {
 		go udc.threadedRecoverLogicalData()
 	}
+	excessPiece := udc.piecesCompleted > udc.erasureCode.MinPieces()
 	udc.mu.Unlock()
+
+	// A piece that arrived after the chunk had enough pieces was fetched in
+	// vain by overdrive.
+	if host, exists := w.renter.hostDB.Host(w.hostPubKey); excessPiece && exists {
+		w.renter.managedRecordOverdriveSpending(host.DownloadBandwidthPrice.Mul64(transferred))
+	}
 }
 
 // managedKillDownloading will drop all of the download work given to the
@@ -154,7 +228,10 @@ func (w *worker) ownedProcessDownloadChunk(udc *unfinishedDownloadChunk) *unfini
 	chunkFailed := udc.piecesCompleted+udc.workersRemaining < udc.erasureCode.MinPieces()
 	pieceData, workerHasPiece := udc.staticChunkMap[w.contract.ID]
 	pieceTaken := udc.pieceUsage[pieceData.index]
-	if chunkComplete || chunkFailed || w.ownedOnDownloadCooldown() || !workerHasPiece || pieceTaken {
+	// A download that completed before the chunk did was either cancelled or
+	// failed, the rest of its chunks don't need to be fetched.
+	downloadComplete := udc.download.staticComplete()
+	if chunkComplete || chunkFailed || downloadComplete || w.ownedOnDownloadCooldown() || !workerHasPiece || pieceTaken {
 		udc.mu.Unlock()
 		udc.managedRemoveWorker()
 		return nil
@@ -197,11 +274,11 @@ func (w *worker) ownedProcessDownloadChunk(udc *unfinishedDownloadChunk) *unfini
 	// Figure out if this chunk needs another worker actively downloading
 	// pieces. The number of workers that should be active simultaneously on
 	// this chunk is the minimum number of pieces required for recovery plus the
-	// number of overdrive workers (typically zero). For our purposes, completed
-	// pieces count as active workers, though the workers have actually
-	// finished.
+	// number of overdrive workers (zero until overdrive kicks in). For our
+	// purposes, completed pieces count as active workers, though the workers
+	// have actually finished.
 	piecesInProgress := udc.piecesRegistered + udc.piecesCompleted
-	desiredPiecesInProgress := udc.erasureCode.MinPieces() + udc.staticOverdrive
+	desiredPiecesInProgress := udc.erasureCode.MinPieces() + udc.overdrive
 	workersDesired := piecesInProgress < desiredPiecesInProgress
 
 	if workersDesired && meetsExtraCriteria {
@@ -0,0 +1,137 @@
+package renter
+
+// workerstats.go keeps rolling statistics of the piece downloads of every
+// worker: the time until the host starts sending the data of a piece, the
+// throughput of the transfer and the rate of failed downloads. The statistics
+// are averages over roughly the last workerStatsWindow downloads, so they
+// follow hosts that become faster or slower over time.
+//
+// When a chunk is distributed to the workers, the workers that hold a piece of
+// the chunk are ranked by the time they are expected to take for their piece.
+// Only the fastest MinPieces workers are given the chunk right away, the slower
+// ones are put on standby and only step in if one of the faster workers fails
+// or if the chunk takes longer than expected, see overdrive.go. Workers without
+// statistics are ranked first, so that their statistics are learned.
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// workerDownloadStats are the rolling statistics of the piece downloads of a
+// worker.
+type workerDownloadStats struct {
+	downloads uint64 // Successful piece downloads.
+	failures  uint64 // Failed piece downloads.
+
+	failureRate     float64 // Rate of recent downloads that failed.
+	throughput      float64 // Bytes per second.
+	timeToFirstByte float64 // Nanoseconds.
+}
+
+// rollingAverage adds the nth sample to the average of the previous samples.
+// Once there are more than workerStatsWindow samples, older samples are
+// weighted less and less.
+func rollingAverage(average, sample float64, n uint64) float64 {
+	if n > workerStatsWindow {
+		n = workerStatsWindow
+	}
+	return average + (sample-average)/float64(n)
+}
+
+// managedRecordDownload adds a successful download of size bytes to the
+// statistics of the worker. elapsed is the duration of the whole download.
+func (w *worker) managedRecordDownload(timeToFirstByte, elapsed time.Duration, size uint64) {
+	w.downloadStatsMu.Lock()
+	defer w.downloadStatsMu.Unlock()
+	stats := &w.downloadStats
+	stats.downloads++
+	stats.failureRate = rollingAverage(stats.failureRate, 0, stats.downloads+stats.failures)
+	stats.timeToFirstByte = rollingAverage(stats.timeToFirstByte, float64(timeToFirstByte), stats.downloads)
+	if transfer := elapsed - timeToFirstByte; transfer > 0 {
+		stats.throughput = rollingAverage(stats.throughput, float64(size)/transfer.Seconds(), stats.downloads)
+	}
+}
+
+// managedRecordDownloadFailure adds a failed download to the statistics of
+// the worker.
+func (w *worker) managedRecordDownloadFailure() {
+	w.downloadStatsMu.Lock()
+	defer w.downloadStatsMu.Unlock()
+	stats := &w.downloadStats
+	stats.failures++
+	stats.failureRate = rollingAverage(stats.failureRate, 1, stats.downloads+stats.failures)
+}
+
+// managedEstimateDownloadTime returns how long the worker is expected to take
+// to download size bytes of a piece, including the retries after failures.
+func (w *worker) managedEstimateDownloadTime(size uint64) time.Duration {
+	w.downloadStatsMu.Lock()
+	defer w.downloadStatsMu.Unlock()
+	stats := w.downloadStats
+	if stats.downloads == 0 && stats.failures == 0 {
+		return 0
+	} else if stats.downloads == 0 || stats.throughput == 0 {
+		return math.MaxInt64
+	}
+	estimate := stats.timeToFirstByte + float64(size)/stats.throughput*float64(time.Second)
+	return time.Duration(estimate / (1 - math.Min(stats.failureRate, maxWorkerFailureRate)))
+}
+
+// managedWorkerInfo returns the download statistics of the worker.
+func (w *worker) managedWorkerInfo() modules.WorkerInfo {
+	w.downloadStatsMu.Lock()
+	defer w.downloadStatsMu.Unlock()
+	return modules.WorkerInfo{
+		ContractID:    w.contract.ID,
+		HostPublicKey: w.hostPubKey,
+
+		Downloads:               w.downloadStats.downloads,
+		DownloadFailures:        w.downloadStats.failures,
+		DownloadFailureRate:     w.downloadStats.failureRate,
+		DownloadThroughput:      uint64(w.downloadStats.throughput),
+		DownloadTimeToFirstByte: time.Duration(w.downloadStats.timeToFirstByte),
+	}
+}
+
+// rankDownloadWorkers sorts the workers by the time they are expected to take
+// to download size bytes of a piece, fastest first.
+func rankDownloadWorkers(workers []*worker, size uint64) {
+	estimates := make(map[*worker]time.Duration, len(workers))
+	for _, w := range workers {
+		estimates[w] = w.managedEstimateDownloadTime(size)
+	}
+	sort.SliceStable(workers, func(i, j int) bool {
+		return estimates[workers[i]] < estimates[workers[j]]
+	})
+}
+
+// Workers returns the download statistics of the renter's workers, the
+// workers with the slowest time to first byte first.
+func (r *Renter) Workers() []modules.WorkerInfo {
+	id := r.mu.RLock()
+	workers := make([]*worker, 0, len(r.workerPool))
+	for _, w := range r.workerPool {
+		workers = append(workers, w)
+	}
+	r.mu.RUnlock(id)
+
+	infos := make([]modules.WorkerInfo, 0, len(workers))
+	for _, w := range workers {
+		info := w.managedWorkerInfo()
+		if host, exists := r.hostDB.Host(w.hostPubKey); exists {
+			info.NetAddress = host.NetAddress
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].DownloadTimeToFirstByte != infos[j].DownloadTimeToFirstByte {
+			return infos[i].DownloadTimeToFirstByte > infos[j].DownloadTimeToFirstByte
+		}
+		return infos[i].ContractID.String() < infos[j].ContractID.String()
+	})
+	return infos
+}
@@ -0,0 +1,108 @@
+package renter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	siasync "github.com/NebulousLabs/Sia/sync"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// TestWorkerDownloadStats checks that the statistics of a worker follow its
+// recent downloads and determine the estimated download time.
+func TestWorkerDownloadStats(t *testing.T) {
+	w := new(worker)
+	if w.managedEstimateDownloadTime(1000) != 0 {
+		t.Fatal("worker without statistics should be expected to be fastest")
+	}
+
+	// 1000 bytes that take 1s after the first byte arrived after 1s.
+	w.managedRecordDownload(time.Second, 2*time.Second, 1000)
+	info := w.managedWorkerInfo()
+	if info.Downloads != 1 || info.DownloadTimeToFirstByte != time.Second || info.DownloadThroughput != 1000 || info.DownloadFailureRate != 0 {
+		t.Fatal("wrong statistics:", info)
+	}
+	if estimate := w.managedEstimateDownloadTime(2000); estimate != 3*time.Second {
+		t.Fatal("wrong estimate:", estimate)
+	}
+
+	// A failure increases the estimate.
+	w.managedRecordDownloadFailure()
+	info = w.managedWorkerInfo()
+	if info.DownloadFailures != 1 || info.DownloadFailureRate != 0.5 {
+		t.Fatal("wrong statistics after failure:", info)
+	}
+	if estimate := w.managedEstimateDownloadTime(2000); estimate != 6*time.Second {
+		t.Fatal("wrong estimate after failure:", estimate)
+	}
+
+	// Old samples fade out.
+	for i := 0; i < 10*workerStatsWindow; i++ {
+		w.managedRecordDownload(time.Millisecond, 2*time.Millisecond, 1000)
+	}
+	info = w.managedWorkerInfo()
+	if info.DownloadTimeToFirstByte > 2*time.Millisecond || info.DownloadFailureRate > 0.01 {
+		t.Fatal("old samples didn't fade out:", info)
+	}
+
+	// A worker that only failed is expected to be slowest.
+	failing := new(worker)
+	failing.managedRecordDownloadFailure()
+	if failing.managedEstimateDownloadTime(1000) != math.MaxInt64 {
+		t.Fatal("failing worker should be expected to be slowest")
+	}
+}
+
+// TestDistributeDownloadChunk checks that a chunk is given to the fastest
+// workers that hold a piece of it, and that the other workers are put on
+// standby.
+func TestDistributeDownloadChunk(t *testing.T) {
+	// Create workers that download with different speeds.
+	r := &Renter{
+		mu:         siasync.New(modules.SafeMutexDelay, 1),
+		workerPool: make(map[types.FileContractID]*worker),
+	}
+	var workers []*worker
+	chunkMap := make(map[types.FileContractID]downloadPieceInfo)
+	for i := 0; i < 5; i++ {
+		w := &worker{
+			downloadChan: make(chan struct{}, 1),
+			renter:       r,
+		}
+		w.contract.ID[0] = byte(i)
+		if i > 0 {
+			w.managedRecordDownload(time.Duration(i)*time.Second, time.Duration(i+1)*time.Second, pieceSize)
+		}
+		if i < 4 {
+			chunkMap[w.contract.ID] = downloadPieceInfo{index: uint64(i)}
+		}
+		r.workerPool[w.contract.ID] = w
+		workers = append(workers, w)
+	}
+
+	// The chunk needs two pieces and the last worker doesn't have one.
+	ec, _ := NewRSCode(2, 2)
+	udc := &unfinishedDownloadChunk{
+		erasureCode:     ec,
+		staticChunkMap:  chunkMap,
+		staticPieceSize: pieceSize,
+		download: &download{
+			completeChan: make(chan struct{}),
+		},
+	}
+	r.managedDistributeDownloadChunkToWorkers(udc)
+	for i, w := range workers {
+		queued := len(w.downloadChunks) == 1
+		if queued != (i < 2) {
+			t.Fatalf("worker %v: queued %v", i, queued)
+		}
+	}
+	if udc.workersRemaining != 4 {
+		t.Fatal("wrong number of workers remaining:", udc.workersRemaining)
+	}
+	if len(udc.workersStandby) != 2 || udc.workersStandby[0] != workers[2] || udc.workersStandby[1] != workers[3] {
+		t.Fatal("slower workers weren't put on standby")
+	}
+}
@@ -135,7 +135,7 @@ func (w *worker) managedUpload(uc *unfinishedUploadChunk, pieceIndex uint64) {
 		MerkleRoot: root,
 	})
 	uc.renterFile.contracts[w.contract.ID] = contract
-	w.renter.saveFile(uc.renterFile)
+	w.renter.saveChunk(uc.renterFile, uc.index)
 	uc.renterFile.mu.Unlock()
 	w.renter.mu.Unlock(id)
 
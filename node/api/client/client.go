@@ -156,12 +156,19 @@ func (c *Client) get(resource string, obj interface{}) error {
 // postRawResponse requests the specified resource. The response, if provided,
 // will be returned in a byte slice
 func (c *Client) postRawResponse(resource string, data string) ([]byte, error) {
-	req, err := c.NewRequest("POST", resource, strings.NewReader(data))
+	// TODO: is setting the content type necessary?
+	return c.postRawResponseBody(resource, strings.NewReader(data), "application/x-www-form-urlencoded")
+}
+
+// postRawResponseBody requests the specified resource, sending the data read
+// from body with the provided content type. The response, if provided, will
+// be returned in a byte slice
+func (c *Client) postRawResponseBody(resource string, body io.Reader, contentType string) ([]byte, error) {
+	req, err := c.NewRequest("POST", resource, body)
 	if err != nil {
 		return nil, err
 	}
-	// TODO: is this necessary?
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", contentType)
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, errors.AddContext(err, "request failed")
@@ -2,14 +2,46 @@ package client
 
 import (
 	"fmt"
+	"io"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/node/api"
+	"github.com/NebulousLabs/Sia/types"
 )
 
+// RenterBackupPost uses the /renter/backup endpoint to back up the renter's
+// metadata to its hosts.
+func (c *Client) RenterBackupPost() (err error) {
+	err = c.post("/renter/backup", "", nil)
+	return
+}
+
+// RenterCacheGet requests the /renter/cache resource.
+func (c *Client) RenterCacheGet() (rc api.RenterCacheGET, err error) {
+	err = c.get("/renter/cache", &rc)
+	return
+}
+
+// RenterCachePost uses the /renter/cache endpoint to set the maximum size of
+// the renter's chunk cache on disk.
+func (c *Client) RenterCachePost(maxSize uint64) (err error) {
+	values := url.Values{}
+	values.Set("maxsize", strconv.FormatUint(maxSize, 10))
+	err = c.post("/renter/cache", values.Encode(), nil)
+	return
+}
+
+// RenterCachePurgePost uses the /renter/cache/purge endpoint to remove all
+// chunks from the renter's chunk cache on disk.
+func (c *Client) RenterCachePurgePost() (err error) {
+	err = c.post("/renter/cache/purge", "", nil)
+	return
+}
+
 // RenterContractsGet requests the /renter/contracts resource
 func (c *Client) RenterContractsGet() (rc api.RenterContracts, err error) {
 	err = c.get("/renter/contracts", &rc)
@@ -23,6 +55,40 @@ func (c *Client) RenterDeletePost(siaPath string) (err error) {
 	return err
 }
 
+// RenterDirGet uses the /renter/dir/:siapath endpoint to list a directory.
+func (c *Client) RenterDirGet(siaPath string) (rd api.RenterDirectory, err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	err = c.get("/renter/dir/"+siaPath, &rd)
+	return
+}
+
+// RenterDirCreatePost uses the /renter/dir/:siapath endpoint to create a
+// directory.
+func (c *Client) RenterDirCreatePost(siaPath string) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	err = c.post("/renter/dir/"+siaPath, "action=create", nil)
+	return
+}
+
+// RenterDirDeletePost uses the /renter/dir/:siapath endpoint to delete a
+// directory and everything it contains.
+func (c *Client) RenterDirDeletePost(siaPath string) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	err = c.post("/renter/dir/"+siaPath, "action=delete", nil)
+	return
+}
+
+// RenterDirRenamePost uses the /renter/dir/:siapath endpoint to rename a
+// directory.
+func (c *Client) RenterDirRenamePost(siaPathOld, siaPathNew string) (err error) {
+	siaPathOld = strings.TrimPrefix(siaPathOld, "/")
+	values := url.Values{}
+	values.Set("action", "rename")
+	values.Set("newsiapath", strings.TrimPrefix(siaPathNew, "/"))
+	err = c.post("/renter/dir/"+siaPathOld, values.Encode(), nil)
+	return
+}
+
 // RenterDownloadGet uses the /renter/download endpoint to download a file to a
 // destination on disk.
 func (c *Client) RenterDownloadGet(siaPath, destination string, offset, length uint64, async bool) (err error) {
@@ -49,6 +115,26 @@ func (c *Client) RenterDownloadsGet() (rdq api.RenterDownloadQueue, err error) {
 	return
 }
 
+// RenterDownloadCancelPost uses the /renter/downloads endpoint to cancel a
+// download.
+func (c *Client) RenterDownloadCancelPost(id string) (err error) {
+	values := url.Values{}
+	values.Set("action", "cancel")
+	values.Set("id", id)
+	err = c.post("/renter/downloads", values.Encode(), nil)
+	return
+}
+
+// RenterDownloadResumePost uses the /renter/downloads endpoint to resume a
+// failed download.
+func (c *Client) RenterDownloadResumePost(id string) (err error) {
+	values := url.Values{}
+	values.Set("action", "resume")
+	values.Set("id", id)
+	err = c.post("/renter/downloads", values.Encode(), nil)
+	return
+}
+
 // RenterDownloadHTTPResponseGet uses the /renter/download endpoint to download
 // a file and return its data.
 func (c *Client) RenterDownloadHTTPResponseGet(siaPath string, offset, length uint64) (resp []byte, err error) {
@@ -77,6 +163,24 @@ func (c *Client) RenterGet() (rg api.RenterGET, err error) {
 	return
 }
 
+// RenterLoadPost uses the /renter/load endpoint to load the files in a .sia
+// file into the renter.
+func (c *Client) RenterLoadPost(source string) (rl api.RenterLoad, err error) {
+	values := url.Values{}
+	values.Set("source", source)
+	err = c.post("/renter/load", values.Encode(), &rl)
+	return
+}
+
+// RenterLoadASCIIPost uses the /renter/loadascii endpoint to load the files in
+// an ASCII-encoded .sia file into the renter.
+func (c *Client) RenterLoadASCIIPost(asciiSia string) (rl api.RenterLoad, err error) {
+	values := url.Values{}
+	values.Set("asciisia", asciiSia)
+	err = c.post("/renter/loadascii", values.Encode(), &rl)
+	return
+}
+
 // RenterPostAllowance uses the /renter endpoint to change the renter's allowance
 func (c *Client) RenterPostAllowance(allowance modules.Allowance) (err error) {
 	values := url.Values{}
@@ -94,6 +198,16 @@ func (c *Client) RenterCancelAllowance() (err error) {
 	return
 }
 
+// RenterMetadataPost uses the /renter/metadata/:siapath endpoint to replace
+// the application metadata of a file.
+func (c *Client) RenterMetadataPost(siaPath string, metadata map[string]string) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	values := url.Values{}
+	setFileMetadata(values, metadata)
+	err = c.post("/renter/metadata/"+siaPath, values.Encode(), nil)
+	return
+}
+
 // RenterPricesGet requests the /renter/prices endpoint's resources.
 func (c *Client) RenterPricesGet() (rpg api.RenterPricesGET, err error) {
 	err = c.get("/renter/prices", &rpg)
@@ -110,6 +224,64 @@ func (c *Client) RenterPostRateLimit(readBPS, writeBPS int64) (err error) {
 	return
 }
 
+// RenterPostDownloadOverdrive uses the /renter endpoint to change the number of
+// extra pieces that the renter requests for chunks that take longer than
+// expected.
+func (c *Client) RenterPostDownloadOverdrive(overdrive uint64) (err error) {
+	values := url.Values{}
+	values.Set("downloadoverdrive", strconv.FormatUint(overdrive, 10))
+	err = c.post("/renter", values.Encode(), nil)
+	return
+}
+
+// RenterPostTrashRetention uses the /renter endpoint to change how long
+// deleted files are kept in the renter's trash.
+func (c *Client) RenterPostTrashRetention(retention time.Duration) (err error) {
+	values := url.Values{}
+	values.Set("trashretention", strconv.FormatInt(int64(retention), 10))
+	err = c.post("/renter", values.Encode(), nil)
+	return
+}
+
+// RenterRecoverBackupGet uses the /renter/recoverbackup endpoint to request
+// the status of the most recent recovery of a backup.
+func (c *Client) RenterRecoverBackupGet() (rrbg api.RenterRecoverBackupGET, err error) {
+	err = c.get("/renter/recoverbackup", &rrbg)
+	return
+}
+
+// RenterRecoverBackupPost uses the /renter/recoverbackup endpoint to start
+// recovering the renter's metadata from the most recent backup.
+func (c *Client) RenterRecoverBackupPost() (err error) {
+	err = c.post("/renter/recoverbackup", "", nil)
+	return
+}
+
+// RenterRepairGet requests the /renter/repair resource.
+func (c *Client) RenterRepairGet() (rq api.RenterRepairQueue, err error) {
+	err = c.get("/renter/repair", &rq)
+	return
+}
+
+// RenterRedundancyGet uses the /renter/redundancy/:siapath endpoint to list
+// the changes of redundancy of a file.
+func (c *Client) RenterRedundancyGet(siaPath string) (rr api.RenterRedundancyGET, err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	err = c.get("/renter/redundancy/"+siaPath, &rr)
+	return
+}
+
+// RenterRedundancyPost uses the /renter/redundancy/:siapath endpoint to start
+// re-encoding a file with a different number of data and parity pieces.
+func (c *Client) RenterRedundancyPost(siaPath string, dataPieces, parityPieces uint64) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	values := url.Values{}
+	values.Set("datapieces", strconv.FormatUint(dataPieces, 10))
+	values.Set("paritypieces", strconv.FormatUint(parityPieces, 10))
+	err = c.post("/renter/redundancy/"+siaPath, values.Encode(), nil)
+	return
+}
+
 // RenterRenamePost uses the /renter/rename/:siapath endpoint to rename a file.
 func (c *Client) RenterRenamePost(siaPathOld, siaPathNew string) (err error) {
 	siaPathOld = strings.TrimPrefix(siaPathOld, "/")
@@ -118,6 +290,54 @@ func (c *Client) RenterRenamePost(siaPathOld, siaPathNew string) (err error) {
 	return
 }
 
+// RenterReplacePost uses the /renter/rename/:siapath endpoint to rename a file,
+// replacing the file that already has the new siapath.
+func (c *Client) RenterReplacePost(siaPathOld, siaPathNew string) (err error) {
+	siaPathOld = strings.TrimPrefix(siaPathOld, "/")
+	values := url.Values{}
+	values.Set("newsiapath", strings.TrimPrefix(siaPathNew, "/"))
+	values.Set("replace", "true")
+	err = c.post("/renter/rename/"+siaPathOld, values.Encode(), nil)
+	return
+}
+
+// RenterSearchGet uses the /renter/search endpoint to search the renter's
+// files.
+func (c *Client) RenterSearchGet(q modules.FileSearchQuery) (rs api.RenterSearch, err error) {
+	values := url.Values{}
+	values.Set("prefix", strings.TrimPrefix(q.Prefix, "/"))
+	setFileMetadata(values, q.Metadata)
+	values.Set("minsize", strconv.FormatUint(q.MinSize, 10))
+	values.Set("maxsize", strconv.FormatUint(q.MaxSize, 10))
+	values.Set("minhealth", strconv.FormatFloat(q.MinHealth, 'f', -1, 64))
+	values.Set("maxhealth", strconv.FormatFloat(q.MaxHealth, 'f', -1, 64))
+	values.Set("offset", strconv.FormatUint(q.Offset, 10))
+	values.Set("limit", strconv.FormatUint(q.Limit, 10))
+	err = c.get("/renter/search?"+values.Encode(), &rs)
+	return
+}
+
+// RenterShareGet uses the /renter/share endpoint to write the specified files
+// to a .sia file at destination.
+func (c *Client) RenterShareGet(siaPaths []string, destination string, stripContractIDs bool) (err error) {
+	values := url.Values{}
+	values.Set("siapaths", strings.Join(siaPaths, ","))
+	values.Set("destination", destination)
+	values.Set("stripcontractids", strconv.FormatBool(stripContractIDs))
+	err = c.get("/renter/share?"+values.Encode(), nil)
+	return
+}
+
+// RenterShareASCIIGet uses the /renter/shareascii endpoint to create an
+// ASCII-encoded .sia file containing the specified files.
+func (c *Client) RenterShareASCIIGet(siaPaths []string, stripContractIDs bool) (rsa api.RenterShareASCII, err error) {
+	values := url.Values{}
+	values.Set("siapaths", strings.Join(siaPaths, ","))
+	values.Set("stripcontractids", strconv.FormatBool(stripContractIDs))
+	err = c.get("/renter/shareascii?"+values.Encode(), &rsa)
+	return
+}
+
 // RenterStreamGet uses the /renter/stream endpoint to download data as a
 // stream.
 func (c *Client) RenterStreamGet(siaPath string) (resp []byte, err error) {
@@ -154,3 +374,184 @@ func (c *Client) RenterUploadDefaultPost(path, siaPath string) (err error) {
 	err = c.post(fmt.Sprintf("/renter/upload/%v", siaPath), values.Encode(), nil)
 	return
 }
+
+// RenterUploadPriorityPost uses the /renter/upload endpoint with default
+// redundancy settings to upload a file with the given upload priority.
+func (c *Client) RenterUploadPriorityPost(path, siaPath string, priority uint64) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	values := url.Values{}
+	values.Set("source", path)
+	values.Set("priority", strconv.FormatUint(priority, 10))
+	err = c.post(fmt.Sprintf("/renter/upload/%v", siaPath), values.Encode(), nil)
+	return
+}
+
+// RenterUploadDedupPost uses the /renter/upload endpoint with default
+// redundancy settings to upload a file with convergent chunking, so that
+// chunks the renter already stores are not uploaded again.
+func (c *Client) RenterUploadDedupPost(path, siaPath string, priority uint64) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	values := url.Values{}
+	values.Set("source", path)
+	values.Set("priority", strconv.FormatUint(priority, 10))
+	values.Set("dedup", "true")
+	err = c.post(fmt.Sprintf("/renter/upload/%v", siaPath), values.Encode(), nil)
+	return
+}
+
+// RenterUploadCompressPost uses the /renter/upload endpoint with default
+// redundancy settings to upload a file whose data is compressed before it is
+// uploaded.
+func (c *Client) RenterUploadCompressPost(path, siaPath string, priority uint64) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	values := url.Values{}
+	values.Set("source", path)
+	values.Set("priority", strconv.FormatUint(priority, 10))
+	values.Set("compress", "true")
+	err = c.post(fmt.Sprintf("/renter/upload/%v", siaPath), values.Encode(), nil)
+	return
+}
+
+// RenterUploadMetadataPost uses the /renter/upload endpoint with default
+// redundancy settings to upload a file with the given application metadata.
+func (c *Client) RenterUploadMetadataPost(path, siaPath string, priority uint64, dedup, compress bool, metadata map[string]string) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	values := url.Values{}
+	values.Set("source", path)
+	values.Set("priority", strconv.FormatUint(priority, 10))
+	values.Set("dedup", strconv.FormatBool(dedup))
+	values.Set("compress", strconv.FormatBool(compress))
+	setFileMetadata(values, metadata)
+	err = c.post(fmt.Sprintf("/renter/upload/%v", siaPath), values.Encode(), nil)
+	return
+}
+
+// RenterSyncGet requests the /renter/sync resource.
+func (c *Client) RenterSyncGet() (rs api.RenterSync, err error) {
+	err = c.get("/renter/sync", &rs)
+	return
+}
+
+// RenterSyncAddPost uses the /renter/sync endpoint to start syncing a local
+// folder with a siapath prefix.
+func (c *Client) RenterSyncAddPost(params modules.SyncFolderParams) (err error) {
+	values := url.Values{}
+	values.Set("action", "add")
+	values.Set("localpath", params.LocalPath)
+	values.Set("siapath", params.SiaPath)
+	values.Set("direction", params.Direction)
+	values.Set("deletepolicy", params.DeletePolicy)
+	err = c.post("/renter/sync", values.Encode(), nil)
+	return
+}
+
+// RenterSyncRemovePost uses the /renter/sync endpoint to stop syncing a local
+// folder.
+func (c *Client) RenterSyncRemovePost(localPath string) (err error) {
+	values := url.Values{}
+	values.Set("action", "remove")
+	values.Set("localpath", localPath)
+	err = c.post("/renter/sync", values.Encode(), nil)
+	return
+}
+
+// RenterTrashGet requests the /renter/trash resource.
+func (c *Client) RenterTrashGet() (rt api.RenterTrash, err error) {
+	err = c.get("/renter/trash", &rt)
+	return
+}
+
+// RenterTrashEmptyPost uses the /renter/trash endpoint to purge all of the
+// files in the renter's trash.
+func (c *Client) RenterTrashEmptyPost() (err error) {
+	values := url.Values{}
+	values.Set("action", "empty")
+	err = c.post("/renter/trash", values.Encode(), nil)
+	return
+}
+
+// RenterTrashRestorePost uses the /renter/trash endpoint to restore a deleted
+// file from the renter's trash.
+func (c *Client) RenterTrashRestorePost(siaPath string) (err error) {
+	values := url.Values{}
+	values.Set("action", "restore")
+	values.Set("siapath", strings.TrimPrefix(siaPath, "/"))
+	err = c.post("/renter/trash", values.Encode(), nil)
+	return
+}
+
+// RenterUploadQuoteGet uses the /renter/uploadquote endpoint to quote the cost
+// of uploading the file or directory at source. The default erasure code is
+// used if dataPieces and parityPieces are 0, and the allowance period if
+// duration is 0.
+func (c *Client) RenterUploadQuoteGet(source string, dataPieces, parityPieces uint64, duration types.BlockHeight) (ruq api.RenterUploadQuoteGET, err error) {
+	values := url.Values{}
+	values.Set("source", source)
+	if dataPieces != 0 || parityPieces != 0 {
+		values.Set("datapieces", strconv.FormatUint(dataPieces, 10))
+		values.Set("paritypieces", strconv.FormatUint(parityPieces, 10))
+	}
+	values.Set("duration", strconv.FormatUint(uint64(duration), 10))
+	err = c.get("/renter/uploadquote?"+values.Encode(), &ruq)
+	return
+}
+
+// RenterUploadQuoteFilesizeGet uses the /renter/uploadquote endpoint to quote
+// the cost of uploading filesize bytes, like RenterUploadQuoteGet.
+func (c *Client) RenterUploadQuoteFilesizeGet(filesize, dataPieces, parityPieces uint64, duration types.BlockHeight) (ruq api.RenterUploadQuoteGET, err error) {
+	values := url.Values{}
+	values.Set("filesize", strconv.FormatUint(filesize, 10))
+	if dataPieces != 0 || parityPieces != 0 {
+		values.Set("datapieces", strconv.FormatUint(dataPieces, 10))
+		values.Set("paritypieces", strconv.FormatUint(parityPieces, 10))
+	}
+	values.Set("duration", strconv.FormatUint(uint64(duration), 10))
+	err = c.get("/renter/uploadquote?"+values.Encode(), &ruq)
+	return
+}
+
+// RenterUploadsPausePost uses the /renter/uploads endpoint to pause the upload
+// of a file. If siaPath is empty, all uploads are paused.
+func (c *Client) RenterUploadsPausePost(siaPath string) (err error) {
+	values := url.Values{}
+	values.Set("action", "pause")
+	values.Set("siapath", strings.TrimPrefix(siaPath, "/"))
+	err = c.post("/renter/uploads", values.Encode(), nil)
+	return
+}
+
+// RenterUploadsResumePost uses the /renter/uploads endpoint to resume the
+// upload of a file. If siaPath is empty, all uploads are resumed.
+func (c *Client) RenterUploadsResumePost(siaPath string) (err error) {
+	values := url.Values{}
+	values.Set("action", "resume")
+	values.Set("siapath", strings.TrimPrefix(siaPath, "/"))
+	err = c.post("/renter/uploads", values.Encode(), nil)
+	return
+}
+
+// RenterUploadStreamPost uses the /renter/uploadstream endpoint to upload the
+// data read from r to the Sia network.
+func (c *Client) RenterUploadStreamPost(r io.Reader, siaPath string, dataPieces, parityPieces uint64) (err error) {
+	siaPath = strings.TrimPrefix(siaPath, "/")
+	values := url.Values{}
+	values.Set("datapieces", strconv.FormatUint(dataPieces, 10))
+	values.Set("paritypieces", strconv.FormatUint(parityPieces, 10))
+	resource := fmt.Sprintf("/renter/uploadstream/%v?%v", siaPath, values.Encode())
+	_, err = c.postRawResponseBody(resource, r, "application/octet-stream")
+	return
+}
+
+// RenterWorkersGet requests the /renter/workers resource.
+func (c *Client) RenterWorkersGet() (rw api.RenterWorkers, err error) {
+	err = c.get("/renter/workers", &rw)
+	return
+}
+
+// setFileMetadata adds the application metadata of a file to values as
+// key=value pairs.
+func setFileMetadata(values url.Values, metadata map[string]string) {
+	for key, value := range metadata {
+		values.Add("metadata", key+"="+value)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"path/filepath"
@@ -69,6 +70,13 @@ type (
 		Settings         modules.RenterSettings     `json:"settings"`
 		FinancialMetrics modules.ContractorSpending `json:"financialmetrics"`
 		CurrentPeriod    types.BlockHeight          `json:"currentperiod"`
+		UploadsPaused    bool                       `json:"uploadspaused"`
+	}
+
+	// RenterCacheGET contains information about the renter's chunk cache on
+	// disk.
+	RenterCacheGET struct {
+		modules.ChunkCacheInfo
 	}
 
 	// RenterContract represents a contract formed by the renter.
@@ -118,6 +126,13 @@ type (
 		Contracts []RenterContract `json:"contracts"`
 	}
 
+	// RenterDirectory lists the directories and files of a renter directory.
+	// The first entry of Directories is the requested directory itself.
+	RenterDirectory struct {
+		Directories []modules.DirectoryInfo `json:"directories"`
+		Files       []modules.FileInfo      `json:"files"`
+	}
+
 	// RenterDownloadQueue contains the renter's download queue.
 	RenterDownloadQueue struct {
 		Downloads []DownloadInfo `json:"downloads"`
@@ -144,16 +159,62 @@ type (
 		modules.RenterPriceEstimation
 	}
 
+	// RenterRecoverBackupGET contains the status of the most recent recovery
+	// of a backup.
+	RenterRecoverBackupGET struct {
+		modules.BackupRecoveryStatus
+	}
+
+	// RenterRedundancyGET lists the changes of redundancy that are in
+	// progress and the ones that ended since the renter started.
+	RenterRedundancyGET struct {
+		Changes []modules.RedundancyChangeInfo `json:"changes"`
+	}
+
+	// RenterRepairQueue lists the chunks that are queued for repair.
+	RenterRepairQueue struct {
+		Chunks []modules.RepairChunkInfo `json:"chunks"`
+	}
+
+	// RenterSearch lists the files that match a search and the total number
+	// of matching files.
+	RenterSearch struct {
+		Files []modules.FileInfo `json:"files"`
+		Total uint64             `json:"total"`
+	}
+
 	// RenterShareASCII contains an ASCII-encoded .sia file.
 	RenterShareASCII struct {
 		ASCIIsia string `json:"asciisia"`
 	}
 
+	// RenterSync lists the renter's synced folders.
+	RenterSync struct {
+		Folders []modules.SyncFolderInfo `json:"folders"`
+	}
+
+	// RenterTrash lists the files in the renter's trash.
+	RenterTrash struct {
+		Files []modules.TrashedFileInfo `json:"files"`
+	}
+
+	// RenterUploadQuoteGET contains the quote that is returned when a GET call
+	// is made to /renter/uploadquote.
+	RenterUploadQuoteGET struct {
+		modules.RenterUploadQuote
+	}
+
+	// RenterWorkers lists the download statistics of the renter's workers.
+	RenterWorkers struct {
+		Workers []modules.WorkerInfo `json:"workers"`
+	}
+
 	// DownloadInfo contains all client-facing information of a file.
 	DownloadInfo struct {
 		Destination     string `json:"destination"`     // The destination of the download.
 		DestinationType string `json:"destinationtype"` // Can be "file", "memory buffer", or "http stream".
 		Filesize        uint64 `json:"filesize"`        // DEPRECATED. Same as 'Length'.
+		ID              string `json:"id"`              // The identifier used to cancel or resume the download.
 		Length          uint64 `json:"length"`          // The length requested for the download.
 		Offset          uint64 `json:"offset"`          // The offset within the siafile requested for the download.
 		SiaPath         string `json:"siapath"`         // The siapath of the file used for the download.
@@ -162,6 +223,7 @@ type (
 		EndTime              time.Time `json:"endtime"`              // The time when the download fully completed.
 		Error                string    `json:"error"`                // Will be the empty string unless there was an error.
 		Received             uint64    `json:"received"`             // Amount of data confirmed and decoded.
+		Resumable            bool      `json:"resumable"`            // Whether the download survives restarts and can be resumed after a failure.
 		StartTime            time.Time `json:"starttime"`            // The time when the download was started.
 		TotalDataTransferred uint64    `json:"totaldatatransferred"` // The total amount of data transferred, including negotiation, overdrive etc.
 	}
@@ -175,6 +237,7 @@ func (api *API) renterHandlerGET(w http.ResponseWriter, req *http.Request, _ htt
 		Settings:         settings,
 		FinancialMetrics: api.renter.PeriodSpending(),
 		CurrentPeriod:    periodStart,
+		UploadsPaused:    api.renter.UploadsPaused(),
 	})
 }
 
@@ -253,6 +316,24 @@ func (api *API) renterHandlerPOST(w http.ResponseWriter, req *http.Request, _ ht
 		}
 		settings.MaxUploadSpeed = uploadSpeed
 	}
+	// Scan the download overdrive. (optional parameter)
+	if o := req.FormValue("downloadoverdrive"); o != "" {
+		var overdrive uint64
+		if _, err := fmt.Sscan(o, &overdrive); err != nil {
+			WriteError(w, Error{"unable to parse downloadoverdrive: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		settings.DownloadOverdrive = overdrive
+	}
+	// Scan the trash retention. (optional parameter)
+	if t := req.FormValue("trashretention"); t != "" {
+		var retention time.Duration
+		if _, err := fmt.Sscan(t, &retention); err != nil {
+			WriteError(w, Error{"unable to parse trashretention: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		settings.TrashRetention = retention
+	}
 	// Set the settings in the renter.
 	err := api.renter.SetSettings(settings)
 	if err != nil {
@@ -318,6 +399,7 @@ func (api *API) renterDownloadsHandler(w http.ResponseWriter, _ *http.Request, _
 			Destination:     di.Destination,
 			DestinationType: di.DestinationType,
 			Filesize:        di.Length,
+			ID:              di.ID,
 			Length:          di.Length,
 			Offset:          di.Offset,
 			SiaPath:         di.SiaPath,
@@ -326,6 +408,7 @@ func (api *API) renterDownloadsHandler(w http.ResponseWriter, _ *http.Request, _
 			EndTime:              di.EndTime,
 			Error:                di.Error,
 			Received:             di.Received,
+			Resumable:            di.Resumable,
 			StartTime:            di.StartTime,
 			TotalDataTransferred: di.TotalDataTransferred,
 		})
@@ -337,6 +420,97 @@ func (api *API) renterDownloadsHandler(w http.ResponseWriter, _ *http.Request, _
 	})
 }
 
+// renterDownloadsHandlerPOST handles the API call to cancel or resume a
+// download.
+func (api *API) renterDownloadsHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	id := req.FormValue("id")
+	if id == "" {
+		WriteError(w, Error{"you must specify the id of the download"}, http.StatusBadRequest)
+		return
+	}
+	var err error
+	switch action := req.FormValue("action"); action {
+	case "cancel":
+		err = api.renter.CancelDownload(id)
+	case "resume":
+		err = api.renter.ResumeDownload(id)
+	case "":
+		WriteError(w, Error{"you must set the action you wish to execute"}, http.StatusBadRequest)
+		return
+	default:
+		WriteError(w, Error{"could not parse action: " + action}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterUploadsHandlerPOST handles the API call to pause or resume the upload
+// of a file or of all files.
+func (api *API) renterUploadsHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	siaPath := strings.TrimPrefix(req.FormValue("siapath"), "/")
+	var err error
+	switch action := req.FormValue("action"); action {
+	case "pause":
+		err = api.renter.PauseUploads(siaPath)
+	case "resume":
+		err = api.renter.ResumeUploads(siaPath)
+	case "":
+		WriteError(w, Error{"you must set the action you wish to execute"}, http.StatusBadRequest)
+		return
+	default:
+		WriteError(w, Error{"could not parse action: " + action}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterDirHandlerGET handles the API call to list a directory of the renter.
+func (api *API) renterDirHandlerGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	directories, files, err := api.renter.DirList(strings.TrimPrefix(ps.ByName("siapath"), "/"))
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, RenterDirectory{
+		Directories: directories,
+		Files:       files,
+	})
+}
+
+// renterDirHandlerPOST handles the API call to create, delete or rename a
+// directory of the renter.
+func (api *API) renterDirHandlerPOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	siaPath := strings.TrimPrefix(ps.ByName("siapath"), "/")
+	var err error
+	switch action := req.FormValue("action"); action {
+	case "create":
+		err = api.renter.CreateDir(siaPath)
+	case "delete":
+		err = api.renter.DeleteDir(siaPath)
+	case "rename":
+		err = api.renter.RenameDir(siaPath, strings.TrimPrefix(req.FormValue("newsiapath"), "/"))
+	case "":
+		WriteError(w, Error{"you must set the action you wish to execute"}, http.StatusBadRequest)
+		return
+	default:
+		WriteError(w, Error{"could not parse action: " + action}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
 // renterLoadHandler handles the API call to load a '.sia' file.
 func (api *API) renterLoadHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	source := req.FormValue("source")
@@ -354,7 +528,7 @@ func (api *API) renterLoadHandler(w http.ResponseWriter, req *http.Request, _ ht
 	WriteJSON(w, RenterLoad{FilesAdded: files})
 }
 
-// renterLoadAsciiHandler handles the API call to load a '.sia' file
+// renterLoadASCIIHandler handles the API call to load a '.sia' file
 // in ASCII form.
 func (api *API) renterLoadASCIIHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	files, err := api.renter.LoadSharedFilesASCII(req.FormValue("asciisia"))
@@ -366,10 +540,268 @@ func (api *API) renterLoadASCIIHandler(w http.ResponseWriter, req *http.Request,
 	WriteJSON(w, RenterLoad{FilesAdded: files})
 }
 
+// renterBackupHandlerPOST handles the API call to back up the renter's
+// metadata to its hosts.
+func (api *API) renterBackupHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := api.renter.CreateBackup(); err != nil {
+		WriteError(w, Error{"failed to create backup: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterCacheHandlerGET handles the API call to show the renter's chunk cache
+// on disk.
+func (api *API) renterCacheHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, RenterCacheGET{
+		ChunkCacheInfo: api.renter.ChunkCache(),
+	})
+}
+
+// renterCacheHandlerPOST handles the API call to set the maximum size of the
+// renter's chunk cache on disk.
+func (api *API) renterCacheHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	m := req.FormValue("maxsize")
+	if m == "" {
+		WriteError(w, Error{"maxsize must be specified"}, http.StatusBadRequest)
+		return
+	}
+	var maxSize uint64
+	if _, err := fmt.Sscan(m, &maxSize); err != nil {
+		WriteError(w, Error{"unable to parse maxsize: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := api.renter.SetChunkCacheSize(maxSize); err != nil {
+		WriteError(w, Error{"failed to set the size of the cache: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterCachePurgeHandlerPOST handles the API call to remove all chunks from
+// the renter's chunk cache on disk.
+func (api *API) renterCachePurgeHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := api.renter.PurgeChunkCache(); err != nil {
+		WriteError(w, Error{"failed to purge the cache: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterRecoverBackupHandlerGET handles the API call to show the status of the
+// most recent recovery of a backup.
+func (api *API) renterRecoverBackupHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, RenterRecoverBackupGET{
+		BackupRecoveryStatus: api.renter.RecoveryStatus(),
+	})
+}
+
+// renterRecoverBackupHandlerPOST handles the API call to start recovering the
+// renter's metadata from the most recent backup.
+func (api *API) renterRecoverBackupHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := api.renter.RecoverBackup(); err != nil {
+		WriteError(w, Error{"failed to recover backup: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterRepairHandlerGET handles the API call to list the chunks that are
+// queued for repair.
+func (api *API) renterRepairHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, RenterRepairQueue{
+		Chunks: api.renter.RepairQueue(),
+	})
+}
+
+// renterWorkersHandlerGET handles the API call to list the download statistics
+// of the renter's workers.
+func (api *API) renterWorkersHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, RenterWorkers{
+		Workers: api.renter.Workers(),
+	})
+}
+
+// renterMetadataHandlerPOST handles the API call to replace the application
+// metadata of a file.
+func (api *API) renterMetadataHandlerPOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	metadata, err := parseFileMetadata(req.Form["metadata"])
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	err = api.renter.SetFileMetadata(strings.TrimPrefix(ps.ByName("siapath"), "/"), metadata)
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterSearchHandlerGET handles the API call to search the renter's files.
+func (api *API) renterSearchHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	query := req.URL.Query()
+	metadata, err := parseFileMetadata(query["metadata"])
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	q := modules.FileSearchQuery{
+		Prefix:   query.Get("prefix"),
+		Metadata: metadata,
+	}
+	params := []struct {
+		name  string
+		value interface{}
+	}{
+		{"minsize", &q.MinSize},
+		{"maxsize", &q.MaxSize},
+		{"minhealth", &q.MinHealth},
+		{"maxhealth", &q.MaxHealth},
+		{"offset", &q.Offset},
+		{"limit", &q.Limit},
+	}
+	for _, p := range params {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		if _, err := fmt.Sscan(v, p.value); err != nil {
+			WriteError(w, Error{"unable to parse '" + p.name + "': " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	files, total := api.renter.SearchFiles(q)
+	WriteJSON(w, RenterSearch{
+		Files: files,
+		Total: total,
+	})
+}
+
+// renterSyncHandlerGET handles the API call to list the renter's synced
+// folders.
+func (api *API) renterSyncHandlerGET(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	WriteJSON(w, RenterSync{
+		Folders: api.renter.SyncFolders(),
+	})
+}
+
+// renterSyncHandlerPOST handles the API call to add or remove a synced
+// folder.
+func (api *API) renterSyncHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var err error
+	switch action := req.FormValue("action"); action {
+	case "add":
+		err = api.renter.AddSyncFolder(modules.SyncFolderParams{
+			LocalPath:    req.FormValue("localpath"),
+			SiaPath:      req.FormValue("siapath"),
+			Direction:    req.FormValue("direction"),
+			DeletePolicy: req.FormValue("deletepolicy"),
+		})
+	case "remove":
+		err = api.renter.RemoveSyncFolder(req.FormValue("localpath"))
+	case "":
+		WriteError(w, Error{"you must set the action you wish to execute"}, http.StatusBadRequest)
+		return
+	default:
+		WriteError(w, Error{"could not parse action: " + action}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterTrashHandlerGET handles the API call to list the files in the
+// renter's trash.
+func (api *API) renterTrashHandlerGET(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	WriteJSON(w, RenterTrash{
+		Files: api.renter.Trash(),
+	})
+}
+
+// renterTrashHandlerPOST handles the API call to restore a file from the
+// renter's trash or to empty the trash.
+func (api *API) renterTrashHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var err error
+	switch action := req.FormValue("action"); action {
+	case "restore":
+		err = api.renter.RestoreFile(strings.TrimPrefix(req.FormValue("siapath"), "/"))
+	case "empty":
+		err = api.renter.EmptyTrash()
+	case "":
+		WriteError(w, Error{"you must set the action you wish to execute"}, http.StatusBadRequest)
+		return
+	default:
+		WriteError(w, Error{"could not parse action: " + action}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// renterRedundancyHandlerGET handles the API call to list the changes of
+// redundancy. If a siapath is given, only the changes of that file are listed.
+func (api *API) renterRedundancyHandlerGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	siaPath := strings.TrimPrefix(ps.ByName("siapath"), "/")
+	changes := api.renter.RedundancyChanges()
+	if siaPath != "" {
+		filtered := changes[:0]
+		for _, rc := range changes {
+			if rc.SiaPath == siaPath {
+				filtered = append(filtered, rc)
+			}
+		}
+		changes = filtered
+	}
+	WriteJSON(w, RenterRedundancyGET{
+		Changes: changes,
+	})
+}
+
+// renterRedundancyHandlerPOST handles the API call to start changing the
+// erasure code of a file.
+func (api *API) renterRedundancyHandlerPOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	ec, err := parseErasureCodingParameters(req.FormValue("datapieces"), req.FormValue("paritypieces"))
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	} else if ec == nil {
+		WriteError(w, Error{"datapieces and paritypieces must be specified"}, http.StatusBadRequest)
+		return
+	}
+	err = api.renter.ChangeRedundancy(strings.TrimPrefix(ps.ByName("siapath"), "/"), ec)
+	if err != nil {
+		WriteError(w, Error{"changing the redundancy failed: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteSuccess(w)
+}
+
 // renterRenameHandler handles the API call to rename a file entry in the
-// renter.
+// renter. If replace is set, the file that has the new siapath is replaced.
 func (api *API) renterRenameHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
-	err := api.renter.RenameFile(strings.TrimPrefix(ps.ByName("siapath"), "/"), req.FormValue("newsiapath"))
+	replace, err := scanBool(req.FormValue("replace"))
+	if err != nil {
+		WriteError(w, Error{"replace parameter could not be parsed: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	siaPath, newSiaPath := strings.TrimPrefix(ps.ByName("siapath"), "/"), req.FormValue("newsiapath")
+	if replace {
+		err = api.renter.ReplaceFile(siaPath, newSiaPath)
+	} else {
+		err = api.renter.RenameFile(siaPath, newSiaPath)
+	}
 	if err != nil {
 		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
 		return
@@ -519,7 +951,13 @@ func (api *API) renterShareHandler(w http.ResponseWriter, req *http.Request, ps
 		return
 	}
 
-	err := api.renter.ShareFiles(strings.Split(req.FormValue("siapaths"), ","), destination)
+	strip, err := scanBool(req.FormValue("stripcontractids"))
+	if err != nil {
+		WriteError(w, Error{"stripcontractids parameter could not be parsed: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	err = api.renter.ShareFiles(strings.Split(req.FormValue("siapaths"), ","), destination, strip)
 	if err != nil {
 		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
 		return
@@ -528,10 +966,16 @@ func (api *API) renterShareHandler(w http.ResponseWriter, req *http.Request, ps
 	WriteSuccess(w)
 }
 
-// renterShareAsciiHandler handles the API call to return a '.sia' file
+// renterShareASCIIHandler handles the API call to return a '.sia' file
 // in ascii form.
 func (api *API) renterShareASCIIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
-	ascii, err := api.renter.ShareFilesASCII(strings.Split(req.FormValue("siapaths"), ","))
+	strip, err := scanBool(req.FormValue("stripcontractids"))
+	if err != nil {
+		WriteError(w, Error{"stripcontractids parameter could not be parsed: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	ascii, err := api.renter.ShareFilesASCII(strings.Split(req.FormValue("siapaths"), ","), strip)
 	if err != nil {
 		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
 		return
@@ -550,9 +994,81 @@ func (api *API) renterStreamHandler(w http.ResponseWriter, req *http.Request, ps
 			http.StatusInternalServerError)
 		return
 	}
+	defer streamer.Close()
 	http.ServeContent(w, req, fileName, time.Time{}, streamer)
 }
 
+// parseErasureCodingParameters parses the erasure coding parameters of an
+// upload. If neither parameter is supplied, a nil ErasureCoder is returned so
+// that the renter's defaults are used.
+func parseErasureCodingParameters(strDataPieces, strParityPieces string) (modules.ErasureCoder, error) {
+	if strDataPieces == "" && strParityPieces == "" {
+		return nil, nil
+	}
+	// Check that both values have been supplied.
+	if strDataPieces == "" || strParityPieces == "" {
+		return nil, errors.New("must provide both the datapieces paramaeter and the paritypieces parameter if specifying erasure coding parameters")
+	}
+
+	// Parse the erasure coding parameters.
+	var dataPieces, parityPieces int
+	_, err := fmt.Sscan(strDataPieces, &dataPieces)
+	if err != nil {
+		return nil, errors.New("unable to read parameter 'datapieces': " + err.Error())
+	}
+	_, err = fmt.Sscan(strParityPieces, &parityPieces)
+	if err != nil {
+		return nil, errors.New("unable to read parameter 'paritypieces': " + err.Error())
+	}
+
+	// Verify that sane values for parityPieces and redundancy are being
+	// supplied.
+	if parityPieces < requiredParityPieces {
+		return nil, fmt.Errorf("a minimum of %v parity pieces is required, but %v parity pieces requested", parityPieces, requiredParityPieces)
+	}
+	redundancy := float64(dataPieces+parityPieces) / float64(dataPieces)
+	if float64(dataPieces+parityPieces)/float64(dataPieces) < requiredRedundancy {
+		return nil, fmt.Errorf("a redundancy of %.2f is required, but redundancy of %.2f supplied", redundancy, requiredRedundancy)
+	}
+
+	// Create the erasure coder.
+	ec, err := renter.NewRSCode(dataPieces, parityPieces)
+	if err != nil {
+		return nil, errors.New("unable to encode file using the provided parameters: " + err.Error())
+	}
+	return ec, nil
+}
+
+// parseUploadPriority parses the priority of an upload. If the priority is not
+// supplied, the lowest priority is used.
+func parseUploadPriority(strPriority string) (uint64, error) {
+	var priority uint64
+	if strPriority == "" {
+		return priority, nil
+	}
+	if _, err := fmt.Sscan(strPriority, &priority); err != nil {
+		return 0, errors.New("unable to read parameter 'priority': " + err.Error())
+	}
+	return priority, nil
+}
+
+// parseFileMetadata parses the application metadata of a file, which is
+// supplied as repeated key=value parameters.
+func parseFileMetadata(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	metadata := make(map[string]string, len(values))
+	for _, v := range values {
+		i := strings.Index(v, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("unable to read parameter 'metadata': %q is not a key=value pair", v)
+		}
+		metadata[v[:i]] = v[i+1:]
+	}
+	return metadata, nil
+}
+
 // renterUploadHandler handles the API call to upload a file.
 func (api *API) renterUploadHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	source := req.FormValue("source")
@@ -562,53 +1078,123 @@ func (api *API) renterUploadHandler(w http.ResponseWriter, req *http.Request, ps
 	}
 
 	// Check whether the erasure coding parameters have been supplied.
-	var ec modules.ErasureCoder
-	if req.FormValue("datapieces") != "" || req.FormValue("paritypieces") != "" {
-		// Check that both values have been supplied.
-		if req.FormValue("datapieces") == "" || req.FormValue("paritypieces") == "" {
-			WriteError(w, Error{"must provide both the datapieces paramaeter and the paritypieces parameter if specifying erasure coding parameters"}, http.StatusBadRequest)
-			return
-		}
+	ec, err := parseErasureCodingParameters(req.FormValue("datapieces"), req.FormValue("paritypieces"))
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	priority, err := parseUploadPriority(req.FormValue("priority"))
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	dedup, err := scanBool(req.FormValue("dedup"))
+	if err != nil {
+		WriteError(w, Error{"dedup parameter could not be parsed: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	compress, err := scanBool(req.FormValue("compress"))
+	if err != nil {
+		WriteError(w, Error{"compress parameter could not be parsed: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	metadata, err := parseFileMetadata(req.Form["metadata"])
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
 
-		// Parse the erasure coding parameters.
-		var dataPieces, parityPieces int
-		_, err := fmt.Sscan(req.FormValue("datapieces"), &dataPieces)
-		if err != nil {
-			WriteError(w, Error{"unable to read parameter 'datapieces': " + err.Error()}, http.StatusBadRequest)
-			return
-		}
-		_, err = fmt.Sscan(req.FormValue("paritypieces"), &parityPieces)
-		if err != nil {
-			WriteError(w, Error{"unable to read parameter 'paritypieces': " + err.Error()}, http.StatusBadRequest)
-			return
-		}
+	// Call the renter to upload the file.
+	err = api.renter.Upload(modules.FileUploadParams{
+		Source:      source,
+		SiaPath:     strings.TrimPrefix(ps.ByName("siapath"), "/"),
+		ErasureCode: ec,
+		Priority:    priority,
+		Dedup:       dedup,
+		Compress:    compress,
+		Metadata:    metadata,
+	})
+	if err != nil {
+		WriteError(w, Error{"upload failed: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteSuccess(w)
+}
 
-		// Verify that sane values for parityPieces and redundancy are being
-		// supplied.
-		if parityPieces < requiredParityPieces {
-			WriteError(w, Error{fmt.Sprintf("a minimum of %v parity pieces is required, but %v parity pieces requested", parityPieces, requiredParityPieces)}, http.StatusBadRequest)
+// renterUploadQuoteHandlerGET handles the API call to quote the cost of an
+// upload.
+func (api *API) renterUploadQuoteHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	source := req.FormValue("source")
+	if source != "" && !filepath.IsAbs(source) {
+		WriteError(w, Error{"source must be an absolute path"}, http.StatusBadRequest)
+		return
+	}
+	var filesize uint64
+	if fs := req.FormValue("filesize"); fs != "" {
+		if source != "" {
+			WriteError(w, Error{"source and filesize can't both be specified"}, http.StatusBadRequest)
 			return
 		}
-		redundancy := float64(dataPieces+parityPieces) / float64(dataPieces)
-		if float64(dataPieces+parityPieces)/float64(dataPieces) < requiredRedundancy {
-			WriteError(w, Error{fmt.Sprintf("a redundancy of %.2f is required, but redundancy of %.2f supplied", redundancy, requiredRedundancy)}, http.StatusBadRequest)
+		if _, err := fmt.Sscan(fs, &filesize); err != nil {
+			WriteError(w, Error{"unable to parse filesize: " + err.Error()}, http.StatusBadRequest)
 			return
 		}
-
-		// Create the erasure coder.
-		ec, err = renter.NewRSCode(dataPieces, parityPieces)
-		if err != nil {
-			WriteError(w, Error{"unable to encode file using the provided parameters: " + err.Error()}, http.StatusBadRequest)
+	}
+	var duration types.BlockHeight
+	if d := req.FormValue("duration"); d != "" {
+		if _, err := fmt.Sscan(d, &duration); err != nil {
+			WriteError(w, Error{"unable to parse duration: " + err.Error()}, http.StatusBadRequest)
 			return
 		}
 	}
+	ec, err := parseErasureCodingParameters(req.FormValue("datapieces"), req.FormValue("paritypieces"))
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
 
-	// Call the renter to upload the file.
-	err := api.renter.Upload(modules.FileUploadParams{
+	quote, err := api.renter.UploadQuote(modules.UploadQuoteParams{
 		Source:      source,
-		SiaPath:     strings.TrimPrefix(ps.ByName("siapath"), "/"),
+		Filesize:    filesize,
 		ErasureCode: ec,
+		Duration:    duration,
 	})
+	if err != nil {
+		WriteError(w, Error{"unable to quote upload: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, RenterUploadQuoteGET{quote})
+}
+
+// renterUploadStreamHandler handles the API call to upload a file from the
+// data in the request body.
+func (api *API) renterUploadStreamHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	// The parameters are read from the query string, since the request body
+	// contains the file.
+	query := req.URL.Query()
+	ec, err := parseErasureCodingParameters(query.Get("datapieces"), query.Get("paritypieces"))
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	priority, err := parseUploadPriority(query.Get("priority"))
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	metadata, err := parseFileMetadata(query["metadata"])
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	// Call the renter to upload the stream.
+	err = api.renter.UploadStreamFromReader(modules.FileUploadParams{
+		SiaPath:     strings.TrimPrefix(ps.ByName("siapath"), "/"),
+		ErasureCode: ec,
+		Priority:    priority,
+		Metadata:    metadata,
+	}, req.Body)
 	if err != nil {
 		WriteError(w, Error{"upload failed: " + err.Error()}, http.StatusInternalServerError)
 		return
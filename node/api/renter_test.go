@@ -931,6 +931,18 @@ func TestRenterHandlerRename(t *testing.T) {
 	if err == nil || err.Error() != renter.ErrPathOverload.Error() {
 		t.Errorf("expected error to be %v; got %v", renter.ErrPathOverload, err)
 	}
+
+	// Replace the file that has the name.
+	renameValues.Set("replace", "true")
+	if err = st.stdPostAPI("/renter/rename/test2", renameValues); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.getAPI("/renter/files", &rf); err != nil {
+		t.Fatal(err)
+	}
+	if len(rf.Files) != 1 || rf.Files[0].SiaPath != "newtest1" || rf.Files[0].LocalPath != path2 {
+		t.Fatal("file wasn't replaced:", rf.Files)
+	}
 }
 
 // TestRenterHandlerDelete checks that deleting a valid file from the renter
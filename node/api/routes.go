@@ -69,18 +69,33 @@ func (api *API) buildHTTPRoutes(requiredUserAgent string, requiredPassword strin
 	if api.renter != nil {
 		router.GET("/renter", api.renterHandlerGET)
 		router.POST("/renter", RequirePassword(api.renterHandlerPOST, requiredPassword))
+		router.POST("/renter/backup", RequirePassword(api.renterBackupHandlerPOST, requiredPassword))
+		router.GET("/renter/cache", api.renterCacheHandlerGET)
+		router.POST("/renter/cache", RequirePassword(api.renterCacheHandlerPOST, requiredPassword))
+		router.POST("/renter/cache/purge", RequirePassword(api.renterCachePurgeHandlerPOST, requiredPassword))
 		router.GET("/renter/contracts", api.renterContractsHandler)
+		router.GET("/renter/dir/*siapath", api.renterDirHandlerGET)
+		router.POST("/renter/dir/*siapath", RequirePassword(api.renterDirHandlerPOST, requiredPassword))
 		router.GET("/renter/downloads", api.renterDownloadsHandler)
+		router.POST("/renter/downloads", RequirePassword(api.renterDownloadsHandlerPOST, requiredPassword))
 		router.GET("/renter/files", api.renterFilesHandler)
 		router.GET("/renter/file/*siapath", api.renterFileHandler)
+		router.POST("/renter/metadata/*siapath", RequirePassword(api.renterMetadataHandlerPOST, requiredPassword))
 		router.GET("/renter/prices", api.renterPricesHandler)
-
-		// TODO: re-enable these routes once the new .sia format has been
-		// standardized and implemented.
-		// router.POST("/renter/load", RequirePassword(api.renterLoadHandler, requiredPassword))
-		// router.POST("/renter/loadascii", RequirePassword(api.renterLoadAsciiHandler, requiredPassword))
-		// router.GET("/renter/share", RequirePassword(api.renterShareHandler, requiredPassword))
-		// router.GET("/renter/shareascii", RequirePassword(api.renterShareAsciiHandler, requiredPassword))
+		router.GET("/renter/recoverbackup", api.renterRecoverBackupHandlerGET)
+		router.POST("/renter/recoverbackup", RequirePassword(api.renterRecoverBackupHandlerPOST, requiredPassword))
+		router.GET("/renter/redundancy/*siapath", api.renterRedundancyHandlerGET)
+		router.POST("/renter/redundancy/*siapath", RequirePassword(api.renterRedundancyHandlerPOST, requiredPassword))
+		router.GET("/renter/repair", api.renterRepairHandlerGET)
+		router.GET("/renter/search", api.renterSearchHandlerGET)
+		router.POST("/renter/load", RequirePassword(api.renterLoadHandler, requiredPassword))
+		router.POST("/renter/loadascii", RequirePassword(api.renterLoadASCIIHandler, requiredPassword))
+		router.GET("/renter/share", RequirePassword(api.renterShareHandler, requiredPassword))
+		router.GET("/renter/shareascii", RequirePassword(api.renterShareASCIIHandler, requiredPassword))
+		router.GET("/renter/sync", api.renterSyncHandlerGET)
+		router.POST("/renter/sync", RequirePassword(api.renterSyncHandlerPOST, requiredPassword))
+		router.GET("/renter/trash", api.renterTrashHandlerGET)
+		router.POST("/renter/trash", RequirePassword(api.renterTrashHandlerPOST, requiredPassword))
 
 		router.POST("/renter/delete/*siapath", RequirePassword(api.renterDeleteHandler, requiredPassword))
 		router.GET("/renter/download/*siapath", RequirePassword(api.renterDownloadHandler, requiredPassword))
@@ -88,6 +103,10 @@ func (api *API) buildHTTPRoutes(requiredUserAgent string, requiredPassword strin
 		router.POST("/renter/rename/*siapath", RequirePassword(api.renterRenameHandler, requiredPassword))
 		router.GET("/renter/stream/*siapath", api.renterStreamHandler)
 		router.POST("/renter/upload/*siapath", RequirePassword(api.renterUploadHandler, requiredPassword))
+		router.POST("/renter/uploadstream/*siapath", RequirePassword(api.renterUploadStreamHandler, requiredPassword))
+		router.GET("/renter/uploadquote", RequirePassword(api.renterUploadQuoteHandlerGET, requiredPassword))
+		router.POST("/renter/uploads", RequirePassword(api.renterUploadsHandlerPOST, requiredPassword))
+		router.GET("/renter/workers", api.renterWorkersHandlerGET)
 
 		// HostDB endpoints.
 		router.GET("/hostdb/active", api.hostdbActiveHandler)
@@ -0,0 +1,982 @@
+package api
+
+// s3.go implements a gateway that serves the files of the renter using a
+// subset of the Amazon S3 API, so that existing S3 tools and libraries can be
+// used with Sia. Only path-style requests are supported, i.e. the bucket is
+// the first element of the path of a request.
+//
+// Buckets are the top-level directories of the renter, and the key of an
+// object is the siapath of the file within its bucket. Since the renter
+// doesn't support overwriting files, objects are uploaded to a temporary file
+// first, which replaces an existing object once the upload has completed. The
+// replaced object is moved to the renter's trash. The parts of multipart
+// uploads are stored as separate files and are concatenated into a single
+// file once the upload is completed. The directory of the parts is named after
+// the upload ID and a hash of the bucket and key of the upload, so that an
+// upload ID is only valid for the object it was created for. If the parts consist of full chunks,
+// their metadata is concatenated, otherwise their data is uploaded again.
+// Multipart uploads that haven't received a part for s3MultipartExpiry are
+// aborted. The temporary files are stored in the hidden .s3 directory, which
+// is never listed as a bucket.
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/modules/renter"
+	"github.com/NebulousLabs/fastrand"
+)
+
+const (
+	// s3Dir is the hidden directory that contains the temporary files of the
+	// gateway.
+	s3Dir = ".s3"
+
+	// s3MaxKeys is the maximum number of keys that are returned by a single
+	// list request.
+	s3MaxKeys = 1000
+
+	// s3MaxParts is the maximum number of parts of a multipart upload.
+	s3MaxParts = 10000
+
+	// s3KeepAliveInterval is the interval in which whitespace is sent while a
+	// multipart upload is completed, so that the client doesn't time out.
+	s3KeepAliveInterval = 10 * time.Second
+
+	// s3MultipartExpiry is the time after which a multipart upload that
+	// hasn't received any parts is aborted.
+	s3MultipartExpiry = 7 * 24 * time.Hour
+
+	// s3MultipartCleanupInterval is the interval in which expired multipart
+	// uploads are aborted.
+	s3MultipartCleanupInterval = time.Hour
+)
+
+var (
+	// s3BucketNameRegexp matches the names of buckets that can be created.
+	s3BucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+	// s3Epoch is reported as the modification time of objects whose
+	// modification time is unknown, such as streamed uploads.
+	s3Epoch = time.Unix(0, 0).UTC()
+)
+
+// Errors returned by the S3 gateway.
+var (
+	errS3AccessDenied           = &s3Error{"AccessDenied", "Access denied.", http.StatusForbidden}
+	errS3AuthorizationMalformed = &s3Error{"AuthorizationHeaderMalformed", "The authorization of the request is malformed.", http.StatusBadRequest}
+	errS3BadDigest              = &s3Error{"BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest}
+	errS3BucketExists           = &s3Error{"BucketAlreadyOwnedByYou", "The bucket already exists.", http.StatusConflict}
+	errS3BucketNotEmpty         = &s3Error{"BucketNotEmpty", "The bucket you tried to delete is not empty.", http.StatusConflict}
+	errS3ContentSHA256Mismatch  = &s3Error{"XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.", http.StatusBadRequest}
+	errS3IncompleteBody         = &s3Error{"IncompleteBody", "The request body is incomplete or malformed.", http.StatusBadRequest}
+	errS3InvalidAccessKey       = &s3Error{"InvalidAccessKeyId", "The access key ID you provided does not exist in our records.", http.StatusForbidden}
+	errS3InvalidBucketName      = &s3Error{"InvalidBucketName", "The specified bucket is not valid.", http.StatusBadRequest}
+	errS3InvalidContentSHA256   = &s3Error{"InvalidArgument", "The provided 'x-amz-content-sha256' header is not valid.", http.StatusBadRequest}
+	errS3InvalidDigest          = &s3Error{"InvalidDigest", "The Content-MD5 you specified is not valid.", http.StatusBadRequest}
+	errS3InvalidExpires         = &s3Error{"AuthorizationQueryParametersError", "X-Amz-Expires must be less than a week (in seconds) that is 604800.", http.StatusBadRequest}
+	errS3InvalidKey             = &s3Error{"InvalidArgument", "Keys must not be empty, end with '/' or contain empty, '.' or '..' path elements.", http.StatusBadRequest}
+	errS3InvalidMaxKeys         = &s3Error{"InvalidArgument", "max-keys must be a non-negative integer.", http.StatusBadRequest}
+	errS3InvalidPart            = &s3Error{"InvalidPart", "One or more of the specified parts could not be found.", http.StatusBadRequest}
+	errS3InvalidPartNumber      = &s3Error{"InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive.", http.StatusBadRequest}
+	errS3InvalidPartOrder       = &s3Error{"InvalidPartOrder", "The list of parts was not in ascending order.", http.StatusBadRequest}
+	errS3MalformedXML           = &s3Error{"MalformedXML", "The XML you provided was not well-formed.", http.StatusBadRequest}
+	errS3MethodNotAllowed       = &s3Error{"MethodNotAllowed", "The specified method is not allowed against this resource.", http.StatusMethodNotAllowed}
+	errS3NoSuchBucket           = &s3Error{"NoSuchBucket", "The specified bucket does not exist.", http.StatusNotFound}
+	errS3NoSuchKey              = &s3Error{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	errS3NoSuchUpload           = &s3Error{"NoSuchUpload", "The specified multipart upload does not exist.", http.StatusNotFound}
+	errS3NotImplemented         = &s3Error{"NotImplemented", "A header or query parameter you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errS3RequestExpired         = &s3Error{"AccessDenied", "Request has expired.", http.StatusForbidden}
+	errS3RequestTimeTooSkewed   = &s3Error{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.", http.StatusForbidden}
+	errS3SignatureMismatch      = &s3Error{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.", http.StatusForbidden}
+	errS3UnsupportedSignature   = &s3Error{"InvalidRequest", "Only AWS Signature Version 4 is supported.", http.StatusBadRequest}
+)
+
+// s3NotImplementedParams are subresources that are not supported.
+var s3NotImplementedParams = []string{"acl", "cors", "encryption", "lifecycle", "logging", "notification", "policy", "replication", "tagging", "uploads", "versioning", "versions", "website"}
+
+type (
+	// An S3Server serves the files of the renter using the S3 API.
+	S3Server struct {
+		handler    *s3Handler
+		httpServer *http.Server
+		listener   net.Listener
+		closeChan  chan struct{}
+	}
+
+	// s3Handler handles the requests of an S3Server. keys maps the access
+	// keys to their secret keys.
+	s3Handler struct {
+		renter modules.Renter
+		keys   map[string]string
+	}
+
+	// s3Error is an error that is returned to the client.
+	s3Error struct {
+		Code    string
+		Message string
+		status  int
+	}
+
+	// s3ErrorResponse is the XML representation of an s3Error.
+	s3ErrorResponse struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}
+
+	// s3Bucket is a bucket in the response to a ListBuckets request.
+	s3Bucket struct {
+		Name         string
+		CreationDate string
+	}
+
+	// s3ListBucketsResult is the response to a ListBuckets request.
+	s3ListBucketsResult struct {
+		XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+		Owner   s3Owner    `xml:"Owner"`
+		Buckets []s3Bucket `xml:"Buckets>Bucket"`
+	}
+
+	// s3Owner is the owner of all buckets.
+	s3Owner struct {
+		ID          string
+		DisplayName string
+	}
+
+	// s3Object is an object in the response to a ListObjects request.
+	s3Object struct {
+		Key          string
+		LastModified string
+		Size         uint64
+		StorageClass string
+	}
+
+	// s3CommonPrefix is a prefix in the response to a ListObjects request
+	// that groups the keys containing the delimiter.
+	s3CommonPrefix struct {
+		Prefix string
+	}
+
+	// s3ListObjectsResult is the response to a ListObjects or ListObjectsV2
+	// request.
+	s3ListObjectsResult struct {
+		XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		EncodingType          string `xml:",omitempty"`
+		MaxKeys               int
+		IsTruncated           bool
+		Marker                *string          `xml:",omitempty"`
+		NextMarker            string           `xml:",omitempty"`
+		ContinuationToken     string           `xml:",omitempty"`
+		NextContinuationToken string           `xml:",omitempty"`
+		StartAfter            string           `xml:",omitempty"`
+		KeyCount              *int             `xml:",omitempty"`
+		Contents              []s3Object       `xml:"Contents"`
+		CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+	}
+
+	// s3LocationConstraint is the response to a GetBucketLocation request.
+	s3LocationConstraint struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+		Location string   `xml:",chardata"`
+	}
+
+	// s3DeleteRequest is the body of a DeleteObjects request.
+	s3DeleteRequest struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+
+	// s3DeleteError is a key that couldn't be deleted by a DeleteObjects
+	// request.
+	s3DeleteError struct {
+		Key     string
+		Code    string
+		Message string
+	}
+
+	// s3DeleteResult is the response to a DeleteObjects request.
+	s3DeleteResult struct {
+		XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+		Deleted []s3Deleted     `xml:"Deleted"`
+		Errors  []s3DeleteError `xml:"Error"`
+	}
+
+	// s3Deleted is a key that was deleted by a DeleteObjects request.
+	s3Deleted struct {
+		Key string
+	}
+
+	// s3InitiateMultipartUploadResult is the response to a
+	// CreateMultipartUpload request.
+	s3InitiateMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}
+
+	// s3CompleteMultipartUpload is the body of a CompleteMultipartUpload
+	// request.
+	s3CompleteMultipartUpload struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+
+	// s3CompleteMultipartUploadResult is the response to a
+	// CompleteMultipartUpload request.
+	s3CompleteMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}
+)
+
+// Error implements the error interface.
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewS3Server creates an S3 gateway for the renter that listens on the
+// provided address. keys maps the access keys that are accepted to their
+// secret keys.
+func NewS3Server(addr string, r modules.Renter, keys map[string]string) (*S3Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	h := &s3Handler{
+		renter: r,
+		keys:   keys,
+	}
+	return &S3Server{
+		handler: h,
+		httpServer: &http.Server{
+			Handler: h,
+			// Uploads and downloads can take a long time, so only the request
+			// headers are subject to a timeout.
+			ReadHeaderTimeout: time.Minute,
+			IdleTimeout:       5 * time.Minute,
+		},
+		listener:  l,
+		closeChan: make(chan struct{}),
+	}, nil
+}
+
+// Address returns the address the S3 gateway is listening on.
+func (srv *S3Server) Address() string {
+	return srv.listener.Addr().String()
+}
+
+// Close closes the listener of the S3 gateway, causing Serve to return.
+func (srv *S3Server) Close() error {
+	close(srv.closeChan)
+	return srv.listener.Close()
+}
+
+// Serve handles S3 requests until the server is closed. It is a blocking
+// function. Expired multipart uploads are aborted while the server is running.
+func (srv *S3Server) Serve() error {
+	go srv.threadedAbortExpiredUploads()
+	err := srv.httpServer.Serve(srv.listener)
+	if err != nil && !strings.HasSuffix(err.Error(), "use of closed network connection") {
+		return err
+	}
+	return nil
+}
+
+// threadedAbortExpiredUploads periodically aborts the multipart uploads that
+// have expired, until the server is closed.
+func (srv *S3Server) threadedAbortExpiredUploads() {
+	for {
+		srv.handler.abortExpiredUploads(time.Now())
+		select {
+		case <-srv.closeChan:
+			return
+		case <-time.After(s3MultipartCleanupInterval):
+		}
+	}
+}
+
+// writeS3Error writes an error response. Errors that didn't originate from
+// the gateway are reported as internal errors.
+func writeS3Error(w http.ResponseWriter, req *http.Request, err error) {
+	s3Err, ok := err.(*s3Error)
+	if !ok {
+		s3Err = &s3Error{"InternalError", err.Error(), http.StatusInternalServerError}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(s3Err.status)
+	if req.Method == "HEAD" {
+		return
+	}
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(s3ErrorResponse{
+		Code:     s3Err.Code,
+		Message:  s3Err.Message,
+		Resource: req.URL.Path,
+	})
+}
+
+// writeS3XML writes a successful response.
+func writeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// s3Time formats a timestamp of a response.
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// s3ETag returns the quoted ETag of an MD5 hash.
+func s3ETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+// validS3Key returns true if key can be mapped to a siapath.
+func validS3Key(key string) bool {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// s3TempPath returns a new siapath for a temporary file.
+func s3TempPath() string {
+	return path.Join(s3Dir, "tmp", hex.EncodeToString(fastrand.Bytes(16)))
+}
+
+// s3UploadDir returns the directory that contains the parts of the multipart
+// upload of an object.
+func s3UploadDir(bucket, key, uploadID string) string {
+	return path.Join(s3Dir, "multipart", uploadID+"-"+crypto.HashBytes([]byte(bucket+"/"+key)).String())
+}
+
+// ServeHTTP handles an S3 request.
+func (h *s3Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := h.authenticate(req, time.Now()); err != nil {
+		writeS3Error(w, req, err)
+		return
+	}
+	bucket := strings.TrimPrefix(req.URL.Path, "/")
+	var key string
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+
+	var err error
+	switch {
+	case bucket == "" && req.Method == "GET":
+		err = h.listBuckets(w)
+	case bucket == "":
+		err = errS3MethodNotAllowed
+	case key == "":
+		err = h.serveBucket(w, req, bucket)
+	default:
+		err = h.serveObject(w, req, bucket, key)
+	}
+	if err != nil {
+		writeS3Error(w, req, err)
+	}
+}
+
+// serveBucket handles the requests that refer to a bucket.
+func (h *s3Handler) serveBucket(w http.ResponseWriter, req *http.Request, bucket string) error {
+	query := req.URL.Query()
+	for _, param := range s3NotImplementedParams {
+		if _, exists := query[param]; exists {
+			return errS3NotImplemented
+		}
+	}
+	_, deleteObjects := query["delete"]
+	_, location := query["location"]
+	switch {
+	case req.Method == "PUT":
+		return h.createBucket(w, bucket)
+	case !h.bucketExists(bucket):
+		return errS3NoSuchBucket
+	case req.Method == "HEAD":
+		return nil
+	case req.Method == "DELETE":
+		return h.deleteBucket(w, bucket)
+	case req.Method == "POST" && deleteObjects:
+		return h.deleteObjects(w, req, bucket)
+	case req.Method == "GET" && location:
+		writeS3XML(w, s3LocationConstraint{})
+		return nil
+	case req.Method == "GET":
+		return h.listObjects(w, query, bucket)
+	}
+	return errS3MethodNotAllowed
+}
+
+// serveObject handles the requests that refer to an object.
+func (h *s3Handler) serveObject(w http.ResponseWriter, req *http.Request, bucket, key string) error {
+	query := req.URL.Query()
+	for _, param := range s3NotImplementedParams {
+		if _, exists := query[param]; exists && param != "uploads" {
+			return errS3NotImplemented
+		}
+	}
+	if req.Header.Get("X-Amz-Copy-Source") != "" {
+		return errS3NotImplemented
+	}
+	if !validS3Key(key) {
+		return errS3InvalidKey
+	} else if !h.bucketExists(bucket) {
+		return errS3NoSuchBucket
+	}
+	siaPath := bucket + "/" + key
+
+	_, createUpload := query["uploads"]
+	uploadID := query.Get("uploadId")
+	switch {
+	case req.Method == "GET" || req.Method == "HEAD":
+		return h.getObject(w, req, siaPath)
+	case req.Method == "PUT" && uploadID != "":
+		return h.uploadPart(w, req, bucket, key, uploadID, query.Get("partNumber"))
+	case req.Method == "PUT":
+		return h.putObject(w, req, siaPath)
+	case req.Method == "DELETE" && uploadID != "":
+		return h.abortMultipartUpload(w, bucket, key, uploadID)
+	case req.Method == "DELETE":
+		return h.deleteObject(w, siaPath)
+	case req.Method == "POST" && createUpload:
+		return h.createMultipartUpload(w, bucket, key)
+	case req.Method == "POST" && uploadID != "":
+		return h.completeMultipartUpload(w, req, bucket, key, uploadID)
+	}
+	return errS3MethodNotAllowed
+}
+
+// bucketExists returns true if the bucket exists.
+func (h *s3Handler) bucketExists(bucket string) bool {
+	if bucket == "" || strings.HasPrefix(bucket, ".") {
+		return false
+	}
+	_, _, err := h.renter.DirList(bucket)
+	return err == nil
+}
+
+// listBuckets lists the top-level directories of the renter.
+func (h *s3Handler) listBuckets(w http.ResponseWriter) error {
+	dirs, _, err := h.renter.DirList("")
+	if err != nil {
+		return err
+	}
+	result := s3ListBucketsResult{
+		Owner:   s3Owner{ID: "sia", DisplayName: "sia"},
+		Buckets: []s3Bucket{},
+	}
+	for _, dir := range dirs[1:] {
+		if strings.HasPrefix(dir.SiaPath, ".") {
+			continue
+		}
+		result.Buckets = append(result.Buckets, s3Bucket{
+			Name:         dir.SiaPath,
+			CreationDate: s3Time(dir.LastUpdate),
+		})
+	}
+	writeS3XML(w, result)
+	return nil
+}
+
+// createBucket creates a new top-level directory.
+func (h *s3Handler) createBucket(w http.ResponseWriter, bucket string) error {
+	if !s3BucketNameRegexp.MatchString(bucket) {
+		return errS3InvalidBucketName
+	} else if _, _, err := h.renter.DirList(bucket); err == nil {
+		return errS3BucketExists
+	}
+	if err := h.renter.CreateDir(bucket); err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/"+bucket)
+	return nil
+}
+
+// deleteBucket deletes a bucket that doesn't contain any objects. Empty
+// directories within the bucket are deleted as well.
+func (h *s3Handler) deleteBucket(w http.ResponseWriter, bucket string) error {
+	dirs, _, err := h.renter.DirList(bucket)
+	if err != nil {
+		return errS3NoSuchBucket
+	} else if dirs[0].NumFiles > 0 {
+		return errS3BucketNotEmpty
+	}
+	if err := h.renter.DeleteDir(bucket); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// listObjects lists the objects of a bucket. Both version 1 and version 2 of
+// the ListObjects request are supported.
+func (h *s3Handler) listObjects(w http.ResponseWriter, query url.Values, bucket string) error {
+	result := s3ListObjectsResult{
+		Name:      bucket,
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		MaxKeys:   s3MaxKeys,
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.Atoi(maxKeys)
+		if err != nil || n < 0 {
+			return errS3InvalidMaxKeys
+		} else if n < s3MaxKeys {
+			result.MaxKeys = n
+		}
+	}
+	v2 := query.Get("list-type") == "2"
+	var marker string
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		result.ContinuationToken = query.Get("continuation-token")
+		marker = result.StartAfter
+		if result.ContinuationToken != "" {
+			token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				return &s3Error{"InvalidArgument", "The continuation token provided is incorrect.", http.StatusBadRequest}
+			}
+			marker = string(token)
+		}
+	} else {
+		marker = query.Get("marker")
+		result.Marker = &marker
+	}
+
+	// Collect the keys of the bucket in lexicographical order.
+	var objects []s3Object
+	for _, fi := range h.renter.FileList() {
+		if !strings.HasPrefix(fi.SiaPath, bucket+"/") {
+			continue
+		}
+		modTime := fi.ModTime
+		if modTime.IsZero() {
+			modTime = s3Epoch
+		}
+		objects = append(objects, s3Object{
+			Key:          strings.TrimPrefix(fi.SiaPath, bucket+"/"),
+			LastModified: s3Time(modTime),
+			Size:         fi.Filesize,
+			StorageClass: "STANDARD",
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	// Keys that contain the delimiter after the prefix are grouped into
+	// common prefixes. A common prefix counts as a single key.
+	var last string
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Key, result.Prefix) || obj.Key <= marker {
+			continue
+		}
+		commonPrefix := ""
+		if result.Delimiter != "" {
+			if i := strings.Index(obj.Key[len(result.Prefix):], result.Delimiter); i >= 0 {
+				commonPrefix = obj.Key[:len(result.Prefix)+i+len(result.Delimiter)]
+			}
+		}
+		if commonPrefix != "" && (commonPrefix == last || strings.HasPrefix(marker, commonPrefix)) {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == result.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: commonPrefix})
+			last = commonPrefix
+		} else {
+			result.Contents = append(result.Contents, obj)
+			last = obj.Key
+		}
+	}
+	if result.IsTruncated && v2 {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	} else if result.IsTruncated {
+		result.NextMarker = last
+	}
+	if v2 {
+		keyCount := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &keyCount
+	}
+
+	// Encode the keys if the client asked for it.
+	if query.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		result.Prefix = url.QueryEscape(result.Prefix)
+		result.Delimiter = url.QueryEscape(result.Delimiter)
+		result.StartAfter = url.QueryEscape(result.StartAfter)
+		result.NextMarker = url.QueryEscape(result.NextMarker)
+		if result.Marker != nil {
+			encoded := url.QueryEscape(*result.Marker)
+			result.Marker = &encoded
+		}
+		for i := range result.Contents {
+			result.Contents[i].Key = url.QueryEscape(result.Contents[i].Key)
+		}
+		for i := range result.CommonPrefixes {
+			result.CommonPrefixes[i].Prefix = url.QueryEscape(result.CommonPrefixes[i].Prefix)
+		}
+	}
+	writeS3XML(w, result)
+	return nil
+}
+
+// getObject serves the contents of a file. Range requests are supported.
+func (h *s3Handler) getObject(w http.ResponseWriter, req *http.Request, siaPath string) error {
+	fi, err := h.renter.File(siaPath)
+	if err != nil {
+		return errS3NoSuchKey
+	}
+	fileName, streamer, err := h.renter.Streamer(siaPath)
+	if err != nil {
+		return err
+	}
+	// Setting the content type prevents ServeContent from downloading the
+	// beginning of the file to detect it.
+	contentType := mime.TypeByExtension(path.Ext(fileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	defer streamer.Close()
+	http.ServeContent(w, req, fileName, fi.ModTime, streamer)
+	return nil
+}
+
+// upload uploads the body of a request to a temporary file and returns its
+// siapath and the MD5 hash of the body. If the request contains a Content-MD5
+// header, it is verified.
+func (h *s3Handler) upload(req *http.Request) (string, []byte, error) {
+	var expected []byte
+	if contentMD5 := req.Header.Get("Content-MD5"); contentMD5 != "" {
+		var err error
+		expected, err = base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expected) != md5.Size {
+			return "", nil, errS3InvalidDigest
+		}
+	}
+
+	tempPath := s3TempPath()
+	hash := md5.New()
+	body := io.TeeReader(req.Body, hash)
+	if err := h.renter.UploadStreamFromReader(modules.FileUploadParams{SiaPath: tempPath}, body); err != nil {
+		if br, ok := req.Body.(*s3BodyReader); ok && br.err != nil {
+			return "", nil, br.err
+		}
+		return "", nil, err
+	}
+	sum := hash.Sum(nil)
+	if expected != nil && string(sum) != string(expected) {
+		h.renter.DeleteFile(tempPath)
+		return "", nil, errS3BadDigest
+	}
+	return tempPath, sum, nil
+}
+
+// replaceFile moves the temporary file at tempPath to siaPath, replacing an
+// existing file in a single step. The temporary file is deleted if it can't be
+// moved.
+func (h *s3Handler) replaceFile(tempPath, siaPath string) error {
+	if err := h.renter.ReplaceFile(tempPath, siaPath); err != nil {
+		h.renter.DeleteFile(tempPath)
+		return err
+	}
+	return nil
+}
+
+// putObject uploads a new object.
+func (h *s3Handler) putObject(w http.ResponseWriter, req *http.Request, siaPath string) error {
+	tempPath, sum, err := h.upload(req)
+	if err != nil {
+		return err
+	}
+	if err := h.replaceFile(tempPath, siaPath); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", s3ETag(sum))
+	return nil
+}
+
+// deleteObject deletes an object. Deleting an object that doesn't exist is
+// not an error.
+func (h *s3Handler) deleteObject(w http.ResponseWriter, siaPath string) error {
+	if err := h.renter.DeleteFile(siaPath); err != nil && err != renter.ErrUnknownPath {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// deleteObjects deletes multiple objects of a bucket.
+func (h *s3Handler) deleteObjects(w http.ResponseWriter, req *http.Request, bucket string) error {
+	var request s3DeleteRequest
+	if err := xml.NewDecoder(req.Body).Decode(&request); err != nil {
+		if br, ok := req.Body.(*s3BodyReader); ok && br.err != nil {
+			return br.err
+		}
+		return errS3MalformedXML
+	}
+	var result s3DeleteResult
+	for _, obj := range request.Objects {
+		var deleteErr *s3DeleteError
+		if !validS3Key(obj.Key) {
+			deleteErr = &s3DeleteError{obj.Key, errS3InvalidKey.Code, errS3InvalidKey.Message}
+		} else if err := h.renter.DeleteFile(bucket + "/" + obj.Key); err != nil && err != renter.ErrUnknownPath {
+			deleteErr = &s3DeleteError{obj.Key, "InternalError", err.Error()}
+		}
+		if deleteErr != nil {
+			result.Errors = append(result.Errors, *deleteErr)
+		} else if !request.Quiet {
+			result.Deleted = append(result.Deleted, s3Deleted{Key: obj.Key})
+		}
+	}
+	writeS3XML(w, result)
+	return nil
+}
+
+// createMultipartUpload starts a new multipart upload. The parts of the
+// upload are stored in their own directory.
+func (h *s3Handler) createMultipartUpload(w http.ResponseWriter, bucket, key string) error {
+	uploadID := hex.EncodeToString(fastrand.Bytes(16))
+	if err := h.renter.CreateDir(s3UploadDir(bucket, key, uploadID)); err != nil {
+		return err
+	}
+	writeS3XML(w, s3InitiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+	return nil
+}
+
+// uploadParts returns the parts of the multipart upload of an object. The
+// parts are stored as files named "<part number>-<md5 hash>". errS3NoSuchUpload
+// is returned if the upload doesn't exist or belongs to another object.
+func (h *s3Handler) uploadParts(bucket, key, uploadID string) (map[int]modules.FileInfo, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil {
+		return nil, errS3NoSuchUpload
+	}
+	_, files, err := h.renter.DirList(s3UploadDir(bucket, key, uploadID))
+	if err != nil {
+		return nil, errS3NoSuchUpload
+	}
+	parts := make(map[int]modules.FileInfo)
+	for _, fi := range files {
+		var number int
+		if _, err := fmt.Sscanf(path.Base(fi.SiaPath), "%d-", &number); err == nil {
+			parts[number] = fi
+		}
+	}
+	return parts, nil
+}
+
+// uploadPart uploads a part of a multipart upload. An existing part with the
+// same number is replaced.
+func (h *s3Handler) uploadPart(w http.ResponseWriter, req *http.Request, bucket, key, uploadID, partNumber string) error {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 || number > s3MaxParts {
+		return errS3InvalidPartNumber
+	}
+	if _, err := h.uploadParts(bucket, key, uploadID); err != nil {
+		return err
+	}
+	tempPath, sum, err := h.upload(req)
+	if err != nil {
+		return err
+	}
+	parts, err := h.uploadParts(bucket, key, uploadID)
+	if err != nil {
+		h.renter.DeleteFile(tempPath)
+		return err
+	}
+	partPath := path.Join(s3UploadDir(bucket, key, uploadID), fmt.Sprintf("%d-%x", number, sum))
+	if err := h.replaceFile(tempPath, partPath); err != nil {
+		return err
+	}
+	// The previous upload of the part is only deleted once the new upload
+	// has taken its place. A part with the same contents has the same path
+	// and was replaced already.
+	if old, exists := parts[number]; exists && old.SiaPath != partPath {
+		h.renter.DeleteFile(old.SiaPath)
+	}
+	w.Header().Set("ETag", s3ETag(sum))
+	return nil
+}
+
+// abortExpiredUploads aborts the multipart uploads that haven't received any
+// parts for s3MultipartExpiry.
+func (h *s3Handler) abortExpiredUploads(now time.Time) {
+	dirs, _, err := h.renter.DirList(path.Join(s3Dir, "multipart"))
+	if err != nil || len(dirs) == 0 {
+		return
+	}
+	for _, dir := range dirs[1:] {
+		if now.Sub(dir.LastUpdate) >= s3MultipartExpiry {
+			h.renter.DeleteDir(dir.SiaPath)
+		}
+	}
+}
+
+// abortMultipartUpload deletes the parts of a multipart upload.
+func (h *s3Handler) abortMultipartUpload(w http.ResponseWriter, bucket, key, uploadID string) error {
+	if _, err := h.uploadParts(bucket, key, uploadID); err != nil {
+		return err
+	}
+	if err := h.renter.DeleteDir(s3UploadDir(bucket, key, uploadID)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// completeMultipartUpload concatenates the parts of a multipart upload into
+// the object. If the renter can't concatenate the metadata of the parts, their
+// data is downloaded and uploaded again. Since that can take a long time,
+// whitespace is sent to the client until the upload is complete, like S3
+// does. Because the response has already started, errors that occur while the
+// parts are concatenated are reported in the body of the response.
+func (h *s3Handler) completeMultipartUpload(w http.ResponseWriter, req *http.Request, bucket, key, uploadID string) error {
+	parts, err := h.uploadParts(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	var request s3CompleteMultipartUpload
+	if err := xml.NewDecoder(req.Body).Decode(&request); err != nil {
+		if br, ok := req.Body.(*s3BodyReader); ok && br.err != nil {
+			return br.err
+		}
+		return errS3MalformedXML
+	} else if len(request.Parts) == 0 {
+		return errS3MalformedXML
+	}
+
+	// Check that the parts exist and are in ascending order. The ETag of the
+	// object is the hash of the hashes of its parts.
+	var partPaths []string
+	etagHash := md5.New()
+	for i, part := range request.Parts {
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			return errS3InvalidPartOrder
+		}
+		fi, exists := parts[part.PartNumber]
+		sum := strings.Trim(part.ETag, `"`)
+		if !exists || path.Base(fi.SiaPath) != fmt.Sprintf("%d-%s", part.PartNumber, sum) {
+			return errS3InvalidPart
+		}
+		partHash, _ := hex.DecodeString(sum)
+		etagHash.Write(partHash)
+		partPaths = append(partPaths, fi.SiaPath)
+	}
+	etag := fmt.Sprintf(`"%x-%d"`, etagHash.Sum(nil), len(request.Parts))
+	result := s3CompleteMultipartUploadResult{
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag,
+	}
+
+	// Try to concatenate the metadata of the parts first.
+	tempPath := s3TempPath()
+	err = h.renter.ConcatFiles(partPaths, tempPath)
+	if err == nil {
+		if err := h.replaceFile(tempPath, bucket+"/"+key); err != nil {
+			return err
+		}
+		h.renter.DeleteDir(s3UploadDir(bucket, key, uploadID))
+		writeS3XML(w, result)
+		return nil
+	} else if err != renter.ErrConcatIncompatible {
+		return err
+	}
+	var streamers []modules.Streamer
+	for _, partPath := range partPaths {
+		_, streamer, err := h.renter.Streamer(partPath)
+		if err != nil {
+			for _, streamer := range streamers {
+				streamer.Close()
+			}
+			return err
+		}
+		streamers = append(streamers, streamer)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	flusher, _ := w.(http.Flusher)
+	done := make(chan error, 1)
+	go func() {
+		readers := make([]io.Reader, len(streamers))
+		for i, streamer := range streamers {
+			readers[i] = streamer
+			defer streamer.Close()
+		}
+		err := h.renter.UploadStreamFromReader(modules.FileUploadParams{SiaPath: tempPath}, io.MultiReader(readers...))
+		if err == nil {
+			err = h.replaceFile(tempPath, bucket+"/"+key)
+		}
+		done <- err
+	}()
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case err = <-done:
+		case <-time.After(s3KeepAliveInterval):
+			io.WriteString(w, " ")
+			continue
+		}
+		break
+	}
+	if err != nil {
+		xml.NewEncoder(w).Encode(s3ErrorResponse{
+			Code:     "InternalError",
+			Message:  err.Error(),
+			Resource: req.URL.Path,
+		})
+		return nil
+	}
+	h.renter.DeleteDir(s3UploadDir(bucket, key, uploadID))
+	xml.NewEncoder(w).Encode(result)
+	return nil
+}
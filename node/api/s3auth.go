@@ -0,0 +1,463 @@
+package api
+
+// s3auth.go verifies the AWS Signature Version 4 of requests to the S3
+// gateway. Requests are signed either in the Authorization header or, for
+// presigned URLs, in the query string. The payload of a request is either
+// unsigned, signed with its SHA256 hash, or sent using the aws-chunked
+// encoding, in which case every chunk is signed individually.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3SigningAlgorithm is the only signing algorithm that is supported.
+	s3SigningAlgorithm = "AWS4-HMAC-SHA256"
+
+	// s3TimeFormat is the format of the X-Amz-Date header.
+	s3TimeFormat = "20060102T150405Z"
+
+	// s3MaxClockSkew is the maximum difference between the time a request was
+	// signed and the time it is received.
+	s3MaxClockSkew = 15 * time.Minute
+
+	// s3MaxPresignExpiry is the maximum time for which a presigned request is
+	// valid.
+	s3MaxPresignExpiry = 7 * 24 * time.Hour
+
+	// s3MaxChunkSize is the maximum size of a single chunk of an aws-chunked
+	// payload.
+	s3MaxChunkSize = 16 << 20
+
+	// The values of the X-Amz-Content-Sha256 header that don't contain the
+	// hash of the payload.
+	s3UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	s3StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	s3StreamingUnsignedPayload = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// s3EmptySHA256 is the hex encoded SHA256 hash of an empty payload.
+	s3EmptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Signature contains the parameters of a signed request.
+type s3Signature struct {
+	accessKey     string
+	amzDate       string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	presigned     bool
+}
+
+// scope returns the credential scope of the signature.
+func (sig s3Signature) scope() string {
+	return strings.Join([]string{sig.date, sig.region, sig.service, "aws4_request"}, "/")
+}
+
+// ParseS3Keys parses a comma separated list of access key pairs of the form
+// "accesskey:secretkey".
+func ParseS3Keys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, ":")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, fmt.Errorf("invalid S3 key pair %q, expected accesskey:secretkey", pair)
+		}
+		keys[pair[:i]] = pair[i+1:]
+	}
+	return keys, nil
+}
+
+// s3URIEncode encodes s as specified by Signature Version 4. Every byte except
+// for the unreserved characters is percent-encoded, '/' is only encoded if
+// encodeSlash is true.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// s3HMAC returns the HMAC-SHA256 of data.
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3SHA256 returns the hex encoded SHA256 hash of data.
+func s3SHA256(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// s3SigningKey derives the key that signs the requests of a day, region and
+// service from the secret key.
+func s3SigningKey(secret, date, region, service string) []byte {
+	key := s3HMAC([]byte("AWS4"+secret), date)
+	key = s3HMAC(key, region)
+	key = s3HMAC(key, service)
+	return s3HMAC(key, "aws4_request")
+}
+
+// s3CanonicalRequest returns the canonical form of a request.
+func s3CanonicalRequest(req *http.Request, sig s3Signature, payloadHash string) string {
+	// The query parameters are sorted by their encoded keys and values. The
+	// signature of a presigned request isn't part of the canonical request.
+	var params []string
+	for key, values := range req.URL.Query() {
+		if sig.presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	var headers bytes.Buffer
+	for _, name := range sig.signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = req.Host
+		case "content-length":
+			value = strconv.FormatInt(req.ContentLength, 10)
+		default:
+			value = strings.Join(req.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, false),
+		strings.Join(params, "&"),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// s3StringToSign returns the string that is signed for a request.
+func s3StringToSign(sig s3Signature, canonicalRequest string) string {
+	return strings.Join([]string{
+		s3SigningAlgorithm,
+		sig.amzDate,
+		sig.scope(),
+		s3SHA256([]byte(canonicalRequest)),
+	}, "\n")
+}
+
+// parseS3Credential parses the credential of a request, which is of the form
+// "accesskey/date/region/service/aws4_request".
+func parseS3Credential(credential string, sig *s3Signature) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return errS3AuthorizationMalformed
+	}
+	sig.accessKey, sig.date, sig.region, sig.service = parts[0], parts[1], parts[2], parts[3]
+	return nil
+}
+
+// parseS3Signature parses the signature of a request from its Authorization
+// header or its query string.
+func parseS3Signature(req *http.Request) (s3Signature, error) {
+	var sig s3Signature
+	query := req.URL.Query()
+	auth := req.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, s3SigningAlgorithm+" "):
+		for _, field := range strings.Split(strings.TrimPrefix(auth, s3SigningAlgorithm+" "), ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 {
+				return s3Signature{}, errS3AuthorizationMalformed
+			}
+			switch kv[0] {
+			case "Credential":
+				if err := parseS3Credential(kv[1], &sig); err != nil {
+					return s3Signature{}, err
+				}
+			case "SignedHeaders":
+				sig.signedHeaders = strings.Split(kv[1], ";")
+			case "Signature":
+				sig.signature = kv[1]
+			}
+		}
+		sig.amzDate = req.Header.Get("X-Amz-Date")
+	case query.Get("X-Amz-Algorithm") == s3SigningAlgorithm:
+		if err := parseS3Credential(query.Get("X-Amz-Credential"), &sig); err != nil {
+			return s3Signature{}, err
+		}
+		sig.signedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+		sig.signature = query.Get("X-Amz-Signature")
+		sig.amzDate = query.Get("X-Amz-Date")
+		sig.presigned = true
+	case auth != "" || query.Get("X-Amz-Algorithm") != "":
+		return s3Signature{}, errS3UnsupportedSignature
+	default:
+		return s3Signature{}, errS3AccessDenied
+	}
+
+	if sig.signature == "" || sig.amzDate == "" || sig.service != "s3" {
+		return s3Signature{}, errS3AuthorizationMalformed
+	}
+	if !sort.StringsAreSorted(sig.signedHeaders) {
+		return s3Signature{}, errS3AuthorizationMalformed
+	}
+	signsHost := false
+	for _, name := range sig.signedHeaders {
+		signsHost = signsHost || name == "host"
+	}
+	if !signsHost {
+		return s3Signature{}, errS3AuthorizationMalformed
+	}
+	return sig, nil
+}
+
+// authenticate verifies the signature of a request. If the payload of the
+// request is signed, the body of the request is replaced by a reader that
+// verifies the payload while it is read.
+func (h *s3Handler) authenticate(req *http.Request, now time.Time) error {
+	sig, err := parseS3Signature(req)
+	if err != nil {
+		return err
+	}
+	secret, exists := h.keys[sig.accessKey]
+	if !exists {
+		return errS3InvalidAccessKey
+	}
+
+	// Check that the request isn't too old or has expired.
+	signTime, err := time.Parse(s3TimeFormat, sig.amzDate)
+	if err != nil || !strings.HasPrefix(sig.amzDate, sig.date) {
+		return errS3AuthorizationMalformed
+	}
+	if sig.presigned {
+		expires, err := strconv.Atoi(req.URL.Query().Get("X-Amz-Expires"))
+		if err != nil || expires < 0 {
+			return errS3AuthorizationMalformed
+		} else if time.Duration(expires)*time.Second > s3MaxPresignExpiry {
+			return errS3InvalidExpires
+		}
+		if now.After(signTime.Add(time.Duration(expires) * time.Second)) {
+			return errS3RequestExpired
+		} else if signTime.After(now.Add(s3MaxClockSkew)) {
+			return errS3RequestTimeTooSkewed
+		}
+	} else if signTime.Before(now.Add(-s3MaxClockSkew)) || signTime.After(now.Add(s3MaxClockSkew)) {
+		return errS3RequestTimeTooSkewed
+	}
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if sig.presigned && payloadHash == "" {
+		payloadHash = s3UnsignedPayload
+	} else if payloadHash == "" {
+		return errS3AuthorizationMalformed
+	}
+	signingKey := s3SigningKey(secret, sig.date, sig.region, sig.service)
+	expected := hex.EncodeToString(s3HMAC(signingKey, s3StringToSign(sig, s3CanonicalRequest(req, sig, payloadHash))))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return errS3SignatureMismatch
+	}
+
+	// Verify the payload while it is read.
+	switch payloadHash {
+	case s3UnsignedPayload:
+		req.Body = &s3BodyReader{r: req.Body}
+	case s3StreamingPayload, s3StreamingUnsignedPayload:
+		cr := &s3ChunkedReader{
+			r: bufio.NewReader(req.Body),
+		}
+		if payloadHash == s3StreamingPayload {
+			cr.signingKey = signingKey
+			cr.amzDate = sig.amzDate
+			cr.scope = sig.scope()
+			cr.prevSignature = sig.signature
+		}
+		req.Body = &s3BodyReader{r: cr}
+		req.ContentLength = -1
+		if length, err := strconv.ParseInt(req.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+			req.ContentLength = length
+		}
+	default:
+		if _, err := hex.DecodeString(payloadHash); err != nil || len(payloadHash) != sha256.Size*2 {
+			return errS3InvalidContentSHA256
+		}
+		req.Body = &s3BodyReader{
+			r:        req.Body,
+			hash:     sha256.New(),
+			expected: payloadHash,
+		}
+	}
+	return nil
+}
+
+// s3BodyReader reads the body of a request. If expected is set, it verifies
+// that the hash of the body matches expected once the body has been read. The
+// first error is kept, so that it can be reported even if the reader of the
+// body discards it.
+type s3BodyReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+	err      error
+}
+
+// Read implements the io.Reader interface.
+func (br *s3BodyReader) Read(p []byte) (int, error) {
+	if br.err != nil {
+		return 0, br.err
+	}
+	n, err := br.r.Read(p)
+	if br.hash != nil {
+		br.hash.Write(p[:n])
+	}
+	if err == io.EOF && br.hash != nil && hex.EncodeToString(br.hash.Sum(nil)) != br.expected {
+		err = errS3ContentSHA256Mismatch
+	} else if err == io.ErrUnexpectedEOF {
+		err = errS3IncompleteBody
+	}
+	if err != nil && err != io.EOF {
+		br.err = err
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface. The body of the request is closed
+// by the http server.
+func (br *s3BodyReader) Close() error {
+	return nil
+}
+
+// s3ChunkedReader decodes a payload that uses the aws-chunked encoding. Every
+// chunk is preceded by its hex encoded size and, if signingKey is set, by its
+// signature, which is verified before the data of the chunk is returned.
+type s3ChunkedReader struct {
+	r    *bufio.Reader
+	buf  []byte
+	done bool
+
+	signingKey    []byte
+	amzDate       string
+	scope         string
+	prevSignature string
+}
+
+// Read implements the io.Reader interface.
+func (cr *s3ChunkedReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+// readLine reads a line that is terminated by "\r\n".
+func (cr *s3ChunkedReader) readLine() (string, error) {
+	line, err := cr.r.ReadString('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	} else if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errS3IncompleteBody
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// readChunk reads and verifies the next chunk of the payload.
+func (cr *s3ChunkedReader) readChunk() error {
+	header, err := cr.readLine()
+	if err != nil {
+		return err
+	}
+	fields := strings.SplitN(header, ";", 2)
+	size, err := strconv.ParseUint(fields[0], 16, 64)
+	if err != nil || size > s3MaxChunkSize {
+		return errS3IncompleteBody
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, data); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+
+	if cr.signingKey != nil {
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "chunk-signature=") {
+			return errS3SignatureMismatch
+		}
+		signature := strings.TrimPrefix(fields[1], "chunk-signature=")
+		stringToSign := strings.Join([]string{
+			s3SigningAlgorithm + "-PAYLOAD",
+			cr.amzDate,
+			cr.scope,
+			cr.prevSignature,
+			s3EmptySHA256,
+			s3SHA256(data),
+		}, "\n")
+		expected := hex.EncodeToString(s3HMAC(cr.signingKey, stringToSign))
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			return errS3SignatureMismatch
+		}
+		cr.prevSignature = signature
+	}
+
+	// The last chunk is empty and is followed by optional trailing headers,
+	// which are terminated by an empty line.
+	if size == 0 {
+		for {
+			line, err := cr.readLine()
+			if err != nil {
+				return err
+			} else if line == "" {
+				break
+			}
+		}
+		cr.done = true
+		return nil
+	}
+	if line, err := cr.readLine(); err != nil {
+		return err
+	} else if line != "" {
+		return errS3IncompleteBody
+	}
+	cr.buf = data
+	return nil
+}
@@ -0,0 +1,511 @@
+package api
+
+// webdav.go implements a WebDAV (RFC 4918, class 1) server on top of the
+// renter, allowing the renter's files to be mounted by file managers and other
+// WebDAV clients. Directories of the renter are served as collections.
+//
+// PROPFIND lists the renter's directories, GET and HEAD are served through the
+// renter's seekable streamer, which supports range requests, and PUT streams
+// the request body into a new file. Since the renter doesn't support
+// overwriting a file, a PUT to an existing file is uploaded to a temporary
+// file first, which replaces the existing file once the upload has completed.
+// A resource that is replaced by a PUT or MOVE is moved aside first and only
+// deleted once its replacement is in place, so a failed replace leaves it
+// untouched. The temporary files of the WebDAV server and the hidden directory
+// of the S3 gateway are neither listed nor accessible. Locking is not
+// supported.
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/modules/renter"
+	"github.com/NebulousLabs/fastrand"
+)
+
+const (
+	// webdavMethods are the methods that are supported by the WebDAV server.
+	webdavMethods = "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE, MKCOL, MOVE"
+
+	// webdavTempPrefix is the prefix of the temporary files that are used to
+	// overwrite existing files.
+	webdavTempPrefix = ".webdav-upload-"
+
+	// webdavReplacedPrefix is the prefix of the temporary paths that existing
+	// resources are moved to while they are being replaced.
+	webdavReplacedPrefix = ".webdav-replaced-"
+)
+
+var (
+	// errWebDAVNotFound is returned if a request refers to a file or directory
+	// that doesn't exist.
+	errWebDAVNotFound = errors.New("no file or directory with that path")
+)
+
+type (
+	// A WebDAVServer serves the files of the renter over WebDAV.
+	WebDAVServer struct {
+		httpServer *http.Server
+		listener   net.Listener
+	}
+
+	// webdavHandler handles the WebDAV requests of a WebDAVServer.
+	webdavHandler struct {
+		renter   modules.Renter
+		password string
+	}
+
+	// davResource is a file or a directory of the renter. If dir is nil, the
+	// resource is a file.
+	davResource struct {
+		siaPath string
+		dir     *modules.DirectoryInfo
+		file    modules.FileInfo
+	}
+
+	// davMultistatus is the response to a PROPFIND request.
+	davMultistatus struct {
+		XMLName   xml.Name      `xml:"D:multistatus"`
+		XMLNS     string        `xml:"xmlns:D,attr"`
+		Responses []davResponse `xml:"D:response"`
+	}
+
+	// davResponse contains the properties of a single resource.
+	davResponse struct {
+		Href     string      `xml:"D:href"`
+		Propstat davPropstat `xml:"D:propstat"`
+	}
+
+	// davPropstat groups the properties of a resource with their status.
+	davPropstat struct {
+		Prop   davProp `xml:"D:prop"`
+		Status string  `xml:"D:status"`
+	}
+
+	// davProp are the properties of a resource that are reported by PROPFIND.
+	davProp struct {
+		DisplayName   string          `xml:"D:displayname"`
+		ResourceType  davResourceType `xml:"D:resourcetype"`
+		ContentLength *uint64         `xml:"D:getcontentlength,omitempty"`
+		LastModified  string          `xml:"D:getlastmodified,omitempty"`
+	}
+
+	// davResourceType contains an empty collection element if the resource is
+	// a directory.
+	davResourceType struct {
+		Collection *struct{} `xml:"D:collection,omitempty"`
+	}
+)
+
+// NewWebDAVServer creates a WebDAV server for the renter that listens on the
+// provided address. Like the API, the server requires authentication using
+// HTTP basic auth if the password is not the empty string.
+func NewWebDAVServer(addr string, r modules.Renter, password string) (*WebDAVServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &WebDAVServer{
+		httpServer: &http.Server{
+			Handler: &webdavHandler{
+				renter:   r,
+				password: password,
+			},
+			// Uploads and downloads can take a long time, so only the request
+			// headers are subject to a timeout.
+			ReadHeaderTimeout: time.Minute,
+			IdleTimeout:       5 * time.Minute,
+		},
+		listener: l,
+	}, nil
+}
+
+// Address returns the address the WebDAV server is listening on.
+func (srv *WebDAVServer) Address() string {
+	return srv.listener.Addr().String()
+}
+
+// Close closes the listener of the WebDAV server, causing Serve to return.
+func (srv *WebDAVServer) Close() error {
+	return srv.listener.Close()
+}
+
+// Serve handles WebDAV requests until the server is closed. It is a blocking
+// function.
+func (srv *WebDAVServer) Serve() error {
+	err := srv.httpServer.Serve(srv.listener)
+	if err != nil && !strings.HasSuffix(err.Error(), "use of closed network connection") {
+		return err
+	}
+	return nil
+}
+
+// davSiaPath converts the path of a request to a siapath.
+func davSiaPath(urlPath string) string {
+	return strings.Trim(path.Clean("/"+urlPath), "/")
+}
+
+// davReserved returns true if siaPath is used internally by the WebDAV server
+// or the S3 gateway and therefore can't be accessed over WebDAV.
+func davReserved(siaPath string) bool {
+	if siaPath == s3Dir || strings.HasPrefix(siaPath, s3Dir+"/") {
+		return true
+	}
+	for _, name := range strings.Split(siaPath, "/") {
+		if strings.HasPrefix(name, webdavTempPrefix) || strings.HasPrefix(name, webdavReplacedPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// davParent returns the siapath of the directory containing siaPath.
+func davParent(siaPath string) string {
+	parent := path.Dir(siaPath)
+	if parent == "." {
+		return ""
+	}
+	return parent
+}
+
+// href returns the escaped path of the resource. The path of a directory ends
+// with a slash.
+func (res davResource) href() string {
+	p := "/" + res.siaPath
+	if res.dir != nil && res.siaPath != "" {
+		p += "/"
+	}
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// response returns the PROPFIND response of the resource.
+func (res davResource) response() davResponse {
+	prop := davProp{
+		DisplayName: path.Base("/" + res.siaPath),
+	}
+	if res.siaPath == "" {
+		prop.DisplayName = ""
+	}
+	if res.dir != nil {
+		prop.ResourceType.Collection = &struct{}{}
+		if !res.dir.LastUpdate.IsZero() {
+			prop.LastModified = res.dir.LastUpdate.UTC().Format(http.TimeFormat)
+		}
+	} else {
+		size := res.file.Filesize
+		prop.ContentLength = &size
+		if !res.file.ModTime.IsZero() {
+			prop.LastModified = res.file.ModTime.UTC().Format(http.TimeFormat)
+		}
+	}
+	return davResponse{
+		Href: res.href(),
+		Propstat: davPropstat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// stat returns the file or directory at siaPath.
+func (h *webdavHandler) stat(siaPath string) (davResource, error) {
+	if siaPath != "" {
+		if fi, err := h.renter.File(siaPath); err == nil {
+			return davResource{siaPath: siaPath, file: fi}, nil
+		}
+	}
+	dirs, _, err := h.renter.DirList(siaPath)
+	if err != nil || len(dirs) == 0 {
+		return davResource{}, errWebDAVNotFound
+	}
+	return davResource{siaPath: siaPath, dir: &dirs[0]}, nil
+}
+
+// isDir returns true if siaPath is an existing directory.
+func (h *webdavHandler) isDir(siaPath string) bool {
+	res, err := h.stat(siaPath)
+	return err == nil && res.dir != nil
+}
+
+// remove deletes a file or a directory with all of its contents.
+func (h *webdavHandler) remove(res davResource) error {
+	if res.dir != nil {
+		return h.renter.DeleteDir(res.siaPath)
+	}
+	return h.renter.DeleteFile(res.siaPath)
+}
+
+// rename moves a file or a directory to the path to.
+func (h *webdavHandler) rename(res davResource, to string) error {
+	if res.dir != nil {
+		return h.renter.RenameDir(res.siaPath, to)
+	}
+	return h.renter.RenameFile(res.siaPath, to)
+}
+
+// replace moves res to the path of the existing resource dest. dest is moved
+// to a temporary path first and is only deleted once res has taken its place.
+// If res can't be moved, dest is moved back.
+func (h *webdavHandler) replace(res, dest davResource) error {
+	aside := dest
+	aside.siaPath = path.Join(davParent(dest.siaPath), webdavReplacedPrefix+hex.EncodeToString(fastrand.Bytes(8)))
+	if err := h.rename(dest, aside.siaPath); err != nil {
+		return err
+	}
+	if err := h.rename(res, dest.siaPath); err != nil {
+		if restoreErr := h.rename(aside, dest.siaPath); restoreErr != nil {
+			return fmt.Errorf("%v; failed to restore %v: %v", err, dest.siaPath, restoreErr)
+		}
+		return err
+	}
+	if err := h.remove(aside); err != nil && err != renter.ErrUnknownPath {
+		return fmt.Errorf("failed to delete the replaced resource at %v: %v", aside.siaPath, err)
+	}
+	return nil
+}
+
+// ServeHTTP handles a WebDAV request.
+func (h *webdavHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.password != "" {
+		_, pass, ok := req.BasicAuth()
+		if !ok || pass != h.password {
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"SiaWebDAV\"")
+			http.Error(w, "WebDAV authentication failed.", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	siaPath := davSiaPath(req.URL.Path)
+	if davReserved(siaPath) && req.Method != "OPTIONS" {
+		http.Error(w, "path is reserved", http.StatusForbidden)
+		return
+	}
+	switch req.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", webdavMethods)
+		w.Header().Set("MS-Author-Via", "DAV")
+	case "PROPFIND":
+		h.handlePropfind(w, req, siaPath)
+	case "GET", "HEAD":
+		h.handleGet(w, req, siaPath)
+	case "PUT":
+		h.handlePut(w, req, siaPath)
+	case "DELETE":
+		h.handleDelete(w, siaPath)
+	case "MKCOL":
+		h.handleMkcol(w, req, siaPath)
+	case "MOVE":
+		h.handleMove(w, req, siaPath)
+	default:
+		w.Header().Set("Allow", webdavMethods)
+		http.Error(w, fmt.Sprintf("method %v is not supported", req.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePropfind lists the properties of a resource and, if the resource is a
+// directory and the depth is 1, the properties of its children. Every
+// property is returned, regardless of the properties that were requested.
+func (h *webdavHandler) handlePropfind(w http.ResponseWriter, req *http.Request, siaPath string) {
+	depth := req.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		// Listing the whole file tree is refused, as permitted by RFC 4918.
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, xml.Header+`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+		return
+	}
+	res, err := h.stat(siaPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ms := davMultistatus{
+		XMLNS:     "DAV:",
+		Responses: []davResponse{res.response()},
+	}
+	if res.dir != nil && depth == "1" {
+		dirs, files, err := h.renter.DirList(siaPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := 1; i < len(dirs); i++ {
+			if davReserved(dirs[i].SiaPath) {
+				continue
+			}
+			child := davResource{siaPath: dirs[i].SiaPath, dir: &dirs[i]}
+			ms.Responses = append(ms.Responses, child.response())
+		}
+		for _, fi := range files {
+			if davReserved(fi.SiaPath) {
+				continue
+			}
+			child := davResource{siaPath: fi.SiaPath, file: fi}
+			ms.Responses = append(ms.Responses, child.response())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207) // Multi-Status
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(ms)
+}
+
+// handleGet streams the contents of a file. Range requests and conditional
+// requests based on the modification time of the file are supported.
+func (h *webdavHandler) handleGet(w http.ResponseWriter, req *http.Request, siaPath string) {
+	res, err := h.stat(siaPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if res.dir != nil {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, DELETE, MKCOL, MOVE")
+		http.Error(w, "cannot download a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	fileName, streamer, err := h.renter.Streamer(siaPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create download streamer: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer streamer.Close()
+	http.ServeContent(w, req, fileName, res.file.ModTime, streamer)
+}
+
+// handlePut uploads the request body to a file. An existing file is replaced
+// once the upload has completed.
+func (h *webdavHandler) handlePut(w http.ResponseWriter, req *http.Request, siaPath string) {
+	res, err := h.stat(siaPath)
+	exists := err == nil
+	if exists && res.dir != nil {
+		http.Error(w, "cannot upload to a directory", http.StatusMethodNotAllowed)
+		return
+	} else if !h.isDir(davParent(siaPath)) {
+		http.Error(w, "parent directory does not exist", http.StatusConflict)
+		return
+	}
+
+	uploadPath := siaPath
+	if exists {
+		uploadPath = path.Join(davParent(siaPath), webdavTempPrefix+hex.EncodeToString(fastrand.Bytes(8)))
+	}
+	err = h.renter.UploadStreamFromReader(modules.FileUploadParams{SiaPath: uploadPath}, req.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("upload failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	// Replace the existing file.
+	if err := h.replace(davResource{siaPath: uploadPath}, res); err != nil {
+		h.renter.DeleteFile(uploadPath)
+		http.Error(w, fmt.Sprintf("failed to replace file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDelete deletes a file or a directory with all of its contents.
+func (h *webdavHandler) handleDelete(w http.ResponseWriter, siaPath string) {
+	if siaPath == "" {
+		http.Error(w, "cannot delete the root directory", http.StatusForbidden)
+		return
+	}
+	res, err := h.stat(siaPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.remove(res); err != nil {
+		http.Error(w, fmt.Sprintf("failed to delete: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMkcol creates a new directory.
+func (h *webdavHandler) handleMkcol(w http.ResponseWriter, req *http.Request, siaPath string) {
+	if req.ContentLength > 0 {
+		http.Error(w, "MKCOL does not support a request body", http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := h.stat(siaPath); err == nil {
+		http.Error(w, "a file or directory already exists at that path", http.StatusMethodNotAllowed)
+		return
+	} else if !h.isDir(davParent(siaPath)) {
+		http.Error(w, "parent directory does not exist", http.StatusConflict)
+		return
+	}
+	if err := h.renter.CreateDir(siaPath); err != nil {
+		http.Error(w, fmt.Sprintf("failed to create directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleMove renames a file or a directory. An existing resource at the
+// destination is replaced unless the Overwrite header is "F".
+func (h *webdavHandler) handleMove(w http.ResponseWriter, req *http.Request, siaPath string) {
+	dest, err := url.Parse(req.Header.Get("Destination"))
+	if err != nil || dest.Path == "" {
+		http.Error(w, "invalid Destination header", http.StatusBadRequest)
+		return
+	} else if dest.Host != "" && dest.Host != req.Host {
+		http.Error(w, "cannot move to a different server", http.StatusBadGateway)
+		return
+	}
+	destPath := davSiaPath(dest.Path)
+	if davReserved(destPath) {
+		http.Error(w, "destination is reserved", http.StatusForbidden)
+		return
+	}
+
+	res, err := h.stat(siaPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if siaPath == "" || destPath == "" || strings.HasPrefix(destPath+"/", siaPath+"/") {
+		http.Error(w, "cannot move a resource onto or into itself", http.StatusForbidden)
+		return
+	} else if !h.isDir(davParent(destPath)) {
+		http.Error(w, "parent directory of the destination does not exist", http.StatusConflict)
+		return
+	}
+
+	destRes, err := h.stat(destPath)
+	overwrite := err == nil
+	if overwrite && req.Header.Get("Overwrite") == "F" {
+		http.Error(w, "destination already exists", http.StatusPreconditionFailed)
+		return
+	}
+
+	if overwrite {
+		err = h.replace(res, destRes)
+	} else {
+		err = h.rename(res, destPath)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to move: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if overwrite {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
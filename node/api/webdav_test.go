@@ -0,0 +1,268 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// davListing is the parsed response to a PROPFIND request.
+type davListing struct {
+	Responses []struct {
+		Href          string    `xml:"href"`
+		ContentLength *uint64   `xml:"propstat>prop>getcontentlength"`
+		Collection    *xml.Name `xml:"propstat>prop>resourcetype>collection"`
+	} `xml:"response"`
+}
+
+// davRequest sends a WebDAV request to srv and returns the response along with
+// its body.
+func davRequest(srv *httptest.Server, method, path string, body []byte, header map[string]string) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.SetBasicAuth("", "password")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	return resp, respBody, err
+}
+
+// TestWebDAV checks that the files of the renter can be listed, downloaded,
+// uploaded, moved and deleted over WebDAV.
+func TestWebDAV(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	st, path := setupTestDownload(t, 1000, "webdav.dat", true)
+	defer func() {
+		st.server.panicClose()
+		os.Remove(path)
+	}()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&webdavHandler{renter: st.renter, password: "password"})
+	defer srv.Close()
+
+	// Requests need to be authenticated.
+	resp, err := http.Get(srv.URL + "/webdav.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("unauthenticated request wasn't rejected:", resp.Status)
+	}
+	resp, _, err = davRequest(srv, "OPTIONS", "/", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.Header.Get("DAV") != "1" {
+		t.Fatal("wrong DAV header:", resp.Header.Get("DAV"))
+	}
+
+	// List the root directory.
+	resp, _, err = davRequest(srv, "PROPFIND", "/", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusForbidden {
+		t.Fatal("PROPFIND with infinite depth wasn't refused:", resp.Status)
+	}
+	resp, body, err := davRequest(srv, "PROPFIND", "/", nil, map[string]string{"Depth": "1"})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != 207 {
+		t.Fatal("PROPFIND failed:", resp.Status, string(body))
+	}
+	var listing davListing
+	if err := xml.Unmarshal(body, &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Responses) != 2 || listing.Responses[0].Href != "/" || listing.Responses[0].Collection == nil {
+		t.Fatal("wrong listing of the root directory:", string(body))
+	}
+	file := listing.Responses[1]
+	if file.Href != "/webdav.dat" || file.Collection != nil || file.ContentLength == nil || *file.ContentLength != 1000 {
+		t.Fatal("wrong listing of the file:", string(body))
+	}
+
+	// Download a range of the file.
+	resp, body, err = davRequest(srv, "GET", "/webdav.dat", nil, map[string]string{"Range": "bytes=100-199"})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[100:200]) {
+		t.Fatal("range request returned the wrong data:", resp.Status)
+	}
+
+	// The response carries the modification time of the file, so range
+	// requests can be made conditional.
+	lastModified := resp.Header.Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatal("response doesn't have a Last-Modified header")
+	}
+	resp, _, err = davRequest(srv, "GET", "/webdav.dat", nil, map[string]string{"If-Modified-Since": lastModified})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusNotModified {
+		t.Fatal("conditional request for an unmodified file wasn't answered with 304:", resp.Status)
+	}
+
+	// The hidden directory of the S3 gateway is neither listed nor
+	// accessible.
+	if err := st.renter.CreateDir(s3Dir); err != nil {
+		t.Fatal(err)
+	}
+	_, body, err = davRequest(srv, "PROPFIND", "/", nil, map[string]string{"Depth": "1"})
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(body), s3Dir) {
+		t.Fatal("hidden directory was listed:", string(body))
+	}
+	for _, method := range []string{"PROPFIND", "DELETE", "MOVE"} {
+		resp, _, err := davRequest(srv, method, "/"+s3Dir, nil, map[string]string{"Destination": srv.URL + "/moved"})
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("%v of the hidden directory wasn't refused: %v", method, resp.Status)
+		}
+	}
+
+	// Create a directory and upload a file into it.
+	for _, test := range []struct {
+		path   string
+		status int
+	}{{"/dir", http.StatusCreated}, {"/dir", http.StatusMethodNotAllowed}, {"/missing/dir", http.StatusConflict}} {
+		resp, _, err := davRequest(srv, "MKCOL", test.path, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != test.status {
+			t.Fatalf("MKCOL %v: expected %v, got %v", test.path, test.status, resp.Status)
+		}
+	}
+	newData := fastrand.Bytes(500)
+	resp, body, err = davRequest(srv, "PUT", "/dir/new.dat", newData, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusCreated {
+		t.Fatal("PUT failed:", resp.Status, string(body))
+	}
+	_, body, err = davRequest(srv, "GET", "/dir/new.dat", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(body, newData) {
+		t.Fatal("uploaded file has the wrong contents")
+	}
+
+	// Overwrite the file. The temporary upload doesn't remain in the
+	// directory.
+	newData = fastrand.Bytes(300)
+	resp, body, err = davRequest(srv, "PUT", "/dir/new.dat", newData, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusNoContent {
+		t.Fatal("overwriting PUT failed:", resp.Status, string(body))
+	}
+	_, body, err = davRequest(srv, "GET", "/dir/new.dat", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(body, newData) {
+		t.Fatal("overwritten file has the wrong contents")
+	}
+	_, body, err = davRequest(srv, "PROPFIND", "/dir", nil, map[string]string{"Depth": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing = davListing{}
+	if err := xml.Unmarshal(body, &listing); err != nil {
+		t.Fatal(err)
+	} else if len(listing.Responses) != 2 || listing.Responses[0].Href != "/dir/" || listing.Responses[1].Href != "/dir/new.dat" {
+		t.Fatal("wrong listing of the directory:", string(body))
+	}
+
+	// Move the file out of the directory.
+	resp, _, err = davRequest(srv, "MOVE", "/dir/new.dat", nil, map[string]string{
+		"Destination": srv.URL + "/webdav.dat",
+		"Overwrite":   "F",
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatal("MOVE onto an existing file wasn't refused:", resp.Status)
+	}
+	resp, _, err = davRequest(srv, "MOVE", "/dir/new.dat", nil, map[string]string{"Destination": srv.URL + "/moved.dat"})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusCreated {
+		t.Fatal("MOVE failed:", resp.Status)
+	}
+	_, body, err = davRequest(srv, "GET", "/moved.dat", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(body, newData) {
+		t.Fatal("moved file has the wrong contents")
+	}
+
+	// Move the file onto the existing file. The replaced file doesn't remain
+	// in the directory.
+	resp, _, err = davRequest(srv, "MOVE", "/moved.dat", nil, map[string]string{"Destination": srv.URL + "/webdav.dat"})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusNoContent {
+		t.Fatal("overwriting MOVE failed:", resp.Status)
+	}
+	_, body, err = davRequest(srv, "GET", "/webdav.dat", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(body, newData) {
+		t.Fatal("overwritten file has the wrong contents")
+	}
+	_, body, err = davRequest(srv, "PROPFIND", "/", nil, map[string]string{"Depth": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing = davListing{}
+	if err := xml.Unmarshal(body, &listing); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range listing.Responses {
+		if strings.Contains(r.Href, webdavReplacedPrefix) || r.Href == "/moved.dat" {
+			t.Fatal("wrong listing of the root directory:", string(body))
+		}
+	}
+
+	// Delete the file and the directory.
+	for _, p := range []string{"/webdav.dat", "/dir"} {
+		resp, _, err := davRequest(srv, "DELETE", p, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != http.StatusNoContent {
+			t.Fatal("DELETE failed:", p, resp.Status)
+		}
+		resp, _, err = davRequest(srv, "PROPFIND", p, nil, map[string]string{"Depth": "0"})
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != http.StatusNotFound {
+			t.Fatal("deleted resource still exists:", p, resp.Status)
+		}
+	}
+}
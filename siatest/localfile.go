@@ -1,6 +1,7 @@
 package siatest
 
 import (
+	"encoding/hex"
 	"io/ioutil"
 	"math"
 	"os"
@@ -34,6 +35,20 @@ func NewFile(size int) (*LocalFile, error) {
 	}, err
 }
 
+// NewCompressibleFile creates and returns a new LocalFile with a random name.
+// It will write size random hexadecimal digits to the file, which compress to
+// a bit more than half of their size.
+func NewCompressibleFile(size int) (*LocalFile, error) {
+	fileName := strconv.Itoa(fastrand.Intn(math.MaxInt32))
+	path := filepath.Join(SiaTestingDir, fileName)
+	bytes := []byte(hex.EncodeToString(fastrand.Bytes(size/2 + 1))[:size])
+	err := ioutil.WriteFile(path, bytes, 0600)
+	return &LocalFile{
+		path:     path,
+		checksum: crypto.HashBytes(bytes),
+	}, err
+}
+
 // Delete removes the LocalFile from disk.
 func (lf *LocalFile) Delete() error {
 	return os.Remove(lf.path)
@@ -163,6 +163,42 @@ func (tn *TestNode) FileInfo(rf *RemoteFile) (modules.FileInfo, error) {
 	return modules.FileInfo{}, errors.New("file is not tracked by the renter")
 }
 
+// ChangeRedundancy uses the node to re-encode a file with the given number of
+// data and parity pieces and waits until the file has been re-encoded.
+func (tn *TestNode) ChangeRedundancy(rf *RemoteFile, dataPieces, parityPieces uint64) error {
+	if err := tn.RenterRedundancyPost(rf.siaPath, dataPieces, parityPieces); err != nil {
+		return err
+	}
+	var rc modules.RedundancyChangeInfo
+	err := Retry(1000, 100*time.Millisecond, func() error {
+		rrg, err := tn.RenterRedundancyGet(rf.siaPath)
+		if err != nil {
+			return err
+		} else if len(rrg.Changes) == 0 {
+			return errors.New("change of redundancy is missing")
+		}
+		rc = rrg.Changes[len(rrg.Changes)-1]
+		if !rc.Completed {
+			return errors.New("file hasn't been re-encoded yet")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if rc.Error != "" {
+		return errors.New(rc.Error)
+	}
+	fi, err := tn.FileInfo(rf)
+	if err != nil {
+		return errors.AddContext(err, "re-encoded file is not tracked by the renter")
+	}
+	if fi.DataPieces != dataPieces || fi.ParityPieces != parityPieces {
+		return fmt.Errorf("file should be %v-of-%v but is %v-of-%v", dataPieces, dataPieces+parityPieces,
+			fi.DataPieces, fi.DataPieces+fi.ParityPieces)
+	}
+	return nil
+}
+
 // Upload uses the node to upload the file.
 func (tn *TestNode) Upload(lf *LocalFile, dataPieces, parityPieces uint64) (*RemoteFile, error) {
 	// Upload file
@@ -183,6 +219,70 @@ func (tn *TestNode) Upload(lf *LocalFile, dataPieces, parityPieces uint64) (*Rem
 	return rf, nil
 }
 
+// UploadDedup uses the node to upload the file to siaPath with convergent
+// chunking and the renter's default redundancy.
+func (tn *TestNode) UploadDedup(lf *LocalFile, siaPath string) (*RemoteFile, error) {
+	err := tn.RenterUploadDedupPost(lf.path, siaPath, 0)
+	if err != nil {
+		return nil, err
+	}
+	rf := &RemoteFile{
+		siaPath:  siaPath,
+		checksum: lf.checksum,
+	}
+	// Make sure renter tracks file
+	_, err = tn.FileInfo(rf)
+	if err != nil {
+		return rf, errors.AddContext(err, "uploaded file is not tracked by the renter")
+	}
+	return rf, nil
+}
+
+// UploadCompressed uses the node to upload the file to siaPath with
+// compression and the renter's default redundancy.
+func (tn *TestNode) UploadCompressed(lf *LocalFile, siaPath string) (*RemoteFile, error) {
+	err := tn.RenterUploadCompressPost(lf.path, siaPath, 0)
+	if err != nil {
+		return nil, err
+	}
+	rf := &RemoteFile{
+		siaPath:  siaPath,
+		checksum: lf.checksum,
+	}
+	// Make sure renter tracks file
+	_, err = tn.FileInfo(rf)
+	if err != nil {
+		return rf, errors.AddContext(err, "uploaded file is not tracked by the renter")
+	}
+	return rf, nil
+}
+
+// UploadMetadata uses the node to upload the file to siaPath with the given
+// application metadata and the renter's default redundancy.
+func (tn *TestNode) UploadMetadata(lf *LocalFile, siaPath string, metadata map[string]string) (*RemoteFile, error) {
+	err := tn.RenterUploadMetadataPost(lf.path, siaPath, 0, false, false, metadata)
+	if err != nil {
+		return nil, err
+	}
+	rf := &RemoteFile{
+		siaPath:  siaPath,
+		checksum: lf.checksum,
+	}
+	// Make sure renter tracks file
+	_, err = tn.FileInfo(rf)
+	if err != nil {
+		return rf, errors.AddContext(err, "uploaded file is not tracked by the renter")
+	}
+	return rf, nil
+}
+
+// UploadQuote uses the node to quote the cost of uploading the file with the
+// given redundancy and storing it for the allowance period.
+func (tn *TestNode) UploadQuote(lf *LocalFile, dataPieces, parityPieces uint64) (modules.RenterUploadQuote, error) {
+	ruq, err := tn.RenterUploadQuoteGet(lf.path, dataPieces, parityPieces, 0)
+	return ruq.RenterUploadQuote, err
+}
+
 // UploadNewFile initiates the upload of a filesize bytes large file.
 func (tn *TestNode) UploadNewFile(filesize int, dataPieces uint64, parityPieces uint64) (*LocalFile, *RemoteFile, error) {
 	// Create file for upload
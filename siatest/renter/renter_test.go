@@ -1,15 +1,19 @@
 package renter
 
 import (
+	"bytes"
 	"errors"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/NebulousLabs/Sia/build"
 	"github.com/NebulousLabs/Sia/crypto"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/modules/renter"
 	"github.com/NebulousLabs/Sia/node"
+	"github.com/NebulousLabs/Sia/node/api"
 	"github.com/NebulousLabs/Sia/siatest"
 	"github.com/NebulousLabs/fastrand"
 )
@@ -48,6 +52,23 @@ func TestRenter(t *testing.T) {
 		{"TestDownloadMultipleLargeSectors", testDownloadMultipleLargeSectors},
 		{"TestRenterLocalRepair", testRenterLocalRepair},
 		{"TestRenterRemoteRepair", testRenterRemoteRepair},
+		{"TestRenterDirectories", testRenterDirectories},
+		{"TestUploadStreaming", testUploadStreaming},
+		{"TestPartialDownload", testPartialDownload},
+		{"TestPackedFiles", testPackedFiles},
+		{"TestDedupFiles", testDedupFiles},
+		{"TestCompressedFiles", testCompressedFiles},
+		{"TestFileMetadata", testFileMetadata},
+		{"TestTrash", testTrash},
+		{"TestUploadQuote", testUploadQuote},
+		{"TestChangeRedundancy", testChangeRedundancy},
+		{"TestChunkCache", testChunkCache},
+		{"TestStreamReadAhead", testStreamReadAhead},
+		{"TestRenterWorkers", testRenterWorkers},
+		{"TestDownloadOverdrive", testDownloadOverdrive},
+		{"TestStuckChunks", testStuckChunks},
+		{"TestDownloadCancelResume", testDownloadCancelResume},
+		{"TestPauseUploads", testPauseUploads},
 	}
 	// Run subtests
 	for _, subtest := range subTests {
@@ -57,6 +78,289 @@ func TestRenter(t *testing.T) {
 	}
 }
 
+// TestRenterShareLoad checks that a file shared by one renter can be loaded
+// and downloaded by another renter that has contracts with the same hosts.
+func TestRenterShareLoad(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	// Create a group with two renters.
+	groupParams := siatest.GroupParams{
+		Hosts:   2,
+		Renters: 2,
+		Miners:  1,
+	}
+	tg, err := siatest.NewGroupFromTemplate(groupParams)
+	if err != nil {
+		t.Fatal("Failed to create group: ", err)
+	}
+	defer func() {
+		if err := tg.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	renters := tg.Renters()
+	sharer, loader := renters[0], renters[1]
+
+	// Upload a file with the first renter and share it without the contract
+	// IDs. The file is too large to be packed, since packed files can't be
+	// shared.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, remoteFile, err := sharer.UploadNewFileBlocking(int(modules.SectorSize)+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal("Failed to upload a file for testing: ", err)
+	}
+	files, err := sharer.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa, err := sharer.RenterShareASCIIGet([]string{files[0].SiaPath}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the file with the second renter and download it through its own
+	// contracts.
+	rl, err := loader.RenterLoadASCIIPost(rsa.ASCIIsia)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.FilesAdded) != 1 || rl.FilesAdded[0] != files[0].SiaPath {
+		t.Fatal("file not loaded properly:", rl.FilesAdded)
+	}
+	if _, err := loader.DownloadByStream(remoteFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRenterBackup tests that a renter can recover its files from a backup.
+func TestRenterBackup(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	// Create a group for the test.
+	groupParams := siatest.GroupParams{
+		Hosts:   2,
+		Renters: 1,
+		Miners:  1,
+	}
+	tg, err := siatest.NewGroupFromTemplate(groupParams)
+	if err != nil {
+		t.Fatal("Failed to create group: ", err)
+	}
+	defer func() {
+		if err := tg.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := tg.Renters()[0]
+
+	// Recovering should fail if no backup was created yet.
+	if err := r.RenterRecoverBackupPost(); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForRecovery(r); err == nil {
+		t.Fatal("recovering a backup should fail before a backup was created")
+	}
+
+	// Upload a file and back up the renter. Mine a block to confirm the
+	// beacon of the backup.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, remoteFile, err := r.UploadNewFileBlocking(100+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal("Failed to upload a file for testing: ", err)
+	}
+	fi, err := r.FileInfo(remoteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterBackupPost(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tg.Miners()[0].MineBlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete the file and recover it from the backup.
+	if err := r.RenterDeletePost(fi.SiaPath); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if err := r.RenterRecoverBackupPost(); err != nil {
+			return err
+		}
+		return waitForRecovery(r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RenterFileGet(fi.SiaPath); err != nil {
+		t.Fatal("file wasn't recovered:", err)
+	}
+	if _, err := r.DownloadByStream(remoteFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recover the backup on a fresh node that only knows the seed. It has no
+	// contracts and needs to form them with the hosts of the backup.
+	restored, err := recoverOnNewNode(tg, r, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := restored.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := restored.DownloadByStream(remoteFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRenterBackupContracts tests that the contracts with the backup hosts can
+// still be revised by a renter that was recovered after old snapshots were
+// released.
+func TestRenterBackupContracts(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	// Create a group for the test.
+	groupParams := siatest.GroupParams{
+		Hosts:   2,
+		Renters: 1,
+		Miners:  1,
+	}
+	tg, err := siatest.NewGroupFromTemplate(groupParams)
+	if err != nil {
+		t.Fatal("Failed to create group: ", err)
+	}
+	defer func() {
+		if err := tg.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := tg.Renters()[0]
+
+	// Upload a file and back up the renter once more than snapshots are
+	// kept, so that the sectors of the first snapshot are deleted from the
+	// contracts with the backup hosts.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, remoteFile, err := r.UploadNewFileBlocking(100+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal("Failed to upload a file for testing: ", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := r.RenterBackupPost(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tg.Miners()[0].MineBlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The recovered renter can revise the contracts with the backup hosts,
+	// which are the only hosts of the group.
+	restored, err := recoverOnNewNode(tg, r, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := restored.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := restored.DownloadByStream(remoteFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := restored.UploadNewFileBlocking(100+siatest.Fuzz(), dataPieces, parityPieces); err != nil {
+		t.Fatal("Failed to upload a file to the restored contracts: ", err)
+	}
+}
+
+// recoverOnNewNode creates a fresh node from the seed of r and recovers the
+// most recent backup of r on it.
+func recoverOnNewNode(tg *siatest.TestGroup, r *siatest.TestNode, testName string) (_ *siatest.TestNode, err error) {
+	wsg, err := r.WalletSeedsGet()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := siatest.TestDir(testName, "restored")
+	if err != nil {
+		return nil, err
+	}
+	restored, err := siatest.NewCleanNodeFromSeed(node.Renter(dir), wsg.PrimarySeed)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			restored.Close()
+		}
+	}()
+	miner := tg.Miners()[0]
+	if err := restored.GatewayConnectPost(miner.GatewayAddress()); err != nil {
+		return nil, err
+	}
+	err = build.Retry(600, 100*time.Millisecond, func() error {
+		mcg, err := miner.ConsensusGet()
+		if err != nil {
+			return err
+		}
+		rcg, err := restored.ConsensusGet()
+		if err != nil {
+			return err
+		}
+		if mcg.CurrentBlock != rcg.CurrentBlock {
+			return errors.New("restored node isn't synced yet")
+		}
+		for _, h := range tg.Hosts() {
+			if err := restored.KnowsHost(h); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := restored.RenterRecoverBackupPost(); err != nil {
+		return nil, err
+	}
+	if err := waitForRecovery(restored); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// waitForRecovery waits for the recovery of a backup on a node to finish and
+// returns the error it reported.
+func waitForRecovery(tn *siatest.TestNode) error {
+	var status api.RenterRecoverBackupGET
+	err := build.Retry(600, 100*time.Millisecond, func() (err error) {
+		status, err = tn.RenterRecoverBackupGet()
+		if err != nil {
+			return err
+		}
+		if status.Active {
+			return errors.New("recovery is still in progress")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+	return nil
+}
+
 // testUploadDownload is a subtest that uses an existing TestGroup to test if
 // uploading and downloading a file works
 func testUploadDownload(t *testing.T, tg *siatest.TestGroup) {
@@ -129,7 +433,7 @@ func testSingleFileGet(t *testing.T, tg *siatest.TestGroup) {
 		if err != nil {
 			t.Fatal("Failed to request single file", err)
 		}
-		if file != f {
+		if !reflect.DeepEqual(file, f) {
 			t.Fatal("Single file queries does not match file previously requested.")
 		}
 	}
@@ -306,6 +610,884 @@ func testRenterRemoteRepair(t *testing.T, tg *siatest.TestGroup) {
 	}
 }
 
+// testRenterDirectories tests creating, listing, renaming and deleting
+// directories through the API.
+func testRenterDirectories(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Create a nested directory. The parent should be created as well.
+	if err := r.RenterDirCreatePost("testdir/subdir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterDirCreatePost("testdir/subdir"); err == nil {
+		t.Fatal("creating an existing directory should fail")
+	}
+	rd, err := r.RenterDirGet("testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rd.Directories) != 2 || rd.Directories[1].SiaPath != "testdir/subdir" {
+		t.Fatal("unexpected directories:", rd.Directories)
+	}
+
+	// Rename the directory.
+	if err := r.RenterDirRenamePost("testdir", "renameddir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RenterDirGet("testdir"); err == nil {
+		t.Fatal("old directory should not exist after rename")
+	}
+	rd, err = r.RenterDirGet("renameddir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rd.Directories) != 2 || rd.Directories[1].SiaPath != "renameddir/subdir" {
+		t.Fatal("unexpected directories after rename:", rd.Directories)
+	}
+
+	// Delete the directory.
+	if err := r.RenterDirDeletePost("renameddir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RenterDirGet("renameddir"); err == nil {
+		t.Fatal("directory should not exist after delete")
+	}
+}
+
+// testUploadStreaming uploads a file from a stream and checks that it can be
+// downloaded again.
+func testUploadStreaming(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload a stream that spans multiple chunks.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	chunkSize := int((modules.SectorSize - crypto.TwofishOverhead) * dataPieces)
+	data := fastrand.Bytes(2*chunkSize + siatest.Fuzz() + 100)
+	siaPath := "streamfile"
+	if err := r.RenterUploadStreamPost(bytes.NewReader(data), siaPath, dataPieces, parityPieces); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file should be complete and have no local copy.
+	rf, err := r.RenterFileGet(siaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.File.Filesize != uint64(len(data)) {
+		t.Fatalf("expected filesize %v, got %v", len(data), rf.File.Filesize)
+	}
+	if rf.File.LocalPath != "" {
+		t.Fatal("streamed file shouldn't have a local path:", rf.File.LocalPath)
+	}
+	if !rf.File.Available {
+		t.Fatal("streamed file should be available")
+	}
+
+	// Download the file and compare the data.
+	downloaded, err := r.RenterStreamGet(siaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatal("downloaded data doesn't match the uploaded stream")
+	}
+
+	// Streamed chunks go through the upload heap, so they aren't uploaded
+	// while the upload pipeline is paused.
+	if err := r.RenterUploadsPausePost(""); err != nil {
+		t.Fatal(err)
+	}
+	uploadErr := make(chan error, 1)
+	go func() {
+		uploadErr <- r.RenterUploadStreamPost(bytes.NewReader(data), "pausedstreamfile", dataPieces, parityPieces)
+	}()
+	select {
+	case err := <-uploadErr:
+		t.Fatal("streaming upload completed while uploads were paused:", err)
+	case <-time.After(2 * time.Second):
+	}
+	if err := r.RenterUploadsResumePost(""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-uploadErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("streaming upload didn't complete after uploads were resumed")
+	}
+}
+
+// testPartialDownload checks that downloading a small range of a file only
+// transfers the segments of the pieces that contain the requested part.
+func testPartialDownload(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload a file that fills a chunk with multiple data pieces.
+	dataPieces := uint64(2)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	pieceSize := modules.SectorSize - crypto.TwofishOverhead
+	data := fastrand.Bytes(int(pieceSize * dataPieces))
+	siaPath := "partialfile"
+	if err := r.RenterUploadStreamPost(bytes.NewReader(data), siaPath, dataPieces, parityPieces); err != nil {
+		t.Fatal(err)
+	}
+
+	// Download a range of the second data piece through the API and check
+	// that less than a piece was transferred.
+	offset, length := pieceSize+1000+uint64(siatest.Fuzz()), uint64(500)
+	downloaded, err := r.RenterDownloadHTTPResponseGet(siaPath, offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data[offset:offset+length]) {
+		t.Fatal("downloaded range doesn't match the uploaded data")
+	}
+	rdq, err := r.RenterDownloadsGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rdq.Downloads) == 0 || rdq.Downloads[0].Offset != offset {
+		t.Fatal("download is missing from the download history")
+	}
+	if transferred := rdq.Downloads[0].TotalDataTransferred; transferred == 0 || transferred >= pieceSize {
+		t.Fatal("expected less than a piece to be transferred, got", transferred)
+	}
+
+	// Stream a range that crosses the pieces after seeking.
+	start, end := pieceSize-100, pieceSize+100
+	downloaded, err = r.RenterStreamPartialGet(siaPath, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data[start:end+1]) {
+		t.Fatal("streamed range doesn't match the uploaded data")
+	}
+}
+
+// testStreamReadAhead checks that files which span several chunks are streamed
+// correctly when the renter prefetches the chunks ahead of the reader, both
+// from the start of the file and after seeking into the middle of a chunk.
+func testStreamReadAhead(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+	// Upload a file of 4 chunks.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	chunkSize := int((modules.SectorSize - crypto.TwofishOverhead) * dataPieces)
+	lf, rf, err := r.UploadNewFileBlocking(4*chunkSize+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stream(rf); err != nil {
+		t.Fatal(err)
+	}
+	from := uint64(chunkSize + chunkSize/2)
+	if _, err := r.StreamPartial(rf, lf, from, uint64(3*chunkSize+100)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testRenterWorkers checks that the renter keeps download statistics for
+// every worker.
+func testRenterWorkers(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, rf, err := r.UploadNewFileBlocking(int(modules.SectorSize)+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DownloadByStream(rf); err != nil {
+		t.Fatal(err)
+	}
+	rw, err := r.RenterWorkersGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Earlier subtests may have formed contracts with hosts that left the
+	// group.
+	if len(rw.Workers) < len(tg.Hosts()) {
+		t.Fatalf("expected at least %v workers, got %v", len(tg.Hosts()), len(rw.Workers))
+	}
+	var downloads uint64
+	for _, w := range rw.Workers {
+		if w.Downloads == 0 {
+			continue
+		}
+		if w.NetAddress == "" || w.DownloadTimeToFirstByte <= 0 || w.DownloadThroughput == 0 {
+			t.Fatal("statistics of worker are incomplete:", w)
+		}
+		downloads += w.Downloads
+	}
+	if downloads == 0 {
+		t.Fatal("no downloads were recorded")
+	}
+}
+
+// testDownloadOverdrive checks that the download overdrive can be set through
+// the API and that downloads with overdrive succeed.
+func testDownloadOverdrive(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+	rg, err := r.RenterGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.RenterPostDownloadOverdrive(rg.Settings.DownloadOverdrive)
+	overdrive := uint64(len(tg.Hosts()))
+	if err := r.RenterPostDownloadOverdrive(overdrive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Download a file with overdrive.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, rf, err := r.UploadNewFileBlocking(int(modules.SectorSize)+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DownloadToDisk(rf, false); err != nil {
+		t.Fatal(err)
+	}
+	rg, err = r.RenterGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rg.Settings.DownloadOverdrive != overdrive {
+		t.Fatalf("expected overdrive %v, got %v", overdrive, rg.Settings.DownloadOverdrive)
+	}
+	fm := rg.FinancialMetrics
+	if fm.OverdriveSpending.Cmp(fm.DownloadSpending) > 0 {
+		t.Fatal("overdrive spending exceeds the download spending")
+	}
+}
+
+// testDownloadCancelResume checks that downloads to disk are resumable and
+// that only incomplete downloads can be cancelled or resumed.
+func testDownloadCancelResume(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload a file and download it to disk.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, rf, err := r.UploadNewFileBlocking(int(modules.SectorSize)+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DownloadToDisk(rf, false); err != nil {
+		t.Fatal(err)
+	}
+	rdq, err := r.RenterDownloadsGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rdq.Downloads) == 0 {
+		t.Fatal("download is missing from the download history")
+	}
+	d := rdq.Downloads[0]
+	if d.ID == "" || !d.Resumable || !d.Completed || d.Error != "" {
+		t.Fatal("download to disk should be completed and resumable:", d)
+	}
+
+	// The completed download can neither be cancelled nor resumed.
+	if err := r.RenterDownloadCancelPost(d.ID); err == nil {
+		t.Fatal("completed download was cancelled")
+	}
+	if err := r.RenterDownloadResumePost(d.ID); err == nil {
+		t.Fatal("completed download was resumed")
+	}
+	if err := r.RenterDownloadCancelPost("unknown"); err == nil {
+		t.Fatal("unknown download was cancelled")
+	}
+}
+
+// testPauseUploads checks that files aren't uploaded while the uploads are
+// paused, and that they are uploaded once the uploads are resumed.
+func testPauseUploads(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Pause all uploads and upload a file.
+	if err := r.RenterUploadsPausePost(""); err != nil {
+		t.Fatal(err)
+	}
+	rg, err := r.RenterGet()
+	if err != nil {
+		t.Fatal(err)
+	} else if !rg.UploadsPaused {
+		t.Fatal("uploads weren't paused")
+	}
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, rf, err := r.UploadNewFile(int(modules.SectorSize)+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pause the file as well, so that it remains paused after all uploads
+	// are resumed.
+	if err := r.RenterUploadsPausePost(rf.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterUploadsResumePost(""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	fi, err := r.FileInfo(rf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.UploadPaused || fi.UploadProgress != 0 {
+		t.Fatal("paused file was uploaded:", fi.UploadPaused, fi.UploadProgress)
+	}
+
+	// Resume the file. It should be uploaded now.
+	if err := r.RenterUploadsResumePost(rf.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WaitForUploadProgress(rf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WaitForUploadRedundancy(rf, float64(dataPieces+parityPieces)/float64(dataPieces)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testPackedFiles checks that small files which are packed into the same
+// chunk can be downloaded individually, also after the other files of the
+// chunk were deleted.
+func testPackedFiles(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload a few small files in quick succession, so they share a pack.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	var remoteFiles []*siatest.RemoteFile
+	for i := 0; i < 3; i++ {
+		_, rf, err := r.UploadNewFile(100+siatest.Fuzz(), dataPieces, parityPieces)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remoteFiles = append(remoteFiles, rf)
+	}
+	for _, rf := range remoteFiles {
+		if err := r.WaitForUploadRedundancy(rf, float64(dataPieces+parityPieces)/float64(dataPieces)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.DownloadByStream(rf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Delete all but the last file, which should still be available.
+	for _, rf := range remoteFiles[:len(remoteFiles)-1] {
+		if err := r.RenterDeletePost(rf.SiaPath()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := remoteFiles[len(remoteFiles)-1]
+	if _, err := r.DownloadByStream(last); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stream(last); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterDeletePost(last.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testFileMetadata checks that files can be uploaded with application
+// metadata, that the metadata can be replaced and that files can be searched
+// by their metadata.
+func testFileMetadata(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload two files with metadata.
+	var files []*siatest.RemoteFile
+	for _, tag := range []string{"red", "blue"} {
+		lf, err := siatest.NewFile(100 + siatest.Fuzz())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rf, err := r.UploadMetadata(lf, "metadata/"+tag, map[string]string{"color": tag, "app": "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, rf)
+	}
+	fi, err := r.FileInfo(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Metadata["color"] != "red" || fi.Metadata["app"] != "test" {
+		t.Fatal("wrong metadata:", fi.Metadata)
+	}
+
+	// Search the files by their prefix and metadata. The results are sorted
+	// by siapath.
+	rs, err := r.RenterSearchGet(modules.FileSearchQuery{Prefix: "metadata/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Total != 2 || len(rs.Files) != 2 || rs.Files[0].SiaPath != files[1].SiaPath() {
+		t.Fatal("wrong search results:", rs)
+	}
+	rs, err = r.RenterSearchGet(modules.FileSearchQuery{Prefix: "metadata/", Metadata: map[string]string{"color": "blue"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Total != 1 || len(rs.Files) != 1 || rs.Files[0].SiaPath != files[1].SiaPath() {
+		t.Fatal("wrong search results:", rs)
+	}
+	rs, err = r.RenterSearchGet(modules.FileSearchQuery{Prefix: "metadata/", Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Total != 2 || len(rs.Files) != 1 || rs.Files[0].SiaPath != files[0].SiaPath() {
+		t.Fatal("wrong page of search results:", rs)
+	}
+
+	// Replace the metadata of the first file.
+	if err := r.RenterMetadataPost(files[0].SiaPath(), map[string]string{"color": "blue"}); err != nil {
+		t.Fatal(err)
+	}
+	rs, err = r.RenterSearchGet(modules.FileSearchQuery{Prefix: "metadata/", Metadata: map[string]string{"color": "blue"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Total != 2 {
+		t.Fatal("wrong search results after replacing the metadata:", rs)
+	}
+	rs, err = r.RenterSearchGet(modules.FileSearchQuery{Prefix: "metadata/", Metadata: map[string]string{"app": "test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Total != 1 || rs.Files[0].SiaPath != files[1].SiaPath() {
+		t.Fatal("replaced metadata is still found:", rs)
+	}
+
+	// Invalid metadata is rejected.
+	if err := r.RenterMetadataPost(files[0].SiaPath(), map[string]string{"": "value"}); err == nil {
+		t.Fatal("expected metadata with an empty key to be rejected")
+	}
+}
+
+// testTrash checks that deleted files are moved to the trash, that they can be
+// restored and downloaded again, and that the trash can be emptied.
+func testTrash(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// The other tests delete files as well, so only the file of this test is
+	// looked for in the trash.
+	inTrash := func(siaPath string) bool {
+		rt, err := r.RenterTrashGet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range rt.Files {
+			if file.SiaPath == siaPath {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Upload a file and delete it.
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	_, rf, err := r.UploadNewFileBlocking(100+siatest.Fuzz(), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterDeletePost(rf.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FileInfo(rf); err == nil {
+		t.Fatal("deleted file is still listed")
+	}
+	if !inTrash(rf.SiaPath()) {
+		t.Fatal("deleted file isn't in the trash")
+	}
+
+	// Restore the file and download it.
+	if err := r.RenterTrashRestorePost(rf.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+	if inTrash(rf.SiaPath()) {
+		t.Fatal("restored file is still in the trash")
+	}
+	if _, err := r.DownloadByStream(rf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete the file again and empty the trash.
+	if err := r.RenterDeletePost(rf.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterTrashEmptyPost(); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := r.RenterTrashGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.Files) != 0 {
+		t.Fatal("trash wasn't emptied:", rt.Files)
+	}
+	if err := r.RenterTrashRestorePost(rf.SiaPath()); err == nil {
+		t.Fatal("expected restoring a purged file to fail")
+	}
+}
+
+// testUploadQuote checks that uploads are quoted with the prices of the
+// group's hosts and that the quote of a file matches the quote of its size.
+func testUploadQuote(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	lf, err := siatest.NewFile(int(modules.SectorSize) + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := r.UploadQuote(lf, dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rg, err := r.RenterGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Hosts != uint64(len(tg.Hosts())) || quote.Duration != rg.Settings.Allowance.Period {
+		t.Fatal("wrong quote:", quote)
+	}
+	if quote.UploadSize != 2*modules.SectorSize*uint64(len(tg.Hosts())) {
+		t.Fatal("wrong upload size:", quote.UploadSize)
+	}
+	if quote.StorageCost.IsZero() || quote.UploadCost.IsZero() || !quote.WithinAllowance {
+		t.Fatal("wrong costs:", quote)
+	}
+
+	// Quoting the size of the file gives the same quote.
+	ruq, err := r.RenterUploadQuoteFilesizeGet(quote.Filesize, dataPieces, parityPieces, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ruq.RenterUploadQuote, quote) {
+		t.Fatal("quotes of the file and of its size don't match:", ruq.RenterUploadQuote, quote)
+	}
+
+	// A longer duration is more expensive.
+	ruq, err = r.RenterUploadQuoteFilesizeGet(quote.Filesize, dataPieces, parityPieces, 2*quote.Duration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ruq.StorageCost.Cmp(quote.StorageCost) <= 0 || ruq.TotalCost.Cmp(quote.TotalCost) <= 0 {
+		t.Fatal("longer duration isn't more expensive:", ruq.RenterUploadQuote, quote)
+	}
+}
+
+// testChangeRedundancy checks that the redundancy of a file can be changed in
+// place and by re-encoding the file without a local copy of the file.
+func testChangeRedundancy(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload a file with a few chunks and remove the local copy.
+	lf, rf, err := r.UploadNewFileBlocking(2*int(modules.SectorSize)+siatest.Fuzz(), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Raise the parity in place, re-encode the file and lower the parity in
+	// place. The file should be complete after every change.
+	hosts := uint64(len(tg.Hosts()))
+	changes := []struct {
+		dataPieces, parityPieces uint64
+	}{
+		{1, hosts - 1},
+		{2, hosts - 2},
+		{2, 1},
+	}
+	for _, c := range changes {
+		if err := r.ChangeRedundancy(rf, c.dataPieces, c.parityPieces); err != nil {
+			t.Fatal(err)
+		}
+		redundancy := float64(c.dataPieces+c.parityPieces) / float64(c.dataPieces)
+		if err := r.WaitForUploadRedundancy(rf, redundancy); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.DownloadByStream(rf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every change is listed as completed.
+	rrg, err := r.RenterRedundancyGet(rf.SiaPath())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rrg.Changes) != len(changes) {
+		t.Fatalf("expected %v changes, got %v", len(changes), len(rrg.Changes))
+	}
+	for i, rc := range rrg.Changes {
+		if !rc.Completed || rc.Error != "" || rc.DataPieces != changes[i].dataPieces || rc.ParityPieces != changes[i].parityPieces {
+			t.Fatal("wrong change of redundancy:", rc)
+		}
+	}
+
+	// The erasure code can't be changed to the code the file already has.
+	if err := r.RenterRedundancyPost(rf.SiaPath(), 2, 1); err == nil {
+		t.Fatal("expected changing to the same redundancy to fail")
+	}
+}
+
+// testDedupFiles checks that files uploaded with convergent chunking can be
+// downloaded, and that a file that shares its chunks with a deleted file is
+// still available.
+func testDedupFiles(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload the same file twice.
+	lf, err := siatest.NewFile(int(modules.SectorSize) + siatest.Fuzz())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf1, err := r.UploadDedup(lf, "dedup1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf2, err := r.UploadDedup(lf, "dedup2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rf := range []*siatest.RemoteFile{rf1, rf2} {
+		if err := r.WaitForUploadRedundancy(rf, float64(len(tg.Hosts()))); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := r.FileInfo(rf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.Deduplicated {
+			t.Fatal("file is not deduplicated")
+		}
+		if _, err := r.DownloadByStream(rf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Delete the first file, the second file should still be available.
+	if err := r.RenterDeletePost(rf1.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DownloadByStream(rf2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stream(rf2); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterDeletePost(rf2.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testCompressedFiles checks that compressed files, including packed ones, can
+// be downloaded and streamed completely and partially.
+func testCompressedFiles(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload a file whose compressed data spans multiple chunks and a file
+	// that is small enough to be packed once it's compressed.
+	lf, err := siatest.NewCompressibleFile(int(5*modules.SectorSize) + siatest.Fuzz())
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := siatest.NewCompressibleFile(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err := r.UploadCompressed(lf, "compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rfSmall, err := r.UploadCompressed(small, "compressedsmall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rf := range []*siatest.RemoteFile{rf, rfSmall} {
+		if err := r.WaitForUploadRedundancy(rf, float64(len(tg.Hosts()))); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := r.FileInfo(rf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Compression != "deflate" || fi.CompressedSize >= fi.Filesize {
+			t.Fatal("file is not compressed:", fi.Compression, fi.CompressedSize, fi.Filesize)
+		}
+		if _, err := r.DownloadToDisk(rf, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.DownloadByStream(rf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Stream(rf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Ranges that start and end within frames are decompressed from the
+	// frames that overlap them.
+	ranges := [][2]uint64{{0, 0}, {100, 5000}, {modules.SectorSize + 10, 4*modules.SectorSize - 1}}
+	for _, rg := range ranges {
+		if _, err := r.StreamPartial(rf, lf, rg[0], rg[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, rf := range []*siatest.RemoteFile{rf, rfSmall} {
+		if err := r.RenterDeletePost(rf.SiaPath()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testChunkCache checks that downloaded chunks are cached on disk and that
+// downloads and streams read them from the cache.
+func testChunkCache(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Enable the cache and upload a file with two chunks.
+	if err := r.RenterCachePost(100 * modules.SectorSize); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := r.RenterCachePost(0); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	dataPieces := uint64(1)
+	parityPieces := uint64(len(tg.Hosts())) - dataPieces
+	chunkSize := int((modules.SectorSize - crypto.TwofishOverhead) * dataPieces)
+	_, rf, err := r.UploadNewFileBlocking(2*chunkSize, dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Download the file, its chunks are added to the cache once the download
+	// is complete.
+	if _, err := r.DownloadToDisk(rf, false); err != nil {
+		t.Fatal(err)
+	}
+	var hits uint64
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		rc, err := r.RenterCacheGet()
+		if err != nil {
+			return err
+		}
+		if rc.Chunks != 2 {
+			return errors.New("chunks of the downloaded file weren't cached")
+		}
+		hits = rc.Hits
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Downloads and streams of the file read the chunks from the cache.
+	if _, err := r.DownloadToDisk(rf, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Stream(rf); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := r.RenterCacheGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Hits < hits+4 {
+		t.Fatalf("expected at least %v cache hits but got %v", hits+4, rc.Hits)
+	}
+
+	// Purging the cache removes the chunks.
+	if err := r.RenterCachePurgePost(); err != nil {
+		t.Fatal(err)
+	}
+	if rc, err = r.RenterCacheGet(); err != nil {
+		t.Fatal(err)
+	} else if rc.Chunks != 0 || rc.Size != 0 {
+		t.Fatal("cache wasn't purged:", rc.Chunks, rc.Size)
+	}
+	if _, err := r.DownloadByStream(rf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testStuckChunks checks that chunks which can't be repaired to full
+// redundancy are marked as stuck.
+func testStuckChunks(t *testing.T, tg *siatest.TestGroup) {
+	// Grab the first of the group's renters
+	r := tg.Renters()[0]
+
+	// Upload a file with more pieces than there are hosts. The chunks of the
+	// file can never be fully repaired.
+	numHosts := uint64(len(tg.Hosts()))
+	dataPieces := uint64(1)
+	parityPieces := numHosts
+	_, rf, err := r.UploadNewFile(int(modules.SectorSize), dataPieces, parityPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(600, 100*time.Millisecond, func() error {
+		fi, err := r.FileInfo(rf)
+		if err != nil {
+			return err
+		}
+		if fi.StuckChunks == 0 {
+			return errors.New("file doesn't have stuck chunks yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every host stores a piece of every chunk.
+	fi, err := r.FileInfo(rf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Health != float64(numHosts)/float64(dataPieces) {
+		t.Fatalf("health should be %v but was %v", numHosts, fi.Health)
+	}
+	if _, err := r.RenterRepairGet(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenterDeletePost(rf.SiaPath()); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // testRenterStreamingCache checks if the chunk cache works correctly.
 func testRenterStreamingCache(t *testing.T, tg *siatest.TestGroup) {
 	// Grab the first of the group's renters
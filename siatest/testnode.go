@@ -40,6 +40,18 @@ func NewNode(nodeParams node.NodeParams) (*TestNode, error) {
 
 // NewCleanNode creates a new TestNode that's not yet funded
 func NewCleanNode(nodeParams node.NodeParams) (*TestNode, error) {
+	return newCleanNode(nodeParams, "")
+}
+
+// NewCleanNodeFromSeed creates a new TestNode whose wallet is initialized
+// from an existing seed. The node isn't connected to any peers yet.
+func NewCleanNodeFromSeed(nodeParams node.NodeParams, seed string) (*TestNode, error) {
+	return newCleanNode(nodeParams, seed)
+}
+
+// newCleanNode creates a new TestNode. If seed is empty a new wallet seed is
+// generated.
+func newCleanNode(nodeParams node.NodeParams, seed string) (*TestNode, error) {
 	userAgent := "Sia-Agent"
 	password := "password"
 
@@ -58,11 +70,16 @@ func NewCleanNode(nodeParams node.NodeParams) (*TestNode, error) {
 	tn := &TestNode{*s, *c, ""}
 
 	// Init wallet
-	wip, err := tn.WalletInitPost("", false)
-	if err != nil {
+	if seed == "" {
+		wip, err := tn.WalletInitPost("", false)
+		if err != nil {
+			return nil, err
+		}
+		seed = wip.PrimarySeed
+	} else if err := tn.WalletInitSeedPost(seed, "", false); err != nil {
 		return nil, err
 	}
-	tn.primarySeed = wip.PrimarySeed
+	tn.primarySeed = seed
 
 	// Unlock wallet
 	if err := tn.WalletUnlockPost(tn.primarySeed); err != nil {